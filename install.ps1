@@ -39,38 +39,22 @@ if (-not (Test-Path "$installDir\logs")) {
     Write-Host "Created logs directory: $installDir\logs"
 }
 
-# Check if NSSM is available
-$nssmPath = "$env:ProgramFiles\nssm\nssm.exe"
-$installService = $true
-
-if (-not (Test-Path $nssmPath)) {
-    $installService = $false
-    Write-Warning "NSSM not found at $nssmPath. The service will not be installed automatically."
-    Write-Host "You can download NSSM from https://nssm.cc and install the service manually."
+# Install and start the service, replacing an existing one
+Write-Host "Installing Windows service..."
+$exe = "$installDir\veeam-monitor.exe"
+if (Get-Service -Name VeeamBackupMonitor -ErrorAction SilentlyContinue) {
+    & $exe -service uninstall
 }
-
-# Install as a service if NSSM is available
+& $exe -service install -config "$installDir\config.json"
+$installService = $?
 if ($installService) {
-    Write-Host "Installing Windows service using NSSM..."
-    
-    # Remove existing service if it exists
-    & $nssmPath stop VeeamBackupMonitor 2>$null
-    & $nssmPath remove VeeamBackupMonitor confirm 2>$null
-    
-    # Install new service
-    & $nssmPath install VeeamBackupMonitor "$installDir\veeam-monitor.exe"
-    & $nssmPath set VeeamBackupMonitor AppDirectory "$installDir"
-    & $nssmPath set VeeamBackupMonitor Description "Monitors Veeam Backup & Replication jobs and sends email alerts on failures"
-    & $nssmPath set VeeamBackupMonitor Start SERVICE_AUTO_START
-    
-    # Start the service
-    & $nssmPath start VeeamBackupMonitor
-    
-    if ($?) {
-        Write-Host "Service installed and started successfully."
-    } else {
-        Write-Warning "Failed to install or start the service."
-    }
+    & $exe -service start
+}
+
+if ($installService -and $?) {
+    Write-Host "Service installed and started successfully."
+} else {
+    Write-Warning "Failed to install or start the service."
 }
 
 Write-Host ""
@@ -1,44 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/smtp"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
-)
-
-// Configuration for the application
-type Config struct {
-	VeeamPowerShellModule string   `json:"veeamPowerShellModule"`
-	VeeamServerAddress    string   `json:"veeamServerAddress"`
-	CheckIntervalMinutes  int      `json:"checkIntervalMinutes"`
-	SMTPServer            string   `json:"smtpServer"`
-	SMTPPort              int      `json:"smtpPort"`
-	EmailFrom             string   `json:"emailFrom"`
-	EmailTo               []string `json:"emailTo"`
-	EmailPassword         string   `json:"emailPassword"`
-	MonitorFailedJobs     bool     `json:"monitorFailedJobs"`
-	MonitorWarningJobs    bool     `json:"monitorWarningJobs"`
-	MonitorRunningJobs    bool     `json:"monitorRunningJobs"`
-	LongRunningThreshold  int      `json:"longRunningThreshold"` // In minutes
-}
 
-// Represents a Veeam job status
-type JobStatus struct {
-	Name        string
-	Status      string
-	StartTime   string
-	EndTime     string
-	Description string
-	Duration    string
-}
+	"veeam-monitor/veeammonitor"
+)
 
 func main() {
 	// Define command-line arguments
@@ -48,31 +23,86 @@ func main() {
 	emailTo := flag.String("to", "", "Recipient email address")
 	smtpServer := flag.String("smtp", "", "SMTP server address")
 	configFile := flag.String("config", "config.json", "Path to configuration file")
+	initConfig := flag.Bool("init-config", false, "Write a sample configuration file to the -config path and exit")
+	force := flag.Bool("force", false, "Overwrite an existing file when used with -init-config")
+	strictConfig := flag.Bool("strict-config", false, "Refuse to start on any configuration problem, including a missing config file, instead of using defaults")
+	migrate := flag.Bool("migrate", false, "Rewrite the -config file in the current schema with defaults for missing fields and exit")
+	testEmail := flag.Bool("test-email", false, "Send a test email using the configured settings and exit")
+	testNotify := flag.Bool("test-notify", false, "Send a test message through every configured notification channel and exit")
+	diagnose := flag.Bool("diagnose", false, "Check the Veeam module and connection, SMTP and webhooks, print a summary and exit")
+	dashboard := flag.Bool("dashboard", false, "Show a live status dashboard in the terminal instead of the log")
+	simulate := flag.Bool("simulate", false, "Monitor synthetic job data instead of querying Veeam, for demos and testing")
+	simulateFixture := flag.String("simulate-fixture", "", "JSON file with the job data used by -simulate, random data is generated when empty")
+	serviceCommand := flag.String("service", "", "Manage the Windows service: install (running with the -config file), uninstall, start or stop, then exit")
 	
 	// Parse command-line flags
 	flag.Parse()
-	
+
+	// Generate a sample configuration instead of monitoring
+	if *initConfig {
+		if err := veeammonitor.WriteSampleConfig(*configFile, *force); err != nil {
+			log.Fatalf("Error writing sample configuration: %v\n", err)
+		}
+		log.Printf("Sample configuration written to %s\n", *configFile)
+		return
+	}
+
+	// Upgrade an older configuration file instead of monitoring
+	if *migrate {
+		if err := veeammonitor.MigrateConfigFile(*configFile); err != nil {
+			log.Fatalf("Error migrating configuration: %v\n", err)
+		}
+		log.Printf("Configuration %s migrated to version %d, the original was saved as %s.bak\n",
+			*configFile, veeammonitor.CurrentConfigVersion, *configFile)
+		return
+	}
+
+	// Install or control the Windows service instead of monitoring
+	if *serviceCommand != "" {
+		if err := controlService(*serviceCommand, *configFile); err != nil {
+			log.Fatalf("Error running service command %s: %v\n", *serviceCommand, err)
+		}
+		log.Printf("Service command %s completed for %s\n", *serviceCommand, serviceName)
+		return
+	}
+
+	// Started by the service control manager
+	inService := isWindowsService()
+	if inService {
+		if err := useExecutableDirectory(); err != nil {
+			log.Printf("Error changing to the executable's directory: %v\n", err)
+		}
+	}
+
 	// Set up logging
 	logFile, err := setupLogging()
 	if err != nil {
 		log.Printf("Error setting up logging: %v. Will log to console only.\n", err)
 	} else {
 		defer logFile.Close()
+		// A service has no console to log to
+		if inService {
+			log.SetOutput(logFile)
+		}
 	}
 
 	// Load configuration from file
+	loadConfig := veeammonitor.LoadConfig
+	if *strictConfig {
+		loadConfig = veeammonitor.LoadStrictConfig
+	}
 	config, err := loadConfig(*configFile)
-	if err != nil {
+	if errors.Is(err, veeammonitor.ErrInvalidConfig) {
+		log.Fatalf("Error loading configuration: %v\n", err)
+	}
+	if errors.Is(err, veeammonitor.ErrConfigNotFound) {
+		log.Printf("No configuration file at %s, using default values and command-line parameters\n", *configFile)
+		config = veeammonitor.DefaultConfig()
+	} else if err != nil {
 		log.Printf("Error loading configuration: %v\n", err)
 		log.Println("Will use default values and command-line parameters")
 		// Create default config if file loading failed
-		config = &Config{
-			VeeamPowerShellModule: "Veeam.Backup.PowerShell",
-			CheckIntervalMinutes:  15,
-			SMTPPort:              25,
-			MonitorFailedJobs:     true,
-			LongRunningThreshold:  120,
-		}
+		config = veeammonitor.DefaultConfig()
 	}
 
 	// Override config with command-line parameters if provided
@@ -102,7 +132,7 @@ func main() {
 	}
 
 	// Validate essential configuration
-	if config.VeeamServerAddress == "" {
+	if config.VeeamServerAddress == "" && len(config.Servers) == 0 {
 		log.Println("Warning: No Veeam server address specified")
 	}
 	
@@ -110,59 +140,140 @@ func main() {
 		log.Println("Warning: Email configuration incomplete. Notifications will not be sent.")
 	}
 
-	log.Println("Starting Veeam backup monitoring service")
+	// Log lines carry the configured timestamp and each check cycle's ID
+	veeammonitor.UseLogWriter(config)
 
-	// Main monitoring loop
-	for {
-		log.Println("Checking Veeam backup job statuses...")
-		
-		// Monitor different job types based on configuration
-		var problematicJobs []JobStatus
-		
-		if config.MonitorFailedJobs {
-			failedJobs, err := getJobsByStatus(config, "Failed")
-			if err != nil {
-				log.Printf("Error checking failed jobs: %v\n", err)
-			} else {
-				log.Printf("Found %d failed jobs\n", len(failedJobs))
-				problematicJobs = append(problematicJobs, failedJobs...)
-			}
+	// Secrets are masked by Config.String
+	log.Printf("Effective configuration: %s\n", config)
+
+	// Verify notification settings instead of monitoring
+	if *testEmail {
+		if err := veeammonitor.SendTestEmail(config); err != nil {
+			log.Fatalf("Test email failed: %v\n", err)
 		}
-		
-		if config.MonitorWarningJobs {
-			warningJobs, err := getJobsByStatus(config, "Warning")
-			if err != nil {
-				log.Printf("Error checking warning jobs: %v\n", err)
+		log.Printf("Test email sent successfully to %s\n", strings.Join(config.EmailTo, ", "))
+		return
+	}
+
+	if *testNotify {
+		results := veeammonitor.SendTestNotifications(config)
+		if len(results) == 0 {
+			log.Fatalln("No notification channels are configured")
+		}
+
+		failed := false
+		for _, result := range results {
+			if result.Err != nil {
+				log.Printf("Test %s notification failed: %v\n", result.Channel, result.Err)
+				failed = true
 			} else {
-				log.Printf("Found %d warning jobs\n", len(warningJobs))
-				problematicJobs = append(problematicJobs, warningJobs...)
+				log.Printf("Test %s notification sent successfully\n", result.Channel)
 			}
 		}
-		
-		if config.MonitorRunningJobs {
-			longRunningJobs, err := getLongRunningJobs(config)
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Check every dependency instead of monitoring
+	if *diagnose {
+		results := veeammonitor.NewMonitor(config).Diagnose()
+		if len(config.Servers) > 0 {
+			results = veeammonitor.NewMonitorGroup(config).Diagnose()
+		}
+		results.Write(os.Stdout)
+		if results.Failed() {
+			log.Fatalln("Diagnostics found critical problems")
+		}
+		log.Println("All critical diagnostics passed")
+		return
+	}
+
+	log.Println("Starting Veeam backup monitoring service")
+
+	// Several servers are each checked by a monitor of their own, in parallel
+	if len(config.Servers) > 0 {
+		group := veeammonitor.NewMonitorGroup(config)
+		if *simulate {
+			dataset, err := loadSimulatedDataset(*simulateFixture)
 			if err != nil {
-				log.Printf("Error checking long-running jobs: %v\n", err)
-			} else {
-				log.Printf("Found %d long-running jobs\n", len(longRunningJobs))
-				problematicJobs = append(problematicJobs, longRunningJobs...)
+				log.Fatalf("Error loading simulation fixture: %v\n", err)
 			}
+			for _, monitor := range group.Monitors {
+				monitor.Source = veeammonitor.NewSimulatedSource(monitor.Config, dataset)
+			}
+			log.Println("SIMULATION MODE: job statuses are synthetic, Veeam is not queried")
 		}
-		
-		// Send email notifications if there are problematic jobs
-		if len(problematicJobs) > 0 {
-			if err := sendEmailAlert(problematicJobs, config); err != nil {
-				log.Printf("Error sending email alert: %v\n", err)
-			} else {
-				log.Println("Email alert sent successfully")
+		if *dashboard {
+			log.Println("The dashboard shows a single server, logging instead of showing it")
+		}
+
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		go func() {
+			for range hangups {
+				log.Println("Received SIGHUP, requesting an immediate check of every server")
+				group.TriggerCheck()
 			}
-		} else {
-			log.Println("No problematic jobs found")
+		}()
+
+		log.Printf("Monitoring %d Veeam servers\n", len(group.Monitors))
+		if err := runMonitor(group, inService); err != nil {
+			log.Printf("Error: %v\n", err)
+			os.Exit(veeammonitor.FatalErrorExitCode)
 		}
+		return
+	}
 
-		// Sleep until next check
-		log.Printf("Sleeping for %d minutes until next check\n", config.CheckIntervalMinutes)
-		time.Sleep(time.Duration(config.CheckIntervalMinutes) * time.Minute)
+	// Main monitoring loop
+	monitor := veeammonitor.NewMonitor(config)
+	if *simulate {
+		dataset, err := loadSimulatedDataset(*simulateFixture)
+		if err != nil {
+			log.Fatalf("Error loading simulation fixture: %v\n", err)
+		}
+		monitor.Source = veeammonitor.NewSimulatedSource(config, dataset)
+		log.Println("SIMULATION MODE: job statuses are synthetic, Veeam is not queried")
+	}
+
+	// SIGHUP runs a check immediately
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			log.Println("Received SIGHUP, requesting an immediate check")
+			monitor.TriggerCheck()
+		}
+	}()
+
+	// The dashboard replaces the log on screen, which it shows as recent events
+	if *dashboard && !inService {
+		if veeammonitor.IsTerminal(os.Stdout) {
+			events := veeammonitor.NewEventLog()
+			log.SetOutput(events)
+			veeammonitor.UseLogWriter(config)
+			stopOnSignal(monitor)
+			done := make(chan struct{})
+			go func() {
+				if err := monitor.Run(); err != nil {
+					log.Printf("Error: %v\n", err)
+					os.Exit(veeammonitor.FatalErrorExitCode)
+				}
+				close(done)
+			}()
+			if err := monitor.RunDashboard(os.Stdout, events); err != nil {
+				fmt.Fprintf(os.Stderr, "Error showing the dashboard: %v\n", err)
+				monitor.Stop()
+			}
+			<-done
+			return
+		}
+		log.Println("Standard output is not a terminal, logging instead of showing the dashboard")
+	}
+	if err := runMonitor(monitor, inService); err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(veeammonitor.FatalErrorExitCode)
 	}
 }
 
@@ -191,221 +302,11 @@ func setupLogging() (*os.File, error) {
 	return logFile, nil
 }
 
-// Load configuration from JSON file
-func loadConfig(filePath string) (*Config, error) {
-	data, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %v", err)
-	}
-
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %v", err)
-	}
-
-	// Set defaults for any missing values
-	if config.CheckIntervalMinutes < 1 {
-		log.Println("Warning: Check interval is less than 1 minute, setting to default of 15 minutes")
-		config.CheckIntervalMinutes = 15
-	}
-	
-	if !config.MonitorFailedJobs && !config.MonitorWarningJobs && !config.MonitorRunningJobs {
-		log.Println("Warning: No monitoring options enabled, enabling failed job monitoring by default")
-		config.MonitorFailedJobs = true
-	}
-	
-	if config.LongRunningThreshold < 1 {
-		config.LongRunningThreshold = 120 // Default to 2 hours
-		log.Println("Warning: Long running threshold not set, defaulting to 120 minutes")
-	}
-
-	return &config, nil
-}
-
-// Get jobs by status (Failed, Warning, etc.)
-func getJobsByStatus(config *Config, status string) ([]JobStatus, error) {
-	// PowerShell command to get jobs with specified status
-	psCommand := fmt.Sprintf(`
-		Import-Module %s
-		if ("%s" -ne "") {
-			$Server = Connect-VBRServer -Server %s
-		}
-		Get-VBRJob | Where-Object {$_.LastResult -eq "%s"} | Select-Object Name,LastResult,LastStart,LastEnd,Description | ConvertTo-Csv -NoTypeInformation
-		if ("%s" -ne "") {
-			Disconnect-VBRServer
-		}
-	`, config.VeeamPowerShellModule, config.VeeamServerAddress, config.VeeamServerAddress, status, config.VeeamServerAddress)
-
-	// Execute PowerShell command
-	cmd := exec.Command("powershell", "-Command", psCommand)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute PowerShell command for %s jobs: %v", status, err)
-	}
-
-	// Parse the CSV output
-	return parseJobStatusOutput(string(output), status)
-}
-
-// Get long-running jobs
-func getLongRunningJobs(config *Config) ([]JobStatus, error) {
-	// PowerShell command to get currently running jobs
-	psCommand := fmt.Sprintf(`
-		Import-Module %s
-		if ("%s" -ne "") {
-			$Server = Connect-VBRServer -Server %s
-		}
-		$runningJobs = Get-VBRJob | Where-Object {$_.IsRunning -eq $true} | Select-Object Name,@{Name="Status";Expression={"Running"}},@{Name="StartTime";Expression={$_.FindLastSession().CreationTime}},@{Name="EndTime";Expression={"N/A"}},@{Name="Description";Expression={"Currently running"}},@{Name="Duration";Expression={((Get-Date) - $_.FindLastSession().CreationTime).TotalMinutes}}
-		$longRunningJobs = $runningJobs | Where-Object {$_.Duration -gt %d}
-		$longRunningJobs | ConvertTo-Csv -NoTypeInformation
-		if ("%s" -ne "") {
-			Disconnect-VBRServer
-		}
-	`, config.VeeamPowerShellModule, config.VeeamServerAddress, config.VeeamServerAddress, config.LongRunningThreshold, config.VeeamServerAddress)
-
-	// Execute PowerShell command
-	cmd := exec.Command("powershell", "-Command", psCommand)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute PowerShell command for long-running jobs: %v", err)
-	}
-
-	// Parse the CSV output
-	jobs, err := parseJobStatusOutput(string(output), "Running")
-	if err != nil {
-		return nil, err
-	}
-	
-	// Add duration information to job description
-	for i := range jobs {
-		jobs[i].Description = fmt.Sprintf("Long-running job (over %d minutes): %s", 
-			config.LongRunningThreshold, jobs[i].Description)
-	}
-	
-	return jobs, nil
-}
-
-// Parse the CSV output from PowerShell
-func parseJobStatusOutput(output string, status string) ([]JobStatus, error) {
-	lines := strings.Split(output, "\n")
-	if len(lines) < 2 {
-		return []JobStatus{}, nil
-	}
-
-	var jobs []JobStatus
-	// Skip header line and process data lines
-	for i := 1; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
-			continue
-		}
 
-		// Parse CSV line (this is simplified - use a proper CSV parser in production)
-		fields := strings.Split(line, ",")
-		if len(fields) >= 5 {
-			job := JobStatus{
-				Name:        strings.Trim(fields[0], "\""),
-				Status:      strings.Trim(fields[1], "\""),
-				StartTime:   strings.Trim(fields[2], "\""),
-				EndTime:     strings.Trim(fields[3], "\""),
-				Description: strings.Trim(fields[4], "\""),
-			}
-			
-			// Add duration if available (for running jobs)
-			if len(fields) >= 6 {
-				job.Duration = strings.Trim(fields[5], "\"")
-			}
-			
-			jobs = append(jobs, job)
-		}
+// Load the -simulate-fixture dataset, nil for random data when no fixture is given
+func loadSimulatedDataset(path string) (*veeammonitor.SimulatedDataset, error) {
+	if path == "" {
+		return nil, nil
 	}
-
-	return jobs, nil
+	return veeammonitor.LoadSimulatedDataset(path)
 }
-
-// Send email alert for problematic jobs
-func sendEmailAlert(problematicJobs []JobStatus, config *Config) error {
-	// Create email subject and body
-	subject := fmt.Sprintf("ALERT: %d Veeam Backup Jobs Need Attention", len(problematicJobs))
-	
-	// Group jobs by status for better readability
-	failedJobs := []JobStatus{}
-	warningJobs := []JobStatus{}
-	runningJobs := []JobStatus{}
-	
-	for _, job := range problematicJobs {
-		switch job.Status {
-		case "Failed":
-			failedJobs = append(failedJobs, job)
-		case "Warning":
-			warningJobs = append(warningJobs, job)
-		case "Running":
-			runningJobs = append(runningJobs, job)
-		}
-	}
-	
-	// Build email body
-	body := "Veeam Backup & Replication Job Status Report\n"
-	body += "===========================================\n\n"
-	
-	if len(failedJobs) > 0 {
-		body += fmt.Sprintf("FAILED JOBS (%d):\n", len(failedJobs))
-		body += "--------------\n"
-		for _, job := range failedJobs {
-			body += fmt.Sprintf("Job: %s\nStatus: %s\nStart Time: %s\nEnd Time: %s\nDescription: %s\n\n",
-				job.Name, job.Status, job.StartTime, job.EndTime, job.Description)
-		}
-		body += "\n"
-	}
-	
-	if len(warningJobs) > 0 {
-		body += fmt.Sprintf("WARNING JOBS (%d):\n", len(warningJobs))
-		body += "----------------\n"
-		for _, job := range warningJobs {
-			body += fmt.Sprintf("Job: %s\nStatus: %s\nStart Time: %s\nEnd Time: %s\nDescription: %s\n\n",
-				job.Name, job.Status, job.StartTime, job.EndTime, job.Description)
-		}
-		body += "\n"
-	}
-	
-	if len(runningJobs) > 0 {
-		body += fmt.Sprintf("LONG-RUNNING JOBS (%d):\n", len(runningJobs))
-		body += "---------------------\n"
-		for _, job := range runningJobs {
-			durationText := ""
-			if job.Duration != "" {
-				durationMin, _ := strings.Split(job.Duration, ".")[0], strings.Split(job.Duration, ".")[1]
-				durationText = fmt.Sprintf(" (Running for %s minutes)", durationMin)
-			}
-			
-			body += fmt.Sprintf("Job: %s\nStatus: %s%s\nStart Time: %s\nDescription: %s\n\n",
-				job.Name, job.Status, durationText, job.StartTime, job.Description)
-		}
-	}
-	
-	body += "\nThis is an automated message from the Veeam Backup Monitor.\n"
-
-	// Prepare email message
-	msg := fmt.Sprintf("From: %s\r\n"+
-		"To: %s\r\n"+
-		"Subject: %s\r\n"+
-		"\r\n"+
-		"%s", config.EmailFrom, strings.Join(config.EmailTo, ", "), subject, body)
-
-	// Connect to SMTP server
-	var auth smtp.Auth
-	if config.EmailPassword != "" {
-		auth = smtp.PlainAuth("", config.EmailFrom, config.EmailPassword, config.SMTPServer)
-	}
-	
-	// Send the email
-	err := smtp.SendMail(
-		fmt.Sprintf("%s:%d", config.SMTPServer, config.SMTPPort),
-		auth,
-		config.EmailFrom,
-		config.EmailTo,
-		[]byte(msg),
-	)
-	
-	return err
-} 
\ No newline at end of file
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// Name the Windows service is installed under
+const serviceName = "VeeamBackupMonitor"
+
+// A Monitor or MonitorGroup, which the service stops when Windows asks it to
+type stoppableMonitor interface {
+	Run() error
+	Stop()
+}
+
+// Run the monitor under the service control manager when started as a
+// service, or else in the foreground until SIGINT or SIGTERM
+func runMonitor(monitor stoppableMonitor, inService bool) error {
+	if inService {
+		return runService(monitor)
+	}
+	stopOnSignal(monitor)
+	return monitor.Run()
+}
+
+// Stop the monitor on SIGINT or SIGTERM, which cuts a check in progress
+// short and lets Run save the state before returning. A second signal exits
+// at once.
+func stopOnSignal(monitor stoppableMonitor) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		log.Printf("Received %v, stopping\n", received)
+		monitor.Stop()
+		<-signals
+		log.Println("Received a second signal, exiting without waiting")
+		os.Exit(1)
+	}()
+}
+
+// Services start in the system directory; work from the executable's
+// directory instead, so the logs directory and relative paths in the config
+// are next to it
+func useExecutableDirectory() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return os.Chdir(filepath.Dir(exe))
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// Only Windows has a service control manager
+func isWindowsService() bool {
+	return false
+}
+
+func runService(monitor stoppableMonitor) error {
+	return fmt.Errorf("running as a service is only supported on Windows")
+}
+
+func controlService(command, configFile string) error {
+	return fmt.Errorf("-service is only supported on Windows, use systemd or another service manager to run the monitor")
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServiceOnlyOnWindows(t *testing.T) {
+	if isWindowsService() {
+		t.Error("detected a Windows service")
+	}
+	if err := runService(newFakeMonitor(nil)); err == nil {
+		t.Error("ran as a service")
+	}
+	if err := controlService("install", "config.json"); err == nil {
+		t.Error("installed a service")
+	}
+}
+
+func TestStopOnSignal(t *testing.T) {
+	monitor := newFakeMonitor(nil)
+	stopOnSignal(monitor)
+	// Or a later signal would exit the test binary
+	defer signal.Reset(os.Interrupt, syscall.SIGTERM)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-monitor.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor not stopped by SIGTERM")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// Monitor whose Run returns err once Stop is called
+type fakeMonitor struct {
+	err      error
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+func newFakeMonitor(err error) *fakeMonitor {
+	return &fakeMonitor{err: err, stopped: make(chan struct{})}
+}
+
+func (m *fakeMonitor) Run() error {
+	<-m.stopped
+	return m.err
+}
+
+func (m *fakeMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stopped) })
+}
+
+func TestRunMonitorInForeground(t *testing.T) {
+	monitor := newFakeMonitor(errors.New("fatal"))
+	monitor.Stop()
+	defer signal.Reset(os.Interrupt, syscall.SIGTERM)
+	if err := runMonitor(monitor, false); err == nil || err.Error() != "fatal" {
+		t.Errorf("got error %v, want Run's", err)
+	}
+}
+
+func TestUseExecutableDirectory(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	if err := useExecutableDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	exe, _ := os.Executable()
+	got, _ := os.Getwd()
+	if want := filepath.Dir(exe); got != want {
+		t.Errorf("working directory is %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"veeam-monitor/veeammonitor"
+)
+
+// How long -service stop waits for the service to stop
+const serviceStopTimeout = 5 * time.Minute
+
+// Whether the process was started by the service control manager
+func isWindowsService() bool {
+	inService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Printf("Error detecting whether running as a service: %v\n", err)
+		return false
+	}
+	return inService
+}
+
+// Handles service control requests, stopping the monitor on stop or shutdown
+type monitorService struct {
+	monitor stoppableMonitor
+}
+
+func (s *monitorService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.monitor.Run()
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			// Only a fatal error stops monitoring on its own
+			if err != nil {
+				log.Printf("Error: %v\n", err)
+				return true, veeammonitor.FatalErrorExitCode
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				changes <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Println("Service stop requested, stopping")
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopTimeout / time.Millisecond)}
+				s.monitor.Stop()
+				if err := <-done; err != nil {
+					log.Printf("Error: %v\n", err)
+				}
+				return false, 0
+			default:
+				log.Printf("Ignoring unexpected service control request %d\n", request.Cmd)
+			}
+		}
+	}
+}
+
+// Run the monitor under the service control manager until the service is
+// stopped
+func runService(monitor stoppableMonitor) error {
+	return svc.Run(serviceName, &monitorService{monitor: monitor})
+}
+
+// Carry out a -service command: install, uninstall, start or stop
+func controlService(command, configFile string) error {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("error connecting to the service control manager, run as Administrator: %v", err)
+	}
+	defer manager.Disconnect()
+
+	if command == "install" {
+		return installService(manager, configFile)
+	}
+
+	service, err := manager.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %v", serviceName, err)
+	}
+	defer service.Close()
+
+	switch command {
+	case "uninstall":
+		if status, err := service.Query(); err == nil && status.State != svc.Stopped {
+			if err := stopService(service); err != nil {
+				return err
+			}
+		}
+		return service.Delete()
+	case "start":
+		return service.Start()
+	case "stop":
+		return stopService(service)
+	}
+	return fmt.Errorf("unknown service command %q, use install, uninstall, start or stop", command)
+}
+
+// Register the service to start automatically at boot, running this
+// executable with the absolute path of the config file. Windows restarts it
+// a minute after it crashes or exits with an error.
+func installService(manager *mgr.Mgr, configFile string) error {
+	if service, err := manager.OpenService(serviceName); err == nil {
+		service.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	configPath, err := filepath.Abs(configFile)
+	if err != nil {
+		return err
+	}
+
+	service, err := manager.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Veeam Backup Monitor",
+		Description: "Monitors Veeam Backup & Replication jobs and sends alerts on failures",
+		StartType:   mgr.StartAutomatic,
+	}, "-config", configPath)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}}
+	if err := service.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		log.Printf("Error setting the service to restart after failures: %v\n", err)
+	}
+	if err := service.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		log.Printf("Error setting the service to restart after failures: %v\n", err)
+	}
+	return nil
+}
+
+// Ask the service to stop and wait until it has
+func stopService(service *mgr.Service) error {
+	status, err := service.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop within %v", serviceName, serviceStopTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if status, err = service.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+
+	"veeam-monitor/veeammonitor"
+)
+
+// Next status the service reported, failing the test when none comes
+func nextStatus(t *testing.T, changes chan svc.Status) svc.Status {
+	select {
+	case status := <-changes:
+		return status
+	case <-time.After(5 * time.Second):
+		t.Fatal("service reported no status")
+	}
+	return svc.Status{}
+}
+
+func TestServiceStopRequest(t *testing.T) {
+	monitor := newFakeMonitor(nil)
+	requests, changes := make(chan svc.ChangeRequest), make(chan svc.Status, 10)
+	type result struct {
+		specific bool
+		code     uint32
+	}
+	done := make(chan result)
+	go func() {
+		specific, code := (&monitorService{monitor: monitor}).Execute(nil, requests, changes)
+		done <- result{specific, code}
+	}()
+
+	if status := nextStatus(t, changes); status.State != svc.StartPending {
+		t.Errorf("got state %d, want start pending", status.State)
+	}
+	if status := nextStatus(t, changes); status.State != svc.Running || status.Accepts != svc.AcceptStop|svc.AcceptShutdown {
+		t.Errorf("got status %+v, want running and accepting stop and shutdown", status)
+	}
+	requests <- svc.ChangeRequest{Cmd: svc.Interrogate, CurrentStatus: svc.Status{State: svc.Running}}
+	if status := nextStatus(t, changes); status.State != svc.Running {
+		t.Errorf("got state %d answering interrogate", status.State)
+	}
+
+	requests <- svc.ChangeRequest{Cmd: svc.Stop}
+	if status := nextStatus(t, changes); status.State != svc.StopPending {
+		t.Errorf("got state %d, want stop pending", status.State)
+	}
+	if got := <-done; got.specific || got.code != 0 {
+		t.Errorf("got exit code %+v, want 0", got)
+	}
+}
+
+func TestServiceFatalError(t *testing.T) {
+	monitor := newFakeMonitor(errors.New("PowerShell is not installed"))
+	monitor.Stop()
+	specific, code := (&monitorService{monitor: monitor}).Execute(nil, make(chan svc.ChangeRequest), make(chan svc.Status, 10))
+	if !specific || code != veeammonitor.FatalErrorExitCode {
+		t.Errorf("got exit code %d (service specific %v), want %d", code, specific, veeammonitor.FatalErrorExitCode)
+	}
+}
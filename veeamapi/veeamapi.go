@@ -0,0 +1,358 @@
+// Package veeamapi is a client for the REST API of Veeam Backup &
+// Replication 11 and 12, enough to read job states, job sessions and
+// repository space without the Veeam PowerShell module.
+package veeamapi
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API revisions to request with x-api-version
+const (
+	APIVersion11 = "1.0-rev2" // Veeam Backup & Replication 11
+	APIVersion12 = "1.1-rev0" // Veeam Backup & Replication 12
+)
+
+// DefaultPort is where the Veeam Backup & Replication REST API listens
+const DefaultPort = 9419
+
+// Items requested per page of a list
+const pageSize = 500
+
+var (
+	// ErrUnreachable is returned when the API can't be connected to
+	ErrUnreachable = errors.New("Veeam REST API unreachable")
+
+	// ErrAuthentication is returned when the server rejects the credentials
+	ErrAuthentication = errors.New("Veeam REST API rejected the credentials")
+
+	// ErrInvalidResponse is returned for responses that aren't the expected JSON
+	ErrInvalidResponse = errors.New("invalid Veeam REST API response")
+)
+
+// Error is an unsuccessful API response, with the error body the API returned
+type Error struct {
+	StatusCode int
+	ErrorCode  string `json:"errorCode"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("Veeam REST API returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.ErrorCode != "" {
+		message += " (" + e.ErrorCode + ")"
+	}
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+// Client calls the API of one backup server. Tokens are requested with the
+// username and password on first use, refreshed before they expire, and
+// requested again when the server stops accepting them. A Client is safe
+// for concurrent use.
+type Client struct {
+	BaseURL    string // e.g. https://vbr.example.com:9419
+	Username   string
+	Password   string
+	APIVersion string // Defaults to APIVersion12
+	HTTPClient *http.Client
+
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expires      time.Time
+}
+
+// NewClient creates a client for the API at baseURL. Certificates are not
+// checked when insecureSkipVerify is set, for servers with the self-signed
+// certificate Veeam installs.
+func NewClient(baseURL, username, password string, insecureSkipVerify bool) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Username:   username,
+		Password:   password,
+		APIVersion: APIVersion12,
+		HTTPClient: &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+			},
+		},
+	}
+}
+
+// JobState is the state of a job and the result of its last run, from
+// /api/v1/jobs/states
+type JobState struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Type           string    `json:"type"`   // e.g. Backup, BackupCopy, WindowsAgentBackup
+	Status         string    `json:"status"` // running, inactive or disabled
+	LastResult     string    `json:"lastResult"`
+	LastRun        time.Time `json:"lastRun"`
+	NextRun        time.Time `json:"nextRun"`
+	Description    string    `json:"description"`
+	RepositoryName string    `json:"repositoryName"`
+}
+
+// Disabled reports whether the job's schedule is disabled
+func (j JobState) Disabled() bool {
+	return strings.EqualFold(j.Status, "disabled")
+}
+
+// Session is one run of a job, from /api/v1/sessions
+type Session struct {
+	ID              string        `json:"id"`
+	Name            string        `json:"name"`
+	JobID           string        `json:"jobId"`
+	SessionType     string        `json:"sessionType"`
+	CreationTime    time.Time     `json:"creationTime"`
+	EndTime         time.Time     `json:"endTime"`
+	State           string        `json:"state"` // Stopped once the session has ended
+	ProgressPercent int           `json:"progressPercent"`
+	Result          SessionResult `json:"result"`
+}
+
+// SessionResult is how a session ended
+type SessionResult struct {
+	Result     string `json:"result"` // None, Success, Warning or Failed
+	Message    string `json:"message"`
+	IsCanceled bool   `json:"isCanceled"`
+}
+
+// Stopped reports whether the session has ended
+func (s Session) Stopped() bool {
+	return strings.EqualFold(s.State, "Stopped")
+}
+
+// RepositoryState is the space of a repository, from
+// /api/v1/backupInfrastructure/repositories/states
+type RepositoryState struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	HostName    string  `json:"hostName"`
+	Path        string  `json:"path"`
+	CapacityGB  float64 `json:"capacityGB"`
+	FreeGB      float64 `json:"freeGB"`
+	UsedSpaceGB float64 `json:"usedSpaceGB"`
+}
+
+// JobStates lists every job with the state of its last run
+func (c *Client) JobStates() ([]JobState, error) {
+	var states []JobState
+	err := c.list("/api/v1/jobs/states", nil, func(data json.RawMessage) error {
+		var page []JobState
+		err := json.Unmarshal(data, &page)
+		states = append(states, page...)
+		return err
+	})
+	return states, err
+}
+
+// Sessions lists the newest job sessions, newest first, up to limit
+func (c *Client) Sessions(limit int) ([]Session, error) {
+	query := url.Values{
+		"orderColumn": {"CreationTime"},
+		"orderAsc":    {"false"},
+	}
+	var sessions []Session
+	err := c.list("/api/v1/sessions", query, func(data json.RawMessage) error {
+		var page []Session
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		sessions = append(sessions, page...)
+		if len(sessions) >= limit {
+			sessions = sessions[:limit]
+			return errLastPage
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+// RepositoryStates lists every repository with its capacity and free space
+func (c *Client) RepositoryStates() ([]RepositoryState, error) {
+	var states []RepositoryState
+	err := c.list("/api/v1/backupInfrastructure/repositories/states", nil, func(data json.RawMessage) error {
+		var page []RepositoryState
+		err := json.Unmarshal(data, &page)
+		states = append(states, page...)
+		return err
+	})
+	return states, err
+}
+
+// Returned by a page callback to stop paging without an error
+var errLastPage = errors.New("last page")
+
+// GET every page of a list, passing each page's data to add
+func (c *Client) list(path string, query url.Values, add func(json.RawMessage) error) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	for skip := 0; ; {
+		query.Set("skip", strconv.Itoa(skip))
+		query.Set("limit", strconv.Itoa(pageSize))
+
+		var page struct {
+			Data       json.RawMessage `json:"data"`
+			Pagination struct {
+				Total int `json:"total"`
+				Count int `json:"count"`
+			} `json:"pagination"`
+		}
+		if err := c.Get(path+"?"+query.Encode(), &page); err != nil {
+			return err
+		}
+		if err := add(page.Data); err == errLastPage {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidResponse, path, err)
+		}
+
+		skip += page.Pagination.Count
+		if page.Pagination.Count == 0 || skip >= page.Pagination.Total {
+			return nil
+		}
+	}
+}
+
+// Get requests an API resource and decodes its JSON response into v
+func (c *Client) Get(path string, v interface{}) error {
+	token, err := c.token(false)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do("GET", path, token, nil)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// The token was revoked or the server restarted, log on again once
+		resp.Body.Close()
+		if token, err = c.token(true); err != nil {
+			return err
+		}
+		resp, err = c.do("GET", path, token, nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: error reading response: %v", ErrUnreachable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp.StatusCode, data)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidResponse, path, err)
+	}
+	return nil
+}
+
+// Access token to call the API with: the current one, one refreshed with the
+// refresh token when it is about to expire, or a new one from the
+// credentials when there is none, renew is set or refreshing fails
+func (c *Client) token(renew bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !renew && c.accessToken != "" && time.Until(c.expires) > time.Minute {
+		return c.accessToken, nil
+	}
+	if !renew && c.refreshToken != "" {
+		if err := c.requestToken(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {c.refreshToken}}); err == nil {
+			return c.accessToken, nil
+		}
+	}
+	err := c.requestToken(url.Values{"grant_type": {"password"}, "username": {c.Username}, "password": {c.Password}})
+	return c.accessToken, err
+}
+
+// Request a token from /api/oauth2/token, keeping it and its refresh token
+func (c *Client) requestToken(form url.Values) error {
+	c.accessToken, c.refreshToken = "", ""
+	resp, err := c.do("POST", "/api/oauth2/token", "", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: error reading token response: %v", ErrUnreachable, err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w for %s: %v", ErrAuthentication, c.Username, responseError(resp.StatusCode, data))
+	case resp.StatusCode != http.StatusOK:
+		return responseError(resp.StatusCode, data)
+	}
+
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"` // Seconds
+	}
+	if err := json.Unmarshal(data, &token); err != nil || token.AccessToken == "" {
+		return fmt.Errorf("%w: no access token in the token response", ErrInvalidResponse)
+	}
+	c.accessToken, c.refreshToken = token.AccessToken, token.RefreshToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return nil
+}
+
+// Send a request with the API version header, authenticated with token
+// unless it is empty, in which case body is a token request form
+func (c *Client) do(method, path, token string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	version := c.APIVersion
+	if version == "" {
+		version = APIVersion12
+	}
+	request.Header.Set("x-api-version", version)
+	request.Header.Set("Accept", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w at %s: %v", ErrUnreachable, c.BaseURL, err)
+	}
+	return resp, nil
+}
+
+// Error for an unsuccessful response, with the API's message when the body has one
+func responseError(statusCode int, body []byte) error {
+	apiErr := &Error{StatusCode: statusCode}
+	if json.Unmarshal(body, apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(strings.SplitN(string(body), "\n", 2)[0])
+	}
+	return apiErr
+}
@@ -0,0 +1,219 @@
+package veeamapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stand-in backup server answering token requests and paging lists two
+// items at a time
+type fakeServer struct {
+	mu        sync.Mutex
+	expiresIn int // Lifetime of issued tokens in seconds
+	tokens    map[string]bool
+	refresh   map[string]bool
+	logons    int
+	refreshes int
+	requests  []*http.Request
+
+	jobs     []JobState
+	sessions []Session
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *Client) {
+	f := &fakeServer{expiresIn: 900, tokens: map[string]bool{}, refresh: map[string]bool{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, NewClient(server.URL+"/", "admin", "secret", false)
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/api/oauth2/token" {
+		r.ParseForm()
+		switch {
+		case r.PostForm.Get("grant_type") == "password" && r.PostForm.Get("username") == "admin" && r.PostForm.Get("password") == "secret":
+			f.logons++
+		case r.PostForm.Get("grant_type") == "refresh_token" && f.refresh[r.PostForm.Get("refresh_token")]:
+			f.refreshes++
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errorCode":"invalid_grant","message":"Invalid credentials"}`)
+			return
+		}
+		n := f.logons + f.refreshes
+		access, refresh := "access-"+strconv.Itoa(n), "refresh-"+strconv.Itoa(n)
+		f.tokens[access], f.refresh[refresh] = true, true
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": access, "refresh_token": refresh, "expires_in": f.expiresIn})
+		return
+	}
+
+	if !f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var items interface{}
+	switch r.URL.Path {
+	case "/api/v1/jobs/states":
+		items = f.jobs
+	case "/api/v1/sessions":
+		items = f.sessions
+	case "/api/v1/broken":
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"errorCode":"InternalError","message":"Database unavailable"}`)
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	data, _ := json.Marshal(items)
+	var all []json.RawMessage
+	json.Unmarshal(data, &all)
+	skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+	end := skip + 2
+	if end > len(all) {
+		end = len(all)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":       all[skip:end],
+		"pagination": map[string]int{"total": len(all), "count": end - skip, "skip": skip},
+	})
+}
+
+// Revoke every access token, as a server restart does
+func (f *fakeServer) revoke() {
+	f.mu.Lock()
+	f.tokens = map[string]bool{}
+	f.mu.Unlock()
+}
+
+func (f *fakeServer) counts() (logons, refreshes, requests int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logons, f.refreshes, len(f.requests)
+}
+
+func TestJobStatesPaged(t *testing.T) {
+	server, client := newFakeServer(t)
+	for i := 1; i <= 5; i++ {
+		server.jobs = append(server.jobs, JobState{ID: strconv.Itoa(i), Name: fmt.Sprintf("Job %d", i), Type: "Backup", LastResult: "Success"})
+	}
+	server.jobs[4].Status = "Disabled"
+
+	states, err := client.JobStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 5 || states[0].Name != "Job 1" || states[4].Name != "Job 5" || !states[4].Disabled() || states[0].Disabled() {
+		t.Fatalf("got %+v, want all five jobs", states)
+	}
+	if logons, _, requests := server.counts(); logons != 1 || requests != 4 {
+		t.Errorf("got %d logons and %d requests, want one logon and three pages", logons, requests)
+	}
+	for _, request := range server.requests[1:] {
+		if request.Header.Get("x-api-version") != APIVersion12 || request.Header.Get("Authorization") != "Bearer access-1" {
+			t.Errorf("got headers %v", request.Header)
+		}
+	}
+}
+
+func TestSessionsLimit(t *testing.T) {
+	server, client := newFakeServer(t)
+	start := time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		server.sessions = append(server.sessions, Session{ID: strconv.Itoa(i), JobID: "1", State: "Stopped", CreationTime: start.Add(-time.Duration(i) * time.Hour), Result: SessionResult{Result: "Success"}})
+	}
+
+	sessions, err := client.Sessions(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 3 || sessions[2].ID != "2" || !sessions[0].Stopped() || !sessions[0].CreationTime.Equal(start) {
+		t.Errorf("got %+v, want the newest three sessions", sessions)
+	}
+	if _, _, requests := server.counts(); requests != 3 {
+		t.Errorf("got %d requests, want paging to stop once the limit was reached", requests)
+	}
+	query := server.requests[1].URL.Query()
+	if query.Get("orderColumn") != "CreationTime" || query.Get("orderAsc") != "false" {
+		t.Errorf("got query %v, want sessions newest first", query)
+	}
+}
+
+func TestLogonAgainWhenTokenRejected(t *testing.T) {
+	server, client := newFakeServer(t)
+	if _, err := client.JobStates(); err != nil {
+		t.Fatal(err)
+	}
+	server.revoke()
+	if _, err := client.JobStates(); err != nil {
+		t.Fatalf("after the token was revoked: %v", err)
+	}
+	if logons, refreshes, _ := server.counts(); logons != 2 || refreshes != 0 {
+		t.Errorf("got %d logons and %d refreshes, want a new logon after the 401", logons, refreshes)
+	}
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+	server, client := newFakeServer(t)
+	server.expiresIn = 30
+	if _, err := client.JobStates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.JobStates(); err != nil {
+		t.Fatal(err)
+	}
+	if logons, refreshes, _ := server.counts(); logons != 1 || refreshes != 1 {
+		t.Errorf("got %d logons and %d refreshes, want the expiring token refreshed", logons, refreshes)
+	}
+
+	// A rejected refresh token falls back to the credentials
+	server.mu.Lock()
+	server.refresh = map[string]bool{}
+	server.mu.Unlock()
+	if _, err := client.JobStates(); err != nil {
+		t.Fatal(err)
+	}
+	if logons, _, _ := server.counts(); logons != 2 {
+		t.Errorf("got %d logons, want a new logon when refreshing failed", logons)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server, client := newFakeServer(t)
+	client.Password = "wrong"
+	if _, err := client.JobStates(); !errors.Is(err, ErrAuthentication) || !strings.Contains(err.Error(), "Invalid credentials") {
+		t.Errorf("got error %v, want ErrAuthentication with the server's message", err)
+	}
+
+	client.Password = "secret"
+	err := client.Get("/api/v1/broken", &struct{}{})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || err.Error() != "Veeam REST API returned 500 Internal Server Error (InternalError): Database unavailable" {
+		t.Errorf("got error %v", err)
+	}
+
+	server.mu.Lock()
+	server.jobs = []JobState{{ID: "1"}}
+	server.mu.Unlock()
+	if err := client.Get("/api/v1/jobs/states", &[]JobState{}); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("got error %v decoding a page as a list, want ErrInvalidResponse", err)
+	}
+
+	unreachable := NewClient("http://127.0.0.1:1", "admin", "secret", false)
+	if _, err := unreachable.JobStates(); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("got error %v, want ErrUnreachable", err)
+	}
+}
@@ -0,0 +1,250 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Acknowledgement silencing a job's alerts while someone works on it
+type jobAck struct {
+	Name         string    `json:"name"`
+	JobType      string    `json:"jobType"`
+	Status       string    `json:"status"` // Problems acknowledged, as jobProblems has them; a new one clears the ack
+	Acknowledged time.Time `json:"acknowledged"`
+	Expires      time.Time `json:"expires"` // Zero when the ack lasts until the job changes
+	Comment      string    `json:"comment,omitempty"`
+}
+
+// Problems found by the last check, served by /status
+type cycleStatus struct {
+	Checked      time.Time          `json:"checked"`
+	Unreachable  bool               `json:"unreachable"`
+	Jobs         []JobStatus        `json:"jobs"`
+	Repositories []RepositoryStatus `json:"repositories"`
+	Acks         []jobAck           `json:"acks"`
+	Circuits     []channelCircuit   `json:"circuits"` // Notification channel circuit breakers
+	History      []cycleEvent       `json:"history"`  // Recent checks, oldest first
+	Pause        pauseStatus        `json:"pause"`
+}
+
+// Flag acknowledged jobs and drop acks that no longer apply: expired ones,
+// ones whose job now shows a problem that wasn't acknowledged and, when the
+// cycle saw every job (complete), ones whose job has recovered. A job that
+// loses one of its acknowledged problems stays acknowledged.
+func (m *Monitor) applyAcks(jobs []JobStatus, complete bool, now time.Time) []JobStatus {
+	m.ackMu.Lock()
+	defer m.ackMu.Unlock()
+	if len(m.state.Acks) == 0 {
+		return jobs
+	}
+
+	problems := problemsByJob(jobs)
+	for key, problem := range problems {
+		ack := m.state.Acks[key]
+		if ack == nil {
+			continue
+		}
+		switch {
+		case !ack.Expires.IsZero() && !now.Before(ack.Expires):
+			m.logf("Acknowledgement of job %s expired\n", ack.Name)
+			delete(m.state.Acks, key)
+		case !coversStatuses(ack.Status, problem.Status):
+			m.logf("Acknowledgement of job %s cleared, its status changed from %s to %s\n", ack.Name, ack.Status, problem.Status)
+			delete(m.state.Acks, key)
+		}
+	}
+
+	if complete {
+		for key, ack := range m.state.Acks {
+			if problems[key] == nil {
+				m.logf("Acknowledgement of job %s cleared, the job has recovered\n", ack.Name)
+				delete(m.state.Acks, key)
+			}
+		}
+	}
+
+	for i, job := range jobs {
+		if m.state.Acks[jobIdentity(job)] != nil {
+			jobs[i].Acknowledged = true
+		}
+	}
+	return jobs
+}
+
+// Whether every one of statuses was acknowledged in acked, both comma
+// separated as jobProblems has them
+func coversStatuses(acked, statuses string) bool {
+	ackedList := strings.Split(acked, ", ")
+	for _, status := range strings.Split(statuses, ", ") {
+		if !containsString(ackedList, status) {
+			return false
+		}
+	}
+	return true
+}
+
+// Split off acknowledged jobs, which are not alerted on
+func withoutAcknowledged(jobs []JobStatus) ([]JobStatus, int) {
+	return withoutJobs(jobs, func(job JobStatus) bool { return job.Acknowledged })
+}
+
+// Split off failed jobs Veeam will retry, for SuppressRetryPendingAlerts
+func withoutRetryPending(jobs []JobStatus) ([]JobStatus, int) {
+	return withoutJobs(jobs, func(job JobStatus) bool { return job.Status == "Failed" && job.RetryPending })
+}
+
+// Drop the jobs matching drop, returning the rest and how many were dropped
+func withoutJobs(jobs []JobStatus, drop func(JobStatus) bool) ([]JobStatus, int) {
+	var kept []JobStatus
+	dropped := 0
+	for _, job := range jobs {
+		if drop(job) {
+			dropped++
+			continue
+		}
+		kept = append(kept, job)
+	}
+	return kept, dropped
+}
+
+// Acknowledge the currently problematic jobs with the given name, and type
+// when one is given, for duration or, when zero, until the job changes
+func (m *Monitor) acknowledge(name, jobType string, duration time.Duration, comment string, now time.Time) ([]jobAck, error) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	m.ackMu.Lock()
+	defer m.ackMu.Unlock()
+
+	// A job with several problems gets one ack covering all of them
+	problems := problemsByJob(m.status.Jobs)
+	acked := map[string]bool{}
+	var acks []jobAck
+	for i, job := range m.status.Jobs {
+		if !strings.EqualFold(job.Name, name) || (jobType != "" && !strings.EqualFold(job.JobType, jobType)) {
+			continue
+		}
+		m.status.Jobs[i].Acknowledged = true
+		key := jobIdentity(job)
+		if acked[key] {
+			continue
+		}
+		acked[key] = true
+		ack := jobAck{Name: job.Name, JobType: job.JobType, Status: problems[key].Status, Acknowledged: now, Comment: comment}
+		if duration > 0 {
+			ack.Expires = now.Add(duration)
+		}
+		if m.state.Acks == nil {
+			m.state.Acks = map[string]*jobAck{}
+		}
+		m.state.Acks[key] = &ack
+		acks = append(acks, ack)
+	}
+	if len(acks) == 0 {
+		return nil, fmt.Errorf("job %q has no current problem to acknowledge", name)
+	}
+	return acks, nil
+}
+
+// Record the problems found by a check for /status, and what changed since
+// the previous check in the cycle history. The jobs are copied as
+// acknowledging one flags it in place.
+func (m *Monitor) setStatus(status cycleStatus) {
+	status.Jobs = append([]JobStatus{}, status.Jobs...)
+	m.statusMu.Lock()
+	previous := m.status
+	m.status = status
+	m.statusMu.Unlock()
+	m.cycleHistory().add(newCycleEvent(status, previous, m.cycleID))
+}
+
+// Acknowledge a job: POST /ack with job, and optionally jobType, duration
+// (e.g. 4h) and comment, as form values or a JSON object
+func (m *Monitor) handleAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Job      string `json:"job"`
+		JobType  string `json:"jobType"`
+		Duration string `json:"duration"`
+		Comment  string `json:"comment"`
+		UI       bool   `json:"-"` // Posted by the web page, which is shown again
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+	} else {
+		request.Job, request.JobType = r.FormValue("job"), r.FormValue("jobType")
+		request.Duration, request.Comment = r.FormValue("duration"), r.FormValue("comment")
+		request.UI = r.FormValue("ui") != ""
+	}
+	if strings.TrimSpace(request.Job) == "" {
+		http.Error(w, "job is required", http.StatusBadRequest)
+		return
+	}
+	var duration time.Duration
+	if request.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, fmt.Sprintf("invalid duration %q, use e.g. 30m or 4h", request.Duration), http.StatusBadRequest)
+			return
+		}
+	}
+
+	acks, err := m.acknowledge(strings.TrimSpace(request.Job), request.JobType, duration, request.Comment, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	m.logf("Job %s acknowledged over HTTP from %s\n", request.Job, r.RemoteAddr)
+
+	// Save now unless a running check will save it when it finishes
+	if m.cycleMu.TryLock() {
+		m.saveState()
+		m.cycleMu.Unlock()
+	}
+
+	if request.UI {
+		http.Redirect(w, r, m.pathPrefix+"/", http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(acks)
+}
+
+// Problems found by the last check, with acknowledged jobs flagged
+func (m *Monitor) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m.statusMu.Lock()
+	status := m.status
+	m.statusMu.Unlock()
+
+	m.ackMu.Lock()
+	status.Acks = []jobAck{}
+	for _, ack := range m.state.Acks {
+		status.Acks = append(status.Acks, *ack)
+	}
+	m.ackMu.Unlock()
+	sort.Slice(status.Acks, func(i, j int) bool { return status.Acks[i].Name < status.Acks[j].Name })
+	status.Circuits = m.channelCircuits()
+	status.History = m.cycleHistory().list()
+	status.Pause = m.pauseState(time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,124 @@
+package veeammonitor
+
+import (
+	"testing"
+	"time"
+)
+
+// Monitor whose last check found jobs, ready to acknowledge them
+func newAckMonitor(jobs []JobStatus) *Monitor {
+	m := newTestMonitor(testConfig(), nil)
+	m.status.Jobs = append([]JobStatus{}, jobs...)
+	return m
+}
+
+// Whether each job is flagged acknowledged, in order
+func acknowledgedFlags(jobs []JobStatus) []bool {
+	var flags []bool
+	for _, job := range jobs {
+		flags = append(flags, job.Acknowledged)
+	}
+	return flags
+}
+
+func TestAcknowledgeSilencesJob(t *testing.T) {
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+	other := JobStatus{Name: "Files", JobType: "Backup", Status: "Failed"}
+	m := newAckMonitor([]JobStatus{failed, other})
+	now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	if _, err := m.acknowledge("nightly", "", 0, "disk replaced", now); err != nil {
+		t.Fatal(err)
+	}
+	jobs := m.applyAcks([]JobStatus{failed, other}, true, now.Add(time.Hour))
+	if flags := acknowledgedFlags(jobs); !flags[0] || flags[1] {
+		t.Errorf("got acknowledged flags %v, want only Nightly", flags)
+	}
+	alertJobs, acknowledged := withoutAcknowledged(jobs)
+	if acknowledged != 1 || len(alertJobs) != 1 || alertJobs[0].Name != "Files" {
+		t.Errorf("alerting on %v with %d acknowledged, want Files with 1", jobNames(alertJobs), acknowledged)
+	}
+}
+
+func TestAcknowledgeUnknownJob(t *testing.T) {
+	m := newAckMonitor([]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}})
+	if _, err := m.acknowledge("Files", "", 0, "", time.Now()); err == nil {
+		t.Error("acknowledged a job with no problem")
+	}
+}
+
+func TestAckExpiry(t *testing.T) {
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+	m := newAckMonitor([]JobStatus{failed})
+	now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	if _, err := m.acknowledge("Nightly", "", 4*time.Hour, "", now); err != nil {
+		t.Fatal(err)
+	}
+
+	if jobs := m.applyAcks([]JobStatus{failed}, true, now.Add(3*time.Hour)); !jobs[0].Acknowledged {
+		t.Error("ack lapsed before it expired")
+	}
+	if jobs := m.applyAcks([]JobStatus{failed}, true, now.Add(4*time.Hour)); jobs[0].Acknowledged {
+		t.Error("ack still applies after it expired")
+	}
+	if len(m.state.Acks) != 0 {
+		t.Error("expired ack kept")
+	}
+}
+
+func TestAckClearedByStateChange(t *testing.T) {
+	now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	warning := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Warning"}
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+
+	m := newAckMonitor([]JobStatus{warning})
+	m.acknowledge("Nightly", "", 0, "", now)
+	if jobs := m.applyAcks([]JobStatus{failed}, true, now.Add(time.Hour)); jobs[0].Acknowledged || len(m.state.Acks) != 0 {
+		t.Error("ack of a warning still applies to a failure")
+	}
+
+	m = newAckMonitor([]JobStatus{warning})
+	m.acknowledge("Nightly", "", 0, "", now)
+	m.applyAcks(nil, true, now.Add(time.Hour))
+	if len(m.state.Acks) != 0 {
+		t.Error("ack kept after the job recovered")
+	}
+
+	// A check that missed some jobs can't tell a recovery apart
+	m = newAckMonitor([]JobStatus{warning})
+	m.acknowledge("Nightly", "", 0, "", now)
+	m.applyAcks(nil, false, now.Add(time.Hour))
+	if len(m.state.Acks) != 1 {
+		t.Error("ack dropped by an incomplete check")
+	}
+}
+
+func TestAckOfJobWithTwoProblems(t *testing.T) {
+	now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+	drift := JobStatus{Name: "Nightly", JobType: "Backup", Status: driftStatus}
+	m := newAckMonitor([]JobStatus{failed, drift})
+	acks, err := m.acknowledge("Nightly", "", 0, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acks) != 1 {
+		t.Fatalf("got %d acks, want one covering both problems", len(acks))
+	}
+
+	for _, order := range [][]JobStatus{{failed, drift}, {drift, failed}} {
+		jobs := m.applyAcks(order, true, now.Add(time.Hour))
+		if flags := acknowledgedFlags(jobs); !flags[0] || !flags[1] {
+			t.Errorf("got acknowledged flags %v, want both", flags)
+		}
+	}
+
+	// Losing a problem keeps the ack, gaining one clears it
+	if jobs := m.applyAcks([]JobStatus{failed}, true, now.Add(2*time.Hour)); !jobs[0].Acknowledged {
+		t.Error("ack cleared when the drift went away")
+	}
+	stale := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Stale"}
+	if jobs := m.applyAcks([]JobStatus{failed, stale}, true, now.Add(3*time.Hour)); jobs[0].Acknowledged || jobs[1].Acknowledged {
+		t.Error("ack still applies after a new problem")
+	}
+}
@@ -0,0 +1,113 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FailureCause is a failure reason shared by several failed or warning jobs,
+// listed once in alerts so a common cause, such as a repository or proxy
+// going down, isn't buried among the jobs it broke
+type FailureCause struct {
+	Status string      `json:"status"`
+	Reason string      `json:"reason"` // Description of the first of the jobs, its name replaced by <job>
+	Jobs   []JobStatus `json:"jobs"`
+}
+
+// Parts of a failure reason that differ between jobs with the same cause
+var (
+	failureReasonNumbers = regexp.MustCompile(`[0-9]+`)
+	failureReasonSpaces  = regexp.MustCompile(`\s+`)
+)
+
+// Key grouping jobs whose failure reasons match once case, the job's own
+// name, numbers (sizes, IDs, times) and spacing are disregarded
+func failureReasonKey(job JobStatus) string {
+	reason := strings.ToLower(job.Description)
+	if job.Name != "" {
+		reason = strings.ReplaceAll(reason, strings.ToLower(job.Name), "{job}")
+	}
+	reason = failureReasonNumbers.ReplaceAllString(reason, "#")
+	reason = failureReasonSpaces.ReplaceAllString(reason, " ")
+	return job.Status + "/" + strings.TrimRight(strings.TrimSpace(reason), ".")
+}
+
+// Failed and warning jobs sharing a failure reason with at least minJobs-1
+// others, most jobs first. Jobs without a description are never grouped.
+func failureCauses(jobs []JobStatus, minJobs int) []FailureCause {
+	if minJobs < 2 {
+		return nil
+	}
+	byReason := map[string]*FailureCause{}
+	var keys []string
+	for _, job := range jobs {
+		if (job.Status != "Failed" && job.Status != "Warning") || strings.TrimSpace(job.Description) == "" {
+			continue
+		}
+		key := failureReasonKey(job)
+		cause := byReason[key]
+		if cause == nil {
+			cause = &FailureCause{Status: job.Status, Reason: job.Description}
+			if job.Name != "" {
+				cause.Reason = regexp.MustCompile("(?i)"+regexp.QuoteMeta(job.Name)).ReplaceAllLiteralString(job.Description, "<job>")
+			}
+			byReason[key] = cause
+			keys = append(keys, key)
+		}
+		cause.Jobs = append(cause.Jobs, job)
+	}
+
+	var causes []FailureCause
+	for _, key := range keys {
+		if cause := byReason[key]; len(cause.Jobs) >= minJobs {
+			causes = append(causes, *cause)
+		}
+	}
+	sort.SliceStable(causes, func(i, j int) bool { return len(causes[i].Jobs) > len(causes[j].Jobs) })
+	return causes
+}
+
+// Heading of a cause, e.g. "23 jobs failed: Repository is unavailable"
+func (c FailureCause) summary(config *Config) string {
+	verb := "failed"
+	if c.Status == "Warning" {
+		verb = "finished with warnings"
+	}
+	return fmt.Sprintf("%d jobs %s: %s", len(c.Jobs), verb, config.displayDescription(c.Reason))
+}
+
+// Names of a cause's jobs with their types, e.g. "SQL01 (Backup), SQL02 (Backup)"
+func (c FailureCause) jobList() string {
+	names := make([]string, len(c.Jobs))
+	for i, job := range c.Jobs {
+		names[i] = fmt.Sprintf("%s (%s)", job.Name, job.JobType)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Whether a job is listed under one of the report's causes rather than on its own
+func (r *AlertReport) inCause(job JobStatus) bool {
+	key := jobIdentity(job)
+	for _, cause := range r.Causes {
+		if cause.Status != job.Status {
+			continue
+		}
+		for _, causeJob := range cause.Jobs {
+			if jobIdentity(causeJob) == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Jobs not listed under one of the report's causes
+func (r *AlertReport) withoutCauses(jobs []JobStatus) []JobStatus {
+	if len(r.Causes) == 0 {
+		return jobs
+	}
+	kept, _ := withoutJobs(jobs, r.inCause)
+	return kept
+}
@@ -0,0 +1,110 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Jobs broken by a repository outage, with one failing for its own reason
+func repositoryOutageJobs() []JobStatus {
+	return []JobStatus{
+		{Name: "SQL01", JobType: "Backup", Status: "Failed", Description: "Repository 'Main' is unavailable (error 1231)."},
+		{Name: "Exchange", JobType: "Backup", Status: "Failed", Description: "License expired"},
+		{Name: "SQL02", JobType: "Backup", Status: "Failed", Description: "repository 'Main'  is unavailable (error 1232)"},
+		{Name: "Files", JobType: "Backup", Status: "Warning", Description: "Repository 'Main' is unavailable (error 1231)."},
+		{Name: "Web", JobType: "Backup", Status: "Failed", Description: "Repository 'Main' is unavailable (error 1231)."},
+	}
+}
+
+func TestFailureCauses(t *testing.T) {
+	causes := failureCauses(repositoryOutageJobs(), 2)
+	if len(causes) != 1 {
+		t.Fatalf("got causes %+v, want one", causes)
+	}
+	cause := causes[0]
+	if cause.Status != "Failed" || cause.Reason != "Repository 'Main' is unavailable (error 1231)." ||
+		!equalStrings(jobNames(cause.Jobs), []string{"SQL01", "SQL02", "Web"}) {
+		t.Errorf("got cause %+v, want the three failed jobs sharing the repository reason", cause)
+	}
+
+	if causes := failureCauses(repositoryOutageJobs(), 4); len(causes) != 0 {
+		t.Errorf("got causes %+v with fewer jobs than the minimum", causes)
+	}
+	if causes := failureCauses(repositoryOutageJobs(), 0); causes != nil {
+		t.Errorf("got causes %+v with aggregation disabled", causes)
+	}
+}
+
+func TestFailureCausesSeparateReasons(t *testing.T) {
+	jobs := []JobStatus{
+		{Name: "SQL01", JobType: "Backup", Status: "Failed", Description: "Proxy PX1 is offline"},
+		{Name: "SQL02", JobType: "Backup", Status: "Failed", Description: "Proxy PX1 is offline"},
+		{Name: "Web", JobType: "Backup", Status: "Failed", Description: "Repository 'Main' is unavailable"},
+		{Name: "Files", JobType: "Backup", Status: "Failed", Description: "Repository 'Main' is unavailable"},
+		{Name: "Mail", JobType: "Backup", Status: "Failed", Description: "Repository 'Main' is unavailable"},
+		{Name: "Fileshare", JobType: "Backup", Status: "Failed", Description: "Job Fileshare exceeded its backup window"},
+		{Name: "Archive", JobType: "Backup", Status: "Failed", Description: "Job archive exceeded its backup window"},
+		{Name: "Empty1", JobType: "Backup", Status: "Failed"},
+		{Name: "Empty2", JobType: "Backup", Status: "Failed"},
+	}
+	causes := failureCauses(jobs, 2)
+	want := []struct {
+		reason string
+		jobs   []string
+	}{
+		{"Repository 'Main' is unavailable", []string{"Web", "Files", "Mail"}},
+		{"Proxy PX1 is offline", []string{"SQL01", "SQL02"}},
+		{"Job <job> exceeded its backup window", []string{"Fileshare", "Archive"}},
+	}
+	if len(causes) != len(want) {
+		t.Fatalf("got %d causes %+v, want %d", len(causes), causes, len(want))
+	}
+	for i, want := range want {
+		if causes[i].Reason != want.reason || !equalStrings(jobNames(causes[i].Jobs), want.jobs) {
+			t.Errorf("cause %d: got %q with %v, want %q with %v", i, causes[i].Reason, jobNames(causes[i].Jobs), want.reason, want.jobs)
+		}
+	}
+}
+
+func TestCausesInReport(t *testing.T) {
+	config := testConfig()
+	config.AggregateFailuresMinJobs = 3
+	report := NewAlertReport(repositoryOutageJobs(), nil, severityCritical, config, time.Now())
+
+	_, body := buildEmailBody(report, config)
+	for _, want := range []string{
+		"COMMON FAILURE CAUSES (1):",
+		"3 jobs failed: Repository 'Main' is unavailable (error 1231).\nJobs: SQL01 (Backup), SQL02 (Backup), Web (Backup)",
+		"Job: Exchange",
+		"Job: Files",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Job: SQL01") {
+		t.Errorf("job listed under its cause is listed again on its own:\n%s", body)
+	}
+
+	// The JSON report and CSV keep every job with its own detail
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded AlertReport
+	json.Unmarshal(data, &decoded)
+	if len(decoded.Failed) != 4 || len(decoded.Causes) != 1 || decoded.Failed[2].Description != "repository 'Main'  is unavailable (error 1232)" {
+		t.Errorf("got JSON report %s", data)
+	}
+	attachment, err := csvAttachment(report, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"SQL01", "SQL02", "Web", "Exchange", "Files"} {
+		if !strings.Contains(string(attachment.Data), name) {
+			t.Errorf("CSV is missing %s:\n%s", name, attachment.Data)
+		}
+	}
+}
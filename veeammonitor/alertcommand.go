@@ -0,0 +1,96 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Alert passed as JSON on stdin to OnAlertCommand
+type alertCommandPayload struct {
+	Server       string             `json:"server"`
+	Severity     string             `json:"severity"`
+	Timestamp    time.Time          `json:"timestamp"`
+	CycleID      string             `json:"cycleId,omitempty"`
+	Fingerprint  string             `json:"fingerprint,omitempty"`
+	Counts       map[string]int     `json:"counts"`
+	Jobs         []JobStatus        `json:"jobs"`
+	Repositories []RepositoryStatus `json:"repositories"`
+	Groups       []JobGroup         `json:"groups,omitempty"`
+}
+
+// Run the configured alert command with the alert as JSON on stdin and
+// VEEAM_* counts in its environment, logging its exit code and output
+func runAlertCommand(ctx context.Context, report *AlertReport, config *Config) error {
+	counts := map[string]int{
+		"failed":       report.Counts.Failed,
+		"warning":      report.Counts.Warning,
+		"stuck":        report.Counts.Stuck,
+		"disabled":     report.Counts.Disabled,
+		"missing":      report.Counts.Missing,
+		"running":      report.Counts.Running,
+		"stale":        report.Counts.Stale,
+		"deviation":    report.Counts.Deviation,
+		"drift":        report.Counts.Drift,
+		"repositories": report.Counts.Repositories,
+	}
+	payload, err := json.Marshal(alertCommandPayload{
+		Server:       report.Server,
+		Severity:     report.Severity,
+		Timestamp:    report.Timestamp,
+		CycleID:      report.CycleID,
+		Fingerprint:  report.Fingerprint,
+		Counts:       counts,
+		Jobs:         report.Jobs(),
+		Repositories: report.Repositories,
+		Groups:       report.Groups,
+	})
+	if err != nil {
+		return err
+	}
+
+	if timeout := config.commandTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, config.OnAlertCommand)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("VEEAM_SERVER=%s", report.Server),
+		fmt.Sprintf("VEEAM_SEVERITY=%s", report.Severity),
+		fmt.Sprintf("VEEAM_FAILED_COUNT=%d", counts["failed"]),
+		fmt.Sprintf("VEEAM_WARNING_COUNT=%d", counts["warning"]),
+		fmt.Sprintf("VEEAM_RUNNING_COUNT=%d", counts["running"]),
+		fmt.Sprintf("VEEAM_STALE_COUNT=%d", counts["stale"]),
+		fmt.Sprintf("VEEAM_DEVIATION_COUNT=%d", counts["deviation"]),
+		fmt.Sprintf("VEEAM_REPOSITORY_COUNT=%d", counts["repositories"]),
+	)
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+
+	if text := strings.TrimSpace(string(output)); text != "" {
+		log.Printf("Alert command output: %s\n", text)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("alert command timed out after %v", time.Since(start).Round(time.Second))
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("alert command exited with code %d", exitErr.ExitCode())
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package veeammonitor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write an executable shell script to a temporary directory
+func writeScript(t *testing.T, script string) (path, dir string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("alert command tests use a shell script")
+	}
+	dir = t.TempDir()
+	path = filepath.Join(dir, "alert.sh")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path, dir
+}
+
+func TestAlertCommandPayload(t *testing.T) {
+	path, dir := writeScript(t, `cd "$(dirname "$0")"
+cat > payload.json
+echo "$VEEAM_SEVERITY $VEEAM_FAILED_COUNT $VEEAM_WARNING_COUNT" > env
+`)
+	config := testConfig()
+	config.OnAlertCommand = path
+	jobs := []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: "Disk full"},
+		{Name: "Weekly", JobType: "Backup", Status: "Warning"},
+	}
+	repos := []RepositoryStatus{{Name: "Main", TotalBytes: 100, FreeBytes: 1}}
+	report := NewAlertReport(jobs, repos, severityCritical, config, time.Now())
+
+	if err := runAlertCommand(context.Background(), report, config); err != nil {
+		t.Fatalf("running the command: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "payload.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("payload isn't JSON: %v\n%s", err, data)
+	}
+	for _, key := range []string{"server", "severity", "timestamp", "counts", "jobs", "repositories"} {
+		if _, ok := payload[key]; !ok {
+			t.Errorf("payload has no %s: %s", key, data)
+		}
+	}
+	var decoded alertCommandPayload
+	json.Unmarshal(data, &decoded)
+	if decoded.Severity != severityCritical || decoded.Counts["failed"] != 1 || decoded.Counts["warning"] != 1 ||
+		decoded.Counts["repositories"] != 1 || len(decoded.Jobs) != 2 || decoded.Jobs[0].Name != "Nightly" {
+		t.Errorf("got payload %+v", decoded)
+	}
+
+	env, _ := ioutil.ReadFile(filepath.Join(dir, "env"))
+	if got := strings.TrimSpace(string(env)); got != "critical 1 1" {
+		t.Errorf("got environment %q, want the severity and counts", got)
+	}
+}
+
+func TestAlertCommandExitCode(t *testing.T) {
+	path, _ := writeScript(t, "cat > /dev/null\nexit 3\n")
+	config := testConfig()
+	config.OnAlertCommand = path
+	report := NewAlertReport(nil, nil, severityWarning, config, time.Now())
+
+	err := runAlertCommand(context.Background(), report, config)
+	if err == nil || err.Error() != "alert command exited with code 3" {
+		t.Errorf("got error %v, want the exit code", err)
+	}
+}
@@ -0,0 +1,177 @@
+package veeammonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/smithy-go"
+)
+
+// SNS limits on a message subject and body
+const (
+	snsMaxSubject = 100
+	snsMaxMessage = 256 * 1024
+)
+
+// SNS calls the monitor makes, implemented by *sns.Client
+type snsAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+	GetTopicAttributes(ctx context.Context, params *sns.GetTopicAttributesInput, optFns ...func(*sns.Options)) (*sns.GetTopicAttributesOutput, error)
+}
+
+// SES calls the monitor makes, implemented by *sesv2.Client
+type sesAPI interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+	GetAccount(ctx context.Context, params *sesv2.GetAccountInput, optFns ...func(*sesv2.Options)) (*sesv2.GetAccountOutput, error)
+}
+
+// Clients of the AWS services, replaced by fakes in tests
+var (
+	newSNSClient = func(cfg aws.Config) snsAPI { return sns.NewFromConfig(cfg) }
+	newSESClient = func(cfg aws.Config) sesAPI { return sesv2.NewFromConfig(cfg) }
+)
+
+// AWS SDK configs by region and HTTP client. Keeping them means credentials
+// are looked up once and then refreshed by the SDK as they expire, rather
+// than on every send.
+var awsConfigs = struct {
+	sync.Mutex
+	byClient map[awsConfigKey]aws.Config
+}{byClient: map[awsConfigKey]aws.Config{}}
+
+type awsConfigKey struct {
+	region     string
+	httpClient *http.Client
+}
+
+// SDK config for the configured region, sending requests through the proxy
+// and timeout of httpClient. Credentials come from the standard chain:
+// environment variables, the shared config and credentials files (with
+// AWS_PROFILE), then the ECS task or EC2 instance role.
+func loadAWSConfig(ctx context.Context, httpClient *http.Client, config *Config) (aws.Config, error) {
+	key := awsConfigKey{config.awsRegion(), httpClient}
+	awsConfigs.Lock()
+	defer awsConfigs.Unlock()
+	if cfg, ok := awsConfigs.byClient[key]; ok {
+		return cfg, nil
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(key.region), awsconfig.WithHTTPClient(awsHTTPClient(httpClient)))
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+	awsConfigs.byClient[key] = cfg
+	return cfg, nil
+}
+
+// The SDK's HTTP client with the proxy and timeout of httpClient. It has to
+// be the SDK's own so AWS_CA_BUNDLE can add its certificates.
+func awsHTTPClient(httpClient *http.Client) *awshttp.BuildableClient {
+	client := awshttp.NewBuildableClient().WithTimeout(httpClient.Timeout)
+	if transport, ok := httpClient.Transport.(*http.Transport); ok {
+		client = client.WithTransportOptions(func(t *http.Transport) { t.Proxy = transport.Proxy })
+	}
+	return client
+}
+
+// Region AWS requests go to: AWSRegion, the AWS_REGION or
+// AWS_DEFAULT_REGION environment variables, or the region of SNSTopicARN
+func (c *Config) awsRegion() string {
+	for _, region := range []string{c.AWSRegion, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if region != "" {
+			return region
+		}
+	}
+	if parts := strings.Split(c.SNSTopicARN, ":"); len(parts) == 6 {
+		return parts[3]
+	}
+	return ""
+}
+
+// Describe a failed AWS call by the service's error code and message, e.g.
+// "SNS Publish failed: AuthorizationError: Not allowed to publish"
+func awsError(service, action string, err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Errorf("%s %s failed: %s: %s", service, action, apiErr.ErrorCode(), apiErr.ErrorMessage())
+	}
+	return fmt.Errorf("%s %s failed: %v", service, action, err)
+}
+
+// Publishes alerts to SNSTopicARN
+type snsNotifier struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+func (n snsNotifier) Name() string { return "sns" }
+func (n snsNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	subject, message := buildEmailBody(report, n.config.forChannel("sns"))
+	return publishSNSMessage(ctx, n.httpClient, n.config, subject, message)
+}
+
+// Publish a message to SNSTopicARN. SNS rejects subjects over 100
+// characters or with line breaks, and messages over 256 KB, so both are cut.
+func publishSNSMessage(ctx context.Context, httpClient *http.Client, config *Config, subject, message string) error {
+	subject = truncateRunes(strings.Join(strings.Fields(subject), " "), snsMaxSubject)
+	if len(message) > snsMaxMessage {
+		cut := snsMaxMessage - 100
+		for cut > 0 && !utf8.RuneStart(message[cut]) {
+			cut--
+		}
+		message = message[:cut] + "\n\n... message truncated to fit SNS's size limit\n"
+	}
+
+	cfg, err := loadAWSConfig(ctx, httpClient, config)
+	if err != nil {
+		return err
+	}
+	_, err = newSNSClient(cfg).Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(config.SNSTopicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(message),
+	})
+	if err != nil {
+		return awsError("SNS", "Publish", err)
+	}
+	return nil
+}
+
+// Sends alert emails through Amazon SES in place of the SMTP email channel
+type sesNotifier struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+func (n sesNotifier) Name() string { return "ses" }
+func (n sesNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	return sendTemplatedEmailAlert(ctx, n.httpClient, report, n.config.forChannel("ses"), n.config.channelTemplate("ses"))
+}
+
+// Send a built email message with SES, from EmailFrom to the addresses in
+// its headers
+func sendSESEmail(ctx context.Context, httpClient *http.Client, config *Config, msg []byte) error {
+	cfg, err := loadAWSConfig(ctx, httpClient, config)
+	if err != nil {
+		return err
+	}
+	_, err = newSESClient(cfg).SendEmail(ctx, &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(config.EmailFrom),
+		Content:          &sestypes.EmailContent{Raw: &sestypes.RawMessage{Data: msg}},
+	})
+	if err != nil {
+		return awsError("SES", "SendEmail", err)
+	}
+	return nil
+}
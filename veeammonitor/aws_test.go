@@ -0,0 +1,242 @@
+package veeammonitor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/smithy-go"
+)
+
+// Fake SNS and SES clients recording the calls they get and answering them
+// with err
+type fakeAWS struct {
+	mu        sync.Mutex
+	err       error
+	configs   []aws.Config
+	publishes []*sns.PublishInput
+	emails    []*sesv2.SendEmailInput
+}
+
+// Install a fake for newSNSClient and newSESClient, with static
+// credentials in the environment
+func newFakeAWS(t *testing.T, err error) *fakeAWS {
+	fake := &fakeAWS{err: err}
+	snsClient, sesClient := newSNSClient, newSESClient
+	newSNSClient = func(cfg aws.Config) snsAPI { fake.record(cfg); return fake }
+	newSESClient = func(cfg aws.Config) sesAPI { fake.record(cfg); return fake }
+	t.Cleanup(func() { newSNSClient, newSESClient = snsClient, sesClient })
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	return fake
+}
+
+func (f *fakeAWS) record(cfg aws.Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.configs = append(f.configs, cfg)
+}
+
+func (f *fakeAWS) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.publishes = append(f.publishes, params)
+	return &sns.PublishOutput{}, f.err
+}
+
+func (f *fakeAWS) GetTopicAttributes(ctx context.Context, params *sns.GetTopicAttributesInput, optFns ...func(*sns.Options)) (*sns.GetTopicAttributesOutput, error) {
+	return &sns.GetTopicAttributesOutput{}, f.err
+}
+
+func (f *fakeAWS) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.emails = append(f.emails, params)
+	return &sesv2.SendEmailOutput{}, f.err
+}
+
+func (f *fakeAWS) GetAccount(ctx context.Context, params *sesv2.GetAccountInput, optFns ...func(*sesv2.Options)) (*sesv2.GetAccountOutput, error) {
+	return &sesv2.GetAccountOutput{}, f.err
+}
+
+func (f *fakeAWS) received() ([]*sns.PublishInput, []*sesv2.SendEmailInput) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*sns.PublishInput(nil), f.publishes...), append([]*sesv2.SendEmailInput(nil), f.emails...)
+}
+
+// Config publishing to an SNS topic in us-east-1
+func awsTestConfig() *Config {
+	config := testConfig()
+	config.SNSTopicARN = "arn:aws:sns:us-east-1:123456789012:veeam-alerts"
+	return config
+}
+
+func TestSNSNotifierPublishes(t *testing.T) {
+	fake := newFakeAWS(t, nil)
+	config := awsTestConfig()
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}, nil, severityCritical, config, time.Now())
+
+	if err := (snsNotifier{config, http.DefaultClient}).Notify(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	publishes, _ := fake.received()
+	if len(publishes) != 1 {
+		t.Fatalf("got %d publishes, want 1", len(publishes))
+	}
+	input := publishes[0]
+	if aws.ToString(input.TopicArn) != config.SNSTopicARN {
+		t.Errorf("published to %q", aws.ToString(input.TopicArn))
+	}
+	if subject := aws.ToString(input.Subject); strings.ContainsAny(subject, "\r\n") || len([]rune(subject)) > snsMaxSubject || subject == "" {
+		t.Errorf("got subject %q", subject)
+	}
+	if !strings.Contains(aws.ToString(input.Message), "Nightly") {
+		t.Errorf("message doesn't list the job:\n%s", aws.ToString(input.Message))
+	}
+}
+
+func TestPublishSNSMessageTruncates(t *testing.T) {
+	fake := newFakeAWS(t, nil)
+	config := awsTestConfig()
+	subject := "Veeam\r\n  alert " + strings.Repeat("é", 200)
+	message := strings.Repeat("ü", snsMaxMessage)
+
+	if err := publishSNSMessage(context.Background(), http.DefaultClient, config, subject, message); err != nil {
+		t.Fatal(err)
+	}
+	publishes, _ := fake.received()
+	got := aws.ToString(publishes[0].Subject)
+	if !strings.HasPrefix(got, "Veeam alert é") || len([]rune(got)) > snsMaxSubject {
+		t.Errorf("got subject %q", got)
+	}
+	body := aws.ToString(publishes[0].Message)
+	if len(body) > snsMaxMessage || !strings.HasSuffix(body, "... message truncated to fit SNS's size limit\n") || !strings.Contains(body, "ü\n") {
+		t.Errorf("got a %d byte message ending %q", len(body), body[len(body)-60:])
+	}
+}
+
+func TestSESSendsRawEmail(t *testing.T) {
+	fake := newFakeAWS(t, nil)
+	config := testConfig()
+	config.SESEnabled = true
+	config.AWSRegion = "eu-west-1"
+	config.EmailFrom = "veeam@example.com"
+	config.EmailTo = []string{"ops@example.com"}
+	m := newTestMonitor(config, nil)
+	if notifiers := m.notifiers(); len(notifiers) == 0 || notifiers[0].Name() != "ses" {
+		t.Fatalf("got notifiers %v, want SES in place of SMTP", notifiers)
+	}
+
+	if err := sendEmail(context.Background(), http.DefaultClient, config, "Backup failed", "Nightly failed\n"); err != nil {
+		t.Fatal(err)
+	}
+	_, emails := fake.received()
+	if len(emails) != 1 || aws.ToString(emails[0].FromEmailAddress) != "veeam@example.com" {
+		t.Fatalf("got emails %+v", emails)
+	}
+	if emails[0].Content == nil || emails[0].Content.Raw == nil {
+		t.Fatal("email wasn't sent as a raw message")
+	}
+	raw := string(emails[0].Content.Raw.Data)
+	for _, want := range []string{"To: ops@example.com", "Subject: Backup failed", "Nightly failed"} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("raw message doesn't contain %q:\n%s", want, raw)
+		}
+	}
+	if region := fake.configs[0].Region; region != "eu-west-1" {
+		t.Errorf("sent through region %q", region)
+	}
+}
+
+func TestLoadAWSConfig(t *testing.T) {
+	newFakeAWS(t, nil)
+	config := awsTestConfig()
+	config.AWSRegion = "eu-north-1"
+	config.ProxyURL = "http://proxy.example.com:3128"
+	httpClient := newHTTPClient(config)
+
+	cfg, err := loadAWSConfig(context.Background(), httpClient, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Region != "eu-north-1" {
+		t.Errorf("got region %q", cfg.Region)
+	}
+	client, ok := cfg.HTTPClient.(*awshttp.BuildableClient)
+	if !ok {
+		t.Fatalf("got HTTP client %T", cfg.HTTPClient)
+	}
+	proxy, err := client.GetTransport().Proxy(httptest.NewRequest(http.MethodPost, "https://sns.eu-north-1.amazonaws.com/", nil))
+	if err != nil || proxy == nil || proxy.Host != "proxy.example.com:3128" || client.GetTimeout() != httpClient.Timeout {
+		t.Errorf("got proxy %v and timeout %v, want the monitor's", proxy, client.GetTimeout())
+	}
+	credentials, err := cfg.Credentials.Retrieve(context.Background())
+	if err != nil || credentials.AccessKeyID != "AKIDTEST" {
+		t.Errorf("got credentials %q and error %v, want the environment's", credentials.AccessKeyID, err)
+	}
+}
+
+func TestAWSErrorResponse(t *testing.T) {
+	newFakeAWS(t, &smithy.GenericAPIError{Code: "AuthorizationError", Message: "Not allowed to publish"})
+	err := publishSNSMessage(context.Background(), http.DefaultClient, awsTestConfig(), "Subject", "Message")
+	if err == nil || err.Error() != "SNS Publish failed: AuthorizationError: Not allowed to publish" {
+		t.Errorf("got error %v", err)
+	}
+
+	newFakeAWS(t, errors.New("connection refused"))
+	err = publishSNSMessage(context.Background(), http.DefaultClient, awsTestConfig(), "Subject", "Message")
+	if err == nil || err.Error() != "SNS Publish failed: connection refused" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestAWSErrorDoesNotBlockOtherChannels(t *testing.T) {
+	fake := newFakeAWS(t, errors.New("status 500"))
+	m, capture := newCaptureMonitor(awsTestConfig(), statusRunner([]string{"Nightly"}, nil))
+	m.RunCheckCycle()
+
+	if publishes, _ := fake.received(); len(publishes) == 0 {
+		t.Error("nothing was published to SNS")
+	}
+	if sent := capture.sent(); len(sent) != 1 {
+		t.Errorf("sent %d reports to the other channel, want 1", len(sent))
+	}
+}
+
+func TestAWSRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	config := awsTestConfig()
+	if region := config.awsRegion(); region != "us-east-1" {
+		t.Errorf("got region %q from the topic ARN", region)
+	}
+	t.Setenv("AWS_DEFAULT_REGION", "eu-central-1")
+	if region := config.awsRegion(); region != "eu-central-1" {
+		t.Errorf("got region %q, want AWS_DEFAULT_REGION", region)
+	}
+	t.Setenv("AWS_REGION", "eu-west-2")
+	if region := config.awsRegion(); region != "eu-west-2" {
+		t.Errorf("got region %q, want AWS_REGION", region)
+	}
+	config.AWSRegion = "ap-south-1"
+	if region := config.awsRegion(); region != "ap-south-1" {
+		t.Errorf("got region %q, want awsRegion", region)
+	}
+
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	if region := testConfig().awsRegion(); region != "" {
+		t.Errorf("got region %q without any configured", region)
+	}
+}
@@ -0,0 +1,191 @@
+package veeammonitor
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Status of jobs whose latest session moved unusually little or much data
+const deviationStatus = "Deviation"
+
+// Sessions needed in a job's baseline before it is compared against
+const backupSizeMinBaseline = 3
+
+// BackupSize is the data moved by a job's latest session and the number of
+// restore points it has
+type BackupSize struct {
+	Name             string
+	JobType          string
+	Result           string // Result of the session
+	Session          string // Identifies the session, its end time
+	TransferredBytes int64
+	RestorePoints    int
+}
+
+// BackupSizeReporter is implemented by sources that can report backup sizes
+// for MonitorBackupSize
+type BackupSizeReporter interface {
+	BackupSizes() ([]BackupSize, error)
+}
+
+// A session recorded in a job's size baseline
+type sizeSample struct {
+	Session          string `json:"session"`
+	TransferredBytes int64  `json:"transferredBytes"`
+	RestorePoints    int    `json:"restorePoints"`
+}
+
+// Average transferred bytes and restore points of a baseline
+func baselineAverages(samples []sizeSample) (transferred, restorePoints float64) {
+	for _, sample := range samples {
+		transferred += float64(sample.TransferredBytes)
+		restorePoints += float64(sample.RestorePoints)
+	}
+	n := float64(len(samples))
+	return transferred / n, restorePoints / n
+}
+
+// Percentage by which value differs from average, 0 when there is no average
+func deviationPercent(value, average float64) float64 {
+	if average <= 0 {
+		return 0
+	}
+	return math.Abs(value-average) / average * 100
+}
+
+// Compare each job's newest session against the average of its previous
+// sessions, returning Deviation jobs for those differing by more than
+// BackupSizeDeviationPercent. Every new session is added to the baseline,
+// which keeps the last BackupSizeBaselineRuns sessions; jobs without enough
+// history yet are only recorded.
+func (m *Monitor) backupSizeDeviations(sizes []BackupSize) []JobStatus {
+	config, state := m.Config, &m.state
+	if state.SizeBaselines == nil {
+		state.SizeBaselines = map[string][]sizeSample{}
+	}
+	threshold := float64(config.BackupSizeDeviationPercent)
+
+	var deviations []JobStatus
+	building := 0
+	for _, size := range sizes {
+		// Failed sessions move little data and are alerted on already
+		if size.Result == "Failed" || size.Session == "" {
+			continue
+		}
+		key := jobIdentity(JobStatus{Name: size.Name, JobType: size.JobType})
+		baseline := state.SizeBaselines[key]
+		if len(baseline) > 0 && baseline[len(baseline)-1].Session == size.Session {
+			continue // Already compared
+		}
+
+		if len(baseline) < backupSizeMinBaseline {
+			building++
+		} else {
+			transferred, restorePoints := baselineAverages(baseline)
+			var reasons []string
+			if deviation := deviationPercent(float64(size.TransferredBytes), transferred); deviation > threshold {
+				reasons = append(reasons, fmt.Sprintf("transferred %s, %.0f%% %s the average of %s",
+					formatGB(size.TransferredBytes), deviation, aboveOrBelow(float64(size.TransferredBytes), transferred), formatGB(int64(transferred))))
+			}
+			if deviation := deviationPercent(float64(size.RestorePoints), restorePoints); deviation > threshold {
+				reasons = append(reasons, fmt.Sprintf("%d restore points, %.0f%% %s the average of %.1f",
+					size.RestorePoints, deviation, aboveOrBelow(float64(size.RestorePoints), restorePoints), restorePoints))
+			}
+			if len(reasons) > 0 {
+				deviations = append(deviations, JobStatus{
+					Name:    size.Name,
+					Status:  deviationStatus,
+					EndTime: config.displayTime(size.Session),
+					JobType: size.JobType,
+					Description: fmt.Sprintf("Last session %s over the last %d sessions",
+						strings.Join(reasons, "; "), len(baseline)),
+				})
+			}
+		}
+
+		baseline = append(baseline, sizeSample{
+			Session:          size.Session,
+			TransferredBytes: size.TransferredBytes,
+			RestorePoints:    size.RestorePoints,
+		})
+		if len(baseline) > config.BackupSizeBaselineRuns {
+			baseline = baseline[len(baseline)-config.BackupSizeBaselineRuns:]
+		}
+		state.SizeBaselines[key] = baseline
+	}
+
+	if building > 0 {
+		m.logf("Building the backup size baseline for %d jobs (%d sessions needed)\n", building, backupSizeMinBaseline)
+	}
+	return deviations
+}
+
+// Direction of a deviation for descriptions
+func aboveOrBelow(value, average float64) string {
+	if value < average {
+		return "below"
+	}
+	return "above"
+}
+
+// Get the latest session size and restore point count of every backup job
+func (m *Monitor) getBackupSizes() ([]BackupSize, error) {
+	query := fmt.Sprintf(`
+		Get-VBRJob | ForEach-Object {
+			$session = $_.FindLastSession()
+			if ($session -and $session.EndTime -gt $session.CreationTime) {
+				$backup = Get-VBRBackup -Name $_.Name -ErrorAction SilentlyContinue
+				$points = if ($backup) { @(Get-VBRRestorePoint -Backup $backup).Count } else { 0 }
+				[pscustomobject]@{Name=$_.Name; Result=$session.Result; Session=$session.EndTime.ToString("o"); TransferredBytes=$session.Progress.TransferedSize; RestorePoints=$points}
+			}
+		} | %s
+	`, convertToCsv(m.Config))
+
+	output, err := m.runVeeamScript(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute PowerShell command for backup sizes: %w", err)
+	}
+	return parseBackupSizeOutput(output, m.Config.csvDelimiter())
+}
+
+// Parse the backup size CSV output from PowerShell
+func parseBackupSizeOutput(output string, delimiter rune) ([]BackupSize, error) {
+	reader := csv.NewReader(strings.NewReader(cleanCSVOutput(output)))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, &ParseError{"backup size output", err}
+	}
+	if len(records) < 2 {
+		return []BackupSize{}, nil
+	}
+
+	var sizes []BackupSize
+	// Skip header line and process data lines
+	for _, record := range records[1:] {
+		if len(record) < 5 {
+			continue
+		}
+		transferred, err := strconv.ParseInt(strings.TrimSpace(record[3]), 10, 64)
+		if err != nil {
+			return nil, &ParseError{"backup size output", fmt.Errorf("invalid transferred size %q for job %s", record[3], record[0])}
+		}
+		restorePoints, err := strconv.Atoi(strings.TrimSpace(record[4]))
+		if err != nil {
+			return nil, &ParseError{"backup size output", fmt.Errorf("invalid restore point count %q for job %s", record[4], record[0])}
+		}
+		sizes = append(sizes, BackupSize{
+			Name:             record[0],
+			JobType:          jobTypeLabels["backup"],
+			Result:           record[1],
+			Session:          record[2],
+			TransferredBytes: transferred,
+			RestorePoints:    restorePoints,
+		})
+	}
+	return sizes, nil
+}
@@ -0,0 +1,118 @@
+package veeammonitor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+const backupSizeHeader = `"Name","Result","Session","TransferredBytes","RestorePoints"` + "\r\n"
+
+func TestParseBackupSizeOutput(t *testing.T) {
+	output := backupSizeHeader + `"Nightly","Success","2024-03-01T01:20:00.0000000+00:00","1048576","14"` + "\r\n"
+	sizes, err := parseBackupSizeOutput(output, ',')
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(sizes) != 1 {
+		t.Fatalf("got %d sizes, want 1", len(sizes))
+	}
+	if got := sizes[0]; got.Name != "Nightly" || got.TransferredBytes != 1048576 || got.RestorePoints != 14 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestParseBackupSizeOutputFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"malformed CSV", backupSizeHeader + `"Nightly","Success,"x","1","1"` + "\r\n"},
+		{"bad size", backupSizeHeader + `"Nightly","Success","x","lots","14"` + "\r\n"},
+		{"bad count", backupSizeHeader + `"Nightly","Success","x","1048576","some"` + "\r\n"},
+	}
+	for _, test := range tests {
+		_, err := parseBackupSizeOutput(test.output, ',')
+		if !errors.Is(err, ErrParseFailure) {
+			t.Errorf("%s: got error %v, want ErrParseFailure", test.name, err)
+		}
+	}
+}
+
+func TestDeviationPercent(t *testing.T) {
+	tests := []struct {
+		value, average, want float64
+	}{
+		{100, 100, 0},
+		{150, 100, 50},
+		{10, 100, 90},
+		{0, 100, 100},
+		{300, 100, 200},
+		{5, 0, 0},
+	}
+	for _, test := range tests {
+		if got := deviationPercent(test.value, test.average); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("deviationPercent(%v, %v) = %v, want %v", test.value, test.average, got, test.want)
+		}
+	}
+}
+
+func TestBackupSizeDeviations(t *testing.T) {
+	const gb = 1 << 30
+	config := testConfig()
+	config.BackupSizeDeviationPercent = 50
+	config.BackupSizeBaselineRuns = 4
+	m := newTestMonitor(config, nil)
+	seed := func(bytes int64, restorePoints int) []sizeSample {
+		var samples []sizeSample
+		for i := 1; i <= 4; i++ {
+			samples = append(samples, sizeSample{Session: fmt.Sprintf("2024-02-2%dT01:00:00", i), TransferredBytes: bytes, RestorePoints: restorePoints})
+		}
+		return samples
+	}
+	m.state.SizeBaselines = map[string][]sizeSample{
+		"backup/shrunk":    seed(10*gb, 14),
+		"backup/steady":    seed(10*gb, 14),
+		"backup/pruned":    seed(10*gb, 14),
+		"backup/failing":   seed(10*gb, 14),
+		"backup/two early": seed(10*gb, 14)[:2],
+	}
+
+	deviations := m.backupSizeDeviations([]BackupSize{
+		{Name: "Shrunk", JobType: "Backup", Result: "Success", Session: "2024-03-01T01:00:00", TransferredBytes: 1 * gb, RestorePoints: 14},
+		{Name: "Steady", JobType: "Backup", Result: "Success", Session: "2024-03-01T01:00:00", TransferredBytes: 14 * gb, RestorePoints: 14},
+		{Name: "Pruned", JobType: "Backup", Result: "Warning", Session: "2024-03-01T01:00:00", TransferredBytes: 10 * gb, RestorePoints: 3},
+		{Name: "Failing", JobType: "Backup", Result: "Failed", Session: "2024-03-01T01:00:00", TransferredBytes: 0, RestorePoints: 14},
+		{Name: "Two early", JobType: "Backup", Result: "Success", Session: "2024-03-01T01:00:00", TransferredBytes: 1, RestorePoints: 1},
+		{Name: "New", JobType: "Backup", Result: "Success", Session: "2024-03-01T01:00:00", TransferredBytes: 1, RestorePoints: 1},
+	})
+
+	if names := jobNames(deviations); !equalStrings(names, []string{"Shrunk", "Pruned"}) {
+		t.Fatalf("got deviations %v, want Shrunk and Pruned", names)
+	}
+	if !strings.Contains(deviations[0].Description, "90% below the average of 10.0 GB") || deviations[0].Status != deviationStatus {
+		t.Errorf("got %+v, want transferred 90%% below average", deviations[0])
+	}
+	if !strings.Contains(deviations[1].Description, "3 restore points, 79% below the average of 14.0") {
+		t.Errorf("got %+v, want restore points 79%% below average", deviations[1])
+	}
+
+	// Sessions join the baseline, which keeps the configured number of runs
+	baselines := m.state.SizeBaselines
+	if shrunk := baselines["backup/shrunk"]; len(shrunk) != 4 || shrunk[3].TransferredBytes != 1*gb {
+		t.Errorf("Shrunk's baseline is %+v, want the new session last of 4", shrunk)
+	}
+	if len(baselines["backup/failing"]) != 4 || baselines["backup/failing"][3].Session == "2024-03-01T01:00:00" {
+		t.Error("failed session was added to the baseline")
+	}
+	if len(baselines["backup/new"]) != 1 || len(baselines["backup/two early"]) != 3 {
+		t.Errorf("baselines being built have %d and %d sessions, want 1 and 3", len(baselines["backup/new"]), len(baselines["backup/two early"]))
+	}
+
+	// The same session isn't compared twice
+	if again := m.backupSizeDeviations([]BackupSize{{Name: "Shrunk", JobType: "Backup", Result: "Success", Session: "2024-03-01T01:00:00", TransferredBytes: 1 * gb, RestorePoints: 14}}); len(again) != 0 {
+		t.Errorf("got %+v for a session already compared", again)
+	}
+}
@@ -0,0 +1,97 @@
+package veeammonitor
+
+import (
+	"sort"
+	"time"
+)
+
+// States of a channel's circuit breaker
+const (
+	circuitClosed   = "closed"    // Alerts are sent
+	circuitOpen     = "open"      // Alerts are skipped until the cooldown ends
+	circuitHalfOpen = "half-open" // The next alert tests whether the channel has recovered
+)
+
+// Circuit breaker of one notification channel, served by /status
+type channelCircuit struct {
+	Channel   string     `json:"channel"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`            // Consecutive send failures
+	OpenUntil *time.Time `json:"openUntil,omitempty"` // End of the cooldown while open
+}
+
+// Report whether an alert may be sent through a channel now. An open
+// circuit whose cooldown has ended half-opens, letting one alert through to
+// test the channel.
+func (m *Monitor) allowChannel(channel string, now time.Time) bool {
+	if m.Config.CircuitBreakerFailures <= 0 {
+		return true
+	}
+	m.circuitMu.Lock()
+	defer m.circuitMu.Unlock()
+
+	circuit := m.circuits[channel]
+	if circuit == nil || circuit.State == circuitClosed {
+		return true
+	}
+	if circuit.State == circuitOpen {
+		if now.Before(*circuit.OpenUntil) {
+			m.logf("%s notifier circuit open, skipping it until %s\n", channel, formatNextCheck(circuit.OpenUntil.In(m.Config.location())))
+			return false
+		}
+		circuit.State = circuitHalfOpen
+		m.logf("%s notifier circuit half-open, testing whether it has recovered\n", channel)
+	}
+	return true
+}
+
+// Record the outcome of sending through a channel. CircuitBreakerFailures
+// consecutive failures, or a failed test while half-open, open the circuit
+// for CircuitBreakerCooldownMinutes; a success closes it.
+func (m *Monitor) recordChannelResult(channel string, err error, now time.Time) {
+	config := m.Config
+	if config.CircuitBreakerFailures <= 0 {
+		return
+	}
+	m.circuitMu.Lock()
+	defer m.circuitMu.Unlock()
+
+	if m.circuits == nil {
+		m.circuits = map[string]*channelCircuit{}
+	}
+	circuit := m.circuits[channel]
+	if circuit == nil {
+		circuit = &channelCircuit{Channel: channel, State: circuitClosed}
+		m.circuits[channel] = circuit
+	}
+
+	if err == nil {
+		if circuit.State != circuitClosed {
+			m.logf("%s notifier circuit closed, the channel has recovered\n", channel)
+		}
+		circuit.State, circuit.Failures, circuit.OpenUntil = circuitClosed, 0, nil
+		return
+	}
+
+	circuit.Failures++
+	if circuit.State == circuitHalfOpen || circuit.Failures >= config.CircuitBreakerFailures {
+		cooldown := time.Duration(config.CircuitBreakerCooldownMinutes) * time.Minute
+		until := now.Add(cooldown)
+		circuit.State, circuit.OpenUntil = circuitOpen, &until
+		m.logf("%s notifier circuit open after %d consecutive failures, skipping it for %d minutes\n",
+			channel, circuit.Failures, config.CircuitBreakerCooldownMinutes)
+	}
+}
+
+// Every channel's circuit breaker, sorted by channel
+func (m *Monitor) channelCircuits() []channelCircuit {
+	m.circuitMu.Lock()
+	defer m.circuitMu.Unlock()
+
+	circuits := []channelCircuit{}
+	for _, circuit := range m.circuits {
+		circuits = append(circuits, *circuit)
+	}
+	sort.Slice(circuits, func(i, j int) bool { return circuits[i].Channel < circuits[j].Channel })
+	return circuits
+}
@@ -0,0 +1,108 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Monitor sending alerts to a working capture channel and a failing slack one
+func newCircuitTestMonitor() (*Monitor, *captureNotifier, *captureNotifier) {
+	config := testConfig()
+	config.CircuitBreakerFailures = 2
+	config.CircuitBreakerCooldownMinutes = 30
+	m, working := newCaptureMonitor(config, nil)
+	failing := &captureNotifier{name: "slack", err: errors.New("webhook returned status 500")}
+	m.Notifiers = append(m.Notifiers, failing)
+	return m, working, failing
+}
+
+// Circuit breaker of a channel as served by /status
+func statusCircuit(t *testing.T, m *Monitor, channel string) channelCircuit {
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status cycleStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	for _, circuit := range status.Circuits {
+		if circuit.Channel == channel {
+			return circuit
+		}
+	}
+	return channelCircuit{}
+}
+
+// Let a channel's open circuit reach the end of its cooldown
+func endCircuitCooldown(m *Monitor, channel string) {
+	m.circuitMu.Lock()
+	past := time.Now().Add(-time.Second)
+	m.circuits[channel].OpenUntil = &past
+	m.circuitMu.Unlock()
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	m, working, failing := newCircuitTestMonitor()
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}, nil, severityCritical, m.Config, time.Now())
+
+	m.sendAlerts(report)
+	if circuit := statusCircuit(t, m, "slack"); circuit.State != circuitClosed || circuit.Failures != 1 {
+		t.Errorf("after one failure got circuit %+v, want it still closed", circuit)
+	}
+	m.sendAlerts(report)
+	circuit := statusCircuit(t, m, "slack")
+	if circuit.State != circuitOpen || circuit.Failures != 2 || circuit.OpenUntil == nil || circuit.OpenUntil.Sub(time.Now()) < 29*time.Minute {
+		t.Errorf("after two failures got circuit %+v, want it open for 30 minutes", circuit)
+	}
+
+	// Skipped during the cooldown, while the other channel still gets alerts
+	m.sendAlerts(report)
+	if len(failing.sent()) != 2 || len(working.sent()) != 3 {
+		t.Errorf("slack got %d alerts and capture %d, want slack skipped while open", len(failing.sent()), len(working.sent()))
+	}
+
+	// Half-open after the cooldown, and closed by a successful test
+	endCircuitCooldown(m, "slack")
+	failing.err = nil
+	m.sendAlerts(report)
+	if len(failing.sent()) != 3 {
+		t.Error("the half-open circuit didn't let an alert through")
+	}
+	if circuit := statusCircuit(t, m, "slack"); circuit.State != circuitClosed || circuit.Failures != 0 || circuit.OpenUntil != nil {
+		t.Errorf("after recovering got circuit %+v, want it closed", circuit)
+	}
+	if circuit := statusCircuit(t, m, "capture"); circuit.State != circuitClosed {
+		t.Errorf("got working channel circuit %+v", circuit)
+	}
+}
+
+func TestCircuitBreakerReopensOnFailedTest(t *testing.T) {
+	m, _, failing := newCircuitTestMonitor()
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}, nil, severityCritical, m.Config, time.Now())
+	m.sendAlerts(report)
+	m.sendAlerts(report)
+
+	endCircuitCooldown(m, "slack")
+	m.sendAlerts(report)
+	if len(failing.sent()) != 3 {
+		t.Fatal("the half-open circuit didn't let an alert through")
+	}
+	if circuit := statusCircuit(t, m, "slack"); circuit.State != circuitOpen || circuit.OpenUntil == nil || circuit.OpenUntil.Before(time.Now()) {
+		t.Errorf("after a failed test got circuit %+v, want it open again", circuit)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	m, _, failing := newCircuitTestMonitor()
+	m.Config.CircuitBreakerFailures = 0
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}, nil, severityCritical, m.Config, time.Now())
+	for i := 0; i < 5; i++ {
+		m.sendAlerts(report)
+	}
+	if len(failing.sent()) != 5 || len(m.channelCircuits()) != 0 {
+		t.Errorf("slack got %d of 5 alerts with circuits %+v, want no circuit breaking", len(failing.sent()), m.channelCircuits())
+	}
+}
@@ -0,0 +1,120 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Client a job belongs to: the ClientMapping entry matching its name, or
+// empty when it matches none
+func (c *Config) jobClient(name string) string {
+	patterns := make([]string, 0, len(c.ClientMapping))
+	for pattern := range c.ClientMapping {
+		patterns = append(patterns, pattern)
+	}
+	if pattern, ok := matchJobName(patterns, name); ok {
+		return strings.TrimSpace(c.ClientMapping[pattern])
+	}
+	return ""
+}
+
+// Sends a client's recipients alerts listing only that client's jobs
+type emailClientNotifier struct {
+	config     *Config
+	httpClient *http.Client
+	client     string
+	recipients []string
+}
+
+func (n emailClientNotifier) Name() string { return clientChannel(n.client) }
+
+// Notify expects the report routedReport made for the client's channel
+func (n emailClientNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	config := *n.config.forChannel(n.Name())
+	config.EmailTo = n.recipients
+	return sendTemplatedEmailAlert(ctx, n.httpClient, report, &config, config.channelTemplate(n.Name()))
+}
+
+// Channel name of a client's emails, for NotificationRouting
+func clientChannel(client string) string {
+	return "client:" + strings.ToLower(client)
+}
+
+// Client whose emails go to a channel, empty when it isn't a client's
+func (c *Config) channelClient(channel string) string {
+	for client := range c.ClientRecipients {
+		if clientChannel(client) == strings.ToLower(channel) {
+			return client
+		}
+	}
+	return ""
+}
+
+// The part of a report about a client's jobs, or nil when none of its jobs
+// are in it. Repositories are shared between clients and left out.
+func clientReport(report *AlertReport, config *Config, client string) *AlertReport {
+	var jobs []JobStatus
+	for _, job := range report.Jobs() {
+		if strings.EqualFold(config.jobClient(job.Name), client) {
+			jobs = append(jobs, job)
+		}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	severity := alertSeverity(config, jobs, nil)
+	if severity == severityNone {
+		return nil
+	}
+	filtered := NewAlertReport(jobs, nil, severity, config, report.Timestamp)
+	filtered.CycleID = report.CycleID
+	filtered.Client = client
+	return filtered
+}
+
+// A notifier for every client with recipients, sorted by client name
+func clientNotifiers(config *Config, httpClient *http.Client) []Notifier {
+	clients := make([]string, 0, len(config.ClientRecipients))
+	for client := range config.ClientRecipients {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+
+	notifiers := make([]Notifier, 0, len(clients))
+	for _, client := range clients {
+		notifiers = append(notifiers, emailClientNotifier{config, httpClient, client, config.ClientRecipients[client]})
+	}
+	return notifiers
+}
+
+// Check that every client with recipients has jobs mapped to it, and that
+// no client address also gets the full report through emailTo
+func validateClients(config *Config) error {
+	mapped := map[string]bool{}
+	for _, client := range config.ClientMapping {
+		mapped[strings.ToLower(strings.TrimSpace(client))] = true
+	}
+	everything := map[string]bool{}
+	for _, to := range config.EmailTo {
+		everything[strings.ToLower(strings.TrimSpace(to))] = true
+	}
+
+	for client, recipients := range config.ClientRecipients {
+		if len(recipients) == 0 {
+			return fmt.Errorf("clientRecipients %q needs at least one recipient", client)
+		}
+		if !mapped[strings.ToLower(strings.TrimSpace(client))] {
+			return fmt.Errorf("clientRecipients %q names a client no clientMapping entry maps jobs to", client)
+		}
+		for _, to := range recipients {
+			if everything[strings.ToLower(strings.TrimSpace(to))] {
+				return fmt.Errorf("clientRecipients %q address %s is also in emailTo and would see every client's jobs", client, to)
+			}
+		}
+	}
+	return nil
+}
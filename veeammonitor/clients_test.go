@@ -0,0 +1,112 @@
+package veeammonitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Config for an MSP monitoring the jobs of two clients next to its own
+func clientTestConfig(server string) *Config {
+	config := smtpTestConfig(server, "noc@msp.example")
+	config.ClientMapping = map[string]string{"ACME-*": "Acme", "GLX-*": "Globex"}
+	config.ClientRecipients = map[string][]string{
+		"Acme":   {"it@acme.example"},
+		"Globex": {"ops@globex.example", "cto@globex.example"},
+	}
+	return config
+}
+
+func TestJobClient(t *testing.T) {
+	config := clientTestConfig("localhost")
+	tests := map[string]string{
+		"ACME-SQL":  "Acme",
+		"acme-web":  "Acme",
+		"GLX-Files": "Globex",
+		"Internal":  "",
+	}
+	for name, want := range tests {
+		if got := config.jobClient(name); got != want {
+			t.Errorf("%s: got client %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestClientReport(t *testing.T) {
+	config := clientTestConfig("localhost")
+	jobs := []JobStatus{
+		{Name: "ACME-SQL", JobType: "Backup", Status: "Failed"},
+		{Name: "GLX-Files", JobType: "Backup", Status: "Warning"},
+		{Name: "Internal", JobType: "Backup", Status: "Failed"},
+	}
+	repos := []RepositoryStatus{{Name: "Shared", TotalBytes: 100, FreeBytes: 5}}
+	report := NewAlertReport(jobs, repos, severityCritical, config, time.Now())
+
+	acme := clientReport(report, config, "acme")
+	if acme == nil || acme.Client != "acme" || !equalStrings(jobNames(acme.Jobs()), []string{"ACME-SQL"}) || len(acme.Repositories) != 0 {
+		t.Errorf("got Acme report %+v, want only its failed job", acme)
+	}
+	globex := clientReport(report, config, "Globex")
+	if globex == nil || globex.Severity != severityWarning || !equalStrings(jobNames(globex.Jobs()), []string{"GLX-Files"}) {
+		t.Errorf("got Globex report %+v, want only its warning job", globex)
+	}
+	if other := clientReport(report, config, "Initech"); other != nil {
+		t.Errorf("got report %+v for a client without jobs", other)
+	}
+}
+
+func TestClientRecipientIsolation(t *testing.T) {
+	relay := newFakeSMTP(t)
+	config := clientTestConfig(relay.addr)
+	config.MonitorWarningJobs = true
+	m := newTestMonitor(config, statusRunner([]string{"ACME-SQL", "Internal"}, []string{"ACME-Web"}))
+	m.RunCheckCycle()
+
+	byRecipient := map[string]string{}
+	for _, delivery := range relay.delivered() {
+		for _, to := range delivery.to {
+			byRecipient[to] = delivery.data
+		}
+	}
+	if len(byRecipient) != 2 {
+		t.Fatalf("delivered to %d recipients, want the NOC and Acme only", len(byRecipient))
+	}
+	noc := byRecipient["noc@msp.example"]
+	for _, name := range []string{"ACME-SQL", "ACME-Web", "Internal"} {
+		if !strings.Contains(noc, name) {
+			t.Errorf("NOC email is missing %s:\n%s", name, noc)
+		}
+	}
+
+	acme := byRecipient["it@acme.example"]
+	if !strings.Contains(acme, "Hello Acme,") || !strings.Contains(acme, "ACME-SQL") || !strings.Contains(acme, "ACME-Web") || !strings.Contains(acme, " for Acme") {
+		t.Errorf("Acme email doesn't list its own jobs:\n%s", acme)
+	}
+	if strings.Contains(acme, "Internal") || strings.Contains(acme, "noc@msp.example") {
+		t.Errorf("Acme email shows another client's jobs or recipients:\n%s", acme)
+	}
+	if _, ok := byRecipient["ops@globex.example"]; ok {
+		t.Error("Globex was emailed with none of its jobs failing")
+	}
+}
+
+func TestValidateClients(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *Config)
+	}{
+		{"no recipients", func(config *Config) { config.ClientRecipients["Acme"] = nil }},
+		{"unmapped client", func(config *Config) { config.ClientRecipients["Initech"] = []string{"it@initech.example"} }},
+		{"client address in emailTo", func(config *Config) { config.EmailTo = append(config.EmailTo, "IT@acme.example") }},
+	}
+	if err := validateClients(clientTestConfig("localhost")); err != nil {
+		t.Fatalf("valid clients refused: %v", err)
+	}
+	for _, test := range tests {
+		config := clientTestConfig("localhost")
+		test.modify(config)
+		if err := validateClients(config); err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
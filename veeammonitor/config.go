@@ -0,0 +1,1067 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CurrentConfigVersion is the schema version written by -init-config and -migrate.
+// Version 1 is the original schema, which had no configVersion field.
+const CurrentConfigVersion = 2
+
+// ErrInvalidConfig is returned by LoadConfig when the config file parses but
+// holds a value that can't safely be defaulted
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ErrConfigNotFound is returned by LoadConfig when the config file doesn't
+// exist, in which case defaults and command-line parameters can be used
+var ErrConfigNotFound = errors.New("configuration file not found")
+
+// Config holds the configuration for the application. Fields tagged
+// secret:"true" are masked whenever the config is printed.
+type Config struct {
+	ConfigVersion int  `json:"configVersion" yaml:"configVersion"`
+	StrictConfig  bool `json:"strictConfig" yaml:"strictConfig"` // Refuse to start on any config problem instead of using defaults
+
+	VeeamPowerShellModule   string         `json:"veeamPowerShellModule" yaml:"veeamPowerShellModule"`
+	VeeamServerAddress      string         `json:"veeamServerAddress" yaml:"veeamServerAddress"`
+	VeeamUsername           string         `json:"veeamUsername" yaml:"veeamUsername"` // Connect to the Veeam server as this account instead of the monitor's own
+	VeeamPassword           string         `json:"veeamPassword" yaml:"veeamPassword" secret:"true"`
+	Servers                 []ServerConfig `json:"servers" yaml:"servers"` // Veeam servers monitored side by side, empty monitors only veeamServerAddress
+	CheckIntervalMinutes    int            `json:"checkIntervalMinutes" yaml:"checkIntervalMinutes"`
+	SMTPServer              string         `json:"smtpServer" yaml:"smtpServer"`
+	SMTPPort                int            `json:"smtpPort" yaml:"smtpPort"`                             // 0 picks 25, or 587 with smtpStartTLS and 465 with smtpTLS
+	SMTPTLS                 bool           `json:"smtpTLS" yaml:"smtpTLS"`                               // Implicit TLS from the first byte, as on port 465
+	SMTPStartTLS            bool           `json:"smtpStartTLS" yaml:"smtpStartTLS"`                     // Require STARTTLS rather than using it only when the server offers it
+	SMTPInsecureSkipVerify  bool           `json:"smtpInsecureSkipVerify" yaml:"smtpInsecureSkipVerify"` // Accept any SMTP server certificate
+	SMTPCAFile              string         `json:"smtpCAFile" yaml:"smtpCAFile"`                         // PEM certificates trusted for SMTP instead of the system's, e.g. an internal CA
+	EmailFrom               string         `json:"emailFrom" yaml:"emailFrom"`
+	EmailTo                 []string       `json:"emailTo" yaml:"emailTo"`
+	SendPerRecipient        bool           `json:"sendPerRecipient" yaml:"sendPerRecipient"` // Deliver to each recipient separately so one rejected address doesn't fail the rest
+	EmailPassword           string         `json:"emailPassword" yaml:"emailPassword" secret:"true"`
+	MonitorFailedJobs       bool           `json:"monitorFailedJobs" yaml:"monitorFailedJobs"`
+	MonitorWarningJobs      bool           `json:"monitorWarningJobs" yaml:"monitorWarningJobs"`
+	MonitorRunningJobs      bool           `json:"monitorRunningJobs" yaml:"monitorRunningJobs"`
+	LongRunningThreshold    int            `json:"longRunningThreshold" yaml:"longRunningThreshold"`       // In minutes
+	LongRunningGraceMinutes int            `json:"longRunningGraceMinutes" yaml:"longRunningGraceMinutes"` // Extra minutes past the threshold before a job is flagged
+	FatalErrorBehavior      string         `json:"fatalErrorBehavior" yaml:"fatalErrorBehavior"`           // retry, backoff or exit when PowerShell or the Veeam module isn't installed
+	StuckSessionMinutes     int            `json:"stuckSessionMinutes" yaml:"stuckSessionMinutes"`         // Running sessions without progress this long are Stuck, 0 disables
+	ScheduleDriftMinutes    int            `json:"scheduleDriftMinutes" yaml:"scheduleDriftMinutes"`       // Alert when a job's last run started this far from its scheduled time, 0 disables
+	MonitorJobTypes         []string       `json:"monitorJobTypes" yaml:"monitorJobTypes"`                 // backup, copy, tape, agent, surebackup
+	SureBackupSeverity      string         `json:"sureBackupSeverity" yaml:"sureBackupSeverity"`           // Severity of failed SureBackup verifications, whatever statusSeverityMap says for Failed
+
+	IncrementalQueries       bool `json:"incrementalQueries" yaml:"incrementalQueries"`             // Only query jobs whose last session ended since the previous check for failed and warning jobs
+	FullQueryIntervalMinutes int  `json:"fullQueryIntervalMinutes" yaml:"fullQueryIntervalMinutes"` // Query every job this often with incrementalQueries, to reconcile the cache
+
+	WarningIgnorePatterns []string `json:"warningIgnorePatterns" yaml:"warningIgnorePatterns"` // Regular expressions for benign warning descriptions
+
+	MaxDescriptionLength int `json:"maxDescriptionLength" yaml:"maxDescriptionLength"` // Characters of a job description shown in alerts, 0 for no limit
+
+	MonitorRepositories                 bool `json:"monitorRepositories" yaml:"monitorRepositories"`
+	RepositoryFreeSpaceThresholdPercent int  `json:"repositoryFreeSpaceThresholdPercent" yaml:"repositoryFreeSpaceThresholdPercent"`
+
+	PagerDutyRoutingKey string `json:"pagerDutyRoutingKey" yaml:"pagerDutyRoutingKey" secret:"true"` // Events API v2 integration key
+	PagerDutyRegion     string `json:"pagerDutyRegion" yaml:"pagerDutyRegion"`                       // Service region of the PagerDuty account, us or eu
+
+	OpsgenieAPIKey     string            `json:"opsgenieAPIKey" yaml:"opsgenieAPIKey" secret:"true"` // API integration key, empty disables Opsgenie
+	OpsgenieRegion     string            `json:"opsgenieRegion" yaml:"opsgenieRegion"`               // Region of the Opsgenie account, us or eu
+	OpsgeniePriorities map[string]string `json:"opsgeniePriorities" yaml:"opsgeniePriorities"`       // Alert priority (P1 to P5) by severity, critical or warning
+	DiscordWebhookURL  string            `json:"discordWebhookURL" yaml:"discordWebhookURL" secret:"true"`
+	SlackWebhookURL    string            `json:"slackWebhookURL" yaml:"slackWebhookURL" secret:"true"` // Incoming webhook URL, empty disables Slack
+	SlackChannel       string            `json:"slackChannel" yaml:"slackChannel"`                     // Channel posted to instead of the webhook's own, e.g. #backups
+	TeamsWebhookURL    string            `json:"teamsWebhookURL" yaml:"teamsWebhookURL" secret:"true"` // Teams incoming webhook or workflow URL, empty disables Teams
+	OnAlertCommand     string            `json:"onAlertCommand" yaml:"onAlertCommand"`                 // Executable run with the alert as JSON on stdin
+
+	Webhooks       []Webhook `json:"webhooks" yaml:"webhooks"`             // Endpoints alerts are posted to as JSON, each its own channel
+	WebhookRetries int       `json:"webhookRetries" yaml:"webhookRetries"` // Extra attempts of a webhook post or PagerDuty event that fails or gets 429 or 5xx
+
+	// Amazon SNS and SES delivery, authenticated by the standard AWS
+	// credential chain
+	AWSRegion   string `json:"awsRegion" yaml:"awsRegion"`     // Empty uses AWS_REGION, or the region of snsTopicArn
+	SNSTopicARN string `json:"snsTopicArn" yaml:"snsTopicArn"` // Topic alerts are published to, empty disables SNS
+	SESEnabled  bool   `json:"sesEnabled" yaml:"sesEnabled"`   // Send email through SES from emailFrom instead of smtpServer
+
+	CommandTimeoutSeconds int `json:"commandTimeoutSeconds" yaml:"commandTimeoutSeconds"` // Limit for PowerShell and alert commands
+
+	// How Veeam is queried: "powershell" through Transport, or the Veeam
+	// Backup & Replication "rest" API at RESTURL
+	Backend                string `json:"backend" yaml:"backend"`
+	RESTURL                string `json:"restURL" yaml:"restURL"` // Defaults to https://VeeamServerAddress:9419
+	RESTUsername           string `json:"restUsername" yaml:"restUsername"`
+	RESTPassword           string `json:"restPassword" yaml:"restPassword" secret:"true"`
+	RESTInsecureSkipVerify bool   `json:"restInsecureSkipVerify" yaml:"restInsecureSkipVerify"` // Accept the self-signed certificate Veeam installs
+	RESTAPIVersion         string `json:"restApiVersion" yaml:"restApiVersion"`                 // x-api-version, 1.1-rev0 (VBR 12) unless set
+
+	// Where job statuses come from with the powershell backend: "local"
+	// PowerShell, "winrm" PowerShell on WinRMHost or the "enterprisemanager"
+	// REST API
+	Transport               string `json:"transport" yaml:"transport"`
+	WinRMHost               string `json:"winrmHost" yaml:"winrmHost"`
+	WinRMPort               int    `json:"winrmPort" yaml:"winrmPort"` // Defaults to 5985, or 5986 with HTTPS
+	WinRMUsername           string `json:"winrmUsername" yaml:"winrmUsername"`
+	WinRMPassword           string `json:"winrmPassword" yaml:"winrmPassword" secret:"true"`
+	WinRMHTTPS              bool   `json:"winrmHTTPS" yaml:"winrmHTTPS"`
+	WinRMInsecureSkipVerify bool   `json:"winrmInsecureSkipVerify" yaml:"winrmInsecureSkipVerify"` // Accept self-signed WinRM certificates
+
+	EnterpriseManagerURL                string `json:"enterpriseManagerURL" yaml:"enterpriseManagerURL"` // e.g. https://em.example.com:9398
+	EnterpriseManagerUsername           string `json:"enterpriseManagerUsername" yaml:"enterpriseManagerUsername"`
+	EnterpriseManagerPassword           string `json:"enterpriseManagerPassword" yaml:"enterpriseManagerPassword" secret:"true"`
+	EnterpriseManagerInsecureSkipVerify bool   `json:"enterpriseManagerInsecureSkipVerify" yaml:"enterpriseManagerInsecureSkipVerify"` // Accept self-signed certificates
+
+	AlertMinFailedJobs  int `json:"alertMinFailedJobs" yaml:"alertMinFailedJobs"`   // Failed jobs needed before alerting or paging
+	AlertMinWarningJobs int `json:"alertMinWarningJobs" yaml:"alertMinWarningJobs"` // Warning jobs needed before alerting or paging
+
+	StatusSeverityMap map[string]string `json:"statusSeverityMap" yaml:"statusSeverityMap"` // Job status (or "Repository") to critical, warning or info
+
+	MaxJobAgeHours      int  `json:"maxJobAgeHours" yaml:"maxJobAgeHours"`           // Alert on jobs that haven't run for this long, 0 disables
+	IncludeDisabledJobs bool `json:"includeDisabledJobs" yaml:"includeDisabledJobs"` // Also check disabled jobs for staleness
+
+	CriticalJobs []string `json:"criticalJobs" yaml:"criticalJobs"` // Job names or wildcard patterns that must stay enabled
+	ExpectedJobs []string `json:"expectedJobs" yaml:"expectedJobs"` // Job names or wildcard patterns that must exist
+
+	// Alert when a backup job's latest session transfers unusually little or
+	// much data, or its restore point count changes sharply
+	MonitorBackupSize          bool `json:"monitorBackupSize" yaml:"monitorBackupSize"`
+	BackupSizeDeviationPercent int  `json:"backupSizeDeviationPercent" yaml:"backupSizeDeviationPercent"` // Difference from the baseline average that alerts
+	BackupSizeBaselineRuns     int  `json:"backupSizeBaselineRuns" yaml:"backupSizeBaselineRuns"`         // Sessions averaged into the baseline
+
+	// OAuth2 client credentials for XOAUTH2 SMTP authentication
+	OAuthTenantID     string `json:"oauthTenantID" yaml:"oauthTenantID"` // Microsoft 365 tenant, filling in oauthTokenURL and oauthScope
+	OAuthTokenURL     string `json:"oauthTokenURL" yaml:"oauthTokenURL"`
+	OAuthClientID     string `json:"oauthClientID" yaml:"oauthClientID"`
+	OAuthClientSecret string `json:"oauthClientSecret" yaml:"oauthClientSecret" secret:"true"`
+	OAuthScope        string `json:"oauthScope" yaml:"oauthScope"`
+
+	// Standby SMTP relay used when the primary can't be reached
+	SMTPServerFallback    string `json:"smtpServerFallback" yaml:"smtpServerFallback"`
+	SMTPPortFallback      int    `json:"smtpPortFallback" yaml:"smtpPortFallback"`                         // Defaults to smtpPort
+	EmailPasswordFallback string `json:"emailPasswordFallback" yaml:"emailPasswordFallback" secret:"true"` // Leave empty for an unauthenticated relay
+
+	EmailSubjectTemplate string `json:"emailSubjectTemplate" yaml:"emailSubjectTemplate"` // Go text/template for the alert subject
+	HTMLEmail            bool   `json:"htmlEmail" yaml:"htmlEmail"`                       // Send alert emails as HTML with the plain text as alternative
+	TemplatePath         string `json:"templatePath" yaml:"templatePath"`                 // Go html/template file for HTML alert emails, empty uses the built-in one
+	EmailLogoURL         string `json:"emailLogoURL" yaml:"emailLogoURL"`                 // Image shown at the top of HTML alert emails, e.g. a company logo
+
+	// Named Go text/templates rendering an AlertReport, used by the channels
+	// in ChannelTemplates and by EmailAudiences instead of the standard format
+	ReportTemplates  map[string]string `json:"reportTemplates" yaml:"reportTemplates"`
+	ChannelTemplates map[string]string `json:"channelTemplates" yaml:"channelTemplates"` // Template name for email or discord
+	EmailAudiences   []EmailAudience   `json:"emailAudiences" yaml:"emailAudiences"`     // Extra recipient groups, each its own email channel
+	ReportFormat     string            `json:"reportFormat" yaml:"reportFormat"`         // verbose for a block per job, compact for one line per job
+	ChannelFormats   map[string]string `json:"channelFormats" yaml:"channelFormats"`     // reportFormat overrides by channel name
+	AttachCSV        bool              `json:"attachCSV" yaml:"attachCSV"`               // Attach a CSV of problematic jobs to alert emails
+	MaxMessageBytes  int               `json:"maxMessageBytes" yaml:"maxMessageBytes"`   // Largest alert email or Discord alert, longer ones list only the worst jobs; 0 is unlimited
+
+	AggregateFailuresMinJobs int `json:"aggregateFailuresMinJobs" yaml:"aggregateFailuresMinJobs"` // Jobs sharing a failure reason that are listed as one cause, 0 lists every job
+
+	MaxNotificationsPerHour   int `json:"maxNotificationsPerHour" yaml:"maxNotificationsPerHour"`     // Limit across all channels per window, 0 disables
+	NotificationWindowMinutes int `json:"notificationWindowMinutes" yaml:"notificationWindowMinutes"` // Length of the rate limit window
+
+	CircuitBreakerFailures        int `json:"circuitBreakerFailures" yaml:"circuitBreakerFailures"`               // Consecutive send failures that stop alerts to a channel, 0 disables
+	CircuitBreakerCooldownMinutes int `json:"circuitBreakerCooldownMinutes" yaml:"circuitBreakerCooldownMinutes"` // How long a channel is skipped before it is tested again
+	NotificationTimeoutSeconds    int `json:"notificationTimeoutSeconds" yaml:"notificationTimeoutSeconds"`       // Deadline of each channel's send, 0 for none
+
+	DebounceSeconds int `json:"debounceSeconds" yaml:"debounceSeconds"` // Hold a new alert this long to combine it with problems found by a re-check, 0 disables
+
+	CooldownMinutes    int            `json:"cooldownMinutes" yaml:"cooldownMinutes"`       // Minimum time between repeat alerts for the same failure, 0 never repeats them
+	JobCooldownMinutes map[string]int `json:"jobCooldownMinutes" yaml:"jobCooldownMinutes"` // Per-job overrides keyed by job name or wildcard pattern
+	NotifyRecoveries   bool           `json:"notifyRecoveries" yaml:"notifyRecoveries"`     // Email when alerted jobs are back to normal
+
+	HistoryDatabase      string `json:"historyDatabase" yaml:"historyDatabase"`           // SQLite file every check's job results are recorded in, empty keeps no history
+	HistoryRetentionDays int    `json:"historyRetentionDays" yaml:"historyRetentionDays"` // Days of results kept in historyDatabase, 0 keeps them forever
+
+	GroupMapping map[string]string `json:"groupMapping" yaml:"groupMapping"` // Report group of the jobs matching each job name or wildcard pattern
+
+	ClientMapping    map[string]string   `json:"clientMapping" yaml:"clientMapping"`       // Client of the jobs matching each job name or wildcard pattern
+	ClientRecipients map[string][]string `json:"clientRecipients" yaml:"clientRecipients"` // Addresses of each client, sent alerts about only its own jobs
+
+	StateFile          string `json:"stateFile" yaml:"stateFile"`                   // Alert history kept across restarts, empty keeps it in memory only
+	StateRetentionDays int    `json:"stateRetentionDays" yaml:"stateRetentionDays"` // Forget jobs missing from Veeam for this long, 0 keeps them forever
+
+	HTTPListenAddress string `json:"httpListenAddress" yaml:"httpListenAddress"` // Address for the HTTP API, e.g. 127.0.0.1:8080, empty disables it
+	PauseFile         string `json:"pauseFile" yaml:"pauseFile"`                 // Notifications are paused while this file exists, empty disables it
+	StatusHistorySize int    `json:"statusHistorySize" yaml:"statusHistorySize"` // Recent checks kept in memory for /status and the dashboard
+
+	CronSchedule string `json:"cronSchedule" yaml:"cronSchedule"` // Cron expression for check times, overrides checkIntervalMinutes
+	Timezone     string `json:"timezone" yaml:"timezone"`         // IANA time zone for shown times and cronSchedule, empty uses the local zone
+
+	LogTimestampFormat string `json:"logTimestampFormat" yaml:"logTimestampFormat"` // Go time layout or rfc3339, rfc3339nano or iso8601 for log lines, empty for 2006/01/02 15:04:05
+	LogDedupSeconds    int    `json:"logDedupSeconds" yaml:"logDedupSeconds"`       // Collapse repeats of a log message, reporting their count within this many seconds, 0 disables
+
+	ReportJSONPath             string `json:"reportJSONPath" yaml:"reportJSONPath"`                         // File rewritten with each cycle's report, empty disables it
+	ReportHistoryDir           string `json:"reportHistoryDir" yaml:"reportHistoryDir"`                     // Directory archiving each cycle's report as gzip, empty disables it
+	ReportHistoryRetentionDays int    `json:"reportHistoryRetentionDays" yaml:"reportHistoryRetentionDays"` // Delete archived reports older than this, 0 keeps them forever
+	WeeklyReportDay            string `json:"weeklyReportDay" yaml:"weeklyReportDay"`                       // Day the weekly trend report is emailed, e.g. Monday, empty disables it
+	WeeklyReportTime           string `json:"weeklyReportTime" yaml:"weeklyReportTime"`                     // Time of day of the weekly report, HH:MM in timezone
+	DigestSchedule             string `json:"digestSchedule" yaml:"digestSchedule"`                         // Cron expression of when the digest of every job's sessions is emailed, empty disables it
+
+	HeartbeatURL             string `json:"heartbeatURL" yaml:"heartbeatURL" secret:"true"`           // Pinged after every successful check, e.g. a healthchecks.io check URL
+	ProxyURL                 string `json:"proxyURL" yaml:"proxyURL" secret:"true"`                   // Proxy for Discord, Slack, Teams, webhook, PagerDuty, heartbeat and OAuth requests, empty uses HTTPS_PROXY/HTTP_PROXY
+	WatchdogStalenessMinutes int    `json:"watchdogStalenessMinutes" yaml:"watchdogStalenessMinutes"` // Log an error when no check succeeds for this long, 0 for three check intervals, -1 disables
+
+	// Channels (email, discord, command, pagerduty) that receive each
+	// severity. Empty sends everything everywhere.
+	NotificationRouting map[string][]string `json:"notificationRouting" yaml:"notificationRouting"`
+
+	SuppressRetryPendingAlerts bool `json:"suppressRetryPendingAlerts" yaml:"suppressRetryPendingAlerts"` // Don't alert on failed jobs Veeam will automatically retry
+	SuppressInitialAlerts      bool `json:"suppressInitialAlerts" yaml:"suppressInitialAlerts"`           // Record the first check's problems after startup without alerting
+	IncludeNextRun             bool `json:"includeNextRun" yaml:"includeNextRun"`                         // Show each job's next scheduled run in alerts
+
+	CSVDelimiter string `json:"csvDelimiter" yaml:"csvDelimiter"` // Delimiter PowerShell writes query results with
+
+	EscalateAfterFailures int      `json:"escalateAfterFailures" yaml:"escalateAfterFailures"` // Consecutive failed checks before a job's severity is raised, 0 disables
+	EscalationChannels    []string `json:"escalationChannels" yaml:"escalationChannels"`       // Channels escalated jobs are also sent to, e.g. pagerduty
+
+	StatsDAddress string `json:"statsDAddress" yaml:"statsDAddress"` // host:port of a StatsD or DogStatsD agent, empty disables metrics
+	StatsDPrefix  string `json:"statsDPrefix" yaml:"statsDPrefix"`   // Prepended to every metric name
+	StatsDTags    bool   `json:"statsDTags" yaml:"statsDTags"`       // Add a DogStatsD server tag to every metric
+
+	serverName string // Name of the Servers entry this config was made for
+}
+
+// Minutes a job must have been running before it is flagged long-running:
+// LongRunningThreshold plus the grace period, so a job that started at a
+// check's edge isn't flagged over rounding or a few seconds of clock skew
+func (c *Config) longRunningMinutes() int {
+	return c.LongRunningThreshold + c.LongRunningGraceMinutes
+}
+
+// DefaultConfig returns the configuration used when no config file can be loaded
+func DefaultConfig() *Config {
+	return &Config{
+		ConfigVersion:         CurrentConfigVersion,
+		VeeamPowerShellModule: "Veeam.Backup.PowerShell",
+		CheckIntervalMinutes:  15,
+		HTMLEmail:             true,
+		MonitorFailedJobs:     true,
+		LongRunningThreshold:  120,
+		MonitorJobTypes:       []string{"backup"},
+		SureBackupSeverity:    severityCritical,
+		MaxDescriptionLength:  300,
+
+		FullQueryIntervalMinutes: 60,
+
+		RepositoryFreeSpaceThresholdPercent: 10,
+
+		BackupSizeDeviationPercent: 50,
+		BackupSizeBaselineRuns:     7,
+
+		AlertMinFailedJobs:  1,
+		AlertMinWarningJobs: 1,
+
+		StatusSeverityMap: defaultStatusSeverities(),
+
+		NotificationWindowMinutes:     60,
+		CircuitBreakerFailures:        5,
+		CircuitBreakerCooldownMinutes: 30,
+		NotificationTimeoutSeconds:    60,
+
+		WebhookRetries:  3,
+		PagerDutyRegion: "us",
+		OpsgenieRegion:  "us",
+
+		CooldownMinutes: 360,
+
+		HistoryRetentionDays: 90,
+
+		CommandTimeoutSeconds: 300,
+		Backend:               "powershell",
+		Transport:             "local",
+
+		StateFile:          "veeam-monitor-state.json",
+		StateRetentionDays: 30,
+
+		ReportHistoryRetentionDays: 90,
+
+		StatusHistorySize: 50,
+
+		CSVDelimiter: ",",
+
+		StatsDPrefix: "veeam_monitor",
+	}
+}
+
+// Key of StatusSeverityMap used for repositories low on free space
+const repositoryStatus = "Repository"
+
+// Severity of each status unless StatusSeverityMap says otherwise
+func defaultStatusSeverities() map[string]string {
+	return map[string]string{
+		"Failed":         severityCritical,
+		"Stale":          severityCritical, // A job that didn't run at all is at least as bad as one that failed
+		stuckStatus:      severityCritical, // A dead session never finishes or resolves on its own
+		disabledStatus:   severityCritical, // Only CriticalJobs are reported disabled
+		missingStatus:    severityCritical, // A deleted job silently stops being backed up
+		deviationStatus:  severityWarning,
+		driftStatus:      severityWarning,
+		"Warning":        severityWarning,
+		"Running":        severityWarning,
+		repositoryStatus: severityWarning,
+	}
+}
+
+// Severity assigned to a job status, or to repositories low on space
+func (c *Config) statusSeverity(status string) string {
+	for key, severity := range c.StatusSeverityMap {
+		if strings.EqualFold(key, status) {
+			return severity
+		}
+	}
+	return defaultStatusSeverities()[status]
+}
+
+// Number of jobs with a status needed before it raises an alert
+func (c *Config) alertMinimum(status string) int {
+	switch status {
+	case "Failed":
+		return c.AlertMinFailedJobs
+	case "Warning":
+		return c.AlertMinWarningJobs
+	}
+	return 1
+}
+
+// Job description as shown in alert messages, shortened to MaxDescriptionLength.
+// JSON output such as the alert command payload keeps the full text.
+func (c *Config) displayDescription(description string) string {
+	if c.MaxDescriptionLength <= 0 {
+		return description
+	}
+	return truncateRunes(description, c.MaxDescriptionLength)
+}
+
+// Delimiter of the PowerShell CSV output, a comma unless CSVDelimiter is set
+func (c *Config) csvDelimiter() rune {
+	delimiter, err := csvDelimiterRune(c.CSVDelimiter)
+	if err != nil {
+		return ','
+	}
+	return delimiter
+}
+
+// Check that a CSVDelimiter value is a single character usable as a delimiter
+func csvDelimiterRune(value string) (rune, error) {
+	if value == "" {
+		return ',', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' || runes[0] == utf8.RuneError {
+		return 0, fmt.Errorf("invalid csvDelimiter %q, it must be a single character other than a quote or line break", value)
+	}
+	return runes[0], nil
+}
+
+// A line showing a job's next run, formatted with format, when
+// IncludeNextRun is set and the source reported one
+func (c *Config) nextRunLine(job JobStatus, format string) string {
+	if !c.IncludeNextRun || job.NextRun == "" {
+		return ""
+	}
+	return fmt.Sprintf(format, job.NextRun)
+}
+
+// Maximum time an external command may run
+func (c *Config) commandTimeout() time.Duration {
+	return time.Duration(c.CommandTimeoutSeconds) * time.Second
+}
+
+// Events API endpoint of the PagerDuty account's region
+func (c *Config) pagerDutyEventsURL() string {
+	if eventsURL, ok := pagerDutyEventsURLs[c.PagerDutyRegion]; ok {
+		return eventsURL
+	}
+	return pagerDutyEventsURLs["us"]
+}
+
+// Alert API of the Opsgenie account's region
+func (c *Config) opsgenieAPIURL() string {
+	if apiURL, ok := opsgenieAPIURLs[c.OpsgenieRegion]; ok {
+		return apiURL
+	}
+	return opsgenieAPIURLs["us"]
+}
+
+// Deadline of the context each notifier is given
+func (c *Config) notificationTimeout() time.Duration {
+	return time.Duration(c.NotificationTimeoutSeconds) * time.Second
+}
+
+// LoadConfig loads configuration from a JSON file, or a YAML one when the
+// path ends in .yaml or .yml
+func LoadConfig(filePath string) (*Config, error) {
+	return loadConfig(filePath, false)
+}
+
+// LoadStrictConfig loads a config file like LoadConfig, but treats every
+// problem as fatal: a missing or unreadable file and any value LoadConfig
+// would warn about and replace with a default all return ErrInvalidConfig.
+// StrictConfig in the file does the same for LoadConfig.
+func LoadStrictConfig(filePath string) (*Config, error) {
+	return loadConfig(filePath, true)
+}
+
+func loadConfig(filePath string, strict bool) (*Config, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		if strict {
+			return nil, fmt.Errorf("%w: config file %s does not exist", ErrInvalidConfig, filePath)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, filePath)
+	}
+	if err != nil {
+		if strict {
+			return nil, fmt.Errorf("%w: error reading config file: %v", ErrInvalidConfig, err)
+		}
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	// A file that doesn't parse is never replaced by defaults, which would
+	// hide a typo and leave the monitor running without alerting anyone.
+	// The keys present are recorded so absent fields can be told apart from
+	// fields explicitly set to zero.
+	var config Config
+	var present map[string]bool
+	if isYAMLFile(filePath) {
+		present, err = decodeYAMLConfig(data, &config)
+	} else {
+		data = stripJSONComments(data)
+		present, err = decodeJSONConfig(data, &config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: error parsing %s config file %s: %v", ErrInvalidConfig, configFormat(filePath), filePath, err)
+	}
+	strict = strict || config.StrictConfig
+
+	// Values replaced by defaults are logged, and fatal in strict mode
+	var problems []string
+	warn := func(format string, args ...interface{}) {
+		problem := fmt.Sprintf(format, args...)
+		log.Printf("Warning: %s\n", problem)
+		problems = append(problems, problem)
+	}
+
+	migrateConfig(&config, present)
+
+	// Misspelled keys would otherwise leave their setting at its default
+	// without a word. Nested objects are checked by decoding again with
+	// unknown fields disallowed, which stops at the first one.
+	unknown := unknownConfigKeys(present)
+	for _, key := range unknown {
+		warn("Unknown config field %q is ignored%s", key, configKeySuggestion(key))
+	}
+	if len(unknown) == 0 {
+		if isYAMLFile(filePath) {
+			if err := unknownYAMLField(data); err != nil {
+				warn("Config has an unknown field at %v, which is ignored", err)
+			}
+		} else {
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&Config{}); err != nil {
+				warn("Config has an %s, which is ignored", strings.TrimPrefix(err.Error(), "json: "))
+			}
+		}
+	}
+
+	// Set defaults for any missing values
+	if config.SMTPTLS && config.SMTPStartTLS {
+		return nil, fmt.Errorf("%w: smtpTLS and smtpStartTLS can't both be set, use smtpTLS for implicit TLS (port 465) or smtpStartTLS for STARTTLS (port 587)", ErrInvalidConfig)
+	}
+	if config.SMTPPort < 0 || config.SMTPPort > 65535 {
+		warn("SMTP port %d is not valid, using the default of %d", config.SMTPPort, config.defaultSMTPPort())
+		config.SMTPPort = 0
+	} else if config.SMTPPort == 0 && config.SMTPServer != "" {
+		log.Printf("smtpPort is not set, using %d for SMTP %s\n", config.defaultSMTPPort(), config.smtpEncryption())
+	}
+	if config.SMTPCAFile != "" {
+		if _, err := smtpTLSConfig(&config, ""); err != nil {
+			return nil, fmt.Errorf("%w: smtpCAFile: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	if config.CheckIntervalMinutes < 1 {
+		warn("Check interval is less than 1 minute, setting to default of 15 minutes")
+		config.CheckIntervalMinutes = 15
+	}
+
+	if config.DebounceSeconds < 0 {
+		config.DebounceSeconds = 0
+	}
+	if config.DebounceSeconds > config.CheckIntervalMinutes*60 {
+		warn("debounceSeconds %d is longer than the check interval, using %d seconds",
+			config.DebounceSeconds, config.CheckIntervalMinutes*60)
+		config.DebounceSeconds = config.CheckIntervalMinutes * 60
+	}
+
+	// A bad schedule would silently change when checks run, so refuse it
+	if config.CronSchedule != "" {
+		if _, err := parseCronSchedule(config.CronSchedule); err != nil {
+			return nil, fmt.Errorf("%w: cronSchedule %q: %v", ErrInvalidConfig, config.CronSchedule, err)
+		}
+	}
+
+	config.ReportFormat = strings.ToLower(strings.TrimSpace(config.ReportFormat))
+	switch config.ReportFormat {
+	case "":
+		config.ReportFormat = reportFormatVerbose
+	case reportFormatVerbose, reportFormatCompact:
+	default:
+		warn("Unknown reportFormat %q, using verbose", config.ReportFormat)
+		config.ReportFormat = reportFormatVerbose
+	}
+	for channel, format := range config.ChannelFormats {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format != reportFormatVerbose && format != reportFormatCompact {
+			return nil, fmt.Errorf("%w: channelFormats %q: unknown format %q, use verbose or compact", ErrInvalidConfig, channel, format)
+		}
+		config.ChannelFormats[channel] = format
+	}
+
+	if config.OAuthTenantID != "" {
+		if config.OAuthTokenURL == "" {
+			config.OAuthTokenURL = microsoftTokenURL(config.OAuthTenantID)
+		}
+		if config.OAuthScope == "" {
+			config.OAuthScope = microsoftSMTPScope
+		}
+	}
+	if config.OAuthTokenURL != "" && (config.OAuthClientID == "" || config.OAuthClientSecret == "") {
+		return nil, fmt.Errorf("%w: OAuth2 SMTP authentication needs oauthClientID and oauthClientSecret", ErrInvalidConfig)
+	}
+	if err := validateReportTemplates(&config); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if config.HTMLEmail && config.TemplatePath != "" {
+		if _, err := renderHTMLEmail(sampleTemplateReport(&config), &config, "Sample"); err != nil {
+			return nil, fmt.Errorf("%w: templatePath %s: %v", ErrInvalidConfig, config.TemplatePath, err)
+		}
+	}
+	if err := validateClients(&config); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	if (config.SNSTopicARN != "" || config.SESEnabled) && config.awsRegion() == "" {
+		return nil, fmt.Errorf("%w: snsTopicArn and sesEnabled need awsRegion or the AWS_REGION environment variable", ErrInvalidConfig)
+	}
+	if config.SNSTopicARN != "" && !strings.HasPrefix(config.SNSTopicARN, "arn:") {
+		return nil, fmt.Errorf("%w: snsTopicArn %q is not an ARN such as arn:aws:sns:us-east-1:123456789012:veeam-alerts", ErrInvalidConfig, config.SNSTopicARN)
+	}
+
+	if config.ProxyURL != "" {
+		if _, err := parseProxyURL(config.ProxyURL); err != nil {
+			return nil, fmt.Errorf("%w: proxyURL: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	if config.Timezone != "" {
+		if _, err := time.LoadLocation(config.Timezone); err != nil {
+			return nil, fmt.Errorf("%w: timezone %q is not an IANA time zone name such as America/Chicago", ErrInvalidConfig, config.Timezone)
+		}
+	}
+
+	if !config.MonitorFailedJobs && !config.MonitorWarningJobs && !config.MonitorRunningJobs {
+		warn("No monitoring options enabled, enabling failed job monitoring by default")
+		config.MonitorFailedJobs = true
+	}
+
+	if config.LongRunningThreshold < 1 {
+		config.LongRunningThreshold = 120 // Default to 2 hours
+		warn("Long running threshold not set, defaulting to 120 minutes")
+	}
+	if config.LogDedupSeconds < 0 {
+		warn("logDedupSeconds is negative, not collapsing repeated log messages")
+		config.LogDedupSeconds = 0
+	}
+	if config.LongRunningGraceMinutes < 0 {
+		warn("Long running grace period is negative, using no grace period")
+		config.LongRunningGraceMinutes = 0
+	}
+	config.FatalErrorBehavior = strings.ToLower(strings.TrimSpace(config.FatalErrorBehavior))
+	switch config.FatalErrorBehavior {
+	case "":
+		config.FatalErrorBehavior = "retry"
+	case "retry", "backoff", "exit":
+	default:
+		warn("Unknown fatalErrorBehavior %q, using retry", config.FatalErrorBehavior)
+		config.FatalErrorBehavior = "retry"
+	}
+	if config.StuckSessionMinutes < 0 {
+		config.StuckSessionMinutes = 0
+	}
+	if config.ScheduleDriftMinutes < 0 {
+		config.ScheduleDriftMinutes = 0
+	}
+
+	// Only keep job types we know how to query
+	var jobTypes []string
+	for _, jobType := range config.MonitorJobTypes {
+		jobType = strings.ToLower(strings.TrimSpace(jobType))
+		if _, ok := jobTypeSources[jobType]; !ok {
+			warn("Unknown job type %q in monitorJobTypes, ignoring it", jobType)
+			continue
+		}
+		jobTypes = append(jobTypes, jobType)
+	}
+	if len(jobTypes) == 0 {
+		jobTypes = []string{"backup"}
+	}
+	config.MonitorJobTypes = jobTypes
+
+	config.Backend = strings.ToLower(strings.TrimSpace(config.Backend))
+	switch config.Backend {
+	case "", "powershell":
+		config.Backend = "powershell"
+	case "rest":
+		// Servers are checked one by one below, as each may have its own address and credentials
+		if len(config.Servers) > 0 {
+			break
+		}
+		if config.RESTURL == "" && config.VeeamServerAddress == "" {
+			warn("Backend is rest but neither restURL nor veeamServerAddress is set, using PowerShell")
+			config.Backend = "powershell"
+		} else if config.RESTUsername == "" {
+			warn("Backend is rest but restUsername is not set, using PowerShell")
+			config.Backend = "powershell"
+		} else if config.RESTURL != "" {
+			if u, err := url.Parse(config.RESTURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				warn("restURL %q is not an https:// URL, using PowerShell", config.RESTURL)
+				config.Backend = "powershell"
+			}
+		}
+	default:
+		warn("Unknown backend %q, using PowerShell", config.Backend)
+		config.Backend = "powershell"
+	}
+
+	if err := validateServers(config.Servers); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if err := validateWebhooks(config.Webhooks); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	for _, server := range config.serverConfigs() {
+		switch server.Backend {
+		case "", "powershell":
+		case "rest":
+			if server.RESTUsername == "" || (server.RESTURL == "" && server.VeeamServerAddress == "") {
+				warn("Server %s uses the rest backend but has no restUsername, or no restURL or veeamServerAddress", server.serverName)
+			}
+		default:
+			warn("Server %s has unknown backend %q, using PowerShell", server.serverName, server.Backend)
+		}
+	}
+
+	config.Transport = strings.ToLower(strings.TrimSpace(config.Transport))
+	switch config.Transport {
+	case "local":
+	case "winrm":
+		if config.WinRMHost == "" {
+			warn("Transport is winrm but winrmHost is not set, running PowerShell locally")
+			config.Transport = "local"
+		}
+	case "enterprisemanager":
+		if config.EnterpriseManagerURL == "" {
+			warn("Transport is enterprisemanager but enterpriseManagerURL is not set, running PowerShell locally")
+			config.Transport = "local"
+		}
+	default:
+		warn("Unknown transport %q, running PowerShell locally", config.Transport)
+		config.Transport = "local"
+	}
+
+	for _, pattern := range config.WarningIgnorePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			warn("Invalid warningIgnorePatterns entry %q will be skipped: %v", pattern, err)
+		}
+	}
+
+	if _, err := csvDelimiterRune(config.CSVDelimiter); err != nil {
+		warn("%v, using a comma", err)
+		config.CSVDelimiter = ","
+	}
+
+	if config.MaxDescriptionLength < 0 {
+		config.MaxDescriptionLength = 0
+	}
+
+	if config.CommandTimeoutSeconds < 0 {
+		config.CommandTimeoutSeconds = 0
+	}
+
+	if config.StatusHistorySize < 1 {
+		config.StatusHistorySize = 50
+	}
+
+	if config.ReportHistoryRetentionDays < 0 {
+		config.ReportHistoryRetentionDays = 0
+	}
+	if config.WeeklyReportDay != "" {
+		if _, err := parseWeekday(config.WeeklyReportDay); err != nil {
+			return nil, fmt.Errorf("%w: weeklyReportDay: %v", ErrInvalidConfig, err)
+		}
+		if config.WeeklyReportTime == "" {
+			config.WeeklyReportTime = "08:00"
+		}
+		if _, err := time.Parse("15:04", config.WeeklyReportTime); err != nil {
+			return nil, fmt.Errorf("%w: weeklyReportTime %q is not a time of day such as 08:00", ErrInvalidConfig, config.WeeklyReportTime)
+		}
+		if config.ReportHistoryDir == "" {
+			return nil, fmt.Errorf("%w: weeklyReportDay needs reportHistoryDir, the weekly report is built from the archived reports", ErrInvalidConfig)
+		}
+		if config.ReportHistoryRetentionDays > 0 && config.ReportHistoryRetentionDays < 14 {
+			warn("reportHistoryRetentionDays %d keeps less than two weeks of reports, the weekly report can't compare with the previous week", config.ReportHistoryRetentionDays)
+		}
+	}
+
+	if config.DigestSchedule != "" {
+		if _, err := parseCronSchedule(config.DigestSchedule); err != nil {
+			return nil, fmt.Errorf("%w: digestSchedule %q: %v", ErrInvalidConfig, config.DigestSchedule, err)
+		}
+	}
+
+	if config.EscalateAfterFailures < 0 {
+		config.EscalateAfterFailures = 0
+	}
+	for i, channel := range config.EscalationChannels {
+		config.EscalationChannels[i] = strings.ToLower(strings.TrimSpace(channel))
+	}
+
+	if config.CooldownMinutes < 0 {
+		config.CooldownMinutes = 0
+	}
+
+	if config.NotificationWindowMinutes < 1 {
+		config.NotificationWindowMinutes = 60
+	}
+	if config.CircuitBreakerFailures < 0 {
+		config.CircuitBreakerFailures = 0
+	}
+	if config.CircuitBreakerCooldownMinutes < 1 {
+		config.CircuitBreakerCooldownMinutes = 30
+	}
+	if config.HistoryRetentionDays < 0 {
+		config.HistoryRetentionDays = 0
+	}
+	if config.NotificationTimeoutSeconds < 0 {
+		config.NotificationTimeoutSeconds = 0
+	}
+	if config.WebhookRetries < 0 {
+		config.WebhookRetries = 0
+	}
+	config.PagerDutyRegion = strings.ToLower(strings.TrimSpace(config.PagerDutyRegion))
+	if _, ok := pagerDutyEventsURLs[config.PagerDutyRegion]; !ok {
+		warn("Unknown pagerDutyRegion %q, using us", config.PagerDutyRegion)
+		config.PagerDutyRegion = "us"
+	}
+	config.OpsgenieRegion = strings.ToLower(strings.TrimSpace(config.OpsgenieRegion))
+	if _, ok := opsgenieAPIURLs[config.OpsgenieRegion]; !ok {
+		warn("Unknown opsgenieRegion %q, using us", config.OpsgenieRegion)
+		config.OpsgenieRegion = "us"
+	}
+	// Unknown severities and priorities fall back to the default priority
+	if len(config.OpsgeniePriorities) > 0 {
+		priorities := map[string]string{}
+		for severity, priority := range config.OpsgeniePriorities {
+			severity = strings.ToLower(strings.TrimSpace(severity))
+			priority = strings.ToUpper(strings.TrimSpace(priority))
+			switch {
+			case severity != severityCritical && severity != severityWarning:
+				warn("Unknown severity %q in opsgeniePriorities, ignoring it", severity)
+			case len(priority) != 2 || priority[0] != 'P' || priority[1] < '1' || priority[1] > '5':
+				warn("Unknown priority %q for %s in opsgeniePriorities, using %s", priority, severity, defaultOpsgeniePriorities()[severity])
+			default:
+				priorities[severity] = priority
+			}
+		}
+		config.OpsgeniePriorities = priorities
+	}
+
+	// Unknown severities fall back to the default for the status
+	for status, severity := range config.StatusSeverityMap {
+		severity = strings.ToLower(strings.TrimSpace(severity))
+		switch severity {
+		case severityCritical, severityWarning, severityInfo:
+			config.StatusSeverityMap[status] = severity
+		default:
+			warn("Unknown severity %q for status %s in statusSeverityMap, using the default", severity, status)
+			delete(config.StatusSeverityMap, status)
+		}
+	}
+
+	config.SureBackupSeverity = strings.ToLower(strings.TrimSpace(config.SureBackupSeverity))
+	switch config.SureBackupSeverity {
+	case severityCritical, severityWarning, severityInfo:
+	default:
+		warn("Unknown sureBackupSeverity %q, using critical", config.SureBackupSeverity)
+		config.SureBackupSeverity = severityCritical
+	}
+
+	// Match severities and channel names case-insensitively
+	if len(config.NotificationRouting) > 0 {
+		routing := map[string][]string{}
+		for severity, channels := range config.NotificationRouting {
+			severity = strings.ToLower(strings.TrimSpace(severity))
+			switch severity {
+			case severityCritical, severityWarning, severityInfo:
+			default:
+				warn("Unknown severity %q in notificationRouting, ignoring it", severity)
+				continue
+			}
+			for _, channel := range channels {
+				routing[severity] = append(routing[severity], strings.ToLower(strings.TrimSpace(channel)))
+			}
+		}
+		config.NotificationRouting = routing
+	}
+
+	if config.AlertMinFailedJobs < 1 {
+		config.AlertMinFailedJobs = 1
+	}
+	if config.AlertMinWarningJobs < 1 {
+		config.AlertMinWarningJobs = 1
+	}
+
+	if config.MonitorBackupSize && config.BackupSizeDeviationPercent < 1 {
+		warn("Backup size deviation percent not set, defaulting to 50 percent")
+		config.BackupSizeDeviationPercent = 50
+	}
+	if config.BackupSizeBaselineRuns < backupSizeMinBaseline {
+		config.BackupSizeBaselineRuns = 7
+	}
+
+	if config.MonitorRepositories && (config.RepositoryFreeSpaceThresholdPercent < 1 || config.RepositoryFreeSpaceThresholdPercent > 100) {
+		warn("Repository free space threshold not set or out of range, defaulting to 10 percent")
+		config.RepositoryFreeSpaceThresholdPercent = 10
+	}
+
+	if strict && len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s (strictConfig is set)", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+
+	return &config, nil
+}
+
+// Decode a JSON config into config, returning the top-level keys it sets.
+// Errors get the line and column they occurred at.
+func decodeJSONConfig(data []byte, config *Config) (map[string]bool, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, errors.New(jsonErrorPosition(data, err))
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, errors.New(jsonErrorPosition(data, err))
+	}
+	present := map[string]bool{}
+	for key := range keys {
+		present[key] = true
+	}
+	return present, nil
+}
+
+// Describe a JSON decoding error with the line and column it occurred at
+func jsonErrorPosition(data []byte, err error) string {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err.Error()
+	}
+	if offset < 1 {
+		return err.Error()
+	}
+
+	// The offset is just past the byte the error was found at
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line, column := 1, 1
+	for _, c := range data[:offset-1] {
+		if c == '\n' {
+			line, column = line+1, 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Sprintf("line %d, column %d: %v", line, column, err)
+}
+
+// Fields with no usable zero value, keyed by the config version that
+// introduced them. Files older than that version get their defaults when they
+// leave them out, and so do files of that version, as fields kept being added
+// to it after the first files were written with it. Every other missing field
+// keeps its zero value, so an older file doesn't gain a state file, cooldowns
+// or HTML email it never asked for, and an empty veeamPowerShellModule still
+// uses the detected module.
+var configFieldsAddedIn = map[int][]string{
+	2: {
+		"commandTimeoutSeconds",
+		"transport",
+		"statsDPrefix",
+		"fullQueryIntervalMinutes",
+		"sureBackupSeverity",
+		"notificationTimeoutSeconds",
+		"pagerDutyRegion",
+		"opsgenieRegion",
+	},
+}
+
+// Fill defaults for the fields added in or since the file's configVersion
+// that it doesn't set, naming them in a single warning. Fields present in the
+// file are left alone, even when set to zero, and are checked by the
+// validation in LoadConfig.
+func migrateConfig(config *Config, present map[string]bool) {
+	if !present["configVersion"] {
+		config.ConfigVersion = 1
+	}
+	if config.ConfigVersion < CurrentConfigVersion {
+		log.Printf("Config file is version %d, the current version is %d. Run with -migrate to update it.\n",
+			config.ConfigVersion, CurrentConfigVersion)
+	} else if config.ConfigVersion > CurrentConfigVersion {
+		log.Printf("Warning: Config file version %d is newer than this monitor supports (%d)\n",
+			config.ConfigVersion, CurrentConfigVersion)
+	}
+
+	fields := map[string]int{}
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		fields[strings.Split(configType.Field(i).Tag.Get("json"), ",")[0]] = i
+	}
+	defaults := reflect.ValueOf(DefaultConfig()).Elem()
+	target := reflect.ValueOf(config).Elem()
+	var missing []string
+	for version, keys := range configFieldsAddedIn {
+		if version < config.ConfigVersion {
+			continue
+		}
+		for _, key := range keys {
+			if present[key] {
+				continue
+			}
+			missing = append(missing, key)
+			target.Field(fields[key]).Set(defaults.Field(fields[key]))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		log.Printf("Config file is missing %d fields, using their defaults: %s. Run with -migrate to add them.\n",
+			len(missing), strings.Join(missing, ", "))
+	}
+}
+
+// Keys of the config file, from the json tags of Config
+func configKeys() []string {
+	var keys []string
+	fields := reflect.TypeOf(Config{})
+	for i := 0; i < fields.NumField(); i++ {
+		if key := strings.Split(fields.Field(i).Tag.Get("json"), ",")[0]; key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Top-level keys of the file that match no Config field, sorted
+func unknownConfigKeys(present map[string]bool) []string {
+	known := map[string]bool{}
+	for _, key := range configKeys() {
+		known[key] = true
+	}
+	var unknown []string
+	for key := range present {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Suggest the config key an unknown one was probably meant to be, e.g.
+// ", did you mean \"checkIntervalMinutes\"?", or nothing when none is close
+func configKeySuggestion(key string) string {
+	best, bestDistance := "", 3
+	for _, known := range configKeys() {
+		if distance := editDistance(strings.ToLower(key), strings.ToLower(known)); distance < bestDistance {
+			best, bestDistance = known, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// MigrateConfigFile rewrites a config file in the current schema, filling in
+// defaults for missing fields. The original is kept with a .bak extension.
+func MigrateConfigFile(filePath string) error {
+	original, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	config, err := LoadConfig(filePath)
+	if err != nil {
+		return err
+	}
+	config.ConfigVersion = CurrentConfigVersion
+
+	var data []byte
+	if isYAMLFile(filePath) {
+		data, err = renderYAMLConfig(config, false)
+	} else {
+		data, err = json.MarshalIndent(config, "", "    ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding config: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filePath+".bak", original, 0644); err != nil {
+		return fmt.Errorf("error backing up config file: %v", err)
+	}
+	return ioutil.WriteFile(filePath, data, 0644)
+}
@@ -0,0 +1,349 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// Load a config file written to a temporary directory, returning what was logged
+func loadTestConfig(t *testing.T, data []byte) (*Config, string) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	return config, logged.String()
+}
+
+func TestLoadOldConfigLogsMissingFieldsOnce(t *testing.T) {
+	config, logged := loadTestConfig(t, []byte(`{"smtpServer": "mail.example", "emailTo": ["ops@example.com"]}`))
+
+	var missingLines []string
+	for _, line := range strings.Split(strings.TrimSpace(logged), "\n") {
+		if strings.Contains(line, "missing") {
+			missingLines = append(missingLines, line)
+		}
+	}
+	if len(missingLines) != 1 {
+		t.Fatalf("got %d lines about missing fields, want 1:\n%s", len(missingLines), logged)
+	}
+	for _, want := range []string{"transport", "pagerDutyRegion", "-migrate"} {
+		if !strings.Contains(missingLines[0], want) {
+			t.Errorf("missing fields line %q doesn't mention %s", missingLines[0], want)
+		}
+	}
+
+	if config.SMTPServer != "mail.example" || config.Transport != "local" {
+		t.Errorf("got smtpServer %q and transport %q, want the file's server and the default transport",
+			config.SMTPServer, config.Transport)
+	}
+}
+
+func TestLoadVersion1ConfigKeepsZeroValues(t *testing.T) {
+	config, _ := loadTestConfig(t, []byte(`{
+		"veeamServerAddress": "vbr01",
+		"checkIntervalMinutes": 30,
+		"smtpServer": "mail.example",
+		"emailTo": ["ops@example.com"],
+		"monitorWarningJobs": true
+	}`))
+	if config.ConfigVersion != 1 {
+		t.Errorf("got configVersion %d, want 1 for a file without one", config.ConfigVersion)
+	}
+	// Settings the file predates keep the behaviour it was written for
+	if config.MonitorFailedJobs || config.StateFile != "" || config.CooldownMinutes != 0 || config.HTMLEmail {
+		t.Errorf("got monitorFailedJobs %v, stateFile %q, cooldownMinutes %d and htmlEmail %v, want them left at zero",
+			config.MonitorFailedJobs, config.StateFile, config.CooldownMinutes, config.HTMLEmail)
+	}
+}
+
+func TestLoadCurrentConfigLogsNoMissingFields(t *testing.T) {
+	data, err := json.Marshal(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, logged := loadTestConfig(t, data)
+	if strings.Contains(logged, "missing") || strings.Contains(logged, "-migrate") {
+		t.Errorf("current config logged:\n%s", logged)
+	}
+}
+
+func TestConfigFieldsAddedInVersion(t *testing.T) {
+	// Fields added to version 2 after its first files were written
+	config, logged := loadTestConfig(t, []byte(`{"configVersion": 2, "veeamServerAddress": "vbr01"}`))
+	if config.SureBackupSeverity != DefaultConfig().SureBackupSeverity || !strings.Contains(logged, "sureBackupSeverity") {
+		t.Errorf("got sureBackupSeverity %q from a version 2 file without it, logging:\n%s", config.SureBackupSeverity, logged)
+	}
+
+	// A newer file already had the chance to set them
+	_, logged = loadTestConfig(t, []byte(`{"configVersion": 3, "veeamServerAddress": "vbr01"}`))
+	if strings.Contains(logged, "missing") {
+		t.Errorf("version 3 file logged:\n%s", logged)
+	}
+}
+
+// Write a config file to a temporary directory, returning its path
+func writeConfigFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMissingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if _, err := LoadConfig(path); !errors.Is(err, ErrConfigNotFound) || errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want ErrConfigNotFound so defaults are used", err)
+	}
+	if _, err := LoadStrictConfig(path); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("strict: got error %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestLoadBrokenConfig(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"missing comma", "{\n  \"smtpServer\": \"mail.example\"\n  \"smtpPort\": 25\n}", "line 3, column 3: invalid character"},
+		{"wrong type", "{\n  \"smtpPort\": \"twenty-five\"\n}", "line 2, column 27: json: cannot unmarshal string"},
+		{"truncated", `{"smtpServer": "mail.example",`, "unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		path := writeConfigFile(t, "config.json", test.data)
+		_, err := LoadConfig(path)
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: got error %v, want ErrInvalidConfig rather than defaults", test.name, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %q, want it to contain %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestLoadValidConfig(t *testing.T) {
+	config, _ := loadTestConfig(t, []byte(`{
+		// Comments are allowed
+		"smtpServer": "mail.example",
+		"smtpPort": 2525,
+		"emailTo": ["ops@example.com"],
+		"checkIntervalMinutes": 30
+	}`))
+	if config.SMTPServer != "mail.example" || config.SMTPPort != 2525 || len(config.EmailTo) != 1 || config.CheckIntervalMinutes != 30 {
+		t.Errorf("got config %+v", config)
+	}
+	if config.VeeamPowerShellModule != "" {
+		t.Errorf("got module %q, want it left empty so the installed module is detected", config.VeeamPowerShellModule)
+	}
+}
+
+func TestStrictConfig(t *testing.T) {
+	data := `{"checkIntervalMinutes": 5, "debounceSeconds": 900}`
+	if _, err := LoadConfig(writeConfigFile(t, "config.json", data)); err != nil {
+		t.Errorf("lenient: got error %v, want the value replaced and a warning", err)
+	}
+	if _, err := LoadStrictConfig(writeConfigFile(t, "config.json", data)); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("strict: got error %v, want ErrInvalidConfig", err)
+	}
+	strict := `{"strictConfig": true, "checkIntervalMinutes": 5, "debounceSeconds": 900}`
+	if _, err := LoadConfig(writeConfigFile(t, "config.json", strict)); !errors.Is(err, ErrInvalidConfig) ||
+		!strings.Contains(err.Error(), "debounceSeconds 900 is longer than the check interval") {
+		t.Errorf("strictConfig in the file: got error %v, want ErrInvalidConfig naming the problem", err)
+	}
+}
+
+func TestDisplayDescription(t *testing.T) {
+	tests := []struct {
+		description string
+		max         int
+		want        string
+	}{
+		{"Disk full", 300, "Disk full"},
+		{"Disk full", 9, "Disk full"},
+		{"Disk full!", 9, "Disk ful…"},
+		{"Speicherplatz läuft über", 18, "Speicherplatz läu…"},
+		{"ディスクがいっぱいです", 6, "ディスクが…"},
+		{"备份失败🔥🔥🔥", 6, "备份失败🔥…"},
+		{"Disk full", 0, "Disk full"},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.MaxDescriptionLength = test.max
+		got := config.displayDescription(test.description)
+		if got != test.want {
+			t.Errorf("%q shortened to %d: got %q, want %q", test.description, test.max, got, test.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%q shortened to %d cut a character in half: %q", test.description, test.max, got)
+		}
+	}
+}
+
+func TestLongDescriptionOnlyShortenedInMessages(t *testing.T) {
+	description := strings.Repeat("Ошибка резервного копирования. ", 20)
+	config := testConfig()
+	jobs := []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: description}}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+
+	_, body := buildEmailBody(report, config)
+	shortened := config.displayDescription(description)
+	if len([]rune(shortened)) != config.MaxDescriptionLength || !strings.Contains(body, "Description: "+shortened+"\n") {
+		t.Errorf("email body doesn't have the description shortened to %d characters:\n%s", config.MaxDescriptionLength, body)
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), description) {
+		t.Error("JSON report doesn't have the full description")
+	}
+}
+
+func TestSMTPPortDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		want     int
+		logged   string
+	}{
+		{"no encryption", `"smtpServer": "mail.example"`, 25, "smtpPort is not set, using 25 for SMTP without required encryption"},
+		{"STARTTLS", `"smtpServer": "mail.example", "smtpStartTLS": true`, 587, "smtpPort is not set, using 587 for SMTP with STARTTLS"},
+		{"implicit TLS", `"smtpServer": "mail.example", "smtpTLS": true`, 465, "smtpPort is not set, using 465 for SMTP over TLS"},
+		{"explicit port", `"smtpServer": "mail.example", "smtpTLS": true, "smtpPort": 2525`, 2525, ""},
+		{"explicit default port", `"smtpServer": "mail.example", "smtpStartTLS": true, "smtpPort": 25`, 25, ""},
+		{"invalid port", `"smtpServer": "mail.example", "smtpStartTLS": true, "smtpPort": 70000`, 587, "SMTP port 70000 is not valid, using the default of 587"},
+	}
+	for _, test := range tests {
+		config, logged := loadTestConfig(t, []byte("{"+test.settings+"}"))
+		if got := config.smtpPort(); got != test.want {
+			t.Errorf("%s: got port %d, want %d", test.name, got, test.want)
+		}
+		if test.logged == "" && strings.Contains(logged, "smtpPort is not set") {
+			t.Errorf("%s: logged a default for an explicit port:\n%s", test.name, logged)
+		}
+		if !strings.Contains(logged, test.logged) {
+			t.Errorf("%s: log doesn't contain %q:\n%s", test.name, test.logged, logged)
+		}
+		if relays := smtpRelays(config); relays[0].Port != test.want {
+			t.Errorf("%s: relay uses port %d, want %d", test.name, relays[0].Port, test.want)
+		}
+	}
+
+	// The fallback relay follows the primary's port unless it has its own
+	config, _ := loadTestConfig(t, []byte(`{"smtpServer": "mail.example", "smtpTLS": true, "smtpServerFallback": "backup.example"}`))
+	if relays := smtpRelays(config); relays[1].Port != 465 {
+		t.Errorf("got fallback port %d, want 465", relays[1].Port)
+	}
+	config, _ = loadTestConfig(t, []byte(`{"smtpServer": "mail.example", "smtpTLS": true, "smtpServerFallback": "backup.example", "smtpPortFallback": 25}`))
+	if relays := smtpRelays(config); relays[1].Port != 25 {
+		t.Errorf("got fallback port %d, want 25", relays[1].Port)
+	}
+}
+
+func TestSMTPEncryptionConflict(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"smtpServer": "mail.example", "smtpTLS": true, "smtpStartTLS": true}`)
+	if _, err := LoadConfig(path); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestOAuthTenant(t *testing.T) {
+	config, _ := loadTestConfig(t, []byte(`{"oauthTenantID": " contoso.onmicrosoft.com ", "oauthClientID": "client", "oauthClientSecret": "secret"}`))
+	if config.OAuthTokenURL != "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/token" || config.OAuthScope != microsoftSMTPScope {
+		t.Errorf("got token URL %q and scope %q", config.OAuthTokenURL, config.OAuthScope)
+	}
+
+	// Explicit settings win over the tenant's
+	config, _ = loadTestConfig(t, []byte(`{"oauthTenantID": "contoso", "oauthTokenURL": "https://login.example/token", "oauthScope": "smtp", "oauthClientID": "client", "oauthClientSecret": "secret"}`))
+	if config.OAuthTokenURL != "https://login.example/token" || config.OAuthScope != "smtp" {
+		t.Errorf("got token URL %q and scope %q", config.OAuthTokenURL, config.OAuthScope)
+	}
+
+	path := writeConfigFile(t, "config.json", `{"oauthTenantID": "contoso", "oauthClientID": "client"}`)
+	if _, err := LoadConfig(path); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v without a client secret, want ErrInvalidConfig", err)
+	}
+}
+
+func TestUnknownConfigField(t *testing.T) {
+	data := `{"checkIntervalMinute": 5, "frobnicate": true}`
+	config, logged := loadTestConfig(t, []byte(data))
+	if config.CheckIntervalMinutes != DefaultConfig().CheckIntervalMinutes {
+		t.Errorf("lenient: got check interval %d, want the misspelled field ignored", config.CheckIntervalMinutes)
+	}
+	for _, want := range []string{
+		`Unknown config field "checkIntervalMinute" is ignored, did you mean "checkIntervalMinutes"?`,
+		`Unknown config field "frobnicate" is ignored` + "\n",
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("lenient: log doesn't contain %q:\n%s", want, logged)
+		}
+	}
+
+	_, err := LoadStrictConfig(writeConfigFile(t, "config.json", data))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), `"checkIntervalMinute"`) || !strings.Contains(err.Error(), `"frobnicate"`) {
+		t.Errorf("strict: got error %v, want ErrInvalidConfig naming both fields", err)
+	}
+	_, err = LoadConfig(writeConfigFile(t, "config.json", `{"strictConfig": true, "checkIntervalMinute": 5}`))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), `"checkIntervalMinute"`) {
+		t.Errorf("strictConfig in the file: got error %v, want ErrInvalidConfig naming the field", err)
+	}
+}
+
+func TestUnknownNestedConfigField(t *testing.T) {
+	data := `{"webhooks": [{"name": "ops", "url": "https://hooks.example/ops", "header": {"X-Token": "secret"}}]}`
+	config, logged := loadTestConfig(t, []byte(data))
+	if len(config.Webhooks) != 1 || !strings.Contains(logged, `unknown field "header"`) {
+		t.Errorf("lenient: got webhooks %+v, log:\n%s", config.Webhooks, logged)
+	}
+	if _, err := LoadStrictConfig(writeConfigFile(t, "config.json", data)); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), `"header"`) {
+		t.Errorf("strict: got error %v, want ErrInvalidConfig naming the field", err)
+	}
+
+	// YAML files are checked the same way
+	if _, err := LoadStrictConfig(writeConfigFile(t, "config.yaml", "checkIntervalMinute: 5\n")); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("strict YAML: got error %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestLongRunningGracePeriod(t *testing.T) {
+	config, _ := loadTestConfig(t, []byte(`{"longRunningThreshold": 120, "longRunningGraceMinutes": 3}`))
+	if got := config.longRunningMinutes(); got != 123 {
+		t.Errorf("got %d minutes, want the threshold plus the grace period", got)
+	}
+	config, logged := loadTestConfig(t, []byte(`{"longRunningThreshold": 120, "longRunningGraceMinutes": -5}`))
+	if got := config.longRunningMinutes(); got != 120 || !strings.Contains(logged, "Long running grace period is negative") {
+		t.Errorf("got %d minutes for a negative grace period, logged:\n%s", got, logged)
+	}
+}
+
+func TestShippedConfigLoadsCleanly(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, logged := loadTestConfig(t, data)
+	if strings.Contains(logged, "Config file") {
+		t.Errorf("loading the example config logged:\n%s", logged)
+	}
+	if config.CooldownMinutes != 360 || config.WebhookRetries != 3 {
+		t.Errorf("got cooldownMinutes %d and webhookRetries %d, want the defaults", config.CooldownMinutes, config.WebhookRetries)
+	}
+}
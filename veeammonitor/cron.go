@@ -0,0 +1,65 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// A parsed five-field cron expression: minute hour day-of-month month
+// day-of-week. Next comes from the robfig/cron schedule.
+type cronSchedule struct {
+	cron.Schedule
+}
+
+// Parse a standard cron expression such as "0 8,18 * * mon-fri" or a
+// descriptor such as "@daily". The schedule runs in the zone of the times
+// given to it, so a TZ prefix is refused in favour of the timezone setting.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "TZ=") || strings.HasPrefix(expr, "CRON_TZ=") {
+		return nil, fmt.Errorf("time zone prefixes aren't supported, set timezone instead")
+	}
+	if fields := strings.Fields(expr); len(fields) == 5 {
+		fields[4] = cronSundays(fields[4])
+		expr = strings.Join(fields, " ")
+	}
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &cronSchedule{schedule}, nil
+}
+
+// Day of week 7 is Sunday in standard cron but not in robfig/cron, so it is
+// rewritten to 0, and a range ending on it to one ending on Saturday plus 0
+func cronSundays(dow string) string {
+	parts := strings.Split(dow, ",")
+	for i, part := range parts {
+		switch {
+		case part == "7":
+			parts[i] = "0"
+		case strings.HasSuffix(part, "-7"):
+			parts[i] = strings.TrimSuffix(part, "-7") + "-6,0"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Previous returns the last time before t matching the schedule, or the zero
+// time when nothing matches in the few years before t
+func (s *cronSchedule) Previous(t time.Time) time.Time {
+	for step := time.Hour; step < 5*365*24*time.Hour; step *= 2 {
+		match := s.Next(t.Add(-step))
+		if match.IsZero() || !match.Before(t) {
+			continue
+		}
+		for next := s.Next(match); !next.IsZero() && next.Before(t); next = s.Next(next) {
+			match = next
+		}
+		return match
+	}
+	return time.Time{}
+}
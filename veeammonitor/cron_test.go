@@ -0,0 +1,97 @@
+package veeammonitor
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"0 8 * *",
+		"0 8 * * * *",
+		"60 8 * * *",
+		"0 24 * * *",
+		"0 8 0 * *",
+		"0 8 * 13 *",
+		"0 8 * * 8",
+		"0 8 * * fri-mon",
+		"*/0 * * * *",
+		"0 8 * * someday",
+		"@fortnightly",
+		"CRON_TZ=Europe/Berlin 0 8 * * *",
+	} {
+		if _, err := parseCronSchedule(expr); err == nil {
+			t.Errorf("%q parsed without error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 3, day, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		// 2024-03-01 is a Friday
+		{"0 8,18 * * mon-fri", at(1, 7, 59), at(1, 8, 0)},
+		{"0 8,18 * * mon-fri", at(1, 8, 0), at(1, 18, 0)},
+		{"0 8,18 * * mon-fri", at(1, 18, 30), at(4, 8, 0)},
+		{"*/15 * * * *", at(1, 10, 7), at(1, 10, 15)},
+		{"*/15 * * * *", at(1, 10, 59), at(1, 11, 0)},
+		{"5/20 * * * *", at(1, 10, 46), at(1, 11, 5)},
+		{"@daily", at(1, 10, 0), at(2, 0, 0)},
+		{"@hourly", at(1, 10, 30), at(1, 11, 0)},
+		{"0 6 * * 7", at(1, 0, 0), at(3, 6, 0)},
+		{"0 6 * * SUN", at(1, 0, 0), at(3, 6, 0)},
+		{"0 6 * * sat-7", at(1, 0, 0), at(2, 6, 0)},
+		{"0 6 * * 6-7", at(2, 7, 0), at(3, 6, 0)},
+		{"0 0 1 APR *", at(1, 0, 0), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// Restricting both day fields matches either, the 13th or a Monday
+		{"0 0 13 * mon", at(1, 0, 0), at(4, 0, 0)},
+		{"0 0 13 * mon", at(12, 0, 0), at(13, 0, 0)},
+		{"0 0 29 2 *", at(1, 0, 0), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", at(1, 0, 0), time.Time{}},
+	}
+	for _, test := range tests {
+		cron, err := parseCronSchedule(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if got := cron.Next(test.after); !got.Equal(test.want) {
+			t.Errorf("%q after %v: got %v, want %v", test.expr, test.after, got, test.want)
+		}
+	}
+}
+
+func TestCronNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	cron, _ := parseCronSchedule("0 8 * * *")
+	got := cron.Next(time.Date(2024, 3, 1, 9, 0, 0, 0, loc))
+	if want := time.Date(2024, 3, 2, 8, 0, 0, 0, loc); !got.Equal(want) || got.Location() != loc {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCronPrevious(t *testing.T) {
+	cron, _ := parseCronSchedule("0 8,18 * * mon-fri")
+	got := cron.Previous(time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestInvalidCronScheduleRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"cronSchedule": "0 25 * * *"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want ErrInvalidConfig", err)
+	}
+}
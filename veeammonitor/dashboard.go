@@ -0,0 +1,260 @@
+package veeammonitor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// How often the dashboard is redrawn, so the countdown keeps moving
+const dashboardRefresh = time.Second
+
+// Lines of the log kept for the dashboard's recent events
+const dashboardEventLines = 200
+
+// Checks shown from the cycle history
+const dashboardHistoryLines = 5
+
+// EventLog keeps the most recent log lines for the dashboard. Set it as the
+// log output so logging doesn't scroll the dashboard away.
+type EventLog struct {
+	mu      sync.Mutex
+	lines   []string
+	partial string // Text after the last newline
+}
+
+// NewEventLog creates an empty event log
+func NewEventLog() *EventLog {
+	return &EventLog{}
+}
+
+func (l *EventLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	text := l.partial + string(p)
+	lines := strings.Split(text, "\n")
+	l.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if strings.TrimSpace(line) != "" {
+			l.lines = append(l.lines, line)
+		}
+	}
+	if len(l.lines) > dashboardEventLines {
+		l.lines = append([]string(nil), l.lines[len(l.lines)-dashboardEventLines:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns the logged lines, oldest first
+func (l *EventLog) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// IsTerminal reports whether f is a terminal rather than a file or pipe
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Record when the next scheduled check runs, zero when none is scheduled
+func (m *Monitor) setNextCheck(next time.Time) {
+	m.statusMu.Lock()
+	m.nextCheck = next
+	m.statusMu.Unlock()
+}
+
+// RunDashboard shows a live status view on w, redrawn every second, until
+// the monitor is stopped or q is pressed, which stops the monitor. It shows
+// the same problems as /status, so run it alongside Run.
+func (m *Monitor) RunDashboard(w io.Writer, events *EventLog) error {
+	program := tea.NewProgram(dashboardModel{monitor: m, events: events, width: 80, height: 24, now: time.Now()},
+		tea.WithOutput(w), tea.WithAltScreen(), tea.WithContext(m.runContext()))
+	_, err := program.Run()
+	if errors.Is(err, tea.ErrProgramKilled) {
+		// Stopping the monitor ended the dashboard
+		return nil
+	}
+	return err
+}
+
+// Message redrawing the dashboard
+type dashboardTick time.Time
+
+// The bubbletea model of the dashboard. The view is read from the monitor
+// each time it is drawn.
+type dashboardModel struct {
+	monitor       *Monitor
+	events        *EventLog
+	width, height int
+	now           time.Time
+}
+
+func dashboardTicker() tea.Cmd {
+	return tea.Tick(dashboardRefresh, func(t time.Time) tea.Msg { return dashboardTick(t) })
+}
+
+func (d dashboardModel) Init() tea.Cmd {
+	return dashboardTicker()
+}
+
+func (d dashboardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		d.width, d.height = msg.Width, msg.Height
+	case dashboardTick:
+		d.now = time.Time(msg)
+		return d, dashboardTicker()
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c":
+			d.monitor.Stop()
+			return d, tea.Quit
+		}
+	}
+	return d, nil
+}
+
+func (d dashboardModel) View() string {
+	m := d.monitor
+	m.statusMu.Lock()
+	status, next := m.status, m.nextCheck
+	m.statusMu.Unlock()
+	return dashboardView(m.Config, status, next, m.cycleHistory().list(), d.events.Lines(), d.now, d.width, d.height)
+}
+
+// Render the dashboard: a header with the check times, problematic jobs
+// grouped by status, repositories low on space, and as many recent events as
+// fit below. Lines are cut to width.
+func dashboardView(config *Config, status cycleStatus, next time.Time, history []cycleEvent, events []string, now time.Time, width, height int) string {
+	loc := config.location()
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, truncateRunes(fmt.Sprintf(format, args...), width))
+	}
+
+	// Title on the left, clock on the right
+	title, clock := "Veeam Backup Monitor - "+serverDisplayName(config), now.In(loc).Format("2006-01-02 15:04:05")
+	gap := width - len([]rune(title)) - len(clock)
+	if gap < 2 {
+		gap = 2
+	}
+	add("%s%s%s", title, strings.Repeat(" ", gap), clock)
+	lastCheck := "not yet"
+	if !status.Checked.IsZero() {
+		lastCheck = fmt.Sprintf("%s (%s ago)", status.Checked.In(loc).Format("15:04:05"), now.Sub(status.Checked).Truncate(time.Second))
+	}
+	nextCheck := "none scheduled"
+	if !next.IsZero() {
+		wait := next.Sub(now).Truncate(time.Second)
+		if wait < 0 {
+			wait = 0
+		}
+		nextCheck = fmt.Sprintf("%s (in %s)", formatNextCheck(next.In(loc)), wait)
+	}
+	add("Last check: %s   Next check: %s", lastCheck, nextCheck)
+	switch {
+	case status.Checked.IsZero():
+		add("Server: waiting for the first check")
+	case status.Unreachable:
+		add("Server: UNREACHABLE, job statuses unknown")
+	case len(status.Jobs) == 0 && len(status.Repositories) == 0:
+		add("Server: reachable, no problematic jobs")
+	default:
+		add("Server: reachable")
+	}
+
+	sections := []struct{ status, title string }{
+		{"Failed", "FAILED"},
+		{"Warning", "WARNING"},
+		{stuckStatus, "STUCK"},
+		{disabledStatus, "DISABLED"},
+		{missingStatus, "MISSING"},
+		{"Running", "LONG-RUNNING"},
+		{"Stale", "NOT RUN RECENTLY"},
+		{deviationStatus, "SIZE DEVIATION"},
+		{driftStatus, "SCHEDULE DRIFT"},
+	}
+	for _, section := range sections {
+		var jobs []JobStatus
+		for _, job := range status.Jobs {
+			if job.Status == section.status {
+				jobs = append(jobs, job)
+			}
+		}
+		if len(jobs) == 0 {
+			continue
+		}
+		add("")
+		add("%s (%d)", section.title, len(jobs))
+		for _, job := range jobs {
+			var flags []string
+			if job.Acknowledged {
+				flags = append(flags, "acknowledged")
+			}
+			if job.RetryPending {
+				flags = append(flags, "retry pending")
+			}
+			if job.Escalated {
+				flags = append(flags, fmt.Sprintf("escalated, failed %d checks", job.ConsecutiveFailures))
+			}
+			flagText := ""
+			if len(flags) > 0 {
+				flagText = " [" + strings.Join(flags, "] [") + "]"
+			}
+			add("  %s (%s)%s  %s", job.Name, job.JobType, flagText, job.Description)
+			if job.Note != "" {
+				add("    Note: %s", job.Note)
+			}
+		}
+	}
+
+	if len(status.Repositories) > 0 {
+		add("")
+		add("REPOSITORIES LOW ON SPACE (%d)", len(status.Repositories))
+		for _, repo := range status.Repositories {
+			add("  %s  %s free of %s (%.1f%%)", repo.DisplayName(), formatGB(repo.FreeBytes), formatGB(repo.TotalBytes), repo.FreePercent())
+		}
+	}
+
+	if len(history) > 0 {
+		if len(history) > dashboardHistoryLines {
+			history = history[len(history)-dashboardHistoryLines:]
+		}
+		add("")
+		add("RECENT CHECKS")
+		for _, event := range history {
+			summary := fmt.Sprintf("%d jobs, %d repositories", event.Jobs, event.Repositories)
+			if event.Unreachable {
+				summary = "unreachable"
+			}
+			if len(event.Changes) > 0 {
+				summary += ": " + strings.Join(event.Changes, "; ")
+			}
+			add("  %s  %s", event.Time.In(config.location()).Format("15:04:05"), summary)
+		}
+	}
+
+	// Recent events fill the rest of the screen, newest last
+	add("")
+	add("RECENT EVENTS")
+	room := height - len(lines) - 1
+	if room < 1 {
+		room = 1
+	}
+	if len(events) > room {
+		events = events[len(events)-room:]
+	}
+	for _, event := range events {
+		add("  %s", event)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
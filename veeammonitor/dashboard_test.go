@@ -0,0 +1,146 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestDashboardView(t *testing.T) {
+	config := testConfig()
+	config.Timezone = "UTC"
+	now := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+	status := cycleStatus{
+		Checked: now.Add(-90 * time.Second),
+		Jobs: []JobStatus{
+			{Name: "Weekly", JobType: "Backup", Status: "Warning", Description: "Retrying", Acknowledged: true},
+			{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: "Disk full", RetryPending: true, Note: "Vendor ticket open"},
+			{Name: "Offsite", JobType: "Backup Copy", Status: "Running", Description: "Running for 5h"},
+		},
+		Repositories: []RepositoryStatus{{Name: "Main", TotalBytes: 100 << 30, FreeBytes: 5 << 30}},
+	}
+	history := []cycleEvent{{Time: now.Add(-90 * time.Second), Jobs: 3, Repositories: 1, Changes: []string{"Nightly failed"}}}
+	events := []string{"first", "second"}
+
+	want := "Veeam Backup Monitor - localhost" + strings.Repeat(" ", 29) + "2024-03-01 08:30:00\n" +
+		`Last check: 08:28:30 (1m30s ago)   Next check: Fri Mar 1 08:43:30 (in 13m30s)
+Server: reachable
+
+FAILED (1)
+  Nightly (Backup) [retry pending]  Disk full
+    Note: Vendor ticket open
+
+WARNING (1)
+  Weekly (Backup) [acknowledged]  Retrying
+
+LONG-RUNNING (1)
+  Offsite (Backup Copy)  Running for 5h
+
+REPOSITORIES LOW ON SPACE (1)
+  Main  5.0 GB free of 100.0 GB (5.0%)
+
+RECENT CHECKS
+  08:28:30  3 jobs, 1 repositories: Nightly failed
+
+RECENT EVENTS
+  first
+  second
+`
+	got := dashboardView(config, status, now.Add(13*time.Minute+30*time.Second), history, events, now, 80, 40)
+	if got != want {
+		t.Errorf("got view:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDashboardViewStates(t *testing.T) {
+	config := testConfig()
+	now := time.Now()
+	tests := []struct {
+		status cycleStatus
+		want   string
+	}{
+		{cycleStatus{}, "Server: waiting for the first check"},
+		{cycleStatus{Checked: now, Unreachable: true}, "Server: UNREACHABLE, job statuses unknown"},
+		{cycleStatus{Checked: now}, "Server: reachable, no problematic jobs"},
+	}
+	for _, test := range tests {
+		view := dashboardView(config, test.status, time.Time{}, nil, nil, now, 80, 24)
+		lines := strings.Split(view, "\n")
+		if lines[2] != test.want {
+			t.Errorf("got %q, want %q", lines[2], test.want)
+		}
+		if test.status.Checked.IsZero() && !strings.Contains(lines[1], "Last check: not yet   Next check: none scheduled") {
+			t.Errorf("got %q before any check", lines[1])
+		}
+	}
+}
+
+func TestDashboardViewFitsTerminal(t *testing.T) {
+	config := testConfig()
+	status := cycleStatus{Checked: time.Now(), Jobs: []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: strings.Repeat("very long description ", 10)},
+	}}
+	var events []string
+	for i := 0; i < 50; i++ {
+		events = append(events, fmt.Sprintf("event %d", i))
+	}
+
+	view := dashboardView(config, status, time.Time{}, nil, events, time.Now(), 40, 20)
+	lines := strings.Split(strings.TrimSuffix(view, "\n"), "\n")
+	if len(lines) > 20 {
+		t.Errorf("drew %d lines on a 20 line terminal", len(lines))
+	}
+	for _, line := range lines {
+		if len([]rune(line)) > 40 {
+			t.Errorf("line %q is wider than the terminal", line)
+		}
+	}
+	if last := lines[len(lines)-1]; last != "  event 49" {
+		t.Errorf("got last line %q, want the newest event", last)
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	events := NewEventLog()
+	fmt.Fprint(events, "first\n\nsec")
+	fmt.Fprint(events, "ond\nthird")
+	if got := events.Lines(); !equalStrings(got, []string{"first", "second"}) {
+		t.Errorf("got lines %q, want the complete non-blank lines", got)
+	}
+
+	for i := 0; i < dashboardEventLines+10; i++ {
+		fmt.Fprintf(events, "line %d\n", i)
+	}
+	lines := events.Lines()
+	if len(lines) != dashboardEventLines || lines[len(lines)-1] != fmt.Sprintf("line %d", dashboardEventLines+9) {
+		t.Errorf("kept %d lines ending %q, want the newest %d", len(lines), lines[len(lines)-1], dashboardEventLines)
+	}
+}
+
+func TestDashboardModel(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	var model tea.Model = dashboardModel{monitor: m, events: NewEventLog(), width: 80, height: 24, now: time.Now()}
+
+	tick := time.Date(2024, 3, 1, 8, 30, 0, 0, time.Local)
+	model, cmd := model.Update(dashboardTick(tick))
+	if cmd == nil || !strings.Contains(model.View(), "2024-03-01 08:30:00") {
+		t.Error("a tick didn't redraw the clock and schedule the next one")
+	}
+
+	model, _ = model.Update(tea.WindowSizeMsg{Width: 30, Height: 10})
+	lines := strings.Split(strings.TrimSuffix(model.View(), "\n"), "\n")
+	if len(lines) > 10 || len([]rune(lines[0])) > 30 {
+		t.Errorf("drew %d lines starting %q after resizing to 30x10", len(lines), lines[0])
+	}
+
+	if _, cmd := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")}); cmd != nil || m.runContext().Err() != nil {
+		t.Error("another key quit the dashboard")
+	}
+	_, cmd = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if cmd == nil || cmd() != tea.Quit() || m.runContext().Err() == nil {
+		t.Error("q didn't stop the monitor and quit")
+	}
+}
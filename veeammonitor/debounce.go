@@ -0,0 +1,33 @@
+package veeammonitor
+
+import (
+	"time"
+)
+
+// Whether to hold an alert that is ready to send. The first alert after a
+// quiet period is held for DebounceSeconds and a re-check is queued for the
+// end of the window, so problems that appear meanwhile go out in the same
+// alert. Checks inside the window keep holding it.
+func (m *Monitor) debouncing(now time.Time) bool {
+	window := time.Duration(m.Config.DebounceSeconds) * time.Second
+	if window <= 0 {
+		return false
+	}
+	if m.debounceUntil.IsZero() {
+		m.debounceUntil = now.Add(window)
+		m.logf("Holding the alert for %v to collect related problems\n", window)
+		time.AfterFunc(window, func() { m.TriggerCheck() })
+		return true
+	}
+	if now.Before(m.debounceUntil) {
+		return true
+	}
+	m.debounceUntil = time.Time{}
+	return false
+}
+
+// Whether an alert is being held by debouncing. Incident channels hold new
+// incidents with it.
+func (m *Monitor) alertHeld() bool {
+	return time.Now().Before(m.debounceUntil)
+}
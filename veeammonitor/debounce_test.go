@@ -0,0 +1,110 @@
+package veeammonitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runner answering the Failed query with the jobs named in *failed
+func failedJobsRunner(failed *[]string) fakeRunner {
+	return func(script string) (string, string, error) {
+		return statusRunner(*failed, nil)(script)
+	}
+}
+
+// Let the window of a held alert end without waiting for it
+func endDebounceWindow(m *Monitor) {
+	m.debounceUntil = time.Now().Add(-time.Second)
+}
+
+func TestDebounceCombinesStaggeredFailures(t *testing.T) {
+	config := testConfig()
+	config.DebounceSeconds = 60
+	failed := []string{"Nightly"}
+	m, capture := newCaptureMonitor(config, failedJobsRunner(&failed))
+
+	m.RunCheckCycle()
+	if !m.alertHeld() {
+		t.Fatal("the first alert wasn't held")
+	}
+	failed = []string{"Nightly", "Weekly"}
+	m.RunCheckCycle()
+	if sent := capture.sent(); len(sent) != 0 {
+		t.Fatalf("sent %d alerts within the debounce window", len(sent))
+	}
+
+	endDebounceWindow(m)
+	failed = []string{"Nightly", "Weekly", "Files"}
+	m.RunCheckCycle()
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d alerts, want one combined alert", len(sent))
+	}
+	var names []string
+	for _, job := range sent[0].Jobs() {
+		names = append(names, job.Name)
+	}
+	if len(names) != 3 {
+		t.Errorf("alerted on %v, want the three staggered failures", names)
+	}
+	if m.alertHeld() {
+		t.Error("an alert is still held after sending")
+	}
+}
+
+func TestDebounceWindowResetWhenProblemsClear(t *testing.T) {
+	config := testConfig()
+	config.DebounceSeconds = 60
+	failed := []string{"Nightly"}
+	m, capture := newCaptureMonitor(config, failedJobsRunner(&failed))
+
+	m.RunCheckCycle()
+	failed = nil
+	m.RunCheckCycle()
+	if m.alertHeld() {
+		t.Error("still holding an alert with no problems left")
+	}
+	if sent := capture.sent(); len(sent) != 0 {
+		t.Errorf("sent %d alerts for a failure that cleared within the window", len(sent))
+	}
+}
+
+func TestDebounceDoesNotDelayRecovery(t *testing.T) {
+	relay := newFakeSMTP(t)
+	config := smtpTestConfig(relay.addr, "ops@example.com")
+	config.DebounceSeconds = 60
+	config.NotifyRecoveries = true
+	failed := []string{"Nightly"}
+	m, capture := newCaptureMonitor(config, failedJobsRunner(&failed))
+	m.RunCheckCycle()
+	endDebounceWindow(m)
+	m.RunCheckCycle()
+	if len(capture.sent()) != 1 {
+		t.Fatal("Nightly wasn't alerted")
+	}
+
+	// Weekly's alert is held, but Nightly's recovery goes out straight away
+	failed = []string{"Weekly"}
+	m.RunCheckCycle()
+	if !m.alertHeld() || len(capture.sent()) != 1 {
+		t.Error("the new failure wasn't held")
+	}
+	var recovered bool
+	for _, message := range relay.delivered() {
+		recovered = recovered || strings.Contains(message.data, "Subject: RESOLVED: Veeam job Nightly back to normal")
+		if strings.Contains(message.data, "Weekly") {
+			t.Errorf("mailed the held Weekly alert:\n%s", message.data)
+		}
+	}
+	if !recovered {
+		t.Error("Nightly's recovery wasn't mailed")
+	}
+}
+
+func TestDebounceLimitedToCheckInterval(t *testing.T) {
+	config, logged := loadTestConfig(t, []byte(`{"checkIntervalMinutes": 5, "debounceSeconds": 900}`))
+	if config.DebounceSeconds != 300 || !strings.Contains(logged, "debounceSeconds 900 is longer than the check interval") {
+		t.Errorf("got debounceSeconds %d, logged %q, want it limited to the 300 second interval", config.DebounceSeconds, logged)
+	}
+}
@@ -0,0 +1,217 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// DiagnosticResult is the outcome of one -diagnose check
+type DiagnosticResult struct {
+	Check    string
+	Critical bool   // A failure means monitoring can't work
+	Skipped  bool   // Not configured, so not checked
+	Detail   string // What was verified, when the check passed
+	Err      error
+}
+
+// Status shown for the result in the summary table
+func (r DiagnosticResult) Status() string {
+	switch {
+	case r.Skipped:
+		return "SKIP"
+	case r.Err == nil:
+		return "PASS"
+	case r.Critical:
+		return "FAIL"
+	}
+	return "WARN"
+}
+
+// DiagnosticResults are the results of every -diagnose check, in order
+type DiagnosticResults []DiagnosticResult
+
+// Failed reports whether a critical check failed
+func (results DiagnosticResults) Failed() bool {
+	for _, result := range results {
+		if result.Critical && !result.Skipped && result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the results as a summary table
+func (results DiagnosticResults) Write(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CHECK\tRESULT\tDETAIL")
+	for _, result := range results {
+		detail := result.Detail
+		if result.Err != nil {
+			detail = result.Err.Error()
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\n", result.Check, result.Status(), detail)
+	}
+	return table.Flush()
+}
+
+// Diagnose checks, one after another, everything monitoring depends on: the
+// Veeam PowerShell module, the connection to Veeam, each SMTP relay (or SES),
+// each configured webhook and the SNS topic. Nothing is sent to the notification channels.
+func (m *Monitor) Diagnose() DiagnosticResults {
+	return append(m.diagnoseVeeam(), diagnoseNotifications(m.httpClient, m.Config)...)
+}
+
+// Check the Veeam PowerShell module and the connection to Veeam
+func (m *Monitor) diagnoseVeeam() DiagnosticResults {
+	config := m.Config
+	var results DiagnosticResults
+
+	if m.Source == nil {
+		module := DiagnosticResult{Check: "PowerShell module", Critical: true}
+		module.Detail, module.Err = m.diagnoseModule()
+		results = append(results, module)
+
+		connection := DiagnosticResult{Check: "Veeam connection", Critical: true}
+		if module.Err != nil {
+			connection.Err = fmt.Errorf("not checked, the module didn't load")
+		} else {
+			_, connection.Err = m.runVeeamScript("Get-VBRServer -ErrorAction Stop | Out-Null")
+			connection.Detail = "connected to " + serverDisplayName(config)
+		}
+		results = append(results, connection)
+	} else {
+		connection := DiagnosticResult{Check: "Veeam connection", Critical: true}
+		if starter, ok := m.Source.(CycleStarter); ok {
+			starter.StartCycle()
+		}
+		jobs, err := m.Source.JobsByStatus("Failed")
+		connection.Err = err
+		connection.Detail = fmt.Sprintf("connected to %s, %d failed jobs", serverDisplayName(config), len(jobs))
+		results = append(results, connection)
+	}
+	return results
+}
+
+// Check each SMTP relay (or SES), each configured webhook and the SNS topic
+func diagnoseNotifications(httpClient *http.Client, config *Config) DiagnosticResults {
+	var results DiagnosticResults
+	if config.SESEnabled {
+		check := DiagnosticResult{Check: "SES", Critical: true}
+		check.Detail, check.Err = diagnoseAWS(httpClient, config, "SES", "GetAccount", func(ctx context.Context, cfg aws.Config) error {
+			_, err := newSESClient(cfg).GetAccount(ctx, &sesv2.GetAccountInput{})
+			return err
+		})
+		results = append(results, check)
+	} else if config.EmailFrom == "" && len(config.EmailTo) == 0 && config.SMTPServer == "" {
+		results = append(results, DiagnosticResult{Check: "SMTP", Critical: true, Skipped: true, Detail: "email not configured"})
+	} else {
+		for i, relay := range smtpRelays(config) {
+			check := DiagnosticResult{Check: "SMTP " + relay.Server, Critical: i == 0}
+			if i > 0 {
+				check.Check = "SMTP fallback " + relay.Server
+			}
+			check.Detail, check.Err = verifyRelay(httpClient, config, relay)
+			results = append(results, check)
+		}
+	}
+
+	type webhookCheck struct {
+		check, url string
+		critical   bool
+		requireOK  bool // Only a 2xx status passes, rather than any answer
+	}
+	webhooks := []webhookCheck{
+		{"Discord webhook", config.DiscordWebhookURL, true, true},
+		{"Slack webhook", config.SlackWebhookURL, true, false},
+		{"Teams webhook", config.TeamsWebhookURL, true, false},
+		{"PagerDuty", config.pagerDutyEventsURL(), true, false},
+		{"Opsgenie", config.opsgenieAPIURL(), true, false},
+		{"Heartbeat URL", config.HeartbeatURL, false, true},
+	}
+	for _, webhook := range config.Webhooks {
+		webhooks = append(webhooks, webhookCheck{"Webhook " + webhook.Name, webhook.URL, true, false})
+	}
+	for _, webhook := range webhooks {
+		check := DiagnosticResult{Check: webhook.check, Critical: webhook.critical}
+		switch {
+		case webhook.check == "PagerDuty" && config.PagerDutyRoutingKey == "", webhook.check == "Opsgenie" && config.OpsgenieAPIKey == "", webhook.url == "":
+			check.Skipped, check.Detail = true, "not configured"
+		default:
+			check.Detail, check.Err = checkURLReachable(httpClient, webhook.url, webhook.requireOK)
+		}
+		results = append(results, check)
+	}
+
+	topic := DiagnosticResult{Check: "SNS topic", Critical: true, Skipped: true, Detail: "not configured"}
+	if config.SNSTopicARN != "" {
+		topic.Skipped = false
+		topic.Detail, topic.Err = diagnoseAWS(httpClient, config, "SNS", "GetTopicAttributes", func(ctx context.Context, cfg aws.Config) error {
+			_, err := newSNSClient(cfg).GetTopicAttributes(ctx, &sns.GetTopicAttributesInput{TopicArn: aws.String(config.SNSTopicARN)})
+			return err
+		})
+	}
+	return append(results, topic)
+}
+
+// Call a read-only AWS action to check the credentials, region and
+// permissions a channel needs
+func diagnoseAWS(httpClient *http.Client, config *Config, service, action string, call func(ctx context.Context, cfg aws.Config) error) (string, error) {
+	ctx := context.Background()
+	cfg, err := loadAWSConfig(ctx, httpClient, config)
+	if err != nil {
+		return "", err
+	}
+	if err := call(ctx, cfg); err != nil {
+		return "", awsError(service, action, err)
+	}
+	return fmt.Sprintf("%s %s succeeded in %s", service, action, config.awsRegion()), nil
+}
+
+// Load the configured Veeam module, detecting it first when none is configured
+func (m *Monitor) diagnoseModule() (string, error) {
+	if m.Config.VeeamPowerShellModule == "" {
+		if err := m.DetectPowerShellModule(); err != nil {
+			return "", err
+		}
+	}
+	if m.Config.VeeamPowerShellModule == "" {
+		return "", fmt.Errorf("no Veeam PowerShell module or snap-in found")
+	}
+
+	_, stderr, err := m.Runner.Run(loadModuleStatement(m.Config.VeeamPowerShellModule))
+	if err != nil {
+		return "", powerShellError(stderr, err)
+	}
+	return m.Config.VeeamPowerShellModule + " loaded", nil
+}
+
+// Check that a URL answers a GET with a 2xx status, or with requireOK unset
+// any status but a server error. Endpoints that only accept POST still prove
+// they are reachable by rejecting the GET.
+func checkURLReachable(httpClient *http.Client, url string, requireOK bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), heartbeatTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", redactURLError(err)
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return "", redactURLError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 500 || requireOK && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return "", fmt.Errorf("%s returned status %s", redactURL(url), resp.Status)
+	}
+	return fmt.Sprintf("%s answered %s", redactURL(url), resp.Status), nil
+}
@@ -0,0 +1,120 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Server answering every request with status
+func statusServer(t *testing.T, status int) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// Status of each result, keyed by check
+func diagnosticStatuses(results DiagnosticResults) map[string]string {
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result.Check] = result.Status()
+	}
+	return statuses
+}
+
+func TestDiagnose(t *testing.T) {
+	relay := newFakeSMTP(t)
+	config := smtpTestConfig(relay.addr, "ops@example.com")
+	config.SMTPServerFallback = closedAddress(t)
+	config.DiscordWebhookURL = statusServer(t, http.StatusOK)
+	config.SlackWebhookURL = statusServer(t, http.StatusMethodNotAllowed)
+	config.HeartbeatURL = statusServer(t, http.StatusNotFound)
+	var scripts []string
+	m := newTestMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		scripts = append(scripts, script)
+		return "", "", nil
+	}))
+
+	results := m.Diagnose()
+	want := map[string]string{
+		"PowerShell module":                          "PASS",
+		"Veeam connection":                           "PASS",
+		"SMTP " + relay.addr:                         "PASS",
+		"SMTP fallback " + config.SMTPServerFallback: "WARN",
+		"Discord webhook":                            "PASS",
+		"Slack webhook":                              "PASS", // Rejecting the GET still proves it's reachable
+		"Teams webhook":                              "SKIP",
+		"PagerDuty":                                  "SKIP",
+		"Opsgenie":                                   "SKIP",
+		"Heartbeat URL":                              "WARN",
+		"SNS topic":                                  "SKIP",
+	}
+	got := diagnosticStatuses(results)
+	for check, status := range want {
+		if got[check] != status {
+			t.Errorf("%s: got %s, want %s", check, got[check], status)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got checks %v, want %v", got, want)
+	}
+	if results.Failed() {
+		t.Error("only non-critical checks failed, but the diagnosis failed")
+	}
+	if len(scripts) != 2 || !strings.Contains(scripts[0], "Import-Module Veeam.Backup.PowerShell") || !strings.Contains(scripts[1], "Get-VBRServer") {
+		t.Errorf("ran scripts %q, want the module loaded then the server queried", scripts)
+	}
+	if len(relay.delivered()) != 0 {
+		t.Error("diagnosing sent an email")
+	}
+
+	var table bytes.Buffer
+	if err := results.Write(&table); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != len(results)+1 || !strings.HasPrefix(lines[0], "CHECK") || strings.Join(strings.Fields(lines[1]), " ") != "PowerShell module PASS Veeam.Backup.PowerShell loaded" {
+		t.Errorf("got summary table:\n%s", table.String())
+	}
+}
+
+func TestDiagnoseCriticalFailures(t *testing.T) {
+	config := smtpTestConfig(closedAddress(t), "ops@example.com")
+	config.TeamsWebhookURL = statusServer(t, http.StatusInternalServerError)
+	m := newTestMonitor(config, staticRunner("", "Import-Module : module not found", errors.New("exit status 1")))
+
+	results := m.Diagnose()
+	got := diagnosticStatuses(results)
+	for _, check := range []string{"PowerShell module", "Veeam connection", "SMTP " + config.SMTPServer, "Teams webhook"} {
+		if got[check] != "FAIL" {
+			t.Errorf("%s: got %s, want FAIL", check, got[check])
+		}
+	}
+	if !results.Failed() {
+		t.Error("critical checks failed, but the diagnosis passed")
+	}
+	for _, result := range results {
+		if result.Check == "Veeam connection" && !strings.Contains(result.Err.Error(), "not checked") {
+			t.Errorf("got connection error %v, want it not checked without the module", result.Err)
+		}
+	}
+}
+
+func TestDiagnoseSource(t *testing.T) {
+	config := testConfig()
+	m := newTestMonitor(config, staticRunner("", "", errors.New("PowerShell shouldn't run")))
+	m.Source = stubSource{jobs: []JobStatus{{Name: "Nightly", Status: "Failed"}}}
+
+	results := m.Diagnose()
+	if results[0].Check != "Veeam connection" || results[0].Err != nil || !strings.HasSuffix(results[0].Detail, "1 failed jobs") {
+		t.Errorf("got %+v, want the connection checked through the source", results[0])
+	}
+	if got := diagnosticStatuses(results); got["SMTP"] != "SKIP" || got["PowerShell module"] != "" {
+		t.Errorf("got checks %v, want SMTP skipped and no module check", got)
+	}
+}
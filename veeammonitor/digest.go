@@ -0,0 +1,317 @@
+package veeammonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PowerShell listing every session of the jobs of each supported type with
+// the columns Name, Result, CreationTime and EndTime. Running sessions have
+// the result None and an EndTime before their CreationTime.
+var jobSessionSources = map[string]string{
+	"backup": `Get-VBRBackupSession | Where-Object {$_.JobType -eq "Backup"} | ForEach-Object {
+			[pscustomobject]@{Name=$_.JobName; Result=$_.Result; CreationTime=$_.CreationTime; EndTime=$_.EndTime}
+		}`,
+	"copy": `Get-VBRBackupCopyJob | ForEach-Object {
+			$job = $_
+			Get-VBRSession -Job $job | ForEach-Object { [pscustomobject]@{Name=$job.Name; Result=$_.Result; CreationTime=$_.CreationTime; EndTime=$_.EndTime} }
+		}`,
+	"tape": `Get-VBRTapeJob | ForEach-Object {
+			$job = $_
+			Get-VBRSession -Job $job | ForEach-Object { [pscustomobject]@{Name=$job.Name; Result=$_.Result; CreationTime=$_.CreationTime; EndTime=$_.EndTime} }
+		}`,
+	"agent": `Get-VBRComputerBackupJob | ForEach-Object {
+			$job = $_
+			Get-VBRComputerBackupJobSession -Name $job.Name | ForEach-Object { [pscustomobject]@{Name=$job.Name; Result=$_.Result; CreationTime=$_.CreationTime; EndTime=$_.EndTime} }
+		}`,
+	"surebackup": `& {
+			if (-not (Get-Command Get-VBRSureBackupJob -ErrorAction SilentlyContinue)) {
+				[Console]::Error.WriteLine("SureBackup cmdlets not available, Veeam 12 or later is needed to monitor SureBackup jobs")
+				return
+			}
+			$jobs = @{}
+			Get-VBRSureBackupJob | ForEach-Object { $jobs[$_.Id] = $_.Name }
+			Get-VBRSureBackupSession | Where-Object {$jobs.ContainsKey($_.JobId)} | ForEach-Object {
+				[pscustomobject]@{Name=$jobs[$_.JobId]; Result=$_.Result; CreationTime=$_.CreationTime; EndTime=$_.EndTime}
+			}
+		}`,
+}
+
+// Get the sessions of every monitored job started since a time. A failing
+// job type is logged and left out, like in the checks.
+func (m *Monitor) getSessions(since time.Time) ([]JobStatus, error) {
+	config := m.Config
+	var sessions []JobStatus
+	var lastErr error
+	failures := 0
+
+	for _, jobType := range config.MonitorJobTypes {
+		output, err := m.runVeeamScript(fmt.Sprintf(`
+		$since = [datetime]::Parse('%s', [cultureinfo]::InvariantCulture, [System.Globalization.DateTimeStyles]::RoundtripKind).ToLocalTime()
+		%s | Where-Object {$_.CreationTime -ge $since} | Select-Object Name,@{Name="Status";Expression={$_.Result}},CreationTime,EndTime,@{Name="Description";Expression={""}},@{Name="Duration";Expression={
+			if ($_.EndTime -gt $_.CreationTime) { ($_.EndTime - $_.CreationTime).TotalMinutes.ToString([cultureinfo]::InvariantCulture) }
+		}} | %s
+	`, since.UTC().Format(time.RFC3339), jobSessionSources[jobType], convertToCsv(config)))
+		if errors.Is(err, ErrVeeamUnreachable) {
+			return nil, err
+		}
+		if err == nil {
+			var typeSessions []JobStatus
+			if typeSessions, err = parseJobStatusOutput(output, "", config.csvDelimiter()); err == nil {
+				for i := range typeSessions {
+					typeSessions[i].JobType = jobTypeLabels[jobType]
+					typeSessions[i].StartTime = config.displayTime(typeSessions[i].StartTime)
+					typeSessions[i].EndTime = config.displayTime(typeSessions[i].EndTime)
+				}
+				sessions = append(sessions, typeSessions...)
+				continue
+			}
+		}
+		m.logf("Error listing %s job sessions: %v\n", jobType, err)
+		lastErr = err
+		failures++
+	}
+
+	if failures > 0 && failures == len(config.MonitorJobTypes) {
+		return nil, lastErr
+	}
+	return sessions, nil
+}
+
+// Sessions of one job over a digest period
+type digestJob struct {
+	Name      string
+	JobType   string
+	Sessions  int // Started in the period, running ones included
+	Succeeded int
+	Warnings  int
+	Failures  int
+
+	minutes float64 // Total length of the finished sessions
+	timed   int     // Finished sessions with a known length
+}
+
+// Finished sessions, the ones the success rate is taken over
+func (j digestJob) finished() int {
+	return j.Succeeded + j.Warnings + j.Failures
+}
+
+// Average length of the job's finished sessions, empty when none is known
+func (j digestJob) averageDuration() string {
+	if j.timed == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.0f minutes", j.minutes/float64(j.timed))
+}
+
+// Summary of every job's sessions between two digests
+type digest struct {
+	Start, End time.Time
+	Jobs       []digestJob // Every job, most failures first
+	Total      digestJob   // The sessions of all jobs together
+}
+
+// Percentage of finished sessions that succeeded, warnings not counting as
+// successes
+func successRate(job digestJob) string {
+	if job.finished() == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", float64(job.Succeeded)*100/float64(job.finished()))
+}
+
+// Count the sessions of each job. Jobs are listed even without sessions in
+// the period; sessions of jobs missing from jobs are counted all the same.
+func buildDigest(jobs, sessions []JobStatus, start, end time.Time) digest {
+	d := digest{Start: start, End: end}
+	byJob := map[string]*digestJob{}
+	var order []string
+	job := func(status JobStatus) *digestJob {
+		key := jobIdentity(status)
+		if byJob[key] == nil {
+			byJob[key] = &digestJob{Name: status.Name, JobType: status.JobType}
+			order = append(order, key)
+		}
+		return byJob[key]
+	}
+	for _, status := range jobs {
+		job(status)
+	}
+
+	for _, session := range sessions {
+		for _, counts := range []*digestJob{job(session), &d.Total} {
+			counts.Sessions++
+			switch session.Status {
+			case "Success":
+				counts.Succeeded++
+			case "Warning":
+				counts.Warnings++
+			case "Failed":
+				counts.Failures++
+			default:
+				// Still running, no result or length yet
+				continue
+			}
+			if minutes, err := strconv.ParseFloat(session.Duration, 64); err == nil {
+				counts.minutes += minutes
+				counts.timed++
+			}
+		}
+	}
+
+	for _, key := range order {
+		d.Jobs = append(d.Jobs, *byJob[key])
+	}
+	sort.Slice(d.Jobs, func(i, j int) bool {
+		a, b := d.Jobs[i], d.Jobs[j]
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		if a.Warnings != b.Warnings {
+			return a.Warnings > b.Warnings
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+	return d
+}
+
+// Render the digest email
+func digestEmail(d digest, config *Config) (subject, body string) {
+	loc := config.location()
+	total := d.Total
+	subject = fmt.Sprintf("Veeam digest (%s): %d sessions, %s successful, %d failed", serverDisplayName(config), total.Sessions, successRate(total), total.Failures)
+
+	body = "Veeam Backup & Replication Digest\n"
+	body += "=================================\n\n"
+	body += fmt.Sprintf("Server: %s\n", serverDisplayName(config))
+	body += fmt.Sprintf("Period: %s to %s\n", d.Start.In(loc).Format("Mon Jan 2 15:04"), d.End.In(loc).Format("Mon Jan 2 15:04"))
+	body += fmt.Sprintf("Jobs: %d\n", len(d.Jobs))
+	body += fmt.Sprintf("Sessions: %d (%d successful, %d with warnings, %d failed, %d running)\n",
+		total.Sessions, total.Succeeded, total.Warnings, total.Failures, total.Sessions-total.finished())
+	body += fmt.Sprintf("Success rate: %s\n", successRate(total))
+	body += fmt.Sprintf("Total failures: %d\n", total.Failures)
+	if average := total.averageDuration(); average != "" {
+		body += fmt.Sprintf("Average duration: %s\n", average)
+	}
+	body += "\n"
+
+	var active, idle []digestJob
+	for _, job := range d.Jobs {
+		if job.Sessions == 0 {
+			idle = append(idle, job)
+		} else {
+			active = append(active, job)
+		}
+	}
+
+	if len(active) > 0 {
+		body += "JOBS:\n"
+		body += "-----\n"
+		for _, job := range active {
+			body += fmt.Sprintf("%s (%s): %s successful, sessions: %d, failed: %d, with warnings: %d", job.Name, job.JobType, successRate(job), job.Sessions, job.Failures, job.Warnings)
+			if average := job.averageDuration(); average != "" {
+				body += fmt.Sprintf(", average duration: %s", average)
+			}
+			body += "\n"
+		}
+		body += "\n"
+	}
+
+	if len(idle) > 0 {
+		body += "JOBS WITHOUT SESSIONS:\n"
+		body += "----------------------\n"
+		for _, job := range idle {
+			body += fmt.Sprintf("%s (%s)\n", job.Name, job.JobType)
+		}
+		body += "\n"
+	}
+
+	body += "This is an automated message from the Veeam Backup Monitor.\n"
+	return subject, body
+}
+
+// Email the digest on DigestSchedule, each covering the sessions started
+// since the schedule's previous run, until ctx is cancelled
+func (m *Monitor) runDigests(ctx context.Context) {
+	schedule, err := parseCronSchedule(m.Config.DigestSchedule)
+	if err != nil {
+		m.logf("Error parsing digestSchedule, not sending digests: %v\n", err)
+		return
+	}
+	loc := m.Config.location()
+	for {
+		next := schedule.Next(time.Now().In(loc))
+		if next.IsZero() {
+			m.logln("Digest schedule has no upcoming run, not sending digests")
+			return
+		}
+		m.logf("Next digest at %s\n", formatNextCheck(next))
+		if !sleepUntil(ctx, next) {
+			return
+		}
+
+		start := schedule.Previous(next)
+		if start.IsZero() {
+			start = next.AddDate(0, 0, -1)
+		}
+		if err := m.sendDigest(start, next); err != nil {
+			m.logf("Error sending digest: %v\n", err)
+		}
+	}
+}
+
+// List every job and its sessions started in [start, end) and email the digest
+func (m *Monitor) sendDigest(start, end time.Time) error {
+	d, err := m.queryDigest(start, end)
+	if err != nil {
+		return err
+	}
+
+	config := m.Config
+	subject, body := digestEmail(d, config)
+	ctx, cancel := m.notificationContext()
+	err = sendEmail(ctx, m.httpClient, config, subject, body)
+	cancel()
+	m.recordNotification(config.emailChannel(), err)
+	if err != nil {
+		return err
+	}
+	m.logf("Sent digest covering %d sessions of %d jobs\n", d.Total.Sessions, len(d.Jobs))
+	return nil
+}
+
+// Query the source for the digest of [start, end)
+func (m *Monitor) queryDigest(start, end time.Time) (digest, error) {
+	// Sources aren't safe to query while a check is using them
+	m.cycleMu.Lock()
+	defer m.cycleMu.Unlock()
+	source := m.source()
+	lister, ok := source.(SessionLister)
+	if !ok {
+		return digest{}, fmt.Errorf("the job source can't list job sessions")
+	}
+	sessions, err := lister.Sessions(start)
+	if err != nil {
+		return digest{}, fmt.Errorf("error listing job sessions: %v", err)
+	}
+	var inPeriod []JobStatus
+	for _, session := range sessions {
+		started, err := time.ParseInLocation(displayTimeLayout, session.StartTime, m.Config.location())
+		if err != nil || started.Before(end) {
+			inPeriod = append(inPeriod, session)
+		}
+	}
+
+	var jobs []JobStatus
+	if jobLister, ok := source.(JobLister); ok {
+		if jobs, err = jobLister.AllJobs(); err != nil {
+			m.logf("Error listing jobs for the digest, only jobs with sessions are listed: %v\n", err)
+		}
+	}
+	return buildDigest(jobs, inPeriod, start, end), nil
+}
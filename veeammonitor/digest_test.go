@@ -0,0 +1,133 @@
+package veeammonitor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDigest(t *testing.T) {
+	jobs := []JobStatus{
+		{Name: "Nightly", JobType: "Backup"},
+		{Name: "Weekly", JobType: "Backup"},
+		{Name: "Archive", JobType: "Backup"},
+	}
+	sessions := []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Success", Duration: "20"},
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", Duration: "40"},
+		{Name: "Nightly", JobType: "Backup", Status: "None"},
+		{Name: "Weekly", JobType: "Backup", Status: "Warning"},
+		{Name: "Deleted", JobType: "Backup Copy", Status: "Success", Duration: "12.5"},
+	}
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	d := buildDigest(jobs, sessions, start, start.AddDate(0, 0, 1))
+
+	var names []string
+	for _, job := range d.Jobs {
+		names = append(names, job.Name)
+	}
+	if want := []string{"Nightly", "Weekly", "Archive", "Deleted"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("got jobs %v, want %v, most failures first", names, want)
+	}
+	nightly := d.Jobs[0]
+	if nightly.Sessions != 3 || nightly.Succeeded != 1 || nightly.Failures != 1 || nightly.finished() != 2 {
+		t.Errorf("got Nightly %+v", nightly)
+	}
+	if successRate(nightly) != "50.0%" || nightly.averageDuration() != "30 minutes" {
+		t.Errorf("got success rate %s and average %q", successRate(nightly), nightly.averageDuration())
+	}
+	if archive := d.Jobs[2]; archive.Sessions != 0 || successRate(archive) != "n/a" || archive.averageDuration() != "" {
+		t.Errorf("got Archive %+v", archive)
+	}
+	if total := d.Total; total.Sessions != 5 || total.Succeeded != 2 || total.Warnings != 1 || total.Failures != 1 || successRate(total) != "50.0%" {
+		t.Errorf("got total %+v", total)
+	}
+}
+
+func TestDigestEmail(t *testing.T) {
+	config := testConfig()
+	config.Timezone = "UTC"
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	d := buildDigest([]JobStatus{{Name: "Archive", JobType: "Backup"}}, []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", Duration: "30"},
+		{Name: "Nightly", JobType: "Backup", Status: "Success", Duration: "10"},
+	}, start, start.AddDate(0, 0, 1))
+
+	subject, body := digestEmail(d, config)
+	if subject != "Veeam digest (localhost): 2 sessions, 50.0% successful, 1 failed" {
+		t.Errorf("got subject %q", subject)
+	}
+	for _, want := range []string{
+		"Period: Fri Mar 1 08:00 to Sat Mar 2 08:00\n",
+		"Sessions: 2 (1 successful, 0 with warnings, 1 failed, 0 running)\n",
+		"Average duration: 20 minutes\n",
+		"JOBS:\n-----\nNightly (Backup): 50.0% successful, sessions: 2, failed: 1, with warnings: 0, average duration: 20 minutes\n",
+		"JOBS WITHOUT SESSIONS:\n----------------------\nArchive (Backup)\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestGetSessions(t *testing.T) {
+	config := testConfig()
+	config.MonitorJobTypes = []string{"backup", "copy"}
+	var scripts []string
+	m := newTestMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		scripts = append(scripts, script)
+		if strings.Contains(script, "Get-VBRBackupCopyJob") {
+			return "", "Get-VBRBackupCopyJob : not supported", errors.New("exit status 1")
+		}
+		return `"Name","Status","CreationTime","EndTime","Description","Duration"` + "\n" +
+			`"Nightly","Failed","2024-03-01T01:00:00","2024-03-01T01:30:00","","30"` + "\n", "", nil
+	}))
+
+	since := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	sessions, err := m.source().(SessionLister).Sessions(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].JobType != "Backup" || sessions[0].Status != "Failed" || sessions[0].Duration != "30" || sessions[0].StartTime != config.displayTime("2024-03-01T01:00:00") {
+		t.Errorf("got %+v, want the backup session with the copy jobs left out", sessions)
+	}
+	if !strings.Contains(scripts[0], "'2024-03-01T00:00:00Z'") {
+		t.Errorf("script doesn't filter on the start of the period:\n%s", scripts[0])
+	}
+
+	config.MonitorJobTypes = []string{"copy"}
+	if _, err := m.getSessions(since); err == nil {
+		t.Error("got no error when every job type failed")
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	smtp := newFakeSMTP(t)
+	config := smtpTestConfig(smtp.addr, "ops@example.com")
+	config.Timezone = "UTC"
+	m := newTestMonitor(config, nil)
+	m.Source = NewSimulatedSource(config, &SimulatedDataset{Jobs: []SimulatedJob{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", HoursSinceLastRun: 2},
+		{Name: "Weekly", JobType: "Backup", Status: "Success", HoursSinceLastRun: 100},
+	}})
+	now := time.Now()
+
+	if err := m.sendDigest(now.Add(-24*time.Hour), now); err != nil {
+		t.Fatal(err)
+	}
+	delivered := smtp.delivered()
+	if len(delivered) != 1 {
+		t.Fatalf("sent %d emails, want the digest", len(delivered))
+	}
+	for _, want := range []string{"Subject: Veeam digest (localhost): 1 sessions, 0.0% successful, 1 failed", "Nightly (Backup): 0.0% successful", "JOBS WITHOUT SESSIONS:\n----------------------\nWeekly (Backup)"} {
+		if !strings.Contains(delivered[0].data, want) {
+			t.Errorf("digest doesn't contain %q:\n%s", want, delivered[0].data)
+		}
+	}
+
+	m.Source = stubSource{}
+	if err := m.sendDigest(now.Add(-24*time.Hour), now); err == nil || err.Error() != "the job source can't list job sessions" {
+		t.Errorf("got error %v from a source without sessions", err)
+	}
+}
@@ -0,0 +1,24 @@
+package veeammonitor
+
+// Status of CriticalJobs found disabled
+const disabledStatus = "Disabled"
+
+// Disabled jobs whose name matches CriticalJobs, as Disabled jobs. Disabled
+// jobs matching nothing are assumed to be disabled on purpose.
+func disabledCriticalJobs(jobs []JobStatus, config *Config) []JobStatus {
+	var disabled []JobStatus
+	for _, job := range jobs {
+		if !job.Disabled {
+			continue
+		}
+		if _, ok := matchJobName(config.CriticalJobs, job.Name); !ok {
+			continue
+		}
+		job.Status = disabledStatus
+		job.Description = "Critical job is disabled and won't run until it is enabled again"
+		job.Duration = ""
+		job.NextRun = notScheduled
+		disabled = append(disabled, job)
+	}
+	return disabled
+}
@@ -0,0 +1,85 @@
+package veeammonitor
+
+import (
+	"strings"
+	"testing"
+)
+
+// Every job as the inventory query returns it: a critical job disabled, one
+// enabled, and a test job disabled on purpose
+const inventoryOutput = `"Name","LastResult","LastStart","LastEnd","Description","NextRun","IsEnabled"` + "\n" +
+	`"SQL-Prod","Success","3/1/2024 1:00:00 AM","3/1/2024 1:20:00 AM","","","False"` + "\n" +
+	`"SQL-Reporting","Success","3/1/2024 2:00:00 AM","3/1/2024 2:20:00 AM","","3/2/2024 2:00:00 AM","True"` + "\n" +
+	`"Test-Restore","Success","2/1/2024 1:00:00 AM","2/1/2024 1:20:00 AM","","","False"` + "\n"
+
+func TestParseJobStatusOutputIsEnabled(t *testing.T) {
+	jobs, err := parseJobStatusOutput(inventoryOutput, "", ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var disabled []bool
+	for _, job := range jobs {
+		disabled = append(disabled, job.Disabled)
+	}
+	if len(disabled) != 3 || !disabled[0] || disabled[1] || !disabled[2] {
+		t.Errorf("got disabled flags %v, want SQL-Prod and Test-Restore disabled", disabled)
+	}
+
+	jobs, _ = parseJobStatusOutput(jobCSVHeader+`"Nightly","Failed","","","","",""`+"\n", "Failed", ',')
+	if len(jobs) != 1 || jobs[0].Disabled {
+		t.Errorf("got %+v, want jobs listed without IsEnabled taken as enabled", jobs)
+	}
+}
+
+func TestDisabledCriticalJobs(t *testing.T) {
+	config := testConfig()
+	config.CriticalJobs = []string{"SQL-*", "Exchange"}
+	jobs := []JobStatus{
+		{Name: "SQL-Prod", JobType: "Backup", Status: "Success", Disabled: true, Duration: "12", NextRun: "3/2/2024 1:00:00 AM"},
+		{Name: "SQL-Reporting", JobType: "Backup", Status: "Success"},
+		{Name: "Test-Restore", JobType: "Backup", Status: "Success", Disabled: true},
+		{Name: "exchange", JobType: "Backup Copy", Status: "Failed", Disabled: true},
+	}
+
+	disabled := disabledCriticalJobs(jobs, config)
+	if names := jobNames(disabled); !equalStrings(names, []string{"SQL-Prod", "exchange"}) {
+		t.Fatalf("got disabled critical jobs %v, want SQL-Prod and exchange", names)
+	}
+	for _, job := range disabled {
+		if job.Status != disabledStatus || job.Duration != "" || job.NextRun != notScheduled || !strings.Contains(job.Description, "Critical job is disabled") {
+			t.Errorf("got %+v", job)
+		}
+	}
+	if jobs[0].Status != "Success" {
+		t.Error("the listed job was changed")
+	}
+
+	config.CriticalJobs = nil
+	if disabled := disabledCriticalJobs(jobs, config); len(disabled) != 0 {
+		t.Errorf("got %+v without criticalJobs", disabled)
+	}
+}
+
+func TestCheckCycleFlagsDisabledCriticalJob(t *testing.T) {
+	config := testConfig()
+	config.CriticalJobs = []string{"SQL-*"}
+	m, capture := newCaptureMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		if strings.Contains(script, "NextRun,IsEnabled |") {
+			return inventoryOutput, "", nil
+		}
+		return jobCSVHeader, "", nil
+	}))
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d reports, want 1", len(sent))
+	}
+	report := sent[0]
+	if names := jobNames(report.Disabled); !equalStrings(names, []string{"SQL-Prod"}) || report.Severity != severityCritical {
+		t.Errorf("got disabled %v with severity %s, want SQL-Prod critical", names, report.Severity)
+	}
+	if _, body := buildEmailBody(report, config); strings.Contains(body, "Test-Restore") {
+		t.Errorf("the job disabled on purpose is reported:\n%s", body)
+	}
+}
@@ -0,0 +1,301 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Discord message limits
+const (
+	discordMaxEmbeds      = 10   // Embeds per message
+	discordMaxFields      = 25   // Fields per embed
+	discordMaxMessageSize = 6000 // Characters across all embeds in a message
+	discordMaxFieldName   = 256
+	discordMaxFieldValue  = 1024
+	discordMaxContent     = 2000 // Characters of message content, used by report templates
+)
+
+// Embed colors by severity
+const (
+	discordColorCritical = 0xE74C3C
+	discordColorWarning  = 0xF39C12
+	discordColorInfo     = 0x3498DB
+)
+
+// Message posted to a Discord webhook
+type discordMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Fields      []discordEmbedField `json:"fields"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+	Footer      *discordEmbedFooter `json:"footer,omitempty"`
+}
+
+type discordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// Embed color for the worst severity present
+func discordColor(severity string) int {
+	switch severity {
+	case severityCritical:
+		return discordColorCritical
+	case severityWarning:
+		return discordColorWarning
+	}
+	return discordColorInfo
+}
+
+// Cut text to a maximum number of characters, ending with an ellipsis when
+// shortened. Counts runes so multi-byte characters are never split.
+func truncateRunes(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	if max < 1 {
+		return ""
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// One embed field per common failure cause, job and repository of a report
+func discordFields(report *AlertReport, config *Config) []discordEmbedField {
+	// Grouped reports list each group's jobs together, named after the group
+	jobs, groupOf := report.Jobs(), map[int]string{}
+	if len(report.Groups) > 0 {
+		jobs = nil
+		for _, group := range report.Groups {
+			for _, job := range group.Jobs {
+				groupOf[len(jobs)] = group.Name
+				jobs = append(jobs, job)
+			}
+		}
+	}
+
+	var fields []discordEmbedField
+	for _, cause := range report.Causes {
+		fields = append(fields, discordEmbedField{
+			Name:  truncateRunes(cause.summary(config), discordMaxFieldName),
+			Value: truncateRunes(cause.jobList(), discordMaxFieldValue),
+		})
+	}
+	for i, job := range jobs {
+		if report.inCause(job) {
+			continue
+		}
+		name := job.Name
+		if group, ok := groupOf[i]; ok {
+			name = fmt.Sprintf("[%s] %s", group, job.Name)
+		}
+		value := fmt.Sprintf("**Status:** %s\n%s%s**Type:** %s\n**Start:** %s\n**End:** %s\n%s%s%s",
+			displayStatus(job), escalationLine(job, "**Escalated:** failed %d checks in a row\n"), failingSinceLine(job, "**Failing Since:** %s\n"), job.JobType, job.StartTime, job.EndTime,
+			config.nextRunLine(job, "**Next Run:** %s\n"), noteLine(job, "**Note:** %s\n"), config.displayDescription(job.Description))
+		fields = append(fields, discordEmbedField{
+			Name:  truncateRunes(name, discordMaxFieldName),
+			Value: truncateRunes(value, discordMaxFieldValue),
+		})
+	}
+	for _, repo := range report.Repositories {
+		value := fmt.Sprintf("**Free:** %s of %s (%.1f%%)", formatGB(repo.FreeBytes), formatGB(repo.TotalBytes), repo.FreePercent())
+		fields = append(fields, discordEmbedField{
+			Name:  truncateRunes("Repository: "+repo.DisplayName(), discordMaxFieldName),
+			Value: truncateRunes(value, discordMaxFieldValue),
+		})
+	}
+	return fields
+}
+
+// Title of an alert in chat channels
+func alertTitle(report *AlertReport) string {
+	title := fmt.Sprintf("Veeam alert (%s): %d jobs need attention", report.Server, report.Counts.Total)
+	if report.Counts.Repositories > 0 {
+		title += fmt.Sprintf(", %d repositories low on space", report.Counts.Repositories)
+	}
+	return title
+}
+
+// Build the messages for an alert in the compact format, one line per
+// common failure cause, job and repository, split at Discord's content limit
+func buildCompactDiscordMessages(report *AlertReport, config *Config) []discordMessage {
+	lines := []string{"**" + alertTitle(report) + "**"}
+	for _, cause := range report.Causes {
+		lines = append(lines, fmt.Sprintf("**%s:** %s", cause.summary(config), cause.jobList()))
+	}
+	for _, job := range report.Jobs() {
+		if report.inCause(job) {
+			continue
+		}
+		line := compactJobLine(job, config, report.Timestamp)
+		if len(report.Groups) > 0 {
+			line = fmt.Sprintf("[%s] %s", config.jobGroup(job.Name), line)
+		}
+		lines = append(lines, line)
+	}
+	for _, repo := range report.Repositories {
+		lines = append(lines, compactRepositoryLine(repo))
+	}
+	lines = append(lines, omittedSummary(report)...)
+	if footer := alertFooter(report); footer != "" {
+		lines = append(lines, footer)
+	}
+
+	var messages []discordMessage
+	content := ""
+	for _, line := range lines {
+		line = truncateRunes(line, discordMaxContent)
+		if content != "" && len([]rune(content))+1+len([]rune(line)) > discordMaxContent {
+			messages = append(messages, discordMessage{Content: content})
+			content = ""
+		}
+		if content != "" {
+			content += "\n"
+		}
+		content += line
+	}
+	return append(messages, discordMessage{Content: content})
+}
+
+// Build the messages for an alert, with one field per job and repository,
+// split so no message exceeds Discord's embed, field and size limits
+func buildDiscordMessages(report *AlertReport, config *Config) []discordMessage {
+	fields := fitDiscordFields(report, config)
+
+	title := alertTitle(report)
+	timestamp := report.Timestamp.UTC().Format(time.RFC3339)
+
+	var messages []discordMessage
+	var message discordMessage
+	messageSize := 0
+	for len(fields) > 0 {
+		count := len(fields)
+		if count > discordMaxFields {
+			count = discordMaxFields
+		}
+
+		// Shrink the embed until it fits in the remaining message budget
+		embed := discordEmbed{Title: title, Color: discordColor(report.Severity), Timestamp: timestamp}
+		if footer := alertFooter(report); footer != "" {
+			embed.Footer = &discordEmbedFooter{Text: footer}
+		}
+		size := len([]rune(embed.Title))
+		if embed.Footer != nil {
+			size += len([]rune(embed.Footer.Text))
+		}
+		for i := 0; i < count; i++ {
+			fieldSize := len([]rune(fields[i].Name)) + len([]rune(fields[i].Value))
+			if size+fieldSize > discordMaxMessageSize {
+				break
+			}
+			embed.Fields = append(embed.Fields, fields[i])
+			size += fieldSize
+		}
+
+		// Start a new message when this one is full
+		if len(message.Embeds) == discordMaxEmbeds || messageSize+size > discordMaxMessageSize {
+			messages = append(messages, message)
+			message = discordMessage{}
+			messageSize = 0
+		}
+
+		message.Embeds = append(message.Embeds, embed)
+		messageSize += size
+		fields = fields[len(embed.Fields):]
+	}
+	if len(message.Embeds) > 0 {
+		messages = append(messages, message)
+	}
+
+	// Number the embeds when the alert is split
+	total := 0
+	for _, m := range messages {
+		total += len(m.Embeds)
+	}
+	if total > 1 {
+		n := 1
+		for i := range messages {
+			for j := range messages[i].Embeds {
+				messages[i].Embeds[j].Title += fmt.Sprintf(" (%d/%d)", n, total)
+				n++
+			}
+		}
+	}
+
+	return messages
+}
+
+// Post an alert to the Discord webhook, split across as many messages as needed
+func sendDiscordAlert(ctx context.Context, httpClient *http.Client, report *AlertReport, config *Config) error {
+	if name := config.channelTemplate("discord"); name != "" {
+		content, err := renderReportTemplate(report, config, name)
+		if err == nil {
+			return postDiscordMessage(ctx, httpClient, config.DiscordWebhookURL, discordMessage{Content: truncateRunes(content, discordMaxContent)})
+		}
+		log.Printf("Error rendering report template %s, sending the standard Discord alert: %v\n", name, err)
+	}
+	messages := buildDiscordMessages(report, config)
+	if config.compact() {
+		messages = buildCompactDiscordMessages(report, config)
+	}
+	for i, message := range messages {
+		if err := postDiscordMessage(ctx, httpClient, config.DiscordWebhookURL, message); err != nil {
+			return fmt.Errorf("error sending Discord message %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Post a single message to a Discord webhook
+func postDiscordMessage(ctx context.Context, httpClient *http.Client, webhookURL string, message discordMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return redactURLError(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return redactURLError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Discord returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// Footer of an alert's messages with its check cycle and fingerprint, empty
+// when it has neither
+func alertFooter(report *AlertReport) string {
+	var parts []string
+	if report.CycleID != "" {
+		parts = append(parts, "Check cycle "+report.CycleID)
+	}
+	if report.Fingerprint != "" {
+		parts = append(parts, "Fingerprint "+report.Fingerprint)
+	}
+	return strings.Join(parts, " · ")
+}
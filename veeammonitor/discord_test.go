@@ -0,0 +1,125 @@
+package veeammonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stand-in Discord webhook collecting the messages posted to it
+func newDiscordServer(t *testing.T) (string, func() []discordMessage) {
+	var mu sync.Mutex
+	var messages []discordMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message discordMessage
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("decoding message: %v", err)
+		}
+		mu.Lock()
+		messages = append(messages, message)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() []discordMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]discordMessage{}, messages...)
+	}
+}
+
+func TestDiscordAlertEmbed(t *testing.T) {
+	url, received := newDiscordServer(t)
+	config := testConfig()
+	config.DiscordWebhookURL = url
+	jobs := []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: "Disk full"}}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+
+	if err := sendDiscordAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+		t.Fatal(err)
+	}
+	messages := received()
+	if len(messages) != 1 || len(messages[0].Embeds) != 1 {
+		t.Fatalf("got %+v, want one message with one embed", messages)
+	}
+	embed := messages[0].Embeds[0]
+	if embed.Color != discordColorCritical || !strings.Contains(embed.Title, "1 jobs need attention") || embed.Timestamp == "" {
+		t.Errorf("got embed %+v", embed)
+	}
+	if len(embed.Fields) != 1 || embed.Fields[0].Name != "Nightly" ||
+		!strings.Contains(embed.Fields[0].Value, "**Status:** Failed") || !strings.Contains(embed.Fields[0].Value, "Disk full") {
+		t.Errorf("got fields %+v", embed.Fields)
+	}
+}
+
+func TestDiscordAlertSplitsLargeReports(t *testing.T) {
+	url, received := newDiscordServer(t)
+	config := testConfig()
+	config.DiscordWebhookURL = url
+	var jobs []JobStatus
+	for i := 0; i < 300; i++ {
+		jobs = append(jobs, JobStatus{Name: fmt.Sprintf("Job %03d", i), JobType: "Backup", Status: "Failed", Description: strings.Repeat("x", 250)})
+	}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+
+	if err := sendDiscordAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+		t.Fatal(err)
+	}
+	messages := received()
+	if len(messages) < 2 {
+		t.Fatalf("got %d messages, want the alert split", len(messages))
+	}
+
+	fields, embeds := 0, 0
+	for _, message := range messages {
+		if len(message.Embeds) > discordMaxEmbeds {
+			t.Errorf("message has %d embeds", len(message.Embeds))
+		}
+		size := 0
+		for _, embed := range message.Embeds {
+			embeds++
+			if len(embed.Fields) > discordMaxFields {
+				t.Errorf("embed has %d fields", len(embed.Fields))
+			}
+			size += len([]rune(embed.Title))
+			for _, field := range embed.Fields {
+				size += len([]rune(field.Name)) + len([]rune(field.Value))
+			}
+			fields += len(embed.Fields)
+		}
+		if size > discordMaxMessageSize {
+			t.Errorf("message has %d characters", size)
+		}
+	}
+	if fields != len(jobs) {
+		t.Errorf("sent %d fields, want one per job", fields)
+	}
+	if last := messages[len(messages)-1].Embeds; !strings.HasSuffix(last[len(last)-1].Title, fmt.Sprintf("(%d/%d)", embeds, embeds)) {
+		t.Errorf("last embed is titled %q, want it numbered %d/%d", last[len(last)-1].Title, embeds, embeds)
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		text string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"eleven char", 10, "eleven ch…"},
+		{"ééééé", 3, "éé…"},
+		{"anything", 0, ""},
+	}
+	for _, test := range tests {
+		if got := truncateRunes(test.text, test.max); got != test.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", test.text, test.max, got, test.want)
+		}
+	}
+}
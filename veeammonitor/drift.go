@@ -0,0 +1,127 @@
+package veeammonitor
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status of jobs whose last run started far from its scheduled time
+const driftStatus = "Drift"
+
+// JobSchedule is the time of day a job is scheduled to start and when its
+// last session actually started
+type JobSchedule struct {
+	Name      string
+	JobType   string
+	Scheduled string    // Time of day, e.g. 22:00; empty for jobs without a fixed start time
+	LastStart time.Time // Zero when the job has never run
+}
+
+// ScheduleReporter is implemented by sources that can report job schedules
+// for ScheduleDriftMinutes
+type ScheduleReporter interface {
+	JobSchedules() ([]JobSchedule, error)
+}
+
+// Minutes a run started after its scheduled time of day, negative when it
+// started early, taking whichever of the day's neighbouring occurrences is
+// nearer so a 23:50 schedule started at 00:10 is 20 minutes late
+func scheduleDrift(scheduled time.Time, started time.Time) int {
+	minutes := func(t time.Time) int { return t.Hour()*60 + t.Minute() }
+	drift := (minutes(started) - minutes(scheduled) + 24*60) % (24 * 60)
+	if drift >= 12*60 {
+		drift -= 24 * 60
+	}
+	return drift
+}
+
+// Drift jobs for the schedules whose last run started more than
+// ScheduleDriftMinutes from its scheduled time of day. Jobs that run
+// periodically, continuously, after another job or only manually have no
+// scheduled time and are skipped, as are jobs that never ran.
+func scheduleDriftJobs(schedules []JobSchedule, config *Config) []JobStatus {
+	var drifted []JobStatus
+	for _, schedule := range schedules {
+		if schedule.Scheduled == "" || schedule.LastStart.IsZero() {
+			continue
+		}
+		scheduled, err := time.Parse("15:04", schedule.Scheduled)
+		if err != nil {
+			continue
+		}
+
+		drift := scheduleDrift(scheduled, schedule.LastStart)
+		if drift <= config.ScheduleDriftMinutes && -drift <= config.ScheduleDriftMinutes {
+			continue
+		}
+		direction := "after"
+		if drift < 0 {
+			direction, drift = "before", -drift
+		}
+		drifted = append(drifted, JobStatus{
+			Name:      schedule.Name,
+			Status:    driftStatus,
+			StartTime: config.displayTime(schedule.LastStart.Format(time.RFC3339)),
+			JobType:   schedule.JobType,
+			Description: fmt.Sprintf("Last run started at %s, %d minutes %s its %s schedule (threshold %d minutes)",
+				schedule.LastStart.Format("15:04"), drift, direction, schedule.Scheduled, config.ScheduleDriftMinutes),
+		})
+	}
+	return drifted
+}
+
+// Get the daily or monthly start time of every backup job and when its last
+// session started, in the Veeam server's time zone
+func (m *Monitor) getJobSchedules() ([]JobSchedule, error) {
+	query := fmt.Sprintf(`
+		Get-VBRJob | ForEach-Object {
+			$options = $_.ScheduleOptions
+			$scheduled = ""
+			if ($_.IsScheduleEnabled -and $options) {
+				if ($options.OptionsDaily.Enabled) { $scheduled = $options.OptionsDaily.TimeLocal.ToString("HH:mm") }
+				elseif ($options.OptionsMonthly.Enabled) { $scheduled = $options.OptionsMonthly.TimeLocal.ToString("HH:mm") }
+			}
+			$session = $_.FindLastSession()
+			[pscustomobject]@{Name=$_.Name; Scheduled=$scheduled; LastStart=$(if ($session) { ([datetimeoffset]$session.CreationTime).ToString("o") } else { "" })}
+		} | %s
+	`, convertToCsv(m.Config))
+
+	output, err := m.runVeeamScript(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute PowerShell command for job schedules: %w", err)
+	}
+	return parseJobScheduleOutput(output, m.Config.csvDelimiter())
+}
+
+// Parse the job schedule CSV output from PowerShell
+func parseJobScheduleOutput(output string, delimiter rune) ([]JobSchedule, error) {
+	reader := csv.NewReader(strings.NewReader(cleanCSVOutput(output)))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, &ParseError{"job schedule output", err}
+	}
+	if len(records) < 2 {
+		return []JobSchedule{}, nil
+	}
+
+	var schedules []JobSchedule
+	// Skip header line and process data lines
+	for _, record := range records[1:] {
+		if len(record) < 3 {
+			continue
+		}
+		schedule := JobSchedule{Name: record[0], JobType: jobTypeLabels["backup"], Scheduled: strings.TrimSpace(record[1])}
+		if start := strings.TrimSpace(record[2]); start != "" {
+			schedule.LastStart, err = time.Parse(time.RFC3339, start)
+			if err != nil {
+				return nil, &ParseError{"job schedule output", fmt.Errorf("invalid last start %q for job %s", start, record[0])}
+			}
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
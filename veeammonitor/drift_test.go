@@ -0,0 +1,105 @@
+package veeammonitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleDrift(t *testing.T) {
+	tests := []struct {
+		scheduled, started string
+		want               int
+	}{
+		{"22:00", "22:00", 0},
+		{"22:00", "22:20", 20},
+		{"22:00", "21:40", -20},
+		{"23:50", "00:10", 20},
+		{"00:10", "23:50", -20},
+		{"22:00", "04:00", 360},
+		{"04:00", "22:00", -360},
+	}
+	for _, test := range tests {
+		scheduled, _ := time.Parse("15:04", test.scheduled)
+		started, _ := time.Parse("15:04", test.started)
+		if got := scheduleDrift(scheduled, started); got != test.want {
+			t.Errorf("scheduled %s, started %s: got drift %d, want %d", test.scheduled, test.started, got, test.want)
+		}
+	}
+}
+
+func TestScheduleDriftJobs(t *testing.T) {
+	config := testConfig()
+	config.ScheduleDriftMinutes = 60
+	started := func(clock string) time.Time {
+		start, _ := time.Parse(time.RFC3339, "2024-03-01T"+clock+":00Z")
+		return start
+	}
+	schedules := []JobSchedule{
+		{Name: "OnTime", JobType: "Backup", Scheduled: "22:00", LastStart: started("22:05")},
+		{Name: "AtThreshold", JobType: "Backup", Scheduled: "22:00", LastStart: started("23:00")},
+		{Name: "Chained", JobType: "Backup", Scheduled: "22:00", LastStart: started("04:00")},
+		{Name: "Early", JobType: "Backup", Scheduled: "22:00", LastStart: started("20:30")},
+		{Name: "Periodic", JobType: "Backup", LastStart: started("04:00")},
+		{Name: "NeverRan", JobType: "Backup", Scheduled: "22:00"},
+		{Name: "BadSchedule", JobType: "Backup", Scheduled: "late", LastStart: started("04:00")},
+	}
+
+	drifted := scheduleDriftJobs(schedules, config)
+	if names := jobNames(drifted); !equalStrings(names, []string{"Chained", "Early"}) {
+		t.Fatalf("got drifted jobs %v, want Chained and Early", names)
+	}
+	want := []string{
+		"Last run started at 04:00, 360 minutes after its 22:00 schedule (threshold 60 minutes)",
+		"Last run started at 20:30, 90 minutes before its 22:00 schedule (threshold 60 minutes)",
+	}
+	for i, job := range drifted {
+		if job.Status != driftStatus || job.JobType != "Backup" || job.Description != want[i] {
+			t.Errorf("got %+v, want description %q", job, want[i])
+		}
+	}
+}
+
+func TestParseJobScheduleOutput(t *testing.T) {
+	output := `"Name","Scheduled","LastStart"` + "\n" +
+		`"Nightly","22:00","2024-03-02T04:00:00.0000000+01:00"` + "\n" +
+		`"Periodic","","2024-03-02T01:00:00.0000000+01:00"` + "\n" +
+		`"New","22:00",""` + "\n"
+	schedules, err := parseJobScheduleOutput(output, ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 3 {
+		t.Fatalf("got schedules %+v, want 3", schedules)
+	}
+	if nightly := schedules[0]; nightly.Scheduled != "22:00" || nightly.JobType != "Backup" || nightly.LastStart.Format("15:04") != "04:00" {
+		t.Errorf("got %+v, want the start in the server's time zone", nightly)
+	}
+	if schedules[1].Scheduled != "" || !schedules[2].LastStart.IsZero() {
+		t.Errorf("got %+v, want the missing schedule and start left empty", schedules[1:])
+	}
+
+	if _, err := parseJobScheduleOutput(`"Name","Scheduled","LastStart"`+"\n"+`"Nightly","22:00","yesterday"`+"\n", ','); err == nil {
+		t.Error("accepted an invalid last start")
+	}
+}
+
+func TestCheckCycleReportsDrift(t *testing.T) {
+	config := testConfig()
+	config.ScheduleDriftMinutes = 60
+	m, capture := newCaptureMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		if strings.Contains(script, "ScheduleOptions") {
+			return `"Name","Scheduled","LastStart"` + "\n" +
+				`"Nightly","22:00","2024-03-02T04:00:00.0000000+01:00"` + "\n" +
+				`"Hourly","","2024-03-02T04:00:00.0000000+01:00"` + "\n" +
+				`"Files","22:00","2024-03-01T22:10:00.0000000+01:00"` + "\n", "", nil
+		}
+		return jobCSVHeader, "", nil
+	}))
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 || len(sent[0].Drift) != 1 || sent[0].Drift[0].Name != "Nightly" {
+		t.Fatalf("sent %+v, want an alert for Nightly only", sent)
+	}
+}
@@ -0,0 +1,863 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Values available to EmailSubjectTemplate
+type subjectData struct {
+	Total        int // Problematic jobs
+	Failed       int
+	Warning      int
+	Stuck        int // Running sessions that stopped making progress
+	Disabled     int // CriticalJobs that are disabled
+	Missing      int // ExpectedJobs that don't exist
+	Running      int
+	Stale        int
+	Deviation    int // Jobs whose backup size deviates from their baseline
+	Drift        int // Jobs whose last run started far from its scheduled time
+	Repositories int // Repositories low on free space
+	Server       string
+	Severity     string
+	Timestamp    time.Time
+	Client       string // ClientMapping client of a client's own email, empty otherwise
+}
+
+// Subject used when no template is configured
+func defaultEmailSubject(data subjectData) string {
+	subject := fmt.Sprintf("ALERT: %d Veeam Backup Jobs Need Attention", data.Total)
+	if data.Total == 0 {
+		subject = fmt.Sprintf("ALERT: %d Veeam Repositories Low on Free Space", data.Repositories)
+	} else if data.Repositories > 0 {
+		subject += fmt.Sprintf(", %d Repositories Low on Free Space", data.Repositories)
+	}
+	if data.Client != "" {
+		subject += " for " + data.Client
+	}
+	return subject
+}
+
+// Render the alert subject from EmailSubjectTemplate, falling back to the
+// default subject if the template can't be parsed or executed
+func renderEmailSubject(config *Config, data subjectData) string {
+	if config.EmailSubjectTemplate == "" {
+		return defaultEmailSubject(data)
+	}
+
+	tmpl, err := template.New("subject").Parse(config.EmailSubjectTemplate)
+	if err != nil {
+		log.Printf("Error parsing email subject template, using default subject: %v\n", err)
+		return defaultEmailSubject(data)
+	}
+
+	var subject bytes.Buffer
+	if err := tmpl.Execute(&subject, data); err != nil {
+		log.Printf("Error rendering email subject template, using default subject: %v\n", err)
+		return defaultEmailSubject(data)
+	}
+
+	// Header values can't span lines
+	return strings.Join(strings.Fields(subject.String()), " ")
+}
+
+// Send email alert for problematic jobs and repositories low on space
+func sendEmailAlert(ctx context.Context, httpClient *http.Client, report *AlertReport, config *Config) error {
+	subject, body, html := emailAlertContent(report, config)
+
+	var attachments []emailAttachment
+	if config.AttachCSV && report.Counts.Total > 0 {
+		attachment, err := csvAttachment(report, config)
+		if err != nil {
+			return err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if config.MaxMessageBytes > 0 && emailSize(body+html, attachments) > config.MaxMessageBytes {
+		subject, body, html, attachments = fitEmailAlert(report, config)
+	}
+	return sendHTMLEmail(ctx, httpClient, config, subject, body, html, attachments...)
+}
+
+// Subject, plain text body and, with HTMLEmail, HTML body of an alert email.
+// An HTML template that fails is logged and the email sent as plain text.
+func emailAlertContent(report *AlertReport, config *Config) (subject, body, html string) {
+	subject, body = buildEmailBody(report, config)
+	if config.HTMLEmail {
+		var err error
+		if html, err = renderHTMLEmail(report, config, subject); err != nil {
+			log.Printf("Error rendering HTML email, sending plain text only: %v\n", err)
+		}
+	}
+	return subject, body, html
+}
+
+// CSV attachment listing every job of a report
+func csvAttachment(report *AlertReport, config *Config) (emailAttachment, error) {
+	data, err := problematicJobsCSV(report.Jobs(), config)
+	if err != nil {
+		return emailAttachment{}, fmt.Errorf("error building CSV attachment: %v", err)
+	}
+	return emailAttachment{
+		Filename:    fmt.Sprintf("veeam-problematic-jobs-%s.csv", report.Timestamp.Format("2006-01-02-1504")),
+		ContentType: "text/csv",
+		Data:        data,
+	}, nil
+}
+
+// Render the alert subject and plain text body from a report, one section
+// per status. Nothing is sent, so other outputs can reuse the same text.
+func buildEmailBody(report *AlertReport, config *Config) (subject, body string) {
+	subject = emailSubject(report, config)
+	lowSpaceRepos := report.Repositories
+
+	// Build email body
+	body = "Veeam Backup & Replication Job Status Report\n"
+	body += "===========================================\n\n"
+	if report.Client != "" {
+		body += fmt.Sprintf("Hello %s,\n\nThis report lists only your backup jobs.\n\n", report.Client)
+	}
+	body += fmt.Sprintf("Severity: %s\n\n", strings.ToUpper(report.Severity))
+
+	if len(report.Causes) > 0 {
+		body += fmt.Sprintf("COMMON FAILURE CAUSES (%d):\n", len(report.Causes))
+		body += "-------------------------\n"
+		for _, cause := range report.Causes {
+			body += fmt.Sprintf("%s\nJobs: %s\n\n", cause.summary(config), cause.jobList())
+		}
+		body += "\n"
+	}
+
+	if len(report.Groups) > 0 {
+		for _, group := range report.Groups {
+			heading := "GROUP " + group.summary()
+			groupReport := group.report()
+			groupReport.Causes = report.Causes
+			body += heading + "\n" + strings.Repeat("=", len([]rune(heading))) + "\n\n"
+			body += strings.TrimRight(emailJobSections(groupReport, config), "\n") + "\n\n\n"
+		}
+	} else {
+		body += emailJobSections(report, config)
+	}
+
+	if len(lowSpaceRepos) > 0 {
+		if (report.Counts.Running > 0 || report.Counts.Stale > 0 || report.Counts.Deviation > 0 || report.Counts.Drift > 0) && len(report.Groups) == 0 && !config.compact() {
+			body += "\n"
+		}
+		body += fmt.Sprintf("REPOSITORIES LOW ON FREE SPACE (%d, threshold %d%%):\n", len(lowSpaceRepos), report.RepositoryThresholdPercent)
+		body += "------------------------------\n"
+		for _, repo := range lowSpaceRepos {
+			if config.compact() {
+				body += compactRepositoryLine(repo) + "\n"
+				continue
+			}
+			body += fmt.Sprintf("Repository: %s\nUsed: %s\nFree: %s of %s (%.1f%%)\n\n",
+				repo.DisplayName(), formatGB(repo.UsedBytes()), formatGB(repo.FreeBytes), formatGB(repo.TotalBytes), repo.FreePercent())
+		}
+	}
+
+	body += "\nThis is an automated message from the Veeam Backup Monitor.\n"
+	if report.CycleID != "" {
+		body += fmt.Sprintf("Check cycle: %s\n", report.CycleID)
+	}
+	if report.Fingerprint != "" {
+		body += fmt.Sprintf("Alert fingerprint: %s\n", report.Fingerprint)
+	}
+	return subject, body
+}
+
+// Alert email subject for a report, from EmailSubjectTemplate when set
+func emailSubject(report *AlertReport, config *Config) string {
+	return renderEmailSubject(config, subjectData{
+		Total:        report.Counts.Total,
+		Failed:       report.Counts.Failed,
+		Warning:      report.Counts.Warning,
+		Stuck:        report.Counts.Stuck,
+		Disabled:     report.Counts.Disabled,
+		Missing:      report.Counts.Missing,
+		Running:      report.Counts.Running,
+		Stale:        report.Counts.Stale,
+		Deviation:    report.Counts.Deviation,
+		Drift:        report.Counts.Drift,
+		Repositories: report.Counts.Repositories,
+		Server:       report.Server,
+		Severity:     report.Severity,
+		Timestamp:    report.Timestamp,
+		Client:       report.Client,
+	})
+}
+
+// Render a report's jobs as plain text, one section per status or one line
+// per job in the compact format. Jobs listed under a common failure cause are
+// left out.
+func emailJobSections(report *AlertReport, config *Config) string {
+	if config.compact() {
+		return compactJobSections(report, config)
+	}
+	failedJobs, warningJobs, runningJobs, staleJobs := report.withoutCauses(report.Failed), report.withoutCauses(report.Warning), report.Running, report.Stale
+
+	// Truncated reports still have a section for every status, ending with
+	// how many jobs were left out
+	omitted, hint := report.omitted, report.omittedHint
+	runningCount, staleCount := len(runningJobs)+omitted.Running, len(staleJobs)+omitted.Stale
+
+	body := ""
+	if len(failedJobs)+omitted.Failed > 0 {
+		body += fmt.Sprintf("FAILED JOBS (%d):\n", len(failedJobs)+omitted.Failed)
+		body += "--------------\n"
+		for _, job := range failedJobs {
+			body += fmt.Sprintf("Job: %s\nType: %s\nStatus: %s\n%s%sStart Time: %s\nEnd Time: %s\n%sDescription: %s\n%s\n",
+				job.Name, job.JobType, displayStatus(job), escalationLine(job, "Escalated: failed %d checks in a row\n"), failingSinceLine(job, "Failing Since: %s\n"),
+				job.StartTime, job.EndTime, config.nextRunLine(job, "Next Run: %s\n"), config.displayDescription(job.Description), noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Failed, "failed", hint, "%s\n\n")
+		body += "\n"
+	}
+
+	if len(warningJobs)+omitted.Warning > 0 {
+		body += fmt.Sprintf("WARNING JOBS (%d):\n", len(warningJobs)+omitted.Warning)
+		body += "----------------\n"
+		for _, job := range warningJobs {
+			body += fmt.Sprintf("Job: %s\nType: %s\nStatus: %s\nStart Time: %s\nEnd Time: %s\n%sDescription: %s\n%s\n",
+				job.Name, job.JobType, job.Status, job.StartTime, job.EndTime, config.nextRunLine(job, "Next Run: %s\n"), config.displayDescription(job.Description),
+				noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Warning, "warning", hint, "%s\n\n")
+		body += "\n"
+	}
+
+	if len(report.Stuck)+omitted.Stuck > 0 {
+		body += fmt.Sprintf("STUCK SESSIONS (%d):\n", len(report.Stuck)+omitted.Stuck)
+		body += "---------------\n"
+		for _, job := range report.Stuck {
+			body += fmt.Sprintf("Job: %s\nType: %s\nStatus: %s\nStart Time: %s\nDescription: %s\n%s\n",
+				job.Name, job.JobType, job.Status, job.StartTime, config.displayDescription(job.Description), noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Stuck, "stuck", hint, "%s\n\n")
+		body += "\n"
+	}
+
+	if len(report.Disabled)+omitted.Disabled > 0 {
+		body += fmt.Sprintf("DISABLED CRITICAL JOBS (%d):\n", len(report.Disabled)+omitted.Disabled)
+		body += "----------------------\n"
+		for _, job := range report.Disabled {
+			body += fmt.Sprintf("Job: %s\nType: %s\nLast End Time: %s\nDescription: %s\n%s\n",
+				job.Name, job.JobType, job.EndTime, config.displayDescription(job.Description), noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Disabled, "disabled critical", hint, "%s\n\n")
+		body += "\n"
+	}
+
+	if len(report.Missing)+omitted.Missing > 0 {
+		body += fmt.Sprintf("MISSING EXPECTED JOBS (%d):\n", len(report.Missing)+omitted.Missing)
+		body += "---------------------\n"
+		for _, job := range report.Missing {
+			body += fmt.Sprintf("Job: %s\nDescription: %s\n%s\n", job.Name, config.displayDescription(job.Description), noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Missing, "missing expected", hint, "%s\n\n")
+		body += "\n"
+	}
+
+	if runningCount > 0 {
+		body += fmt.Sprintf("LONG-RUNNING JOBS (%d):\n", runningCount)
+		body += "---------------------\n"
+		for _, job := range runningJobs {
+			durationText := ""
+			if job.Duration != "" {
+				durationMin := strings.SplitN(job.Duration, ".", 2)[0]
+				durationText = fmt.Sprintf(" (Running for %s minutes)", durationMin)
+			}
+
+			body += fmt.Sprintf("Job: %s\nType: %s\nStatus: %s%s\nStart Time: %s\nDescription: %s\n%s\n",
+				job.Name, job.JobType, job.Status, durationText, job.StartTime, config.displayDescription(job.Description), noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Running, "long-running", hint, "%s\n\n")
+	}
+
+	if staleCount > 0 {
+		if runningCount > 0 {
+			body += "\n"
+		}
+		body += fmt.Sprintf("JOBS THAT HAVEN'T RUN (%d):\n", staleCount)
+		body += "-------------------------\n"
+		for _, job := range staleJobs {
+			lastRun := job.EndTime
+			if lastRun == "" {
+				lastRun = job.StartTime
+			}
+			if lastRun == "" {
+				lastRun = "never"
+			}
+			body += fmt.Sprintf("Job: %s\nType: %s\nLast Run: %s\n%sDescription: %s\n%s\n",
+				job.Name, job.JobType, lastRun, config.nextRunLine(job, "Next Run: %s\n"), config.displayDescription(job.Description), noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Stale, "stale", hint, "%s\n\n")
+	}
+
+	if len(report.Deviation)+omitted.Deviation > 0 {
+		if runningCount > 0 || staleCount > 0 {
+			body += "\n"
+		}
+		body += fmt.Sprintf("BACKUP SIZE DEVIATIONS (%d):\n", len(report.Deviation)+omitted.Deviation)
+		body += "----------------------\n"
+		for _, job := range report.Deviation {
+			body += fmt.Sprintf("Job: %s\nType: %s\nSession End: %s\nDescription: %s\n%s\n",
+				job.Name, job.JobType, job.EndTime, config.displayDescription(job.Description), noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Deviation, "size deviation", hint, "%s\n\n")
+	}
+
+	if len(report.Drift)+omitted.Drift > 0 {
+		if runningCount > 0 || staleCount > 0 || len(report.Deviation)+omitted.Deviation > 0 {
+			body += "\n"
+		}
+		body += fmt.Sprintf("SCHEDULE DRIFT (%d):\n", len(report.Drift)+omitted.Drift)
+		body += "--------------\n"
+		for _, job := range report.Drift {
+			body += fmt.Sprintf("Job: %s\nType: %s\nStart Time: %s\nDescription: %s\n%s\n",
+				job.Name, job.JobType, job.StartTime, config.displayDescription(job.Description), noteLine(job, "Note: %s\n"))
+		}
+		body += omittedLine(omitted.Drift, "schedule drift", hint, "%s\n\n")
+	}
+
+	return body
+}
+
+// Channel email is sent through, for metrics and logs
+func (c *Config) emailChannel() string {
+	if c.SESEnabled {
+		return "ses"
+	}
+	return "email"
+}
+
+// Name used for the Veeam server in alerts
+func serverDisplayName(config *Config) string {
+	if config.serverName != "" {
+		return config.serverName
+	}
+	return serverAddress(config)
+}
+
+// Address of the Veeam server, localhost when none is set
+func serverAddress(config *Config) string {
+	if config.VeeamServerAddress == "" {
+		return "localhost"
+	}
+	return config.VeeamServerAddress
+}
+
+// Time allowed to connect to an SMTP server
+const smtpDialTimeout = 10 * time.Second
+
+// File attached to an email
+type emailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Send a plain-text email to all configured recipients. Cancelling ctx
+// abandons the send.
+func sendEmail(ctx context.Context, httpClient *http.Client, config *Config, subject, body string, attachments ...emailAttachment) error {
+	return sendHTMLEmail(ctx, httpClient, config, subject, body, "", attachments...)
+}
+
+// Send an email with an HTML body besides the plain text one, for mail
+// clients to pick from. An empty html sends plain text only.
+func sendHTMLEmail(ctx context.Context, httpClient *http.Client, config *Config, subject, body, html string, attachments ...emailAttachment) error {
+	// Prepare email message
+	msg, err := buildEmailMessage(config, subject, body, html, attachments)
+	if err != nil {
+		return err
+	}
+	if config.SESEnabled {
+		return sendSESEmail(ctx, httpClient, config, msg)
+	}
+
+	// Try the primary relay, then the fallback if the primary can't be reached
+	relays := smtpRelays(config)
+	for i, relay := range relays {
+		err = sendViaRelay(ctx, httpClient, config, relay, msg)
+		if err == nil {
+			if i > 0 {
+				log.Printf("Email delivered via fallback SMTP server %s\n", relay.Server)
+			}
+			return nil
+		}
+
+		// Cancelling closed the connection, which isn't the relay's fault
+		if ctx.Err() != nil {
+			return fmt.Errorf("email not sent: %v", ctx.Err())
+		}
+		if i+1 < len(relays) && isConnectionError(err) {
+			log.Printf("Error connecting to SMTP server %s: %v. Trying fallback server %s\n", relay.Server, err, relays[i+1].Server)
+			continue
+		}
+		return err
+	}
+	return err
+}
+
+// SMTP server used to deliver mail
+type smtpRelay struct {
+	Server   string
+	Port     int
+	Password string
+	OAuth    bool // Authenticate with XOAUTH2 instead of Password
+}
+
+// Primary SMTP relay followed by the fallback, if one is configured
+func smtpRelays(config *Config) []smtpRelay {
+	relays := []smtpRelay{{
+		Server:   config.SMTPServer,
+		Port:     config.smtpPort(),
+		Password: config.EmailPassword,
+		OAuth:    config.OAuthTokenURL != "",
+	}}
+
+	if config.SMTPServerFallback != "" {
+		port := config.SMTPPortFallback
+		if port == 0 {
+			port = config.smtpPort()
+		}
+		relays = append(relays, smtpRelay{
+			Server:   config.SMTPServerFallback,
+			Port:     port,
+			Password: config.EmailPasswordFallback,
+		})
+	}
+	return relays
+}
+
+// Send a prepared message through one relay
+func sendViaRelay(ctx context.Context, httpClient *http.Client, config *Config, relay smtpRelay, msg []byte) error {
+	addr, host, err := smtpAddress(relay.Server, relay.Port)
+	if err != nil {
+		return err
+	}
+	// Authentication checks the server name without the port
+	relay.Server = host
+
+	// Connect to SMTP server
+	auth, err := smtpAuth(httpClient, config, relay)
+	if err != nil {
+		return err
+	}
+
+	deliver := func(auth smtp.Auth) error {
+		if config.SendPerRecipient && len(config.EmailTo) > 1 {
+			return sendPerRecipient(ctx, config, addr, host, auth, msg)
+		}
+		return sendSMTP(ctx, config, addr, host, auth, config.EmailTo, msg)
+	}
+
+	// Send the email
+	err = deliver(auth)
+	if relay.OAuth && errors.Is(err, errSMTPAuth) && ctx.Err() == nil {
+		log.Printf("SMTP server rejected the OAuth2 token, retrying with a new one: %v\n", err)
+		smtpTokenCache.Invalidate()
+		if auth, err = smtpAuth(httpClient, config, relay); err != nil {
+			return err
+		}
+		err = deliver(auth)
+	}
+	return err
+}
+
+// Returned when the SMTP server rejects the credentials
+var errSMTPAuth = errors.New("SMTP authentication failed")
+
+// Deliver a message in one SMTP transaction, as smtp.SendMail does, over a
+// connection encrypted as configured
+func sendSMTP(ctx context.Context, config *Config, addr, host string, auth smtp.Auth, to []string, msg []byte) error {
+	client, _, err := dialSMTP(ctx, config, addr, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("%w: %v", errSMTPAuth, err)
+		}
+	}
+	if err := client.Mail(config.EmailFrom); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(msg); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Connect to an SMTP server and say hello, encrypting the connection with
+// implicit TLS when SMTPTLS is set and otherwise with STARTTLS when the
+// server offers it, which SMTPStartTLS requires. Returns what was negotiated.
+// Cancelling ctx closes the connection, failing the command in progress.
+func dialSMTP(ctx context.Context, config *Config, addr, host string) (*smtp.Client, []string, error) {
+	tlsConfig, err := smtpTLSConfig(config, host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	steps := []string{"connected to " + addr}
+	if config.SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+		steps[0] += " over TLS"
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	context.AfterFunc(ctx, func() { conn.Close() })
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := client.Hello("localhost"); err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	if config.SMTPTLS {
+		return client, steps, nil
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, nil, fmt.Errorf("STARTTLS failed: %v", err)
+		}
+		steps = append(steps, "STARTTLS")
+	} else if config.SMTPStartTLS {
+		client.Close()
+		return nil, nil, fmt.Errorf("%s doesn't offer STARTTLS, which smtpStartTLS requires", addr)
+	}
+	return client, steps, nil
+}
+
+// TLS settings for an SMTP server named host. Its certificate is checked
+// against SMTPCAFile when set and the system's roots otherwise, unless
+// SMTPInsecureSkipVerify turns checking off.
+func smtpTLSConfig(config *Config, host string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.SMTPInsecureSkipVerify,
+	}
+	if config.SMTPCAFile != "" {
+		data, err := ioutil.ReadFile(config.SMTPCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SMTP CA file: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates in %s", config.SMTPCAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	return tlsConfig, nil
+}
+
+// SMTP port, defaulting by encryption when SMTPPort isn't set
+func (c *Config) smtpPort() int {
+	if c.SMTPPort > 0 {
+		return c.SMTPPort
+	}
+	return c.defaultSMTPPort()
+}
+
+// Usual port of the configured SMTP encryption
+func (c *Config) defaultSMTPPort() int {
+	switch {
+	case c.SMTPTLS:
+		return 465
+	case c.SMTPStartTLS:
+		return 587
+	}
+	return 25
+}
+
+// SMTP encryption in words, for logs
+func (c *Config) smtpEncryption() string {
+	switch {
+	case c.SMTPTLS:
+		return "over TLS"
+	case c.SMTPStartTLS:
+		return "with STARTTLS"
+	}
+	return "without required encryption"
+}
+
+// Deliver a message to each recipient in its own SMTP transaction, so one
+// rejected address doesn't stop the others getting it. Succeeds when any
+// delivery did, otherwise returns every recipient's error.
+func sendPerRecipient(ctx context.Context, config *Config, addr, host string, auth smtp.Auth, msg []byte) error {
+	var failures []interface{}
+	for _, to := range config.EmailTo {
+		if err := sendSMTP(ctx, config, addr, host, auth, []string{to}, msg); err != nil {
+			// The remaining recipients won't get it either
+			if ctx.Err() != nil {
+				return fmt.Errorf("%s: %w", to, err)
+			}
+			log.Printf("Error delivering email to %s: %v\n", to, err)
+			failures = append(failures, fmt.Errorf("%s: %w", to, err))
+			continue
+		}
+		log.Printf("Email delivered to %s\n", to)
+	}
+
+	// Every recipient's error is wrapped, so connection errors still make
+	// sendEmail try the fallback relay
+	if len(failures) == len(config.EmailTo) {
+		format := "delivery failed for every recipient: " + strings.TrimSuffix(strings.Repeat("%w; ", len(failures)), "; ")
+		return fmt.Errorf(format, failures...)
+	}
+	if len(failures) > 0 {
+		log.Printf("Warning: Email delivered to %d of %d recipients\n", len(config.EmailTo)-len(failures), len(config.EmailTo))
+	}
+	return nil
+}
+
+// Connect to a relay, negotiate TLS as configured and authenticate, without
+// sending a message. Returns what was negotiated.
+func verifyRelay(httpClient *http.Client, config *Config, relay smtpRelay) (string, error) {
+	addr, host, err := smtpAddress(relay.Server, relay.Port)
+	if err != nil {
+		return "", err
+	}
+	relay.Server = host
+
+	client, steps, err := dialSMTP(context.Background(), config, addr, host)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	auth, err := smtpAuth(httpClient, config, relay)
+	if err != nil {
+		return "", err
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return "", fmt.Errorf("server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return "", fmt.Errorf("authentication failed: %v", err)
+		}
+		steps = append(steps, "authenticated")
+	}
+	client.Quit()
+	return strings.Join(steps, ", "), nil
+}
+
+// Dial address and host name of an SMTP server. The server may be a host
+// name, an IPv4 or IPv6 address (bracketed or not) or include its own port,
+// which then wins over port.
+func smtpAddress(server string, port int) (addr, host string, err error) {
+	server = strings.TrimSpace(server)
+	host = server
+	if h, p, splitErr := net.SplitHostPort(server); splitErr == nil {
+		n, convErr := strconv.Atoi(p)
+		if convErr != nil || n < 1 || n > 65535 {
+			return "", "", fmt.Errorf("invalid port in SMTP server %q", server)
+		}
+		host, port = h, n
+	} else if strings.HasPrefix(server, "[") && strings.HasSuffix(server, "]") {
+		host = server[1 : len(server)-1]
+	} else if strings.Contains(server, ":") && net.ParseIP(server) == nil {
+		return "", "", fmt.Errorf("invalid SMTP server %q", server)
+	}
+
+	if host == "" || strings.ContainsAny(host, " /[]") {
+		return "", "", fmt.Errorf("invalid SMTP server %q", server)
+	}
+	if port < 1 || port > 65535 {
+		return "", "", fmt.Errorf("invalid SMTP port %d", port)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), host, nil
+}
+
+// Whether an SMTP error means the server couldn't be reached at all, as
+// opposed to the server rejecting the message
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Build the message: plain text alone, text and HTML as alternatives of
+// each other, and multipart/mixed around either with attachments
+func buildEmailMessage(config *Config, subject, body, html string, attachments []emailAttachment) ([]byte, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", config.EmailFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(config.EmailTo, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+
+	if html == "" && len(attachments) == 0 {
+		msg.WriteString("\r\n")
+		msg.WriteString(body)
+		return msg.Bytes(), nil
+	}
+
+	header := textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	}
+	content := []byte(body)
+	if html != "" {
+		var err error
+		if header, content, err = alternativeBodies(body, html); err != nil {
+			return nil, err
+		}
+	}
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		for _, key := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+			if value := header.Get(key); value != "" {
+				fmt.Fprintf(&msg, "%s: %s\r\n", key, value)
+			}
+		}
+		msg.WriteString("\r\n")
+		msg.Write(content)
+		return msg.Bytes(), nil
+	}
+
+	writer := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", writer.Boundary())
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(content); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf("%s; name=%q", attachment.ContentType, attachment.Filename)},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachment.Filename)},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(base64Lines(attachment.Data)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// The plain text and HTML bodies as a multipart/alternative entity. HTML is
+// quoted-printable, as its lines may be longer than SMTP allows.
+func alternativeBodies(body, html string) (textproto.MIMEHeader, []byte, error) {
+	var content bytes.Buffer
+	writer := multipart.NewWriter(&content)
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := part.Write([]byte(body)); err != nil {
+		return nil, nil, err
+	}
+
+	part, err = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	encoder := quotedprintable.NewWriter(part)
+	if _, err := encoder.Write([]byte(html)); err != nil {
+		return nil, nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=%q", writer.Boundary())}}
+	return header, content.Bytes(), nil
+}
+
+// Base64 encode data wrapped at 76 characters per line as MIME requires
+func base64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var out bytes.Buffer
+	for len(encoded) > 76 {
+		out.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	out.WriteString(encoded + "\r\n")
+	return out.Bytes()
+}
+
+// CSV listing of problematic jobs for the alert attachment
+func problematicJobsCSV(jobs []JobStatus, config *Config) ([]byte, error) {
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	header := []string{"name", "status", "start", "end", "duration", "server", "reason"}
+	if config.IncludeNextRun {
+		header = append(header, "next_run")
+	}
+	writer.Write(header)
+	for _, job := range jobs {
+		record := []string{job.Name, job.Status, job.StartTime, job.EndTime, job.Duration, serverDisplayName(config), job.Description}
+		if config.IncludeNextRun {
+			record = append(record, job.NextRun)
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return out.Bytes(), writer.Error()
+}
@@ -0,0 +1,606 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRenderEmailSubject(t *testing.T) {
+	data := subjectData{Total: 3, Failed: 1, Warning: 2, Server: "veeam01", Severity: severityCritical}
+	tests := []struct {
+		template, want string
+	}{
+		{"", "ALERT: 3 Veeam Backup Jobs Need Attention"},
+		{"[{{.Severity}}] {{.Failed}} failed, {{.Warning}} warning on {{.Server}}", "[critical] 1 failed, 2 warning on veeam01"},
+		{"{{if .Failed}}FAILED{{else}}WARNING{{end}}: {{.Server}}", "FAILED: veeam01"},
+		{"Veeam\n  {{.Total}} jobs\n", "Veeam 3 jobs"},
+		{"{{.NoSuchField}} jobs", "ALERT: 3 Veeam Backup Jobs Need Attention"},
+		{"{{.Failed", "ALERT: 3 Veeam Backup Jobs Need Attention"},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.EmailSubjectTemplate = test.template
+		if got := renderEmailSubject(config, data); got != test.want {
+			t.Errorf("template %q: got %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestDefaultEmailSubject(t *testing.T) {
+	tests := []struct {
+		data subjectData
+		want string
+	}{
+		{subjectData{Total: 2}, "ALERT: 2 Veeam Backup Jobs Need Attention"},
+		{subjectData{Repositories: 1}, "ALERT: 1 Veeam Repositories Low on Free Space"},
+		{subjectData{Total: 2, Repositories: 1}, "ALERT: 2 Veeam Backup Jobs Need Attention, 1 Repositories Low on Free Space"},
+		{subjectData{Total: 2, Client: "Contoso"}, "ALERT: 2 Veeam Backup Jobs Need Attention for Contoso"},
+	}
+	for _, test := range tests {
+		if got := defaultEmailSubject(test.data); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.data, got, test.want)
+		}
+	}
+}
+
+func TestCSVAttachmentInMessage(t *testing.T) {
+	config := testConfig()
+	config.EmailFrom, config.EmailTo = "monitor@example.com", []string{"ops@example.com"}
+	config.VeeamServerAddress = "veeam01"
+	jobs := []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", StartTime: "3/1/2024 1:00:00 AM", EndTime: "3/1/2024 1:20:00 AM", Description: `Disk "D:" full, 0 bytes free`},
+		{Name: "Weekly", JobType: "Backup", Status: "Warning"},
+	}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC))
+	attachment, err := csvAttachment(report, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := buildEmailMessage(config, "subject", "body", "", []emailAttachment{attachment})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("reading message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("got Content-Type %q, want multipart/mixed", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var records [][]string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		if part.FileName() != "veeam-problematic-jobs-2024-03-01-0200.csv" {
+			t.Errorf("got attachment %q", part.FileName())
+		}
+		decoded, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		if err != nil {
+			t.Fatalf("decoding attachment: %v", err)
+		}
+		if records, err = csv.NewReader(bytes.NewReader(decoded)).ReadAll(); err != nil {
+			t.Fatalf("reading attachment CSV: %v", err)
+		}
+	}
+
+	want := [][]string{
+		{"name", "status", "start", "end", "duration", "server", "reason"},
+		{"Nightly", "Failed", "3/1/2024 1:00:00 AM", "3/1/2024 1:20:00 AM", "", "veeam01", `Disk "D:" full, 0 bytes free`},
+		{"Weekly", "Warning", "", "", "", "veeam01", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got CSV %q, want %q", records, want)
+	}
+}
+
+// Message accepted by a fakeSMTP server
+type smtpDelivery struct {
+	from      string
+	to        []string
+	data      string
+	encrypted bool
+}
+
+// Minimal SMTP server rejecting some recipients. With a TLS config it speaks
+// TLS from the first byte when implicitTLS is set and otherwise offers
+// STARTTLS. With a token it offers XOAUTH2 and accepts only that token.
+type fakeSMTP struct {
+	addr        string
+	reject      map[string]bool
+	tlsConfig   *tls.Config
+	implicitTLS bool
+	token       string
+
+	mu         sync.Mutex
+	deliveries []smtpDelivery
+}
+
+func newFakeSMTP(t *testing.T, reject ...string) *fakeSMTP {
+	server := &fakeSMTP{reject: map[string]bool{}}
+	for _, address := range reject {
+		server.reject[address] = true
+	}
+	server.start(t)
+	return server
+}
+
+// Fake SMTP server with a self-signed certificate for 127.0.0.1, and a PEM
+// file of the certificate to trust it with
+func newTLSFakeSMTP(t *testing.T, implicitTLS bool) (*fakeSMTP, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake SMTP"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeSMTP{
+		reject:      map[string]bool{},
+		tlsConfig:   &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		implicitTLS: implicitTLS,
+	}
+	server.start(t)
+	return server, caFile
+}
+
+// Listen on a free local port until the test ends
+func (s *fakeSMTP) start(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	s.addr = listener.Addr().String()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	encrypted := s.tlsConfig != nil && s.implicitTLS
+	if encrypted {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+	defer func() { conn.Close() }()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+	var delivery smtpDelivery
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		argument := strings.TrimSpace(strings.TrimPrefix(line, strings.SplitN(line, " ", 2)[0]))
+		address := strings.Trim(argument[strings.Index(argument, ":")+1:], "<> ")
+		switch command {
+		case "EHLO", "HELO":
+			extensions := []string{"fake"}
+			if s.tlsConfig != nil && !encrypted {
+				extensions = append(extensions, "STARTTLS")
+			}
+			if s.token != "" {
+				extensions = append(extensions, "AUTH XOAUTH2")
+			}
+			for i, extension := range extensions {
+				separator := "-"
+				if i == len(extensions)-1 {
+					separator = " "
+				}
+				text.PrintfLine("250%s%s", separator, extension)
+			}
+		case "AUTH":
+			response, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(argument, "XOAUTH2 "))
+			if !strings.Contains(string(response), "auth=Bearer "+s.token+"\x01") {
+				text.PrintfLine("535 5.7.3 Authentication unsuccessful")
+				continue
+			}
+			text.PrintfLine("235 Authenticated")
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			conn = tls.Server(conn, s.tlsConfig)
+			text = textproto.NewConn(conn)
+			encrypted = true
+		case "MAIL":
+			delivery = smtpDelivery{from: address, encrypted: encrypted}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			if s.reject[address] {
+				text.PrintfLine("550 No such user %s", address)
+				continue
+			}
+			delivery.to = append(delivery.to, address)
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			delivery.data = string(data)
+			s.mu.Lock()
+			s.deliveries = append(s.deliveries, delivery)
+			s.mu.Unlock()
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTP) delivered() []smtpDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpDelivery{}, s.deliveries...)
+}
+
+// Address nothing is listening on
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// Config sending email to recipients through server
+func smtpTestConfig(server string, recipients ...string) *Config {
+	config := testConfig()
+	config.SMTPServer = server
+	config.EmailFrom = "monitor@example.com"
+	config.EmailTo = recipients
+	return config
+}
+
+func TestEmailFallsBackWhenPrimaryIsDown(t *testing.T) {
+	fallback := newFakeSMTP(t)
+	config := smtpTestConfig(closedAddress(t), "ops@example.com")
+	config.SMTPServerFallback = fallback.addr
+
+	if err := sendEmail(context.Background(), http.DefaultClient, config, "subject", "body"); err != nil {
+		t.Fatalf("sending through the fallback: %v", err)
+	}
+	delivered := fallback.delivered()
+	if len(delivered) != 1 || delivered[0].to[0] != "ops@example.com" || !strings.Contains(delivered[0].data, "Subject: subject") {
+		t.Errorf("fallback got %+v, want the message", delivered)
+	}
+}
+
+func TestEmailRejectedByPrimaryIsNotRetried(t *testing.T) {
+	primary, fallback := newFakeSMTP(t, "ops@example.com"), newFakeSMTP(t)
+	config := smtpTestConfig(primary.addr, "ops@example.com")
+	config.SMTPServerFallback = fallback.addr
+
+	err := sendEmail(context.Background(), http.DefaultClient, config, "subject", "body")
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("got error %v, want the primary's rejection", err)
+	}
+	if delivered := fallback.delivered(); len(delivered) != 0 {
+		t.Errorf("fallback got %+v after the primary answered", delivered)
+	}
+}
+
+func TestSendPerRecipient(t *testing.T) {
+	relay := newFakeSMTP(t, "gone@example.com")
+	config := smtpTestConfig(relay.addr, "ops@example.com", "gone@example.com", "noc@example.com")
+
+	// Together, the rejected address fails the whole send
+	if err := sendEmail(context.Background(), http.DefaultClient, config, "subject", "body"); err == nil {
+		t.Error("sent to a rejected recipient without sendPerRecipient")
+	}
+	if delivered := relay.delivered(); len(delivered) != 0 {
+		t.Fatalf("delivered %+v after a recipient was rejected", delivered)
+	}
+
+	config.SendPerRecipient = true
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	err := sendEmail(context.Background(), http.DefaultClient, config, "subject", "body")
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		t.Errorf("got error %v, want success as some recipients got it", err)
+	}
+	delivered := relay.delivered()
+	if len(delivered) != 2 || len(delivered[0].to) != 1 || delivered[0].to[0] != "ops@example.com" || delivered[1].to[0] != "noc@example.com" {
+		t.Errorf("delivered %+v, want one transaction per accepted recipient", delivered)
+	}
+	for _, line := range []string{"Email delivered to ops@example.com", "Error delivering email to gone@example.com", "Email delivered to 2 of 3 recipients"} {
+		if !strings.Contains(logged.String(), line) {
+			t.Errorf("log has no %q:\n%s", line, logged.String())
+		}
+	}
+}
+
+func TestSendPerRecipientAllRejected(t *testing.T) {
+	relay := newFakeSMTP(t, "gone@example.com", "left@example.com")
+	config := smtpTestConfig(relay.addr, "gone@example.com", "left@example.com")
+	config.SendPerRecipient = true
+
+	err := sendEmail(context.Background(), http.DefaultClient, config, "subject", "body")
+	if err == nil || !strings.Contains(err.Error(), "delivery failed for every recipient") ||
+		!strings.Contains(err.Error(), "gone@example.com: 550") || !strings.Contains(err.Error(), "left@example.com: 550") {
+		t.Errorf("got error %v, want each recipient's rejection", err)
+	}
+}
+
+func TestBuildEmailBody(t *testing.T) {
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed", StartTime: "3/1/2024 1:00:00 AM", EndTime: "3/1/2024 1:20:00 AM", Description: "Disk full"}
+	warning := JobStatus{Name: "Weekly", JobType: "Backup Copy", Status: "Warning", StartTime: "3/1/2024 2:00:00 AM", EndTime: "3/1/2024 2:05:00 AM", Description: "Retrying"}
+	failedSection := "FAILED JOBS (1):\n--------------\n" +
+		"Job: Nightly\nType: Backup\nStatus: Failed\nStart Time: 3/1/2024 1:00:00 AM\nEnd Time: 3/1/2024 1:20:00 AM\nDescription: Disk full\n\n\n"
+	warningSection := "WARNING JOBS (1):\n----------------\n" +
+		"Job: Weekly\nType: Backup Copy\nStatus: Warning\nStart Time: 3/1/2024 2:00:00 AM\nEnd Time: 3/1/2024 2:05:00 AM\nDescription: Retrying\n\n\n"
+	tests := []struct {
+		name     string
+		jobs     []JobStatus
+		severity string
+		sections string
+		subject  string
+	}{
+		{"empty", nil, severityWarning, "", ""},
+		{"failed only", []JobStatus{failed}, severityCritical, failedSection, "ALERT: 1 Veeam Backup Jobs Need Attention"},
+		{"warning only", []JobStatus{warning}, severityWarning, warningSection, "ALERT: 1 Veeam Backup Jobs Need Attention"},
+		{"mixed", []JobStatus{warning, failed}, severityCritical, failedSection + warningSection, "ALERT: 2 Veeam Backup Jobs Need Attention"},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		report := NewAlertReport(test.jobs, nil, test.severity, config, time.Now())
+		subject, body := buildEmailBody(report, config)
+
+		want := "Veeam Backup & Replication Job Status Report\n===========================================\n\n" +
+			"Severity: " + strings.ToUpper(test.severity) + "\n\n" + test.sections +
+			"\nThis is an automated message from the Veeam Backup Monitor.\n"
+		if report.Fingerprint != "" {
+			want += "Alert fingerprint: " + report.Fingerprint + "\n"
+		}
+		if body != want {
+			t.Errorf("%s: got body\n%s\nwant\n%s", test.name, body, want)
+		}
+		if test.subject != "" && subject != test.subject {
+			t.Errorf("%s: got subject %q, want %q", test.name, subject, test.subject)
+		}
+	}
+}
+
+func TestSMTPAddress(t *testing.T) {
+	tests := []struct {
+		server     string
+		port       int
+		addr, host string
+	}{
+		{"192.0.2.10", 25, "192.0.2.10:25", "192.0.2.10"},
+		{"2001:db8::25", 587, "[2001:db8::25]:587", "2001:db8::25"},
+		{"[2001:db8::25]", 587, "[2001:db8::25]:587", "2001:db8::25"},
+		{"smtp.example.com", 587, "smtp.example.com:587", "smtp.example.com"},
+		{" smtp.example.com ", 25, "smtp.example.com:25", "smtp.example.com"},
+		{"smtp.example.com:2525", 587, "smtp.example.com:2525", "smtp.example.com"},
+		{"192.0.2.10:2525", 25, "192.0.2.10:2525", "192.0.2.10"},
+		{"[2001:db8::25]:2525", 25, "[2001:db8::25]:2525", "2001:db8::25"},
+	}
+	for _, test := range tests {
+		addr, host, err := smtpAddress(test.server, test.port)
+		if err != nil || addr != test.addr || host != test.host {
+			t.Errorf("smtpAddress(%q, %d) = %q, %q, %v, want %q, %q", test.server, test.port, addr, host, err, test.addr, test.host)
+		}
+	}
+}
+
+func TestSMTPAddressInvalid(t *testing.T) {
+	tests := []struct {
+		server string
+		port   int
+	}{
+		{"", 25},
+		{"smtp.example.com:", 25},
+		{"smtp.example.com:smtp", 25},
+		{"smtp.example.com:70000", 25},
+		{"smtp.example.com:25:26", 25},
+		{"smtp example.com", 25},
+		{"smtp.example.com", 0},
+		{"smtp.example.com", 65536},
+	}
+	for _, test := range tests {
+		if addr, _, err := smtpAddress(test.server, test.port); err == nil {
+			t.Errorf("smtpAddress(%q, %d) = %q, want an error", test.server, test.port, addr)
+		}
+	}
+}
+
+func TestSMTPImplicitTLS(t *testing.T) {
+	server, caFile := newTLSFakeSMTP(t, true)
+	config := smtpTestConfig(server.addr, "ops@example.com")
+	config.SMTPTLS = true
+
+	if err := sendEmail(context.Background(), http.DefaultClient, config, "Test", "body"); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("got error %v, want the untrusted certificate refused", err)
+	}
+	config.SMTPCAFile = caFile
+	if err := sendEmail(context.Background(), http.DefaultClient, config, "Test", "body"); err != nil {
+		t.Fatal(err)
+	}
+	config.SMTPCAFile = ""
+	config.SMTPInsecureSkipVerify = true
+	if err := sendEmail(context.Background(), http.DefaultClient, config, "Test", "body"); err != nil {
+		t.Fatal(err)
+	}
+	if delivered := server.delivered(); len(delivered) != 2 || !delivered[0].encrypted || !delivered[1].encrypted {
+		t.Errorf("got %+v, want two encrypted deliveries", delivered)
+	}
+
+	detail, err := verifyRelay(http.DefaultClient, config, smtpRelays(config)[0])
+	if err != nil || detail != "connected to "+server.addr+" over TLS" {
+		t.Errorf("got %q and error %v", detail, err)
+	}
+}
+
+func TestSMTPStartTLS(t *testing.T) {
+	server, caFile := newTLSFakeSMTP(t, false)
+	config := smtpTestConfig(server.addr, "ops@example.com")
+	config.SMTPCAFile = caFile
+
+	// Used when offered, even when not required
+	if err := sendEmail(context.Background(), http.DefaultClient, config, "Test", "body"); err != nil {
+		t.Fatal(err)
+	}
+	if delivered := server.delivered(); len(delivered) != 1 || !delivered[0].encrypted {
+		t.Errorf("got %+v, want an encrypted delivery", delivered)
+	}
+	detail, err := verifyRelay(http.DefaultClient, config, smtpRelays(config)[0])
+	if err != nil || detail != "connected to "+server.addr+", STARTTLS" {
+		t.Errorf("got %q and error %v", detail, err)
+	}
+
+	plain := newFakeSMTP(t)
+	config = smtpTestConfig(plain.addr, "ops@example.com")
+	if err := sendEmail(context.Background(), http.DefaultClient, config, "Test", "body"); err != nil {
+		t.Fatal(err)
+	}
+	config.SMTPStartTLS = true
+	err = sendEmail(context.Background(), http.DefaultClient, config, "Test", "body")
+	if err == nil || !strings.Contains(err.Error(), plain.addr+" doesn't offer STARTTLS, which smtpStartTLS requires") {
+		t.Errorf("got error %v", err)
+	}
+	if delivered := plain.delivered(); len(delivered) != 1 || delivered[0].encrypted {
+		t.Errorf("got %+v, want only the unencrypted delivery before STARTTLS was required", delivered)
+	}
+}
+
+func TestSMTPCAFileWithoutCertificates(t *testing.T) {
+	config := testConfig()
+	config.SMTPCAFile = filepath.Join(t.TempDir(), "ca.pem")
+	ioutil.WriteFile(config.SMTPCAFile, []byte("not a certificate"), 0644)
+	if _, err := smtpTLSConfig(config, "mail.example"); err == nil || err.Error() != "no PEM certificates in "+config.SMTPCAFile {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestSMTPRenewsRejectedOAuthToken(t *testing.T) {
+	var mu sync.Mutex
+	issued := 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		issued++
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, issued)
+		mu.Unlock()
+	}))
+	defer tokens.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return issued
+	}
+	smtpTokenCache.Invalidate()
+	t.Cleanup(smtpTokenCache.Invalidate)
+
+	server := &fakeSMTP{reject: map[string]bool{}, token: "token-2"}
+	server.start(t)
+	config := smtpTestConfig(server.addr, "ops@example.com")
+	config.OAuthTokenURL = tokens.URL
+	config.OAuthClientID, config.OAuthClientSecret = "client", "secret"
+
+	// token-1 is rejected although it hasn't expired, token-2 is then cached
+	for i := 0; i < 2; i++ {
+		if err := sendEmail(context.Background(), http.DefaultClient, config, "Test", "body"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(server.delivered()) != 2 || count() != 2 {
+		t.Errorf("delivered %d emails with %d tokens, want 2 with 2", len(server.delivered()), count())
+	}
+
+	// A new token that is rejected too isn't retried again
+	smtpTokenCache.Invalidate()
+	err := sendEmail(context.Background(), http.DefaultClient, config, "Test", "body")
+	if !errors.Is(err, errSMTPAuth) || count() != 4 {
+		t.Errorf("got error %v after %d tokens, want errSMTPAuth after one retry", err, count())
+	}
+}
+
+func TestEmailAbandonedWhenCancelled(t *testing.T) {
+	// Accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(10 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = sendEmail(ctx, http.DefaultClient, smtpTestConfig(listener.Addr().String(), "ops@example.com"), "Test", "body")
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("got error %v after %v, want the send abandoned when the context ends", err, time.Since(start))
+	}
+}
@@ -0,0 +1,372 @@
+package veeammonitor
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Veeam Backup Enterprise Manager job types and the monitorJobTypes they belong to
+var enterpriseManagerJobTypes = map[string]string{
+	"Backup":         "backup",
+	"BackupSync":     "copy",
+	"BackupCopy":     "copy",
+	"EndpointBackup": "agent",
+	"Tape":           "tape",
+}
+
+// Number of sessions fetched per cycle, newest first
+const enterpriseManagerPageSize = 1000
+
+// EnterpriseManagerSource is a JobSource that reads job sessions and
+// repositories from the Veeam Backup Enterprise Manager REST API instead of
+// PowerShell, so no Veeam console is needed on the monitoring machine.
+type EnterpriseManagerSource struct {
+	Config *Config
+
+	client    *http.Client
+	sessionID string // X-RestSvcSessionId of the current logon
+
+	now       time.Time            // Enterprise Manager's time when this cycle started
+	clockSkew time.Duration        // Enterprise Manager's clock minus ours, from the Date header of its last response
+	sessions  []emBackupJobSession // Newest session of each monitored job
+	err       error                // Error fetching this cycle's sessions
+}
+
+// NewEnterpriseManagerSource creates a source for the configured Enterprise Manager
+func NewEnterpriseManagerSource(config *Config) *EnterpriseManagerSource {
+	return &EnterpriseManagerSource{
+		Config: config,
+		client: &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: config.EnterpriseManagerInsecureSkipVerify},
+			},
+		},
+	}
+}
+
+// Backup job session as returned by /api/query?type=BackupJobSession
+type emBackupJobSession struct {
+	JobName         string `json:"JobName"`
+	JobType         string `json:"JobType"`
+	State           string `json:"State"`  // Starting, Working, Stopping, Stopped...
+	Result          string `json:"Result"` // Success, Warning, Failed or None while running
+	CreationTimeUTC string `json:"CreationTimeUTC"`
+	EndTimeUTC      string `json:"EndTimeUTC"`
+}
+
+// Repository as returned by /api/query?type=Repository
+type emRepository struct {
+	Name      string `json:"Name"`
+	Capacity  int64  `json:"Capacity"`
+	FreeSpace int64  `json:"FreeSpace"`
+}
+
+// Job as returned by /api/query?type=Job
+type emJob struct {
+	Name            string `json:"Name"`
+	JobType         string `json:"JobType"`
+	ScheduleEnabled bool   `json:"ScheduleEnabled"`
+}
+
+// StartCycle fetches the sessions every query of this cycle reports on.
+// Session ages are measured against Enterprise Manager's clock, corrected
+// by the skew seen in its responses, since its session times come from it.
+func (s *EnterpriseManagerSource) StartCycle() {
+	s.sessions, s.err = s.latestSessions()
+	s.now = time.Now().Add(s.clockSkew)
+	if s.clockSkew >= time.Minute || s.clockSkew <= -time.Minute {
+		log.Printf("Enterprise Manager's clock differs from this machine's by %s, measuring job durations with its clock\n", s.clockSkew.Round(time.Second))
+	}
+}
+
+// This cycle's sessions, fetched now for callers that don't start cycles
+func (s *EnterpriseManagerSource) cycleSessions() ([]emBackupJobSession, error) {
+	if s.now.IsZero() {
+		s.StartCycle()
+	}
+	return s.sessions, s.err
+}
+
+// Newest session per monitored job, the running one if a job is running
+func (s *EnterpriseManagerSource) latestSessions() ([]emBackupJobSession, error) {
+	var result struct {
+		Entities struct {
+			BackupJobSessions struct {
+				BackupJobSessions []emBackupJobSession `json:"BackupJobSessions"`
+			} `json:"BackupJobSessions"`
+		} `json:"Entities"`
+	}
+	query := url.Values{
+		"type":     {"BackupJobSession"},
+		"format":   {"Entities"},
+		"sortDesc": {"CreationTime"},
+		"pageSize": {strconv.Itoa(enterpriseManagerPageSize)},
+	}
+	if err := s.get("/api/query?"+query.Encode(), &result); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var sessions []emBackupJobSession
+	for _, session := range result.Entities.BackupJobSessions.BackupJobSessions {
+		if seen[session.JobName] || !s.monitored(session.JobType) {
+			continue
+		}
+		seen[session.JobName] = true
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// Whether a job type is in MonitorJobTypes
+func (s *EnterpriseManagerSource) monitored(emJobType string) bool {
+	jobType, ok := enterpriseManagerJobTypes[emJobType]
+	if !ok {
+		return false
+	}
+	for _, monitored := range s.Config.MonitorJobTypes {
+		if monitored == jobType {
+			return true
+		}
+	}
+	return false
+}
+
+// Convert a session into a JobStatus
+func (s *EnterpriseManagerSource) jobStatus(session emBackupJobSession, status string) JobStatus {
+	endTime := s.Config.displayTime(session.EndTimeUTC)
+	if session.State != "Stopped" {
+		endTime = "N/A"
+	}
+	return JobStatus{
+		Name:      session.JobName,
+		Status:    status,
+		StartTime: s.Config.displayTime(session.CreationTimeUTC),
+		EndTime:   endTime,
+		JobType:   jobTypeLabels[enterpriseManagerJobTypes[session.JobType]],
+	}
+}
+
+func (s *EnterpriseManagerSource) JobsByStatus(status string) ([]JobStatus, error) {
+	sessions, err := s.cycleSessions()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []JobStatus
+	for _, session := range sessions {
+		if session.State == "Stopped" && session.Result == status {
+			jobs = append(jobs, s.jobStatus(session, status))
+		}
+	}
+	return jobs, nil
+}
+
+func (s *EnterpriseManagerSource) LongRunningJobs() ([]JobStatus, error) {
+	sessions, err := s.cycleSessions()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []JobStatus
+	for _, session := range sessions {
+		if session.State == "Stopped" {
+			continue
+		}
+		started, err := time.Parse(time.RFC3339, session.CreationTimeUTC)
+		if err != nil {
+			continue
+		}
+		minutes := s.now.Sub(started).Minutes()
+		if minutes <= float64(s.Config.longRunningMinutes()) {
+			continue
+		}
+		job := s.jobStatus(session, "Running")
+		job.Duration = strconv.FormatFloat(minutes, 'f', 2, 64)
+		job.Description = fmt.Sprintf("Long-running job (over %d minutes): Currently running", s.Config.LongRunningThreshold)
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *EnterpriseManagerSource) StaleJobs() ([]JobStatus, error) {
+	sessions, err := s.cycleSessions()
+	if err != nil {
+		return nil, err
+	}
+	allJobs, err := s.jobs()
+	if err != nil {
+		return nil, err
+	}
+
+	lastSession := map[string]emBackupJobSession{}
+	for _, session := range sessions {
+		lastSession[session.JobName] = session
+	}
+
+	var jobs []JobStatus
+	for _, job := range allJobs {
+		if !s.monitored(job.JobType) || (!job.ScheduleEnabled && !s.Config.IncludeDisabledJobs) {
+			continue
+		}
+
+		status := JobStatus{Name: job.Name, Status: "Stale", JobType: jobTypeLabels[enterpriseManagerJobTypes[job.JobType]], Duration: "-1"}
+		if session, ok := lastSession[job.Name]; ok {
+			status = s.jobStatus(session, "Stale")
+			lastRun := session.EndTimeUTC
+			if session.State != "Stopped" || lastRun == "" {
+				lastRun = session.CreationTimeUTC
+			}
+			if at, err := time.Parse(time.RFC3339, lastRun); err == nil {
+				status.Duration = strconv.FormatFloat(s.now.Sub(at).Hours(), 'f', 2, 64)
+			}
+		}
+		jobs = append(jobs, status)
+	}
+	return staleJobs(jobs, s.Config.MaxJobAgeHours), nil
+}
+
+func (s *EnterpriseManagerSource) AllJobs() ([]JobStatus, error) {
+	allJobs, err := s.jobs()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []JobStatus
+	for _, job := range allJobs {
+		if s.monitored(job.JobType) {
+			jobs = append(jobs, JobStatus{Name: job.Name, JobType: jobTypeLabels[enterpriseManagerJobTypes[job.JobType]], Disabled: !job.ScheduleEnabled})
+		}
+	}
+	return jobs, nil
+}
+
+// Every job known to the Enterprise Manager
+func (s *EnterpriseManagerSource) jobs() ([]emJob, error) {
+	var result struct {
+		Entities struct {
+			Jobs struct {
+				Jobs []emJob `json:"Jobs"`
+			} `json:"Jobs"`
+		} `json:"Entities"`
+	}
+	if err := s.get("/api/query?type=Job&format=Entities", &result); err != nil {
+		return nil, err
+	}
+	return result.Entities.Jobs.Jobs, nil
+}
+
+func (s *EnterpriseManagerSource) Repositories() ([]RepositoryStatus, error) {
+	var result struct {
+		Entities struct {
+			Repositories struct {
+				Repositories []emRepository `json:"Repositories"`
+			} `json:"Repositories"`
+		} `json:"Entities"`
+	}
+	if err := s.get("/api/query?type=Repository&format=Entities", &result); err != nil {
+		return nil, err
+	}
+
+	var repos []RepositoryStatus
+	for _, repo := range result.Entities.Repositories.Repositories {
+		repos = append(repos, RepositoryStatus{Name: repo.Name, TotalBytes: repo.Capacity, FreeBytes: repo.FreeSpace})
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+// GET an API resource and decode the JSON response, logging on first and
+// again whenever the session has expired
+func (s *EnterpriseManagerSource) get(path string, v interface{}) error {
+	if s.sessionID == "" {
+		if err := s.logon(); err != nil {
+			return err
+		}
+	}
+
+	resp, err := s.request("GET", path, true)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// Sessions expire after a period of inactivity, log on again once
+		resp.Body.Close()
+		if err := s.logon(); err != nil {
+			return err
+		}
+		resp, err = s.request("GET", path, true)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading Enterprise Manager response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Enterprise Manager request %s failed with %s: %s", path, resp.Status, firstLine(string(data)))
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return &ParseError{"Enterprise Manager response", err}
+	}
+	return nil
+}
+
+// Create a REST session with the configured credentials
+func (s *EnterpriseManagerSource) logon() error {
+	s.sessionID = ""
+	resp, err := s.request("POST", "/api/sessionMngr/?v=latest", false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w: Enterprise Manager rejected the credentials for %s", ErrVeeamUnreachable, s.Config.EnterpriseManagerUsername)
+	case resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK:
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%w: Enterprise Manager logon failed with %s: %s", ErrVeeamUnreachable, resp.Status, firstLine(string(data)))
+	}
+
+	s.sessionID = resp.Header.Get("X-RestSvcSessionId")
+	if s.sessionID == "" {
+		return fmt.Errorf("%w: Enterprise Manager logon returned no session ID", ErrVeeamUnreachable)
+	}
+	return nil
+}
+
+// Send a request to the Enterprise Manager, authenticated with the session or with Basic auth for logon
+func (s *EnterpriseManagerSource) request(method, path string, withSession bool) (*http.Response, error) {
+	endpoint := strings.TrimRight(s.Config.EnterpriseManagerURL, "/") + path
+	request, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if withSession {
+		request.Header.Set("X-RestSvcSessionId", s.sessionID)
+	} else {
+		request.SetBasicAuth(s.Config.EnterpriseManagerUsername, s.Config.EnterpriseManagerPassword)
+	}
+
+	resp, err := s.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot connect to Enterprise Manager at %s: %v", ErrVeeamUnreachable, s.Config.EnterpriseManagerURL, err)
+	}
+	// The Date header only has whole seconds, so smaller skew is noise
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		if s.clockSkew = time.Until(date); s.clockSkew > -2*time.Second && s.clockSkew < 2*time.Second {
+			s.clockSkew = 0
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,189 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stand-in Enterprise Manager REST API answering job session queries
+type fakeEnterpriseManager struct {
+	sessions    []emBackupJobSession
+	clockOffset time.Duration // How far its clock is ahead of ours, sent in the Date header
+
+	mu        sync.Mutex
+	logons    int
+	sessionID string
+}
+
+func (f *fakeEnterpriseManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Date", time.Now().Add(f.clockOffset).UTC().Format(http.TimeFormat))
+	if r.URL.Path == "/api/sessionMngr/" {
+		if username, password, ok := r.BasicAuth(); r.Method != "POST" || !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.logons++
+		f.sessionID = fmt.Sprintf("session-%d", f.logons)
+		w.Header().Set("X-RestSvcSessionId", f.sessionID)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if f.sessionID == "" || r.Header.Get("X-RestSvcSessionId") != f.sessionID {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Query().Get("type") {
+	case "BackupJobSession":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Entities": map[string]interface{}{"BackupJobSessions": map[string]interface{}{"BackupJobSessions": f.sessions}},
+		})
+	case "Repository":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Entities": map[string]interface{}{"Repositories": map[string]interface{}{"Repositories": []emRepository{{Name: "Main", Capacity: 1000, FreeSpace: 50}}}},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Let the current session time out
+func (f *fakeEnterpriseManager) expire() {
+	f.mu.Lock()
+	f.sessionID = ""
+	f.mu.Unlock()
+}
+
+// Enterprise Manager source for a test server
+func newEnterpriseManagerTestSource(url, password string) *EnterpriseManagerSource {
+	config := testConfig()
+	config.EnterpriseManagerURL = url
+	config.EnterpriseManagerUsername = "admin"
+	config.EnterpriseManagerPassword = password
+	config.EnterpriseManagerInsecureSkipVerify = true
+	return NewEnterpriseManagerSource(config)
+}
+
+func TestEnterpriseManagerJobStates(t *testing.T) {
+	now := time.Now().UTC()
+	at := func(ago time.Duration) string { return now.Add(-ago).Format(time.RFC3339) }
+	service := &fakeEnterpriseManager{sessions: []emBackupJobSession{
+		{JobName: "Offsite", JobType: "Backup", State: "Working", Result: "None", CreationTimeUTC: at(5 * time.Hour)},
+		{JobName: "Nightly", JobType: "Backup", State: "Stopped", Result: "Failed", CreationTimeUTC: at(2 * time.Hour), EndTimeUTC: at(time.Hour)},
+		{JobName: "Weekly", JobType: "Backup", State: "Stopped", Result: "Warning", CreationTimeUTC: at(3 * time.Hour), EndTimeUTC: at(2 * time.Hour)},
+		{JobName: "Tapes", JobType: "Tape", State: "Stopped", Result: "Failed", CreationTimeUTC: at(3 * time.Hour), EndTimeUTC: at(2 * time.Hour)},
+		{JobName: "Nightly", JobType: "Backup", State: "Stopped", Result: "Success", CreationTimeUTC: at(26 * time.Hour), EndTimeUTC: at(25 * time.Hour)},
+	}}
+	server := httptest.NewTLSServer(service)
+	defer server.Close()
+	source := newEnterpriseManagerTestSource(server.URL, "secret")
+	source.StartCycle()
+
+	// Only the newest session of each monitored job counts
+	failed, err := source.JobsByStatus("Failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Name != "Nightly" || failed[0].JobType != "Backup" || failed[0].EndTime == "N/A" {
+		t.Errorf("got failed jobs %+v, want Nightly", failed)
+	}
+	warning, _ := source.JobsByStatus("Warning")
+	if len(warning) != 1 || warning[0].Name != "Weekly" {
+		t.Errorf("got warning jobs %+v, want Weekly", warning)
+	}
+	running, _ := source.LongRunningJobs()
+	if len(running) != 1 || running[0].Name != "Offsite" || running[0].Status != "Running" || running[0].EndTime != "N/A" {
+		t.Errorf("got long-running jobs %+v, want Offsite", running)
+	}
+	repos, err := source.Repositories()
+	if err != nil || len(repos) != 1 || repos[0].TotalBytes != 1000 || repos[0].FreeBytes != 50 {
+		t.Errorf("got repositories %+v, %v", repos, err)
+	}
+}
+
+func TestEnterpriseManagerRenewsExpiredSession(t *testing.T) {
+	service := &fakeEnterpriseManager{}
+	server := httptest.NewTLSServer(service)
+	defer server.Close()
+	source := newEnterpriseManagerTestSource(server.URL, "secret")
+
+	source.StartCycle()
+	service.expire()
+	source.StartCycle()
+	if _, err := source.JobsByStatus("Failed"); err != nil {
+		t.Fatalf("querying after the session expired: %v", err)
+	}
+	if service.logons != 2 || source.sessionID != "session-2" {
+		t.Errorf("logged on %d times with session %q, want a new session after expiry", service.logons, source.sessionID)
+	}
+}
+
+func TestEnterpriseManagerRejectedCredentials(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeEnterpriseManager{})
+	defer server.Close()
+	source := newEnterpriseManagerTestSource(server.URL, "wrong")
+
+	source.StartCycle()
+	if _, err := source.JobsByStatus("Failed"); !errors.Is(err, ErrVeeamUnreachable) {
+		t.Errorf("got error %v, want ErrVeeamUnreachable", err)
+	}
+}
+
+func TestEnterpriseManagerVerifiesCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeEnterpriseManager{})
+	defer server.Close()
+	source := newEnterpriseManagerTestSource(server.URL, "secret")
+	source.Config.EnterpriseManagerInsecureSkipVerify = false
+	source = NewEnterpriseManagerSource(source.Config)
+
+	source.StartCycle()
+	if _, err := source.JobsByStatus("Failed"); !errors.Is(err, ErrVeeamUnreachable) {
+		t.Errorf("got error %v, want the self-signed certificate refused", err)
+	}
+}
+
+func TestEnterpriseManagerLongRunningGrace(t *testing.T) {
+	// Enterprise Manager's clock runs 10 minutes ahead, and its session
+	// times come from that clock
+	offset := 10 * time.Minute
+	serverNow := time.Now().Add(offset).UTC()
+	at := func(ago time.Duration) string { return serverNow.Add(-ago).Format(time.RFC3339) }
+	service := &fakeEnterpriseManager{clockOffset: offset, sessions: []emBackupJobSession{
+		{JobName: "Under", JobType: "Backup", State: "Working", Result: "None", CreationTimeUTC: at(59 * time.Minute)},
+		{JobName: "Threshold", JobType: "Backup", State: "Working", Result: "None", CreationTimeUTC: at(61 * time.Minute)},
+		{JobName: "Grace", JobType: "Backup", State: "Working", Result: "None", CreationTimeUTC: at(64*time.Minute + 30*time.Second)},
+		{JobName: "Over", JobType: "Backup", State: "Working", Result: "None", CreationTimeUTC: at(65*time.Minute + 30*time.Second)},
+		{JobName: "Skewed", JobType: "Backup", State: "Working", Result: "None", CreationTimeUTC: at(72 * time.Minute)},
+	}}
+	server := httptest.NewTLSServer(service)
+	defer server.Close()
+	source := newEnterpriseManagerTestSource(server.URL, "secret")
+	source.Config.LongRunningThreshold = 60
+	source.Config.LongRunningGraceMinutes = 5
+	source.StartCycle()
+
+	if source.clockSkew < offset-2*time.Second || source.clockSkew > offset+2*time.Second {
+		t.Errorf("got clock skew %v, want about %v", source.clockSkew, offset)
+	}
+	running, err := source.LongRunningJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := jobNames(running); !equalStrings(names, []string{"Over", "Skewed"}) {
+		t.Errorf("got long-running jobs %v, want only those past the threshold and grace period", names)
+	}
+	for _, job := range running {
+		if job.Description != "Long-running job (over 60 minutes): Currently running" {
+			t.Errorf("got description %q", job.Description)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Count consecutive checks each job has been found failed, marking jobs
+// that reach EscalateAfterFailures as escalated. Jobs found with another
+// problem start counting again; recovered jobs are reset by recordRecovered.
+func (m *Monitor) recordFailures(problematicJobs []JobStatus, now time.Time) []JobStatus {
+	config, state := m.Config, &m.state
+	if state.Jobs == nil {
+		state.Jobs = map[string]*jobAlertState{}
+	}
+
+	// A job also reported with another problem, e.g. drift, is still failing
+	failing := map[string]bool{}
+	for _, job := range problematicJobs {
+		if job.Status == "Failed" {
+			failing[jobIdentity(job)] = true
+		}
+	}
+
+	for i, job := range problematicJobs {
+		key := jobIdentity(job)
+		entry := state.Jobs[key]
+		if !failing[key] {
+			if entry != nil {
+				entry.ConsecutiveFailures = 0
+			}
+			continue
+		}
+		if job.Status != "Failed" {
+			continue
+		}
+
+		if entry == nil {
+			entry = &jobAlertState{Name: job.Name, JobType: job.JobType, Status: job.Status, LastSeen: now}
+			state.Jobs[key] = entry
+		}
+		entry.ConsecutiveFailures++
+		problematicJobs[i].ConsecutiveFailures = entry.ConsecutiveFailures
+
+		if config.EscalateAfterFailures > 0 && entry.ConsecutiveFailures >= config.EscalateAfterFailures {
+			problematicJobs[i].Escalated = true
+			if entry.ConsecutiveFailures == config.EscalateAfterFailures {
+				m.logf("Escalating %s after %d consecutive failed checks\n", job.Name, entry.ConsecutiveFailures)
+			}
+		}
+	}
+	return problematicJobs
+}
+
+// Severity of a job: its status severity, or SureBackupSeverity for a failed
+// SureBackup verification, one level higher once escalated
+func (c *Config) jobSeverity(job JobStatus) string {
+	severity := c.statusSeverity(job.Status)
+	if job.Status == "Failed" && job.JobType == jobTypeLabels["surebackup"] {
+		severity = c.SureBackupSeverity
+	}
+	if job.Escalated {
+		severity = raiseSeverity(severity)
+	}
+	return severity
+}
+
+// Next severity up, critical staying critical
+func raiseSeverity(severity string) string {
+	switch severity {
+	case severityInfo:
+		return severityWarning
+	case severityWarning, severityCritical:
+		return severityCritical
+	}
+	return severity
+}
+
+// Whether escalated jobs are sent to a channel in addition to its routing
+func (c *Config) escalatesTo(channel string) bool {
+	for _, name := range c.EscalationChannels {
+		if strings.EqualFold(name, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a job is sent to a channel, by the routing of its severity or
+// as an escalation
+func (c *Config) routesJob(job JobStatus, channel string) bool {
+	return c.routes(c.jobSeverity(job), channel) || (job.Escalated && c.escalatesTo(channel))
+}
+
+// Status of a job as shown in alerts, noting failed jobs Veeam will retry
+func displayStatus(job JobStatus) string {
+	if job.Status == "Failed" && job.RetryPending {
+		return "Failed (retry pending)"
+	}
+	return job.Status
+}
+
+// A line noting a job's escalation, formatted with format, for escalated jobs
+func escalationLine(job JobStatus, format string) string {
+	if !job.Escalated {
+		return ""
+	}
+	return fmt.Sprintf(format, job.ConsecutiveFailures)
+}
@@ -0,0 +1,101 @@
+package veeammonitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscalationAfterRepeatedFailures(t *testing.T) {
+	config := testConfig()
+	config.EscalateAfterFailures = 3
+	m := newTestMonitor(config, nil)
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+	now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	for check := 1; check <= 4; check++ {
+		jobs := m.recordFailures([]JobStatus{failed}, now)
+		if jobs[0].ConsecutiveFailures != check {
+			t.Errorf("check %d: %d consecutive failures", check, jobs[0].ConsecutiveFailures)
+		}
+		if want := check >= 3; jobs[0].Escalated != want {
+			t.Errorf("check %d: escalated %v, want %v", check, jobs[0].Escalated, want)
+		}
+	}
+	if severity := config.jobSeverity(JobStatus{Status: "Warning", Escalated: true}); severity != severityCritical {
+		t.Errorf("escalated warning has severity %s, want critical", severity)
+	}
+
+	// Recovering starts the count again
+	m.recordRecovered(nil, now)
+	if jobs := m.recordFailures([]JobStatus{failed}, now); jobs[0].ConsecutiveFailures != 1 || jobs[0].Escalated {
+		t.Errorf("after recovering: %d failures, escalated %v", jobs[0].ConsecutiveFailures, jobs[0].Escalated)
+	}
+
+	// So does another problem
+	m.recordFailures([]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Warning"}}, now)
+	if jobs := m.recordFailures([]JobStatus{failed}, now); jobs[0].ConsecutiveFailures != 1 {
+		t.Errorf("after a warning: %d failures, want 1", jobs[0].ConsecutiveFailures)
+	}
+}
+
+func TestEscalationCountsFailuresReportedWithOtherProblems(t *testing.T) {
+	config := testConfig()
+	config.EscalateAfterFailures = 2
+	m := newTestMonitor(config, nil)
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+	drift := JobStatus{Name: "Nightly", JobType: "Backup", Status: driftStatus}
+	now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	m.recordFailures([]JobStatus{drift, failed}, now)
+	jobs := m.recordFailures([]JobStatus{failed, drift}, now)
+	if jobs[0].ConsecutiveFailures != 2 || !jobs[0].Escalated {
+		t.Errorf("got %d failures, escalated %v, want 2 and escalated", jobs[0].ConsecutiveFailures, jobs[0].Escalated)
+	}
+}
+
+func TestSureBackupSeverity(t *testing.T) {
+	config := testConfig()
+	config.StatusSeverityMap["Failed"] = severityWarning
+	verification := JobStatus{Name: "Verify SQL", JobType: "SureBackup", Status: "Failed"}
+	backup := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+
+	if got := config.jobSeverity(verification); got != severityCritical {
+		t.Errorf("got severity %s for a failed verification, want the critical default", got)
+	}
+	if got := config.jobSeverity(backup); got != severityWarning {
+		t.Errorf("got severity %s for a failed backup, want statusSeverityMap's", got)
+	}
+	warned := verification
+	warned.Status = "Warning"
+	if got := config.jobSeverity(warned); got != severityWarning {
+		t.Errorf("got severity %s for a verification with warnings", got)
+	}
+
+	config.SureBackupSeverity = severityInfo
+	if got := config.jobSeverity(verification); got != severityInfo {
+		t.Errorf("got severity %s, want sureBackupSeverity", got)
+	}
+	verification.Escalated = true
+	if got := config.jobSeverity(verification); got != severityWarning {
+		t.Errorf("got severity %s for an escalated verification", got)
+	}
+
+	config, logged := loadTestConfig(t, []byte(`{"sureBackupSeverity": "urgent"}`))
+	if config.SureBackupSeverity != severityCritical || !strings.Contains(logged, `Unknown sureBackupSeverity "urgent"`) {
+		t.Errorf("got sureBackupSeverity %q for an unknown value, logged:\n%s", config.SureBackupSeverity, logged)
+	}
+}
+
+func TestCheckCycleSureBackupSeverity(t *testing.T) {
+	config := testConfig()
+	config.MonitorJobTypes = []string{"surebackup"}
+	config.SureBackupSeverity = severityWarning
+	m, capture := newCaptureMonitor(config, sureBackupRunner(jobCSVHeader+`"Verify SQL","Failed","3/1/2024 4:00:00 AM","3/1/2024 4:35:00 AM","Heartbeat test failed","",""`+"\n", ""))
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 || len(sent[0].Failed) != 1 || sent[0].Failed[0].JobType != "SureBackup" || sent[0].Severity != severityWarning {
+		t.Fatalf("sent %+v, want the failed verification at sureBackupSeverity", sent)
+	}
+}
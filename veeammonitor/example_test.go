@@ -0,0 +1,40 @@
+package veeammonitor_test
+
+import (
+	"context"
+	"fmt"
+
+	"veeam-monitor/veeammonitor"
+)
+
+// Answers the PowerShell queries with one failed job
+type sampleRunner struct{}
+
+func (sampleRunner) Run(script string) (string, string, error) {
+	output := `"Name","LastResult","LastStart","LastEnd","Description","NextRun","RetryPending"` + "\n" +
+		`"Nightly","Failed","2024-03-01T01:00:00","2024-03-01T01:20:00","Disk full","",""` + "\n"
+	return output, "", nil
+}
+
+// Prints the reports sent to it
+type printNotifier struct{}
+
+func (printNotifier) Name() string { return "print" }
+
+func (printNotifier) Notify(ctx context.Context, report *veeammonitor.AlertReport) error {
+	for _, job := range report.Jobs() {
+		fmt.Printf("%s: %s (%s)\n", job.Status, job.Name, job.Description)
+	}
+	return nil
+}
+
+func ExampleMonitor() {
+	config := veeammonitor.DefaultConfig()
+	config.StateFile = ""
+
+	m := veeammonitor.NewMonitor(config)
+	m.Runner = sampleRunner{}
+	m.Notifiers = []veeammonitor.Notifier{printNotifier{}}
+	m.RunCheckCycle()
+	// Output: Failed: Nightly (Disk full)
+}
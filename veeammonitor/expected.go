@@ -0,0 +1,61 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Status of ExpectedJobs that Veeam doesn't list at all
+const missingStatus = "Missing"
+
+// ExpectedJobs entries matching none of jobs, as Missing jobs. A job that
+// exists but is disabled or failing is not missing; those are reported by
+// the other checks. When a job with a similar name exists, the description
+// says so, since the expected job was likely renamed.
+func missingExpectedJobs(jobs []JobStatus, config *Config) []JobStatus {
+	var missing []JobStatus
+	for _, expected := range config.ExpectedJobs {
+		found := false
+		for _, job := range jobs {
+			if _, ok := matchJobName([]string{expected}, job.Name); ok {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		description := "Expected job no longer exists in Veeam"
+		if strings.ContainsAny(expected, "*?[") {
+			description = "No job in Veeam matches this expected job pattern"
+		} else if similar := similarJobName(jobs, expected); similar != "" {
+			description += fmt.Sprintf("; a job named %q exists, was it renamed?", similar)
+		}
+		missing = append(missing, JobStatus{
+			Name:        expected,
+			Status:      missingStatus,
+			Description: description,
+			NextRun:     notScheduled,
+		})
+	}
+	return missing
+}
+
+// Name of the job closest to name, when one differs by only a few
+// characters or contains it, as "SQL01" renamed to "SQL01 Daily" would
+func similarJobName(jobs []JobStatus, name string) string {
+	best, bestDistance := "", 4
+	name = strings.ToLower(name)
+	for _, job := range jobs {
+		other := strings.ToLower(job.Name)
+		distance := editDistance(name, other)
+		if strings.Contains(other, name) || strings.Contains(name, other) {
+			distance = 1
+		}
+		if distance < bestDistance {
+			best, bestDistance = job.Name, distance
+		}
+	}
+	return best
+}
@@ -0,0 +1,67 @@
+package veeammonitor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMissingExpectedJobs(t *testing.T) {
+	config := testConfig()
+	jobs := []JobStatus{
+		{Name: "SQL01 Daily", JobType: "Backup", Status: "Success"},
+		{Name: "Files", JobType: "Backup", Status: "Failed"},
+		{Name: "Exchange", JobType: "Backup", Status: "Success", Disabled: true},
+	}
+
+	tests := []struct {
+		name     string
+		expected []string
+		missing  []string
+		want     string
+	}{
+		{"present", []string{"files", "Exchange"}, nil, ""},
+		{"absent", []string{"Files", "Domain Controllers"}, []string{"Domain Controllers"}, "Expected job no longer exists in Veeam"},
+		{"renamed", []string{"SQL01"}, []string{"SQL01"}, `Expected job no longer exists in Veeam; a job named "SQL01 Daily" exists, was it renamed?`},
+		{"pattern", []string{"File*", "Archive-*"}, []string{"Archive-*"}, "No job in Veeam matches this expected job pattern"},
+	}
+	for _, test := range tests {
+		config.ExpectedJobs = test.expected
+		missing := missingExpectedJobs(jobs, config)
+		if names := jobNames(missing); !equalStrings(names, test.missing) {
+			t.Errorf("%s: got missing %v, want %v", test.name, names, test.missing)
+			continue
+		}
+		for _, job := range missing {
+			if job.Status != missingStatus || job.NextRun != notScheduled || job.Description != test.want {
+				t.Errorf("%s: got %+v, want description %q", test.name, job, test.want)
+			}
+		}
+	}
+}
+
+func TestCheckCycleReportsMissingExpectedJob(t *testing.T) {
+	config := testConfig()
+	config.ExpectedJobs = []string{"SQL-Prod", "SQL-Reporting", "Exchange"}
+	m, capture := newCaptureMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		if strings.Contains(script, "NextRun,IsEnabled |") {
+			return inventoryOutput, "", nil
+		}
+		return jobCSVHeader, "", nil
+	}))
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d reports, want 1", len(sent))
+	}
+	report := sent[0]
+	if names := jobNames(report.Missing); !equalStrings(names, []string{"Exchange"}) {
+		t.Errorf("got missing %v, want Exchange only", names)
+	}
+	if len(report.Disabled) != 0 {
+		t.Errorf("got disabled %v, want the disabled SQL-Prod left to criticalJobs", jobNames(report.Disabled))
+	}
+	if _, body := buildEmailBody(report, config); !strings.Contains(body, "MISSING EXPECTED JOBS (1):") {
+		t.Errorf("body doesn't list the missing job:\n%s", body)
+	}
+}
@@ -0,0 +1,91 @@
+package veeammonitor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrFatalEnvironment is returned when PowerShell or the Veeam module isn't
+// installed, which retrying can't fix
+var ErrFatalEnvironment = errors.New("PowerShell environment unusable")
+
+// ErrModuleNotFound is returned when the Veeam PowerShell module or snap-in
+// isn't installed. It is also ErrFatalEnvironment.
+var ErrModuleNotFound = fmt.Errorf("%w: Veeam PowerShell module not installed", ErrFatalEnvironment)
+
+// FatalErrorExitCode is the exit status after a fatal environment error when
+// FatalErrorBehavior is exit, so a service manager can restart the monitor
+const FatalErrorExitCode = 3
+
+// Longest wait between checks when FatalErrorBehavior is backoff
+const maxFatalErrorBackoff = 6 * time.Hour
+
+// Module load errors meaning the Veeam console isn't installed, as opposed
+// to a module that failed to load this time
+var fatalModuleErrors = []string{
+	"was not loaded because no valid module file was found",
+	"is not installed on this computer",
+	"No snap-ins have been registered",
+}
+
+// Whether the error of a failed module load means the module isn't installed
+func isFatalModuleError(text string) bool {
+	for _, pattern := range fatalModuleErrors {
+		if strings.Contains(text, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remember a fatal environment error seen while querying, for the check
+// loop to act on once the cycle ends
+func (m *Monitor) noteFatalError(err error) {
+	if errors.Is(err, ErrFatalEnvironment) {
+		m.fatalMu.Lock()
+		m.fatalErr = err
+		m.fatalMu.Unlock()
+	}
+}
+
+// Apply FatalErrorBehavior after a check, returning when the next check runs
+// or an error when the monitor should exit. With retry nothing changes; with
+// backoff every check that hits a fatal error waits twice as long as the
+// previous one, from CheckIntervalMinutes up to six hours, and the schedule
+// resumes once a check gets past it.
+func (m *Monitor) afterFatalError(next time.Time, schedule func(time.Time) time.Time) (time.Time, error) {
+	config := m.Config
+	m.fatalMu.Lock()
+	fatal := m.fatalErr
+	m.fatalErr = nil
+	m.fatalMu.Unlock()
+
+	if fatal == nil {
+		if m.fatalBackoff > 0 {
+			m.logln("The PowerShell environment works again, resuming the regular schedule")
+			m.fatalBackoff = 0
+			next = schedule(time.Now())
+		}
+		return next, nil
+	}
+
+	switch config.FatalErrorBehavior {
+	case "exit":
+		return next, fmt.Errorf("stopping the monitor: %w", fatal)
+	case "backoff":
+		if m.fatalBackoff == 0 {
+			m.fatalBackoff = time.Duration(config.CheckIntervalMinutes) * time.Minute
+		} else {
+			m.fatalBackoff *= 2
+		}
+		if m.fatalBackoff > maxFatalErrorBackoff {
+			m.fatalBackoff = maxFatalErrorBackoff
+		}
+		m.logf("PowerShell environment is unusable, retrying in %v: %v\n", m.fatalBackoff, fatal)
+		return time.Now().Add(m.fatalBackoff), nil
+	}
+	m.logf("PowerShell environment is unusable, checks will keep failing until it is fixed: %v\n", fatal)
+	return next, nil
+}
@@ -0,0 +1,113 @@
+package veeammonitor
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestFatalErrorClassification(t *testing.T) {
+	tests := []struct {
+		name   string
+		runner fakeRunner
+		fatal  bool
+		module bool
+	}{
+		{"module not installed", staticRunner(moduleErrorMarker+" The specified module 'Veeam.Backup.PowerShell' was not loaded because no valid module file was found in any module directory.\n", "", errors.New("exit status 1")), true, true},
+		{"snap-in not registered", staticRunner(moduleErrorMarker+" No snap-ins have been registered for Windows PowerShell version 5.\n", "", errors.New("exit status 1")), true, true},
+		{"console not installed", staticRunner("", moduleErrorMarker+" Veeam Backup & Replication console is not installed on this computer", errors.New("exit status 1")), true, true},
+		{"powershell missing", staticRunner("", "", &exec.Error{Name: "powershell.exe", Err: exec.ErrNotFound}), true, false},
+		{"module failed to load", staticRunner(moduleErrorMarker+" Import-Module : Access is denied\n", "", errors.New("exit status 1")), false, false},
+		{"server unreachable", staticRunner(connectErrorMarker+" No connection could be made because the target machine actively refused it\n", "", errors.New("exit status 1")), false, false},
+		{"access denied", staticRunner("", "Get-VBRJob : Access is denied", errors.New("exit status 1")), false, false},
+		{"timeout", staticRunner("", "", fmt.Errorf("%w after 300 seconds", ErrPowerShellTimeout)), false, false},
+	}
+	for _, test := range tests {
+		m := newTestMonitor(testConfig(), test.runner)
+		_, err := m.runVeeamScript("Get-VBRJob")
+		if err == nil {
+			t.Errorf("%s: no error", test.name)
+			continue
+		}
+		if fatal := errors.Is(err, ErrFatalEnvironment); fatal != test.fatal {
+			t.Errorf("%s: got fatal %v, want %v: %v", test.name, fatal, test.fatal, err)
+		}
+		if module := errors.Is(err, ErrModuleNotFound); module != test.module {
+			t.Errorf("%s: got module not found %v, want %v: %v", test.name, module, test.module, err)
+		}
+		if noted := m.fatalErr != nil; noted != test.fatal {
+			t.Errorf("%s: fatal error noted %v, want %v", test.name, noted, test.fatal)
+		}
+	}
+}
+
+func TestAfterFatalError(t *testing.T) {
+	next := time.Now().Add(15 * time.Minute)
+	schedule := func(from time.Time) time.Time { return from.Add(15 * time.Minute) }
+	fatal := fmt.Errorf("%w: Veeam.Backup.PowerShell", ErrModuleNotFound)
+
+	m := newTestMonitor(testConfig(), nil)
+	m.noteFatalError(errors.New("exit status 1"))
+	if got, err := m.afterFatalError(next, schedule); err != nil || !got.Equal(next) {
+		t.Errorf("after a transient error got %v, %v, want the schedule kept", got, err)
+	}
+
+	// retry keeps the schedule
+	m.noteFatalError(fatal)
+	if got, err := m.afterFatalError(next, schedule); err != nil || !got.Equal(next) {
+		t.Errorf("retry: got %v, %v, want the schedule kept", got, err)
+	}
+
+	config := testConfig()
+	config.FatalErrorBehavior = "exit"
+	m = newTestMonitor(config, nil)
+	m.noteFatalError(fatal)
+	if _, err := m.afterFatalError(next, schedule); !errors.Is(err, ErrModuleNotFound) {
+		t.Errorf("exit: got error %v, want the fatal error", err)
+	}
+}
+
+func TestFatalErrorBackoff(t *testing.T) {
+	config := testConfig()
+	config.FatalErrorBehavior = "backoff"
+	config.CheckIntervalMinutes = 60
+	m := newTestMonitor(config, nil)
+	schedule := func(from time.Time) time.Time { return from.Add(time.Hour) }
+	fatal := fmt.Errorf("%w: powershell executable not found", ErrFatalEnvironment)
+
+	for _, want := range []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 6 * time.Hour, 6 * time.Hour} {
+		m.noteFatalError(fatal)
+		before := time.Now()
+		next, err := m.afterFatalError(time.Time{}, schedule)
+		if err != nil || next.Before(before.Add(want)) || next.After(time.Now().Add(want)) {
+			t.Errorf("got next check in %v, want %v", next.Sub(before), want)
+		}
+	}
+
+	// The regular schedule resumes once a check gets past the error
+	before := time.Now()
+	next, err := m.afterFatalError(time.Time{}, schedule)
+	if err != nil || m.fatalBackoff != 0 || next.Before(before.Add(time.Hour)) || next.After(time.Now().Add(time.Hour)) {
+		t.Errorf("got next check %v with backoff %v, want the schedule resumed", next, m.fatalBackoff)
+	}
+}
+
+func TestRunExitsOnFatalError(t *testing.T) {
+	config := testConfig()
+	config.FatalErrorBehavior = "exit"
+	m := newTestMonitor(config, staticRunner("", "", &exec.Error{Name: "powershell.exe", Err: exec.ErrNotFound}))
+
+	result := make(chan error, 1)
+	go func() { result <- m.Run() }()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrFatalEnvironment) {
+			t.Errorf("Run returned %v, want ErrFatalEnvironment", err)
+		}
+	case <-time.After(5 * time.Second):
+		m.Stop()
+		t.Fatal("Run kept going after a fatal error")
+	}
+}
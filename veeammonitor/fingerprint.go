@@ -0,0 +1,44 @@
+package veeammonitor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// Hex digits kept of an alert fingerprint
+const fingerprintLength = 16
+
+// Stable hash of a set of problems: the same jobs with the same statuses and
+// reasons, and the same low-space repositories, always give the same
+// fingerprint, whatever their order or the cycle that found them.
+func alertFingerprint(jobs []JobStatus, repos []RepositoryStatus) string {
+	var lines []string
+	for _, job := range jobs {
+		lines = append(lines, jobIdentity(job)+"|"+job.Status+"|"+fingerprintReason(job))
+	}
+	for _, repo := range repos {
+		lines = append(lines, "repository/"+strings.ToLower(repo.DisplayName())+"|Low space|")
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])[:fingerprintLength]
+}
+
+// Reason a job is in an alert, for its fingerprint. Finished sessions are
+// told apart by their end time and Veeam's description, so a new failure of
+// the same job changes the fingerprint. The descriptions of the other
+// statuses count up the time elapsed, which would change it every cycle, so
+// their status alone is the reason.
+func fingerprintReason(job JobStatus) string {
+	switch job.Status {
+	case "Failed", "Warning":
+		return job.EndTime + "|" + strings.Join(strings.Fields(job.Description), " ")
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package veeammonitor
+
+import "testing"
+
+func TestAlertFingerprintStable(t *testing.T) {
+	jobs := []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "2024-03-01T01:20:00", Description: "Disk full"},
+		{Name: "Weekly", JobType: "Backup", Status: "Warning", EndTime: "2024-03-02T03:00:00", Description: "Slow  storage"},
+		{Name: "Copy", JobType: "BackupCopy", Status: stuckStatus, Description: "Running for 3 hours"},
+	}
+	repos := []RepositoryStatus{{Name: "Repo1"}}
+
+	fingerprint := alertFingerprint(jobs, repos)
+	if len(fingerprint) != fingerprintLength {
+		t.Fatalf("got fingerprint %q, want %d hex digits", fingerprint, fingerprintLength)
+	}
+
+	reordered := []JobStatus{jobs[2], jobs[0], jobs[1]}
+	if got := alertFingerprint(reordered, repos); got != fingerprint {
+		t.Errorf("reordered jobs gave %s, want %s", got, fingerprint)
+	}
+
+	// A later cycle: the stuck job's description counts up, Veeam's
+	// whitespace differs, the problems are the same
+	later := append([]JobStatus{}, jobs...)
+	later[1].Description = "Slow storage"
+	later[2].Description = "Running for 4 hours"
+	if got := alertFingerprint(later, repos); got != fingerprint {
+		t.Errorf("same problems in a later cycle gave %s, want %s", got, fingerprint)
+	}
+
+	if got := alertFingerprint(nil, nil); got != "" {
+		t.Errorf("no problems gave %q, want none", got)
+	}
+}
+
+func TestAlertFingerprintChanges(t *testing.T) {
+	base := []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "2024-03-01T01:20:00", Description: "Disk full"}}
+	fingerprint := alertFingerprint(base, nil)
+
+	tests := []struct {
+		name  string
+		jobs  []JobStatus
+		repos []RepositoryStatus
+	}{
+		{"new session", []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "2024-03-02T01:20:00", Description: "Disk full"}}, nil},
+		{"new reason", []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "2024-03-01T01:20:00", Description: "Access denied"}}, nil},
+		{"new status", []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Warning", EndTime: "2024-03-01T01:20:00", Description: "Disk full"}}, nil},
+		{"another job", append(append([]JobStatus{}, base...), JobStatus{Name: "Weekly", JobType: "Backup", Status: "Failed"}), nil},
+		{"low space", base, []RepositoryStatus{{Name: "Repo1"}}},
+		{"recovered", nil, []RepositoryStatus{{Name: "Repo1"}}},
+	}
+	for _, test := range tests {
+		if got := alertFingerprint(test.jobs, test.repos); got == fingerprint {
+			t.Errorf("%s: fingerprint unchanged", test.name)
+		}
+	}
+}
+
+func TestIncidentKeyFollowsTheProblem(t *testing.T) {
+	config := testConfig()
+	job := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "2024-03-01T01:20:00", Description: "Disk full"}
+	key := incidentKey(config, job)
+
+	repeat := job
+	if got := incidentKey(config, repeat); got != key {
+		t.Errorf("same problem got key %s, want %s", got, key)
+	}
+
+	again := job
+	again.EndTime = "2024-03-02T01:20:00"
+	if got := incidentKey(config, again); got == key {
+		t.Error("new failed session kept the incident key")
+	}
+
+	other := job
+	other.Name = "Weekly"
+	if got := incidentKey(config, other); got == key {
+		t.Error("another job got the same incident key")
+	}
+}
@@ -0,0 +1,108 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Ways of rendering the jobs of an alert, for ReportFormat and ChannelFormats
+const (
+	reportFormatVerbose = "verbose" // A block of fields per job
+	reportFormatCompact = "compact" // One line per job
+)
+
+// Format a channel renders alerts in: its ChannelFormats entry, or
+// ReportFormat
+func (c *Config) channelFormat(channel string) string {
+	for name, format := range c.ChannelFormats {
+		if strings.EqualFold(name, channel) {
+			return format
+		}
+	}
+	return c.ReportFormat
+}
+
+// Config of a channel, with ReportFormat set to the format it renders in
+func (c *Config) forChannel(channel string) *Config {
+	config := *c
+	config.ReportFormat = c.channelFormat(channel)
+	return &config
+}
+
+// Whether alerts are rendered one line per job
+func (c *Config) compact() bool {
+	return c.ReportFormat == reportFormatCompact
+}
+
+// A job on one line, e.g. "[FAILED] SQL01 — Disk full (ended 03:14)"
+func compactJobLine(job JobStatus, config *Config, now time.Time) string {
+	line := fmt.Sprintf("[%s] %s", strings.ToUpper(displayStatus(job)), job.Name)
+	if description := strings.Join(strings.Fields(config.displayDescription(job.Description)), " "); description != "" {
+		line += " — " + description
+	}
+
+	when := ""
+	switch job.Status {
+	case "Failed", "Warning", deviationStatus:
+		if job.EndTime != "" {
+			when = "ended " + compactTime(job.EndTime, now)
+		}
+	case "Stale", disabledStatus:
+		lastRun := job.EndTime
+		if lastRun == "" {
+			lastRun = job.StartTime
+		}
+		when = "never ran"
+		if lastRun != "" {
+			when = "last ran " + compactTime(lastRun, now)
+		}
+	default:
+		if job.StartTime != "" {
+			when = "started " + compactTime(job.StartTime, now)
+		}
+	}
+	if when != "" {
+		line += " (" + when + ")"
+	}
+	if job.Escalated {
+		line += fmt.Sprintf(" [escalated after %d failed checks]", job.ConsecutiveFailures)
+	}
+	return line + noteLine(job, " [note: %s]")
+}
+
+// A displayed time shortened to the time of day when it falls on the same
+// day as now, or to the date and time otherwise
+func compactTime(value string, now time.Time) string {
+	at, err := time.ParseInLocation(displayTimeLayout, value, now.Location())
+	if err != nil {
+		return value
+	}
+	if at.Year() == now.Year() && at.YearDay() == now.YearDay() {
+		return at.Format("15:04")
+	}
+	return at.Format("Jan 2 15:04")
+}
+
+// A repository low on space on one line
+func compactRepositoryLine(repo RepositoryStatus) string {
+	return fmt.Sprintf("[LOW SPACE] %s — %s free of %s (%.1f%%)", repo.DisplayName(), formatGB(repo.FreeBytes), formatGB(repo.TotalBytes), repo.FreePercent())
+}
+
+// Render a report's jobs one line each, worst status first. Jobs listed
+// under a common failure cause are left out.
+func compactJobSections(report *AlertReport, config *Config) string {
+	body := ""
+	for _, job := range report.Jobs() {
+		if !report.inCause(job) {
+			body += compactJobLine(job, config, report.Timestamp) + "\n"
+		}
+	}
+	for _, line := range omittedSummary(report) {
+		body += line + report.omittedHint + "\n"
+	}
+	if body != "" {
+		body += "\n"
+	}
+	return body
+}
@@ -0,0 +1,97 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Jobs rendered in both formats, checked on the morning of March 1st
+func formatTestReport(config *Config) *AlertReport {
+	jobs := []JobStatus{
+		{Name: "SQL01", JobType: "Backup", Status: "Failed", StartTime: "3/1/2024 3:00:00 AM", EndTime: "3/1/2024 3:14:00 AM", Description: "Disk full"},
+		{Name: "Files", JobType: "Backup", Status: "Warning", StartTime: "2/29/2024 11:00:00 PM", EndTime: "2/29/2024 11:40:00 PM", Description: "Slow\n  network", Note: "Ticket 42"},
+		{Name: "Archive", JobType: "Backup", Status: "Stale", EndTime: "2/20/2024 1:00:00 AM"},
+		{Name: "Mail", JobType: "Backup", Status: "Running", StartTime: "3/1/2024 1:00:00 AM", Duration: "540"},
+	}
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+	return NewAlertReport(jobs, nil, severityCritical, config, now)
+}
+
+func TestCompactJobLine(t *testing.T) {
+	config := testConfig()
+	report := formatTestReport(config)
+	want := map[string]string{
+		"SQL01":   "[FAILED] SQL01 — Disk full (ended 03:14)",
+		"Files":   "[WARNING] Files — Slow network (ended Feb 29 23:40) [note: Ticket 42]",
+		"Archive": "[STALE] Archive (last ran Feb 20 01:00)",
+		"Mail":    "[RUNNING] Mail (started 01:00)",
+	}
+	for _, job := range report.Jobs() {
+		if got := compactJobLine(job, config, report.Timestamp); got != want[job.Name] {
+			t.Errorf("got %q, want %q", got, want[job.Name])
+		}
+	}
+
+	never := JobStatus{Name: "New", JobType: "Backup", Status: "Stale"}
+	if got := compactJobLine(never, config, report.Timestamp); got != "[STALE] New (never ran)" {
+		t.Errorf("got %q for a job that never ran", got)
+	}
+	retrying := JobStatus{Name: "SQL02", JobType: "Backup", Status: "Failed", RetryPending: true, Escalated: true, ConsecutiveFailures: 3}
+	if got := compactJobLine(retrying, config, report.Timestamp); got != "[FAILED (RETRY PENDING)] SQL02 [escalated after 3 failed checks]" {
+		t.Errorf("got %q for an escalated job pending retry", got)
+	}
+}
+
+func TestReportFormats(t *testing.T) {
+	verbose := testConfig()
+	_, body := buildEmailBody(formatTestReport(verbose), verbose)
+	for _, want := range []string{
+		"FAILED JOBS (1):\n--------------\nJob: SQL01\nType: Backup\nStatus: Failed\n",
+		"End Time: 3/1/2024 3:14:00 AM\n",
+		"WARNING JOBS (1):",
+		"Note: Ticket 42\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("verbose body doesn't contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "[FAILED]") {
+		t.Errorf("verbose body has compact lines:\n%s", body)
+	}
+
+	compact := testConfig()
+	compact.ReportFormat = reportFormatCompact
+	_, body = buildEmailBody(formatTestReport(compact), compact)
+	want := "[FAILED] SQL01 — Disk full (ended 03:14)\n" +
+		"[WARNING] Files — Slow network (ended Feb 29 23:40) [note: Ticket 42]\n" +
+		"[RUNNING] Mail (started 01:00)\n" +
+		"[STALE] Archive (last ran Feb 20 01:00)\n"
+	if !strings.Contains(body, want) {
+		t.Errorf("compact body doesn't contain\n%s\nin:\n%s", want, body)
+	}
+	if strings.Contains(body, "Job: SQL01") || strings.Contains(body, "FAILED JOBS") {
+		t.Errorf("compact body has verbose blocks:\n%s", body)
+	}
+}
+
+func TestChannelFormats(t *testing.T) {
+	config := testConfig()
+	config.ChannelFormats = map[string]string{"Slack": reportFormatCompact}
+	if !config.forChannel("slack").compact() || config.forChannel("email").compact() || config.compact() {
+		t.Fatal("channelFormats applied to the wrong channels")
+	}
+
+	report := formatTestReport(config)
+	data, err := json.Marshal(buildSlackMessages(report, config.forChannel("slack")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "[FAILED] SQL01 — Disk full (ended 03:14)") {
+		t.Errorf("Slack message isn't compact: %s", data)
+	}
+	if _, body := buildEmailBody(report, config.forChannel("email")); !strings.Contains(body, "Job: SQL01\n") {
+		t.Errorf("email isn't verbose:\n%s", body)
+	}
+}
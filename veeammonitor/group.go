@@ -0,0 +1,103 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Group of jobs matching no GroupMapping entry
+const ungroupedName = "Ungrouped"
+
+// JobGroup is the part of an AlertReport belonging to one GroupMapping group
+type JobGroup struct {
+	Name   string      `json:"name"`
+	Counts AlertCounts `json:"counts"`
+	Jobs   []JobStatus `json:"jobs"` // Grouped failed, warning, running, stale then deviation
+}
+
+// Group a job belongs to: the GroupMapping entry matching its name, or
+// Ungrouped
+func (c *Config) jobGroup(name string) string {
+	patterns := make([]string, 0, len(c.GroupMapping))
+	for pattern := range c.GroupMapping {
+		patterns = append(patterns, pattern)
+	}
+	if pattern, ok := matchJobName(patterns, name); ok && strings.TrimSpace(c.GroupMapping[pattern]) != "" {
+		return strings.TrimSpace(c.GroupMapping[pattern])
+	}
+	return ungroupedName
+}
+
+// Split jobs into their groups, sorted by name with Ungrouped last. Jobs keep
+// their order within a group.
+func groupJobs(jobs []JobStatus, config *Config) []JobGroup {
+	index := map[string]int{}
+	var groups []JobGroup
+	for _, job := range jobs {
+		name := config.jobGroup(job.Name)
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, JobGroup{Name: name})
+		}
+		groups[i].Jobs = append(groups[i].Jobs, job)
+	}
+
+	for i := range groups {
+		groups[i].Counts = groups[i].report().jobCounts()
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if (groups[i].Name == ungroupedName) != (groups[j].Name == ungroupedName) {
+			return groups[j].Name == ungroupedName
+		}
+		return strings.ToLower(groups[i].Name) < strings.ToLower(groups[j].Name)
+	})
+	return groups
+}
+
+// The group's jobs split by status, for rendering a group like a report
+func (g JobGroup) report() *AlertReport {
+	report := &AlertReport{}
+	for _, job := range g.Jobs {
+		report.addJob(job)
+	}
+	return report
+}
+
+// Group heading with its counts, e.g. "Finance: 3 jobs (2 failed, 1 warning)"
+func (g JobGroup) summary() string {
+	var parts []string
+	for _, count := range []struct {
+		n     int
+		label string
+	}{
+		{g.Counts.Failed, "failed"},
+		{g.Counts.Warning, "warning"},
+		{g.Counts.Stuck, "stuck"},
+		{g.Counts.Disabled, "disabled"},
+		{g.Counts.Missing, "missing"},
+		{g.Counts.Running, "long-running"},
+		{g.Counts.Stale, "stale"},
+		{g.Counts.Deviation, "size deviation"},
+		{g.Counts.Drift, "schedule drift"},
+	} {
+		if count.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count.n, count.label))
+		}
+	}
+	jobs := "jobs"
+	if g.Counts.Total == 1 {
+		jobs = "job"
+	}
+	return fmt.Sprintf("%s: %d %s (%s)", g.Name, g.Counts.Total, jobs, strings.Join(parts, ", "))
+}
+
+// A job's name or line prefixed with its group when the report is grouped
+func groupedJobName(job JobStatus, report *AlertReport, config *Config, name string) string {
+	if len(report.Groups) > 0 {
+		return fmt.Sprintf("[%s] %s", config.jobGroup(job.Name), name)
+	}
+	return name
+}
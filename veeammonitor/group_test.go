@@ -0,0 +1,96 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// GroupMapping used by the grouping tests
+func groupTestConfig() *Config {
+	config := testConfig()
+	config.GroupMapping = map[string]string{
+		"SQL-*":       "Databases",
+		"sql-prod-01": "Critical DB",
+		"FS-*":        " Files ",
+		"Temp-*":      " ",
+	}
+	return config
+}
+
+func TestJobGroup(t *testing.T) {
+	config := groupTestConfig()
+	tests := map[string]string{
+		"SQL-Reporting": "Databases",
+		"sql-reporting": "Databases",
+		"SQL-PROD-01":   "Critical DB",
+		"FS-01":         "Files",
+		"Temp-Scratch":  ungroupedName,
+		"Exchange":      ungroupedName,
+	}
+	for name, want := range tests {
+		if got := config.jobGroup(name); got != want {
+			t.Errorf("%s: got group %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGroupedReport(t *testing.T) {
+	config := groupTestConfig()
+	jobs := []JobStatus{
+		{Name: "Exchange", JobType: "Backup", Status: "Failed"},
+		{Name: "SQL-Reporting", JobType: "Backup", Status: "Warning"},
+		{Name: "FS-01", JobType: "Backup", Status: "Failed"},
+		{Name: "SQL-Billing", JobType: "Backup", Status: "Failed"},
+	}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+
+	var summaries []string
+	for _, group := range report.Groups {
+		summaries = append(summaries, group.summary())
+	}
+	want := []string{
+		"Databases: 2 jobs (1 failed, 1 warning)",
+		"Files: 1 job (1 failed)",
+		"Ungrouped: 1 job (1 failed)",
+	}
+	if !equalStrings(summaries, want) {
+		t.Fatalf("got groups %q, want %q", summaries, want)
+	}
+	if names := jobNames(report.Groups[0].Jobs); !equalStrings(names, []string{"SQL-Billing", "SQL-Reporting"}) {
+		t.Errorf("Databases has %v, want failed before warning", names)
+	}
+
+	_, body := buildEmailBody(report, config)
+	databases, files, ungrouped := strings.Index(body, "GROUP Databases"), strings.Index(body, "GROUP Files"), strings.Index(body, "GROUP Ungrouped")
+	if databases < 0 || files < databases || ungrouped < files {
+		t.Errorf("email body doesn't have the groups in order:\n%s", body)
+	}
+	heading := "GROUP Files: 1 job (1 failed)"
+	if !strings.Contains(body, heading+"\n"+strings.Repeat("=", len(heading))+"\n\nFAILED JOBS (1):") {
+		t.Errorf("email body doesn't render the Files group:\n%s", body)
+	}
+
+	text := ""
+	for _, message := range buildSlackMessages(report, config) {
+		data, _ := json.Marshal(message)
+		text += string(data)
+	}
+	for _, name := range []string{"[Databases] SQL-Billing", "[Files] FS-01", "[Ungrouped] Exchange"} {
+		if !strings.Contains(text, name) {
+			t.Errorf("Slack message doesn't show %s", name)
+		}
+	}
+}
+
+func TestReportWithoutGroupMapping(t *testing.T) {
+	config := testConfig()
+	report := NewAlertReport([]JobStatus{{Name: "SQL-Billing", JobType: "Backup", Status: "Failed"}}, nil, severityCritical, config, time.Now())
+	if len(report.Groups) != 0 {
+		t.Errorf("got groups %+v without a GroupMapping", report.Groups)
+	}
+	if _, body := buildEmailBody(report, config); strings.Contains(body, "GROUP ") {
+		t.Errorf("email body has groups:\n%s", body)
+	}
+}
@@ -0,0 +1,114 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Changes listed in one cycle event before the rest are summarized
+const cycleEventMaxChanges = 10
+
+// Summary of one check cycle kept for /status and the dashboard
+type cycleEvent struct {
+	Time         time.Time `json:"time"`
+	CycleID      string    `json:"cycleId,omitempty"`
+	Unreachable  bool      `json:"unreachable"`
+	Jobs         int       `json:"jobs"`         // Problematic jobs
+	Repositories int       `json:"repositories"` // Repositories low on space
+	Changes      []string  `json:"changes,omitempty"`
+}
+
+// Fixed-size ring of the most recent cycle events. Adding to a full ring
+// evicts the oldest event, so memory stays bounded however long the monitor
+// runs.
+type cycleEventRing struct {
+	mu     sync.Mutex
+	events []cycleEvent
+	next   int // Index the next event is written to
+	full   bool
+}
+
+func newCycleEventRing(capacity int) *cycleEventRing {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &cycleEventRing{events: make([]cycleEvent, capacity)}
+}
+
+// Add an event, evicting the oldest when the ring is full
+func (r *cycleEventRing) add(event cycleEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Copy of the events, oldest first
+func (r *cycleEventRing) list() []cycleEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]cycleEvent{}, r.events[:r.next]...)
+	}
+	events := append([]cycleEvent{}, r.events[r.next:]...)
+	return append(events, r.events[:r.next]...)
+}
+
+// Ring of recent cycle events, created with StatusHistorySize on first use
+func (m *Monitor) cycleHistory() *cycleEventRing {
+	m.historyOnce.Do(func() {
+		m.history = newCycleEventRing(m.Config.StatusHistorySize)
+	})
+	return m.history
+}
+
+// Summarize a cycle's status as an event, listing what changed since the
+// previous cycle: reachability, jobs with new or different problems, and
+// jobs no longer reported
+func newCycleEvent(status, previous cycleStatus, cycleID string) cycleEvent {
+	event := cycleEvent{
+		Time:         status.Checked,
+		CycleID:      cycleID,
+		Unreachable:  status.Unreachable,
+		Jobs:         len(status.Jobs),
+		Repositories: len(status.Repositories),
+	}
+
+	var changes []string
+	switch {
+	case status.Unreachable && !previous.Unreachable:
+		changes = append(changes, "Veeam server unreachable")
+	case !status.Unreachable && previous.Unreachable:
+		changes = append(changes, "Veeam server reachable again")
+	}
+	if !status.Unreachable {
+		before := map[string]string{}
+		for _, job := range previous.Jobs {
+			before[jobIdentity(job)] = job.Status
+		}
+		current := map[string]bool{}
+		for _, job := range status.Jobs {
+			key := jobIdentity(job)
+			current[key] = true
+			if was, ok := before[key]; !ok || was != job.Status {
+				changes = append(changes, fmt.Sprintf("%s (%s) is %s", job.Name, job.JobType, job.Status))
+			}
+		}
+		for _, job := range previous.Jobs {
+			if !current[jobIdentity(job)] {
+				changes = append(changes, fmt.Sprintf("%s (%s) is no longer %s", job.Name, job.JobType, job.Status))
+			}
+		}
+	}
+
+	if len(changes) > cycleEventMaxChanges {
+		more := len(changes) - cycleEventMaxChanges + 1
+		changes = append(changes[:cycleEventMaxChanges-1], fmt.Sprintf("... and %d more changes", more))
+	}
+	event.Changes = changes
+	return event
+}
@@ -0,0 +1,153 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Jobs counts of the events, which the tests use to tell events apart
+func eventJobCounts(events []cycleEvent) []int {
+	counts := make([]int, len(events))
+	for i, event := range events {
+		counts[i] = event.Jobs
+	}
+	return counts
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCycleEventRing(t *testing.T) {
+	ring := newCycleEventRing(3)
+	if events := ring.list(); len(events) != 0 {
+		t.Errorf("new ring has events %+v", events)
+	}
+
+	tests := []struct {
+		add  int
+		want []int
+	}{
+		{1, []int{1}},
+		{2, []int{1, 2}},
+		{3, []int{1, 2, 3}},
+		{4, []int{2, 3, 4}},
+		{5, []int{3, 4, 5}},
+		{6, []int{4, 5, 6}},
+		{7, []int{5, 6, 7}},
+	}
+	for _, test := range tests {
+		ring.add(cycleEvent{Jobs: test.add})
+		if got := eventJobCounts(ring.list()); !equalInts(got, test.want) {
+			t.Errorf("after adding %d got %v, want %v", test.add, got, test.want)
+		}
+	}
+
+	// Callers get a copy they can't change the ring through
+	events := ring.list()
+	events[0].Jobs = 100
+	if got := eventJobCounts(ring.list()); !equalInts(got, []int{5, 6, 7}) {
+		t.Errorf("changing the list changed the ring to %v", got)
+	}
+
+	ring = newCycleEventRing(0)
+	ring.add(cycleEvent{Jobs: 1})
+	ring.add(cycleEvent{Jobs: 2})
+	if got := eventJobCounts(ring.list()); !equalInts(got, []int{2}) {
+		t.Errorf("ring without capacity kept %v, want only the newest event", got)
+	}
+}
+
+func TestCycleEventRingConcurrent(t *testing.T) {
+	ring := newCycleEventRing(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ring.add(cycleEvent{Jobs: j})
+				if events := ring.list(); len(events) == 0 || len(events) > 10 {
+					t.Errorf("listed %d events from a ring of 10", len(events))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if events := ring.list(); len(events) != 10 {
+		t.Errorf("got %d events, want the ring full", len(events))
+	}
+}
+
+func TestNewCycleEvent(t *testing.T) {
+	previous := cycleStatus{Jobs: []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed"},
+		{Name: "Weekly", JobType: "Backup", Status: "Warning"},
+	}}
+	status := cycleStatus{Checked: time.Now(), Jobs: []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed"},
+		{Name: "Weekly", JobType: "Backup", Status: "Failed"},
+		{Name: "Offsite", JobType: "Backup Copy", Status: "Warning"},
+	}}
+	event := newCycleEvent(status, previous, "c1")
+	want := []string{"Weekly (Backup) is Failed", "Offsite (Backup Copy) is Warning"}
+	if event.Jobs != 3 || event.CycleID != "c1" || !equalStrings(event.Changes, want) {
+		t.Errorf("got event %+v, want changes %q", event, want)
+	}
+
+	event = newCycleEvent(cycleStatus{}, status, "c2")
+	if len(event.Changes) != 3 || event.Changes[0] != "Nightly (Backup) is no longer Failed" {
+		t.Errorf("got changes %q, want every job cleared", event.Changes)
+	}
+
+	if event := newCycleEvent(cycleStatus{Unreachable: true}, status, "c3"); !equalStrings(event.Changes, []string{"Veeam server unreachable"}) {
+		t.Errorf("got changes %q when the server went down", event.Changes)
+	}
+
+	var many []JobStatus
+	for i := 0; i < cycleEventMaxChanges+5; i++ {
+		many = append(many, JobStatus{Name: string(rune('A' + i)), JobType: "Backup", Status: "Failed"})
+	}
+	event = newCycleEvent(cycleStatus{Jobs: many}, cycleStatus{}, "c4")
+	if len(event.Changes) != cycleEventMaxChanges || event.Changes[cycleEventMaxChanges-1] != "... and 6 more changes" {
+		t.Errorf("got changes %q, want them capped", event.Changes)
+	}
+}
+
+func TestStatusHistory(t *testing.T) {
+	config := testConfig()
+	config.StatusHistorySize = 3
+	m := newTestMonitor(config, nil)
+	for i := 1; i <= 5; i++ {
+		jobs := make([]JobStatus, i)
+		for j := range jobs {
+			jobs[j] = JobStatus{Name: string(rune('A' + j)), JobType: "Backup", Status: "Failed"}
+		}
+		m.setStatus(cycleStatus{Checked: time.Now(), Jobs: jobs})
+	}
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status cycleStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if got := eventJobCounts(status.History); !equalInts(got, []int{3, 4, 5}) {
+		t.Errorf("got history %v, want the newest 3 checks oldest first", got)
+	}
+	if last := status.History[2]; !equalStrings(last.Changes, []string{"E (Backup) is Failed"}) {
+		t.Errorf("got changes %q in the newest event", last.Changes)
+	}
+}
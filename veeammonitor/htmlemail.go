@@ -0,0 +1,156 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+)
+
+// HTML alert email used when TemplatePath is empty. Styles are inline as
+// many mail clients drop style elements.
+const defaultHTMLEmailTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Subject}}</title>
+</head>
+<body style="margin: 0; padding: 16px; background: #f4f4f4; font-family: 'Segoe UI', Arial, sans-serif; font-size: 14px; color: #222;">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" style="max-width: 960px; margin: 0 auto; background: #fff; border-top: 6px solid {{.Color}};">
+<tr><td style="padding: 16px 20px;">
+{{if .LogoURL}}<img src="{{.LogoURL}}" alt="" style="max-height: 48px; margin-bottom: 12px;"><br>{{end}}
+<h1 style="margin: 0 0 4px; font-size: 20px;">Veeam Backup &amp; Replication Job Status Report</h1>
+<p style="margin: 0; color: #666;">{{.Server}} &middot; {{time .Timestamp "Mon Jan 2 15:04"}} &middot; Severity: <strong style="color: {{.Color}};">{{upper .Severity}}</strong></p>
+{{if .Client}}<p>Hello {{.Client}},<br>This report lists only your backup jobs.</p>{{end}}
+</td></tr>
+{{if .Causes}}
+<tr><td style="padding: 8px 20px;">
+<h2 style="margin: 8px 0; font-size: 16px;">Common failure causes ({{len .Causes}})</h2>
+{{range .Causes}}<p style="margin: 0 0 8px;"><strong style="color: {{statusColor .Status}};">{{causeSummary .}}</strong><br>{{causeJobs .}}</p>
+{{end}}
+</td></tr>
+{{end}}
+{{range .Sections}}
+<tr><td style="padding: 8px 20px;">
+<h2 style="margin: 8px 0; font-size: 16px; color: {{.Color}};">{{.Title}} ({{.Count}})</h2>
+{{if .Jobs}}
+<table width="100%" cellpadding="6" cellspacing="0" style="border-collapse: collapse; font-size: 13px;">
+<tr style="background: #f0f0f0; text-align: left;"><th>Job</th><th>Type</th><th>Status</th><th>Start</th><th>End</th><th>Details</th></tr>
+{{$color := .Color}}{{range .Jobs}}
+<tr style="vertical-align: top;">
+<td style="border-bottom: 1px solid #e5e5e5;"><strong>{{jobName .}}</strong></td>
+<td style="border-bottom: 1px solid #e5e5e5;">{{.JobType}}</td>
+<td style="border-bottom: 1px solid #e5e5e5; color: {{$color}}; font-weight: bold;">{{status .}}</td>
+<td style="border-bottom: 1px solid #e5e5e5;">{{.StartTime}}</td>
+<td style="border-bottom: 1px solid #e5e5e5;">{{.EndTime}}</td>
+<td style="border-bottom: 1px solid #e5e5e5;">{{description .Description}}{{if .FailingSince}}<br>Failing since {{.FailingSince}}{{end}}{{with nextRun .}}<br>Next run: {{.}}{{end}}{{if .Note}}<br><em>Note: {{.Note}}</em>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{if .Omitted}}<p style="color: #666;">{{.Omitted}}</p>{{end}}
+</td></tr>
+{{end}}
+{{if .Repositories}}
+<tr><td style="padding: 8px 20px;">
+<h2 style="margin: 8px 0; font-size: 16px; color: {{statusColor "Repository"}};">Repositories low on free space ({{len .Repositories}}, threshold {{.RepositoryThresholdPercent}}%)</h2>
+<table width="100%" cellpadding="6" cellspacing="0" style="border-collapse: collapse; font-size: 13px;">
+<tr style="background: #f0f0f0; text-align: left;"><th>Repository</th><th>Used</th><th>Free</th><th>Total</th></tr>
+{{range .Repositories}}
+<tr>
+<td style="border-bottom: 1px solid #e5e5e5;"><strong>{{.DisplayName}}</strong></td>
+<td style="border-bottom: 1px solid #e5e5e5;">{{gb .UsedBytes}}</td>
+<td style="border-bottom: 1px solid #e5e5e5;">{{gb .FreeBytes}} ({{printf "%.1f" .FreePercent}}%)</td>
+<td style="border-bottom: 1px solid #e5e5e5;">{{gb .TotalBytes}}</td>
+</tr>
+{{end}}
+</table>
+</td></tr>
+{{end}}
+<tr><td style="padding: 16px 20px; color: #888; font-size: 12px;">
+This is an automated message from the Veeam Backup Monitor.
+{{if .CycleID}}<br>Check cycle: {{.CycleID}}{{end}}
+{{if .Fingerprint}}<br>Alert fingerprint: {{.Fingerprint}}{{end}}
+</td></tr>
+</table>
+</body>
+</html>
+`
+
+// Values an HTML email template is executed with: every field of the
+// AlertReport, plus the jobs sorted into sections by status
+type htmlEmailData struct {
+	*AlertReport
+	Subject  string
+	LogoURL  string // EmailLogoURL
+	Color    string // Of the report's severity, as #RRGGBB
+	Sections []htmlEmailSection
+}
+
+// Jobs of one status. Jobs listed under a common failure cause are left out.
+type htmlEmailSection struct {
+	Status  string
+	Title   string // e.g. Failed jobs
+	Color   string // Of the status's severity
+	Count   int    // Jobs with the status, omitted and cause jobs included
+	Jobs    []JobStatus
+	Omitted string // How many jobs the email leaves out, empty when none
+}
+
+// Color of a severity for HTML emails, matching Discord and Slack
+func htmlColor(severity string) string {
+	return fmt.Sprintf("#%06X", discordColor(severity))
+}
+
+// Functions available to HTML email templates: those of report templates
+// and a few for the fields of jobs and causes
+func htmlEmailFuncs(report *AlertReport, config *Config) template.FuncMap {
+	funcs := template.FuncMap(reportTemplateFuncs(config))
+	funcs["statusColor"] = func(status string) string { return htmlColor(config.statusSeverity(status)) }
+	funcs["jobName"] = func(job JobStatus) string { return groupedJobName(job, report, config, job.Name) }
+	funcs["nextRun"] = func(job JobStatus) string { return config.nextRunLine(job, "%s") }
+	funcs["causeSummary"] = func(cause FailureCause) string { return cause.summary(config) }
+	funcs["causeJobs"] = func(cause FailureCause) string { return cause.jobList() }
+	return funcs
+}
+
+// Render the HTML body of an alert email from TemplatePath, or the built-in
+// template when it is empty. The file is read on every alert, so edits apply
+// without a restart.
+func renderHTMLEmail(report *AlertReport, config *Config, subject string) (string, error) {
+	text := defaultHTMLEmailTemplate
+	if config.TemplatePath != "" {
+		data, err := ioutil.ReadFile(config.TemplatePath)
+		if err != nil {
+			return "", fmt.Errorf("error reading email template: %v", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("email").Funcs(htmlEmailFuncs(report, config)).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing email template: %v", err)
+	}
+
+	data := htmlEmailData{
+		AlertReport: report,
+		Subject:     subject,
+		LogoURL:     config.EmailLogoURL,
+		Color:       htmlColor(report.Severity),
+	}
+	for _, section := range report.statusSections() {
+		data.Sections = append(data.Sections, htmlEmailSection{
+			Status:  section.status,
+			Title:   section.title,
+			Color:   htmlColor(config.statusSeverity(section.status)),
+			Count:   len(section.jobs) + section.omitted,
+			Jobs:    report.withoutCauses(section.jobs),
+			Omitted: omittedLine(section.omitted, section.label, report.omittedHint, "%s"),
+		})
+	}
+
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, data); err != nil {
+		return "", fmt.Errorf("error rendering email template: %v", err)
+	}
+	return html.String(), nil
+}
@@ -0,0 +1,121 @@
+package veeammonitor
+
+import (
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderHTMLEmail(t *testing.T) {
+	config := testConfig()
+	config.EmailLogoURL = "https://example.com/logo.png"
+	jobs := []JobStatus{
+		{Name: "Nightly <prod>", JobType: "Backup", Status: "Failed", Description: "Disk full & more"},
+		{Name: "Files", JobType: "Backup", Status: "Warning"},
+	}
+	repos := []RepositoryStatus{{Name: "Main", TotalBytes: 100 << 30, FreeBytes: 5 << 30}}
+	report := NewAlertReport(jobs, repos, severityCritical, config, time.Now())
+
+	html, err := renderHTMLEmail(report, config, "Veeam alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>Veeam alert</title>",
+		`<img src="https://example.com/logo.png"`,
+		"border-top: 6px solid #E74C3C;",
+		`<h2 style="margin: 8px 0; font-size: 16px; color: #E74C3C;">Failed jobs (1)</h2>`,
+		`<h2 style="margin: 8px 0; font-size: 16px; color: #F39C12;">Warning jobs (1)</h2>`,
+		"<strong>Nightly &lt;prod&gt;</strong>",
+		"Disk full &amp; more",
+		"<strong>Main</strong>",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML doesn't contain %q:\n%s", want, html)
+		}
+	}
+}
+
+func TestHTMLEmailTemplatePath(t *testing.T) {
+	config := testConfig()
+	config.TemplatePath = filepath.Join(t.TempDir(), "email.html")
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", Status: "Failed"}}, nil, severityCritical, config, time.Now())
+
+	if _, err := renderHTMLEmail(report, config, "Veeam alert"); err == nil || !strings.HasPrefix(err.Error(), "error reading email template: ") {
+		t.Errorf("got error %v for a missing template", err)
+	}
+
+	ioutil.WriteFile(config.TemplatePath, []byte(`<p>{{.Subject}}: {{range .Sections}}{{.Title}} {{.Count}} {{.Color}}{{end}}</p>`), 0644)
+	html, err := renderHTMLEmail(report, config, "Alert & more")
+	if err != nil || html != "<p>Alert &amp; more: Failed jobs 1 #E74C3C</p>" {
+		t.Errorf("got %q and error %v", html, err)
+	}
+
+	// Edits apply without a restart
+	ioutil.WriteFile(config.TemplatePath, []byte(`{{.NoSuchField}}`), 0644)
+	if _, err := renderHTMLEmail(report, config, "Veeam alert"); err == nil || !strings.HasPrefix(err.Error(), "error rendering email template: ") {
+		t.Errorf("got error %v for a template with an unknown field", err)
+	}
+	ioutil.WriteFile(config.TemplatePath, []byte(`{{if}}`), 0644)
+	if _, err := renderHTMLEmail(report, config, "Veeam alert"); err == nil || !strings.HasPrefix(err.Error(), "error parsing email template: ") {
+		t.Errorf("got error %v for a template that doesn't parse", err)
+	}
+}
+
+// Parts of a multipart/alternative message by content type
+func alternativeParts(t *testing.T, data string) map[string]string {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got content type %q, want multipart/alternative", msg.Header.Get("Content-Type"))
+	}
+	parts := map[string]string{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	return parts
+}
+
+func TestHTMLAlertEmail(t *testing.T) {
+	smtp := newFakeSMTP(t)
+	config := smtpTestConfig(smtp.addr, "ops@example.com")
+	config.HTMLEmail = true
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: "Disk full"}}, nil, severityCritical, config, time.Now())
+
+	if err := sendEmailAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+		t.Fatal(err)
+	}
+	parts := alternativeParts(t, smtp.delivered()[0].data)
+	if !strings.Contains(parts["text/plain"], "Job: Nightly\n") {
+		t.Errorf("got plain text part %q", parts["text/plain"])
+	}
+	if !strings.Contains(parts["text/html"], "<strong>Nightly</strong>") {
+		t.Errorf("got HTML part %q", parts["text/html"])
+	}
+
+	// A broken template still sends the plain text
+	config.TemplatePath = filepath.Join(t.TempDir(), "missing.html")
+	if err := sendEmailAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+		t.Fatal(err)
+	}
+	if data := smtp.delivered()[1].data; strings.Contains(data, "multipart/alternative") || !strings.Contains(data, "Job: Nightly\n") {
+		t.Errorf("got email %q, want plain text only", data)
+	}
+}
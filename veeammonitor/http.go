@@ -0,0 +1,114 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Handler returns the HTTP API of the monitor:
+//
+//	GET  /                      web page for snoozing the current problems
+//	POST /check                 queue an immediate check
+//	POST /ack                   acknowledge a job, silencing its alerts
+//	POST /pause?minutes=N       pause every notification for N minutes
+//	GET  /status                problems found by the last check
+//	GET  /jobs/{name}           one job's current status
+//	PUT  /jobs/{name}/note      attach a note shown with the job in alerts
+//	GET  /jobs/{name}/history   the job's recorded results, with historyDatabase
+//	GET  /metrics               metrics in the Prometheus text format
+//
+// Requests that change anything are refused when a browser says they come
+// from another site's page, see sameOrigin.
+func (m *Monitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", m.handleUI)
+	mux.HandleFunc("/check", m.handleCheck)
+	mux.HandleFunc("/ack", m.handleAck)
+	mux.HandleFunc("/pause", m.handlePause)
+	mux.HandleFunc("/status", m.handleStatus)
+	mux.HandleFunc("/jobs/", m.handleJob)
+	mux.HandleFunc("/metrics", m.handleMetrics)
+	return rejectCrossOrigin(mux)
+}
+
+// Refuse requests other than GET and HEAD that a browser sent from another
+// site, so a page elsewhere can't pause notifications or acknowledge jobs
+// through a user's browser. Clients such as curl send no Origin and pass.
+func rejectCrossOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			log.Printf("Rejected cross-origin %s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Whether a request came from this server's own pages, or from outside a
+// browser. Browsers mark cross-site requests with Sec-Fetch-Site, and send
+// Origin, or failing that Referer, which must then name the requested host.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, r.Host)
+}
+
+// How long requests in progress get to finish when the HTTP API shuts down
+const httpShutdownTimeout = 5 * time.Second
+
+// Serve the HTTP API on HTTPListenAddress until ctx is cancelled
+func (m *Monitor) serveHTTP(ctx context.Context) {
+	m.logf("HTTP API listening on %s\n", m.Config.HTTPListenAddress)
+	listenAndServe(ctx, m.Config.HTTPListenAddress, m.Handler())
+}
+
+// Serve handler on addr until ctx is cancelled, then shut down gracefully
+func listenAndServe(ctx context.Context, addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Error running HTTP API: %v\n", err)
+	}
+}
+
+// Queue an immediate check
+func (m *Monitor) handleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m.logf("Immediate check requested over HTTP from %s\n", r.RemoteAddr)
+	if !m.TriggerCheck() {
+		http.Error(w, "a check is already pending", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "check queued")
+}
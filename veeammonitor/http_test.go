@@ -0,0 +1,113 @@
+package veeammonitor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerRejectsCrossOriginRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"no origin, as from curl", http.MethodPost, nil, http.StatusOK},
+		{"own origin", http.MethodPost, map[string]string{"Origin": "http://monitor.example:8080"}, http.StatusOK},
+		{"own page as referer", http.MethodPost, map[string]string{"Referer": "http://monitor.example:8080/"}, http.StatusOK},
+		{"same-origin fetch", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://monitor.example:8080"}, http.StatusOK},
+		{"other origin", http.MethodPost, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"other site as referer", http.MethodPost, map[string]string{"Referer": "https://evil.example/page"}, http.StatusForbidden},
+		{"other port", http.MethodPost, map[string]string{"Origin": "http://monitor.example:9090"}, http.StatusForbidden},
+		{"opaque origin", http.MethodPost, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"cross-site fetch", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"cross-origin resume", http.MethodDelete, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestMonitor(testConfig(), nil)
+			request := httptest.NewRequest(test.method, "http://monitor.example:8080/pause?minutes=30", nil)
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			m.Handler().ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want {
+				t.Errorf("got status %d, want %d: %s", recorder.Code, test.want, recorder.Body)
+			}
+			paused := m.pauseState(time.Now()).Paused
+			if test.want == http.StatusForbidden && test.method == http.MethodPost && paused {
+				t.Error("rejected request paused notifications")
+			}
+		})
+	}
+}
+
+func TestHandlerAllowsCrossOriginReads(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	request := httptest.NewRequest(http.MethodGet, "http://monitor.example:8080/status", nil)
+	request.Header.Set("Origin", "https://dashboard.example")
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("got status %d, want 200", recorder.Code)
+	}
+}
+
+func TestPauseMinutesLimit(t *testing.T) {
+	tests := []struct {
+		minutes string
+		want    int
+	}{
+		{"1", http.StatusOK},
+		{"43200", http.StatusOK},
+		{"43201", http.StatusBadRequest},
+		{"9223372036854775807", http.StatusBadRequest},
+		{"99999999999999999999", http.StatusBadRequest},
+		{"0", http.StatusBadRequest},
+		{"-5", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		m := newTestMonitor(testConfig(), nil)
+		request := httptest.NewRequest(http.MethodPost, "/pause", strings.NewReader(`{"minutes": `+test.minutes+`}`))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		m.Handler().ServeHTTP(recorder, request)
+
+		if recorder.Code != test.want {
+			t.Errorf("minutes %s: got status %d, want %d", test.minutes, recorder.Code, test.want)
+		}
+	}
+}
+
+func TestListenAndServeStopsWithContext(t *testing.T) {
+	addr := closedAddress(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		listenAndServe(ctx, addr, http.NotFoundHandler())
+		close(done)
+	}()
+	// Wait for the server to come up
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server still running after the context ended")
+	}
+}
@@ -0,0 +1,127 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A channel that opens an incident per problematic job and closes it once
+// the job recovers: PagerDuty incidents or Opsgenie alerts
+type incidentChannel struct {
+	name    string          // Shown in logs, e.g. "PagerDuty"
+	channel string          // Name in NotificationRouting
+	open    map[string]bool // Keys of the open incidents, kept in the state
+	trigger func(ctx context.Context, key string, job JobStatus, fingerprint string) error
+	resolve func(ctx context.Context, key string) error
+}
+
+// Key identifying a job's incident, the PagerDuty dedup key and Opsgenie
+// alias: the job and the fingerprint of its problem. Repeat triggers for the
+// same problem group into one incident, while a new failed session or a
+// change of status opens a new one and closes the old.
+func incidentKey(config *Config, job JobStatus) string {
+	return strings.ToLower(fmt.Sprintf("veeam-monitor/%s/%s/%s/%s",
+		serverDisplayName(config), job.JobType, job.Name, alertFingerprint([]JobStatus{job}, nil)))
+}
+
+// Whether a job's status is enabled for alerting by the monitor toggles and
+// routed to an incident channel, PagerDuty or Opsgenie. Informational jobs
+// never page anyone.
+func pagesJob(config *Config, job JobStatus, channel string) bool {
+	if config.jobSeverity(job) == severityInfo || !config.routesJob(job, channel) {
+		return false
+	}
+	switch job.Status {
+	case "Failed":
+		return config.MonitorFailedJobs
+	case "Warning":
+		return config.MonitorWarningJobs
+	case stuckStatus:
+		return config.StuckSessionMinutes > 0
+	case disabledStatus:
+		return len(config.CriticalJobs) > 0
+	case missingStatus:
+		return len(config.ExpectedJobs) > 0
+	case "Running":
+		return config.MonitorRunningJobs
+	case "Stale":
+		return config.MaxJobAgeHours > 0
+	case deviationStatus:
+		return config.MonitorBackupSize
+	case driftStatus:
+		return config.ScheduleDriftMinutes > 0
+	}
+	return false
+}
+
+// Open incidents on a channel for newly problematic jobs and close the ones
+// that have recovered. Closes are only sent when the cycle saw every job
+// (complete). Opening follows the email alert: jobs below the alert
+// thresholds aren't paged, problems found by the first check are taken as
+// already open when SuppressInitialAlerts is set, and new incidents wait
+// while an alert is being debounced.
+func (m *Monitor) notifyIncidents(c incidentChannel, problematicJobs []JobStatus, complete bool) {
+	config := m.Config
+
+	var monitored []JobStatus
+	for _, job := range problematicJobs {
+		if pagesJob(config, job, c.channel) {
+			monitored = append(monitored, job)
+		}
+	}
+	fingerprint := alertFingerprint(monitored, nil)
+
+	current := map[string]bool{}
+	for _, job := range monitored {
+		current[incidentKey(config, job)] = true
+	}
+	initial := config.SuppressInitialAlerts && !m.warmedUp
+	for _, job := range jobsMeetingThresholds(config, monitored) {
+		key := incidentKey(config, job)
+		retrying := config.SuppressRetryPendingAlerts && job.RetryPending
+		if c.open[key] || job.Acknowledged || retrying {
+			continue
+		}
+		if initial {
+			m.logf("Not opening a %s incident for %s, its problem was found by the first check\n", c.name, job.Name)
+			c.open[key] = true
+			continue
+		}
+		if m.alertHeld() {
+			m.logf("%s incident for %s held with the alert\n", c.name, job.Name)
+			continue
+		}
+		if !m.allowNotification(c.name) {
+			continue
+		}
+
+		err := c.trigger(m.runContext(), key, job, fingerprint)
+		m.recordNotification(c.channel, err)
+		if err != nil {
+			m.logf("Error opening %s incident for %s: %v\n", c.name, job.Name, err)
+			continue
+		}
+		m.logf("%s incident opened for %s\n", c.name, job.Name)
+		c.open[key] = true
+	}
+
+	if !complete {
+		return
+	}
+
+	for key := range c.open {
+		if current[key] || !m.allowNotification(c.name) {
+			continue
+		}
+
+		err := c.resolve(m.runContext(), key)
+		m.recordNotification(c.channel, err)
+		if err != nil {
+			m.logf("Error closing %s incident %s: %v\n", c.name, key, err)
+			continue
+		}
+		m.logf("%s incident closed for %s\n", c.name, key)
+		delete(c.open, key)
+	}
+}
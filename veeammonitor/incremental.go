@@ -0,0 +1,116 @@
+package veeammonitor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// How far back incremental queries reach before the previous one started,
+// so sessions ending while it ran or small clock differences with the Veeam
+// server aren't missed. Jobs seen twice just replace their cached copy.
+const incrementalOverlap = 5 * time.Minute
+
+// PowerShell listing the jobs of a source whose last session ended after
+// since, or every job when since is zero, whatever their last result
+func changedJobsQuery(source string, since time.Time, config *Config) string {
+	filter := ""
+	if !since.IsZero() {
+		filter = fmt.Sprintf(`| Where-Object {$_.LastEnd -and $_.LastEnd -gt ([datetimeoffset]::Parse('%s', [cultureinfo]::InvariantCulture)).LocalDateTime} `,
+			since.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf(`%s %s| Select-Object Name,LastResult,LastStart,LastEnd,Description,NextRun,RetryPending | %s`, source, filter, convertToCsv(config))
+}
+
+// Bring the job cache up to date for IncrementalQueries. Each job type is
+// queried for the jobs that finished a session since its previous query,
+// which replace their cached copy; every job is queried instead on startup,
+// every FullQueryIntervalMinutes, and for job types whose previous query
+// failed, dropping jobs that no longer exist.
+func (m *Monitor) refreshJobCache(now time.Time) {
+	config := m.Config
+	full := m.jobCache == nil || now.Sub(m.lastFullQuery) >= time.Duration(config.FullQueryIntervalMinutes)*time.Minute
+	if m.jobCache == nil {
+		m.jobCache = map[string]JobStatus{}
+		m.jobCacheSince = map[string]time.Time{}
+	}
+	m.jobCacheErr = nil
+
+	changed, failures := 0, 0
+	var lastErr error
+	for _, jobType := range config.MonitorJobTypes {
+		since, queried := m.jobCacheSince[jobType]
+		if full || !queried {
+			since = time.Time{}
+		}
+
+		jobs, err := m.queryJobType(jobType, "changed", func(source string) string {
+			return changedJobsQuery(source, since, config)
+		}, "")
+		if errors.Is(err, ErrVeeamUnreachable) {
+			m.jobCacheErr = err
+			return
+		}
+		if err != nil {
+			lastErr = err
+			failures++
+			continue
+		}
+
+		m.jobCache = mergeJobCache(m.jobCache, jobs, jobTypeLabels[jobType], since.IsZero())
+		m.jobCacheSince[jobType] = now.Add(-incrementalOverlap)
+		changed += len(jobs)
+	}
+
+	if failures > 0 && failures == len(config.MonitorJobTypes) {
+		m.jobCacheErr = lastErr
+		return
+	}
+	if full && failures == 0 {
+		m.lastFullQuery = now
+		m.logf("Full job query found %d jobs\n", changed)
+	} else {
+		m.logf("Incremental job query found %d jobs with new sessions, %d jobs cached\n", changed, len(m.jobCache))
+	}
+}
+
+// Merge queried jobs of one job type into the cache, keyed by jobIdentity.
+// A full query replaces every cached job of the type, so deleted jobs go.
+func mergeJobCache(cache map[string]JobStatus, jobs []JobStatus, jobType string, full bool) map[string]JobStatus {
+	if full {
+		for key, job := range cache {
+			if job.JobType == jobType {
+				delete(cache, key)
+			}
+		}
+	}
+	for _, job := range jobs {
+		cache[jobIdentity(job)] = job
+	}
+	return cache
+}
+
+// Cached jobs whose last result was status, for IncrementalQueries
+func (m *Monitor) cachedJobsByStatus(status string) ([]JobStatus, error) {
+	if m.jobCache == nil && m.jobCacheErr == nil {
+		m.refreshJobCache(time.Now())
+	}
+	if m.jobCacheErr != nil {
+		return nil, m.jobCacheErr
+	}
+
+	jobs := []JobStatus{}
+	for _, job := range m.jobCache {
+		if job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].JobType != jobs[j].JobType {
+			return jobs[i].JobType < jobs[j].JobType
+		}
+		return jobs[i].Name < jobs[j].Name
+	})
+	return jobs, nil
+}
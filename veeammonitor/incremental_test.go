@@ -0,0 +1,109 @@
+package veeammonitor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedJobsQuery(t *testing.T) {
+	config := testConfig()
+	if query := changedJobsQuery("Get-VBRJob", time.Time{}, config); strings.Contains(query, "Where-Object") {
+		t.Errorf("full query filters jobs: %s", query)
+	}
+
+	since := time.Date(2024, 3, 1, 2, 0, 0, 0, time.FixedZone("CET", 3600))
+	query := changedJobsQuery("Get-VBRJob", since, config)
+	want := `Get-VBRJob | Where-Object {$_.LastEnd -and $_.LastEnd -gt ([datetimeoffset]::Parse('2024-03-01T01:00:00Z', [cultureinfo]::InvariantCulture)).LocalDateTime} | Select-Object Name,LastResult,LastStart,LastEnd,Description,NextRun,RetryPending | `
+	if !strings.HasPrefix(query, want) {
+		t.Errorf("got query %s, want it to start %s", query, want)
+	}
+}
+
+func TestMergeJobCache(t *testing.T) {
+	cache := map[string]JobStatus{}
+	cache = mergeJobCache(cache, []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed"},
+		{Name: "Weekly", JobType: "Backup", Status: "Success"},
+	}, "Backup", true)
+	cache = mergeJobCache(cache, []JobStatus{{Name: "Nightly", JobType: "Backup Copy", Status: "Warning"}}, "Backup Copy", true)
+
+	// Only changed jobs are queried, the rest stay cached
+	cache = mergeJobCache(cache, []JobStatus{{Name: "Weekly", JobType: "Backup", Status: "Failed"}}, "Backup", false)
+	if len(cache) != 3 || cache["backup/weekly"].Status != "Failed" || cache["backup/nightly"].Status != "Failed" {
+		t.Errorf("got cache %+v after an incremental query", cache)
+	}
+
+	// A full query drops jobs of its type that no longer exist
+	cache = mergeJobCache(cache, []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Success"}}, "Backup", true)
+	if _, ok := cache["backup/weekly"]; ok || len(cache) != 2 || cache["backup/nightly"].Status != "Success" || cache["backup copy/nightly"].Status != "Warning" {
+		t.Errorf("got cache %+v after a full query", cache)
+	}
+}
+
+func TestRefreshJobCache(t *testing.T) {
+	config := testConfig()
+	config.IncrementalQueries = true
+	config.FullQueryIntervalMinutes = 60
+	var scripts []string
+	rows := ""
+	m := newTestMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		scripts = append(scripts, script)
+		return jobCSVHeader + rows, "", nil
+	}))
+	cachedNames := func(status string) []string {
+		jobs, err := m.getJobsByStatus(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, job := range jobs {
+			names = append(names, job.Name)
+		}
+		return names
+	}
+	start := time.Now()
+
+	// Startup queries every job
+	rows = `"Nightly","Failed","2024-03-01T01:00:00","2024-03-01T01:20:00","","",""` + "\n" +
+		`"Weekly","Success","2024-03-01T02:00:00","2024-03-01T02:20:00","","",""` + "\n"
+	m.refreshJobCache(start)
+	if strings.Contains(scripts[0], "$_.LastEnd -gt") {
+		t.Error("the first query was incremental")
+	}
+	if got := cachedNames("Failed"); !equalStrings(got, []string{"Nightly"}) {
+		t.Errorf("got failed jobs %v, want Nightly", got)
+	}
+
+	// Later checks only query jobs with new sessions
+	rows = `"Weekly","Failed","2024-03-01T03:00:00","2024-03-01T03:20:00","","",""` + "\n"
+	m.refreshJobCache(start.Add(15 * time.Minute))
+	since := start.Add(-incrementalOverlap).UTC().Format(time.RFC3339)
+	if !strings.Contains(scripts[1], "$_.LastEnd -gt ([datetimeoffset]::Parse('"+since+"'") {
+		t.Errorf("second query isn't filtered on jobs ended since %s: %s", since, scripts[1])
+	}
+	if got := cachedNames("Failed"); !equalStrings(got, []string{"Nightly", "Weekly"}) {
+		t.Errorf("got failed jobs %v, want the cached Nightly and changed Weekly", got)
+	}
+
+	// A full query reconciles the cache once the interval has passed
+	rows = `"Nightly","Success","2024-03-01T04:00:00","2024-03-01T04:20:00","","",""` + "\n"
+	m.refreshJobCache(start.Add(61 * time.Minute))
+	if strings.Contains(scripts[2], "$_.LastEnd -gt") {
+		t.Error("the query after fullQueryIntervalMinutes was incremental")
+	}
+	if got := cachedNames("Failed"); len(got) != 0 || len(m.jobCache) != 1 {
+		t.Errorf("got failed jobs %v and cache %+v, want Weekly dropped", got, m.jobCache)
+	}
+}
+
+func TestRefreshJobCacheUnreachable(t *testing.T) {
+	config := testConfig()
+	config.IncrementalQueries = true
+	m := newTestMonitor(config, staticRunner(connectErrorMarker+" No connection could be made\n", "", errors.New("exit status 1")))
+
+	if _, err := m.getJobsByStatus("Failed"); !errors.Is(err, ErrVeeamUnreachable) {
+		t.Errorf("got error %v, want ErrVeeamUnreachable", err)
+	}
+}
@@ -0,0 +1,151 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Results /jobs/{name}/history returns unless ?limit= asks for another number
+const defaultHistoryLimit = 100
+
+// JobHistory stores the job results each check observes, so history and
+// how long a job has been failing survive restarts. HistoryDatabase selects
+// the SQLite store, compiled in with the sqlite build tag.
+type JobHistory interface {
+	// Record the job results a check observed: the problems it found and
+	// the last successful result of every other job, with Status Success.
+	// complete is false when a query failed, so jobs missing from the check
+	// may still be failing.
+	Record(server, cycleID string, jobs []JobStatus, complete bool, at time.Time) error
+	// First check of the job's current run of failed checks, zero when it
+	// isn't failing
+	FailingSince(server string, job JobStatus) (time.Time, error)
+	// Latest results of a job, newest first. An empty jobType matches any.
+	Results(server, name, jobType string, limit int) ([]HistoryResult, error)
+	Close() error
+}
+
+// HistoryResult is one job result recorded by a check
+type HistoryResult struct {
+	CheckedAt   time.Time `json:"checkedAt"`
+	CycleID     string    `json:"cycleId,omitempty"`
+	Server      string    `json:"server"`
+	Name        string    `json:"name"`
+	JobType     string    `json:"jobType"`
+	Status      string    `json:"status"`
+	StartTime   string    `json:"startTime,omitempty"`
+	EndTime     string    `json:"endTime,omitempty"`
+	Duration    string    `json:"duration,omitempty"`
+	Description string    `json:"description,omitempty"`
+}
+
+// Open HistoryDatabase, logging and carrying on without history when it
+// can't be opened
+func openConfiguredHistory(config *Config) JobHistory {
+	if config.HistoryDatabase == "" {
+		return nil
+	}
+	history, err := openJobHistory(config.HistoryDatabase, config.HistoryRetentionDays)
+	if err != nil {
+		log.Printf("Error opening history database, not keeping job history: %v\n", err)
+		return nil
+	}
+	log.Printf("Recording job history in %s\n", config.HistoryDatabase)
+	return history
+}
+
+// Record this check's problems and the successful results among allJobs in
+// the history, and mark failed jobs with the check that first found them
+// failing
+func (m *Monitor) recordHistory(jobs, allJobs []JobStatus, complete bool, now time.Time) []JobStatus {
+	if m.History == nil {
+		return jobs
+	}
+	server := serverDisplayName(m.Config)
+	observed := append(append([]JobStatus{}, jobs...), successfulResults(allJobs)...)
+	if err := m.History.Record(server, m.cycleID, observed, complete, now); err != nil {
+		m.logf("Error recording job history: %v\n", err)
+		return jobs
+	}
+	for i, job := range jobs {
+		if job.Status != "Failed" {
+			continue
+		}
+		since, err := m.History.FailingSince(server, job)
+		if err != nil {
+			m.logf("Error reading job history of %s: %v\n", job.Name, err)
+			continue
+		}
+		if !since.IsZero() {
+			jobs[i].FailingSince = since.In(m.Config.location()).Format(displayTimeLayout)
+		}
+	}
+	return jobs
+}
+
+// Jobs whose last result is a success, with the session's length in minutes
+// as Duration like SessionLister gives it, for success rates and durations
+func successfulResults(allJobs []JobStatus) []JobStatus {
+	var successes []JobStatus
+	for _, job := range allJobs {
+		if job.Status != "Success" {
+			continue
+		}
+		start, startErr := time.Parse(displayTimeLayout, job.StartTime)
+		end, endErr := time.Parse(displayTimeLayout, job.EndTime)
+		if startErr == nil && endErr == nil && !end.Before(start) {
+			job.Duration = fmt.Sprintf("%.0f", end.Sub(start).Minutes())
+		}
+		successes = append(successes, job)
+	}
+	return successes
+}
+
+// Format a job's FailingSince line, empty when it isn't known
+func failingSinceLine(job JobStatus, format string) string {
+	if job.FailingSince == "" {
+		return ""
+	}
+	return fmt.Sprintf(format, job.FailingSince)
+}
+
+// Report a job's recorded results as JSON, newest first: GET
+// /jobs/{name}/history, with ?type= to pick one job type and ?limit= for
+// more or fewer than the default 100
+func (m *Monitor) handleJobHistory(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if m.History == nil {
+		http.Error(w, "job history is not recorded, set historyDatabase", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := m.History.Results(serverDisplayName(m.Config), name, strings.TrimSpace(r.URL.Query().Get("type")), limit)
+	if err != nil {
+		m.logf("Error reading job history of %s over HTTP: %v\n", name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []HistoryResult{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,14 @@
+//go:build !sqlite
+
+package veeammonitor
+
+import "fmt"
+
+// Builds without the sqlite tag have no SQLite driver, keeping the default
+// build free of cgo. HistoryDatabase then fails to open: the monitor logs
+// the error and runs without job history, so alerts have no failing-since
+// times and /jobs/{name}/history answers 404. The weekly report doesn't
+// need it, being built from the reports archived in ReportHistoryDir.
+func openJobHistory(path string, retentionDays int) (JobHistory, error) {
+	return nil, fmt.Errorf("this build has no SQLite support, rebuild with go build -tags sqlite to use historyDatabase")
+}
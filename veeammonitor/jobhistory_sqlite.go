@@ -0,0 +1,162 @@
+//go:build sqlite
+
+package veeammonitor
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Tables of the history database. Each check is one row of checks, with a
+// row of job_results per job result it observed: each problem found and the
+// last result of every job that succeeded. Checks see a session until the
+// job runs again, so one session has a row in every check until then;
+// success rates count sessions by end_time.
+const sqliteHistorySchema = `
+CREATE TABLE IF NOT EXISTS checks (
+	id         INTEGER PRIMARY KEY,
+	server     TEXT NOT NULL,
+	cycle_id   TEXT NOT NULL,
+	checked_at INTEGER NOT NULL, -- Unix seconds
+	complete   INTEGER NOT NULL  -- 1 when every query succeeded
+);
+CREATE INDEX IF NOT EXISTS checks_server ON checks (server, checked_at);
+CREATE TABLE IF NOT EXISTS job_results (
+	check_id    INTEGER NOT NULL REFERENCES checks (id),
+	server      TEXT NOT NULL,
+	name        TEXT NOT NULL,
+	job_type    TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	start_time  TEXT NOT NULL,
+	end_time    TEXT NOT NULL,
+	duration    TEXT NOT NULL,
+	description TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS job_results_job ON job_results (server, name COLLATE NOCASE, job_type COLLATE NOCASE, check_id);
+CREATE INDEX IF NOT EXISTS job_results_check ON job_results (check_id);
+`
+
+// How often rows older than HistoryRetentionDays are deleted
+const historyPruneInterval = 24 * time.Hour
+
+// JobHistory kept in an SQLite database file
+type sqliteHistory struct {
+	db            *sql.DB
+	retentionDays int
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// Open or create the history database at path. Monitors of several servers
+// may share the file, waiting for each other's writes.
+func openJobHistory(path string, retentionDays int) (JobHistory, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteHistorySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating tables in %s: %v", path, err)
+	}
+	return &sqliteHistory{db: db, retentionDays: retentionDays}, nil
+}
+
+func (h *sqliteHistory) Record(server, cycleID string, jobs []JobStatus, complete bool, at time.Time) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO checks (server, cycle_id, checked_at, complete) VALUES (?, ?, ?, ?)", server, cycleID, at.Unix(), complete)
+	if err != nil {
+		return err
+	}
+	checkID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		_, err := tx.Exec("INSERT INTO job_results (check_id, server, name, job_type, status, start_time, end_time, duration, description) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			checkID, server, job.Name, job.JobType, job.Status, job.StartTime, job.EndTime, job.Duration, job.Description)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return h.prune(at)
+}
+
+// Delete checks older than the retention period, at most once a day
+func (h *sqliteHistory) prune(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.retentionDays <= 0 || now.Sub(h.lastPruned) < historyPruneInterval {
+		return nil
+	}
+	h.lastPruned = now
+
+	cutoff := now.AddDate(0, 0, -h.retentionDays).Unix()
+	if _, err := h.db.Exec("DELETE FROM job_results WHERE check_id IN (SELECT id FROM checks WHERE checked_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("error deleting old job results: %v", err)
+	}
+	if _, err := h.db.Exec("DELETE FROM checks WHERE checked_at < ?", cutoff); err != nil {
+		return fmt.Errorf("error deleting old checks: %v", err)
+	}
+	return nil
+}
+
+// The job has been failing since the first check that found it failed after
+// the last complete check that didn't
+func (h *sqliteHistory) FailingSince(server string, job JobStatus) (time.Time, error) {
+	var since sql.NullInt64
+	err := h.db.QueryRow(`
+SELECT MIN(c.checked_at) FROM checks c JOIN job_results r ON r.check_id = c.id
+WHERE r.server = ? AND r.name = ? COLLATE NOCASE AND r.job_type = ? COLLATE NOCASE AND r.status = 'Failed'
+AND c.id > COALESCE((
+	SELECT MAX(k.id) FROM checks k WHERE k.server = ? AND k.complete = 1 AND NOT EXISTS (
+		SELECT 1 FROM job_results x
+		WHERE x.check_id = k.id AND x.name = ? COLLATE NOCASE AND x.job_type = ? COLLATE NOCASE AND x.status = 'Failed')
+), 0)`, server, job.Name, job.JobType, server, job.Name, job.JobType).Scan(&since)
+	if err != nil || !since.Valid {
+		return time.Time{}, err
+	}
+	return time.Unix(since.Int64, 0), nil
+}
+
+func (h *sqliteHistory) Results(server, name, jobType string, limit int) ([]HistoryResult, error) {
+	rows, err := h.db.Query(`
+SELECT c.checked_at, c.cycle_id, r.server, r.name, r.job_type, r.status, r.start_time, r.end_time, r.duration, r.description
+FROM job_results r JOIN checks c ON c.id = r.check_id
+WHERE r.server = ? AND r.name = ? COLLATE NOCASE AND (? = '' OR r.job_type = ? COLLATE NOCASE)
+ORDER BY c.id DESC LIMIT ?`, server, name, jobType, jobType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []HistoryResult
+	for rows.Next() {
+		var result HistoryResult
+		var checkedAt int64
+		if err := rows.Scan(&checkedAt, &result.CycleID, &result.Server, &result.Name, &result.JobType, &result.Status,
+			&result.StartTime, &result.EndTime, &result.Duration, &result.Description); err != nil {
+			return nil, err
+		}
+		result.CheckedAt = time.Unix(checkedAt, 0)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (h *sqliteHistory) Close() error {
+	return h.db.Close()
+}
@@ -0,0 +1,96 @@
+//go:build sqlite
+
+package veeammonitor
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// History database in a temporary directory
+func openTestHistory(t *testing.T, retentionDays int) JobHistory {
+	history, err := openJobHistory(filepath.Join(t.TempDir(), "history.db"), retentionDays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { history.Close() })
+	return history
+}
+
+func TestSQLiteFailingSince(t *testing.T) {
+	history := openTestHistory(t, 0)
+	nightly := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	checks := []struct {
+		jobs     []JobStatus
+		complete bool
+		want     time.Time
+	}{
+		{[]JobStatus{nightly}, true, start},
+		{[]JobStatus{nightly}, true, start},
+		// A check whose queries failed doesn't end the run of failures
+		{nil, false, start},
+		{[]JobStatus{nightly}, true, start},
+		// A complete check without the failure does
+		{nil, true, time.Time{}},
+		{[]JobStatus{nightly}, true, start.Add(5 * time.Hour)},
+	}
+	for i, check := range checks {
+		at := start.Add(time.Duration(i) * time.Hour)
+		if err := history.Record("vbr01", "cycle", check.jobs, check.complete, at); err != nil {
+			t.Fatal(err)
+		}
+		since, err := history.FailingSince("vbr01", JobStatus{Name: "NIGHTLY", JobType: "backup"})
+		if err != nil || !since.Equal(check.want) {
+			t.Errorf("check %d: failing since %v, %v, want %v", i+1, since, err, check.want)
+		}
+	}
+	if since, _ := history.FailingSince("vbr02", nightly); !since.IsZero() {
+		t.Errorf("got failing since %v on another server", since)
+	}
+}
+
+func TestSQLiteResults(t *testing.T) {
+	history := openTestHistory(t, 0)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	for i, status := range []string{"Failed", "Warning", "Failed"} {
+		jobs := []JobStatus{
+			{Name: "Nightly", JobType: "Backup", Status: status, EndTime: "3/1/2024 1:20:00 AM", Description: "Disk full"},
+			{Name: "Nightly", JobType: "Backup Copy", Status: "Failed"},
+		}
+		if err := history.Record("vbr01", fmt.Sprintf("cycle-%d", i+1), jobs, true, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := history.Results("vbr01", "nightly", "Backup", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Status != "Failed" || results[1].Status != "Warning" {
+		t.Fatalf("got %+v, want the newest two results", results)
+	}
+	first := results[0]
+	if first.CycleID != "cycle-3" || !first.CheckedAt.Equal(start.Add(2*time.Hour)) || first.Server != "vbr01" || first.EndTime != "3/1/2024 1:20:00 AM" || first.Description != "Disk full" {
+		t.Errorf("got result %+v", first)
+	}
+	if all, _ := history.Results("vbr01", "Nightly", "", 100); len(all) != 6 {
+		t.Errorf("got %d results of any type, want 6", len(all))
+	}
+}
+
+func TestSQLitePrunesOldChecks(t *testing.T) {
+	history := openTestHistory(t, 30)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	nightly := []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}
+	history.Record("vbr01", "old", nightly, true, start)
+	history.Record("vbr01", "recent", nightly, true, start.AddDate(0, 0, 20))
+	history.Record("vbr01", "new", nightly, true, start.AddDate(0, 0, 40))
+
+	results, err := history.Results("vbr01", "Nightly", "", 100)
+	if err != nil || len(results) != 2 || results[1].CycleID != "recent" {
+		t.Errorf("got %+v and error %v, want the check older than 30 days deleted", results, err)
+	}
+}
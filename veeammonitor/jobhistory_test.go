@@ -0,0 +1,163 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// JobHistory keeping what is recorded in memory
+type memoryHistory struct {
+	records [][]JobStatus
+	since   time.Time // Returned by FailingSince for every job
+
+	queried string // Arguments of the last Results call
+	results []HistoryResult
+}
+
+func (h *memoryHistory) Record(server, cycleID string, jobs []JobStatus, complete bool, at time.Time) error {
+	h.records = append(h.records, jobs)
+	return nil
+}
+
+func (h *memoryHistory) FailingSince(server string, job JobStatus) (time.Time, error) {
+	return h.since, nil
+}
+
+func (h *memoryHistory) Results(server, name, jobType string, limit int) ([]HistoryResult, error) {
+	h.queried = fmt.Sprintf("%s %s %q %d", server, name, jobType, limit)
+	return h.results, nil
+}
+
+func (h *memoryHistory) Close() error { return nil }
+
+// Source listing a fixed set of jobs, the ones whose result is a problem
+// also being returned by JobsByStatus
+type stubSource struct {
+	jobs []JobStatus
+}
+
+func (s stubSource) JobsByStatus(status string) ([]JobStatus, error) {
+	var jobs []JobStatus
+	for _, job := range s.jobs {
+		if job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (s stubSource) LongRunningJobs() ([]JobStatus, error)     { return nil, nil }
+func (s stubSource) StaleJobs() ([]JobStatus, error)           { return nil, nil }
+func (s stubSource) Repositories() ([]RepositoryStatus, error) { return nil, nil }
+func (s stubSource) AllJobs() ([]JobStatus, error)             { return s.jobs, nil }
+
+func TestHistoryRecordsSuccessfulResults(t *testing.T) {
+	history := &memoryHistory{}
+	m, _ := newCaptureMonitor(testConfig(), nil)
+	m.History = history
+	m.Source = stubSource{[]JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", StartTime: "3/1/2024 1:00:00 AM", EndTime: "3/1/2024 1:20:00 AM"},
+		{Name: "Weekly", JobType: "Backup", Status: "Success", StartTime: "3/1/2024 2:00:00 AM", EndTime: "3/1/2024 2:45:00 AM"},
+	}}
+	m.RunCheckCycle()
+
+	if len(history.records) != 1 {
+		t.Fatalf("recorded %d checks, want 1", len(history.records))
+	}
+	byName := map[string]JobStatus{}
+	for _, job := range history.records[0] {
+		if _, ok := byName[job.Name]; ok {
+			t.Errorf("%s recorded twice", job.Name)
+		}
+		byName[job.Name] = job
+	}
+	if byName["Nightly"].Status != "Failed" {
+		t.Errorf("got Nightly %+v, want its failure", byName["Nightly"])
+	}
+	if weekly := byName["Weekly"]; weekly.Status != "Success" || weekly.Duration != "45" {
+		t.Errorf("got Weekly %+v, want a 45 minute success", weekly)
+	}
+}
+
+func TestSuccessfulResults(t *testing.T) {
+	jobs := []JobStatus{
+		{Name: "Failed", Status: "Failed"},
+		{Name: "Running", Status: "None"},
+		{Name: "Done", Status: "Success", StartTime: "3/1/2024 11:50:00 PM", EndTime: "3/2/2024 12:10:00 AM"},
+		{Name: "Unknown length", Status: "Success", StartTime: "3/1/2024 11:50:00 PM"},
+	}
+	got := successfulResults(jobs)
+	if len(got) != 2 || got[0].Name != "Done" || got[1].Name != "Unknown length" {
+		t.Fatalf("got %+v, want the two successes", got)
+	}
+	if got[0].Duration != "20" || got[1].Duration != "" {
+		t.Errorf("got durations %q and %q, want 20 and none", got[0].Duration, got[1].Duration)
+	}
+}
+
+func TestAlertsShowFailingSince(t *testing.T) {
+	config := testConfig()
+	config.Timezone = "UTC"
+	history := &memoryHistory{since: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)}
+	m, capture := newCaptureMonitor(config, nil)
+	m.History = history
+	m.Source = stubSource{[]JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed"},
+		{Name: "Files", JobType: "Backup", Status: "Warning"},
+	}}
+	config.MonitorWarningJobs = true
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d reports, want 1", len(sent))
+	}
+	for _, job := range sent[0].Jobs() {
+		want := ""
+		if job.Status == "Failed" {
+			want = "3/1/2024 8:00:00 AM"
+		}
+		if job.FailingSince != want {
+			t.Errorf("%s: failing since %q, want %q", job.Name, job.FailingSince, want)
+		}
+	}
+	if _, body := buildEmailBody(sent[0], config); !strings.Contains(body, "Failing Since: 3/1/2024 8:00:00 AM") {
+		t.Errorf("email doesn't say since when the job fails:\n%s", body)
+	}
+}
+
+func TestJobHistoryHandler(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	if recorder := serveJobRequest(m, http.MethodGet, "/jobs/Nightly/history"); recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d without a history database", recorder.Code)
+	}
+
+	history := &memoryHistory{}
+	m.History = history
+	recorder := serveJobRequest(m, http.MethodGet, "/jobs/Nightly/history")
+	if recorder.Code != http.StatusOK || strings.TrimSpace(recorder.Body.String()) != "[]" || history.queried != `localhost Nightly "" 100` {
+		t.Errorf("got status %d, body %q and query %s", recorder.Code, recorder.Body, history.queried)
+	}
+
+	history.results = []HistoryResult{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}
+	recorder = serveJobRequest(m, http.MethodGet, "/jobs/Nightly/history?type=Backup&limit=5")
+	if !strings.Contains(recorder.Body.String(), `"status":"Failed"`) || history.queried != `localhost Nightly "Backup" 5` {
+		t.Errorf("got body %q and query %s", recorder.Body, history.queried)
+	}
+
+	for _, test := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/jobs/Nightly/history?limit=0", http.StatusBadRequest},
+		{http.MethodGet, "/jobs/Nightly/history?limit=many", http.StatusBadRequest},
+		{http.MethodPost, "/jobs/Nightly/history", http.StatusMethodNotAllowed},
+	} {
+		if recorder := serveJobRequest(m, test.method, test.path); recorder.Code != test.want {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.path, recorder.Code, test.want)
+		}
+	}
+}
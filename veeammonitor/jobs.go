@@ -0,0 +1,137 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// JobFinder is implemented by sources that can query a single job by name,
+// in every monitored job type it exists in
+type JobFinder interface {
+	FindJob(name string) ([]JobStatus, error)
+}
+
+// Query one job by name, narrowing each job type's cmdlet with -Name so
+// Veeam doesn't have to enumerate every job
+func (m *Monitor) findJob(name string) ([]JobStatus, error) {
+	return m.queryJobTypes("named", func(source string) string {
+		return fmt.Sprintf(`%s | Where-Object {$_.Name -eq %s} | Select-Object Name,LastResult,LastStart,LastEnd,Description,NextRun,RetryPending | %s`,
+			namedJobSource(source, name), powerShellQuote(name), convertToCsv(m.Config))
+	}, "")
+}
+
+// Add -Name to the cmdlet a jobTypeSources entry starts with. The name is
+// escaped as -Name takes wildcards, and matched exactly afterwards.
+func namedJobSource(source, name string) string {
+	cmdlet := source
+	if i := strings.IndexAny(source, " \n"); i >= 0 {
+		cmdlet = source[:i]
+	}
+	return fmt.Sprintf("%s -Name ([WildcardPattern]::Escape(%s))%s", cmdlet, powerShellQuote(name), source[len(cmdlet):])
+}
+
+// Jobs named name from the last check's problems, or queried from the source
+// when the last check didn't find the job problematic
+func (m *Monitor) lookupJob(name string) ([]JobStatus, error) {
+	var jobs []JobStatus
+	m.statusMu.Lock()
+	for _, job := range m.status.Jobs {
+		if strings.EqualFold(job.Name, name) {
+			jobs = append(jobs, job)
+		}
+	}
+	m.statusMu.Unlock()
+	if len(jobs) > 0 {
+		return jobs, nil
+	}
+
+	// Sources aren't safe to query while a check is using them
+	m.cycleMu.Lock()
+	defer m.cycleMu.Unlock()
+	source := m.source()
+	if finder, ok := source.(JobFinder); ok {
+		return finder.FindJob(name)
+	}
+	lister, ok := source.(JobLister)
+	if !ok {
+		return nil, fmt.Errorf("the job source can't look up single jobs")
+	}
+	all, err := lister.AllJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range all {
+		if strings.EqualFold(job.Name, name) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// Report one job's current status as JSON. Jobs the last check found
+// problematic are answered from its results, others are queried. Names used
+// by several job types need ?type=, e.g. ?type=Backup%20Copy. Job notes are
+// handled under /jobs/{name}/note and recorded results under
+// /jobs/{name}/history.
+func (m *Monitor) handleJob(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	if note := strings.TrimSuffix(name, "/note"); note != name && note != "" && !strings.Contains(note, "/") {
+		m.handleJobNote(w, r, note)
+		return
+	}
+	if history := strings.TrimSuffix(name, "/history"); history != name && history != "" && !strings.Contains(history, "/") {
+		m.handleJobHistory(w, r, history)
+		return
+	}
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "expected /jobs/{name}, /jobs/{name}/note or /jobs/{name}/history", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobs, err := m.lookupJob(name)
+	if err != nil {
+		m.logf("Error looking up job %s over HTTP: %v\n", name, err)
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrVeeamUnreachable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if jobType := r.URL.Query().Get("type"); jobType != "" {
+		var ofType []JobStatus
+		for _, job := range jobs {
+			if strings.EqualFold(job.JobType, jobType) {
+				ofType = append(ofType, job)
+			}
+		}
+		jobs = ofType
+	}
+
+	switch len(jobs) {
+	case 0:
+		http.Error(w, fmt.Sprintf("job %q not found", name), http.StatusNotFound)
+		return
+	case 1:
+	default:
+		var types []string
+		for _, job := range jobs {
+			types = append(types, job.JobType)
+		}
+		sort.Strings(types)
+		http.Error(w, fmt.Sprintf("several jobs are named %q, add ?type= with one of: %s", name, strings.Join(types, ", ")), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.applyNotes(jobs)[0])
+}
@@ -0,0 +1,99 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Request a path from the monitor's HTTP handler
+func serveJobRequest(m *Monitor, method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder
+}
+
+func TestJobHandlerQueriesJob(t *testing.T) {
+	var scripts []string
+	m := newTestMonitor(testConfig(), fakeRunner(func(script string) (string, string, error) {
+		scripts = append(scripts, script)
+		return jobCSVHeader + `"Nightly's","Success","2024-03-01T01:00:00","2024-03-01T01:20:00","","",""` + "\n", "", nil
+	}))
+
+	recorder := serveJobRequest(m, http.MethodGet, "/jobs/Nightly's")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+	var job JobStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Name != "Nightly's" || job.JobType != "Backup" || job.Status != "Success" {
+		t.Errorf("got job %+v", job)
+	}
+	if len(scripts) != 1 || !strings.Contains(scripts[0], "Get-VBRJob -Name ([WildcardPattern]::Escape('Nightly''s'))") {
+		t.Errorf("ran %q, want a query for the one job", scripts)
+	}
+}
+
+func TestJobHandlerAnswersFromLastCheck(t *testing.T) {
+	m := newTestMonitor(testConfig(), staticRunner("", "", errors.New("PowerShell shouldn't run")))
+	m.setStatus(cycleStatus{Jobs: []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: "Disk full"}}})
+
+	recorder := serveJobRequest(m, http.MethodGet, "/jobs/nightly")
+	var job JobStatus
+	json.NewDecoder(recorder.Body).Decode(&job)
+	if recorder.Code != http.StatusOK || job.Name != "Nightly" || job.Description != "Disk full" {
+		t.Errorf("got status %d and job %+v, want the failed job from the last check", recorder.Code, job)
+	}
+}
+
+func TestJobHandlerNotFound(t *testing.T) {
+	m := newTestMonitor(testConfig(), staticRunner(jobCSVHeader, "", nil))
+	for _, path := range []string{"/jobs/Missing", "/jobs/", "/jobs/a/b"} {
+		if recorder := serveJobRequest(m, http.MethodGet, path); recorder.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want 404", path, recorder.Code)
+		}
+	}
+	if recorder := serveJobRequest(m, http.MethodDelete, "/jobs/Nightly"); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: got status %d, want 405", recorder.Code)
+	}
+}
+
+func TestJobHandlerPowerShellErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		runner fakeRunner
+		want   int
+	}{
+		{"script failure", staticRunner("", "Get-VBRJob : Access is denied", errors.New("exit status 1")), http.StatusBadGateway},
+		{"server unreachable", staticRunner(connectErrorMarker+" No connection could be made\n", "", errors.New("exit status 1")), http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		m := newTestMonitor(testConfig(), test.runner)
+		recorder := serveJobRequest(m, http.MethodGet, "/jobs/Nightly")
+		if recorder.Code != test.want {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, recorder.Code, test.want, recorder.Body)
+		}
+	}
+}
+
+func TestJobHandlerSeveralJobTypes(t *testing.T) {
+	config := testConfig()
+	config.MonitorJobTypes = []string{"backup", "copy"}
+	m := newTestMonitor(config, staticRunner(jobCSVHeader+`"Nightly","Success","","","","",""`+"\n", "", nil))
+
+	if recorder := serveJobRequest(m, http.MethodGet, "/jobs/Nightly"); recorder.Code != http.StatusConflict ||
+		!strings.Contains(recorder.Body.String(), "Backup, Backup Copy") {
+		t.Errorf("got status %d: %s, want a conflict naming the job types", recorder.Code, recorder.Body)
+	}
+	recorder := serveJobRequest(m, http.MethodGet, "/jobs/Nightly?type=backup%20copy")
+	var job JobStatus
+	json.NewDecoder(recorder.Body).Decode(&job)
+	if recorder.Code != http.StatusOK || job.JobType != "Backup Copy" {
+		t.Errorf("got status %d and job %+v, want the Backup Copy job", recorder.Code, job)
+	}
+}
@@ -0,0 +1,186 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timestamp layout of log lines when LogTimestampFormat is empty, the same
+// as the standard logger's
+const defaultLogTimestampFormat = "2006/01/02 15:04:05"
+
+// Names accepted for LogTimestampFormat besides Go time layouts
+var logTimestampFormats = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"iso8601":     "2006-01-02T15:04:05.000Z07:00",
+}
+
+// LogWriter writes log lines with a LogTimestampFormat timestamp. With
+// LogDedupSeconds set, a message repeating the previous one is held back and
+// counted, like syslog does.
+type LogWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	layout string
+	loc    *time.Location
+
+	dedup   time.Duration // Longest a repeat count is held before it is written, 0 disables
+	last    string        // Previous message, without its prefix
+	repeats int           // Times last was repeated since it was written
+	flush   *time.Timer   // Writes the repeat count once dedup has passed
+}
+
+// NewLogWriter wraps out in a LogWriter formatting timestamps as configured
+func NewLogWriter(out io.Writer, config *Config) *LogWriter {
+	return &LogWriter{
+		out:    out,
+		layout: config.logTimestampLayout(),
+		loc:    config.location(),
+		dedup:  time.Duration(config.LogDedupSeconds) * time.Second,
+	}
+}
+
+// UseLogWriter sends the standard logger's output through a LogWriter, in
+// place of the logger's own timestamps
+func UseLogWriter(config *Config) {
+	out := log.Writer()
+	if w, ok := out.(*LogWriter); ok {
+		out = w.out
+	}
+	log.SetOutput(NewLogWriter(out, config))
+	log.SetFlags(0)
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	message := string(p)
+	if w.dedup > 0 {
+		if message == w.last {
+			w.repeats++
+			if w.flush == nil {
+				var timer *time.Timer
+				timer = time.AfterFunc(w.dedup, func() { w.flushRepeats(&timer) })
+				w.flush = timer
+			}
+			return len(p), nil
+		}
+		if err := w.writeRepeats(); err != nil {
+			return 0, err
+		}
+		w.last = message
+	}
+
+	if err := w.writeLines(message); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Write text, one prefixed line per line of it
+func (w *LogWriter) writeLines(text string) error {
+	prefix := time.Now().In(w.loc).Format(w.layout) + " "
+
+	var line bytes.Buffer
+	for _, text := range strings.SplitAfter(text, "\n") {
+		if text != "" {
+			line.WriteString(prefix + text)
+		}
+	}
+	_, err := w.out.Write(line.Bytes())
+	return err
+}
+
+// Write how often the previous message was repeated, if it was
+func (w *LogWriter) writeRepeats() error {
+	if w.flush != nil {
+		w.flush.Stop()
+		w.flush = nil
+	}
+	if w.repeats == 0 {
+		return nil
+	}
+	repeats := w.repeats
+	w.repeats = 0
+	return w.writeLines(fmt.Sprintf("(last message repeated %d times)\n", repeats))
+}
+
+// Write the repeat count once dedup has passed, so it isn't held back until
+// a different message arrives. A later repeat is then written in full again.
+// A timer stopped by a different message while it was firing does nothing.
+// The timer is passed by reference and read under the lock, as it may fire
+// before Write has stored it.
+func (w *LogWriter) flushRepeats(timer **time.Timer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.flush != *timer {
+		return
+	}
+	w.writeRepeats()
+	w.last = ""
+}
+
+// Log a line of the monitor's, tagged with the correlation ID of its running
+// cycle so every line of one cycle can be found with a single search. Each
+// monitor tags only its own lines, so those of servers checked at once can
+// be told apart.
+func (m *Monitor) logf(format string, args ...interface{}) {
+	log.Print(m.logPrefix() + fmt.Sprintf(format, args...))
+}
+
+// Like logf, formatting its arguments as log.Println does
+func (m *Monitor) logln(args ...interface{}) {
+	log.Print(m.logPrefix() + fmt.Sprintln(args...))
+}
+
+// Tag the monitor's log lines with a cycle's correlation ID, returning a
+// function that stops tagging them
+func (m *Monitor) logCycle(id string) func() {
+	m.logMu.Lock()
+	m.logCycleID = id
+	m.logMu.Unlock()
+	return func() {
+		m.logMu.Lock()
+		m.logCycleID = ""
+		m.logMu.Unlock()
+	}
+}
+
+// "[cycle ID] " while a cycle runs, otherwise empty
+func (m *Monitor) logPrefix() string {
+	m.logMu.Lock()
+	defer m.logMu.Unlock()
+	if m.logCycleID == "" {
+		return ""
+	}
+	return "[" + m.logCycleID + "] "
+}
+
+// Short random correlation ID for a check cycle
+func newCycleID() string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%08x", uint32(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Go time layout for log timestamps
+func (c *Config) logTimestampLayout() string {
+	if c.LogTimestampFormat == "" {
+		return defaultLogTimestampFormat
+	}
+	if layout, ok := logTimestampFormats[strings.ToLower(c.LogTimestampFormat)]; ok {
+		return layout
+	}
+	return c.LogTimestampFormat
+}
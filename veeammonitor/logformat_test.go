@@ -0,0 +1,193 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Send the standard logger through a LogWriter into a buffer until the test ends
+func captureLogWriter(t *testing.T, config *Config) *bytes.Buffer {
+	var logged bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(NewLogWriter(&logged, config))
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return &logged
+}
+
+func TestCycleLogLinesShareCorrelationID(t *testing.T) {
+	config := testConfig()
+	config.LogTimestampFormat = "rfc3339"
+	output := jobCSVHeader + `"Nightly","Failed","2024-03-01T01:00:00","2024-03-01T01:20:00","Disk full","",""` + "\n"
+	m, capture := newCaptureMonitor(config, staticRunner(output, "", nil))
+	logged := captureLogWriter(t, config)
+
+	m.RunCheckCycle()
+	m.RunCheckCycle()
+	log.Println("Between cycles")
+
+	sent := capture.sent()
+	if len(sent) == 0 {
+		t.Fatal("no alert sent")
+	}
+	linePattern := regexp.MustCompile(`^(\S+) \[([0-9a-f]{8})\] `)
+	lines := strings.Split(strings.TrimSuffix(logged.String(), "\n"), "\n")
+	var ids []string
+	for _, line := range lines[:len(lines)-1] {
+		match := linePattern.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("line %q has no correlation ID", line)
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, match[1]); err != nil {
+			t.Errorf("line %q doesn't start with an RFC 3339 timestamp", line)
+		}
+		if len(ids) == 0 || ids[len(ids)-1] != match[2] {
+			ids = append(ids, match[2])
+		}
+	}
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Errorf("got correlation IDs %v, want one per cycle", ids)
+	} else if sent[0].CycleID != ids[0] {
+		t.Errorf("alert has cycle ID %q, its log lines %q", sent[0].CycleID, ids[0])
+	}
+	if last := lines[len(lines)-1]; strings.Contains(last, "[") || !strings.HasSuffix(last, " Between cycles") {
+		t.Errorf("got line %q logged outside a cycle, want no correlation ID", last)
+	}
+
+	_, body := buildEmailBody(sent[0], config)
+	if !strings.Contains(body, "Check cycle: "+sent[0].CycleID+"\n") {
+		t.Errorf("email footer has no cycle ID:\n%s", body)
+	}
+}
+
+func TestOverlappingCyclesTaggedPerMonitor(t *testing.T) {
+	config := testConfig()
+	config.LogTimestampFormat = "-"
+	logged := captureLogWriter(t, config)
+	first := newTestMonitor(testConfig(), nil)
+	second := newTestMonitor(testConfig(), nil)
+
+	endFirst := first.logCycle("aaaa0001")
+	endSecond := second.logCycle("bbbb0002")
+	first.logln("first")
+	second.logf("second %d\n", 2)
+	endFirst()
+	first.logln("first after")
+	second.logln("second again")
+	endSecond()
+
+	want := "- [aaaa0001] first\n- [bbbb0002] second 2\n- first after\n- [bbbb0002] second again\n"
+	if got := logged.String(); got != want {
+		t.Errorf("got lines\n%s\nwant each tagged with its own monitor's cycle\n%s", got, want)
+	}
+}
+
+func TestLogTimestampLayout(t *testing.T) {
+	tests := []struct {
+		format, want string
+	}{
+		{"", defaultLogTimestampFormat},
+		{"RFC3339", time.RFC3339},
+		{"iso8601", "2006-01-02T15:04:05.000Z07:00"},
+		{"15:04:05.000", "15:04:05.000"},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.LogTimestampFormat = test.format
+		if got := config.logTimestampLayout(); got != test.want {
+			t.Errorf("format %q: got layout %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+// LogWriter collapsing repeats within dedup, writing lines without a
+// timestamp into a buffer read with logged
+func newDedupLogWriter(dedup time.Duration) (w *LogWriter, logged func() string) {
+	var buffer bytes.Buffer
+	config := testConfig()
+	config.LogTimestampFormat = "-"
+	w = NewLogWriter(&buffer, config)
+	w.dedup = dedup
+	return w, func() string {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return buffer.String()
+	}
+}
+
+func TestLogDedup(t *testing.T) {
+	w, logged := newDedupLogWriter(time.Hour)
+	for _, message := range []string{
+		"Found 23 failed jobs\n",
+		"Found 23 failed jobs\n",
+		"Found 23 failed jobs\n",
+		"Found 23 failed jobs\n",
+		"Found 24 failed jobs\n",
+		"Found 23 failed jobs\n",
+		"Found 23 failed jobs\n",
+		"Sending alert\nto 2 channels\n",
+		"Sending alert\nto 2 channels\n",
+		"Check complete\n",
+	} {
+		if n, err := w.Write([]byte(message)); err != nil || n != len(message) {
+			t.Fatalf("wrote %d bytes with error %v", n, err)
+		}
+	}
+
+	want := "- Found 23 failed jobs\n" +
+		"- (last message repeated 3 times)\n" +
+		"- Found 24 failed jobs\n" +
+		"- Found 23 failed jobs\n" +
+		"- (last message repeated 1 times)\n" +
+		"- Sending alert\n- to 2 channels\n" +
+		"- (last message repeated 1 times)\n" +
+		"- Check complete\n"
+	if got := logged(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLogDedupFlushesAfterTimeout(t *testing.T) {
+	w, logged := newDedupLogWriter(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		w.Write([]byte("Veeam server unreachable\n"))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logged(), "repeated") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	want := "- Veeam server unreachable\n- (last message repeated 2 times)\n"
+	if got := logged(); got != want {
+		t.Fatalf("got:\n%s\nwant the count written without a new message:\n%s", got, want)
+	}
+
+	// After the count is flushed, the message is written in full again
+	w.Write([]byte("Veeam server unreachable\n"))
+	if got := logged(); got != want+"- Veeam server unreachable\n" {
+		t.Errorf("got:\n%s", got)
+	}
+}
+
+func TestLogDedupDisabled(t *testing.T) {
+	w, logged := newDedupLogWriter(0)
+	w.Write([]byte("Found 23 failed jobs\n"))
+	w.Write([]byte("Found 23 failed jobs\n"))
+	if got := logged(); got != "- Found 23 failed jobs\n- Found 23 failed jobs\n" {
+		t.Errorf("got:\n%s", got)
+	}
+
+	config, _ := loadTestConfig(t, []byte(`{"logDedupSeconds": -1}`))
+	if config.LogDedupSeconds != 0 {
+		t.Errorf("got logDedupSeconds %d, want a negative value to disable it", config.LogDedupSeconds)
+	}
+}
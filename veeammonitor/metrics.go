@@ -0,0 +1,242 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix of every Prometheus metric name
+const metricsPrefix = "veeam_monitor_"
+
+// Job statuses as they are labeled in metrics
+var metricStatuses = []struct{ status, label string }{
+	{"Failed", "failed"},
+	{"Warning", "warning"},
+	{stuckStatus, "stuck"},
+	{disabledStatus, "disabled"},
+	{missingStatus, "missing"},
+	{"Running", "long_running"},
+	{"Stale", "stale"},
+	{deviationStatus, "deviation"},
+	{driftStatus, "schedule_drift"},
+}
+
+// Figures a monitor keeps for /metrics: gauges from the last check and
+// counters since startup
+type monitorMetrics struct {
+	mu sync.Mutex
+
+	checks        int
+	lastCheck     time.Time
+	checkDuration time.Duration
+	reachable     bool
+	jobs          map[string]int // Problem jobs of the last check by status label
+	lowSpaceRepos int
+	queryErrors   map[string]int // By queryErrorKind
+
+	powerShellRuns    int
+	powerShellSeconds float64
+
+	notifications        map[string]int // Sent by channel
+	notificationFailures map[string]int // Failed by channel
+}
+
+// Record the outcome of a check cycle
+func (m *Monitor) recordCycleMetrics(jobs []JobStatus, lowSpaceRepos []RepositoryStatus, queryErrors []error, unreachable bool, started time.Time, duration time.Duration) {
+	metrics := &m.metrics
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.checks++
+	metrics.lastCheck, metrics.checkDuration = started, duration
+	metrics.reachable = !unreachable
+	metrics.jobs = map[string]int{}
+	for _, status := range metricStatuses {
+		metrics.jobs[status.label] = countJobsByStatus(jobs, status.status)
+	}
+	metrics.lowSpaceRepos = len(lowSpaceRepos)
+	if metrics.queryErrors == nil {
+		metrics.queryErrors = map[string]int{}
+	}
+	for _, err := range queryErrors {
+		metrics.queryErrors[queryErrorKind(err)]++
+	}
+}
+
+// Record how long a PowerShell query ran
+func (m *Monitor) recordPowerShellRun(duration time.Duration) {
+	m.metrics.mu.Lock()
+	m.metrics.powerShellRuns++
+	m.metrics.powerShellSeconds += duration.Seconds()
+	m.metrics.mu.Unlock()
+}
+
+// Record a notification sent, or failing to send, through a channel
+func (m *Monitor) recordNotification(channel string, err error) {
+	metrics := &m.metrics
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.notifications == nil {
+		metrics.notifications, metrics.notificationFailures = map[string]int{}, map[string]int{}
+	}
+	if err != nil {
+		metrics.notificationFailures[channel]++
+	} else {
+		metrics.notifications[channel]++
+	}
+}
+
+// A Prometheus metric and its samples
+type metricFamily struct {
+	name, help, kind string
+	samples          []metricSample
+}
+
+// One value of a metric
+type metricSample struct {
+	suffix string      // _sum or _count for the values of a summary
+	labels [][2]string // Name and value of each label but server
+	value  float64
+}
+
+// Metric with a single unlabeled sample
+func singleMetric(name, kind, help string, value float64) metricFamily {
+	return metricFamily{name: name, help: help, kind: kind, samples: []metricSample{{value: value}}}
+}
+
+// The monitor's metrics, each sample labeled with its server
+func (m *Monitor) metricFamilies() []metricFamily {
+	metrics := &m.metrics
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	m.healthMu.Lock()
+	lastSuccess := m.lastSuccess
+	m.healthMu.Unlock()
+
+	reachable := 0.0
+	if metrics.reachable {
+		reachable = 1
+	}
+
+	jobs := metricFamily{name: "jobs", help: "Problem jobs found by the last check, by status.", kind: "gauge"}
+	for _, status := range metricStatuses {
+		jobs.samples = append(jobs.samples, metricSample{labels: [][2]string{{"status", status.label}}, value: float64(metrics.jobs[status.label])})
+	}
+	queryErrors := metricFamily{name: "query_errors_total", help: "Failed Veeam queries since startup, by cause.", kind: "counter"}
+	for _, known := range queryErrorKinds {
+		queryErrors.samples = append(queryErrors.samples, metricSample{labels: [][2]string{{"kind", known.kind}}, value: float64(metrics.queryErrors[known.kind])})
+	}
+	queryErrors.samples = append(queryErrors.samples, metricSample{labels: [][2]string{{"kind", "other"}}, value: float64(metrics.queryErrors["other"])})
+	powerShell := metricFamily{name: "powershell_duration_seconds", help: "Time spent running PowerShell queries.", kind: "summary", samples: []metricSample{
+		{suffix: "_sum", value: metrics.powerShellSeconds},
+		{suffix: "_count", value: float64(metrics.powerShellRuns)},
+	}}
+	sent := metricFamily{name: "notifications_total", help: "Notifications sent since startup, by channel.", kind: "counter"}
+	failed := metricFamily{name: "notification_failures_total", help: "Notifications that failed to send since startup, by channel.", kind: "counter"}
+	for _, channel := range sortedKeys(metrics.notifications, metrics.notificationFailures) {
+		sent.samples = append(sent.samples, metricSample{labels: [][2]string{{"channel", channel}}, value: float64(metrics.notifications[channel])})
+		failed.samples = append(failed.samples, metricSample{labels: [][2]string{{"channel", channel}}, value: float64(metrics.notificationFailures[channel])})
+	}
+
+	families := []metricFamily{
+		singleMetric("checks_total", "counter", "Check cycles run since startup.", float64(metrics.checks)),
+		singleMetric("last_check_timestamp_seconds", "gauge", "Start of the last check cycle, in seconds since the Unix epoch.", unixSeconds(metrics.lastCheck)),
+		singleMetric("last_success_timestamp_seconds", "gauge", "End of the last check cycle that queried everything without errors, in seconds since the Unix epoch.", unixSeconds(lastSuccess)),
+		singleMetric("check_duration_seconds", "gauge", "How long the last check cycle took.", metrics.checkDuration.Seconds()),
+		singleMetric("server_reachable", "gauge", "Whether the last check reached the Veeam server.", reachable),
+		jobs,
+		singleMetric("repositories_low_space", "gauge", "Repositories below repositoryFreeSpaceThresholdPercent at the last check.", float64(metrics.lowSpaceRepos)),
+		queryErrors,
+		powerShell,
+		sent,
+		failed,
+	}
+
+	server := serverDisplayName(m.Config)
+	for i := range families {
+		for j := range families[i].samples {
+			families[i].samples[j].labels = append([][2]string{{"server", server}}, families[i].samples[j].labels...)
+		}
+	}
+	return families
+}
+
+// Seconds since the Unix epoch, 0 for the zero time
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// Keys of the maps, sorted
+func sortedKeys(maps ...map[string]int) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Write the metrics of monitors in the Prometheus text format, each metric
+// once with the samples of every monitor
+func writeMetrics(w io.Writer, monitors []*Monitor) error {
+	var families []metricFamily
+	for _, m := range monitors {
+		for i, family := range m.metricFamilies() {
+			if i == len(families) {
+				families = append(families, family)
+			} else {
+				families[i].samples = append(families[i].samples, family.samples...)
+			}
+		}
+	}
+
+	var out strings.Builder
+	for _, family := range families {
+		name := metricsPrefix + family.name
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+		for _, sample := range family.samples {
+			var labels []string
+			for _, label := range sample.labels {
+				labels = append(labels, label[0]+"=\""+metricLabelEscaper.Replace(label[1])+"\"")
+			}
+			fmt.Fprintf(&out, "%s%s{%s} %s\n", name, sample.suffix, strings.Join(labels, ","), strconv.FormatFloat(sample.value, 'g', -1, 64))
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// Escapes label values for the Prometheus text format
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Serve the metrics in the Prometheus text format
+func (m *Monitor) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	serveMetrics(w, r, []*Monitor{m})
+}
+
+// Serve the metrics of monitors to a GET
+func serveMetrics(w http.ResponseWriter, r *http.Request, monitors []*Monitor) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, monitors)
+}
@@ -0,0 +1,92 @@
+package veeammonitor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Body of GET /metrics from handler
+func getMetrics(t *testing.T, handler http.Handler) string {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("got status %d and content type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	body, _ := ioutil.ReadAll(recorder.Body)
+	return string(body)
+}
+
+func TestMetricsAfterCheck(t *testing.T) {
+	config := testConfig()
+	config.MonitorWarningJobs = true
+	m, _ := newCaptureMonitor(config, statusRunner([]string{"Nightly", "Weekly"}, []string{"Files"}))
+	m.RunCheckCycle()
+	m.recordNotification("teams", errors.New("connection refused"))
+
+	body := getMetrics(t, m.Handler())
+	for _, want := range []string{
+		"# HELP veeam_monitor_checks_total Check cycles run since startup.\n# TYPE veeam_monitor_checks_total counter\nveeam_monitor_checks_total{server=\"localhost\"} 1\n",
+		"veeam_monitor_server_reachable{server=\"localhost\"} 1\n",
+		"veeam_monitor_jobs{server=\"localhost\",status=\"failed\"} 2\n",
+		"veeam_monitor_jobs{server=\"localhost\",status=\"warning\"} 1\n",
+		"veeam_monitor_jobs{server=\"localhost\",status=\"stale\"} 0\n",
+		"veeam_monitor_query_errors_total{server=\"localhost\",kind=\"timeout\"} 0\n",
+		"veeam_monitor_notifications_total{server=\"localhost\",channel=\"capture\"} 1\n",
+		"veeam_monitor_notification_failures_total{server=\"localhost\",channel=\"teams\"} 1\n",
+		"# TYPE veeam_monitor_powershell_duration_seconds summary\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "last_check_timestamp_seconds{server=\"localhost\"} 0\n") {
+		t.Error("last check time not recorded")
+	}
+}
+
+func TestMetricsUnreachable(t *testing.T) {
+	m := newTestMonitor(testConfig(), staticRunner(connectErrorMarker+" No connection could be made\n", "", errors.New("exit status 1")))
+	m.RunCheckCycle()
+
+	body := getMetrics(t, m.Handler())
+	for _, want := range []string{
+		"veeam_monitor_server_reachable{server=\"localhost\"} 0\n",
+		"veeam_monitor_query_errors_total{server=\"localhost\",kind=\"unreachable\"} 1\n",
+		"veeam_monitor_last_success_timestamp_seconds{server=\"localhost\"} 0\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsOfEveryServer(t *testing.T) {
+	g := newTestMonitorGroup("vbr01", `vbr"02`)
+	g.Monitors[0].recordCycleMetrics([]JobStatus{{Name: "Nightly", Status: "Failed"}}, nil, nil, false, time.Unix(1700000000, 0), 1500*time.Millisecond)
+
+	body := getMetrics(t, g.Handler())
+	if strings.Count(body, "# TYPE veeam_monitor_checks_total counter") != 1 {
+		t.Errorf("metric described more than once:\n%s", body)
+	}
+	for _, want := range []string{
+		"veeam_monitor_checks_total{server=\"vbr01\"} 1\nveeam_monitor_checks_total{server=\"vbr\\\"02\"} 0\n",
+		"veeam_monitor_last_check_timestamp_seconds{server=\"vbr01\"} 1.7e+09\n",
+		"veeam_monitor_check_duration_seconds{server=\"vbr01\"} 1.5\n",
+		"veeam_monitor_jobs{server=\"vbr01\",status=\"failed\"} 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, body)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	g.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for POST /metrics", recorder.Code)
+	}
+}
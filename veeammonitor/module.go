@@ -0,0 +1,112 @@
+package veeammonitor
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// Veeam PowerShell module names in order of preference. Veeam 11 and later
+// ship the Veeam.Backup.PowerShell module, older versions the VeeamPSSnapIn
+// snap-in.
+var veeamModuleNames = []string{"Veeam.Backup.PowerShell", "VeeamPSSnapIn"}
+
+// Snap-in name, loaded with Add-PSSnapin instead of Import-Module
+const veeamSnapInName = "VeeamPSSnapIn"
+
+// Script listing the installed Veeam modules and snap-ins as Name,Version CSV
+const detectModulesScript = `
+	Get-Module -ListAvailable -Name Veeam.Backup.PowerShell | Select-Object Name,@{Name="Version";Expression={$_.Version.ToString()}} | ConvertTo-Csv -NoTypeInformation
+	Get-PSSnapin -Registered -Name VeeamPSSnapIn -ErrorAction SilentlyContinue | Select-Object Name,@{Name="Version";Expression={$_.Version.ToString()}} | ConvertTo-Csv -NoTypeInformation
+`
+
+// An installed Veeam PowerShell module or snap-in
+type veeamModule struct {
+	Name    string
+	Version string
+}
+
+// Parse the Name,Version CSV written by detectModulesScript. Each command
+// writes its own header row, which is skipped.
+func parseModuleOutput(output string) []veeamModule {
+	reader := csv.NewReader(strings.NewReader(cleanCSVOutput(output)))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil
+	}
+
+	var modules []veeamModule
+	seen := map[string]bool{}
+	for _, record := range records {
+		if len(record) < 2 || record[0] == "Name" || record[0] == "" {
+			continue
+		}
+		module := veeamModule{Name: strings.TrimSpace(record[0]), Version: strings.TrimSpace(record[1])}
+		if seen[module.Name+"/"+module.Version] {
+			continue
+		}
+		seen[module.Name+"/"+module.Version] = true
+		modules = append(modules, module)
+	}
+	return modules
+}
+
+// DetectPowerShellModule looks for installed Veeam PowerShell modules,
+// logging what it finds and warning when VeeamPowerShellModule doesn't match
+// any of them. When VeeamPowerShellModule is empty the detected module is used.
+func (m *Monitor) DetectPowerShellModule() error {
+	config := m.Config
+	stdout, stderr, err := m.runScript(detectModulesScript)
+	if err != nil {
+		return powerShellError(stderr, err)
+	}
+
+	modules := parseModuleOutput(stdout)
+	if len(modules) == 0 {
+		m.logln("Warning: No Veeam PowerShell module or snap-in found, install the Veeam Backup & Replication console")
+		return nil
+	}
+
+	var found []string
+	for _, module := range modules {
+		found = append(found, fmt.Sprintf("%s %s", module.Name, module.Version))
+	}
+	m.logf("Found Veeam PowerShell modules: %s\n", strings.Join(found, ", "))
+
+	if config.VeeamPowerShellModule == "" {
+		detected := preferredModule(modules)
+		m.logf("No veeamPowerShellModule configured, using detected module %s\n", detected)
+		config.VeeamPowerShellModule = detected
+		return nil
+	}
+
+	for _, module := range modules {
+		if strings.EqualFold(module.Name, config.VeeamPowerShellModule) {
+			return nil
+		}
+	}
+	m.logf("Warning: Configured veeamPowerShellModule %q is not installed, available: %s\n",
+		config.VeeamPowerShellModule, strings.Join(found, ", "))
+	return nil
+}
+
+// Most preferred of the detected modules
+func preferredModule(modules []veeamModule) string {
+	for _, name := range veeamModuleNames {
+		for _, module := range modules {
+			if strings.EqualFold(module.Name, name) {
+				return module.Name
+			}
+		}
+	}
+	return modules[0].Name
+}
+
+// PowerShell statement loading a Veeam module or snap-in
+func loadModuleStatement(name string) string {
+	if strings.EqualFold(name, veeamSnapInName) {
+		return "Add-PSSnapin " + name + " -ErrorAction Stop"
+	}
+	return "Import-Module " + name + " -ErrorAction Stop"
+}
@@ -0,0 +1,76 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Get-Module and Get-PSSnapin output of a server with both the module and the snap-in
+const bothModulesOutput = "\ufeff\"Name\",\"Version\"\r\n" +
+	"\"Veeam.Backup.PowerShell\",\"1.0\"\r\n" +
+	"\"Veeam.Backup.PowerShell\",\"1.0\"\r\n" +
+	"\"Name\",\"Version\"\r\n" +
+	"\"VeeamPSSnapIn\",\"11.0.1.1261\"\r\n"
+
+func TestParseModuleOutput(t *testing.T) {
+	want := []veeamModule{{"Veeam.Backup.PowerShell", "1.0"}, {"VeeamPSSnapIn", "11.0.1.1261"}}
+	if got := parseModuleOutput(bothModulesOutput); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := parseModuleOutput(""); len(got) != 0 {
+		t.Errorf("got %+v from no output, want none", got)
+	}
+}
+
+func TestDetectPowerShellModule(t *testing.T) {
+	snapInOnly := "\"Name\",\"Version\"\r\n\"VeeamPSSnapIn\",\"9.5.4.2866\"\r\n"
+	tests := []struct {
+		name       string
+		configured string
+		output     string
+		want       string
+		warning    bool
+	}{
+		{"empty uses the module", "", bothModulesOutput, "Veeam.Backup.PowerShell", false},
+		{"empty uses the snap-in on old versions", "", snapInOnly, "VeeamPSSnapIn", false},
+		{"configured module installed", "veeam.backup.powershell", bothModulesOutput, "veeam.backup.powershell", false},
+		{"configured module missing", "Veeam.Backup.PowerShell", snapInOnly, "Veeam.Backup.PowerShell", true},
+		{"nothing installed", "", "", "", true},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.VeeamPowerShellModule = test.configured
+		m := newTestMonitor(config, fakeRunner(func(script string) (string, string, error) {
+			if !strings.Contains(script, "Get-Module -ListAvailable -Name Veeam.Backup.PowerShell") {
+				t.Errorf("%s: ran %q, want the module detection script", test.name, script)
+			}
+			return test.output, "", nil
+		}))
+
+		var logged bytes.Buffer
+		log.SetOutput(&logged)
+		err := m.DetectPowerShellModule()
+		log.SetOutput(os.Stderr)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if config.VeeamPowerShellModule != test.want {
+			t.Errorf("%s: using module %q, want %q", test.name, config.VeeamPowerShellModule, test.want)
+		}
+		if warned := strings.Contains(logged.String(), "Warning:"); warned != test.warning {
+			t.Errorf("%s: warned %v, want %v:\n%s", test.name, warned, test.warning, logged.String())
+		}
+	}
+}
+
+func TestDetectPowerShellModuleFailure(t *testing.T) {
+	m := newTestMonitor(testConfig(), staticRunner("", "powershell.exe: access denied", errors.New("exit status 1")))
+	if err := m.DetectPowerShellModule(); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("got error %v, want the PowerShell failure", err)
+	}
+}
@@ -0,0 +1,727 @@
+// Package veeammonitor checks Veeam Backup & Replication job statuses and
+// sends alerts for failed, warning, long-running and stale jobs.
+package veeammonitor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// JobStatus represents a Veeam job status
+type JobStatus struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	StartTime   string `json:"startTime"`
+	EndTime     string `json:"endTime"`
+	Description string `json:"description"`
+	Duration    string `json:"duration"` // Minutes running for long-running jobs, hours since the last run for stale jobs
+	JobType     string `json:"jobType"`
+	NextRun     string `json:"nextRun,omitempty"` // Next scheduled run, "not scheduled" for manual or chained jobs, empty when unknown
+
+	ConsecutiveFailures int  `json:"consecutiveFailures,omitempty"` // Checks in a row the job has been found failed
+	Escalated           bool `json:"escalated,omitempty"`           // Failed for EscalateAfterFailures checks or more
+	Acknowledged        bool `json:"acknowledged,omitempty"`        // Silenced through the ack API
+	RetryPending        bool `json:"retryPending,omitempty"`        // Failed, but Veeam will automatically retry it
+	Disabled            bool `json:"disabled,omitempty"`            // Disabled in Veeam, only known for jobs listed with IsEnabled
+
+	Note         string `json:"note,omitempty"`         // Note attached through the notes API
+	FailingSince string `json:"failingSince,omitempty"` // First check of the current run of failed checks, with HistoryDatabase
+}
+
+// Monitor checks Veeam job statuses and sends alerts. Construct it with
+// NewMonitor and drive it with Run, or call RunCheckCycle for a single check.
+type Monitor struct {
+	Config *Config
+	Runner CommandRunner // Runs the PowerShell queries
+	Source JobSource     // Supplies job statuses, nil queries PowerShell through Runner
+
+	Notifiers []Notifier // Channels alerts are sent to besides the configured ones
+	History   JobHistory // Stores the results of every check, nil keeps none
+
+	state      monitorState
+	limiter    *rateLimiter // Shared by every channel, built from MaxNotificationsPerHour
+	httpClient *http.Client // Outbound notification, heartbeat, OAuth and AWS requests, through ProxyURL
+	lastPruned time.Time    // Not persisted, so state is pruned on startup
+
+	pathPrefix string // Path the HTTP API is served under, set by MonitorGroup
+
+	cycleMu       sync.Mutex // Serializes check cycles
+	cycleID       string     // Correlation ID of the running cycle, in its report and alerts
+	logMu         sync.Mutex
+	logCycleID    string     // Correlation ID its log lines are tagged with, empty between cycles
+	reportMu      sync.Mutex // Serializes report writes
+	queueOnce     sync.Once
+	checkRequests chan struct{} // Manual checks waiting to run
+	stopInit      sync.Once
+	stopCtx       context.Context // Cancelled by Stop, ending running PowerShell scripts and sends
+	stop          context.CancelFunc
+
+	statsD  statsDClient
+	metrics monitorMetrics // Served at /metrics
+
+	healthMu    sync.Mutex
+	lastSuccess time.Time // End of the last cycle that queried everything without errors
+
+	debounceUntil time.Time // End of the window an alert is held for, zero when none
+	warmedUp      bool      // A check has reached the server since startup, for SuppressInitialAlerts
+
+	// Jobs of every result keyed by jobIdentity, for IncrementalQueries
+	jobCache      map[string]JobStatus
+	jobCacheSince map[string]time.Time // Start of the next incremental query, by job type
+	jobCacheErr   error                // Why this cycle's refresh failed
+	lastFullQuery time.Time
+
+	fatalMu      sync.Mutex
+	fatalErr     error         // Fatal environment error hit since the last check ended
+	fatalBackoff time.Duration // Current wait between checks for FatalErrorBehavior backoff
+
+	sessionProgress map[string]sessionProgress // Progress of running sessions keyed by jobIdentity, for StuckSessionMinutes
+
+	circuitMu sync.Mutex
+	circuits  map[string]*channelCircuit // Circuit breakers of channels that have been sent to, by name
+
+	pauseMu     sync.Mutex
+	pausedUntil time.Time // End of a pause set through /pause, zero when there is none
+
+	ackMu     sync.Mutex // Guards state.Acks and state.Notes, which the HTTP API changes mid-cycle
+	statusMu  sync.Mutex
+	status    cycleStatus // Problems found by the last check
+	nextCheck time.Time   // Next scheduled check, zero when none is scheduled
+
+	historyOnce sync.Once
+	history     *cycleEventRing // Recent checks for /status and the dashboard
+}
+
+// NewMonitor creates a Monitor that queries Veeam through the REST API when
+// Backend is rest, or else through PowerShell, locally or over WinRM, or the
+// Enterprise Manager API depending on Transport. Alert history is restored
+// from StateFile when one exists, and outbound notifications go through
+// ProxyURL when one is set and are capped by MaxNotificationsPerHour.
+func NewMonitor(config *Config) *Monitor {
+	m := &Monitor{
+		Config: config,
+		Runner: newCommandRunner(config),
+	}
+	switch {
+	case config.Backend == "rest":
+		m.Source = NewVeeamAPISource(config)
+	case config.Transport == "enterprisemanager":
+		m.Source = NewEnterpriseManagerSource(config)
+	}
+	m.httpClient = newHTTPClient(config)
+	m.limiter = newRateLimiter(config.MaxNotificationsPerHour, time.Duration(config.NotificationWindowMinutes)*time.Minute)
+	m.History = openConfiguredHistory(config)
+	if config.StateFile != "" {
+		state, err := loadState(config.StateFile)
+		if err != nil {
+			log.Printf("Error loading state, starting with empty alert history: %v\n", err)
+		}
+		m.state = state
+	}
+	return m
+}
+
+// Run checks job statuses every CheckIntervalMinutes, or on CronSchedule, until
+// Stop is called. Checks requested with TriggerCheck run in between without
+// moving the schedule. It returns nil once stopped, or an ErrFatalEnvironment
+// error when FatalErrorBehavior is exit and PowerShell or the Veeam module
+// isn't installed.
+func (m *Monitor) Run() error {
+	// The background work ends when Run returns
+	ctx, cancel := context.WithCancel(m.runContext())
+	defer cancel()
+	if m.Config.HTTPListenAddress != "" {
+		go m.serveHTTP(ctx)
+	}
+
+	go m.watchdog(ctx, time.Now())
+	if m.Config.WeeklyReportDay != "" {
+		go m.runWeeklyReports(ctx)
+	}
+	if m.Config.DigestSchedule != "" {
+		go m.runDigests(ctx)
+	}
+
+	schedule := m.schedule()
+	next := schedule(time.Now())
+
+	// Check the module up front so a misconfiguration is obvious
+	if m.Source == nil {
+		if err := m.DetectPowerShellModule(); err != nil {
+			m.logf("Error detecting the Veeam PowerShell module: %v\n", err)
+			m.noteFatalError(err)
+		}
+	}
+
+	defer m.shutdown()
+	requests := m.checkRequestQueue()
+	stopped := m.runContext().Done()
+	m.RunCheckCycle()
+	var err error
+	for {
+		if next, err = m.afterFatalError(next, schedule); err != nil {
+			return err
+		}
+
+		// Skip checks missed while a cycle overran
+		for now := time.Now(); !next.IsZero() && !next.After(now); {
+			next = schedule(next)
+		}
+		m.setNextCheck(next)
+		if next.IsZero() {
+			m.logln("Cron schedule has no upcoming run, waiting for manual checks")
+			select {
+			case <-requests:
+			case <-stopped:
+				m.logln("Monitoring stopped")
+				return nil
+			}
+			m.logln("Running manually requested check")
+			m.RunCheckCycle()
+			continue
+		}
+
+		// Sleep until next check
+		m.logf("Sleeping until the next scheduled check at %s\n", formatNextCheck(next.In(m.Config.location())))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			next = schedule(next)
+		case <-requests:
+			timer.Stop()
+			m.logln("Running manually requested check")
+		case <-stopped:
+			timer.Stop()
+			m.logln("Monitoring stopped")
+			return nil
+		}
+		m.RunCheckCycle()
+	}
+}
+
+// Context of everything the monitor runs, cancelled by Stop. Created on
+// first use.
+func (m *Monitor) runContext() context.Context {
+	m.stopInit.Do(func() {
+		m.stopCtx, m.stop = context.WithCancel(context.Background())
+	})
+	return m.stopCtx
+}
+
+// Stop makes Run return, cutting a check in progress short: its PowerShell
+// scripts are killed, notifications being sent are abandoned and its results
+// aren't alerted on. Stop doesn't wait for that; Run returning does.
+func (m *Monitor) Stop() {
+	m.runContext()
+	m.stop()
+}
+
+// Save the state and close the job history database when Run returns
+func (m *Monitor) shutdown() {
+	m.cycleMu.Lock()
+	defer m.cycleMu.Unlock()
+	m.saveState()
+	if m.History == nil {
+		return
+	}
+	if err := m.History.Close(); err != nil {
+		m.logf("Error closing history database: %v\n", err)
+	}
+}
+
+// Function returning the check time following a given one, from
+// CronSchedule when set and CheckIntervalMinutes otherwise
+func (m *Monitor) schedule() func(time.Time) time.Time {
+	if m.Config.CronSchedule != "" {
+		cron, err := parseCronSchedule(m.Config.CronSchedule)
+		if err == nil {
+			loc := m.Config.location()
+			m.logf("Checking on cron schedule %q in time zone %s\n", m.Config.CronSchedule, loc)
+			return func(t time.Time) time.Time { return cron.Next(t.In(loc)) }
+		}
+		m.logf("Error parsing cronSchedule, checking every %d minutes instead: %v\n", m.Config.CheckIntervalMinutes, err)
+	}
+
+	interval := time.Duration(m.Config.CheckIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	return func(t time.Time) time.Time { return t.Add(interval) }
+}
+
+// Time of the next check, with the date when it isn't today
+func formatNextCheck(next time.Time) string {
+	y, m, d := next.Date()
+	if ty, tm, td := time.Now().In(next.Location()).Date(); y == ty && m == tm && d == td {
+		return next.Format("15:04:05")
+	}
+	return next.Format("Mon Jan 2 15:04:05")
+}
+
+// Wait until next, returning false instead if ctx is cancelled first
+func sleepUntil(ctx context.Context, next time.Time) bool {
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Queue of manual check requests, created on first use
+func (m *Monitor) checkRequestQueue() chan struct{} {
+	m.queueOnce.Do(func() {
+		m.checkRequests = make(chan struct{}, 1)
+	})
+	return m.checkRequests
+}
+
+// TriggerCheck asks Run to check job statuses now instead of waiting for the
+// next scheduled check. It returns false when a manual check is already
+// pending; a check requested while another is running starts after it.
+func (m *Monitor) TriggerCheck() bool {
+	select {
+	case m.checkRequestQueue() <- struct{}{}:
+		return true
+	default:
+		m.logln("A manual check is already pending, ignoring the new request")
+		return false
+	}
+}
+
+// RunCheckCycle runs a single monitoring cycle: query job statuses and send
+// alerts. Concurrent calls run one after another.
+func (m *Monitor) RunCheckCycle() {
+	m.cycleMu.Lock()
+	defer m.cycleMu.Unlock()
+	config := m.Config
+
+	m.cycleID = newCycleID()
+	defer m.logCycle(m.cycleID)()
+
+	if config.serverName != "" {
+		m.logf("Checking Veeam backup job statuses on %s...\n", config.serverName)
+	} else {
+		m.logln("Checking Veeam backup job statuses...")
+	}
+	if pause := m.pauseState(time.Now()); pause.Paused {
+		m.logf("Notifications are paused %s, this check won't send any\n", pause.reason(config))
+	}
+	source := m.source()
+	if starter, ok := source.(CycleStarter); ok {
+		starter.StartCycle()
+	}
+
+	// Monitor different job types based on configuration
+	var problematicJobs []JobStatus
+	var lowSpaceRepos []RepositoryStatus
+	unreachable := false
+	var unreachableErr error
+	queryFailed := false            // Some job query failed, so absent jobs can't be assumed healthy
+	var queryErrors []error         // Errors of the failed queries, classified for metrics
+	var ignoredWarnings []JobStatus // Not alerted on, but still counted in metrics
+
+	started := time.Now()
+	defer func() {
+		counted := append(append([]JobStatus{}, problematicJobs...), ignoredWarnings...)
+		m.sendStatsD(counted, lowSpaceRepos, queryErrors, unreachable, time.Since(started))
+		m.recordCycleMetrics(counted, lowSpaceRepos, queryErrors, unreachable, started, time.Since(started))
+	}()
+
+	if config.MonitorFailedJobs {
+		failedJobs, err := source.JobsByStatus("Failed")
+		if err != nil {
+			m.logf("Error checking failed jobs: %v\n", err)
+			queryFailed = true
+			queryErrors = append(queryErrors, err)
+			if errors.Is(err, ErrVeeamUnreachable) {
+				unreachable, unreachableErr = true, err
+			}
+		} else {
+			m.logf("Found %d failed jobs\n", len(failedJobs))
+			problematicJobs = append(problematicJobs, failedJobs...)
+		}
+	}
+
+	if config.MonitorWarningJobs && !unreachable {
+		warningJobs, err := source.JobsByStatus("Warning")
+		if err != nil {
+			m.logf("Error checking warning jobs: %v\n", err)
+			queryFailed = true
+			queryErrors = append(queryErrors, err)
+			if errors.Is(err, ErrVeeamUnreachable) {
+				unreachable, unreachableErr = true, err
+			}
+		} else {
+			m.logf("Found %d warning jobs\n", len(warningJobs))
+			warningJobs, ignoredWarnings = ignoreWarnings(warningJobs, config.WarningIgnorePatterns)
+			if len(ignoredWarnings) > 0 {
+				m.logf("Ignoring %d warning jobs matching warningIgnorePatterns\n", len(ignoredWarnings))
+			}
+			problematicJobs = append(problematicJobs, warningJobs...)
+		}
+	}
+
+	if config.MonitorRunningJobs && !unreachable {
+		longRunningJobs, err := source.LongRunningJobs()
+		if err != nil {
+			m.logf("Error checking long-running jobs: %v\n", err)
+			queryFailed = true
+			queryErrors = append(queryErrors, err)
+			if errors.Is(err, ErrVeeamUnreachable) {
+				unreachable, unreachableErr = true, err
+			}
+		} else {
+			m.logf("Found %d long-running jobs\n", len(longRunningJobs))
+			problematicJobs = append(problematicJobs, longRunningJobs...)
+		}
+	}
+
+	if config.StuckSessionMinutes > 0 && !unreachable {
+		if finder, ok := source.(StuckSessionFinder); ok {
+			stuckJobs, err := finder.StuckSessions()
+			if err != nil {
+				m.logf("Error checking for stuck sessions: %v\n", err)
+				queryFailed = true
+				queryErrors = append(queryErrors, err)
+				if errors.Is(err, ErrVeeamUnreachable) {
+					unreachable, unreachableErr = true, err
+				}
+			} else {
+				m.logf("Found %d stuck sessions with no progress for %d minutes\n", len(stuckJobs), config.StuckSessionMinutes)
+				problematicJobs = append(withoutStuckRunning(problematicJobs, stuckJobs), stuckJobs...)
+			}
+		}
+	}
+
+	if config.MaxJobAgeHours > 0 && !unreachable {
+		staleJobs, err := source.StaleJobs()
+		if err != nil {
+			m.logf("Error checking stale jobs: %v\n", err)
+			queryFailed = true
+			queryErrors = append(queryErrors, err)
+			if errors.Is(err, ErrVeeamUnreachable) {
+				unreachable, unreachableErr = true, err
+			}
+		} else {
+			m.logf("Found %d jobs that haven't run in %d hours\n", len(staleJobs), config.MaxJobAgeHours)
+			problematicJobs = append(problematicJobs, staleJobs...)
+		}
+	}
+
+	if config.ScheduleDriftMinutes > 0 && !unreachable {
+		if reporter, ok := source.(ScheduleReporter); ok {
+			schedules, err := reporter.JobSchedules()
+			if err != nil {
+				m.logf("Error checking job schedules: %v\n", err)
+				queryFailed = true
+				queryErrors = append(queryErrors, err)
+				if errors.Is(err, ErrVeeamUnreachable) {
+					unreachable, unreachableErr = true, err
+				}
+			} else {
+				drifted := scheduleDriftJobs(schedules, config)
+				m.logf("Found %d jobs whose last run started more than %d minutes from their schedule\n", len(drifted), config.ScheduleDriftMinutes)
+				problematicJobs = append(problematicJobs, drifted...)
+			}
+		}
+	}
+
+	// Every job's last result, for the inventory checks and the history
+	var allJobs []JobStatus
+	inventory := len(config.CriticalJobs) > 0 || len(config.ExpectedJobs) > 0
+	if (inventory || m.History != nil) && !unreachable {
+		if lister, ok := source.(JobLister); ok {
+			var err error
+			allJobs, err = lister.AllJobs()
+			if err != nil && !inventory {
+				m.logf("Error listing jobs, only problems are recorded in the history: %v\n", err)
+			} else if err != nil {
+				m.logf("Error checking the job inventory: %v\n", err)
+				queryFailed = true
+				queryErrors = append(queryErrors, err)
+				if errors.Is(err, ErrVeeamUnreachable) {
+					unreachable, unreachableErr = true, err
+				}
+			} else {
+				if len(config.CriticalJobs) > 0 {
+					disabled := disabledCriticalJobs(allJobs, config)
+					m.logf("Found %d disabled critical jobs\n", len(disabled))
+					problematicJobs = append(problematicJobs, disabled...)
+				}
+				if len(config.ExpectedJobs) > 0 {
+					missing := missingExpectedJobs(allJobs, config)
+					m.logf("Found %d missing expected jobs\n", len(missing))
+					problematicJobs = append(problematicJobs, missing...)
+				}
+			}
+		}
+	}
+
+	if config.MonitorBackupSize && !unreachable {
+		if reporter, ok := source.(BackupSizeReporter); ok {
+			sizes, err := reporter.BackupSizes()
+			if err != nil {
+				m.logf("Error checking backup sizes: %v\n", err)
+				queryFailed = true
+				queryErrors = append(queryErrors, err)
+				if errors.Is(err, ErrVeeamUnreachable) {
+					unreachable, unreachableErr = true, err
+				}
+			} else {
+				deviations := m.backupSizeDeviations(sizes)
+				m.logf("Found %d jobs whose backup size deviates more than %d%% from their baseline\n", len(deviations), config.BackupSizeDeviationPercent)
+				problematicJobs = append(problematicJobs, deviations...)
+			}
+		}
+	}
+
+	if config.MonitorRepositories && !unreachable {
+		repos, err := source.Repositories()
+		if err != nil {
+			m.logf("Error checking repositories: %v\n", err)
+			queryErrors = append(queryErrors, err)
+			if errors.Is(err, ErrVeeamUnreachable) {
+				unreachable, unreachableErr = true, err
+			}
+		} else {
+			lowSpaceRepos = lowSpaceRepositories(repos, config.RepositoryFreeSpaceThresholdPercent)
+			m.logf("Found %d repositories below %d%% free space\n", len(lowSpaceRepos), config.RepositoryFreeSpaceThresholdPercent)
+		}
+	}
+
+	// Queries cut short by Stop fail, which says nothing about the jobs
+	if m.runContext().Err() != nil {
+		m.logln("Monitoring stopped during the check, not alerting on its results")
+		return
+	}
+
+	// An unreachable server means we have no idea about job health, so
+	// never report it as "no problematic jobs"
+	defer m.saveState()
+	if unreachable {
+		m.setStatus(cycleStatus{Checked: time.Now(), Unreachable: true})
+		m.handleServerUnreachable(unreachableErr)
+		return
+	}
+	m.handleServerReachable()
+
+	// Acknowledged jobs and jobs still inside their cooldown are left out of
+	// this cycle's alert
+	now := time.Now()
+	problematicJobs = m.recordFailures(problematicJobs, now)
+	problematicJobs = m.recordHistory(problematicJobs, allJobs, !queryFailed, now)
+	problematicJobs = m.applyAcks(problematicJobs, !queryFailed, now)
+	problematicJobs = m.applyNotes(problematicJobs)
+	m.setStatus(cycleStatus{Checked: now, Jobs: problematicJobs, Repositories: lowSpaceRepos})
+	m.writeReports(m.newAlertReport(problematicJobs, lowSpaceRepos, alertSeverity(config, problematicJobs, lowSpaceRepos), now))
+	alertJobs, acknowledged := withoutAcknowledged(problematicJobs)
+	if acknowledged > 0 {
+		m.logf("%d problematic jobs are acknowledged, not alerting on them\n", acknowledged)
+	}
+	if config.SuppressRetryPendingAlerts {
+		var retrying int
+		alertJobs, retrying = withoutRetryPending(alertJobs)
+		if retrying > 0 {
+			m.logf("%d failed jobs will be retried by Veeam, not alerting on them yet\n", retrying)
+		}
+	}
+	alertJobs, cooling := m.jobsDueForAlert(alertJobs, now)
+	if cooling > 0 {
+		m.logf("%d problematic jobs are within their alert cooldown, not alerting on them again yet\n", cooling)
+	}
+
+	// Send notifications if there are problematic jobs or repositories
+	// and the alert thresholds are met
+	if len(alertJobs) > 0 || len(lowSpaceRepos) > 0 {
+		severity := alertSeverity(config, alertJobs, lowSpaceRepos)
+		if severity == severityNone {
+			m.logf("Problems found but below alert thresholds (%d failed, %d warning), not sending alerts\n",
+				countJobsByStatus(alertJobs, "Failed"), countJobsByStatus(alertJobs, "Warning"))
+			m.debounceUntil = time.Time{}
+		} else if config.SuppressInitialAlerts && !m.warmedUp {
+			m.recordInitialProblems(alertJobs, lowSpaceRepos, now)
+		} else if m.debouncing(now) {
+			m.logf("Alert held until %s\n", formatNextCheck(m.debounceUntil.In(config.location())))
+		} else if m.sendAlerts(m.newAlertReport(alertJobs, lowSpaceRepos, severity, now)) {
+			m.recordAlerted(alertJobs, now)
+		}
+	} else {
+		m.debounceUntil = time.Time{}
+		if len(problematicJobs) == 0 {
+			m.logln("No problematic jobs found")
+		}
+	}
+
+	if !queryFailed {
+		m.handleRecovered(m.recordRecovered(problematicJobs, now))
+		m.recordCycleSuccess(time.Now())
+	}
+	m.pruneState(source, now)
+
+	if config.PagerDutyRoutingKey != "" {
+		m.notifyPagerDuty(problematicJobs, !queryFailed)
+	}
+	if config.OpsgenieAPIKey != "" {
+		m.notifyOpsgenie(problematicJobs, !queryFailed)
+	}
+	m.sendSuppressedSummary()
+	m.warmedUp = true
+}
+
+// Build a report of the running cycle, tagged with its correlation ID
+func (m *Monitor) newAlertReport(jobs []JobStatus, repos []RepositoryStatus, severity string, now time.Time) *AlertReport {
+	report := NewAlertReport(jobs, repos, severity, m.Config, now)
+	report.CycleID = m.cycleID
+	return report
+}
+
+// Drop warning jobs whose description matches one of the ignore patterns,
+// returning the remaining jobs and the dropped ones. Patterns are
+// case-insensitive regular expressions; invalid ones are reported when the
+// config is loaded and skipped here.
+func ignoreWarnings(jobs []JobStatus, patterns []string) (kept, ignored []JobStatus) {
+	if len(patterns) == 0 {
+		return jobs, nil
+	}
+
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile("(?i)" + pattern); err == nil {
+			compiled = append(compiled, re)
+		}
+	}
+
+	for _, job := range jobs {
+		matched := false
+		for _, re := range compiled {
+			if job.Status == "Warning" && re.MatchString(job.Description) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			ignored = append(ignored, job)
+			continue
+		}
+		kept = append(kept, job)
+	}
+	return kept, ignored
+}
+
+// Overall alert severities
+const (
+	severityNone     = "none" // Below all alert thresholds
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// Order of severities from least to most severe
+var severityRank = map[string]int{
+	severityNone:     0,
+	severityInfo:     1,
+	severityWarning:  2,
+	severityCritical: 3,
+}
+
+// Count jobs with the given status
+func countJobsByStatus(jobs []JobStatus, status string) int {
+	count := 0
+	for _, job := range jobs {
+		if job.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Work out the overall alert severity: the worst severity, per
+// StatusSeverityMap, of the statuses that meet their alert threshold. Returns
+// severityNone when nothing does.
+func alertSeverity(config *Config, jobs []JobStatus, lowSpaceRepos []RepositoryStatus) string {
+	worst := severityNone
+	raise := func(severity string) {
+		if severityRank[severity] > severityRank[worst] {
+			worst = severity
+		}
+	}
+	for _, job := range jobsMeetingThresholds(config, jobs) {
+		raise(config.jobSeverity(job))
+	}
+	if len(lowSpaceRepos) > 0 {
+		raise(config.statusSeverity(repositoryStatus))
+	}
+	return worst
+}
+
+// The jobs whose status has reached its alert threshold among jobs.
+// Escalated jobs alert whatever the thresholds.
+func jobsMeetingThresholds(config *Config, jobs []JobStatus) []JobStatus {
+	counts := map[string]int{}
+	for _, job := range jobs {
+		counts[job.Status]++
+	}
+
+	var meeting []JobStatus
+	for _, job := range jobs {
+		if job.Escalated || counts[job.Status] >= config.alertMinimum(job.Status) {
+			meeting = append(meeting, job)
+		}
+	}
+	return meeting
+}
+
+// Raise the unreachable alert once per outage
+func (m *Monitor) handleServerUnreachable(cause error) {
+	config, state := m.Config, &m.state
+	if state.ServerUnreachable {
+		m.logln("Veeam server is still unreachable, alert already sent")
+		return
+	}
+
+	m.logln("Veeam server is UNREACHABLE, job statuses could not be checked")
+	if !m.sendNotice(unreachableNotice(config, cause)) {
+		// Leave the state unset so the alert is retried next cycle
+		return
+	}
+	m.logln("Unreachable alert sent successfully")
+	state.ServerUnreachable = true
+}
+
+// Log the alerted jobs that are back to normal and, with NotifyRecoveries,
+// send a resolution notice for them. Their alert state is already cleared,
+// so a failed notice isn't retried.
+func (m *Monitor) handleRecovered(jobs []jobAlertState) {
+	config := m.Config
+	for _, job := range jobs {
+		m.logf("%s (%s) is back to normal after being alerted as %s\n", job.Name, job.JobType, job.Status)
+	}
+	if len(jobs) == 0 || !config.NotifyRecoveries {
+		return
+	}
+	if m.sendNotice(recoveryNotice(config, jobs)) {
+		m.logf("Recovery notification sent for %d jobs\n", len(jobs))
+	}
+}
+
+// Resolve a previously raised unreachable alert once connectivity returns
+func (m *Monitor) handleServerReachable() {
+	config, state := m.Config, &m.state
+	if !state.ServerUnreachable {
+		return
+	}
+
+	m.logln("Veeam server is reachable again")
+	if !m.sendNotice(reachableNotice(config)) {
+		return
+	}
+	state.ServerUnreachable = false
+}
@@ -0,0 +1,595 @@
+package veeammonitor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Defaults with nothing written to disk
+func testConfig() *Config {
+	config := DefaultConfig()
+	config.StateFile = ""
+	return config
+}
+
+// Monitor querying through runner, without NewMonitor's state file, proxy
+// and history setup
+func newTestMonitor(config *Config, runner CommandRunner) *Monitor {
+	return &Monitor{
+		Config:     config,
+		Runner:     runner,
+		httpClient: http.DefaultClient,
+		limiter:    newRateLimiter(config.MaxNotificationsPerHour, time.Duration(config.NotificationWindowMinutes)*time.Minute),
+	}
+}
+
+// Records the reports sent to it
+type captureNotifier struct {
+	name string
+	err  error
+
+	mu      sync.Mutex
+	reports []*AlertReport
+}
+
+func (n *captureNotifier) Name() string { return n.name }
+
+func (n *captureNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.reports = append(n.reports, report)
+	return n.err
+}
+
+func (n *captureNotifier) sent() []*AlertReport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*AlertReport{}, n.reports...)
+}
+
+// Monitor whose alerts go to a captureNotifier
+func newCaptureMonitor(config *Config, runner CommandRunner) (*Monitor, *captureNotifier) {
+	capture := &captureNotifier{name: "capture"}
+	m := newTestMonitor(config, runner)
+	m.Notifiers = []Notifier{capture}
+	return m, capture
+}
+
+// Runner returning the named failed and warning jobs from the status queries
+func statusRunner(failed, warning []string) fakeRunner {
+	return func(script string) (string, string, error) {
+		output := jobCSVHeader
+		for status, names := range map[string][]string{"Failed": failed, "Warning": warning} {
+			if !strings.Contains(script, `$_.LastResult -eq "`+status+`"`) {
+				continue
+			}
+			for _, name := range names {
+				output += `"` + name + `","` + status + `","2024-03-01T01:00:00","2024-03-01T01:20:00","","",""` + "\n"
+			}
+		}
+		return output, "", nil
+	}
+}
+
+func TestUnreachableAlertSentOncePerOutage(t *testing.T) {
+	// Nothing listens on the SMTP port, so every send fails
+	config := smtpTestConfig(closedAddress(t), "ops@example.com")
+
+	m := newTestMonitor(config, nil)
+	m.handleServerUnreachable(ErrVeeamUnreachable)
+	if m.state.ServerUnreachable {
+		t.Error("outage marked as alerted although the alert wasn't sent")
+	}
+
+	m.state.ServerUnreachable = true
+	m.handleServerUnreachable(ErrVeeamUnreachable)
+	if !m.state.ServerUnreachable {
+		t.Error("ongoing outage cleared")
+	}
+
+	// The outage stays open until the resolution notice goes out
+	m.handleServerReachable()
+	if !m.state.ServerUnreachable {
+		t.Error("outage cleared although the resolution notice wasn't sent")
+	}
+}
+
+func TestCheckCycleUnreachable(t *testing.T) {
+	m, capture := newCaptureMonitor(testConfig(), staticRunner(connectErrorMarker+" No connection could be made\n", "", errors.New("exit status 1")))
+	m.RunCheckCycle()
+
+	if !m.status.Unreachable {
+		t.Error("cycle not reported unreachable")
+	}
+	if len(m.status.Jobs) != 0 {
+		t.Errorf("unreachable cycle reported %d jobs", len(m.status.Jobs))
+	}
+	if sent := capture.sent(); len(sent) != 0 {
+		t.Errorf("unreachable cycle sent %d job alerts, want none", len(sent))
+	}
+}
+
+func TestCheckCycleEmptyResult(t *testing.T) {
+	m, capture := newCaptureMonitor(testConfig(), staticRunner(jobCSVHeader, "", nil))
+	m.state.ServerUnreachable = true
+	m.RunCheckCycle()
+
+	if m.status.Unreachable {
+		t.Error("empty result reported unreachable")
+	}
+	if m.status.Checked.IsZero() || len(m.status.Jobs) != 0 {
+		t.Errorf("got status %+v, want a check with no jobs", m.status)
+	}
+	if sent := capture.sent(); len(sent) != 0 {
+		t.Errorf("empty result sent %d alerts, want none", len(sent))
+	}
+}
+
+func TestAlertSeverityThresholds(t *testing.T) {
+	jobs := func(failed, warning int) []JobStatus {
+		var jobs []JobStatus
+		for i := 0; i < failed; i++ {
+			jobs = append(jobs, JobStatus{Name: "Failed job", Status: "Failed"})
+		}
+		for i := 0; i < warning; i++ {
+			jobs = append(jobs, JobStatus{Name: "Warning job", Status: "Warning"})
+		}
+		return jobs
+	}
+	tests := []struct {
+		minFailed, minWarning int
+		failed, warning       int
+		want                  string
+	}{
+		{1, 1, 0, 0, severityNone},
+		{1, 1, 1, 0, severityCritical},
+		{2, 1, 1, 0, severityNone},
+		{2, 1, 2, 0, severityCritical},
+		{1, 3, 0, 2, severityNone},
+		{1, 3, 0, 3, severityWarning},
+		{2, 3, 1, 2, severityNone},
+		{2, 3, 1, 3, severityWarning},
+		{2, 3, 2, 1, severityCritical},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.AlertMinFailedJobs, config.AlertMinWarningJobs = test.minFailed, test.minWarning
+		if got := alertSeverity(config, jobs(test.failed, test.warning), nil); got != test.want {
+			t.Errorf("minimums %d failed and %d warning, %d failed and %d warning jobs: got %s, want %s",
+				test.minFailed, test.minWarning, test.failed, test.warning, got, test.want)
+		}
+	}
+
+	// Running jobs and low repositories aren't subject to the thresholds
+	config := testConfig()
+	config.AlertMinWarningJobs = 5
+	if got := alertSeverity(config, []JobStatus{{Name: "Long", Status: "Running"}}, nil); got != severityWarning {
+		t.Errorf("long-running job: got %s, want %s", got, severityWarning)
+	}
+	if got := alertSeverity(config, nil, []RepositoryStatus{{Name: "Main"}}); got != severityWarning {
+		t.Errorf("low repository: got %s, want %s", got, severityWarning)
+	}
+}
+
+func TestCheckCycleAlertsFailedJobs(t *testing.T) {
+	output := jobCSVHeader + `"Nightly","Failed","2024-03-01T01:00:00","2024-03-01T01:20:00","Disk full","",""` + "\n"
+	m, capture := newCaptureMonitor(testConfig(), staticRunner(output, "", nil))
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d alerts, want 1", len(sent))
+	}
+	if jobs := sent[0].Jobs(); len(jobs) != 1 || jobs[0].Name != "Nightly" || jobs[0].Status != "Failed" {
+		t.Errorf("alerted on %+v, want the failed Nightly job", jobs)
+	}
+}
+
+func TestCheckCycleAlertThresholds(t *testing.T) {
+	tests := []struct {
+		minFailed, minWarning int
+		failed, warning       []string
+		want                  bool
+	}{
+		{1, 1, nil, nil, false},
+		{1, 1, []string{"A"}, nil, true},
+		{2, 1, []string{"A"}, nil, false},
+		{2, 1, []string{"A", "B"}, nil, true},
+		{1, 3, nil, []string{"A", "B"}, false},
+		{1, 3, nil, []string{"A", "B", "C"}, true},
+		{2, 3, []string{"A"}, []string{"B", "C"}, false},
+		{2, 3, []string{"A"}, []string{"B", "C", "D"}, true},
+		{2, 3, []string{"A", "B"}, []string{"C"}, true},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.MonitorWarningJobs = true
+		config.AlertMinFailedJobs, config.AlertMinWarningJobs = test.minFailed, test.minWarning
+		m, capture := newCaptureMonitor(config, statusRunner(test.failed, test.warning))
+		m.RunCheckCycle()
+
+		if sent := len(capture.sent()) > 0; sent != test.want {
+			t.Errorf("minimums %d failed and %d warning, %d failed and %d warning jobs: sent %v, want %v",
+				test.minFailed, test.minWarning, len(test.failed), len(test.warning), sent, test.want)
+		}
+	}
+}
+
+func TestIgnoreWarnings(t *testing.T) {
+	jobs := []JobStatus{
+		{Name: "A", Status: "Warning", Description: "VSS snapshot already exists, retried OK"},
+		{Name: "B", Status: "Warning", Description: "Low disk space on proxy"},
+		{Name: "C", Status: "Failed", Description: "VSS snapshot already exists"},
+		{Name: "D", Status: "Warning", Description: "Changed block tracking was RESET"},
+	}
+	kept, ignored := ignoreWarnings(jobs, []string{`vss snapshot already exists`, `changed block tracking.*reset`, `[invalid`})
+
+	var keptNames, ignoredNames []string
+	for _, job := range kept {
+		keptNames = append(keptNames, job.Name)
+	}
+	for _, job := range ignored {
+		ignoredNames = append(ignoredNames, job.Name)
+	}
+	if strings.Join(keptNames, ",") != "B,C" || strings.Join(ignoredNames, ",") != "A,D" {
+		t.Errorf("kept %v and ignored %v, want B,C kept and A,D ignored", keptNames, ignoredNames)
+	}
+	if kept, ignored := ignoreWarnings(jobs, nil); len(kept) != len(jobs) || len(ignored) != 0 {
+		t.Errorf("without patterns kept %d and ignored %d jobs", len(kept), len(ignored))
+	}
+}
+
+func TestCheckCycleIgnoredWarningsStillCounted(t *testing.T) {
+	runner := fakeRunner(func(script string) (string, string, error) {
+		if !strings.Contains(script, `$_.LastResult -eq "Warning"`) {
+			return jobCSVHeader, "", nil
+		}
+		return jobCSVHeader +
+			`"Benign","Warning","2024-03-01T01:00:00","2024-03-01T01:20:00","VSS snapshot already exists, retried OK","",""` + "\n" +
+			`"Real","Warning","2024-03-01T01:00:00","2024-03-01T01:20:00","Low disk space on proxy","",""` + "\n", "", nil
+	})
+	config := testConfig()
+	config.MonitorWarningJobs = true
+	config.WarningIgnorePatterns = []string{"VSS snapshot already exists"}
+	m, capture := newCaptureMonitor(config, runner)
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d alerts, want 1", len(sent))
+	}
+	if jobs := sent[0].Jobs(); len(jobs) != 1 || jobs[0].Name != "Real" {
+		t.Errorf("alerted on %+v, want only the warning not matching a pattern", jobs)
+	}
+	m.metrics.mu.Lock()
+	warnings := m.metrics.jobs["warning"]
+	m.metrics.mu.Unlock()
+	if warnings != 2 {
+		t.Errorf("metrics count %d warning jobs, want the ignored one counted too", warnings)
+	}
+}
+
+// Wait for the next cycle a cycleRunner reports
+func waitForCycle(t *testing.T, cycles chan struct{}) {
+	select {
+	case <-cycles:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no check cycle ran")
+	}
+}
+
+// Runner reporting each cycle's failed jobs query on cycles, then waiting for release
+func cycleRunner(cycles, release chan struct{}) fakeRunner {
+	return func(script string) (string, string, error) {
+		if strings.Contains(script, `$_.LastResult -eq "Failed"`) {
+			cycles <- struct{}{}
+			<-release
+		}
+		return jobCSVHeader, "", nil
+	}
+}
+
+func TestCheckRequestRunsCycle(t *testing.T) {
+	cycles, release := make(chan struct{}), make(chan struct{})
+	close(release)
+	config := testConfig()
+	config.CheckIntervalMinutes = 60
+	m := newTestMonitor(config, cycleRunner(cycles, release))
+	done := make(chan error)
+	go func() { done <- m.Run() }()
+	waitForCycle(t, cycles)
+
+	// The scheduled check is an hour away, so the next cycle is the requested one
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/check", nil))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202: %s", recorder.Code, recorder.Body)
+	}
+	waitForCycle(t, cycles)
+
+	m.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+}
+
+func TestCheckRequestsDuringCycle(t *testing.T) {
+	cycles, release := make(chan struct{}), make(chan struct{})
+	config := testConfig()
+	config.CheckIntervalMinutes = 60
+	m := newTestMonitor(config, cycleRunner(cycles, release))
+	done := make(chan error)
+	go func() { done <- m.Run() }()
+	waitForCycle(t, cycles)
+
+	// Requests while a cycle runs wait for it, and only one is kept
+	if !m.TriggerCheck() {
+		t.Error("first request wasn't queued")
+	}
+	if m.TriggerCheck() {
+		t.Error("second request was queued while the first is pending")
+	}
+	release <- struct{}{}
+	waitForCycle(t, cycles)
+	release <- struct{}{}
+	select {
+	case <-cycles:
+		t.Error("ran a cycle for the dropped request")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	m.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+}
+
+func TestStatusSeverityMapChangesSeverity(t *testing.T) {
+	warning := JobStatus{Name: "Files", JobType: "Backup", Status: "Warning"}
+	running := JobStatus{Name: "Offsite", JobType: "Backup", Status: "Running"}
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+	tests := []struct {
+		severities map[string]string
+		jobs       []JobStatus
+		want       string
+	}{
+		{nil, []JobStatus{warning}, severityWarning},
+		{nil, []JobStatus{running, failed}, severityCritical},
+		{map[string]string{"Warning": severityCritical}, []JobStatus{warning}, severityCritical},
+		{map[string]string{"warning": severityCritical}, []JobStatus{warning}, severityCritical},
+		{map[string]string{"Running": severityInfo}, []JobStatus{running}, severityInfo},
+		{map[string]string{"Running": severityInfo}, []JobStatus{running, warning}, severityWarning},
+		{map[string]string{"Failed": severityWarning}, []JobStatus{failed}, severityWarning},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.StatusSeverityMap = test.severities
+		if got := alertSeverity(config, test.jobs, nil); got != test.want {
+			t.Errorf("%v with %v: got %s, want %s", jobNames(test.jobs), test.severities, got, test.want)
+		}
+	}
+}
+
+func TestStatusSeverityMapChangesRouting(t *testing.T) {
+	for _, test := range []struct {
+		warningSeverity string
+		wantChannel     string
+	}{
+		{"", "chat"},
+		{severityCritical, "pager"},
+	} {
+		config := testConfig()
+		config.MonitorWarningJobs = true
+		config.NotificationRouting = map[string][]string{severityCritical: {"pager"}, severityWarning: {"chat"}}
+		if test.warningSeverity != "" {
+			config.StatusSeverityMap = map[string]string{"Warning": test.warningSeverity}
+		}
+		m := newTestMonitor(config, statusRunner(nil, []string{"Files"}))
+		pager, chat := &captureNotifier{name: "pager"}, &captureNotifier{name: "chat"}
+		m.Notifiers = []Notifier{pager, chat}
+		m.RunCheckCycle()
+
+		got := map[string][]*AlertReport{"pager": pager.sent(), "chat": chat.sent()}
+		for channel, reports := range got {
+			if channel != test.wantChannel {
+				if len(reports) != 0 {
+					t.Errorf("warning as %q: %s got %d alerts, want none", test.warningSeverity, channel, len(reports))
+				}
+				continue
+			}
+			if len(reports) != 1 {
+				t.Errorf("warning as %q: %s got %d alerts, want 1", test.warningSeverity, channel, len(reports))
+				continue
+			}
+			if want := config.statusSeverity("Warning"); reports[0].Severity != want {
+				t.Errorf("warning as %q: %s alert has severity %s, want %s", test.warningSeverity, channel, reports[0].Severity, want)
+			}
+		}
+	}
+}
+
+func TestCheckCycleSuppressesRetryPending(t *testing.T) {
+	output := jobCSVHeader +
+		`"Nightly","Failed","2024-03-01T01:00:00","2024-03-01T01:20:00","Disk full","","True"` + "\n" +
+		`"Weekly","Failed","2024-03-01T02:00:00","2024-03-01T02:20:00","Disk full","","False"` + "\n"
+	for _, suppress := range []bool{false, true} {
+		config := testConfig()
+		config.SuppressRetryPendingAlerts = suppress
+		m, capture := newCaptureMonitor(config, staticRunner(output, "", nil))
+		m.RunCheckCycle()
+
+		sent := capture.sent()
+		if len(sent) != 1 {
+			t.Fatalf("suppress %v: sent %d alerts, want 1", suppress, len(sent))
+		}
+		var got []string
+		for _, job := range sent[0].Jobs() {
+			got = append(got, job.Name)
+		}
+		want := []string{"Nightly", "Weekly"}
+		if suppress {
+			want = []string{"Weekly"}
+		}
+		if !equalStrings(got, want) {
+			t.Errorf("suppress %v: alerted on %v, want %v", suppress, got, want)
+		}
+	}
+}
+
+func TestSuppressInitialAlerts(t *testing.T) {
+	config := testConfig()
+	config.SuppressInitialAlerts = true
+	config.CooldownMinutes = 360
+	logged := captureLogWriter(t, config)
+	failed := []string{"Nightly"}
+	m, capture := newCaptureMonitor(config, failedJobsRunner(&failed))
+
+	m.RunCheckCycle()
+	if sent := capture.sent(); len(sent) != 0 {
+		t.Fatalf("sent %d reports on the first check", len(sent))
+	}
+	if !strings.Contains(logged.String(), "Would have alerted: Nightly (Backup) is Failed") {
+		t.Errorf("the suppressed alert wasn't logged:\n%s", logged)
+	}
+
+	// Later checks alert on changes only
+	m.RunCheckCycle()
+	if sent := capture.sent(); len(sent) != 0 {
+		t.Errorf("sent %d reports for the problem already known at startup", len(sent))
+	}
+	failed = []string{"Nightly", "Weekly"}
+	m.RunCheckCycle()
+	sent := capture.sent()
+	if len(sent) != 1 || !equalStrings(jobNames(sent[0].Jobs()), []string{"Weekly"}) {
+		t.Errorf("sent %+v, want an alert for Weekly only", sent)
+	}
+}
+
+func TestSuppressInitialAlertsWaitsForReachableCheck(t *testing.T) {
+	config := testConfig()
+	config.SuppressInitialAlerts = true
+	unreachable := true
+	m, capture := newCaptureMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		if unreachable {
+			return connectErrorMarker + " No connection could be made\n", "", errors.New("exit status 1")
+		}
+		return statusRunner([]string{"Nightly"}, nil)(script)
+	}))
+
+	m.RunCheckCycle()
+	unreachable = false
+	m.RunCheckCycle()
+	for _, report := range capture.sent() {
+		if len(report.Failed) > 0 {
+			t.Errorf("alerted on %v in the first check that reached the server", jobNames(report.Failed))
+		}
+	}
+}
+
+func TestInitialAlertsSentByDefault(t *testing.T) {
+	m, capture := newCaptureMonitor(testConfig(), statusRunner([]string{"Nightly"}, nil))
+	m.RunCheckCycle()
+	if sent := capture.sent(); len(sent) != 1 {
+		t.Errorf("sent %d reports on the first check, want 1 with suppressInitialAlerts off", len(sent))
+	}
+}
+
+func TestRecoveryNotice(t *testing.T) {
+	for _, notify := range []bool{false, true} {
+		smtp := newFakeSMTP(t)
+		config := smtpTestConfig(smtp.addr, "ops@example.com")
+		config.NotifyRecoveries = notify
+		failed := []string{"Nightly", "Weekly"}
+		m := newTestMonitor(config, failedJobsRunner(&failed))
+
+		m.RunCheckCycle()
+		failed = []string{"Weekly"}
+		m.RunCheckCycle()
+		if entry := m.state.Jobs[jobIdentity(JobStatus{Name: "Nightly", JobType: "Backup"})]; entry == nil || entry.Status != "" {
+			t.Errorf("notify %v: got state %+v, want Nightly recovered", notify, entry)
+		}
+
+		delivered := smtp.delivered()
+		if !notify {
+			if len(delivered) != 1 {
+				t.Errorf("sent %d emails without notifyRecoveries, want the alert only", len(delivered))
+			}
+			continue
+		}
+		if len(delivered) != 2 {
+			t.Fatalf("sent %d emails, want the alert and the notice", len(delivered))
+		}
+		notice := delivered[1].data
+		for _, want := range []string{"Subject: RESOLVED: Veeam job Nightly back to normal (localhost)", "Job: Nightly\nType: Backup\nPrevious Status: Failed"} {
+			if !strings.Contains(notice, want) {
+				t.Errorf("notice doesn't contain %q:\n%s", want, notice)
+			}
+		}
+		if strings.Contains(notice, "Weekly") {
+			t.Error("notice lists the job that is still failing")
+		}
+	}
+}
+
+// Runner blocking the failed jobs query until the monitor is stopped
+type stoppableRunner struct {
+	started chan struct{}
+}
+
+func (r stoppableRunner) Run(script string) (string, string, error) {
+	return r.RunContext(context.Background(), script)
+}
+
+func (r stoppableRunner) RunContext(ctx context.Context, script string) (string, string, error) {
+	if strings.Contains(script, `$_.LastResult -eq "Failed"`) {
+		r.started <- struct{}{}
+		<-ctx.Done()
+		return "", "", ctx.Err()
+	}
+	return jobCSVHeader, "", nil
+}
+
+func TestStopCutsCheckShort(t *testing.T) {
+	config := testConfig()
+	config.StateFile = filepath.Join(t.TempDir(), "state.json")
+	runner := stoppableRunner{started: make(chan struct{})}
+	m, capture := newCaptureMonitor(config, runner)
+	done := make(chan error)
+	go func() { done <- m.Run() }()
+	waitForCycle(t, runner.started)
+
+	m.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after Stop")
+	}
+	if sent := capture.sent(); len(sent) != 0 || m.status.Unreachable {
+		t.Errorf("sent %d alerts and unreachable %v, want the cut short check ignored", len(sent), m.status.Unreachable)
+	}
+	if _, err := os.Stat(config.StateFile); err != nil {
+		t.Errorf("state not saved: %v", err)
+	}
+}
+
+func TestSleepUntil(t *testing.T) {
+	if !sleepUntil(context.Background(), time.Now().Add(10*time.Millisecond)) {
+		t.Error("sleep ended early")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sleepUntil(ctx, time.Now().Add(time.Hour)) {
+		t.Error("sleep wasn't cut short by the context")
+	}
+}
@@ -0,0 +1,132 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Free-text note attached to a job, e.g. a vendor ticket or known flakiness,
+// shown with the job in every alert until it is removed
+type jobNote struct {
+	Name    string    `json:"name"`
+	Note    string    `json:"note"`
+	Updated time.Time `json:"updated"`
+}
+
+// A line showing a job's note, formatted with format, for jobs that have one
+func noteLine(job JobStatus, format string) string {
+	if job.Note == "" {
+		return ""
+	}
+	return fmt.Sprintf(format, job.Note)
+}
+
+// Set the Note of jobs from the notes kept in state. Notes are keyed by job
+// name, so one applies to every job type using the name.
+func (m *Monitor) applyNotes(jobs []JobStatus) []JobStatus {
+	m.ackMu.Lock()
+	defer m.ackMu.Unlock()
+	for i, job := range jobs {
+		jobs[i].Note = ""
+		if note := m.state.Notes[strings.ToLower(job.Name)]; note != nil {
+			jobs[i].Note = note.Note
+		}
+	}
+	return jobs
+}
+
+// Attach a note to a job, or remove its note when text is empty, also
+// updating the jobs /status reports. The note takes the job's own spelling
+// of the name when the last check found it problematic.
+func (m *Monitor) setNote(name, text string, now time.Time) *jobNote {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	m.ackMu.Lock()
+	defer m.ackMu.Unlock()
+
+	for i, job := range m.status.Jobs {
+		if strings.EqualFold(job.Name, name) {
+			name = job.Name
+			m.status.Jobs[i].Note = text
+		}
+	}
+
+	key := strings.ToLower(name)
+	var note *jobNote
+	if text == "" {
+		delete(m.state.Notes, key)
+	} else {
+		note = &jobNote{Name: name, Note: text, Updated: now}
+		if m.state.Notes == nil {
+			m.state.Notes = map[string]*jobNote{}
+		}
+		m.state.Notes[key] = note
+	}
+
+	return note
+}
+
+// Read, set or remove a job's note: GET, PUT or POST with note as a form
+// value or a JSON object, and DELETE on /jobs/{name}/note
+func (m *Monitor) handleJobNote(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		m.ackMu.Lock()
+		note := m.state.Notes[strings.ToLower(name)]
+		m.ackMu.Unlock()
+		if note == nil {
+			http.Error(w, fmt.Sprintf("job %q has no note", name), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(note)
+		return
+	case http.MethodPut, http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Note string `json:"note"`
+	}
+	if r.Method != http.MethodDelete {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+				return
+			}
+		} else {
+			request.Note = r.FormValue("note")
+		}
+		request.Note = strings.TrimSpace(request.Note)
+		if request.Note == "" {
+			http.Error(w, "note is required, use DELETE to remove a note", http.StatusBadRequest)
+			return
+		}
+	}
+
+	note := m.setNote(name, request.Note, time.Now())
+	if note == nil {
+		m.logf("Note on job %s removed over HTTP from %s\n", name, r.RemoteAddr)
+	} else {
+		m.logf("Note on job %s set over HTTP from %s\n", name, r.RemoteAddr)
+	}
+
+	// Save now unless a running check will save it when it finishes
+	if m.cycleMu.TryLock() {
+		m.saveState()
+		m.cycleMu.Unlock()
+	}
+
+	if note == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(note)
+}
@@ -0,0 +1,111 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Send a note request to the monitor's HTTP handler
+func serveNoteRequest(m *Monitor, method, path, contentType, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, request)
+	return recorder
+}
+
+// Notes of the jobs /status reports, keyed by job name
+func statusNotes(t *testing.T, m *Monitor) map[string]string {
+	recorder := serveNoteRequest(m, http.MethodGet, "/status", "", "")
+	var status cycleStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	notes := map[string]string{}
+	for _, job := range status.Jobs {
+		notes[job.Name] = job.Note
+	}
+	return notes
+}
+
+func TestNoteAttachesToJob(t *testing.T) {
+	config := testConfig()
+	config.StateFile = filepath.Join(t.TempDir(), "state.json")
+	m, capture := newCaptureMonitor(config, statusRunner([]string{"Nightly", "Weekly"}, nil))
+
+	form := url.Values{"note": {"Vendor ticket 1234"}}.Encode()
+	if recorder := serveNoteRequest(m, http.MethodPost, "/jobs/nightly/note", "application/x-www-form-urlencoded", form); recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d reports, want 1", len(sent))
+	}
+	for _, job := range sent[0].Failed {
+		if want := map[string]string{"Nightly": "Vendor ticket 1234"}[job.Name]; job.Note != want {
+			t.Errorf("%s: got note %q, want %q", job.Name, job.Note, want)
+		}
+	}
+	_, body := buildEmailBody(sent[0], config)
+	if strings.Count(body, "Note: Vendor ticket 1234") != 1 || !strings.Contains(body, "Job: Nightly") {
+		t.Errorf("note not rendered once with its job in:\n%s", body)
+	}
+	if notes := statusNotes(t, m); notes["Nightly"] != "Vendor ticket 1234" || notes["Weekly"] != "" {
+		t.Errorf("got /status notes %v", notes)
+	}
+
+	// The note survives a restart
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := newTestMonitor(config, statusRunner([]string{"Nightly"}, nil))
+	restarted.state = state
+	restarted.RunCheckCycle()
+	if notes := statusNotes(t, restarted); notes["Nightly"] != "Vendor ticket 1234" {
+		t.Errorf("after a restart got /status notes %v, want the note kept", notes)
+	}
+}
+
+func TestJobNoteHandler(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	m.setStatus(cycleStatus{Jobs: []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}})
+
+	if recorder := serveNoteRequest(m, http.MethodGet, "/jobs/Nightly/note", "", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("GET without a note: got status %d, want 404", recorder.Code)
+	}
+	if recorder := serveNoteRequest(m, http.MethodPut, "/jobs/Nightly/note", "application/json", `{"note": "  "}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("blank note: got status %d, want 400", recorder.Code)
+	}
+	if recorder := serveNoteRequest(m, http.MethodPatch, "/jobs/Nightly/note", "", ""); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PATCH: got status %d, want 405", recorder.Code)
+	}
+
+	// Set on the name's other spelling, the note takes the job's own
+	serveNoteRequest(m, http.MethodPut, "/jobs/NIGHTLY/note", "application/json", `{"note": "Known flaky, see ticket 42"}`)
+	recorder := serveNoteRequest(m, http.MethodGet, "/jobs/nightly/note", "", "")
+	var note jobNote
+	json.NewDecoder(recorder.Body).Decode(&note)
+	if recorder.Code != http.StatusOK || note.Name != "Nightly" || note.Note != "Known flaky, see ticket 42" || note.Updated.IsZero() {
+		t.Errorf("got status %d and note %+v", recorder.Code, note)
+	}
+	if notes := statusNotes(t, m); notes["Nightly"] != "Known flaky, see ticket 42" {
+		t.Errorf("got /status notes %v", notes)
+	}
+
+	if recorder := serveNoteRequest(m, http.MethodDelete, "/jobs/Nightly/note", "", ""); recorder.Code != http.StatusNoContent {
+		t.Errorf("DELETE: got status %d, want 204", recorder.Code)
+	}
+	if notes := statusNotes(t, m); notes["Nightly"] != "" || len(m.state.Notes) != 0 {
+		t.Errorf("got /status notes %v and state %v after removing the note", notes, m.state.Notes)
+	}
+}
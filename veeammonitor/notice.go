@@ -0,0 +1,237 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notice is a message about the monitor rather than an alert report, such as
+// the Veeam server becoming unreachable or alerted jobs recovering
+type Notice struct {
+	Event     string // unreachable, reachable, recovery or suppressed
+	Severity  string // NotificationRouting sends the notice where alerts of this severity go
+	Server    string
+	Timestamp time.Time
+	Subject   string
+	Body      string // Plain text
+
+	reserved bool // Already counted against the rate limit, as the rate limit summary is
+}
+
+// NoticeNotifier is a Notifier that can also deliver notices. Notifiers
+// without it, like the per-client emails and OnAlertCommand, only receive
+// alert reports.
+type NoticeNotifier interface {
+	Notifier
+	NotifyNotice(ctx context.Context, notice *Notice) error
+}
+
+func newNotice(config *Config, event, severity, subject, body string) *Notice {
+	return &Notice{
+		Event:     event,
+		Severity:  severity,
+		Server:    serverDisplayName(config),
+		Timestamp: time.Now().In(config.location()),
+		Subject:   subject,
+		Body:      body,
+	}
+}
+
+// Send a notice through every channel that takes notices, returning whether
+// any delivered it. Channels are picked, sent to and recorded like sendAlerts
+// does for reports, so notices share the pause, rate limit, circuit breakers
+// and per-channel metrics.
+func (m *Monitor) sendNotice(notice *Notice) bool {
+	var notifiers []NoticeNotifier
+	for _, notifier := range m.notifiers() {
+		noticeNotifier, ok := notifier.(NoticeNotifier)
+		name := notifier.Name()
+		if !ok || !m.Config.routes(notice.Severity, name) {
+			continue
+		}
+		if !m.allowChannel(name, time.Now()) || !notice.reserved && !m.allowNotification(name) {
+			continue
+		}
+		notifiers = append(notifiers, noticeNotifier)
+	}
+	if len(notifiers) == 0 {
+		m.logf("No channel takes the %s notice\n", notice.Event)
+		return false
+	}
+
+	errs := make([]error, len(notifiers))
+	var wg sync.WaitGroup
+	for i, notifier := range notifiers {
+		wg.Add(1)
+		go func(i int, notifier NoticeNotifier) {
+			defer wg.Done()
+			errs[i] = m.deliver(func(ctx context.Context) error { return notifier.NotifyNotice(ctx, notice) })
+		}(i, notifier)
+	}
+	wg.Wait()
+
+	sent := false
+	for i, notifier := range notifiers {
+		name, err := notifier.Name(), errs[i]
+		m.recordChannelResult(name, err, time.Now())
+		m.recordNotification(name, err)
+		if err != nil {
+			m.logf("Error sending %s %s notice: %v\n", name, notice.Event, err)
+			continue
+		}
+		m.logf("Sent %s %s notice\n", name, notice.Event)
+		sent = true
+	}
+	return sent
+}
+
+// Notice that the Veeam server or module can't be reached
+func unreachableNotice(config *Config, cause error) *Notice {
+	subject := fmt.Sprintf("ALERT: Veeam server UNREACHABLE (%s)", serverDisplayName(config))
+
+	body := "Veeam Backup & Replication Monitoring Alert\n"
+	body += "===========================================\n\n"
+	body += fmt.Sprintf("The Veeam server %s could not be contacted.\n", serverDisplayName(config))
+	body += "Job statuses are NOT being monitored until connectivity is restored.\n\n"
+	body += fmt.Sprintf("Error: %v\n", cause)
+	body += "\nThis is an automated message from the Veeam Backup Monitor.\n"
+
+	return newNotice(config, "unreachable", severityCritical, subject, body)
+}
+
+// Notice that a previously unreachable server is back. It goes wherever the
+// unreachable notice went.
+func reachableNotice(config *Config) *Notice {
+	subject := fmt.Sprintf("RESOLVED: Veeam server reachable again (%s)", serverDisplayName(config))
+
+	body := "Veeam Backup & Replication Monitoring Alert\n"
+	body += "===========================================\n\n"
+	body += fmt.Sprintf("The Veeam server %s is reachable again and job monitoring has resumed.\n", serverDisplayName(config))
+	body += "\nThis is an automated message from the Veeam Backup Monitor.\n"
+
+	return newNotice(config, "reachable", severityCritical, subject, body)
+}
+
+// Notice that jobs alerted earlier no longer have a problem. It goes where
+// the worst of their previous statuses was alerted; a job reported with
+// several statuses has them joined by problemsByJob.
+func recoveryNotice(config *Config, jobs []jobAlertState) *Notice {
+	subject := fmt.Sprintf("RESOLVED: %d Veeam jobs back to normal (%s)", len(jobs), serverDisplayName(config))
+	if len(jobs) == 1 {
+		subject = fmt.Sprintf("RESOLVED: Veeam job %s back to normal (%s)", jobs[0].Name, serverDisplayName(config))
+	}
+
+	body := "Veeam Backup & Replication Monitoring Alert\n"
+	body += "===========================================\n\n"
+	body += fmt.Sprintf("These jobs on %s were alerted and no longer have a problem:\n\n", serverDisplayName(config))
+	for _, job := range jobs {
+		body += fmt.Sprintf("Job: %s\nType: %s\nPrevious Status: %s\nLast Alerted: %s\n\n",
+			job.Name, job.JobType, job.Status, job.LastAlerted.In(config.location()).Format(displayTimeLayout))
+	}
+	body += "This is an automated message from the Veeam Backup Monitor.\n"
+
+	severity := severityInfo
+	for _, job := range jobs {
+		for _, status := range strings.Split(job.Status, ", ") {
+			if previous := config.statusSeverity(status); severityRank[previous] > severityRank[severity] {
+				severity = previous
+			}
+		}
+	}
+	return newNotice(config, "recovery", severity, subject, body)
+}
+
+// Summary of the notifications the rate limit dropped, with how many each
+// channel lost and when
+func suppressedNotice(config *Config, dropped []droppedNotification) *Notice {
+	subject := fmt.Sprintf("WARNING: %d Veeam notifications suppressed by the rate limit (%s)", len(dropped), serverDisplayName(config))
+
+	// Channels in the order they first lost a notification
+	var channels []string
+	byChannel := map[string][]time.Time{}
+	for _, d := range dropped {
+		if byChannel[d.channel] == nil {
+			channels = append(channels, d.channel)
+		}
+		byChannel[d.channel] = append(byChannel[d.channel], d.at)
+	}
+
+	body := "Veeam Backup & Replication Monitoring Alert\n"
+	body += "===========================================\n\n"
+	body += fmt.Sprintf("The notification rate limit of %d per %d minutes was reached on %s, and these notifications were not sent:\n\n",
+		config.MaxNotificationsPerHour, config.NotificationWindowMinutes, serverDisplayName(config))
+	for _, channel := range channels {
+		times := byChannel[channel]
+		first := times[0].In(config.location()).Format(displayTimeLayout)
+		if len(times) == 1 {
+			body += fmt.Sprintf("%s: 1 notification at %s\n", channel, first)
+			continue
+		}
+		last := times[len(times)-1].In(config.location()).Format(displayTimeLayout)
+		body += fmt.Sprintf("%s: %d notifications between %s and %s\n", channel, len(times), first, last)
+	}
+	body += "\nCheck the monitor's status page or logs for the current problems.\n"
+	body += "\nThis is an automated message from the Veeam Backup Monitor.\n"
+	notice := newNotice(config, "suppressed", severityWarning, subject, body)
+	notice.reserved = true
+	return notice
+}
+
+// Subject and body of a notice as one message, for chat channels
+func (n *Notice) text() string {
+	return n.Subject + "\n\n" + n.Body
+}
+
+func (n emailNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	return sendEmail(ctx, n.httpClient, n.config, notice.Subject, notice.Body)
+}
+
+func (n sesNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	return sendEmail(ctx, n.httpClient, n.config, notice.Subject, notice.Body)
+}
+
+func (n emailAudienceNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	config := *n.config
+	config.EmailTo = n.audience.To
+	return sendEmail(ctx, n.httpClient, &config, notice.Subject, notice.Body)
+}
+
+func (n discordNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	return postDiscordMessage(ctx, n.httpClient, n.config.DiscordWebhookURL, discordMessage{Content: truncateRunes(notice.text(), discordMaxContent)})
+}
+
+func (n slackNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	return postSlackMessage(ctx, n.httpClient, n.config.SlackWebhookURL, slackMessage{Channel: n.config.SlackChannel, Text: truncateRunes(notice.text(), slackMaxText)})
+}
+
+func (n teamsNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	return postTeamsMessage(ctx, n.httpClient, n.config.TeamsWebhookURL, newTeamsMessage([]teamsElement{teamsText(notice.Subject), teamsText(notice.Body)}))
+}
+
+func (n snsNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	return publishSNSMessage(ctx, n.httpClient, n.config, notice.Subject, notice.Body)
+}
+
+// Body of a notice posted to webhooks
+type webhookNoticePayload struct {
+	Event     string    `json:"event"`
+	Severity  string    `json:"severity"`
+	Server    string    `json:"server"`
+	Timestamp time.Time `json:"timestamp"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+}
+
+func (n webhookNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	return postWebhook(ctx, n.httpClient, n.config, n.webhook, webhookNoticePayload{
+		Event:     notice.Event,
+		Severity:  notice.Severity,
+		Server:    notice.Server,
+		Timestamp: notice.Timestamp,
+		Subject:   notice.Subject,
+		Message:   notice.Body,
+	})
+}
@@ -0,0 +1,147 @@
+package veeammonitor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Records the notices sent to it, besides the reports
+type captureNoticeNotifier struct {
+	captureNotifier
+	notices []*Notice
+}
+
+func (n *captureNoticeNotifier) NotifyNotice(ctx context.Context, notice *Notice) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notices = append(n.notices, notice)
+	return n.err
+}
+
+func (n *captureNoticeNotifier) sentNotices() []*Notice {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*Notice{}, n.notices...)
+}
+
+func TestUnreachableNoticeSentThroughEveryChannel(t *testing.T) {
+	var mu sync.Mutex
+	var slack []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		slack = append(slack, string(data))
+		mu.Unlock()
+	}))
+	defer server.Close()
+	smtp := newFakeSMTP(t)
+
+	config := smtpTestConfig(smtp.addr, "ops@example.com")
+	config.SlackWebhookURL = server.URL
+	config.CircuitBreakerFailures = 1
+	config.CircuitBreakerCooldownMinutes = 30
+	m, reportsOnly := newCaptureMonitor(config, nil)
+	failing := &captureNoticeNotifier{captureNotifier: captureNotifier{name: "pager", err: errors.New("status 500")}}
+	m.Notifiers = append(m.Notifiers, failing)
+
+	m.handleServerUnreachable(ErrVeeamUnreachable)
+	if !m.state.ServerUnreachable {
+		t.Fatal("outage not marked as alerted")
+	}
+	if len(smtp.delivered()) != 1 || len(slack) != 1 || !strings.Contains(slack[0], "Veeam server UNREACHABLE") {
+		t.Errorf("got %d emails and slack messages %q, want the notice on both", len(smtp.delivered()), slack)
+	}
+	if notices := failing.sentNotices(); len(notices) != 1 || notices[0].Event != "unreachable" || notices[0].Severity != severityCritical {
+		t.Errorf("got notices %+v, want the unreachable notice", notices)
+	}
+	if len(reportsOnly.sent()) != 0 {
+		t.Error("a channel without notices was sent a report")
+	}
+	if sent, failed := m.metrics.notifications, m.metrics.notificationFailures; sent["email"] != 1 || sent["slack"] != 1 || failed["pager"] != 1 {
+		t.Errorf("got %v sent and %v failed, want per-channel counts", sent, failed)
+	}
+
+	// The failing channel's circuit opened, so the resolution skips it
+	m.handleServerReachable()
+	if m.state.ServerUnreachable {
+		t.Error("outage still open after the resolution notice was sent")
+	}
+	if len(failing.sentNotices()) != 1 || len(slack) != 2 || !strings.Contains(slack[1], "reachable again") {
+		t.Errorf("got %d notices on the open circuit and slack messages %q", len(failing.sentNotices()), slack)
+	}
+}
+
+func TestNoticesFollowNotificationRouting(t *testing.T) {
+	config := testConfig()
+	config.NotificationRouting = map[string][]string{severityCritical: {"pager"}, severityWarning: {"chat"}}
+	m := newTestMonitor(config, nil)
+	pager := &captureNoticeNotifier{captureNotifier: captureNotifier{name: "pager"}}
+	chat := &captureNoticeNotifier{captureNotifier: captureNotifier{name: "chat"}}
+	m.Notifiers = []Notifier{pager, chat}
+
+	if !m.sendNotice(newNotice(config, "unreachable", severityCritical, "Unreachable", "")) {
+		t.Error("sendNotice reported nothing sent")
+	}
+	if len(pager.sentNotices()) != 1 || len(chat.sentNotices()) != 0 {
+		t.Errorf("pager got %d notices and chat %d, want only pager", len(pager.sentNotices()), len(chat.sentNotices()))
+	}
+
+	m.Notifiers = []Notifier{&captureNotifier{name: "pager"}}
+	if m.sendNotice(newNotice(config, "unreachable", severityCritical, "Unreachable", "")) {
+		t.Error("sendNotice reported a notice sent without a channel that takes them")
+	}
+}
+
+func TestRecoveryNoticeSeverity(t *testing.T) {
+	config := testConfig()
+	tests := []struct {
+		statuses []string
+		want     string
+	}{
+		{[]string{"Warning"}, severityWarning},
+		{[]string{"Warning", "Failed"}, severityCritical},
+		{[]string{"Warning, " + driftStatus}, severityWarning},
+		{[]string{driftStatus + ", Failed"}, severityCritical},
+		{[]string{"Unknown"}, severityInfo},
+	}
+	for _, test := range tests {
+		var jobs []jobAlertState
+		for _, status := range test.statuses {
+			jobs = append(jobs, jobAlertState{Name: "Nightly", JobType: "Backup", Status: status})
+		}
+		notice := recoveryNotice(config, jobs)
+		if notice.Event != "recovery" || notice.Severity != test.want {
+			t.Errorf("%v: got %s notice with severity %s, want a recovery with %s", test.statuses, notice.Event, notice.Severity, test.want)
+		}
+	}
+}
+
+func TestMultiStatusRecoveryKeepsWorstSeverity(t *testing.T) {
+	config := testConfig()
+	config.NotifyRecoveries = true
+	config.NotificationRouting = map[string][]string{severityCritical: {"pager"}, severityWarning: {"chat"}}
+	m := newTestMonitor(config, nil)
+	pager := &captureNoticeNotifier{captureNotifier: captureNotifier{name: "pager"}}
+	chat := &captureNoticeNotifier{captureNotifier: captureNotifier{name: "chat"}}
+	m.Notifiers = []Notifier{pager, chat}
+
+	// Alerted as both drifted and failed in one check
+	nightly := JobStatus{Name: "Nightly", JobType: "Backup"}
+	problems := problemsByJob([]JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: driftStatus},
+		{Name: "Nightly", JobType: "Backup", Status: "Failed"},
+	})
+	status := problems[jobIdentity(nightly)].Status
+	m.handleRecovered([]jobAlertState{{Name: "Nightly", JobType: "Backup", Status: status}})
+
+	notices := pager.sentNotices()
+	if len(notices) != 1 || notices[0].Severity != severityCritical || len(chat.sentNotices()) != 0 {
+		t.Errorf("recovery from %q: pager got %+v and chat %d notices, want a critical notice to pager only", status, notices, len(chat.sentNotices()))
+	}
+}
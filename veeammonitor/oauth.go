@@ -0,0 +1,144 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access token cached until shortly before it expires
+type oauthTokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+var smtpTokenCache = &oauthTokenCache{}
+
+// Scope of SMTP submission to Exchange Online with client credentials
+const microsoftSMTPScope = "https://outlook.office365.com/.default"
+
+// Token endpoint of a Microsoft Entra tenant, given by ID or domain
+func microsoftTokenURL(tenant string) string {
+	return "https://login.microsoftonline.com/" + url.PathEscape(strings.TrimSpace(tenant)) + "/oauth2/v2.0/token"
+}
+
+// Response from an OAuth2 token endpoint
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Get an access token using the client_credentials grant, reusing the cached
+// token while it is still valid
+func (c *oauthTokenCache) Get(httpClient *http.Client, config *Config) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", config.OAuthClientID)
+	form.Set("client_secret", config.OAuthClientSecret)
+	if config.OAuthScope != "" {
+		form.Set("scope", config.OAuthScope)
+	}
+
+	resp, err := httpClient.PostForm(config.OAuthTokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("error requesting OAuth2 token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var token oauthTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error parsing OAuth2 token response (status %s): %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("OAuth2 token request failed (status %s): %s %s", resp.Status, token.Error, token.Description)
+	}
+
+	// Refresh a minute early so a token never expires mid-send
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	if lifetime > 2*time.Minute {
+		lifetime -= time.Minute
+	}
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(lifetime)
+	return c.token, nil
+}
+
+// Forget the cached token, so the next Get requests a new one. Used when the
+// server rejects a token that hasn't expired yet, e.g. after it was revoked.
+func (c *oauthTokenCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
+
+// smtp.Auth implementation of the XOAUTH2 SASL mechanism
+type xoauth2Auth struct {
+	username string
+	token    string
+	host     string
+}
+
+// Build the XOAUTH2 initial client response
+func xoauth2String(username, token string) string {
+	return "user=" + username + "\x01auth=Bearer " + token + "\x01\x01"
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like PlainAuth, never send the bearer token over an unencrypted connection
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection, refusing to send OAuth2 token")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "XOAUTH2", []byte(xoauth2String(a.username, a.token)), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	// On failure the server sends a JSON error challenge which must be answered
+	// with an empty response before it returns the final error
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// Whether the SMTP host is the local machine
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// Choose SMTP authentication for a relay: XOAUTH2 when OAuth2 is configured,
+// PLAIN when a password is set, and none for unauthenticated relays
+func smtpAuth(httpClient *http.Client, config *Config, relay smtpRelay) (smtp.Auth, error) {
+	if relay.OAuth {
+		token, err := smtpTokenCache.Get(httpClient, config)
+		if err != nil {
+			return nil, err
+		}
+		return &xoauth2Auth{username: config.EmailFrom, token: token, host: relay.Server}, nil
+	}
+
+	if strings.TrimSpace(relay.Password) != "" {
+		return smtp.PlainAuth("", config.EmailFrom, relay.Password, relay.Server), nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,97 @@
+package veeammonitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Stand-in token endpoint counting the tokens it hands out
+func newTokenServer(t *testing.T) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	issued := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "client" ||
+			r.Form.Get("client_secret") != "secret" || r.Form.Get("scope") != microsoftSMTPScope {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"Bad client credentials"}`))
+			return
+		}
+		mu.Lock()
+		issued++
+		mu.Unlock()
+		w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return issued
+	}
+}
+
+func TestOAuthTokenCache(t *testing.T) {
+	server, issued := newTokenServer(t)
+	config := testConfig()
+	config.OAuthTokenURL = server.URL
+	config.OAuthClientID, config.OAuthClientSecret, config.OAuthScope = "client", "secret", microsoftSMTPScope
+
+	cache := &oauthTokenCache{}
+	for i := 0; i < 2; i++ {
+		token, err := cache.Get(http.DefaultClient, config)
+		if err != nil || token != "token" {
+			t.Fatalf("got token %q and error %v", token, err)
+		}
+	}
+	if got := issued(); got != 1 {
+		t.Errorf("requested %d tokens, want the first one reused", got)
+	}
+
+	cache.Invalidate()
+	if _, err := cache.Get(http.DefaultClient, config); err != nil {
+		t.Fatal(err)
+	}
+	if got := issued(); got != 2 {
+		t.Errorf("requested %d tokens, want a new one after invalidating", got)
+	}
+}
+
+func TestOAuthTokenRejected(t *testing.T) {
+	server, _ := newTokenServer(t)
+	config := testConfig()
+	config.OAuthTokenURL = server.URL
+	config.OAuthClientID, config.OAuthClientSecret = "client", "wrong"
+
+	_, err := (&oauthTokenCache{}).Get(http.DefaultClient, config)
+	if err == nil || !strings.Contains(err.Error(), "invalid_client Bad client credentials") {
+		t.Errorf("got error %v, want the endpoint's explanation", err)
+	}
+}
+
+func TestXOAUTH2Auth(t *testing.T) {
+	auth := &xoauth2Auth{username: "monitor@example.com", token: "token", host: "smtp.example.com"}
+
+	mechanism, response, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "user=monitor@example.com\x01auth=Bearer token\x01\x01"; mechanism != "XOAUTH2" || string(response) != want {
+		t.Errorf("got %s %q, want XOAUTH2 %q", mechanism, response, want)
+	}
+
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com"}); err == nil {
+		t.Error("token offered over an unencrypted connection")
+	}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "other.example.com", TLS: true}); err == nil {
+		t.Error("token offered to another host")
+	}
+
+	// The error challenge is answered with an empty response
+	if next, err := auth.Next([]byte(`{"status":"400"}`), true); err != nil || next == nil || len(next) != 0 {
+		t.Errorf("got %q and error %v answering the error challenge", next, err)
+	}
+}
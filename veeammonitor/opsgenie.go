@@ -0,0 +1,187 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Opsgenie API endpoints by region
+var opsgenieAPIURLs = map[string]string{
+	"us": "https://api.opsgenie.com",
+	"eu": "https://api.eu.opsgenie.com",
+}
+
+// Channel name of Opsgenie in NotificationRouting
+const opsgenieChannel = "opsgenie"
+
+// Opsgenie limits on alert fields, in characters
+const (
+	opsgenieMaxMessage     = 130
+	opsgenieMaxDescription = 15000
+	opsgenieMaxTag         = 50
+)
+
+// Alert created through the Opsgenie Alert API
+type opsgenieAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"` // Identifies the job's alert, for deduplication and closing
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+	Entity      string            `json:"entity,omitempty"`
+	Source      string            `json:"source"`
+	Priority    string            `json:"priority"` // P1 to P5
+}
+
+// Body of a request closing an alert
+type opsgenieClose struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+// Answer of the Alert API to a request it rejected
+type opsgenieError struct {
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// Priority of each severity unless OpsgeniePriorities says otherwise
+func defaultOpsgeniePriorities() map[string]string {
+	return map[string]string{
+		severityCritical: "P1",
+		severityWarning:  "P3",
+	}
+}
+
+// Opsgenie priority of a job, from its severity
+func (c *Config) opsgeniePriority(job JobStatus) string {
+	severity := c.jobSeverity(job)
+	if priority, ok := c.OpsgeniePriorities[severity]; ok {
+		return priority
+	}
+	return defaultOpsgeniePriorities()[severity]
+}
+
+// Tags of a job's alert, for filtering and routing in Opsgenie: the
+// server, job type, status and severity, and the job's group when
+// GroupMapping is set
+func opsgenieTags(config *Config, job JobStatus) []string {
+	tags := []string{"veeam", serverDisplayName(config), job.JobType, job.Status, config.jobSeverity(job)}
+	if len(config.GroupMapping) > 0 {
+		tags = append(tags, config.jobGroup(job.Name))
+	}
+	var kept []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			kept = append(kept, truncateRunes(tag, opsgenieMaxTag))
+		}
+	}
+	return kept
+}
+
+// Create alerts for newly problematic jobs and close the ones that have
+// recovered, see notifyIncidents
+func (m *Monitor) notifyOpsgenie(problematicJobs []JobStatus, complete bool) {
+	config, state := m.Config, &m.state
+	if state.OpsgenieAlerts == nil {
+		state.OpsgenieAlerts = map[string]bool{}
+	}
+
+	m.notifyIncidents(incidentChannel{
+		name:    "Opsgenie",
+		channel: opsgenieChannel,
+		open:    state.OpsgenieAlerts,
+		trigger: func(ctx context.Context, alias string, job JobStatus, fingerprint string) error {
+			alert := opsgenieAlert{
+				Message:     truncateRunes(fmt.Sprintf("Veeam job %s: %s", job.Name, displayStatus(job)), opsgenieMaxMessage),
+				Alias:       alias,
+				Description: truncateRunes(job.Description, opsgenieMaxDescription),
+				Tags:        opsgenieTags(config, job),
+				Details: map[string]string{
+					"server":      serverDisplayName(config),
+					"job_type":    job.JobType,
+					"status":      job.Status,
+					"start_time":  job.StartTime,
+					"end_time":    job.EndTime,
+					"cycle_id":    m.cycleID,
+					"fingerprint": fingerprint,
+				},
+				Entity:   job.Name,
+				Source:   "veeam-monitor",
+				Priority: config.opsgeniePriority(job),
+			}
+			return sendOpsgenieRequest(ctx, m.httpClient, config, "/v2/alerts", alert)
+		},
+		resolve: func(ctx context.Context, alias string) error {
+			return closeOpsgenieAlert(ctx, m.httpClient, config, alias, "The job is no longer problematic")
+		},
+	}, problematicJobs, complete)
+}
+
+// Close the alert with an alias
+func closeOpsgenieAlert(ctx context.Context, httpClient *http.Client, config *Config, alias, note string) error {
+	path := "/v2/alerts/" + url.PathEscape(alias) + "/close?identifierType=alias"
+	return sendOpsgenieRequest(ctx, httpClient, config, path, opsgenieClose{Source: "veeam-monitor", Note: note})
+}
+
+// Post a request to the Alert API of OpsgenieRegion, retrying like webhooks
+// when Opsgenie is rate limiting or unavailable. The request is given
+// NotificationTimeoutSeconds within ctx, retries included. Opsgenie processes requests
+// asynchronously, so an accepted request can still fail later; such
+// failures are only shown in Opsgenie's logs.
+func sendOpsgenieRequest(ctx context.Context, httpClient *http.Client, config *Config, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	if timeout := config.notificationTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return retryPost(ctx, config.WebhookRetries, "Opsgenie", func() (time.Duration, error) {
+		return postOpsgenieRequest(ctx, httpClient, config, path, data)
+	})
+}
+
+// Post one request, returning when to retry as sendWebhookRequest does.
+// Opsgenie explains rejected requests in the body, which is included.
+func postOpsgenieRequest(ctx context.Context, httpClient *http.Client, config *Config, path string, data []byte) (time.Duration, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, config.opsgenieAPIURL()+path, bytes.NewReader(data))
+	if err != nil {
+		return -1, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "GenieKey "+config.OpsgenieAPIKey)
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return 0, nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 2048))
+	var rejected opsgenieError
+	if json.Unmarshal(body, &rejected) == nil && rejected.Message != "" {
+		reason := rejected.Message
+		for field, problem := range rejected.Errors {
+			reason += fmt.Sprintf("; %s: %s", field, problem)
+		}
+		return retryAfterStatus(resp), fmt.Errorf("Opsgenie returned status %s: %s", resp.Status, reason)
+	}
+	return retryAfterStatus(resp), fmt.Errorf("Opsgenie returned status %s", resp.Status)
+}
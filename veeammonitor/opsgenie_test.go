@@ -0,0 +1,187 @@
+package veeammonitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A request made to a stand-in Alert API
+type opsgenieRequest struct {
+	path  string
+	alert opsgenieAlert
+}
+
+type opsgenieRecorder struct {
+	mu       sync.Mutex
+	requests []opsgenieRequest
+}
+
+func (r *opsgenieRecorder) received() []opsgenieRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]opsgenieRequest{}, r.requests...)
+}
+
+// Monitor sending Opsgenie alerts to a test server through the "test" region
+func newOpsgenieMonitor(t *testing.T) (*Monitor, *opsgenieRecorder) {
+	recorder := &opsgenieRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "GenieKey api-key" {
+			t.Errorf("got Authorization %q", got)
+		}
+		request := opsgenieRequest{path: r.URL.EscapedPath()}
+		if r.URL.RawQuery != "" {
+			request.path += "?" + r.URL.RawQuery
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request.alert); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		recorder.mu.Lock()
+		recorder.requests = append(recorder.requests, request)
+		recorder.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(server.Close)
+	opsgenieAPIURLs["test"] = server.URL
+	t.Cleanup(func() { delete(opsgenieAPIURLs, "test") })
+
+	config := testConfig()
+	config.OpsgenieAPIKey = "api-key"
+	config.OpsgenieRegion = "test"
+	config.WebhookRetries = 0
+	m := newTestMonitor(config, nil)
+	m.warmedUp = true
+	return m, recorder
+}
+
+func TestOpsgenieCreatesAndClosesAlerts(t *testing.T) {
+	m, recorder := newOpsgenieMonitor(t)
+
+	m.notifyOpsgenie(failedJobs("Nightly"), true)
+	requests := recorder.received()
+	if len(requests) != 1 || requests[0].path != "/v2/alerts" {
+		t.Fatalf("got requests %+v, want one alert created", requests)
+	}
+	alias := requests[0].alert.Alias
+	if alias == "" || requests[0].alert.Priority != "P1" {
+		t.Errorf("got alert %+v, want an alias and priority P1", requests[0].alert)
+	}
+
+	// Open alerts aren't created again
+	m.notifyOpsgenie(failedJobs("Nightly"), true)
+	if requests := recorder.received(); len(requests) != 1 {
+		t.Fatalf("repeat check sent %d requests in total, want 1", len(requests))
+	}
+
+	m.notifyOpsgenie(nil, true)
+	requests = recorder.received()
+	want := "/v2/alerts/" + url.PathEscape(alias) + "/close?identifierType=alias"
+	if len(requests) != 2 || requests[1].path != want {
+		t.Errorf("got requests %+v, want the alert closed at %s", requests, want)
+	}
+}
+
+func TestOpsgenieAlertThresholds(t *testing.T) {
+	m, recorder := newOpsgenieMonitor(t)
+	m.Config.AlertMinFailedJobs = 2
+
+	m.notifyOpsgenie(failedJobs("Nightly"), true)
+	if requests := recorder.received(); len(requests) != 0 {
+		t.Fatalf("one failed job below a threshold of two sent %d requests", len(requests))
+	}
+
+	m.notifyOpsgenie(failedJobs("Nightly", "Weekly"), true)
+	if requests := recorder.received(); len(requests) != 2 {
+		t.Errorf("two failed jobs sent %d requests, want 2", len(requests))
+	}
+}
+
+func TestOpsgenieSuppressInitialAlerts(t *testing.T) {
+	m, recorder := newOpsgenieMonitor(t)
+	m.Config.SuppressInitialAlerts = true
+	m.warmedUp = false
+
+	m.notifyOpsgenie(failedJobs("Nightly"), true)
+	m.warmedUp = true
+	m.notifyOpsgenie(failedJobs("Nightly"), true)
+	if requests := recorder.received(); len(requests) != 0 {
+		t.Errorf("problem found by the first check sent %d requests, want none", len(requests))
+	}
+}
+
+func TestOpsgenieAlertPayload(t *testing.T) {
+	m, recorder := newOpsgenieMonitor(t)
+	m.Config.GroupMapping = map[string]string{"Night*": "Production"}
+	jobs := failedJobs("Nightly")
+	jobs[0].Description = strings.Repeat("x", opsgenieMaxDescription+10)
+
+	m.notifyOpsgenie(jobs, true)
+	requests := recorder.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	alert := requests[0].alert
+	if alert.Message != "Veeam job Nightly: Failed" || alert.Entity != "Nightly" || alert.Source != "veeam-monitor" || alert.Alias != incidentKey(m.Config, jobs[0]) {
+		t.Errorf("got alert %+v", alert)
+	}
+	if len([]rune(alert.Description)) != opsgenieMaxDescription {
+		t.Errorf("got a description of %d characters, want it cut to %d", len([]rune(alert.Description)), opsgenieMaxDescription)
+	}
+	if want := []string{"veeam", "localhost", "Backup", "Failed", "critical", "Production"}; !equalStrings(alert.Tags, want) {
+		t.Errorf("got tags %v, want %v", alert.Tags, want)
+	}
+	if alert.Details["server"] != "localhost" || alert.Details["end_time"] != "2024-03-01T01:20:00" || alert.Details["fingerprint"] == "" {
+		t.Errorf("got details %v", alert.Details)
+	}
+}
+
+func TestOpsgeniePriorities(t *testing.T) {
+	config, logged := loadTestConfig(t, []byte(`{"opsgeniePriorities": {"Warning": " p2 ", "critical": "urgent", "info": "P5"}}`))
+	if len(config.OpsgeniePriorities) != 1 || config.OpsgeniePriorities["warning"] != "P2" {
+		t.Errorf("got priorities %v, want only warning's kept", config.OpsgeniePriorities)
+	}
+	for _, want := range []string{`Unknown priority "URGENT" for critical in opsgeniePriorities, using P1`, `Unknown severity "info" in opsgeniePriorities`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log doesn't contain %q:\n%s", want, logged)
+		}
+	}
+
+	tests := []struct {
+		job  JobStatus
+		want string
+	}{
+		{JobStatus{Status: "Failed"}, "P1"},
+		{JobStatus{Status: "Warning"}, "P2"},
+		// Escalated warnings are critical
+		{JobStatus{Status: "Warning", Escalated: true}, "P1"},
+	}
+	for _, test := range tests {
+		if got := config.opsgeniePriority(test.job); got != test.want {
+			t.Errorf("opsgeniePriority(%+v) = %s, want %s", test.job, got, test.want)
+		}
+	}
+}
+
+func TestOpsgenieRejectedRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"message":"Request body is not processable","errors":{"message":"Message can not be empty."},"took":0.001}`))
+	}))
+	defer server.Close()
+	opsgenieAPIURLs["test"] = server.URL
+	defer delete(opsgenieAPIURLs, "test")
+	config := testConfig()
+	config.OpsgenieRegion = "test"
+	config.WebhookRetries = 3
+
+	err := sendOpsgenieRequest(context.Background(), http.DefaultClient, config, "/v2/alerts", opsgenieAlert{})
+	if err == nil || err.Error() != "Opsgenie returned status 422 Unprocessable Entity: Request body is not processable; message: Message can not be empty." {
+		t.Errorf("got error %v, want Opsgenie's explanation without retries", err)
+	}
+}
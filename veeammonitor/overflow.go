@@ -0,0 +1,168 @@
+package veeammonitor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Room left for headers and MIME boundaries when fitting an email into
+// MaxMessageBytes
+const messageHeaderAllowance = 2048
+
+// Approximate size of an email as sent: body plus base64 attachments
+func emailSize(body string, attachments []emailAttachment) int {
+	size := messageHeaderAllowance + len(body)
+	for _, attachment := range attachments {
+		encoded := base64.StdEncoding.EncodedLen(len(attachment.Data))
+		size += encoded + encoded/76*2 + 256
+	}
+	return size
+}
+
+// Copy of a report listing only its first keep jobs, most severe status
+// first, and recording how many of each status were left out. Counts still
+// cover every job. Groups are dropped as they would no longer add up.
+func truncatedReport(report *AlertReport, keep int) *AlertReport {
+	jobs := report.Jobs()
+	if keep > len(jobs) {
+		keep = len(jobs)
+	}
+
+	truncated := *report
+	truncated.Failed, truncated.Warning, truncated.Stuck, truncated.Disabled, truncated.Missing, truncated.Running, truncated.Stale, truncated.Deviation, truncated.Drift = nil, nil, nil, nil, nil, nil, nil, nil, nil
+	truncated.Groups = nil
+	for _, job := range jobs[:keep] {
+		truncated.addJob(job)
+	}
+
+	kept := truncated.jobCounts()
+	truncated.omitted = AlertCounts{
+		Total:     report.Counts.Total - kept.Total,
+		Failed:    report.Counts.Failed - kept.Failed,
+		Warning:   report.Counts.Warning - kept.Warning,
+		Stuck:     report.Counts.Stuck - kept.Stuck,
+		Disabled:  report.Counts.Disabled - kept.Disabled,
+		Missing:   report.Counts.Missing - kept.Missing,
+		Running:   report.Counts.Running - kept.Running,
+		Stale:     report.Counts.Stale - kept.Stale,
+		Deviation: report.Counts.Deviation - kept.Deviation,
+		Drift:     report.Counts.Drift - kept.Drift,
+	}
+	return &truncated
+}
+
+// Largest number of jobs, up to total, for which fits holds, 0 when none does
+func largestFitting(total int, fits func(keep int) bool) int {
+	low, high := 0, total
+	for low < high {
+		mid := (low + high + 1) / 2
+		if fits(mid) {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low
+}
+
+// Line telling how many jobs of a status were left out of a truncated alert
+func omittedLine(count int, label, hint, format string) string {
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf(format, fmt.Sprintf("... and %d more %s jobs%s", count, label, hint))
+}
+
+// Jobs left out of a truncated alert, by status
+func omittedSummary(report *AlertReport) []string {
+	var lines []string
+	for _, omitted := range []struct {
+		count int
+		label string
+	}{
+		{report.omitted.Failed, "failed"},
+		{report.omitted.Warning, "warning"},
+		{report.omitted.Stuck, "stuck"},
+		{report.omitted.Disabled, "disabled critical"},
+		{report.omitted.Missing, "missing expected"},
+		{report.omitted.Running, "long-running"},
+		{report.omitted.Stale, "stale"},
+		{report.omitted.Deviation, "size deviation"},
+		{report.omitted.Drift, "schedule drift"},
+	} {
+		if line := omittedLine(omitted.count, omitted.label, "", "%s"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Shrink an email alert to MaxMessageBytes by listing only the most severe
+// jobs and summarizing the rest. The full list is attached as CSV unless the
+// attachment alone would take over half the limit, in which case the JSON
+// report is pointed to instead.
+func fitEmailAlert(report *AlertReport, config *Config) (subject, body, html string, attachments []emailAttachment) {
+	limit := config.MaxMessageBytes
+	hint := ""
+	if attachment, err := csvAttachment(report, config); err == nil && emailSize("", []emailAttachment{attachment}) <= limit/2 {
+		attachments = []emailAttachment{attachment}
+		hint = ", see the attached CSV"
+	} else if config.ReportJSONPath != "" {
+		hint = ", see the JSON report at " + config.ReportJSONPath
+	}
+
+	build := func(keep int) (string, string, string) {
+		truncated := truncatedReport(report, keep)
+		truncated.omittedHint = hint
+		return emailAlertContent(truncated, config)
+	}
+	keep := largestFitting(report.Counts.Total, func(keep int) bool {
+		_, body, html := build(keep)
+		return emailSize(body+html, attachments) <= limit
+	})
+	log.Printf("Alert email would exceed maxMessageBytes (%d), listing %d of %d jobs\n", limit, keep, report.Counts.Total)
+	subject, body, html = build(keep)
+	return subject, body, html, attachments
+}
+
+// Total characters of Discord embed fields
+func discordFieldsSize(fields []discordEmbedField) int {
+	size := 0
+	for _, field := range fields {
+		size += len([]rune(field.Name)) + len([]rune(field.Value))
+	}
+	return size
+}
+
+// Fields for a Discord alert, limited to MaxMessageBytes in total across
+// every message by listing only the most severe jobs and summarizing the rest
+func fitDiscordFields(report *AlertReport, config *Config) []discordEmbedField {
+	fields := discordFields(report, config)
+	limit := config.MaxMessageBytes
+	if limit <= 0 || discordFieldsSize(fields) <= limit {
+		return fields
+	}
+
+	hint := ""
+	if config.ReportJSONPath != "" {
+		hint = "\nSee the JSON report at " + config.ReportJSONPath
+	}
+	build := func(keep int) []discordEmbedField {
+		truncated := truncatedReport(report, keep)
+		fields := discordFields(truncated, config)
+		if summary := omittedSummary(truncated); len(summary) > 0 {
+			fields = append(fields, discordEmbedField{
+				Name:  "Not shown",
+				Value: truncateRunes(strings.Join(summary, "\n")+hint, discordMaxFieldValue),
+			})
+		}
+		return fields
+	}
+	keep := largestFitting(report.Counts.Total, func(keep int) bool {
+		return discordFieldsSize(build(keep)) <= limit
+	})
+	log.Printf("Discord alert would exceed maxMessageBytes (%d), listing %d of %d jobs\n", limit, keep, report.Counts.Total)
+	return build(keep)
+}
@@ -0,0 +1,167 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Report of an outage with many failed and warning jobs
+func outageReport(config *Config, failed, warning int) *AlertReport {
+	var jobs []JobStatus
+	for i := 0; i < warning; i++ {
+		jobs = append(jobs, JobStatus{Name: fmt.Sprintf("Warning-%03d", i), JobType: "Backup", Status: "Warning", Description: "Retrying"})
+	}
+	for i := 0; i < failed; i++ {
+		jobs = append(jobs, JobStatus{Name: fmt.Sprintf("Failed-%03d", i), JobType: "Backup", Status: "Failed", Description: "Disk full"})
+	}
+	return NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+}
+
+func TestLargestFitting(t *testing.T) {
+	tests := []struct {
+		total, fitting, want int
+	}{
+		{10, 7, 7},
+		{10, 10, 10},
+		{10, 0, 0},
+		{1, 1, 1},
+		{0, 0, 0},
+	}
+	for _, test := range tests {
+		got := largestFitting(test.total, func(keep int) bool { return keep <= test.fitting })
+		if got != test.want {
+			t.Errorf("largestFitting(%d) with %d fitting: got %d, want %d", test.total, test.fitting, got, test.want)
+		}
+	}
+}
+
+func TestTruncatedReportKeepsCountsAndWorstJobs(t *testing.T) {
+	report := outageReport(testConfig(), 150, 50)
+	truncated := truncatedReport(report, 10)
+
+	if truncated.Counts != report.Counts {
+		t.Errorf("got counts %+v, want every job counted %+v", truncated.Counts, report.Counts)
+	}
+	if len(truncated.Failed) != 10 || len(truncated.Warning) != 0 {
+		t.Errorf("listed %d failed and %d warning jobs, want the 10 failed ones first", len(truncated.Failed), len(truncated.Warning))
+	}
+	if truncated.omitted.Failed != 140 || truncated.omitted.Warning != 50 || truncated.omitted.Total != 190 {
+		t.Errorf("got omitted %+v, want 140 failed and 50 warning", truncated.omitted)
+	}
+	want := []string{"... and 140 more failed jobs", "... and 50 more warning jobs"}
+	if got := omittedSummary(truncated); !equalStrings(got, want) {
+		t.Errorf("got summary %q, want %q", got, want)
+	}
+}
+
+func TestEmailOverflowBoundary(t *testing.T) {
+	config := testConfig()
+	config.HTMLEmail = false
+	report := outageReport(config, 150, 50)
+	_, body, _ := emailAlertContent(report, config)
+	full := emailSize(body, nil)
+
+	for _, test := range []struct {
+		limit     int
+		truncated bool
+	}{
+		{0, false},
+		{full, false},
+		{full - 1, true},
+		{full / 4, true},
+	} {
+		relay := newFakeSMTP(t)
+		config := smtpTestConfig(relay.addr, "ops@example.com")
+		config.HTMLEmail = false
+		config.MaxMessageBytes = test.limit
+		if err := sendEmailAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+			t.Fatalf("limit %d: %v", test.limit, err)
+		}
+		delivered := relay.delivered()
+		if len(delivered) != 1 {
+			t.Fatalf("limit %d: delivered %d messages", test.limit, len(delivered))
+		}
+		data := delivered[0].data
+
+		if got := strings.Contains(data, "... and "); got != test.truncated {
+			t.Errorf("limit %d: truncated %v, want %v", test.limit, got, test.truncated)
+		}
+		if !strings.Contains(data, "FAILED JOBS (150):") || !strings.Contains(data, "WARNING JOBS (50):") {
+			t.Errorf("limit %d: the counts weren't kept", test.limit)
+		}
+		if !strings.Contains(data, "Failed-000") {
+			t.Errorf("limit %d: the first failed job wasn't listed", test.limit)
+		}
+	}
+}
+
+func TestFitEmailAlertAttachesCSV(t *testing.T) {
+	config := testConfig()
+	config.HTMLEmail = false
+	config.AttachCSV = true
+	report := outageReport(config, 150, 50)
+	attachment, err := csvAttachment(report, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, _ := emailAlertContent(report, config)
+	config.MaxMessageBytes = 2 * emailSize("", []emailAttachment{attachment})
+	if emailSize(body, []emailAttachment{attachment}) <= config.MaxMessageBytes {
+		t.Fatal("the alert fits, nothing to truncate")
+	}
+
+	_, body, _, attachments := fitEmailAlert(report, config)
+	if len(attachments) != 1 || attachments[0].Filename != attachment.Filename {
+		t.Errorf("attached %+v, want the full list as CSV", attachments)
+	}
+	if size := emailSize(body, attachments); size > config.MaxMessageBytes {
+		t.Errorf("email is %d bytes, over the %d limit", size, config.MaxMessageBytes)
+	}
+	if !strings.Contains(body, "more warning jobs, see the attached CSV") {
+		t.Errorf("body doesn't point to the attachment:\n%s", body)
+	}
+}
+
+func TestFitEmailAlertPointsToJSONReport(t *testing.T) {
+	config := testConfig()
+	config.HTMLEmail = false
+	config.MaxMessageBytes = 4096 // Too small to attach the CSV
+	config.ReportJSONPath = `C:\Reports\veeam.json`
+	report := outageReport(config, 150, 50)
+
+	_, body, _, attachments := fitEmailAlert(report, config)
+	if len(attachments) != 0 {
+		t.Errorf("attached %d files, want the CSV left out", len(attachments))
+	}
+	if size := emailSize(body, attachments); size > config.MaxMessageBytes {
+		t.Errorf("email is %d bytes, over the %d limit", size, config.MaxMessageBytes)
+	}
+	if !strings.Contains(body, `more failed jobs, see the JSON report at C:\Reports\veeam.json`) {
+		t.Errorf("body doesn't point to the JSON report:\n%s", body)
+	}
+}
+
+func TestFitDiscordFields(t *testing.T) {
+	config := testConfig()
+	report := outageReport(config, 150, 50)
+	full := discordFieldsSize(discordFields(report, config))
+
+	config.MaxMessageBytes = full
+	if fields := fitDiscordFields(report, config); discordFieldsSize(fields) != full {
+		t.Errorf("fields at the limit were shortened")
+	}
+
+	config.MaxMessageBytes = full / 4
+	fields := fitDiscordFields(report, config)
+	if size := discordFieldsSize(fields); size > config.MaxMessageBytes {
+		t.Errorf("fields are %d characters, over the %d limit", size, config.MaxMessageBytes)
+	}
+	last := fields[len(fields)-1]
+	if last.Name != "Not shown" || !strings.Contains(last.Value, "more failed jobs") || !strings.Contains(last.Value, "... and 50 more warning jobs") {
+		t.Errorf("got last field %+v, want a summary of the jobs left out", last)
+	}
+}
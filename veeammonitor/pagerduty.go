@@ -0,0 +1,146 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PagerDuty Events API v2 endpoints by service region
+var pagerDutyEventsURLs = map[string]string{
+	"us": "https://events.pagerduty.com/v2/enqueue",
+	"eu": "https://events.eu.pagerduty.com/v2/enqueue",
+}
+
+// Event sent to the PagerDuty Events API v2
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"` // trigger or resolve
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+// Details of a triggered PagerDuty event
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"` // critical, error, warning or info
+	Component     string            `json:"component,omitempty"`
+	Group         string            `json:"group,omitempty"`
+	Class         string            `json:"class,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// Answer of the Events API to an event it rejected
+type pagerDutyError struct {
+	Message string   `json:"message"`
+	Errors  []string `json:"errors"`
+}
+
+// Channel name of PagerDuty in NotificationRouting
+const pagerDutyChannel = "pagerduty"
+
+// Trigger incidents for newly problematic jobs and resolve the ones that have
+// recovered, see notifyIncidents
+func (m *Monitor) notifyPagerDuty(problematicJobs []JobStatus, complete bool) {
+	config, state := m.Config, &m.state
+	if state.PagerDutyIncidents == nil {
+		state.PagerDutyIncidents = map[string]bool{}
+	}
+
+	m.notifyIncidents(incidentChannel{
+		name:    "PagerDuty",
+		channel: pagerDutyChannel,
+		open:    state.PagerDutyIncidents,
+		trigger: func(ctx context.Context, key string, job JobStatus, fingerprint string) error {
+			event := pagerDutyEvent{
+				RoutingKey:  config.PagerDutyRoutingKey,
+				EventAction: "trigger",
+				DedupKey:    key,
+				Payload: &pagerDutyPayload{
+					Summary:   fmt.Sprintf("Veeam job %s: %s", job.Name, job.Status),
+					Source:    serverDisplayName(config),
+					Severity:  config.jobSeverity(job),
+					Component: job.Name,
+					Group:     job.JobType,
+					Class:     job.Status,
+					CustomDetails: map[string]string{
+						"start_time":  job.StartTime,
+						"end_time":    job.EndTime,
+						"description": job.Description,
+						"cycle_id":    m.cycleID,
+						"fingerprint": fingerprint,
+					},
+				},
+			}
+			if len(config.GroupMapping) > 0 {
+				event.Payload.CustomDetails["group"] = config.jobGroup(job.Name)
+			}
+			return sendPagerDutyEvent(ctx, m.httpClient, config, event)
+		},
+		resolve: func(ctx context.Context, key string) error {
+			return sendPagerDutyEvent(ctx, m.httpClient, config, pagerDutyEvent{
+				RoutingKey:  config.PagerDutyRoutingKey,
+				EventAction: "resolve",
+				DedupKey:    key,
+			})
+		},
+	}, problematicJobs, complete)
+}
+
+// Post an event to the Events API of PagerDutyRegion, retrying like
+// webhooks when PagerDuty is rate limiting or unavailable. The event is
+// given NotificationTimeoutSeconds within ctx, retries included.
+func sendPagerDutyEvent(ctx context.Context, httpClient *http.Client, config *Config, event pagerDutyEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if timeout := config.notificationTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return retryPost(ctx, config.WebhookRetries, "PagerDuty", func() (time.Duration, error) {
+		return postPagerDutyEvent(ctx, httpClient, config.pagerDutyEventsURL(), data)
+	})
+}
+
+// Post one event, returning when to retry as sendWebhookRequest does.
+// PagerDuty explains rejected events in the body, which is included.
+func postPagerDutyEvent(ctx context.Context, httpClient *http.Client, eventsURL string, data []byte) (time.Duration, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, eventsURL, bytes.NewReader(data))
+	if err != nil {
+		return -1, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return 0, nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 2048))
+	var rejected pagerDutyError
+	if json.Unmarshal(body, &rejected) == nil && rejected.Message != "" {
+		reason := rejected.Message
+		if len(rejected.Errors) > 0 {
+			reason += ": " + strings.Join(rejected.Errors, "; ")
+		}
+		return retryAfterStatus(resp), fmt.Errorf("PagerDuty returned status %s: %s", resp.Status, reason)
+	}
+	return retryAfterStatus(resp), fmt.Errorf("PagerDuty returned status %s", resp.Status)
+}
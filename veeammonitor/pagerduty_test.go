@@ -0,0 +1,244 @@
+package veeammonitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Collects the events posted to a stand-in Events API
+type pagerDutyRecorder struct {
+	mu     sync.Mutex
+	events []pagerDutyEvent
+}
+
+func (r *pagerDutyRecorder) received() []pagerDutyEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]pagerDutyEvent{}, r.events...)
+}
+
+// Monitor paging a test server through the "test" region
+func newPagerDutyMonitor(t *testing.T) (*Monitor, *pagerDutyRecorder) {
+	recorder := &pagerDutyRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerDutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		recorder.mu.Lock()
+		recorder.events = append(recorder.events, event)
+		recorder.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(server.Close)
+	pagerDutyEventsURLs["test"] = server.URL
+	t.Cleanup(func() { delete(pagerDutyEventsURLs, "test") })
+
+	config := testConfig()
+	config.PagerDutyRoutingKey = "routing-key"
+	config.PagerDutyRegion = "test"
+	config.WebhookRetries = 0
+	m := newTestMonitor(config, nil)
+	m.warmedUp = true
+	return m, recorder
+}
+
+func failedJobs(names ...string) []JobStatus {
+	var jobs []JobStatus
+	for _, name := range names {
+		jobs = append(jobs, JobStatus{Name: name, JobType: "Backup", Status: "Failed", EndTime: "2024-03-01T01:20:00"})
+	}
+	return jobs
+}
+
+func TestPagerDutyAlertThresholds(t *testing.T) {
+	m, recorder := newPagerDutyMonitor(t)
+	m.Config.AlertMinFailedJobs = 2
+
+	m.notifyPagerDuty(failedJobs("Nightly"), true)
+	if events := recorder.received(); len(events) != 0 {
+		t.Fatalf("one failed job below a threshold of two sent %d events", len(events))
+	}
+
+	m.notifyPagerDuty(failedJobs("Nightly", "Weekly"), true)
+	events := recorder.received()
+	if len(events) != 2 {
+		t.Fatalf("two failed jobs sent %d events, want 2", len(events))
+	}
+	for _, event := range events {
+		if event.EventAction != "trigger" {
+			t.Errorf("got %q event, want trigger", event.EventAction)
+		}
+	}
+}
+
+func TestPagerDutyEscalatedJobIgnoresThreshold(t *testing.T) {
+	m, recorder := newPagerDutyMonitor(t)
+	m.Config.AlertMinFailedJobs = 2
+
+	jobs := failedJobs("Nightly")
+	jobs[0].Escalated = true
+	m.notifyPagerDuty(jobs, true)
+	if events := recorder.received(); len(events) != 1 {
+		t.Errorf("escalated job sent %d events, want 1", len(events))
+	}
+}
+
+func TestPagerDutySuppressInitialAlerts(t *testing.T) {
+	m, recorder := newPagerDutyMonitor(t)
+	m.Config.SuppressInitialAlerts = true
+	m.warmedUp = false
+
+	m.notifyPagerDuty(failedJobs("Nightly"), true)
+	if events := recorder.received(); len(events) != 0 {
+		t.Fatalf("first check sent %d events, want none", len(events))
+	}
+
+	// Known from the first check, so not paged later either
+	m.warmedUp = true
+	m.notifyPagerDuty(failedJobs("Nightly"), true)
+	if events := recorder.received(); len(events) != 0 {
+		t.Fatalf("second check sent %d events for a known problem, want none", len(events))
+	}
+
+	// But resolved once it recovers
+	m.notifyPagerDuty(nil, true)
+	if events := recorder.received(); len(events) != 1 || events[0].EventAction != "resolve" {
+		t.Errorf("recovery sent %+v, want one resolve", events)
+	}
+}
+
+func TestPagerDutyHeldWhileDebouncing(t *testing.T) {
+	m, recorder := newPagerDutyMonitor(t)
+	m.debounceUntil = time.Now().Add(time.Minute)
+
+	m.notifyPagerDuty(failedJobs("Nightly"), true)
+	if events := recorder.received(); len(events) != 0 {
+		t.Fatalf("held alert sent %d events, want none", len(events))
+	}
+
+	m.debounceUntil = time.Time{}
+	m.notifyPagerDuty(failedJobs("Nightly"), true)
+	if events := recorder.received(); len(events) != 1 {
+		t.Errorf("released alert sent %d events, want 1", len(events))
+	}
+}
+
+func TestPagerDutyResolvesOnlyCompleteChecks(t *testing.T) {
+	m, recorder := newPagerDutyMonitor(t)
+
+	m.notifyPagerDuty(failedJobs("Nightly"), true)
+	m.notifyPagerDuty(nil, false)
+	if events := recorder.received(); len(events) != 1 {
+		t.Fatalf("incomplete check sent %d events in total, want only the trigger", len(events))
+	}
+
+	m.notifyPagerDuty(nil, true)
+	events := recorder.received()
+	if len(events) != 2 || events[1].EventAction != "resolve" || events[1].DedupKey != events[0].DedupKey {
+		t.Errorf("got events %+v, want the incident triggered then resolved", events)
+	}
+}
+
+func TestPagerDutyEventPayloads(t *testing.T) {
+	m, recorder := newPagerDutyMonitor(t)
+	jobs := failedJobs("Nightly")
+	jobs[0].Description = "Disk full"
+
+	m.notifyPagerDuty(jobs, true)
+	m.notifyPagerDuty(nil, true)
+	events := recorder.received()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want a trigger and a resolve", len(events))
+	}
+
+	trigger, resolve := events[0], events[1]
+	if trigger.RoutingKey != "routing-key" || trigger.EventAction != "trigger" || trigger.DedupKey != incidentKey(m.Config, jobs[0]) {
+		t.Errorf("got trigger %+v", trigger)
+	}
+	payload := trigger.Payload
+	if payload == nil {
+		t.Fatal("trigger has no payload")
+	}
+	if payload.Summary != "Veeam job Nightly: Failed" || payload.Severity != "critical" || payload.Component != "Nightly" ||
+		payload.Group != "Backup" || payload.CustomDetails["description"] != "Disk full" || payload.CustomDetails["fingerprint"] == "" {
+		t.Errorf("got payload %+v", payload)
+	}
+
+	if resolve.EventAction != "resolve" || resolve.DedupKey != trigger.DedupKey || resolve.RoutingKey != "routing-key" || resolve.Payload != nil {
+		t.Errorf("got resolve %+v, want the trigger's dedup key and no payload", resolve)
+	}
+}
+
+func TestPagerDutyRejectedEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"invalid event","message":"Event object is invalid","errors":["'routing_key' is invalid"]}`))
+	}))
+	defer server.Close()
+
+	_, err := postPagerDutyEvent(context.Background(), http.DefaultClient, server.URL, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "Event object is invalid: 'routing_key' is invalid") {
+		t.Errorf("got error %v, want PagerDuty's explanation", err)
+	}
+}
+
+func TestPagerDutyRegion(t *testing.T) {
+	tests := []struct {
+		region, want, logged string
+	}{
+		{`"EU"`, "https://events.eu.pagerduty.com/v2/enqueue", ""},
+		{`""`, "https://events.pagerduty.com/v2/enqueue", `Unknown pagerDutyRegion ""`},
+		{`"apac"`, "https://events.pagerduty.com/v2/enqueue", `Unknown pagerDutyRegion "apac", using us`},
+	}
+	for _, test := range tests {
+		config, logged := loadTestConfig(t, []byte(`{"pagerDutyRegion": `+test.region+`}`))
+		if got := config.pagerDutyEventsURL(); got != test.want {
+			t.Errorf("region %s: got %s, want %s", test.region, got, test.want)
+		}
+		if !strings.Contains(logged, test.logged) {
+			t.Errorf("region %s: log doesn't contain %q:\n%s", test.region, test.logged, logged)
+		}
+	}
+	config, _ := loadTestConfig(t, []byte(`{}`))
+	if config.PagerDutyRegion != "us" {
+		t.Errorf("got default region %q, want us", config.PagerDutyRegion)
+	}
+}
+
+func TestPagerDutyRetries(t *testing.T) {
+	var mu sync.Mutex
+	var statuses []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer server.Close()
+	pagerDutyEventsURLs["test"] = server.URL
+	defer delete(pagerDutyEventsURLs, "test")
+	config := testConfig()
+	config.PagerDutyRegion = "test"
+	config.WebhookRetries = 1
+	event := pagerDutyEvent{RoutingKey: "routing-key", EventAction: "resolve", DedupKey: "key"}
+
+	statuses = []int{http.StatusServiceUnavailable, http.StatusAccepted}
+	if err := sendPagerDutyEvent(context.Background(), http.DefaultClient, config, event); err != nil || len(statuses) != 0 {
+		t.Errorf("got error %v with %d answers left, want the event retried after 503", err, len(statuses))
+	}
+
+	// Rejected events aren't retried, and without an explanation only the
+	// status is reported
+	statuses = []int{http.StatusBadRequest, http.StatusAccepted}
+	err := sendPagerDutyEvent(context.Background(), http.DefaultClient, config, event)
+	if err == nil || err.Error() != "PagerDuty returned status 400 Bad Request" || len(statuses) != 1 {
+		t.Errorf("got error %v with %d answers left", err, len(statuses))
+	}
+}
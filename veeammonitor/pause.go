@@ -0,0 +1,114 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Whether notifications are paused, for /status
+type pauseStatus struct {
+	Paused           bool       `json:"paused"`
+	Until            *time.Time `json:"until,omitempty"`            // End of a pause set through /pause
+	RemainingMinutes int        `json:"remainingMinutes,omitempty"` // Minutes left of a pause set through /pause, rounded up
+	PauseFile        bool       `json:"pauseFile,omitempty"`        // Paused because PauseFile exists
+}
+
+// Current pause state. A pause set through /pause ends by itself once its
+// time is up; one from PauseFile lasts as long as the file exists.
+func (m *Monitor) pauseState(now time.Time) pauseStatus {
+	var status pauseStatus
+	m.pauseMu.Lock()
+	if !m.pausedUntil.IsZero() {
+		if now.Before(m.pausedUntil) {
+			until := m.pausedUntil
+			status.Paused, status.Until = true, &until
+			status.RemainingMinutes = int((until.Sub(now) + time.Minute - 1) / time.Minute)
+		} else {
+			m.logln("Pause ended, notifications resumed")
+			m.pausedUntil = time.Time{}
+		}
+	}
+	m.pauseMu.Unlock()
+
+	if m.Config.PauseFile != "" {
+		if _, err := os.Stat(m.Config.PauseFile); err == nil {
+			status.Paused, status.PauseFile = true, true
+		}
+	}
+	return status
+}
+
+// Pause notifications for duration, or resume them when duration is zero
+func (m *Monitor) pause(duration time.Duration, now time.Time) {
+	m.pauseMu.Lock()
+	defer m.pauseMu.Unlock()
+	m.pausedUntil = time.Time{}
+	if duration > 0 {
+		m.pausedUntil = now.Add(duration)
+	}
+}
+
+// Why notifications are paused, for log lines, empty when they aren't
+func (s pauseStatus) reason(config *Config) string {
+	switch {
+	case s.PauseFile:
+		return fmt.Sprintf("while %s exists", config.PauseFile)
+	case s.Paused:
+		return "until " + formatNextCheck(s.Until.In(config.location()))
+	}
+	return ""
+}
+
+// Longest pause /pause accepts, 30 days
+const maxPauseMinutes = 30 * 24 * 60
+
+// Pause notifications: POST /pause with minutes as a query or form value, or
+// a JSON object. DELETE /pause resumes them early. Checks keep running and
+// logging while paused.
+func (m *Monitor) handlePause(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		m.pause(0, time.Now())
+		m.logf("Notifications resumed over HTTP from %s\n", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.pauseState(time.Now()))
+		return
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Minutes int `json:"minutes"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+	} else if value := r.FormValue("minutes"); value != "" {
+		var err error
+		if request.Minutes, err = strconv.Atoi(value); err != nil {
+			request.Minutes = -1
+		}
+	}
+	if request.Minutes <= 0 || request.Minutes > maxPauseMinutes {
+		http.Error(w, fmt.Sprintf("minutes must be a number from 1 to %d, e.g. /pause?minutes=120", maxPauseMinutes), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	m.pause(time.Duration(request.Minutes)*time.Minute, now)
+	status := m.pauseState(now)
+	m.logf("Notifications paused for %d minutes over HTTP from %s, %s\n", request.Minutes, r.RemoteAddr, status.reason(m.Config))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
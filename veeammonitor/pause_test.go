@@ -0,0 +1,159 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Send a pause request to the monitor's HTTP handler
+func servePauseRequest(m *Monitor, method, target, contentType, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestPauseSuppressesNotifications(t *testing.T) {
+	m, capture := newCaptureMonitor(testConfig(), statusRunner([]string{"Nightly"}, nil))
+
+	recorder := servePauseRequest(m, http.MethodPost, "/pause?minutes=60", "", "")
+	var status pauseStatus
+	json.NewDecoder(recorder.Body).Decode(&status)
+	if recorder.Code != http.StatusOK || !status.Paused || status.RemainingMinutes != 60 || status.Until == nil {
+		t.Fatalf("got status %d and pause %+v, want paused for 60 minutes", recorder.Code, status)
+	}
+
+	// Checks keep running while paused, without notifying
+	m.RunCheckCycle()
+	if sent := capture.sent(); len(sent) != 0 {
+		t.Errorf("sent %d reports while paused", len(sent))
+	}
+	if jobs := m.status.Jobs; len(jobs) != 1 || jobs[0].Name != "Nightly" {
+		t.Errorf("got jobs %+v, want the check to have run", jobs)
+	}
+
+	// The pause ends by itself
+	m.pauseMu.Lock()
+	m.pausedUntil = time.Now().Add(-time.Second)
+	m.pauseMu.Unlock()
+	if status := m.pauseState(time.Now()); status.Paused || !m.pausedUntil.IsZero() {
+		t.Errorf("got pause %+v after it ended", status)
+	}
+	m.RunCheckCycle()
+	if sent := capture.sent(); len(sent) != 1 {
+		t.Errorf("sent %d reports after the pause ended, want 1", len(sent))
+	}
+}
+
+func TestPauseStatus(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	now := time.Now()
+	m.pause(60*time.Minute, now)
+
+	tests := []struct {
+		after time.Duration
+		want  int
+	}{
+		{0, 60},
+		{30 * time.Second, 60},
+		{time.Minute, 59},
+		{59*time.Minute + 30*time.Second, 1},
+	}
+	for _, test := range tests {
+		if status := m.pauseState(now.Add(test.after)); !status.Paused || status.RemainingMinutes != test.want {
+			t.Errorf("after %v: got %+v, want %d minutes remaining", test.after, status, test.want)
+		}
+	}
+
+	recorder := servePauseRequest(m, http.MethodGet, "/status", "", "")
+	var status cycleStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Pause.Paused || status.Pause.RemainingMinutes < 59 || status.Pause.Until == nil {
+		t.Errorf("got /status pause %+v", status.Pause)
+	}
+
+	// DELETE resumes early
+	recorder = servePauseRequest(m, http.MethodDelete, "/pause", "", "")
+	var resumed pauseStatus
+	json.NewDecoder(recorder.Body).Decode(&resumed)
+	if recorder.Code != http.StatusOK || resumed.Paused {
+		t.Errorf("got status %d and pause %+v after resuming", recorder.Code, resumed)
+	}
+	if restarted := newTestMonitor(testConfig(), nil); restarted.pauseState(time.Now()).Paused {
+		t.Error("a new monitor starts paused")
+	}
+}
+
+func TestPauseFile(t *testing.T) {
+	config := testConfig()
+	config.PauseFile = filepath.Join(t.TempDir(), "pause")
+	m := newTestMonitor(config, nil)
+	if m.pauseState(time.Now()).Paused {
+		t.Error("paused without the pause file")
+	}
+	if err := ioutil.WriteFile(config.PauseFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	status := m.pauseState(time.Now())
+	if !status.Paused || !status.PauseFile || status.Until != nil {
+		t.Errorf("got pause %+v with the pause file present", status)
+	}
+	if reason := status.reason(config); reason != "while "+config.PauseFile+" exists" {
+		t.Errorf("got reason %q", reason)
+	}
+	if m.allowNotification("email") {
+		t.Error("notification allowed while paused")
+	}
+	os.Remove(config.PauseFile)
+	if m.pauseState(time.Now()).Paused || !m.allowNotification("email") {
+		t.Error("still paused after the pause file was removed")
+	}
+}
+
+func TestPauseHandlerRequests(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	tests := []struct {
+		name, method, target, contentType, body string
+		want                                    int
+	}{
+		{"no minutes", http.MethodPost, "/pause", "", "", http.StatusBadRequest},
+		{"zero minutes", http.MethodPost, "/pause?minutes=0", "", "", http.StatusBadRequest},
+		{"not a number", http.MethodPost, "/pause?minutes=soon", "", "", http.StatusBadRequest},
+		{"too long", http.MethodPost, "/pause?minutes=43201", "", "", http.StatusBadRequest},
+		{"invalid JSON", http.MethodPost, "/pause", "application/json", "{", http.StatusBadRequest},
+		{"GET", http.MethodGet, "/pause", "", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		if recorder := servePauseRequest(m, test.method, test.target, test.contentType, test.body); recorder.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.want)
+		}
+	}
+	if m.pauseState(time.Now()).Paused {
+		t.Fatal("paused by a rejected request")
+	}
+
+	if recorder := servePauseRequest(m, http.MethodPost, "/pause", "application/json", `{"minutes": 5}`); recorder.Code != http.StatusOK {
+		t.Errorf("JSON: got status %d", recorder.Code)
+	}
+	if status := m.pauseState(time.Now()); !status.Paused || status.RemainingMinutes != 5 {
+		t.Errorf("got pause %+v, want 5 minutes", status)
+	}
+	if recorder := servePauseRequest(m, http.MethodPost, "/pause", "application/x-www-form-urlencoded", "minutes=120"); recorder.Code != http.StatusOK {
+		t.Errorf("form: got status %d", recorder.Code)
+	}
+	if status := m.pauseState(time.Now()); status.RemainingMinutes != 120 {
+		t.Errorf("got pause %+v, want the new pause to replace the old", status)
+	}
+}
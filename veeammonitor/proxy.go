@@ -0,0 +1,60 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Environment variables net/http takes a proxy from, in the order checked
+var proxyEnvironment = []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"}
+
+// Time allowed for an outbound notification, OAuth or AWS request
+const httpClientTimeout = 30 * time.Second
+
+// Check that ProxyURL is a URL a transport can use
+func parseProxyURL(value string) (*url.URL, error) {
+	proxy, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("scheme must be http, https or socks5, e.g. http://proxy.example.com:3128")
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("no proxy host")
+	}
+	return proxy, nil
+}
+
+// Client of a Monitor's outbound notification, heartbeat, OAuth and AWS
+// requests, going through ProxyURL, or the proxy in the environment (honoring
+// NO_PROXY) when it isn't set, logging which is used. Each Monitor has its own
+// so monitors of different configs don't share a proxy. Enterprise Manager
+// and WinRM are reached directly.
+func newHTTPClient(config *Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if config.ProxyURL != "" {
+		proxy, err := parseProxyURL(config.ProxyURL)
+		if err != nil {
+			log.Printf("Error in proxyURL, sending notifications without it: %v\n", err)
+		} else {
+			transport.Proxy = http.ProxyURL(proxy)
+			log.Printf("Sending notifications through proxy %s\n", proxy.Redacted())
+		}
+	} else {
+		for _, name := range proxyEnvironment {
+			if value := os.Getenv(name); value != "" {
+				log.Printf("Sending notifications through the proxy set in %s, except for hosts in NO_PROXY\n", name)
+				break
+			}
+		}
+	}
+	return &http.Client{Timeout: httpClientTimeout, Transport: transport}
+}
@@ -0,0 +1,72 @@
+package veeammonitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Stand-in forward proxy recording the URLs it is asked for
+type stubProxy struct {
+	*httptest.Server
+	mu   sync.Mutex
+	urls []string
+}
+
+func newStubProxy(t *testing.T) *stubProxy {
+	proxy := &stubProxy{}
+	proxy.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.mu.Lock()
+		proxy.urls = append(proxy.urls, r.URL.String())
+		proxy.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func (p *stubProxy) requested() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.urls...)
+}
+
+func TestHTTPClientUsesProxyURL(t *testing.T) {
+	proxy := newStubProxy(t)
+	config := testConfig()
+	config.ProxyURL = proxy.URL
+
+	err := postDiscordMessage(context.Background(), newHTTPClient(config), "http://discord.example/api/webhooks/1", discordMessage{Content: "test"})
+	if err != nil {
+		t.Fatalf("posting through the proxy: %v", err)
+	}
+	if urls := proxy.requested(); len(urls) != 1 || urls[0] != "http://discord.example/api/webhooks/1" {
+		t.Errorf("proxy was asked for %v, want the webhook", urls)
+	}
+}
+
+func TestHTTPClientsArePerConfig(t *testing.T) {
+	first, second := newStubProxy(t), newStubProxy(t)
+	firstConfig, secondConfig := testConfig(), testConfig()
+	firstConfig.ProxyURL = first.URL
+	secondConfig.ProxyURL = second.URL
+
+	// Building the second client leaves the first one's proxy alone
+	firstClient := newHTTPClient(firstConfig)
+	secondClient := newHTTPClient(secondConfig)
+	if err := pingHeartbeat(firstClient, "http://heartbeat.example/first"); err != nil {
+		t.Fatalf("pinging through the first proxy: %v", err)
+	}
+	if err := pingHeartbeat(secondClient, "http://heartbeat.example/second"); err != nil {
+		t.Fatalf("pinging through the second proxy: %v", err)
+	}
+
+	if urls := first.requested(); len(urls) != 1 || urls[0] != "http://heartbeat.example/first" {
+		t.Errorf("first proxy was asked for %v", urls)
+	}
+	if urls := second.requested(); len(urls) != 1 || urls[0] != "http://heartbeat.example/second" {
+		t.Errorf("second proxy was asked for %v", urls)
+	}
+}
@@ -0,0 +1,572 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrVeeamUnreachable is returned when the Veeam module can't be loaded or the server can't be contacted.
+// A module that isn't installed at all is also ErrFatalEnvironment.
+var ErrVeeamUnreachable = errors.New("Veeam server unreachable")
+
+// ErrPowerShellTimeout is returned when a script runs longer than
+// CommandTimeoutSeconds, locally or over WinRM
+var ErrPowerShellTimeout = errors.New("PowerShell timed out")
+
+// ErrParseFailure is matched by every ParseError
+var ErrParseFailure = errors.New("error parsing query output")
+
+// ParseError is returned when the output of a query can't be parsed
+type ParseError struct {
+	What string // The output that didn't parse, e.g. "job output"
+	Err  error
+}
+
+func (e *ParseError) Error() string        { return "error parsing " + e.What + ": " + e.Err.Error() }
+func (e *ParseError) Unwrap() error        { return e.Err }
+func (e *ParseError) Is(target error) bool { return target == ErrParseFailure }
+
+// Kinds of query error, by the sentinel they match, for metrics
+var queryErrorKinds = []struct {
+	kind string
+	err  error
+}{
+	{"module", ErrModuleNotFound},
+	{"environment", ErrFatalEnvironment},
+	{"unreachable", ErrVeeamUnreachable},
+	{"timeout", ErrPowerShellTimeout},
+	{"parse", ErrParseFailure},
+}
+
+// Classify a query error as module, environment, unreachable, timeout,
+// parse or other
+func queryErrorKind(err error) string {
+	for _, known := range queryErrorKinds {
+		if errors.Is(err, known.err) {
+			return known.kind
+		}
+	}
+	return "other"
+}
+
+// Markers written by the PowerShell scripts when setup fails
+const (
+	moduleErrorMarker  = "VEEAM_MODULE_ERROR:"
+	connectErrorMarker = "VEEAM_CONNECT_ERROR:"
+)
+
+// Timestamp layout of the PowerShell CSV output, used by other job sources
+// so every source reports times the same way
+const displayTimeLayout = "1/2/2006 3:04:05 PM"
+
+// CommandRunner runs PowerShell scripts, replaceable so the query layer can be
+// tested. Stdout and stderr are returned separately so error text is never
+// parsed as CSV; err is non-nil when the script exits non-zero.
+type CommandRunner interface {
+	Run(script string) (stdout string, stderr string, err error)
+}
+
+// ContextRunner is a CommandRunner that can abandon a script when a context
+// is cancelled, so stopping the monitor doesn't wait for running queries
+type ContextRunner interface {
+	RunContext(ctx context.Context, script string) (stdout string, stderr string, err error)
+}
+
+// PowerShellRunner runs scripts through the local powershell executable
+type PowerShellRunner struct {
+	Timeout time.Duration     // Kill scripts running longer than this, 0 for no limit
+	Env     map[string]string // Extra environment variables, used to pass secrets outside the script
+}
+
+func (r PowerShellRunner) Run(script string) (string, string, error) {
+	return r.RunContext(context.Background(), script)
+}
+
+// RunContext runs a script like Run, killing powershell when ctx is cancelled
+func (r PowerShellRunner) RunContext(ctx context.Context, script string) (string, string, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	if len(r.Env) > 0 {
+		cmd.Env = os.Environ()
+		for name, value := range r.Env {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%w after %v", ErrPowerShellTimeout, r.Timeout)
+	}
+	return stdout.String(), stderr.String(), err
+}
+
+// Known PowerShell/Veeam errors and clearer explanations for them
+var powerShellErrorHints = []struct {
+	pattern string
+	hint    string
+}{
+	{"was not loaded because no valid module file was found", "Veeam PowerShell module not found, check veeamPowerShellModule and that the Veeam console is installed"},
+	{"is not recognized as the name of a cmdlet", "Veeam cmdlet not available, the installed Veeam version may not support this query"},
+	{"Access is denied", "access denied, run the monitor as an account with Veeam administrator rights"},
+	{"UnauthorizedAccess", "access denied, run the monitor as an account with Veeam administrator rights"},
+	{"running scripts is disabled on this system", "PowerShell execution policy blocks scripts, adjust it with Set-ExecutionPolicy"},
+}
+
+// Build an error for a failed PowerShell run from its stderr output
+func powerShellError(stderr string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w: powershell executable not found: %v", ErrFatalEnvironment, err)
+	}
+
+	stderr = strings.TrimSpace(stderr)
+	for _, known := range powerShellErrorHints {
+		if strings.Contains(stderr, known.pattern) {
+			return fmt.Errorf("%s (%w): %s", known.hint, err, firstLine(stderr))
+		}
+	}
+
+	if stderr == "" {
+		return fmt.Errorf("PowerShell exited with an error: %w", err)
+	}
+	return fmt.Errorf("PowerShell exited with an error (%w): %s", err, stderr)
+}
+
+// First non-empty line of some text
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// Wrap a query with module import and server connect/disconnect, failing
+// with a marker line when either step doesn't succeed
+func veeamScript(config *Config, query string) string {
+	return fmt.Sprintf(`
+		try {
+			%s
+		} catch {
+			Write-Output "%s $_"
+			exit 1
+		}
+		if ("%s" -ne "") {
+			try {
+				$Server = %s
+			} catch {
+				Write-Output "%s $_"
+				exit 1
+			}
+		}
+		%s
+		if ("%s" -ne "") {
+			Disconnect-VBRServer
+		}
+	`, loadModuleStatement(config.VeeamPowerShellModule), moduleErrorMarker, config.veeamConnectServer(), connectStatement(config),
+		connectErrorMarker, query, config.veeamConnectServer())
+}
+
+// Environment variable the Veeam password is passed to PowerShell in, so it
+// never appears in a script or command line
+const veeamPasswordEnv = "VEEAM_MONITOR_PASSWORD"
+
+// Server to connect to with Connect-VBRServer, empty to use the local
+// server without connecting. Credentials need an explicit connection, so
+// with VeeamUsername set the local server is connected to as localhost.
+func (c *Config) veeamConnectServer() string {
+	if c.VeeamServerAddress == "" && c.VeeamUsername != "" {
+		return "localhost"
+	}
+	return c.VeeamServerAddress
+}
+
+// PowerShell connecting to the Veeam server, as VeeamUsername when set with
+// the password read from the environment into a PSCredential
+func connectStatement(config *Config) string {
+	statement := "Connect-VBRServer -Server " + powerShellQuote(config.veeamConnectServer()) + " -ErrorAction Stop"
+	if config.VeeamUsername != "" {
+		statement += fmt.Sprintf(` -Credential (New-Object System.Management.Automation.PSCredential(%s, (ConvertTo-SecureString $env:%s -AsPlainText -Force)))`,
+			powerShellQuote(config.VeeamUsername), veeamPasswordEnv)
+	}
+	return statement
+}
+
+// Quote a value as a PowerShell single-quoted string
+func powerShellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// Environment the Veeam scripts need: the password for VeeamUsername
+func veeamScriptEnv(config *Config) map[string]string {
+	if config.VeeamUsername == "" {
+		return nil
+	}
+	return map[string]string{veeamPasswordEnv: config.VeeamPassword}
+}
+
+// Mask the Veeam password in script output, in case an error message
+// echoes it back
+func redactPassword(text string, config *Config) string {
+	if config.VeeamPassword == "" {
+		return text
+	}
+	return strings.Replace(text, config.VeeamPassword, "***", -1)
+}
+
+// Run a script through Runner, killing it when the monitor is stopped if the
+// runner supports that
+func (m *Monitor) runScript(script string) (string, string, error) {
+	if runner, ok := m.Runner.(ContextRunner); ok {
+		return runner.RunContext(m.runContext(), script)
+	}
+	return m.Runner.Run(script)
+}
+
+// Run a Veeam query script, separating connection failures from other errors
+func (m *Monitor) runVeeamScript(query string) (string, error) {
+	config := m.Config
+	started := time.Now()
+	output, stderr, err := m.runScript(veeamScript(config, query))
+	m.recordPowerShellRun(time.Since(started))
+	output, stderr = redactPassword(output, config), redactPassword(stderr, config)
+
+	// Connection problems are reported even if the exit code was lost
+	for _, line := range strings.Split(output+"\n"+stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, moduleErrorMarker) {
+			text := strings.TrimSpace(strings.TrimPrefix(line, moduleErrorMarker))
+			if isFatalModuleError(text) {
+				err := fmt.Errorf("%w: %w (%s): %s", ErrVeeamUnreachable, ErrModuleNotFound, config.VeeamPowerShellModule, text)
+				m.noteFatalError(err)
+				return "", err
+			}
+			return "", fmt.Errorf("%w: failed to load module %s: %s", ErrVeeamUnreachable, config.VeeamPowerShellModule, text)
+		}
+		if strings.HasPrefix(line, connectErrorMarker) {
+			return "", fmt.Errorf("%w: failed to connect to %s: %s", ErrVeeamUnreachable,
+				config.veeamConnectServer(), strings.TrimSpace(strings.TrimPrefix(line, connectErrorMarker)))
+		}
+	}
+
+	// Never hand error output to the CSV parser
+	if errors.Is(err, ErrVeeamUnreachable) {
+		return "", err
+	}
+	if err != nil {
+		err = powerShellError(stderr, err)
+		m.noteFatalError(err)
+		return "", err
+	}
+	if strings.TrimSpace(stderr) != "" {
+		m.logf("Warning: PowerShell reported errors: %s\n", firstLine(stderr))
+	}
+	return output, nil
+}
+
+// PowerShell listing the jobs of each supported type, normalized to the columns
+// Name, LastResult, LastStart, LastEnd, Description, IsRunning, SessionStart,
+// IsEnabled, NextRun (empty for jobs without a schedule of their own),
+// RetryPending (Veeam will automatically retry the last session) and, where
+// the session is fetched anyway, Session
+var jobTypeSources = map[string]string{
+	"backup": `Get-VBRJob | Select-Object Name,LastResult,LastStart,LastEnd,Description,IsRunning,@{Name="SessionStart";Expression={$_.FindLastSession().CreationTime}},@{Name="IsEnabled";Expression={$_.IsScheduleEnabled}},@{Name="NextRun";Expression={if ($_.IsScheduleEnabled) { $_.ScheduleOptions.NextRun }}},@{Name="RetryPending";Expression={$_.FindLastSession().WillBeRetried -eq $true}}`,
+	"copy": `Get-VBRBackupCopyJob | ForEach-Object {
+			$session = Get-VBRSession -Job $_ -Last
+			[pscustomobject]@{Name=$_.Name; LastResult=$session.Result; LastStart=$session.CreationTime; LastEnd=$session.EndTime; Description=$_.Description; IsRunning=($session.State -eq "Working"); SessionStart=$session.CreationTime; IsEnabled=$_.JobEnabled; NextRun=$(if ($_.JobEnabled -and $_.ScheduleOptions) { $_.ScheduleOptions.NextRun }); RetryPending=($session.WillBeRetried -eq $true); Session=$session}
+		}`,
+	"tape": `Get-VBRTapeJob | ForEach-Object {
+			$session = Get-VBRSession -Job $_ -Last
+			[pscustomobject]@{Name=$_.Name; LastResult=$_.LastResult; LastStart=$session.CreationTime; LastEnd=$session.EndTime; Description=$_.Description; IsRunning=($_.LastState -eq "Working"); SessionStart=$session.CreationTime; IsEnabled=$_.Enabled; NextRun=$(if ($_.Enabled) { $_.NextRun }); RetryPending=($session.WillBeRetried -eq $true); Session=$session}
+		}`,
+	"agent": `Get-VBRComputerBackupJob | ForEach-Object {
+			$session = Get-VBRComputerBackupJobSession -Name $_.Name | Sort-Object CreationTime -Descending | Select-Object -First 1
+			[pscustomobject]@{Name=$_.Name; LastResult=$session.Result; LastStart=$session.CreationTime; LastEnd=$session.EndTime; Description=$_.Description; IsRunning=($session.State -eq "Working"); SessionStart=$session.CreationTime; IsEnabled=$_.JobEnabled; NextRun=$(if ($_.JobEnabled -and $_.ScheduleOptions) { $_.ScheduleOptions.NextRun }); RetryPending=($session.WillBeRetried -eq $true); Session=$session}
+		}`,
+	// The SureBackup cmdlets arrived in Veeam 12. Without them no rows are
+	// written, and the note on stderr is logged as a warning.
+	"surebackup": `& {
+			if (-not (Get-Command Get-VBRSureBackupJob -ErrorAction SilentlyContinue)) {
+				[Console]::Error.WriteLine("SureBackup cmdlets not available, Veeam 12 or later is needed to monitor SureBackup jobs")
+				return
+			}
+			$sessions = @(Get-VBRSureBackupSession)
+			Get-VBRSureBackupJob | ForEach-Object {
+				$job = $_
+				$session = $sessions | Where-Object {$_.JobId -eq $job.Id} | Sort-Object CreationTime -Descending | Select-Object -First 1
+				[pscustomobject]@{Name=$job.Name; LastResult=$session.Result; LastStart=$session.CreationTime; LastEnd=$session.EndTime; Description=$job.Description; IsRunning=($session.State -eq "Working"); SessionStart=$session.CreationTime; IsEnabled=($job.IsEnabled -ne $false); NextRun=$(if ($job.IsEnabled -ne $false -and $job.ScheduleOptions) { $job.ScheduleOptions.NextRun }); RetryPending=$false; Session=$session}
+			}
+		}`,
+}
+
+// Display names for the supported job types
+var jobTypeLabels = map[string]string{
+	"backup":     "Backup",
+	"copy":       "Backup Copy",
+	"tape":       "Tape",
+	"agent":      "Agent",
+	"surebackup": "SureBackup",
+}
+
+// Run a query for every monitored job type and merge the results. A failing
+// job type (e.g. a cmdlet missing on older Veeam versions) doesn't hide the others.
+func (m *Monitor) queryJobTypes(description string, buildQuery func(source string) string, status string) ([]JobStatus, error) {
+	config := m.Config
+	var jobs []JobStatus
+	var lastErr error
+	failures := 0
+
+	for _, jobType := range config.MonitorJobTypes {
+		typeJobs, err := m.queryJobType(jobType, description, buildQuery, status)
+		if errors.Is(err, ErrVeeamUnreachable) {
+			return nil, err
+		}
+		if err != nil {
+			lastErr = err
+			failures++
+			continue
+		}
+		jobs = append(jobs, typeJobs...)
+	}
+
+	if failures > 0 && failures == len(config.MonitorJobTypes) {
+		return nil, lastErr
+	}
+	return jobs, nil
+}
+
+// Run a query for one job type, logging errors other than an unreachable server
+func (m *Monitor) queryJobType(jobType string, description string, buildQuery func(source string) string, status string) ([]JobStatus, error) {
+	config := m.Config
+	output, err := m.runVeeamScript(buildQuery(jobTypeSources[jobType]))
+	if err != nil {
+		err = fmt.Errorf("failed to execute PowerShell command for %s %s jobs: %w", jobType, description, err)
+		if !errors.Is(err, ErrVeeamUnreachable) {
+			m.logf("Error checking %s jobs: %v\n", jobType, err)
+		}
+		return nil, err
+	}
+
+	// Parse the CSV output
+	jobs, err := parseJobStatusOutput(output, status, config.csvDelimiter())
+	if err != nil {
+		m.logf("Error parsing %s jobs: %v\n", jobType, err)
+		return nil, err
+	}
+	for i := range jobs {
+		jobs[i].JobType = jobTypeLabels[jobType]
+		jobs[i].StartTime = config.displayTime(jobs[i].StartTime)
+		jobs[i].EndTime = config.displayTime(jobs[i].EndTime)
+		jobs[i].NextRun = config.displayTime(jobs[i].NextRun)
+	}
+	return jobs, nil
+}
+
+// Get jobs by status (Failed, Warning, etc.)
+func (m *Monitor) getJobsByStatus(status string) ([]JobStatus, error) {
+	if m.Config.IncrementalQueries {
+		return m.cachedJobsByStatus(status)
+	}
+	// PowerShell command to get jobs with specified status
+	return m.queryJobTypes(status, func(source string) string {
+		return fmt.Sprintf(`%s | Where-Object {$_.LastResult -eq "%s"} | Select-Object Name,LastResult,LastStart,LastEnd,Description,NextRun,RetryPending | %s`, source, status, convertToCsv(m.Config))
+	}, status)
+}
+
+// Get every monitored job whatever its result, with an empty Status
+func (m *Monitor) getAllJobs() ([]JobStatus, error) {
+	return m.queryJobTypes("all", func(source string) string {
+		return fmt.Sprintf(`%s | Select-Object Name,LastResult,LastStart,LastEnd,Description,NextRun,IsEnabled | %s`, source, convertToCsv(m.Config))
+	}, "")
+}
+
+// Get long-running jobs
+func (m *Monitor) getLongRunningJobs() ([]JobStatus, error) {
+	config := m.Config
+	// PowerShell command to get currently running jobs. Durations are taken
+	// from the Veeam server's own clock, so skew with this machine can't matter.
+	jobs, err := m.queryJobTypes("long-running", func(source string) string {
+		return fmt.Sprintf(`
+		$runningJobs = %s | Where-Object {$_.IsRunning -eq $true} | Select-Object Name,@{Name="Status";Expression={"Running"}},@{Name="StartTime";Expression={$_.SessionStart}},@{Name="EndTime";Expression={"N/A"}},@{Name="Description";Expression={"Currently running"}},@{Name="Duration";Expression={((Get-Date) - $_.SessionStart).TotalMinutes}},NextRun
+		$longRunningJobs = $runningJobs | Where-Object {$_.Duration -gt %d}
+		$longRunningJobs | Select-Object Name,Status,StartTime,EndTime,Description,@{Name="Duration";Expression={$_.Duration.ToString([cultureinfo]::InvariantCulture)}},NextRun | %s
+	`, source, config.longRunningMinutes(), convertToCsv(config))
+	}, "Running")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Add duration information to job description
+	for i := range jobs {
+		jobs[i].Description = fmt.Sprintf("Long-running job (over %d minutes): %s",
+			config.LongRunningThreshold, jobs[i].Description)
+	}
+
+	return jobs, nil
+}
+
+// Get jobs that haven't run within MaxJobAgeHours
+func (m *Monitor) getStaleJobs() ([]JobStatus, error) {
+	config := m.Config
+	// PowerShell command to get the hours since each job last ran, -1 if it never ran
+	jobs, err := m.queryJobTypes("stale", func(source string) string {
+		return fmt.Sprintf(`
+		$includeDisabled = $%t
+		%s | Where-Object {$includeDisabled -or $_.IsEnabled} | Select-Object Name,@{Name="Status";Expression={"Stale"}},LastStart,LastEnd,Description,@{Name="AgeHours";Expression={
+			$lastRun = if ($_.LastEnd) { $_.LastEnd } else { $_.LastStart }
+			if ($lastRun) { ((Get-Date) - $lastRun).TotalHours.ToString([cultureinfo]::InvariantCulture) } else { "-1" }
+		}},NextRun | %s
+	`, config.IncludeDisabledJobs, source, convertToCsv(config))
+	}, "Stale")
+	if err != nil {
+		return nil, err
+	}
+
+	return staleJobs(jobs, config.MaxJobAgeHours), nil
+}
+
+// Keep jobs whose last run (hours held in Duration) is older than maxAgeHours
+// or that have never run, describing how overdue each one is
+func staleJobs(jobs []JobStatus, maxAgeHours int) []JobStatus {
+	var stale []JobStatus
+	for _, job := range jobs {
+		ageHours, err := strconv.ParseFloat(strings.TrimSpace(job.Duration), 64)
+		if err != nil {
+			log.Printf("Warning: Could not read last run age %q for job %s\n", job.Duration, job.Name)
+			continue
+		}
+
+		if ageHours < 0 {
+			job.Description = "Job has never run"
+		} else if ageHours > float64(maxAgeHours) {
+			job.Description = fmt.Sprintf("Job hasn't run in %d hours (threshold %d hours)", int(ageHours), maxAgeHours)
+		} else {
+			continue
+		}
+		stale = append(stale, job)
+	}
+	return stale
+}
+
+// NextRun of jobs that only run manually or after another job
+const notScheduled = "not scheduled"
+
+// Parse the CSV output from PowerShell. Columns are read by position: name,
+// status, start, end, description and, for long-running and stale jobs, a
+// duration. NextRun and RetryPending are found by their headers as they
+// follow a varying number of columns.
+func parseJobStatusOutput(output string, status string, delimiter rune) ([]JobStatus, error) {
+	reader := csv.NewReader(strings.NewReader(cleanCSVOutput(output)))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, &ParseError{"job output", err}
+	}
+	if len(records) < 2 {
+		return []JobStatus{}, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	column := func(name string) int {
+		if i, ok := columns[name]; ok {
+			return i
+		}
+		return -1
+	}
+	nextRunColumn, retryColumn, enabledColumn := column("NextRun"), column("RetryPending"), column("IsEnabled")
+
+	var jobs []JobStatus
+	// Skip header line and process data lines
+	for _, fields := range records[1:] {
+		if len(fields) < 5 {
+			continue
+		}
+		job := JobStatus{
+			Name:        fields[0],
+			Status:      fields[1],
+			StartTime:   fields[2],
+			EndTime:     fields[3],
+			Description: fields[4],
+		}
+
+		// Add duration if available (for running and stale jobs)
+		if len(fields) >= 6 && nextRunColumn != 5 && retryColumn != 5 && enabledColumn != 5 {
+			job.Duration = invariantDecimal(strings.TrimSpace(fields[5]))
+		}
+
+		if nextRunColumn >= 0 && nextRunColumn < len(fields) {
+			job.NextRun = strings.TrimSpace(fields[nextRunColumn])
+			if job.NextRun == "" {
+				job.NextRun = notScheduled
+			}
+		}
+		if retryColumn >= 0 && retryColumn < len(fields) {
+			job.RetryPending = strings.EqualFold(strings.TrimSpace(fields[retryColumn]), "True")
+		}
+		if enabledColumn >= 0 && enabledColumn < len(fields) {
+			job.Disabled = strings.EqualFold(strings.TrimSpace(fields[enabledColumn]), "False")
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// Prepare PowerShell output for the CSV reader: drop a UTF-8 byte order
+// mark, normalize CRLF and CR line endings, and skip warning or progress
+// lines printed before the quoted header row ConvertTo-Csv writes
+func cleanCSVOutput(output string) string {
+	output = strings.TrimPrefix(output, "\ufeff")
+	output = strings.Replace(output, "\r\n", "\n", -1)
+	output = strings.Replace(output, "\r", "\n", -1)
+
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "\ufeff")
+		if strings.HasPrefix(line, "\"") {
+			lines[i] = line
+			return strings.TrimSpace(strings.Join(lines[i:], "\n"))
+		}
+	}
+	return ""
+}
+
+// Turn a number written with a decimal comma, as some Windows cultures do,
+// into the dot form strconv expects. Numbers already using a dot are kept.
+func invariantDecimal(number string) string {
+	if strings.Count(number, ",") == 1 && !strings.Contains(number, ".") {
+		return strings.Replace(number, ",", ".", 1)
+	}
+	return number
+}
+
+// PowerShell converting objects to CSV with the configured delimiter, so the
+// output doesn't depend on the Windows culture's list separator. Dates are
+// written as ISO 8601 with their UTC offset so they can be shown in Timezone.
+func convertToCsv(config *Config) string {
+	return fmt.Sprintf(`ForEach-Object { foreach ($property in $_.PSObject.Properties) { if ($property.Value -is [datetime]) { $property.Value = $property.Value.ToString("o") } }; $_ } | ConvertTo-Csv -NoTypeInformation -Delimiter '%s'`,
+		strings.Replace(string(config.csvDelimiter()), "'", "''", -1))
+}
@@ -0,0 +1,591 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Stands in for PowerShell, answering every script through a function
+type fakeRunner func(script string) (stdout, stderr string, err error)
+
+func (f fakeRunner) Run(script string) (string, string, error) { return f(script) }
+
+// Runner answering every script with the same output
+func staticRunner(stdout, stderr string, err error) fakeRunner {
+	return func(string) (string, string, error) { return stdout, stderr, err }
+}
+
+// CSV header of the Failed and Warning job queries
+const jobCSVHeader = `"Name","LastResult","LastStart","LastEnd","Description","NextRun","RetryPending"` + "\n"
+
+func TestJobsByStatusUnreachable(t *testing.T) {
+	tests := []struct {
+		name   string
+		runner fakeRunner
+	}{
+		{"connect failure", staticRunner(connectErrorMarker+" The RPC server is unavailable\n", "", errors.New("exit status 1"))},
+		{"connect failure with lost exit code", staticRunner(connectErrorMarker+" The RPC server is unavailable\n", "", nil)},
+		{"module failure", staticRunner(moduleErrorMarker+" Snap-in failed to load\n", "", errors.New("exit status 1"))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestMonitor(testConfig(), test.runner)
+			jobs, err := m.getJobsByStatus("Failed")
+			if !errors.Is(err, ErrVeeamUnreachable) {
+				t.Fatalf("got error %v, want ErrVeeamUnreachable", err)
+			}
+			if len(jobs) != 0 {
+				t.Errorf("got %d jobs from a failed query, want none", len(jobs))
+			}
+		})
+	}
+}
+
+func TestJobsByStatusEmptyResult(t *testing.T) {
+	for _, output := range []string{"", "\r\n", `"Name","LastResult","LastStart","LastEnd","Description","NextRun","RetryPending"` + "\r\n"} {
+		m := newTestMonitor(testConfig(), staticRunner(output, "", nil))
+		jobs, err := m.getJobsByStatus("Failed")
+		if err != nil {
+			t.Fatalf("output %q: unexpected error %v", output, err)
+		}
+		if len(jobs) != 0 {
+			t.Errorf("output %q: got %d jobs, want none", output, len(jobs))
+		}
+	}
+}
+
+func TestJobsByStatusOtherFailureIsNotUnreachable(t *testing.T) {
+	m := newTestMonitor(testConfig(), staticRunner("", "Get-VBRJob : Access is denied", errors.New("exit status 1")))
+	_, err := m.getJobsByStatus("Failed")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if errors.Is(err, ErrVeeamUnreachable) {
+		t.Errorf("access denied reported as unreachable: %v", err)
+	}
+}
+
+func TestVeeamConnectServer(t *testing.T) {
+	tests := []struct {
+		address, username, want string
+	}{
+		{"", "", ""},
+		{"veeam01", "", "veeam01"},
+		{"", "backup-admin", "localhost"},
+		{"veeam01", "backup-admin", "veeam01"},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.VeeamServerAddress, config.VeeamUsername = test.address, test.username
+		if got := config.veeamConnectServer(); got != test.want {
+			t.Errorf("address %q, username %q: got %q, want %q", test.address, test.username, got, test.want)
+		}
+	}
+}
+
+func TestVeeamScriptCredential(t *testing.T) {
+	config := testConfig()
+	config.VeeamServerAddress = "veeam01"
+	config.VeeamUsername = `CORP\o'neil`
+	config.VeeamPassword = "S3cret-pass"
+
+	script := veeamScript(config, "Get-VBRJob")
+	want := `Connect-VBRServer -Server 'veeam01' -ErrorAction Stop -Credential (New-Object System.Management.Automation.PSCredential('CORP\o''neil', (ConvertTo-SecureString $env:VEEAM_MONITOR_PASSWORD -AsPlainText -Force)))`
+	if !strings.Contains(script, want) {
+		t.Errorf("script doesn't connect with the credential:\n%s", script)
+	}
+	if strings.Contains(script, config.VeeamPassword) {
+		t.Errorf("script contains the password:\n%s", script)
+	}
+	if env := veeamScriptEnv(config); len(env) != 1 || env[veeamPasswordEnv] != config.VeeamPassword {
+		t.Errorf("got environment %v, want the password in %s", env, veeamPasswordEnv)
+	}
+	if runner := newCommandRunner(config).(PowerShellRunner); runner.Env[veeamPasswordEnv] != config.VeeamPassword {
+		t.Error("PowerShell runner doesn't pass the password in its environment")
+	}
+	config.Transport = "winrm"
+	if runner := newCommandRunner(config).(*WinRMRunner); runner.Env[veeamPasswordEnv] != config.VeeamPassword {
+		t.Error("WinRM runner doesn't pass the password in its environment")
+	}
+
+	config.VeeamUsername = ""
+	if script := veeamScript(config, "Get-VBRJob"); strings.Contains(script, "-Credential") || veeamScriptEnv(config) != nil {
+		t.Errorf("connected with a credential without a username:\n%s", script)
+	}
+}
+
+func TestVeeamPasswordNotLogged(t *testing.T) {
+	config := testConfig()
+	config.VeeamUsername = "backup-admin"
+	config.VeeamPassword = "S3cret-pass"
+	var scripts []string
+	m := newTestMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		scripts = append(scripts, script)
+		// An error echoing the password back
+		return connectErrorMarker + " Login failed for backup-admin with S3cret-pass\n",
+			"Connect-VBRServer : password S3cret-pass rejected", errors.New("exit status 1")
+	}))
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	_, err := m.getJobsByStatus("Failed")
+	log.SetOutput(os.Stderr)
+
+	if err == nil || !strings.Contains(err.Error(), "Login failed for backup-admin with ***") {
+		t.Errorf("got error %v, want the login failure with the password masked", err)
+	}
+	for _, text := range append(scripts, logged.String(), fmt.Sprint(err)) {
+		if strings.Contains(text, config.VeeamPassword) {
+			t.Errorf("password appears in %q", text)
+		}
+	}
+}
+
+// Runner answering each job type's cmdlet with a failed job of that type,
+// and failing scripts for any cmdlet not listed
+func jobTypeRunner(names map[string]string) fakeRunner {
+	return func(script string) (string, string, error) {
+		for cmdlet, name := range names {
+			if strings.Contains(script, cmdlet+" ") {
+				return jobCSVHeader + `"` + name + `","Failed","2024-03-01T01:00:00","2024-03-01T01:20:00","Disk full","",""` + "\n", "", nil
+			}
+		}
+		return "", "Get-VBRTapeJob : The term is not recognized", errors.New("exit status 1")
+	}
+}
+
+func TestJobsByStatusEachJobType(t *testing.T) {
+	config := testConfig()
+	config.MonitorJobTypes = []string{"backup", "copy", "tape", "agent"}
+	m := newTestMonitor(config, jobTypeRunner(map[string]string{
+		"Get-VBRJob":               "Nightly",
+		"Get-VBRBackupCopyJob":     "Offsite",
+		"Get-VBRTapeJob":           "Archive",
+		"Get-VBRComputerBackupJob": "Laptops",
+	}))
+
+	jobs, err := m.getJobsByStatus("Failed")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	got := map[string]string{}
+	for _, job := range jobs {
+		got[job.Name] = job.JobType
+	}
+	want := map[string]string{"Nightly": "Backup", "Offsite": "Backup Copy", "Archive": "Tape", "Laptops": "Agent"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got job types %v, want %v", got, want)
+	}
+}
+
+func TestJobsByStatusFailingJobTypeKeepsOthers(t *testing.T) {
+	config := testConfig()
+	config.MonitorJobTypes = []string{"backup", "tape"}
+	m := newTestMonitor(config, jobTypeRunner(map[string]string{"Get-VBRJob": "Nightly"}))
+
+	jobs, err := m.getJobsByStatus("Failed")
+	if err != nil {
+		t.Fatalf("one failing job type failed the query: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "Nightly" {
+		t.Errorf("got %+v, want the backup job", jobs)
+	}
+
+	config.MonitorJobTypes = []string{"tape"}
+	if _, err := m.getJobsByStatus("Failed"); err == nil {
+		t.Error("every job type failing gave no error")
+	}
+}
+
+func TestStaleJobs(t *testing.T) {
+	output := `"Name","Status","LastStart","LastEnd","Description","AgeHours","NextRun"` + "\n" +
+		`"Old","Stale","2024-03-01T01:00:00","2024-03-01T01:20:00","","30.5",""` + "\n" +
+		`"Recent","Stale","2024-03-02T05:00:00","2024-03-02T05:20:00","","2",""` + "\n" +
+		`"Boundary","Stale","2024-03-01T07:00:00","2024-03-01T07:20:00","","24",""` + "\n" +
+		`"Never","Stale","","","","-1",""` + "\n"
+	config := testConfig()
+	config.MaxJobAgeHours = 24
+	m := newTestMonitor(config, staticRunner(output, "", nil))
+
+	jobs, err := m.getStaleJobs()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].Name != "Old" || jobs[1].Name != "Never" {
+		t.Fatalf("got %+v, want Old and Never", jobs)
+	}
+	if jobs[0].Description != "Job hasn't run in 30 hours (threshold 24 hours)" || jobs[1].Description != "Job has never run" {
+		t.Errorf("got descriptions %q and %q", jobs[0].Description, jobs[1].Description)
+	}
+	if got := alertSeverity(config, jobs, nil); got != severityCritical {
+		t.Errorf("stale jobs: got severity %s, want %s", got, severityCritical)
+	}
+}
+
+func TestJobsByStatusPowerShellFailure(t *testing.T) {
+	// Rows written before the failure mustn't be taken as results
+	partial := jobCSVHeader + `"Nightly","Failed","2024-03-01T01:00:00","2024-03-01T01:20:00","Disk full","",""` + "\n"
+	tests := []struct {
+		stderr, want string
+	}{
+		{"Get-VBRJob : Access is denied.", "access denied, run the monitor as an account with Veeam administrator rights"},
+		{"File C:\\monitor.ps1 cannot be loaded because running scripts is disabled on this system.", "execution policy"},
+		{"Get-VBRJob : Unexpected error\r\nAt line:1 char:1", "PowerShell exited with an error (exit status 1): Get-VBRJob : Unexpected error"},
+		{"", "PowerShell exited with an error: exit status 1"},
+	}
+	for _, test := range tests {
+		m := newTestMonitor(testConfig(), staticRunner(partial, test.stderr, errors.New("exit status 1")))
+		jobs, err := m.getJobsByStatus("Failed")
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("stderr %q: got error %v, want %q", test.stderr, err, test.want)
+		}
+		if len(jobs) != 0 {
+			t.Errorf("stderr %q: got jobs %+v from a failed run", test.stderr, jobs)
+		}
+	}
+}
+
+func TestJobsByStatusStderrWithoutExitCode(t *testing.T) {
+	output := jobCSVHeader + `"Nightly","Failed","2024-03-01T01:00:00","2024-03-01T01:20:00","Disk full","",""` + "\n"
+	m := newTestMonitor(testConfig(), staticRunner(output, "WARNING: The cmdlet is deprecated", nil))
+	jobs, err := m.getJobsByStatus("Failed")
+	if err != nil {
+		t.Fatalf("warning on stderr failed the query: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "Nightly" {
+		t.Errorf("got %+v, want the failed job", jobs)
+	}
+}
+
+func TestParseJobStatusOutputNextRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{"status query", jobCSVHeader +
+			`"Nightly","Failed","3/1/2024 1:00:00 AM","3/1/2024 1:20:00 AM","Disk full","3/2/2024 1:00:00 AM","False"` + "\n" +
+			`"Chained","Failed","3/1/2024 2:00:00 AM","3/1/2024 2:20:00 AM","Disk full","","False"` + "\n",
+			[]string{"3/2/2024 1:00:00 AM", notScheduled}},
+		{"long-running query", `"Name","Status","StartTime","EndTime","Description","Duration","NextRun"` + "\n" +
+			`"Nightly","Running","3/1/2024 1:00:00 AM","N/A","Currently running","185.5","3/2/2024 1:00:00 AM"` + "\n" +
+			`"Manual","Running","3/1/2024 1:00:00 AM","N/A","Currently running","130.25",""` + "\n",
+			[]string{"3/2/2024 1:00:00 AM", notScheduled}},
+		{"no NextRun column", `"Name","LastResult","LastStart","LastEnd","Description"` + "\n" +
+			`"Nightly","Failed","3/1/2024 1:00:00 AM","3/1/2024 1:20:00 AM","Disk full"` + "\n",
+			[]string{""}},
+	}
+	for _, test := range tests {
+		jobs, err := parseJobStatusOutput(test.output, "Failed", ',')
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var got []string
+		for _, job := range jobs {
+			got = append(got, job.NextRun)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got next runs %q, want %q", test.name, got, test.want)
+		}
+		if test.name == "long-running query" && jobs[0].Duration != "185.5" {
+			t.Errorf("%s: got duration %q, want 185.5", test.name, jobs[0].Duration)
+		}
+	}
+}
+
+func TestParseJobStatusOutputRetryPending(t *testing.T) {
+	output := jobCSVHeader +
+		`"Nightly","Failed","3/1/2024 1:00:00 AM","3/1/2024 1:20:00 AM","Disk full","","True"` + "\n" +
+		`"Weekly","Failed","3/1/2024 2:00:00 AM","3/1/2024 2:20:00 AM","Disk full","","False"` + "\n" +
+		`"Files","Failed","3/1/2024 3:00:00 AM","3/1/2024 3:20:00 AM","Disk full","",""` + "\n"
+	jobs, err := parseJobStatusOutput(output, "Failed", ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"Nightly": true, "Weekly": false, "Files": false}
+	if len(jobs) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(jobs), len(want))
+	}
+	for _, job := range jobs {
+		if job.RetryPending != want[job.Name] {
+			t.Errorf("%s: got retry pending %v, want %v", job.Name, job.RetryPending, want[job.Name])
+		}
+	}
+
+	if got := displayStatus(jobs[0]); got != "Failed (retry pending)" {
+		t.Errorf("got status %q for a job Veeam will retry", got)
+	}
+	if got := displayStatus(jobs[1]); got != "Failed" {
+		t.Errorf("got status %q for a job out of retries", got)
+	}
+}
+
+func TestNextRunInAlerts(t *testing.T) {
+	jobs := []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", NextRun: "3/2/2024 1:00:00 AM"},
+		{Name: "Chained", JobType: "Backup", Status: "Failed", NextRun: notScheduled},
+	}
+	for _, include := range []bool{true, false} {
+		config := testConfig()
+		config.IncludeNextRun = include
+		_, body := buildEmailBody(NewAlertReport(jobs, nil, severityCritical, config, time.Now()), config)
+		for _, line := range []string{"Next Run: 3/2/2024 1:00:00 AM\n", "Next Run: not scheduled\n"} {
+			if strings.Contains(body, line) != include {
+				t.Errorf("includeNextRun %v: body has %q %v:\n%s", include, line, !include, body)
+			}
+		}
+	}
+}
+
+func TestSemicolonDelimitedOutput(t *testing.T) {
+	config := testConfig()
+	config.CSVDelimiter = ";"
+	var scripts []string
+	m := newTestMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		scripts = append(scripts, script)
+		return `"Name";"LastResult";"LastStart";"LastEnd";"Description";"NextRun";"RetryPending"` + "\r\n" +
+			`"Nightly";"Failed";"2024-03-01T01:00:00";"2024-03-01T01:20:00";"Disk full, 0,5 GB free";"";"False"` + "\r\n", "", nil
+	}))
+
+	jobs, err := m.getJobsByStatus("Failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "Nightly" || jobs[0].Description != "Disk full, 0,5 GB free" {
+		t.Errorf("got %+v, want Nightly with its whole description", jobs)
+	}
+	if len(scripts) == 0 || !strings.Contains(scripts[0], "ConvertTo-Csv -NoTypeInformation -Delimiter ';'") {
+		t.Errorf("script doesn't ask for semicolons: %q", scripts)
+	}
+}
+
+func TestCommaDecimalDurations(t *testing.T) {
+	output := `"Name";"Status";"StartTime";"EndTime";"Description";"Duration";"NextRun"` + "\n" +
+		`"Nightly";"Running";"3/1/2024 1:00:00 AM";"N/A";"Currently running";"185,5";""` + "\n" +
+		`"Files";"Running";"3/1/2024 1:00:00 AM";"N/A";"Currently running";"130.25";""` + "\n"
+	jobs, err := parseJobStatusOutput(output, "Running", ';')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, job := range jobs {
+		got = append(got, job.Duration)
+	}
+	if want := []string{"185.5", "130.25"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got durations %q, want %q", got, want)
+	}
+}
+
+func TestCSVDelimiterRune(t *testing.T) {
+	for value, want := range map[string]rune{"": ',', ",": ',', ";": ';', "\t": '\t', "|": '|'} {
+		if got, err := csvDelimiterRune(value); err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", value, got, err, want)
+		}
+	}
+	for _, value := range []string{";;", `"`, "\n", "\r"} {
+		if _, err := csvDelimiterRune(value); err == nil {
+			t.Errorf("%q accepted as a delimiter", value)
+		}
+	}
+}
+
+func TestParseJobStatusOutputCleansPowerShellOutput(t *testing.T) {
+	rows := `"Name","LastResult","LastStart","LastEnd","Description"` + "\n" +
+		`"Nightly","Failed","3/1/2024 1:00:00 AM","3/1/2024 1:20:00 AM","Disk full"` + "\n" +
+		`"Weekly","Failed","3/2/2024 1:00:00 AM","3/2/2024 1:20:00 AM","Line one` + "\n" + `line two"` + "\n"
+	preamble := "WARNING: The names of some imported commands from the module 'Veeam.Backup.PowerShell' include unapproved verbs.\n" +
+		"Loading Veeam snap-in... 100%\n\n"
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"plain", rows},
+		{"BOM", "\ufeff" + rows},
+		{"CRLF", strings.Replace(rows, "\n", "\r\n", -1)},
+		{"CR", strings.Replace(rows, "\n", "\r", -1)},
+		{"BOM and CRLF", "\ufeff" + strings.Replace(rows, "\n", "\r\n", -1)},
+		{"preamble", preamble + rows},
+		{"BOM after preamble", strings.Replace(preamble, "\n", "\r\n", -1) + "\ufeff" + strings.Replace(rows, "\n", "\r\n", -1) + "\r\n\r\n"},
+	}
+	want := []JobStatus{
+		{Name: "Nightly", Status: "Failed", StartTime: "3/1/2024 1:00:00 AM", EndTime: "3/1/2024 1:20:00 AM", Description: "Disk full"},
+		{Name: "Weekly", Status: "Failed", StartTime: "3/2/2024 1:00:00 AM", EndTime: "3/2/2024 1:20:00 AM", Description: "Line one\nline two"},
+	}
+	for _, test := range tests {
+		jobs, err := parseJobStatusOutput(test.output, "Failed", ',')
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, jobs, want)
+		}
+	}
+
+	if jobs, err := parseJobStatusOutput(preamble, "Failed", ','); err != nil || len(jobs) != 0 {
+		t.Errorf("preamble alone: got %+v, %v, want no jobs", jobs, err)
+	}
+}
+
+func TestLongRunningGraceInQuery(t *testing.T) {
+	config := testConfig()
+	config.LongRunningThreshold = 240
+	config.LongRunningGraceMinutes = 10
+	var scripts []string
+	m := newTestMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		scripts = append(scripts, script)
+		return `"Name","Status","StartTime","EndTime","Description","Duration","NextRun"` + "\n" +
+			`"Offsite","Running","3/1/2024 1:00:00 AM","N/A","Currently running","251.5",""` + "\n", "", nil
+	}))
+
+	jobs, err := m.getLongRunningJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 || !strings.Contains(scripts[0], "$_.Duration -gt 250}") || !strings.Contains(scripts[0], "((Get-Date) - $_.SessionStart)") {
+		t.Errorf("the query doesn't measure on the server against the threshold plus grace period:\n%s", scripts[0])
+	}
+	if len(jobs) == 0 || jobs[0].Description != "Long-running job (over 240 minutes): Currently running" {
+		t.Errorf("got jobs %+v, want the configured threshold in the description", jobs)
+	}
+}
+
+func TestQueryErrorTypes(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		name   string
+		runner fakeRunner
+		query  func(m *Monitor) error
+		want   error
+		kind   string
+	}{
+		{"server unreachable", staticRunner(connectErrorMarker+" No connection could be made\n", "", exit), nil, ErrVeeamUnreachable, "unreachable"},
+		{"module failed to load", staticRunner(moduleErrorMarker+" Import-Module : Access is denied\n", "", exit), nil, ErrVeeamUnreachable, "unreachable"},
+		{"module not installed", staticRunner(moduleErrorMarker+" No snap-ins have been registered for Windows PowerShell version 5.\n", "", exit), nil, ErrModuleNotFound, "module"},
+		{"powershell missing", staticRunner("", "", &exec.Error{Name: "powershell.exe", Err: exec.ErrNotFound}), nil, ErrFatalEnvironment, "environment"},
+		{"timeout", staticRunner("", "", fmt.Errorf("%w after 5m0s", ErrPowerShellTimeout)), nil, ErrPowerShellTimeout, "timeout"},
+		{"bad repository size", staticRunner(`"Name","ScaleOut","TotalBytes","FreeBytes"`+"\n"+`"Main","","lots","5"`+"\n", "", nil), func(m *Monitor) error {
+			_, err := m.getRepositoryStatuses()
+			return err
+		}, ErrParseFailure, "parse"},
+		{"bad schedule time", staticRunner(`"Name","Scheduled","LastStart"`+"\n"+`"Nightly","True","yesterday"`+"\n", "", nil), func(m *Monitor) error {
+			_, err := m.getJobSchedules()
+			return err
+		}, ErrParseFailure, "parse"},
+		{"other failure", staticRunner("", "Get-VBRJob : Unexpected error", exit), nil, nil, "other"},
+	}
+	for _, test := range tests {
+		m := newTestMonitor(testConfig(), test.runner)
+		query := test.query
+		if query == nil {
+			query = func(m *Monitor) error {
+				_, err := m.getJobsByStatus("Failed")
+				return err
+			}
+		}
+		err := query(m)
+		if err == nil {
+			t.Errorf("%s: no error", test.name)
+			continue
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v, want it to match %v", test.name, err, test.want)
+		}
+		if kind := queryErrorKind(err); kind != test.kind {
+			t.Errorf("%s: got kind %s, want %s: %v", test.name, kind, test.kind, err)
+		}
+		var parseErr *ParseError
+		if parse := errors.As(err, &parseErr); parse != (test.kind == "parse") {
+			t.Errorf("%s: errors.As ParseError is %v: %v", test.name, parse, err)
+		}
+		if test.kind == "parse" && !strings.Contains(err.Error(), "error parsing ") {
+			t.Errorf("%s: got message %q", test.name, err)
+		}
+	}
+}
+
+// Runner answering the Failed query with a failed backup job and the latest
+// SureBackup sessions as surebackup, or stderr when the cmdlets are absent
+func sureBackupRunner(surebackup, stderr string) fakeRunner {
+	return func(script string) (string, string, error) {
+		if strings.Contains(script, "Get-VBRSureBackupSession") {
+			return surebackup, stderr, nil
+		}
+		return jobCSVHeader + `"Nightly","Failed","3/1/2024 1:00:00 AM","3/1/2024 1:20:00 AM","Disk full","",""` + "\n", "", nil
+	}
+}
+
+func TestSureBackupSessions(t *testing.T) {
+	config := testConfig()
+	config.MonitorJobTypes = []string{"backup", "surebackup"}
+	sessions := `"Name","LastResult","LastStart","LastEnd","Description","IsRunning","SessionStart","IsEnabled","NextRun","RetryPending"` + "\n" +
+		`"Verify SQL","Failed","3/1/2024 4:00:00 AM","3/1/2024 4:35:00 AM","Heartbeat test failed for SQL01","False","3/1/2024 4:00:00 AM","True","3/2/2024 4:00:00 AM","False"` + "\n" +
+		`"Verify Files","Success","3/1/2024 5:00:00 AM","3/1/2024 5:20:00 AM","","False","3/1/2024 5:00:00 AM","True","3/2/2024 5:00:00 AM","False"` + "\n"
+	m := newTestMonitor(config, sureBackupRunner(`"Name","LastResult","LastStart","LastEnd","Description","NextRun","RetryPending"`+"\n"+
+		`"Verify SQL","Failed","3/1/2024 4:00:00 AM","3/1/2024 4:35:00 AM","Heartbeat test failed for SQL01","3/2/2024 4:00:00 AM","False"`+"\n", ""))
+
+	jobs, err := m.getJobsByStatus("Failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].JobType != "Backup" {
+		t.Fatalf("got %+v, want the failed backup and verification", jobs)
+	}
+	if job := jobs[1]; job.Name != "Verify SQL" || job.JobType != "SureBackup" || job.Description != "Heartbeat test failed for SQL01" || job.NextRun == notScheduled {
+		t.Errorf("got %+v, want the failed verification as a SureBackup job", job)
+	}
+
+	// The full session listing, as the inventory reads it
+	parsed, err := parseJobStatusOutput(sessions, "", ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 2 || parsed[0].Status != "Failed" || parsed[1].Status != "Success" || parsed[0].Disabled || parsed[0].RetryPending {
+		t.Errorf("got %+v", parsed)
+	}
+}
+
+func TestSureBackupCmdletsAbsent(t *testing.T) {
+	logged := captureLogWriter(t, testConfig())
+	config := testConfig()
+	config.MonitorJobTypes = []string{"backup", "surebackup"}
+	m := newTestMonitor(config, sureBackupRunner("", "SureBackup cmdlets not available, Veeam 12 or later is needed to monitor SureBackup jobs\n"))
+
+	jobs, err := m.getJobsByStatus("Failed")
+	if err != nil {
+		t.Fatalf("got error %v without the SureBackup cmdlets", err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "Nightly" {
+		t.Errorf("got %+v, want the backup job still reported", jobs)
+	}
+	if !strings.Contains(logged.String(), "Warning: PowerShell reported errors: SureBackup cmdlets not available") {
+		t.Errorf("missing cmdlets not logged:\n%s", logged.String())
+	}
+}
+
+func TestPowerShellRunnerStoppedByContext(t *testing.T) {
+	path, dir := writeScript(t, "exec sleep 30\n")
+	if err := os.Rename(path, filepath.Join(dir, "powershell")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := PowerShellRunner{Timeout: time.Minute}.RunContext(ctx, "Get-VBRJob")
+	if err == nil || errors.Is(err, ErrPowerShellTimeout) || time.Since(start) > 5*time.Second {
+		t.Errorf("got error %v after %v, want powershell killed when the context ends", err, time.Since(start))
+	}
+}
@@ -0,0 +1,112 @@
+package veeammonitor
+
+import (
+	"sync"
+	"time"
+)
+
+// Caps the number of notifications sent across all channels within a
+// sliding window. Excess sends are dropped and remembered, for a single
+// summary once the window has room again.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int // 0 disables limiting
+	window  time.Duration
+	sent    []time.Time
+	dropped []droppedNotification // Since the last summary
+	now     func() time.Time
+}
+
+// Notification dropped by the rate limit
+type droppedNotification struct {
+	channel string
+	at      time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, now: time.Now}
+}
+
+// Report whether a notification on channel may be sent now, remembering it
+// as dropped when it may not
+func (l *rateLimiter) Allow(channel string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit <= 0 {
+		return true
+	}
+	now := l.now()
+	if !l.reserve(now) {
+		l.dropped = append(l.dropped, droppedNotification{channel, now})
+		return false
+	}
+	return true
+}
+
+// Take the notifications dropped since the last summary when the window has
+// room to send one, counting the summary against the limit. Returns nothing
+// while the window is still full, so the summary waits rather than being
+// dropped itself.
+func (l *rateLimiter) TakeDropped() []droppedNotification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.dropped) == 0 || !l.reserve(l.now()) {
+		return nil
+	}
+	dropped := l.dropped
+	l.dropped = nil
+	return dropped
+}
+
+// Record a send at now if the window has room for it. The caller holds mu.
+func (l *rateLimiter) reserve(now time.Time) bool {
+	// Forget sends that have left the window
+	kept := l.sent[:0]
+	for _, t := range l.sent {
+		if now.Sub(t) < l.window {
+			kept = append(kept, t)
+		}
+	}
+	l.sent = kept
+
+	if len(l.sent) >= l.limit {
+		return false
+	}
+	l.sent = append(l.sent, now)
+	return true
+}
+
+// Check the pause and the global rate limit before sending a notification on
+// a channel, logging when it is suppressed
+func (m *Monitor) allowNotification(channel string) bool {
+	if pause := m.pauseState(time.Now()); pause.Paused {
+		m.logf("Notifications are paused %s, not sending %s notification\n", pause.reason(m.Config), channel)
+		return false
+	}
+	if !m.limiter.Allow(channel) {
+		m.logf("Notification rate limit reached (%d per %d minutes), suppressing %s notification\n",
+			m.Config.MaxNotificationsPerHour, m.Config.NotificationWindowMinutes, channel)
+		return false
+	}
+	return true
+}
+
+// Send one summary of the notifications the rate limit dropped, once the
+// window has room for it. Nothing is sent while notifications are paused, and
+// a summary no channel takes is only logged; either way it isn't retried.
+func (m *Monitor) sendSuppressedSummary() {
+	if m.pauseState(time.Now()).Paused {
+		return
+	}
+	dropped := m.limiter.TakeDropped()
+	if len(dropped) == 0 {
+		return
+	}
+	if !m.sendNotice(suppressedNotice(m.Config, dropped)) {
+		m.logf("%d notifications were suppressed by the rate limit, and no summary of them could be sent\n", len(dropped))
+		return
+	}
+	m.logf("Sent summary of %d notifications suppressed by the rate limit\n", len(dropped))
+}
@@ -0,0 +1,146 @@
+package veeammonitor
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Rate limiter reading the time from clock
+func newTestRateLimiter(limit int, clock *time.Time) *rateLimiter {
+	limiter := newRateLimiter(limit, time.Hour)
+	limiter.now = func() time.Time { return *clock }
+	return limiter
+}
+
+func TestRateLimiterSummarizesDropped(t *testing.T) {
+	clock := time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC)
+	limiter := newTestRateLimiter(2, &clock)
+
+	if !limiter.Allow("email") || !limiter.Allow("slack") {
+		t.Fatal("notifications within the limit were dropped")
+	}
+	if limiter.Allow("slack") || limiter.Allow("teams") {
+		t.Fatal("notifications over the limit were allowed")
+	}
+	if dropped := limiter.TakeDropped(); dropped != nil {
+		t.Fatalf("got summary of %v while the window is full, want none", dropped)
+	}
+
+	clock = clock.Add(time.Hour)
+	dropped := limiter.TakeDropped()
+	if len(dropped) != 2 || dropped[0].channel != "slack" || dropped[1].channel != "teams" {
+		t.Fatalf("got dropped %+v, want slack and teams", dropped)
+	}
+	if dropped := limiter.TakeDropped(); dropped != nil {
+		t.Errorf("dropped notifications summarized twice: %v", dropped)
+	}
+
+	// The summary used one of the two sends in the new window
+	if !limiter.Allow("email") {
+		t.Error("notification after the summary was dropped")
+	}
+	if limiter.Allow("email") {
+		t.Error("summary wasn't counted against the limit")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	clock := time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC)
+	limiter := newTestRateLimiter(0, &clock)
+	for i := 0; i < 100; i++ {
+		if !limiter.Allow("email") {
+			t.Fatalf("notification %d dropped with limiting disabled", i)
+		}
+	}
+	if dropped := limiter.TakeDropped(); dropped != nil {
+		t.Errorf("got dropped %v with limiting disabled", dropped)
+	}
+}
+
+func TestNewMonitorLimitsConcurrentNotifications(t *testing.T) {
+	config := testConfig()
+	config.MaxNotificationsPerHour = 5
+	m := NewMonitor(config)
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.allowNotification("email") {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 5 {
+		t.Errorf("allowed %d notifications, want 5", allowed)
+	}
+	if dropped := len(m.limiter.dropped); dropped != 15 {
+		t.Errorf("remembered %d dropped notifications, want 15", dropped)
+	}
+}
+
+func TestSuppressedNotice(t *testing.T) {
+	config := testConfig()
+	config.MaxNotificationsPerHour = 10
+	config.Timezone = "UTC"
+	at := time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC)
+	dropped := []droppedNotification{
+		{"slack", at},
+		{"email", at.Add(time.Minute)},
+		{"slack", at.Add(20 * time.Minute)},
+	}
+
+	notice := suppressedNotice(config, dropped)
+	subject, body := notice.Subject, notice.Body
+	if !strings.Contains(subject, "3 Veeam notifications suppressed") || notice.Severity != severityWarning {
+		t.Errorf("got subject %q with severity %s", subject, notice.Severity)
+	}
+	for _, want := range []string{
+		"rate limit of 10 per 60 minutes",
+		"slack: 2 notifications between 3/1/2024 1:00:00 AM and 3/1/2024 1:20:00 AM\n",
+		"email: 1 notification at 3/1/2024 1:01:00 AM\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Index(body, "slack:") > strings.Index(body, "email:") {
+		t.Error("channels aren't in the order they first lost a notification")
+	}
+}
+
+func TestSuppressedSummarySentThroughNotifiers(t *testing.T) {
+	clock := time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC)
+	config := testConfig()
+	config.MaxNotificationsPerHour = 1
+	m := newTestMonitor(config, nil)
+	m.limiter = newTestRateLimiter(1, &clock)
+	chat := &captureNoticeNotifier{captureNotifier: captureNotifier{name: "chat"}}
+	m.Notifiers = []Notifier{chat}
+
+	m.sendNotice(unreachableNotice(config, ErrVeeamUnreachable))
+	m.sendNotice(reachableNotice(config))
+	clock = clock.Add(time.Hour)
+	m.sendSuppressedSummary()
+
+	notices := chat.sentNotices()
+	if len(notices) != 2 || notices[1].Event != "suppressed" || !strings.Contains(notices[1].Body, "chat: 1 notification at") {
+		t.Fatalf("got notices %+v, want the unreachable notice and a summary of the dropped one", notices)
+	}
+	// Email isn't configured, so only the channel that sent counts
+	if sent := m.metrics.notifications; len(sent) != 1 || sent["chat"] != 2 {
+		t.Errorf("got %v notifications sent, want both on chat", sent)
+	}
+	if dropped := m.limiter.dropped; len(dropped) != 0 {
+		t.Errorf("the summary was itself dropped: %v", dropped)
+	}
+}
@@ -0,0 +1,85 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+)
+
+// Replacement for secret values in printed output
+const redactedValue = "***"
+
+// String returns the config as JSON with every secret field masked, so it
+// is safe to log
+func (c *Config) String() string {
+	data, err := json.Marshal(c.Redacted())
+	if err != nil {
+		return "<invalid config>"
+	}
+	return string(data)
+}
+
+// Redacted returns a copy of the config with every field tagged
+// secret:"true" that is set replaced by "***"
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redactFields(reflect.ValueOf(&redacted).Elem())
+	if c.Servers != nil {
+		redacted.Servers = make([]ServerConfig, len(c.Servers))
+		for i, server := range c.Servers {
+			redactFields(reflect.ValueOf(&server).Elem())
+			redacted.Servers[i] = server
+		}
+	}
+	if c.Webhooks != nil {
+		redacted.Webhooks = make([]Webhook, len(c.Webhooks))
+		for i, webhook := range c.Webhooks {
+			redactFields(reflect.ValueOf(&webhook).Elem())
+			// Headers usually carry credentials
+			if webhook.Headers != nil {
+				headers := map[string]string{}
+				for name := range webhook.Headers {
+					headers[name] = redactedValue
+				}
+				webhook.Headers = headers
+			}
+			redacted.Webhooks[i] = webhook
+		}
+	}
+	return &redacted
+}
+
+// Mask the set secret:"true" fields of a struct
+func redactFields(value reflect.Value) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("secret") != "true" || field.Type.Kind() != reflect.String {
+			continue
+		}
+		if value.Field(i).String() != "" {
+			value.Field(i).SetString(redactedValue)
+		}
+	}
+}
+
+// Strip the path and query from the URL of a failed HTTP request, since
+// webhook URLs carry their token there. Other errors are returned unchanged.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	redacted := *urlErr
+	redacted.URL = redactURL(urlErr.URL)
+	return &redacted
+}
+
+// URL reduced to its scheme and host
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return redactedValue
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/" + redactedValue
+}
@@ -0,0 +1,64 @@
+package veeammonitor
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Set every secret:"true" field of a struct to a value naming it, returning the values
+func fillSecrets(value reflect.Value, prefix string) []string {
+	var secrets []string
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+			secret := prefix + "-" + field.Name + "-s3cr3t"
+			value.Field(i).SetString(secret)
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+func TestConfigStringMasksSecrets(t *testing.T) {
+	config := testConfig()
+	config.SMTPServer = "smtp.example.com"
+	secrets := fillSecrets(reflect.ValueOf(config).Elem(), "config")
+	config.Servers = []ServerConfig{{Name: "veeam02"}}
+	secrets = append(secrets, fillSecrets(reflect.ValueOf(&config.Servers[0]).Elem(), "server")...)
+	config.Webhooks = []Webhook{{Name: "ops", Headers: map[string]string{"Authorization": "Bearer header-s3cr3t"}}}
+	secrets = append(secrets, fillSecrets(reflect.ValueOf(&config.Webhooks[0]).Elem(), "webhook")...)
+	secrets = append(secrets, "Bearer header-s3cr3t")
+	if len(secrets) < 10 {
+		t.Fatalf("only %d secret fields found", len(secrets))
+	}
+
+	for _, dump := range []string{config.String(), fmt.Sprintf("%v", config), fmt.Sprintf("%+v", config), fmt.Sprint(config.Redacted())} {
+		for _, secret := range secrets {
+			if strings.Contains(dump, secret) {
+				t.Errorf("config dump shows %s:\n%s", secret, dump)
+			}
+		}
+		if !strings.Contains(dump, redactedValue) || !strings.Contains(dump, "smtp.example.com") {
+			t.Errorf("config dump doesn't mask secrets and keep other settings:\n%s", dump)
+		}
+	}
+	if !strings.HasPrefix(config.EmailPassword, "config-") || !strings.HasPrefix(config.Servers[0].VeeamPassword, "server-") ||
+		config.Webhooks[0].Headers["Authorization"] != "Bearer header-s3cr3t" {
+		t.Error("redacting changed the config itself")
+	}
+}
+
+func TestRedactURLError(t *testing.T) {
+	err := redactURLError(&url.Error{Op: "Post", URL: "https://hooks.slack.com/services/T000/B000/token-s3cr3t?x=1", Err: errors.New("timeout")})
+	if strings.Contains(err.Error(), "s3cr3t") || !strings.Contains(err.Error(), "https://hooks.slack.com/") {
+		t.Errorf("got %v, want the URL reduced to its host", err)
+	}
+	other := errors.New("token-s3cr3t")
+	if redactURLError(other) != other {
+		t.Error("changed an error without a URL")
+	}
+}
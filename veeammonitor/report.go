@@ -0,0 +1,431 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertReport is the canonical summary of the problems found in a
+// monitoring cycle. It is built once per alert and every notification
+// channel renders from it, so all channels agree on grouping, counts and
+// severity.
+type AlertReport struct {
+	Server       string             `json:"server"`
+	Severity     string             `json:"severity"`
+	Timestamp    time.Time          `json:"timestamp"`
+	CycleID      string             `json:"cycleId,omitempty"`     // Correlation ID of the check cycle, also in its log lines
+	Fingerprint  string             `json:"fingerprint,omitempty"` // Hash of the problems reported, the same for as long as they don't change
+	Counts       AlertCounts        `json:"counts"`
+	Failed       []JobStatus        `json:"failed"`
+	Warning      []JobStatus        `json:"warning"`
+	Stuck        []JobStatus        `json:"stuck"`            // Running sessions that stopped making progress
+	Disabled     []JobStatus        `json:"disabled"`         // CriticalJobs that are disabled
+	Missing      []JobStatus        `json:"missing"`          // ExpectedJobs that don't exist
+	Running      []JobStatus        `json:"running"`          // Long-running jobs
+	Stale        []JobStatus        `json:"stale"`            // Jobs that haven't run within MaxJobAgeHours
+	Deviation    []JobStatus        `json:"deviation"`        // Jobs whose backup size deviates from their baseline
+	Drift        []JobStatus        `json:"drift"`            // Jobs whose last run started far from its scheduled time
+	Repositories []RepositoryStatus `json:"repositories"`     // Repositories low on free space
+	Groups       []JobGroup         `json:"groups,omitempty"` // Jobs by GroupMapping group, when configured
+	Causes       []FailureCause     `json:"causes,omitempty"` // Failure reasons shared by AggregateFailuresMinJobs jobs or more
+	Client       string             `json:"client,omitempty"` // ClientMapping client the report is limited to
+
+	RepositoryThresholdPercent int `json:"repositoryThresholdPercent"`
+
+	omitted     AlertCounts // Jobs left out of a truncated report, by status
+	omittedHint string      // Where the omitted jobs can be found
+}
+
+// AlertCounts holds the number of problems of each kind in an AlertReport
+type AlertCounts struct {
+	Total        int `json:"total"` // Problematic jobs
+	Failed       int `json:"failed"`
+	Warning      int `json:"warning"`
+	Stuck        int `json:"stuck"`
+	Disabled     int `json:"disabled"`
+	Missing      int `json:"missing"`
+	Running      int `json:"running"`
+	Stale        int `json:"stale"`
+	Deviation    int `json:"deviation"`
+	Drift        int `json:"drift"`
+	Repositories int `json:"repositories"`
+}
+
+// NewAlertReport groups problematic jobs by status into a report
+func NewAlertReport(problematicJobs []JobStatus, lowSpaceRepos []RepositoryStatus, severity string, config *Config, now time.Time) *AlertReport {
+	report := &AlertReport{
+		Server:       serverDisplayName(config),
+		Severity:     severity,
+		Timestamp:    now.In(config.location()),
+		Repositories: lowSpaceRepos,
+
+		RepositoryThresholdPercent: config.RepositoryFreeSpaceThresholdPercent,
+	}
+
+	for _, job := range problematicJobs {
+		report.addJob(job)
+	}
+	report.Counts = report.jobCounts()
+	report.Counts.Repositories = len(lowSpaceRepos)
+	if len(config.GroupMapping) > 0 {
+		report.Groups = groupJobs(report.Jobs(), config)
+	}
+	report.Causes = failureCauses(report.Jobs(), config.AggregateFailuresMinJobs)
+	report.Fingerprint = alertFingerprint(problematicJobs, lowSpaceRepos)
+	return report
+}
+
+// Add a job to the list for its status
+func (r *AlertReport) addJob(job JobStatus) {
+	switch job.Status {
+	case "Failed":
+		r.Failed = append(r.Failed, job)
+	case "Warning":
+		r.Warning = append(r.Warning, job)
+	case stuckStatus:
+		r.Stuck = append(r.Stuck, job)
+	case disabledStatus:
+		r.Disabled = append(r.Disabled, job)
+	case missingStatus:
+		r.Missing = append(r.Missing, job)
+	case "Running":
+		r.Running = append(r.Running, job)
+	case "Stale":
+		r.Stale = append(r.Stale, job)
+	case deviationStatus:
+		r.Deviation = append(r.Deviation, job)
+	case driftStatus:
+		r.Drift = append(r.Drift, job)
+	}
+}
+
+// Count the report's jobs by status
+func (r *AlertReport) jobCounts() AlertCounts {
+	counts := AlertCounts{
+		Failed:    len(r.Failed),
+		Warning:   len(r.Warning),
+		Stuck:     len(r.Stuck),
+		Disabled:  len(r.Disabled),
+		Missing:   len(r.Missing),
+		Running:   len(r.Running),
+		Stale:     len(r.Stale),
+		Deviation: len(r.Deviation),
+		Drift:     len(r.Drift),
+	}
+	counts.Total = counts.Failed + counts.Warning + counts.Stuck + counts.Disabled + counts.Missing + counts.Running + counts.Stale + counts.Deviation + counts.Drift
+	return counts
+}
+
+// Jobs returns every job in the report, grouped failed, warning, stuck,
+// disabled, missing, running, stale, deviation then drift
+func (r *AlertReport) Jobs() []JobStatus {
+	var jobs []JobStatus
+	jobs = append(jobs, r.Failed...)
+	jobs = append(jobs, r.Warning...)
+	jobs = append(jobs, r.Stuck...)
+	jobs = append(jobs, r.Disabled...)
+	jobs = append(jobs, r.Missing...)
+	jobs = append(jobs, r.Running...)
+	jobs = append(jobs, r.Stale...)
+	jobs = append(jobs, r.Deviation...)
+	jobs = append(jobs, r.Drift...)
+	return jobs
+}
+
+// Jobs of one status in an alert, with how many a truncated report left out
+type statusSection struct {
+	status, title string
+	label         string // Status as worded in omitted lines, e.g. "long-running"
+	jobs          []JobStatus
+	omitted       int
+}
+
+// The report's jobs by status in the order Jobs lists them, for chat
+// channels that show each status apart. Statuses without jobs are left out.
+func (r *AlertReport) statusSections() []statusSection {
+	var sections []statusSection
+	for _, section := range []statusSection{
+		{"Failed", "Failed jobs", "failed", r.Failed, r.omitted.Failed},
+		{"Warning", "Warning jobs", "warning", r.Warning, r.omitted.Warning},
+		{stuckStatus, "Stuck sessions", "stuck", r.Stuck, r.omitted.Stuck},
+		{disabledStatus, "Disabled critical jobs", "disabled critical", r.Disabled, r.omitted.Disabled},
+		{missingStatus, "Missing expected jobs", "missing expected", r.Missing, r.omitted.Missing},
+		{"Running", "Long-running jobs", "long-running", r.Running, r.omitted.Running},
+		{"Stale", "Jobs that haven't run", "stale", r.Stale, r.omitted.Stale},
+		{deviationStatus, "Backup size deviations", "size deviation", r.Deviation, r.omitted.Deviation},
+		{driftStatus, "Schedule drift", "schedule drift", r.Drift, r.omitted.Drift},
+	} {
+		if len(section.jobs)+section.omitted > 0 {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// Notifier delivers an alert report through one channel
+type Notifier interface {
+	Name() string // Channel name used in logs, rate limiting and NotificationRouting
+	// Send a report. ctx ends after NotificationTimeoutSeconds; notifiers
+	// should give up and return an error when it does.
+	Notify(ctx context.Context, report *AlertReport) error
+}
+
+// Sends alert emails
+type emailNotifier struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+func (n emailNotifier) Name() string { return "email" }
+func (n emailNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	return sendTemplatedEmailAlert(ctx, n.httpClient, report, n.config.forChannel("email"), n.config.channelTemplate("email"))
+}
+
+// Posts alerts to the Discord webhook
+type discordNotifier struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+func (n discordNotifier) Name() string { return "discord" }
+func (n discordNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	return sendDiscordAlert(ctx, n.httpClient, report, n.config.forChannel("discord"))
+}
+
+// Posts alerts to the Slack webhook
+type slackNotifier struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+func (n slackNotifier) Name() string { return "slack" }
+func (n slackNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	return sendSlackAlert(ctx, n.httpClient, report, n.config.forChannel("slack"))
+}
+
+// Posts alerts to the Teams webhook
+type teamsNotifier struct {
+	config     *Config
+	httpClient *http.Client
+}
+
+func (n teamsNotifier) Name() string { return "teams" }
+func (n teamsNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	return sendTeamsAlert(ctx, n.httpClient, report, n.config.forChannel("teams"))
+}
+
+// Runs OnAlertCommand
+type commandNotifier struct{ config *Config }
+
+func (n commandNotifier) Name() string { return "command" }
+func (n commandNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	return runAlertCommand(ctx, report, n.config)
+}
+
+// Channels alerts are sent through: the configured built-in ones followed
+// by any added to Monitor.Notifiers
+func (m *Monitor) notifiers() []Notifier {
+	config := m.Config
+	var notifiers []Notifier
+	if config.SESEnabled {
+		notifiers = append(notifiers, sesNotifier{config, m.httpClient})
+	} else if config.EmailFrom != "" && len(config.EmailTo) > 0 && config.SMTPServer != "" {
+		notifiers = append(notifiers, emailNotifier{config, m.httpClient})
+	}
+	for _, audience := range config.EmailAudiences {
+		notifiers = append(notifiers, emailAudienceNotifier{config, m.httpClient, audience})
+	}
+	notifiers = append(notifiers, clientNotifiers(config, m.httpClient)...)
+	if config.DiscordWebhookURL != "" {
+		notifiers = append(notifiers, discordNotifier{config, m.httpClient})
+	}
+	if config.SlackWebhookURL != "" {
+		notifiers = append(notifiers, slackNotifier{config, m.httpClient})
+	}
+	if config.TeamsWebhookURL != "" {
+		notifiers = append(notifiers, teamsNotifier{config, m.httpClient})
+	}
+	if config.SNSTopicARN != "" {
+		notifiers = append(notifiers, snsNotifier{config, m.httpClient})
+	}
+	for _, webhook := range config.Webhooks {
+		notifiers = append(notifiers, webhookNotifier{config, m.httpClient, webhook})
+	}
+	if config.OnAlertCommand != "" {
+		notifiers = append(notifiers, commandNotifier{config})
+	}
+	return append(notifiers, m.Notifiers...)
+}
+
+// Channels keyed by lowercase name, for NotificationRouting lookups
+func (m *Monitor) notifierRegistry() map[string]Notifier {
+	registry := map[string]Notifier{}
+	for _, notifier := range m.notifiers() {
+		registry[strings.ToLower(notifier.Name())] = notifier
+	}
+	return registry
+}
+
+// Send an alert report through every channel, returning whether any
+// channel delivered it. Channels are sent to all at once, so a slow one
+// doesn't hold up the others. A failing channel is logged and never blocks
+// the others, and one that keeps failing is skipped by its circuit breaker.
+// With NotificationRouting each channel only receives the problems whose
+// severity is routed to it.
+func (m *Monitor) sendAlerts(report *AlertReport) bool {
+	config := m.Config
+	registry := m.notifierRegistry()
+	if len(config.NotificationRouting) > 0 {
+		for _, name := range config.routedChannels() {
+			if registry[name] == nil && name != pagerDutyChannel && name != opsgenieChannel {
+				m.logf("Warning: notificationRouting sends alerts to %s, which is not configured\n", name)
+			}
+		}
+	}
+
+	// Pick the channels first, so the rate limit counts them in order
+	var notifiers []Notifier
+	var reports []*AlertReport
+	for _, notifier := range m.notifiers() {
+		name := notifier.Name()
+		channelReport := m.routedReport(report, name)
+		if channelReport == nil {
+			m.logf("Not sending %s alert, no problems in this alert are routed to it\n", name)
+			continue
+		}
+		if len(config.NotificationRouting) > 0 {
+			m.logf("Routing %d jobs and %d repositories (severity %s) to %s\n",
+				channelReport.Counts.Total, channelReport.Counts.Repositories, channelReport.Severity, name)
+		}
+
+		if !m.allowChannel(name, time.Now()) || !m.allowNotification(name) {
+			continue
+		}
+		notifiers = append(notifiers, notifier)
+		reports = append(reports, channelReport)
+	}
+
+	errs := make([]error, len(notifiers))
+	var wg sync.WaitGroup
+	for i, notifier := range notifiers {
+		wg.Add(1)
+		go func(i int, notifier Notifier) {
+			defer wg.Done()
+			errs[i] = m.notify(notifier, reports[i])
+		}(i, notifier)
+	}
+	wg.Wait()
+
+	sent := false
+	for i, notifier := range notifiers {
+		name, err := notifier.Name(), errs[i]
+		m.recordChannelResult(name, err, time.Now())
+		m.recordNotification(name, err)
+		if err != nil {
+			m.logf("Error sending %s alert: %v\n", name, err)
+			continue
+		}
+		m.logf("Sent %s alert (severity %s)\n", name, reports[i].Severity)
+		sent = true
+	}
+	return sent
+}
+
+// Send a report through one channel with a notificationContext. A notifier
+// that panics fails like one returning an error, so it can't take the
+// others down with it.
+func (m *Monitor) notify(notifier Notifier, report *AlertReport) error {
+	return m.deliver(func(ctx context.Context) error { return notifier.Notify(ctx, report) })
+}
+
+// Run one channel's send with a notificationContext, turning a panic into an
+// error
+func (m *Monitor) deliver(send func(ctx context.Context) error) (err error) {
+	ctx, cancel := m.notificationContext()
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("notifier panicked: %v", r)
+		}
+	}()
+	return send(ctx)
+}
+
+// Context of a notification the monitor sends, ending after
+// NotificationTimeoutSeconds or when the monitor is stopped
+func (m *Monitor) notificationContext() (context.Context, context.CancelFunc) {
+	if timeout := m.Config.notificationTimeout(); timeout > 0 {
+		return context.WithTimeout(m.runContext(), timeout)
+	}
+	return context.WithCancel(m.runContext())
+}
+
+// The part of a report routed to a channel, or nil when nothing in it is.
+// Without NotificationRouting every channel gets the whole report, except
+// that a client's channel only ever gets the client's own jobs.
+func (m *Monitor) routedReport(report *AlertReport, channel string) *AlertReport {
+	config := m.Config
+	if client := config.channelClient(channel); client != "" {
+		if report = clientReport(report, config, client); report == nil {
+			return nil
+		}
+	}
+	if len(config.NotificationRouting) == 0 {
+		return report
+	}
+
+	var jobs []JobStatus
+	for _, job := range report.Jobs() {
+		if config.routesJob(job, channel) {
+			jobs = append(jobs, job)
+		}
+	}
+	var repos []RepositoryStatus
+	if config.routes(config.statusSeverity(repositoryStatus), channel) {
+		repos = report.Repositories
+	}
+
+	severity := alertSeverity(config, jobs, repos)
+	if severity == severityNone {
+		return nil
+	}
+	routed := NewAlertReport(jobs, repos, severity, config, report.Timestamp)
+	routed.CycleID = report.CycleID
+	routed.Client = report.Client
+	return routed
+}
+
+// Whether NotificationRouting sends alerts of a severity to a channel. Every
+// severity goes to every channel when no routing is configured.
+func (c *Config) routes(severity, channel string) bool {
+	if len(c.NotificationRouting) == 0 {
+		return true
+	}
+	for _, name := range c.NotificationRouting[severity] {
+		if strings.EqualFold(name, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Every channel named in NotificationRouting, sorted
+func (c *Config) routedChannels() []string {
+	seen := map[string]bool{}
+	var channels []string
+	for _, names := range c.NotificationRouting {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				channels = append(channels, name)
+			}
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
@@ -0,0 +1,215 @@
+package veeammonitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifiersRenderTheSameReport(t *testing.T) {
+	var mu sync.Mutex
+	posted := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		posted[r.URL.Path] += string(data)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	smtp := newFakeSMTP(t)
+
+	config := smtpTestConfig(smtp.addr, "ops@example.com")
+	config.MonitorWarningJobs = true
+	config.SlackWebhookURL = server.URL + "/slack"
+	config.TeamsWebhookURL = server.URL + "/teams"
+	config.DiscordWebhookURL = server.URL + "/discord"
+	config.Webhooks = []Webhook{{Name: "ops", URL: server.URL + "/webhook"}}
+	m, capture := newCaptureMonitor(config, statusRunner([]string{"Nightly", "Weekly"}, []string{"Files"}))
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d reports, want 1", len(sent))
+	}
+	report := sent[0]
+	if report.Counts.Total != 3 || report.Counts.Failed != 2 || report.Counts.Warning != 1 {
+		t.Fatalf("got counts %+v, want 2 failed and 1 warning", report.Counts)
+	}
+
+	var webhook webhookPayload
+	if err := json.Unmarshal([]byte(posted["/webhook"]), &webhook); err != nil {
+		t.Fatalf("decoding webhook payload: %v\n%s", err, posted["/webhook"])
+	}
+	if webhook.Counts != report.Counts || webhook.Fingerprint != report.Fingerprint || webhook.CycleID != report.CycleID {
+		t.Errorf("webhook got counts %+v, fingerprint %s and cycle %s, want %+v, %s and %s",
+			webhook.Counts, webhook.Fingerprint, webhook.CycleID, report.Counts, report.Fingerprint, report.CycleID)
+	}
+
+	delivered := smtp.delivered()
+	if len(delivered) != 1 {
+		t.Fatalf("delivered %d emails, want 1", len(delivered))
+	}
+	rendered := map[string]string{"email": delivered[0].data, "slack": posted["/slack"], "teams": posted["/teams"], "discord": posted["/discord"]}
+	counts := map[string][]string{
+		"email":   {"FAILED JOBS (2)", "WARNING JOBS (1)"},
+		"slack":   {"Failed jobs (2)", "Warning jobs (1)"},
+		"teams":   {"Failed jobs (2)", "Warning jobs (1)"},
+		"discord": {"3 jobs need attention"},
+	}
+	for channel, text := range rendered {
+		for _, want := range append(counts[channel], "Nightly", "Weekly", "Files", report.Fingerprint) {
+			if !strings.Contains(text, want) {
+				t.Errorf("%s alert doesn't mention %q:\n%s", channel, want, text)
+			}
+		}
+	}
+}
+
+func TestNotificationRoutingBySeverity(t *testing.T) {
+	m, pagerDuty := newPagerDutyMonitor(t)
+	m.Runner = statusRunner([]string{"Nightly"}, []string{"Files"})
+	m.Config.MonitorWarningJobs = true
+	m.Config.NotificationRouting = map[string][]string{
+		severityCritical: {"pagerduty", "mail"},
+		severityWarning:  {"chat"},
+	}
+	mail, chat, archive := &captureNotifier{name: "mail"}, &captureNotifier{name: "chat"}, &captureNotifier{name: "archive"}
+	m.Notifiers = []Notifier{mail, chat, archive}
+	m.RunCheckCycle()
+
+	tests := []struct {
+		notifier *captureNotifier
+		want     []string
+	}{
+		{mail, []string{"Nightly"}},
+		{chat, []string{"Files"}},
+		{archive, nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, report := range test.notifier.sent() {
+			got = append(got, jobNames(report.Jobs())...)
+		}
+		if !equalStrings(got, test.want) {
+			t.Errorf("%s got %v, want %v", test.notifier.name, got, test.want)
+		}
+	}
+	events := pagerDuty.received()
+	if len(events) != 1 || events[0].Payload.Component != "Nightly" {
+		t.Errorf("PagerDuty got %+v, want only the failed job", events)
+	}
+}
+
+// Notifier running a function
+type funcNotifier struct {
+	name   string
+	notify func(ctx context.Context, report *AlertReport) error
+}
+
+func (n funcNotifier) Name() string { return n.name }
+func (n funcNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	return n.notify(ctx, report)
+}
+
+func TestEmailChannelOnlyWhenConfigured(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		want      string
+	}{
+		{"no email settings", func(c *Config) {}, ""},
+		{"no SMTP server", func(c *Config) { c.EmailFrom, c.EmailTo = "veeam@example.com", []string{"ops@example.com"} }, ""},
+		{"no recipients", func(c *Config) { c.EmailFrom, c.SMTPServer = "veeam@example.com", "smtp.example.com" }, ""},
+		{"SMTP", func(c *Config) {
+			c.EmailFrom, c.EmailTo, c.SMTPServer = "veeam@example.com", []string{"ops@example.com"}, "smtp.example.com"
+		}, "email"},
+		{"SES", func(c *Config) { c.SESEnabled = true }, "ses"},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		test.configure(config)
+		var got string
+		for _, notifier := range newTestMonitor(config, nil).notifiers() {
+			if name := notifier.Name(); name == "email" || name == "ses" {
+				got = name
+			}
+		}
+		if got != test.want {
+			t.Errorf("%s: got email channel %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSendAlertsToAllChannelsAtOnce(t *testing.T) {
+	// Each channel waits for the other to be sent to, which only happens in
+	// time when they are sent to together
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	together := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(together)
+	}()
+	waitForOther := funcNotifier{"", func(ctx context.Context, report *AlertReport) error {
+		arrived.Done()
+		select {
+		case <-together:
+			return nil
+		case <-time.After(2 * time.Second):
+			return errors.New("the other channel wasn't sent to meanwhile")
+		}
+	}}
+	first, second := waitForOther, waitForOther
+	first.name, second.name = "first", "second"
+
+	m := newTestMonitor(testConfig(), nil)
+	m.Notifiers = []Notifier{first, second}
+	if !m.sendAlerts(NewAlertReport(failedJobs("Nightly"), nil, severityCritical, m.Config, time.Now())) {
+		t.Error("sendAlerts reported nothing sent")
+	}
+	if sent := m.metrics.notifications; sent["first"] != 1 || sent["second"] != 1 {
+		t.Errorf("got %v notifications sent, want both channels", sent)
+	}
+}
+
+func TestNotifierTimeoutAndPanic(t *testing.T) {
+	config := testConfig()
+	config.NotificationTimeoutSeconds = 1
+	m, capture := newCaptureMonitor(config, nil)
+	var deadline time.Time
+	slow := funcNotifier{"slow", func(ctx context.Context, report *AlertReport) error {
+		deadline, _ = ctx.Deadline()
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	panicking := funcNotifier{"panicking", func(ctx context.Context, report *AlertReport) error {
+		panic("nil map")
+	}}
+	m.Notifiers = append(m.Notifiers, slow, panicking)
+
+	started := time.Now()
+	if !m.sendAlerts(NewAlertReport(failedJobs("Nightly"), nil, severityCritical, config, started)) {
+		t.Error("sendAlerts reported nothing sent")
+	}
+	if deadline.Sub(started) > 2*time.Second || deadline.Before(started) {
+		t.Errorf("got deadline %v after starting, want notificationTimeoutSeconds", deadline.Sub(started))
+	}
+	if len(capture.sent()) != 1 {
+		t.Error("the slow and panicking channels kept the alert from the others")
+	}
+	if failed := m.metrics.notificationFailures; failed["slow"] != 1 || failed["panicking"] != 1 {
+		t.Errorf("got failures %v, want the slow and panicking channels failed", failed)
+	}
+
+	if err := m.notify(panicking, nil); err == nil || err.Error() != "notifier panicked: nil map" {
+		t.Errorf("got error %v", err)
+	}
+}
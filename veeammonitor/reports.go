@@ -0,0 +1,118 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Names of archived reports: report-<UTC timestamp>.json.gz
+const (
+	reportArchivePrefix = "report-"
+	reportArchiveSuffix = ".json.gz"
+	reportArchiveLayout = "20060102T150405Z"
+)
+
+// Write the cycle's report to ReportJSONPath and archive it in
+// ReportHistoryDir. Writing happens in the background so slow disks never
+// delay alerts; reportMu keeps the writes of overlapping cycles in order.
+func (m *Monitor) writeReports(report *AlertReport) {
+	config := m.Config
+	if config.ReportJSONPath == "" && config.ReportHistoryDir == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		m.logf("Error encoding report: %v\n", err)
+		return
+	}
+
+	go func() {
+		m.reportMu.Lock()
+		defer m.reportMu.Unlock()
+
+		if config.ReportJSONPath != "" {
+			if err := writeFileAtomic(config.ReportJSONPath, data); err != nil {
+				m.logf("Error writing JSON report: %v\n", err)
+			}
+		}
+		if config.ReportHistoryDir != "" {
+			if err := archiveReport(config.ReportHistoryDir, data, report.Timestamp); err != nil {
+				m.logf("Error archiving report: %v\n", err)
+			}
+			pruneReportArchives(config.ReportHistoryDir, config.ReportHistoryRetentionDays, report.Timestamp)
+		}
+	}()
+}
+
+// Replace a file through a temporary file and rename, so a crash mid-write
+// can't leave it truncated
+func writeFileAtomic(filePath string, data []byte) error {
+	tmp := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// Store a report as a timestamped gzip file in dir
+func archiveReport(dir string, data []byte, timestamp time.Time) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating report history directory: %v", err)
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("error compressing report: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error compressing report: %v", err)
+	}
+
+	name := reportArchivePrefix + timestamp.UTC().Format(reportArchiveLayout) + reportArchiveSuffix
+	return writeFileAtomic(filepath.Join(dir, name), compressed.Bytes())
+}
+
+// Delete archived reports older than retentionDays, going by the timestamp
+// in the file name. Other files in dir are left alone.
+func pruneReportArchives(dir string, retentionDays int, now time.Time) {
+	if retentionDays <= 0 {
+		return
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading report history directory: %v\n", err)
+		return
+	}
+
+	cutoff := now.Add(-time.Duration(retentionDays) * 24 * time.Hour)
+	pruned := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, reportArchivePrefix) || !strings.HasSuffix(name, reportArchiveSuffix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, reportArchivePrefix), reportArchiveSuffix)
+		timestamp, err := time.Parse(reportArchiveLayout, stamp)
+		if err != nil || !timestamp.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			log.Printf("Error removing old report %s: %v\n", name, err)
+			continue
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		log.Printf("Removed %d archived reports older than %d days\n", pruned, retentionDays)
+	}
+}
@@ -0,0 +1,95 @@
+package veeammonitor
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Decode an archived report
+func readArchivedReport(t *testing.T, path string) *AlertReport {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("archive isn't gzip: %v", err)
+	}
+	var report AlertReport
+	if err := json.NewDecoder(reader).Decode(&report); err != nil {
+		t.Fatalf("decoding archived report: %v", err)
+	}
+	return &report
+}
+
+func TestWriteReportsArchivesGzip(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig()
+	config.ReportJSONPath = filepath.Join(dir, "report.json")
+	config.ReportHistoryDir = filepath.Join(dir, "history")
+	m := newTestMonitor(config, nil)
+	jobs := []JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC))
+
+	m.writeReports(report)
+	archive := filepath.Join(config.ReportHistoryDir, "report-20240301T083000Z.json.gz")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		m.reportMu.Lock()
+		_, err := os.Stat(archive)
+		m.reportMu.Unlock()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("report wasn't archived: %v", err)
+		}
+	}
+
+	archived := readArchivedReport(t, archive)
+	if archived.Counts != report.Counts || len(archived.Failed) != 1 || archived.Failed[0].Name != "Nightly" {
+		t.Errorf("archived %+v, want the report", archived)
+	}
+	if _, err := os.Stat(config.ReportJSONPath); err != nil {
+		t.Errorf("JSON report not written: %v", err)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(config.ReportHistoryDir, "*.tmp")); len(temps) != 0 {
+		t.Errorf("left temporary files %v", temps)
+	}
+}
+
+func TestPruneReportArchives(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	for _, age := range []time.Duration{0, 29 * 24 * time.Hour, 31 * 24 * time.Hour, 90 * 24 * time.Hour} {
+		if err := archiveReport(dir, []byte("{}"), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"notes.txt", "report-latest.json.gz"} {
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	pruneReportArchives(dir, 30, now)
+	entries, _ := ioutil.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	want := []string{"notes.txt", "report-20240302T120000Z.json.gz", "report-20240331T120000Z.json.gz", "report-latest.json.gz"}
+	if !equalStrings(names, want) {
+		t.Errorf("kept %v, want %v", names, want)
+	}
+
+	pruneReportArchives(dir, 0, now.Add(365*24*time.Hour))
+	if after, _ := ioutil.ReadDir(dir); len(after) != len(entries) {
+		t.Errorf("pruned with retention disabled")
+	}
+}
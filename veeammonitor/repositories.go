@@ -0,0 +1,124 @@
+package veeammonitor
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RepositoryStatus represents a backup repository or scale-out repository extent
+type RepositoryStatus struct {
+	Name       string `json:"name"`
+	ScaleOut   string `json:"scaleOut,omitempty"` // Parent scale-out repository for extents, empty otherwise
+	TotalBytes int64  `json:"totalBytes"`
+	FreeBytes  int64  `json:"freeBytes"`
+}
+
+// Used space in bytes
+func (r RepositoryStatus) UsedBytes() int64 {
+	return r.TotalBytes - r.FreeBytes
+}
+
+// Free space as a percentage of the total
+func (r RepositoryStatus) FreePercent() float64 {
+	if r.TotalBytes <= 0 {
+		return 0
+	}
+	return float64(r.FreeBytes) / float64(r.TotalBytes) * 100
+}
+
+// Name including the scale-out repository an extent belongs to
+func (r RepositoryStatus) DisplayName() string {
+	if r.ScaleOut == "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%s (extent of %s)", r.Name, r.ScaleOut)
+}
+
+// Get free/total space for all repositories and scale-out extents
+func (m *Monitor) getRepositoryStatuses() ([]RepositoryStatus, error) {
+	// Standalone repositories first, then the extents of each scale-out repository
+	query := `
+		$repos = @()
+		Get-VBRBackupRepository | ForEach-Object {
+			$container = $_.GetContainer()
+			$repos += [pscustomobject]@{Name=$_.Name; ScaleOut=""; TotalBytes=$container.CachedTotalSpace.InBytes; FreeBytes=$container.CachedFreeSpace.InBytes}
+		}
+		Get-VBRBackupRepository -ScaleOut | ForEach-Object {
+			$scaleOut = $_.Name
+			Get-VBRRepositoryExtent -Repository $_ | ForEach-Object {
+				$container = $_.Repository.GetContainer()
+				$repos += [pscustomobject]@{Name=$_.Name; ScaleOut=$scaleOut; TotalBytes=$container.CachedTotalSpace.InBytes; FreeBytes=$container.CachedFreeSpace.InBytes}
+			}
+		}
+		$repos | %s
+	`
+
+	output, err := m.runVeeamScript(fmt.Sprintf(query, convertToCsv(m.Config)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute PowerShell command for repositories: %w", err)
+	}
+
+	return parseRepositoryOutput(output, m.Config.csvDelimiter())
+}
+
+// Parse the repository CSV output from PowerShell
+func parseRepositoryOutput(output string, delimiter rune) ([]RepositoryStatus, error) {
+	reader := csv.NewReader(strings.NewReader(cleanCSVOutput(output)))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, &ParseError{"repository output", err}
+	}
+	if len(records) < 2 {
+		return []RepositoryStatus{}, nil
+	}
+
+	var repos []RepositoryStatus
+	// Skip header line and process data lines
+	for _, record := range records[1:] {
+		if len(record) < 4 {
+			continue
+		}
+
+		total, err := strconv.ParseInt(strings.TrimSpace(record[2]), 10, 64)
+		if err != nil {
+			return nil, &ParseError{"repository output", fmt.Errorf("invalid total space %q for repository %s", record[2], record[0])}
+		}
+		free, err := strconv.ParseInt(strings.TrimSpace(record[3]), 10, 64)
+		if err != nil {
+			return nil, &ParseError{"repository output", fmt.Errorf("invalid free space %q for repository %s", record[3], record[0])}
+		}
+
+		repos = append(repos, RepositoryStatus{
+			Name:       record[0],
+			ScaleOut:   record[1],
+			TotalBytes: total,
+			FreeBytes:  free,
+		})
+	}
+
+	return repos, nil
+}
+
+// Repositories whose free space has dropped below the threshold percentage
+func lowSpaceRepositories(repos []RepositoryStatus, thresholdPercent int) []RepositoryStatus {
+	var low []RepositoryStatus
+	for _, repo := range repos {
+		// Repositories reporting no capacity (e.g. offline) can't be judged
+		if repo.TotalBytes <= 0 {
+			continue
+		}
+		if repo.FreePercent() < float64(thresholdPercent) {
+			low = append(low, repo)
+		}
+	}
+	return low
+}
+
+// Format a byte count as GB for reports
+func formatGB(bytes int64) string {
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1024*1024*1024))
+}
@@ -0,0 +1,45 @@
+package veeammonitor
+
+import "testing"
+
+func TestParseRepositoryOutput(t *testing.T) {
+	output := `"Name","ScaleOut","TotalBytes","FreeBytes"` + "\r\n" +
+		`"Main","","1000","400"` + "\r\n" +
+		`"Extent 1","SOBR","2000","100"` + "\r\n"
+	repos, err := parseRepositoryOutput(output, ',')
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []RepositoryStatus{
+		{Name: "Main", TotalBytes: 1000, FreeBytes: 400},
+		{Name: "Extent 1", ScaleOut: "SOBR", TotalBytes: 2000, FreeBytes: 100},
+	}
+	if len(repos) != len(want) {
+		t.Fatalf("got %+v, want %+v", repos, want)
+	}
+	for i := range want {
+		if repos[i] != want[i] {
+			t.Errorf("got %+v, want %+v", repos[i], want[i])
+		}
+	}
+	if got := repos[1].DisplayName(); got != "Extent 1 (extent of SOBR)" {
+		t.Errorf("got display name %q", got)
+	}
+}
+
+func TestLowSpaceRepositories(t *testing.T) {
+	repos := []RepositoryStatus{
+		{Name: "Plenty", TotalBytes: 1000, FreeBytes: 500},
+		{Name: "At threshold", TotalBytes: 1000, FreeBytes: 100},
+		{Name: "Low", TotalBytes: 1000, FreeBytes: 99},
+		{Name: "Full", TotalBytes: 1000, FreeBytes: 0},
+		{Name: "Offline", TotalBytes: 0, FreeBytes: 0},
+	}
+	low := lowSpaceRepositories(repos, 10)
+	if len(low) != 2 || low[0].Name != "Low" || low[1].Name != "Full" {
+		t.Errorf("got %+v, want Low and Full", low)
+	}
+	if low := lowSpaceRepositories(repos, 0); len(low) != 0 {
+		t.Errorf("threshold 0 flagged %+v", low)
+	}
+}
@@ -0,0 +1,260 @@
+package veeammonitor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"veeam-monitor/veeamapi"
+)
+
+// Veeam Backup & Replication REST API job types and the monitorJobTypes
+// they belong to
+var veeamAPIJobTypes = map[string]string{
+	"Backup":             "backup",
+	"VSphereBackup":      "backup",
+	"HyperVBackup":       "backup",
+	"BackupCopy":         "copy",
+	"WindowsAgentBackup": "agent",
+	"LinuxAgentBackup":   "agent",
+	"BackupToTape":       "tape",
+	"FileToTape":         "tape",
+	"SureBackup":         "surebackup",
+}
+
+// Number of sessions fetched per cycle, newest first
+const veeamAPISessionLimit = 1000
+
+// VeeamAPISource is a JobSource that reads jobs, sessions and repositories
+// from the REST API of Veeam Backup & Replication 11 or 12 instead of
+// PowerShell, so the monitor can run on Linux or in a container.
+type VeeamAPISource struct {
+	Config *Config
+
+	client *veeamapi.Client
+
+	now      time.Time                    // When this cycle started
+	jobs     map[string]veeamapi.JobState // Monitored jobs by ID
+	sessions []veeamapi.Session           // Newest session of each monitored job
+	err      error                        // Error fetching this cycle's jobs and sessions
+}
+
+// NewVeeamAPISource creates a source for the configured REST API
+func NewVeeamAPISource(config *Config) *VeeamAPISource {
+	client := veeamapi.NewClient(config.restURL(), config.RESTUsername, config.RESTPassword, config.RESTInsecureSkipVerify)
+	if config.RESTAPIVersion != "" {
+		client.APIVersion = config.RESTAPIVersion
+	}
+	return &VeeamAPISource{Config: config, client: client}
+}
+
+// URL of the REST API: RESTURL, or the standard port on VeeamServerAddress
+func (c *Config) restURL() string {
+	if c.RESTURL != "" {
+		return c.RESTURL
+	}
+	return "https://" + net.JoinHostPort(c.VeeamServerAddress, strconv.Itoa(veeamapi.DefaultPort))
+}
+
+// StartCycle fetches the jobs and sessions every query of this cycle reports on
+func (s *VeeamAPISource) StartCycle() {
+	s.now = time.Now()
+	s.jobs, s.sessions, s.err = s.latestSessions()
+}
+
+// This cycle's jobs and sessions, fetched now for callers that don't start cycles
+func (s *VeeamAPISource) cycleSessions() ([]veeamapi.Session, error) {
+	if s.now.IsZero() {
+		s.StartCycle()
+	}
+	return s.sessions, s.err
+}
+
+// Monitored jobs and the newest session of each, the running one if a job is running
+func (s *VeeamAPISource) latestSessions() (map[string]veeamapi.JobState, []veeamapi.Session, error) {
+	states, err := s.client.JobStates()
+	if err != nil {
+		return nil, nil, veeamAPIError(err)
+	}
+	jobs := map[string]veeamapi.JobState{}
+	for _, job := range states {
+		if s.monitored(job.Type) {
+			jobs[job.ID] = job
+		}
+	}
+
+	all, err := s.client.Sessions(veeamAPISessionLimit)
+	if err != nil {
+		return nil, nil, veeamAPIError(err)
+	}
+	seen := map[string]bool{}
+	var sessions []veeamapi.Session
+	for _, session := range all {
+		if _, ok := jobs[session.JobID]; !ok || seen[session.JobID] {
+			continue
+		}
+		seen[session.JobID] = true
+		sessions = append(sessions, session)
+	}
+	return jobs, sessions, nil
+}
+
+// Whether a job type is in MonitorJobTypes
+func (s *VeeamAPISource) monitored(apiJobType string) bool {
+	jobType, ok := veeamAPIJobTypes[apiJobType]
+	if !ok {
+		return false
+	}
+	for _, monitored := range s.Config.MonitorJobTypes {
+		if monitored == jobType {
+			return true
+		}
+	}
+	return false
+}
+
+// A JobStatus for a job, without a session
+func (s *VeeamAPISource) jobStatus(job veeamapi.JobState, status string) JobStatus {
+	return JobStatus{
+		Name:     job.Name,
+		Status:   status,
+		JobType:  jobTypeLabels[veeamAPIJobTypes[job.Type]],
+		NextRun:  s.apiTime(job.NextRun),
+		Disabled: job.Disabled(),
+	}
+}
+
+// A JobStatus for a job's session
+func (s *VeeamAPISource) sessionStatus(session veeamapi.Session, status string) JobStatus {
+	job := s.jobStatus(s.jobs[session.JobID], status)
+	job.StartTime = s.apiTime(session.CreationTime)
+	job.EndTime = "N/A"
+	if session.Stopped() {
+		job.EndTime = s.apiTime(session.EndTime)
+	}
+	return job
+}
+
+// A time from the API as displayed in alerts, empty when the API gave none
+func (s *VeeamAPISource) apiTime(at time.Time) string {
+	if at.IsZero() {
+		return ""
+	}
+	return s.Config.displayTime(at.Format(time.RFC3339))
+}
+
+func (s *VeeamAPISource) JobsByStatus(status string) ([]JobStatus, error) {
+	sessions, err := s.cycleSessions()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []JobStatus
+	for _, session := range sessions {
+		if session.Stopped() && strings.EqualFold(session.Result.Result, status) {
+			job := s.sessionStatus(session, status)
+			job.Description = session.Result.Message
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (s *VeeamAPISource) LongRunningJobs() ([]JobStatus, error) {
+	sessions, err := s.cycleSessions()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []JobStatus
+	for _, session := range sessions {
+		if session.Stopped() || session.CreationTime.IsZero() {
+			continue
+		}
+		minutes := s.now.Sub(session.CreationTime).Minutes()
+		if minutes <= float64(s.Config.longRunningMinutes()) {
+			continue
+		}
+		job := s.sessionStatus(session, "Running")
+		job.Duration = strconv.FormatFloat(minutes, 'f', 2, 64)
+		job.Description = fmt.Sprintf("Long-running job (over %d minutes): Currently running", s.Config.LongRunningThreshold)
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *VeeamAPISource) StaleJobs() ([]JobStatus, error) {
+	sessions, err := s.cycleSessions()
+	if err != nil {
+		return nil, err
+	}
+	lastSession := map[string]veeamapi.Session{}
+	for _, session := range sessions {
+		lastSession[session.JobID] = session
+	}
+
+	var jobs []JobStatus
+	for id, job := range s.jobs {
+		if job.Disabled() && !s.Config.IncludeDisabledJobs {
+			continue
+		}
+
+		status := s.jobStatus(job, "Stale")
+		status.Duration = "-1"
+		status.StartTime = s.apiTime(job.LastRun)
+		lastRun := job.LastRun
+		if session, ok := lastSession[id]; ok {
+			status = s.sessionStatus(session, "Stale")
+			lastRun = session.EndTime
+			if !session.Stopped() || lastRun.IsZero() {
+				lastRun = session.CreationTime
+			}
+		}
+		if !lastRun.IsZero() {
+			status.Duration = strconv.FormatFloat(s.now.Sub(lastRun).Hours(), 'f', 2, 64)
+		}
+		jobs = append(jobs, status)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return staleJobs(jobs, s.Config.MaxJobAgeHours), nil
+}
+
+func (s *VeeamAPISource) AllJobs() ([]JobStatus, error) {
+	if _, err := s.cycleSessions(); err != nil {
+		return nil, err
+	}
+	var jobs []JobStatus
+	for _, job := range s.jobs {
+		jobs = append(jobs, s.jobStatus(job, ""))
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs, nil
+}
+
+func (s *VeeamAPISource) Repositories() ([]RepositoryStatus, error) {
+	states, err := s.client.RepositoryStates()
+	if err != nil {
+		return nil, veeamAPIError(err)
+	}
+
+	const gb = 1024 * 1024 * 1024
+	var repos []RepositoryStatus
+	for _, repo := range states {
+		repos = append(repos, RepositoryStatus{Name: repo.Name, TotalBytes: int64(repo.CapacityGB * gb), FreeBytes: int64(repo.FreeGB * gb)})
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+// Classify a REST API error for the unreachable alert and the query error counts
+func veeamAPIError(err error) error {
+	switch {
+	case errors.Is(err, veeamapi.ErrUnreachable), errors.Is(err, veeamapi.ErrAuthentication):
+		return fmt.Errorf("%w: %v", ErrVeeamUnreachable, err)
+	case errors.Is(err, veeamapi.ErrInvalidResponse):
+		return &ParseError{"Veeam REST API response", err}
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"veeam-monitor/veeamapi"
+)
+
+// Stand-in Veeam Backup & Replication REST API answering every list in one page
+type fakeVeeamAPI struct {
+	jobs         []veeamapi.JobState
+	sessions     []veeamapi.Session
+	repositories []veeamapi.RepositoryState
+}
+
+func (f *fakeVeeamAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/api/oauth2/token" {
+		if r.ParseForm(); r.PostForm.Get("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 900})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var data interface{}
+	count := 0
+	switch r.URL.Path {
+	case "/api/v1/jobs/states":
+		data, count = f.jobs, len(f.jobs)
+	case "/api/v1/sessions":
+		data, count = f.sessions, len(f.sessions)
+	case "/api/v1/backupInfrastructure/repositories/states":
+		data, count = f.repositories, len(f.repositories)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data, "pagination": map[string]int{"total": count, "count": count}})
+}
+
+// REST API source for a test server
+func newVeeamAPITestSource(url, password string) *VeeamAPISource {
+	config := testConfig()
+	config.Backend = "rest"
+	config.RESTURL = url
+	config.RESTUsername = "admin"
+	config.RESTPassword = password
+	config.RESTInsecureSkipVerify = true
+	config.MonitorJobTypes = []string{"backup", "copy"}
+	config.MaxJobAgeHours = 48
+	return NewVeeamAPISource(config)
+}
+
+func TestVeeamAPISourceJobs(t *testing.T) {
+	now := time.Now().UTC()
+	session := func(id, job string, started time.Duration, state, result, message string) veeamapi.Session {
+		s := veeamapi.Session{ID: id, JobID: job, CreationTime: now.Add(-started), State: state, Result: veeamapi.SessionResult{Result: result, Message: message}}
+		if state == "Stopped" {
+			s.EndTime = s.CreationTime.Add(20 * time.Minute)
+		}
+		return s
+	}
+	service := &fakeVeeamAPI{
+		jobs: []veeamapi.JobState{
+			{ID: "1", Name: "Nightly", Type: "VSphereBackup", LastResult: "Failed", LastRun: now.Add(-2 * time.Hour), NextRun: now.Add(20 * time.Hour)},
+			{ID: "2", Name: "Weekly", Type: "Backup", LastResult: "Warning", LastRun: now.Add(-3 * time.Hour)},
+			{ID: "3", Name: "Offsite", Type: "BackupCopy", Status: "running", LastResult: "Success", LastRun: now.Add(-5 * time.Hour)},
+			{ID: "4", Name: "Archive", Type: "Backup", LastResult: "Success", LastRun: now.Add(-72 * time.Hour)},
+			{ID: "5", Name: "Tapes", Type: "BackupToTape", LastResult: "Failed", LastRun: now.Add(-time.Hour)},
+			{ID: "6", Name: "Old", Type: "Backup", Status: "disabled", LastResult: "Success", LastRun: now.Add(-300 * time.Hour)},
+		},
+		sessions: []veeamapi.Session{
+			session("a", "1", 2*time.Hour, "Stopped", "Failed", "Disk full"),
+			session("b", "2", 3*time.Hour, "Stopped", "Warning", "Slow storage"),
+			session("c", "3", 5*time.Hour, "Working", "None", ""),
+			session("d", "5", time.Hour, "Stopped", "Failed", "Drive offline"),
+			session("e", "1", 26*time.Hour, "Stopped", "Success", ""),
+			session("f", "4", 72*time.Hour, "Stopped", "Success", ""),
+		},
+		repositories: []veeamapi.RepositoryState{{Name: "Main", CapacityGB: 100, FreeGB: 2.5}},
+	}
+	server := httptest.NewTLSServer(service)
+	defer server.Close()
+	source := newVeeamAPITestSource(server.URL, "secret")
+	source.StartCycle()
+
+	failed, err := source.JobsByStatus("Failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Name != "Nightly" || failed[0].JobType != "Backup" || failed[0].Description != "Disk full" || failed[0].EndTime == "N/A" || failed[0].NextRun == "" {
+		t.Errorf("got failed jobs %+v, want Nightly only", failed)
+	}
+	warning, _ := source.JobsByStatus("Warning")
+	if len(warning) != 1 || warning[0].Name != "Weekly" {
+		t.Errorf("got warning jobs %+v, want Weekly", warning)
+	}
+	running, _ := source.LongRunningJobs()
+	if len(running) != 1 || running[0].Name != "Offsite" || running[0].JobType != "Backup Copy" || running[0].EndTime != "N/A" {
+		t.Errorf("got long-running jobs %+v, want Offsite", running)
+	}
+	stale, _ := source.StaleJobs()
+	if names := jobNames(stale); !equalStrings(names, []string{"Archive"}) {
+		t.Errorf("got stale jobs %v, want Archive, without the disabled job", names)
+	}
+	all, _ := source.AllJobs()
+	if names := jobNames(all); !equalStrings(names, []string{"Archive", "Nightly", "Offsite", "Old", "Weekly"}) || !all[3].Disabled {
+		t.Errorf("got jobs %v, want the monitored job types only", names)
+	}
+
+	repos, err := source.Repositories()
+	if err != nil || len(repos) != 1 || repos[0].TotalBytes != 100<<30 || repos[0].FreeBytes != 5<<29 {
+		t.Errorf("got repositories %+v, %v", repos, err)
+	}
+}
+
+func TestVeeamAPISourceErrors(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeVeeamAPI{})
+	defer server.Close()
+
+	source := newVeeamAPITestSource(server.URL, "wrong")
+	source.StartCycle()
+	if _, err := source.JobsByStatus("Failed"); !errors.Is(err, ErrVeeamUnreachable) {
+		t.Errorf("got error %v for rejected credentials, want ErrVeeamUnreachable", err)
+	}
+
+	source = newVeeamAPITestSource(server.URL, "secret")
+	source.Config.RESTInsecureSkipVerify = false
+	source = NewVeeamAPISource(source.Config)
+	if _, err := source.Repositories(); !errors.Is(err, ErrVeeamUnreachable) {
+		t.Errorf("got error %v for an untrusted certificate, want ErrVeeamUnreachable", err)
+	}
+
+	if err := veeamAPIError(veeamapi.ErrInvalidResponse); !errors.Is(err, ErrParseFailure) {
+		t.Errorf("got error %v for an invalid response, want a ParseError", err)
+	}
+}
+
+func TestRestURL(t *testing.T) {
+	config := testConfig()
+	config.VeeamServerAddress = "vbr.example.com"
+	if got := config.restURL(); got != "https://vbr.example.com:9419" {
+		t.Errorf("got %q", got)
+	}
+	config.VeeamServerAddress = "2001:db8::1"
+	if got := config.restURL(); got != "https://[2001:db8::1]:9419" {
+		t.Errorf("got %q for an IPv6 address", got)
+	}
+	config.RESTURL = "https://api.example.com:443"
+	if got := config.restURL(); got != config.RESTURL {
+		t.Errorf("got %q, want restURL", got)
+	}
+}
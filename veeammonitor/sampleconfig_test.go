@@ -0,0 +1,56 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSampleConfigRoundTrips(t *testing.T) {
+	var loaded [][]byte
+	for _, name := range []string{"config.json", "config.yaml"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := WriteSampleConfig(path, false); err != nil {
+			t.Fatalf("%s: writing sample: %v", name, err)
+		}
+
+		// Strict loading fails on anything that would be warned about
+		config, err := LoadStrictConfig(path)
+		if err != nil {
+			t.Fatalf("%s: loading sample: %v", name, err)
+		}
+		sample := sampleConfig()
+		if config.VeeamServerAddress != sample.VeeamServerAddress || config.SMTPServer != sample.SMTPServer ||
+			strings.Join(config.EmailTo, ",") != strings.Join(sample.EmailTo, ",") || !config.MonitorWarningJobs {
+			t.Errorf("%s: got %s, want the sample's values", name, config)
+		}
+		data, _ := json.Marshal(config)
+		loaded = append(loaded, data)
+	}
+	if !bytes.Equal(loaded[0], loaded[1]) {
+		t.Errorf("JSON sample loaded as %s, YAML sample as %s", loaded[0], loaded[1])
+	}
+}
+
+func TestWriteSampleConfigKeepsExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := WriteSampleConfig(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteSampleConfig(path, false); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Errorf("got error %v, want a hint to use -force", err)
+	}
+	if err := WriteSampleConfig(path, true); err != nil {
+		t.Errorf("forced overwrite: %v", err)
+	}
+}
+
+func TestStripJSONComments(t *testing.T) {
+	input := "// header\n{\n    // field\n    \"url\": \"http://example.com//path\" // trailing\n}\n"
+	want := "\n{\n    \n    \"url\": \"http://example.com//path\" \n}\n"
+	if got := string(stripJSONComments([]byte(input))); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ServerConfig is a Veeam server in Servers. Empty fields take the
+// top-level setting of the same name.
+type ServerConfig struct {
+	Name                  string `json:"name" yaml:"name"` // Shown in alerts, logs and file names, defaults to veeamServerAddress
+	VeeamServerAddress    string `json:"veeamServerAddress" yaml:"veeamServerAddress"`
+	VeeamUsername         string `json:"veeamUsername" yaml:"veeamUsername"`
+	VeeamPassword         string `json:"veeamPassword" yaml:"veeamPassword" secret:"true"`
+	VeeamPowerShellModule string `json:"veeamPowerShellModule" yaml:"veeamPowerShellModule"`
+	Backend               string `json:"backend" yaml:"backend"`
+	RESTURL               string `json:"restURL" yaml:"restURL"`
+	RESTUsername          string `json:"restUsername" yaml:"restUsername"`
+	RESTPassword          string `json:"restPassword" yaml:"restPassword" secret:"true"`
+}
+
+// Characters a server name may have, since it becomes part of file names
+// and HTTP paths
+var serverNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Name of the server, its address when no name is set
+func (s ServerConfig) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.VeeamServerAddress
+}
+
+// Check that every server has a name usable in file names and HTTP paths
+// and that no two share one
+func validateServers(servers []ServerConfig) error {
+	seen := map[string]bool{}
+	for i, server := range servers {
+		name := server.name()
+		switch {
+		case name == "":
+			return fmt.Errorf("servers entry %d has neither a name nor a veeamServerAddress", i+1)
+		case !serverNamePattern.MatchString(name):
+			return fmt.Errorf("server name %q may only have letters, digits, '.', '-' and '_'", name)
+		case seen[strings.ToLower(name)]:
+			return fmt.Errorf("server name %q is used more than once", name)
+		}
+		seen[strings.ToLower(name)] = true
+	}
+	return nil
+}
+
+// Config of each of Servers: the top-level config with the server's
+// settings, and a state file, JSON report and report history of its own
+func (c *Config) serverConfigs() []*Config {
+	var configs []*Config
+	for _, server := range c.Servers {
+		config := *c
+		config.Servers = nil
+		config.serverName = server.name()
+		config.HTTPListenAddress = ""
+		config.StateFile = serverPath(c.StateFile, config.serverName)
+		config.ReportJSONPath = serverPath(c.ReportJSONPath, config.serverName)
+		if c.ReportHistoryDir != "" {
+			config.ReportHistoryDir = filepath.Join(c.ReportHistoryDir, config.serverName)
+		}
+
+		overrides := []struct {
+			value  string
+			target *string
+		}{
+			{server.VeeamServerAddress, &config.VeeamServerAddress},
+			{server.VeeamUsername, &config.VeeamUsername},
+			{server.VeeamPassword, &config.VeeamPassword},
+			{server.VeeamPowerShellModule, &config.VeeamPowerShellModule},
+			{strings.ToLower(strings.TrimSpace(server.Backend)), &config.Backend},
+			{server.RESTURL, &config.RESTURL},
+			{server.RESTUsername, &config.RESTUsername},
+			{server.RESTPassword, &config.RESTPassword},
+		}
+		for _, override := range overrides {
+			if override.value != "" {
+				*override.target = override.value
+			}
+		}
+		configs = append(configs, &config)
+	}
+	return configs
+}
+
+// A file of one server, e.g. state-vbr01.json for state.json, empty when
+// path is
+func serverPath(path, server string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + server + ext
+}
+
+// MonitorGroup monitors each of Servers with a Monitor of its own. The
+// monitors check their servers in parallel, so a server that is slow or
+// unreachable doesn't hold up the others, and share one HTTP API.
+type MonitorGroup struct {
+	Config   *Config
+	Monitors []*Monitor
+}
+
+// NewMonitorGroup creates a Monitor for each of the configured Servers
+func NewMonitorGroup(config *Config) *MonitorGroup {
+	g := &MonitorGroup{Config: config}
+	for _, serverConfig := range config.serverConfigs() {
+		g.Monitors = append(g.Monitors, NewMonitor(serverConfig))
+	}
+	return g
+}
+
+// Run runs every monitor until all of them have stopped, which only happens
+// after Stop or with FatalErrorBehavior exit. One server stopping leaves the
+// others running; the first error is returned once the last one stops.
+func (g *MonitorGroup) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if g.Config.HTTPListenAddress != "" {
+		go g.serveHTTP(ctx)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(g.Monitors))
+	for _, m := range g.Monitors {
+		wg.Add(1)
+		go func(m *Monitor) {
+			defer wg.Done()
+			if err := m.Run(); err != nil {
+				log.Printf("Stopped monitoring %s: %v\n", serverDisplayName(m.Config), err)
+				errs <- err
+			}
+		}(m)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// Stop makes every monitor's Run return, and with them Run
+func (g *MonitorGroup) Stop() {
+	for _, m := range g.Monitors {
+		m.Stop()
+	}
+}
+
+// TriggerCheck asks every monitor to check its server now
+func (g *MonitorGroup) TriggerCheck() {
+	for _, m := range g.Monitors {
+		m.TriggerCheck()
+	}
+}
+
+// Handler serves the HTTP API of each server under /servers/<name>/, POST
+// /check to check them all and GET /metrics with the metrics of all of them
+func (g *MonitorGroup) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", g.handleIndex)
+	mux.HandleFunc("/check", g.handleCheck)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) { serveMetrics(w, r, g.Monitors) })
+	for _, m := range g.Monitors {
+		prefix := "/servers/" + m.Config.serverName
+		m.pathPrefix = prefix
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, m.Handler()))
+	}
+	return rejectCrossOrigin(mux)
+}
+
+// Diagnose checks the module and connection of every server, each check
+// named after its server, then the notification channels they share
+func (g *MonitorGroup) Diagnose() DiagnosticResults {
+	var results DiagnosticResults
+	for _, m := range g.Monitors {
+		for _, result := range m.diagnoseVeeam() {
+			result.Check = m.Config.serverName + ": " + result.Check
+			results = append(results, result)
+		}
+	}
+	return append(results, diagnoseNotifications(newHTTPClient(g.Config), g.Config)...)
+}
+
+// Serve the HTTP API on HTTPListenAddress until ctx is cancelled
+func (g *MonitorGroup) serveHTTP(ctx context.Context) {
+	log.Printf("HTTP API listening on %s\n", g.Config.HTTPListenAddress)
+	listenAndServe(ctx, g.Config.HTTPListenAddress, g.Handler())
+}
+
+// List the servers, linking to each one's page
+func (g *MonitorGroup) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<!DOCTYPE html><title>Veeam Backup Monitor</title><h1>Veeam servers</h1><ul>")
+	for _, m := range g.Monitors {
+		name := m.Config.serverName
+		fmt.Fprintf(w, "<li><a href=\"/servers/%s/\">%s</a> (%s)</li>\n", name, name, html.EscapeString(serverAddress(m.Config)))
+	}
+	fmt.Fprintln(w, "</ul>")
+}
+
+// Queue an immediate check of every server
+func (g *MonitorGroup) handleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Printf("Immediate check of every server requested over HTTP from %s\n", r.RemoteAddr)
+	g.TriggerCheck()
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "checks queued")
+}
@@ -0,0 +1,164 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateServers(t *testing.T) {
+	tests := []struct {
+		servers []ServerConfig
+		want    string
+	}{
+		{[]ServerConfig{{Name: "vbr01"}, {VeeamServerAddress: "vbr02.example.com"}}, ""},
+		{[]ServerConfig{{Name: "vbr01"}, {}}, "servers entry 2 has neither a name nor a veeamServerAddress"},
+		{[]ServerConfig{{Name: "../vbr01"}}, `server name "../vbr01" may only have letters, digits, '.', '-' and '_'`},
+		{[]ServerConfig{{Name: "VBR01"}, {VeeamServerAddress: "vbr01"}}, `server name "vbr01" is used more than once`},
+	}
+	for _, test := range tests {
+		err := validateServers(test.servers)
+		if (err == nil && test.want != "") || (err != nil && err.Error() != test.want) {
+			t.Errorf("%+v: got error %v, want %q", test.servers, err, test.want)
+		}
+	}
+}
+
+func TestServerConfigs(t *testing.T) {
+	dir := t.TempDir()
+	config := testConfig()
+	config.VeeamServerAddress = "default.example.com"
+	config.VeeamUsername = "monitor"
+	config.StateFile = filepath.Join(dir, "state.json")
+	config.ReportHistoryDir = filepath.Join(dir, "history")
+	config.HTTPListenAddress = "127.0.0.1:8080"
+	config.Servers = []ServerConfig{
+		{Name: "vbr01", VeeamServerAddress: "vbr01.example.com", Backend: " REST ", RESTUsername: "api"},
+		{VeeamServerAddress: "vbr02.example.com", VeeamUsername: "other"},
+	}
+
+	configs := config.serverConfigs()
+	if len(configs) != 2 {
+		t.Fatalf("got %d configs, want one per server", len(configs))
+	}
+	first, second := configs[0], configs[1]
+	if first.serverName != "vbr01" || first.VeeamServerAddress != "vbr01.example.com" || first.VeeamUsername != "monitor" || first.Backend != "rest" || first.RESTUsername != "api" {
+		t.Errorf("got first server config %+v", first)
+	}
+	if second.serverName != "vbr02.example.com" || second.VeeamUsername != "other" || second.Backend != config.Backend {
+		t.Errorf("got second server config %+v", second)
+	}
+	if first.StateFile != filepath.Join(dir, "state-vbr01.json") || first.ReportHistoryDir != filepath.Join(dir, "history", "vbr01") {
+		t.Errorf("got state file %q and history %q, want files of the server's own", first.StateFile, first.ReportHistoryDir)
+	}
+	if first.Servers != nil || first.HTTPListenAddress != "" {
+		t.Error("server config keeps the servers or the HTTP API, which the group runs")
+	}
+	if config.VeeamServerAddress != "default.example.com" || config.StateFile != filepath.Join(dir, "state.json") {
+		t.Error("serverConfigs changed the top-level config")
+	}
+}
+
+func TestServerPath(t *testing.T) {
+	for _, test := range []struct{ path, want string }{
+		{"", ""},
+		{"state.json", "state-vbr01.json"},
+		{filepath.Join("data", "report"), filepath.Join("data", "report-vbr01")},
+	} {
+		if got := serverPath(test.path, "vbr01"); got != test.want {
+			t.Errorf("serverPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+// Group of test monitors for the named servers
+func newTestMonitorGroup(names ...string) *MonitorGroup {
+	config := testConfig()
+	for _, name := range names {
+		config.Servers = append(config.Servers, ServerConfig{Name: name})
+	}
+	g := &MonitorGroup{Config: config}
+	for _, serverConfig := range config.serverConfigs() {
+		g.Monitors = append(g.Monitors, newTestMonitor(serverConfig, nil))
+	}
+	return g
+}
+
+func TestMonitorGroupHandler(t *testing.T) {
+	g := newTestMonitorGroup("vbr01", "vbr02")
+	g.Monitors[1].status.Unreachable = true
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(index), `href="/servers/vbr01/"`) || !strings.Contains(string(index), `href="/servers/vbr02/"`) {
+		t.Errorf("index doesn't link to every server:\n%s", index)
+	}
+
+	for i, name := range []string{"vbr01", "vbr02"} {
+		response, err := http.Get(server.URL + "/servers/" + name + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status cycleStatus
+		err = json.NewDecoder(response.Body).Decode(&status)
+		response.Body.Close()
+		if err != nil || status.Unreachable != (i == 1) {
+			t.Errorf("%s: got status %+v and error %v from another server", name, status, err)
+		}
+	}
+
+	response, err = http.Post(server.URL+"/check", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		t.Errorf("got status %d for a check of every server", response.StatusCode)
+	}
+	for _, m := range g.Monitors {
+		if len(m.checkRequestQueue()) != 1 {
+			t.Errorf("%s: no check queued", m.Config.serverName)
+		}
+	}
+	if response, err = http.Get(server.URL + "/check"); err == nil {
+		response.Body.Close()
+		if response.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("got status %d for GET /check", response.StatusCode)
+		}
+	}
+}
+
+func TestMonitorGroupStop(t *testing.T) {
+	cycles, release := make(chan struct{}), make(chan struct{})
+	close(release)
+	g := newTestMonitorGroup("vbr01", "vbr02")
+	for _, m := range g.Monitors {
+		m.Config.CheckIntervalMinutes = 60
+		m.Runner = cycleRunner(cycles, release)
+	}
+	done := make(chan error)
+	go func() { done <- g.Run() }()
+	waitForCycle(t, cycles)
+	waitForCycle(t, cycles)
+
+	g.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after Stop")
+	}
+}
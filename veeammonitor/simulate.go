@@ -0,0 +1,249 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// SimulatedJob describes one job of a simulated dataset. Times are relative
+// to the moment each cycle runs so fixtures never go out of date.
+type SimulatedJob struct {
+	Name              string  `json:"name"`
+	JobType           string  `json:"jobType"`           // Display type, e.g. Backup or Backup Copy
+	Status            string  `json:"status"`            // Success, Warning, Failed or Running
+	Description       string  `json:"description"`       // Failure reason or job description
+	RunningMinutes    float64 `json:"runningMinutes"`    // How long a Running job has been running
+	HoursSinceLastRun float64 `json:"hoursSinceLastRun"` // Age of the last run, -1 if the job never ran
+}
+
+// SimulatedDataset is the JSON fixture format accepted by LoadSimulatedDataset
+type SimulatedDataset struct {
+	Jobs         []SimulatedJob     `json:"jobs"`
+	Repositories []RepositoryStatus `json:"repositories"`
+}
+
+// LoadSimulatedDataset reads a simulated dataset from a JSON fixture file
+func LoadSimulatedDataset(filePath string) (*SimulatedDataset, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fixture file: %v", err)
+	}
+	var dataset SimulatedDataset
+	if err := json.Unmarshal(stripJSONComments(data), &dataset); err != nil {
+		return nil, fmt.Errorf("error parsing fixture file: %v", err)
+	}
+	return &dataset, nil
+}
+
+// SimulatedSource is a JobSource that needs no Veeam server, for demos,
+// training and integration tests. With a Dataset it replays the same jobs
+// every cycle; without one it generates a random mix of healthy, failed,
+// warning and running jobs each cycle.
+type SimulatedSource struct {
+	Config  *Config
+	Dataset *SimulatedDataset // Fixed data, nil to randomize every cycle
+
+	rand    *rand.Rand
+	now     time.Time
+	current SimulatedDataset
+}
+
+// NewSimulatedSource creates a simulated source, randomized when dataset is nil
+func NewSimulatedSource(config *Config, dataset *SimulatedDataset) *SimulatedSource {
+	return &SimulatedSource{
+		Config:  config,
+		Dataset: dataset,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Job names used for randomly generated data
+var simulatedJobNames = []struct{ name, jobType string }{
+	{"SQL-Production", "Backup"},
+	{"Exchange-Daily", "Backup"},
+	{"FileServer-01", "Backup"},
+	{"DomainControllers", "Backup"},
+	{"Web-Farm", "Backup"},
+	{"Offsite-Copy", "Backup Copy"},
+	{"Weekly-Tape", "Tape"},
+	{"Laptops", "Agent"},
+}
+
+// Failure and warning reasons used for randomly generated data
+var simulatedReasons = map[string][]string{
+	"Failed": {
+		"Error: Failed to connect to the host",
+		"Error: Not enough storage space on the target repository",
+		"Error: Snapshot creation failed",
+	},
+	"Warning": {
+		"Warning: VSS snapshot already exists, retried OK",
+		"Warning: Changed block tracking was reset",
+		"Warning: Low free space on the datastore",
+	},
+}
+
+// StartCycle takes the snapshot every query of this cycle reports on
+func (s *SimulatedSource) StartCycle() {
+	s.now = time.Now()
+	if s.Dataset != nil {
+		s.current = *s.Dataset
+		return
+	}
+	s.current = s.randomDataset()
+}
+
+// Generate a random mix of job results and repository usage
+func (s *SimulatedSource) randomDataset() SimulatedDataset {
+	if s.rand == nil {
+		s.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	var dataset SimulatedDataset
+	for _, definition := range simulatedJobNames {
+		job := SimulatedJob{
+			Name:              definition.name,
+			JobType:           definition.jobType,
+			Status:            "Success",
+			HoursSinceLastRun: s.rand.Float64() * 24,
+		}
+		switch roll := s.rand.Intn(100); {
+		case roll < 15:
+			job.Status = "Failed"
+		case roll < 35:
+			job.Status = "Warning"
+		case roll < 50:
+			job.Status = "Running"
+			job.RunningMinutes = 10 + s.rand.Float64()*240
+		case roll < 55:
+			// Overdue, so the stale check has something to find
+			job.HoursSinceLastRun = 48 + s.rand.Float64()*72
+		}
+		if reasons := simulatedReasons[job.Status]; len(reasons) > 0 {
+			job.Description = reasons[s.rand.Intn(len(reasons))]
+		}
+		dataset.Jobs = append(dataset.Jobs, job)
+	}
+
+	const gb = 1024 * 1024 * 1024
+	for _, name := range []string{"Default Backup Repository", "NAS-01", "Offsite-S3"} {
+		total := int64(2000+s.rand.Intn(8000)) * gb
+		dataset.Repositories = append(dataset.Repositories, RepositoryStatus{
+			Name:       name,
+			TotalBytes: total,
+			FreeBytes:  total * int64(2+s.rand.Intn(60)) / 100,
+		})
+	}
+	return dataset
+}
+
+// Make sure a snapshot exists for callers that don't start cycles
+func (s *SimulatedSource) snapshot() SimulatedDataset {
+	if s.now.IsZero() {
+		s.StartCycle()
+	}
+	return s.current
+}
+
+// Format a time the given number of minutes before the snapshot
+func (s *SimulatedSource) minutesAgo(minutes float64) string {
+	return s.now.Add(-time.Duration(minutes * float64(time.Minute))).In(s.Config.location()).Format(displayTimeLayout)
+}
+
+func (s *SimulatedSource) JobsByStatus(status string) ([]JobStatus, error) {
+	var jobs []JobStatus
+	for _, job := range s.snapshot().Jobs {
+		if job.Status != status {
+			continue
+		}
+		jobs = append(jobs, JobStatus{
+			Name:        job.Name,
+			Status:      job.Status,
+			StartTime:   s.minutesAgo(job.HoursSinceLastRun*60 + 30),
+			EndTime:     s.minutesAgo(job.HoursSinceLastRun * 60),
+			Description: job.Description,
+			JobType:     job.JobType,
+		})
+	}
+	return jobs, nil
+}
+
+func (s *SimulatedSource) LongRunningJobs() ([]JobStatus, error) {
+	var jobs []JobStatus
+	for _, job := range s.snapshot().Jobs {
+		if job.Status != "Running" || job.RunningMinutes <= float64(s.Config.longRunningMinutes()) {
+			continue
+		}
+		jobs = append(jobs, JobStatus{
+			Name:      job.Name,
+			Status:    "Running",
+			StartTime: s.minutesAgo(job.RunningMinutes),
+			EndTime:   "N/A",
+			Description: fmt.Sprintf("Long-running job (over %d minutes): Currently running",
+				s.Config.LongRunningThreshold),
+			Duration: strconv.FormatFloat(job.RunningMinutes, 'f', 2, 64),
+			JobType:  job.JobType,
+		})
+	}
+	return jobs, nil
+}
+
+func (s *SimulatedSource) StaleJobs() ([]JobStatus, error) {
+	var jobs []JobStatus
+	for _, job := range s.snapshot().Jobs {
+		status := JobStatus{
+			Name:        job.Name,
+			Status:      "Stale",
+			Description: job.Description,
+			Duration:    strconv.FormatFloat(job.HoursSinceLastRun, 'f', 2, 64),
+			JobType:     job.JobType,
+		}
+		if job.HoursSinceLastRun >= 0 {
+			status.StartTime = s.minutesAgo(job.HoursSinceLastRun*60 + 30)
+			status.EndTime = s.minutesAgo(job.HoursSinceLastRun * 60)
+		}
+		jobs = append(jobs, status)
+	}
+	return staleJobs(jobs, s.Config.MaxJobAgeHours), nil
+}
+
+func (s *SimulatedSource) Repositories() ([]RepositoryStatus, error) {
+	return s.snapshot().Repositories, nil
+}
+
+func (s *SimulatedSource) AllJobs() ([]JobStatus, error) {
+	var jobs []JobStatus
+	for _, job := range s.snapshot().Jobs {
+		jobs = append(jobs, JobStatus{Name: job.Name, JobType: job.JobType})
+	}
+	return jobs, nil
+}
+
+// Sessions lists each job's last run, when it started since the given time
+func (s *SimulatedSource) Sessions(since time.Time) ([]JobStatus, error) {
+	var sessions []JobStatus
+	for _, job := range s.snapshot().Jobs {
+		session := JobStatus{Name: job.Name, Status: job.Status, JobType: job.JobType}
+		var started time.Time
+		switch {
+		case job.Status == "Running":
+			started = s.now.Add(-time.Duration(job.RunningMinutes * float64(time.Minute)))
+			session.StartTime = s.minutesAgo(job.RunningMinutes)
+		case job.HoursSinceLastRun >= 0:
+			started = s.now.Add(-time.Duration((job.HoursSinceLastRun*60 + 30) * float64(time.Minute)))
+			session.StartTime = s.minutesAgo(job.HoursSinceLastRun*60 + 30)
+			session.EndTime = s.minutesAgo(job.HoursSinceLastRun * 60)
+			session.Duration = "30"
+		default:
+			continue
+		}
+		if !started.Before(since) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
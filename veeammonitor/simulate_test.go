@@ -0,0 +1,62 @@
+package veeammonitor
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSimulatedCycleAlerts(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "fixture.json")
+	err := ioutil.WriteFile(fixture, []byte(`{
+		// Comments are allowed, as in config files
+		"jobs": [
+			{"name": "SQL-Production", "jobType": "Backup", "status": "Failed", "description": "Disk full", "hoursSinceLastRun": 2},
+			{"name": "Exchange-Daily", "jobType": "Backup", "status": "Warning", "hoursSinceLastRun": 3},
+			{"name": "FileServer-01", "jobType": "Backup", "status": "Success", "hoursSinceLastRun": 1},
+			{"name": "Offsite-Copy", "jobType": "Backup Copy", "status": "Running", "runningMinutes": 300, "hoursSinceLastRun": 20}
+		],
+		"repositories": [
+			{"name": "Main", "totalBytes": 1000, "freeBytes": 20},
+			{"name": "Archive", "totalBytes": 1000, "freeBytes": 800}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataset, err := LoadSimulatedDataset(fixture)
+	if err != nil {
+		t.Fatalf("loading fixture: %v", err)
+	}
+
+	config := testConfig()
+	config.MonitorWarningJobs = true
+	config.MonitorRunningJobs = true
+	config.MonitorRepositories = true
+	m, capture := newCaptureMonitor(config, nil)
+	m.Source = NewSimulatedSource(config, dataset)
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d alerts, want 1", len(sent))
+	}
+	report := sent[0]
+	if report.Counts.Failed != 1 || report.Counts.Warning != 1 || report.Counts.Running != 1 || report.Counts.Repositories != 1 {
+		t.Errorf("got counts %+v, want one failed, warning, long-running job and repository", report.Counts)
+	}
+	if report.Failed[0].Name != "SQL-Production" || report.Failed[0].Description != "Disk full" || report.Repositories[0].Name != "Main" {
+		t.Errorf("got failed %+v and repositories %+v", report.Failed, report.Repositories)
+	}
+}
+
+func TestSimulatedRandomDataset(t *testing.T) {
+	source := NewSimulatedSource(testConfig(), nil)
+	for i := 0; i < 20; i++ {
+		source.StartCycle()
+		jobs, _ := source.AllJobs()
+		if len(jobs) != len(simulatedJobNames) {
+			t.Fatalf("cycle %d listed %d jobs, want %d", i, len(jobs), len(simulatedJobNames))
+		}
+	}
+}
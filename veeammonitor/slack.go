@@ -0,0 +1,234 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Slack message limits
+const (
+	slackMaxBlocks      = 50    // Blocks per message, attachments included
+	slackMaxSectionText = 3000  // Characters of a section block's text
+	slackMaxHeaderText  = 150   // Characters of a header block
+	slackMaxText        = 40000 // Characters of message text, used by report templates
+)
+
+// Message posted to a Slack incoming webhook. Text is the notification
+// fallback; the alert itself is in the blocks and attachments.
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text"`
+	Blocks      []slackBlock      `json:"blocks,omitempty"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+// Colored bar of blocks, one per group of problems
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"` // header, section or context
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"` // plain_text or mrkdwn
+	Text string `json:"text"`
+}
+
+// Characters Slack reads as markup in message text
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Section block of mrkdwn text, escaped and cut to Slack's limit
+func slackSection(text string) slackBlock {
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: truncateRunes(slackEscaper.Replace(text), slackMaxSectionText)}}
+}
+
+// Attachment color for a severity, matching the Discord embed colors
+func slackColor(severity string) string {
+	return fmt.Sprintf("#%06X", discordColor(severity))
+}
+
+// Problems of one status posted as one attachment
+type slackGroup struct {
+	color  string
+	blocks []slackBlock
+}
+
+// One group per status with problems, in the order of the email sections,
+// and one for low-space repositories. Failed and warning groups start with
+// their common failure causes.
+func slackGroups(report *AlertReport, config *Config) []slackGroup {
+	var groups []slackGroup
+	for _, section := range report.statusSections() {
+		group := slackGroup{color: slackColor(config.statusSeverity(section.status))}
+		group.blocks = append(group.blocks, slackSection(fmt.Sprintf("*%s (%d)*", section.title, len(section.jobs)+section.omitted)))
+		for _, cause := range report.Causes {
+			if cause.Status == section.status {
+				group.blocks = append(group.blocks, slackSection(fmt.Sprintf("*%s*\n%s", cause.summary(config), cause.jobList())))
+			}
+		}
+
+		jobs := report.withoutCauses(section.jobs)
+		if config.compact() {
+			var lines []string
+			for _, job := range jobs {
+				lines = append(lines, groupedJobName(job, report, config, compactJobLine(job, config, report.Timestamp)))
+			}
+			group.blocks = append(group.blocks, slackLineSections(lines)...)
+		} else {
+			for _, job := range jobs {
+				group.blocks = append(group.blocks, slackSection(slackJobText(job, report, config)))
+			}
+		}
+		if line := omittedLine(section.omitted, section.label, report.omittedHint, "%s"); line != "" {
+			group.blocks = append(group.blocks, slackBlock{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: slackEscaper.Replace(line)}}})
+		}
+		groups = append(groups, group)
+	}
+
+	if len(report.Repositories) > 0 {
+		group := slackGroup{color: slackColor(config.statusSeverity(repositoryStatus))}
+		group.blocks = append(group.blocks, slackSection(fmt.Sprintf("*Repositories low on space (%d)*", len(report.Repositories))))
+		var lines []string
+		for _, repo := range report.Repositories {
+			lines = append(lines, compactRepositoryLine(repo))
+		}
+		group.blocks = append(group.blocks, slackLineSections(lines)...)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Fields of a job in the verbose format
+func slackJobText(job JobStatus, report *AlertReport, config *Config) string {
+	return fmt.Sprintf("*%s*\n*Status:* %s\n%s%s*Type:* %s\n*Start:* %s\n*End:* %s\n%s%s%s",
+		groupedJobName(job, report, config, job.Name), displayStatus(job), escalationLine(job, "*Escalated:* failed %d checks in a row\n"),
+		failingSinceLine(job, "*Failing Since:* %s\n"),
+		job.JobType, job.StartTime, job.EndTime, config.nextRunLine(job, "*Next Run:* %s\n"), noteLine(job, "*Note:* %s\n"),
+		config.displayDescription(job.Description))
+}
+
+// Lines joined into as few section blocks as Slack's text limit allows
+func slackLineSections(lines []string) []slackBlock {
+	var blocks []slackBlock
+	text := ""
+	for _, line := range lines {
+		line = truncateRunes(line, slackMaxSectionText)
+		if text != "" && len([]rune(slackEscaper.Replace(text+"\n"+line))) > slackMaxSectionText {
+			blocks = append(blocks, slackSection(text))
+			text = ""
+		}
+		if text != "" {
+			text += "\n"
+		}
+		text += line
+	}
+	if text != "" {
+		blocks = append(blocks, slackSection(text))
+	}
+	return blocks
+}
+
+// Build the messages for an alert: a header with the alert's title and
+// footer, then a colored attachment per status. Alerts with more blocks than
+// Slack allows are split across messages, continuing a group's attachment in
+// the next message.
+func buildSlackMessages(report *AlertReport, config *Config) []slackMessage {
+	title := alertTitle(report)
+	header := 1
+	footer := alertFooter(report)
+	if footer != "" {
+		header++
+	}
+
+	var messages []slackMessage
+	var message slackMessage
+	blocks := header
+	for _, group := range slackGroups(report, config) {
+		for i, block := range group.blocks {
+			if blocks == slackMaxBlocks {
+				messages = append(messages, message)
+				message, blocks = slackMessage{}, header
+			}
+			if i == 0 || len(message.Attachments) == 0 {
+				message.Attachments = append(message.Attachments, slackAttachment{Color: group.color})
+			}
+			last := &message.Attachments[len(message.Attachments)-1]
+			last.Blocks = append(last.Blocks, block)
+			blocks++
+		}
+	}
+	messages = append(messages, message)
+
+	// Number the messages when the alert is split
+	for i := range messages {
+		text := title
+		if len(messages) > 1 {
+			text += fmt.Sprintf(" (%d/%d)", i+1, len(messages))
+		}
+		messages[i].Channel = config.SlackChannel
+		messages[i].Text = slackEscaper.Replace(text)
+		messages[i].Blocks = []slackBlock{{Type: "header", Text: &slackText{Type: "plain_text", Text: truncateRunes(text, slackMaxHeaderText)}}}
+		if footer != "" {
+			messages[i].Blocks = append(messages[i].Blocks, slackBlock{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: slackEscaper.Replace(footer)}}})
+		}
+	}
+	return messages
+}
+
+// Post an alert to the Slack webhook, split across as many messages as needed
+func sendSlackAlert(ctx context.Context, httpClient *http.Client, report *AlertReport, config *Config) error {
+	if name := config.channelTemplate("slack"); name != "" {
+		text, err := renderReportTemplate(report, config, name)
+		if err == nil {
+			return postSlackMessage(ctx, httpClient, config.SlackWebhookURL, slackMessage{Channel: config.SlackChannel, Text: truncateRunes(text, slackMaxText)})
+		}
+		log.Printf("Error rendering report template %s, sending the standard Slack alert: %v\n", name, err)
+	}
+	for i, message := range buildSlackMessages(report, config) {
+		if err := postSlackMessage(ctx, httpClient, config.SlackWebhookURL, message); err != nil {
+			return fmt.Errorf("error sending Slack message %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Post a single message to a Slack webhook. Slack answers errors such as
+// invalid_blocks or channel_not_found in the body, which is included.
+func postSlackMessage(ctx context.Context, httpClient *http.Client, webhookURL string, message slackMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return redactURLError(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return redactURLError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		if reason := strings.TrimSpace(string(body)); reason != "" {
+			return fmt.Errorf("Slack returned status %s: %s", resp.Status, reason)
+		}
+		return fmt.Errorf("Slack returned status %s", resp.Status)
+	}
+	return nil
+}
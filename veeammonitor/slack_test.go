@@ -0,0 +1,139 @@
+package veeammonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stand-in Slack webhook collecting the messages posted to it and answering
+// with status and reply
+func newSlackServer(t *testing.T, status int, reply string) (string, func() []slackMessage) {
+	var mu sync.Mutex
+	var messages []slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message slackMessage
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("decoding message: %v", err)
+		}
+		mu.Lock()
+		messages = append(messages, message)
+		mu.Unlock()
+		w.WriteHeader(status)
+		fmt.Fprint(w, reply)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() []slackMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]slackMessage{}, messages...)
+	}
+}
+
+func TestSlackAlertMessage(t *testing.T) {
+	url, received := newSlackServer(t, http.StatusOK, "ok")
+	config := testConfig()
+	config.SlackWebhookURL = url
+	config.SlackChannel = "#backups"
+	jobs := []JobStatus{
+		{Name: "Nightly <prod>", JobType: "Backup", Status: "Failed", Description: "Disk full & more"},
+		{Name: "Files", JobType: "Backup", Status: "Warning"},
+	}
+	repos := []RepositoryStatus{{Name: "Main", TotalBytes: 100 << 30, FreeBytes: 2 << 30}}
+	report := NewAlertReport(jobs, repos, severityCritical, config, time.Now())
+
+	if err := sendSlackAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+		t.Fatal(err)
+	}
+	messages := received()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	message := messages[0]
+	if message.Channel != "#backups" || message.Text == "" || message.Blocks[0].Type != "header" || message.Blocks[0].Text.Text != alertTitle(report) {
+		t.Errorf("got message %+v", message)
+	}
+	if len(message.Attachments) != 3 {
+		t.Fatalf("got %d attachments, want failed, warning and repositories", len(message.Attachments))
+	}
+	failed := message.Attachments[0]
+	if failed.Color != "#E74C3C" || !strings.HasPrefix(failed.Blocks[0].Text.Text, "*Failed") {
+		t.Errorf("got failed attachment %+v", failed)
+	}
+	if text := failed.Blocks[1].Text.Text; !strings.Contains(text, "*Nightly &lt;prod&gt;*") || !strings.Contains(text, "Disk full &amp; more") {
+		t.Errorf("job text not escaped: %q", text)
+	}
+	if warning := message.Attachments[1]; warning.Color != "#F39C12" {
+		t.Errorf("got warning color %s", warning.Color)
+	}
+	if repo := message.Attachments[2].Blocks; len(repo) != 2 || !strings.Contains(repo[1].Text.Text, "Main") {
+		t.Errorf("got repository blocks %+v", repo)
+	}
+}
+
+func TestSlackAlertSplitsLargeReports(t *testing.T) {
+	url, received := newSlackServer(t, http.StatusOK, "ok")
+	config := testConfig()
+	config.SlackWebhookURL = url
+	var jobs []JobStatus
+	for i := 0; i < 120; i++ {
+		jobs = append(jobs, JobStatus{Name: fmt.Sprintf("Job %03d", i), JobType: "Backup", Status: "Failed"})
+	}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+
+	if err := sendSlackAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+		t.Fatal(err)
+	}
+	messages := received()
+	if len(messages) < 3 {
+		t.Fatalf("got %d messages, want the alert split", len(messages))
+	}
+	jobBlocks := 0
+	for i, message := range messages {
+		blocks := len(message.Blocks)
+		for _, attachment := range message.Attachments {
+			blocks += len(attachment.Blocks)
+			jobBlocks += len(attachment.Blocks)
+		}
+		if blocks > slackMaxBlocks {
+			t.Errorf("message %d has %d blocks", i+1, blocks)
+		}
+		if want := fmt.Sprintf("(%d/%d)", i+1, len(messages)); !strings.HasSuffix(message.Text, want) {
+			t.Errorf("message %d is titled %q, want it numbered %s", i+1, message.Text, want)
+		}
+	}
+	if jobBlocks != len(jobs)+1 {
+		t.Errorf("sent %d blocks, want one per job and the section heading", jobBlocks)
+	}
+}
+
+func TestSlackLineSections(t *testing.T) {
+	lines := []string{strings.Repeat("a", 2000), strings.Repeat("b", 2000), "c <d>"}
+	blocks := slackLineSections(lines)
+	if len(blocks) != 2 || blocks[1].Text.Text != strings.Repeat("b", 2000)+"\nc &lt;d&gt;" {
+		t.Errorf("got %d blocks, want lines joined up to the section limit", len(blocks))
+	}
+	for _, block := range blocks {
+		if len([]rune(block.Text.Text)) > slackMaxSectionText {
+			t.Errorf("block has %d characters", len([]rune(block.Text.Text)))
+		}
+	}
+}
+
+func TestSlackError(t *testing.T) {
+	url, _ := newSlackServer(t, http.StatusBadRequest, "invalid_blocks\n")
+	config := testConfig()
+	config.SlackWebhookURL = url
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", Status: "Failed"}}, nil, severityCritical, config, time.Now())
+
+	err := sendSlackAlert(context.Background(), http.DefaultClient, report, config)
+	if err == nil || err.Error() != "error sending Slack message 1: Slack returned status 400 Bad Request: invalid_blocks" {
+		t.Errorf("got error %v", err)
+	}
+}
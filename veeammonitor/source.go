@@ -0,0 +1,94 @@
+package veeammonitor
+
+import "time"
+
+// JobSource supplies job and repository statuses to a Monitor. The default
+// source queries Veeam through PowerShell; SimulatedSource generates
+// synthetic data instead.
+type JobSource interface {
+	JobsByStatus(status string) ([]JobStatus, error) // Jobs whose last result was status (Failed, Warning)
+	LongRunningJobs() ([]JobStatus, error)           // Running jobs over LongRunningThreshold
+	StaleJobs() ([]JobStatus, error)                 // Jobs that haven't run within MaxJobAgeHours
+	Repositories() ([]RepositoryStatus, error)       // All repositories and scale-out extents
+}
+
+// CycleStarter is implemented by sources that take a snapshot once per
+// monitoring cycle, so every query in the cycle sees consistent data
+type CycleStarter interface {
+	StartCycle()
+}
+
+// JobLister is implemented by sources that can list every job, healthy or
+// not. It is used to tell deleted jobs apart from healthy ones.
+type JobLister interface {
+	AllJobs() ([]JobStatus, error)
+}
+
+// SessionLister is implemented by sources that can list the sessions every
+// job ran in a period, for the digest email
+type SessionLister interface {
+	// Sessions started since a time, with the session's result as Status and
+	// its length in minutes as Duration, empty while it is running
+	Sessions(since time.Time) ([]JobStatus, error)
+}
+
+// Source used for this cycle, PowerShell through Runner unless Source is set
+func (m *Monitor) source() JobSource {
+	if m.Source != nil {
+		return m.Source
+	}
+	return powerShellSource{m}
+}
+
+// Queries Veeam by running PowerShell scripts through the monitor's Runner
+type powerShellSource struct {
+	m *Monitor
+}
+
+// StartCycle brings the job cache up to date when IncrementalQueries is on
+func (s powerShellSource) StartCycle() {
+	config := s.m.Config
+	if config.IncrementalQueries && (config.MonitorFailedJobs || config.MonitorWarningJobs) {
+		s.m.refreshJobCache(time.Now())
+	}
+}
+
+func (s powerShellSource) JobsByStatus(status string) ([]JobStatus, error) {
+	return s.m.getJobsByStatus(status)
+}
+
+func (s powerShellSource) LongRunningJobs() ([]JobStatus, error) {
+	return s.m.getLongRunningJobs()
+}
+
+func (s powerShellSource) StuckSessions() ([]JobStatus, error) {
+	return s.m.getStuckSessions()
+}
+
+func (s powerShellSource) StaleJobs() ([]JobStatus, error) {
+	return s.m.getStaleJobs()
+}
+
+func (s powerShellSource) Repositories() ([]RepositoryStatus, error) {
+	return s.m.getRepositoryStatuses()
+}
+
+func (s powerShellSource) AllJobs() ([]JobStatus, error) {
+	return s.m.getAllJobs()
+}
+
+func (s powerShellSource) Sessions(since time.Time) ([]JobStatus, error) {
+	return s.m.getSessions(since)
+}
+
+func (s powerShellSource) FindJob(name string) ([]JobStatus, error) {
+	return s.m.findJob(name)
+}
+
+func (s powerShellSource) JobSchedules() ([]JobSchedule, error) {
+	return s.m.getJobSchedules()
+}
+
+func (s powerShellSource) BackupSizes() ([]BackupSize, error) {
+	return s.m.getBackupSizes()
+}
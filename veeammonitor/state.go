@@ -0,0 +1,304 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// State tracked between monitoring cycles. It is saved to StateFile after
+// every cycle so alert history survives restarts.
+type monitorState struct {
+	ServerUnreachable  bool                      `json:"serverUnreachable"`            // An unreachable alert has been sent and not yet resolved
+	PagerDutyIncidents map[string]bool           `json:"pagerDutyIncidents,omitempty"` // Dedup keys of open PagerDuty incidents
+	OpsgenieAlerts     map[string]bool           `json:"opsgenieAlerts,omitempty"`     // Aliases of open Opsgenie alerts
+	Jobs               map[string]*jobAlertState `json:"jobs,omitempty"`               // Alert history keyed by jobIdentity
+	SizeBaselines      map[string][]sizeSample   `json:"sizeBaselines,omitempty"`      // Recent session sizes keyed by jobIdentity
+	Acks               map[string]*jobAck        `json:"acks,omitempty"`               // Acknowledged jobs keyed by jobIdentity
+	Notes              map[string]*jobNote       `json:"notes,omitempty"`              // Job notes keyed by lowercase job name
+}
+
+// Alert history of a single job
+type jobAlertState struct {
+	Name        string    `json:"name"`
+	JobType     string    `json:"jobType"`
+	Status      string    `json:"status"` // Statuses when last alerted, comma separated, empty once the job recovers
+	LastAlerted time.Time `json:"lastAlerted"`
+	LastSeen    time.Time `json:"lastSeen"`              // Last time the job existed in Veeam
+	LastSession string    `json:"lastSession,omitempty"` // End time of the failed or warning session last alerted
+
+	ConsecutiveFailures int  `json:"consecutiveFailures,omitempty"` // Checks in a row the job was found failed
+	Escalated           bool `json:"escalated,omitempty"`           // The last alert escalated the job
+}
+
+// Stable identity of a job across cycles and restarts
+func jobIdentity(job JobStatus) string {
+	return strings.ToLower(job.JobType + "/" + job.Name)
+}
+
+// Load the persisted state, returning an empty state when the file doesn't exist yet
+func loadState(filePath string) (monitorState, error) {
+	var state monitorState
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("error reading state file: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("error parsing state file: %v", err)
+	}
+	return state, nil
+}
+
+// Write the state to StateFile. The file is replaced atomically so a crash
+// mid-write can't leave it truncated.
+func (m *Monitor) saveState() {
+	if m.Config.StateFile == "" {
+		return
+	}
+
+	m.ackMu.Lock()
+	data, err := json.MarshalIndent(m.state, "", "    ")
+	m.ackMu.Unlock()
+	if err != nil {
+		m.logf("Error encoding state: %v\n", err)
+		return
+	}
+	if err := writeFileAtomic(m.Config.StateFile, data); err != nil {
+		m.logf("Error writing state file: %v\n", err)
+	}
+}
+
+// Minimum time between repeat alerts for a job. An exact name in
+// JobCooldownMinutes wins over a wildcard pattern, which wins over CooldownMinutes.
+func (c *Config) jobCooldown(name string) time.Duration {
+	minutes := c.CooldownMinutes
+	patterns := make([]string, 0, len(c.JobCooldownMinutes))
+	for pattern := range c.JobCooldownMinutes {
+		patterns = append(patterns, pattern)
+	}
+	if pattern, ok := matchJobName(patterns, name); ok {
+		minutes = c.JobCooldownMinutes[pattern]
+	}
+
+	if minutes < 0 {
+		minutes = 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// Find the entry matching a job name, case-insensitively. An exact name wins
+// over a wildcard pattern; overlapping patterns are tried in sorted order so
+// they always resolve the same way.
+func matchJobName(patterns []string, name string) (string, bool) {
+	for _, pattern := range patterns {
+		if strings.EqualFold(pattern, name) {
+			return pattern, true
+		}
+	}
+	sorted := append([]string(nil), patterns...)
+	sort.Strings(sorted)
+	for _, pattern := range sorted {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// The session a failed or warning job's alert is about, told apart by its
+// end time. Empty for other statuses, which carry on from check to check.
+func alertSession(job JobStatus) string {
+	if job.Status == "Failed" || job.Status == "Warning" {
+		return job.EndTime
+	}
+	return ""
+}
+
+// A job's problems in one check. A job can be reported more than once in a
+// check, e.g. Failed and also Drift, and its alert history covers them
+// together so neither entry makes the other look like a status change.
+type jobProblems struct {
+	Status    string // Every status, sorted and comma separated
+	Session   string // alertSession of the failed or warning entry
+	Escalated bool
+}
+
+// Problems of each job among jobs, keyed by jobIdentity
+func problemsByJob(jobs []JobStatus) map[string]*jobProblems {
+	statuses := map[string][]string{}
+	problems := map[string]*jobProblems{}
+	for _, job := range jobs {
+		key := jobIdentity(job)
+		problem := problems[key]
+		if problem == nil {
+			problem = &jobProblems{}
+			problems[key] = problem
+		}
+		if !containsString(statuses[key], job.Status) {
+			statuses[key] = append(statuses[key], job.Status)
+		}
+		if session := alertSession(job); session != "" {
+			problem.Session = session
+		}
+		problem.Escalated = problem.Escalated || job.Escalated
+	}
+	for key, list := range statuses {
+		sort.Strings(list)
+		problems[key].Status = strings.Join(list, ", ")
+	}
+	return problems
+}
+
+// Whether list holds value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Split problematic jobs into those due an alert and the number still in
+// their cooldown. A job is due when it has never been alerted, its statuses
+// changed since the last alert, a new session failed or ended with
+// warnings, it has just been escalated, or its cooldown has elapsed as a
+// reminder. All of a job's entries are due or suppressed together.
+func (m *Monitor) jobsDueForAlert(jobs []JobStatus, now time.Time) ([]JobStatus, int) {
+	problems := problemsByJob(jobs)
+	var due []JobStatus
+	suppressed := 0
+	for _, job := range jobs {
+		problem := problems[jobIdentity(job)]
+		previous := m.state.Jobs[jobIdentity(job)]
+		// Entries saved before sessions were tracked have none
+		sameSession := previous != nil && (previous.LastSession == "" || previous.LastSession == problem.Session)
+		// A cooldown of 0 turns reminders off rather than repeating the alert
+		cooldown := m.Config.jobCooldown(job.Name)
+		remind := previous != nil && cooldown > 0 && now.Sub(previous.LastAlerted) >= cooldown
+		if previous != nil && previous.Status == problem.Status && sameSession && (!problem.Escalated || previous.Escalated) && !remind {
+			suppressed++
+			continue
+		}
+		due = append(due, job)
+	}
+	return due, suppressed
+}
+
+// Record that an alert was sent for the given jobs
+func (m *Monitor) recordAlerted(jobs []JobStatus, now time.Time) {
+	if m.state.Jobs == nil {
+		m.state.Jobs = map[string]*jobAlertState{}
+	}
+	problems := problemsByJob(jobs)
+	for _, job := range jobs {
+		key := jobIdentity(job)
+		entry := m.state.Jobs[key]
+		if entry == nil {
+			entry = &jobAlertState{}
+			m.state.Jobs[key] = entry
+		}
+		problem := problems[key]
+		entry.Name, entry.JobType, entry.Status = job.Name, job.JobType, problem.Status
+		entry.LastAlerted, entry.LastSeen = now, now
+		entry.LastSession = problem.Session
+		entry.Escalated = problem.Escalated
+	}
+}
+
+// Record the problems of the first check after startup as alerted without
+// sending anything, for SuppressInitialAlerts, so a restart doesn't repeat
+// alerts for problems that were already known
+func (m *Monitor) recordInitialProblems(jobs []JobStatus, repos []RepositoryStatus, now time.Time) {
+	m.logf("First check since startup, recording %d problematic jobs and %d repositories low on space without alerting\n", len(jobs), len(repos))
+	for _, job := range jobs {
+		m.logf("Would have alerted: %s (%s) is %s\n", job.Name, job.JobType, job.Status)
+	}
+	for _, repo := range repos {
+		m.logf("Would have alerted: repository %s is low on free space\n", repo.DisplayName())
+	}
+	m.recordAlerted(jobs, now)
+}
+
+// Mark jobs that are no longer problematic as recovered, so a new failure
+// alerts straight away instead of waiting out the cooldown. Returns the
+// jobs that had been alerted, as they were before recovering, by name.
+func (m *Monitor) recordRecovered(problematicJobs []JobStatus, now time.Time) []jobAlertState {
+	current := map[string]bool{}
+	for _, job := range problematicJobs {
+		current[jobIdentity(job)] = true
+	}
+	var recovered []jobAlertState
+	for key, job := range m.state.Jobs {
+		if current[key] {
+			job.LastSeen = now
+			continue
+		}
+		if job.Status != "" && !job.LastAlerted.IsZero() {
+			recovered = append(recovered, *job)
+		}
+		job.Status = ""
+		job.LastSession = ""
+		job.ConsecutiveFailures = 0
+		job.Escalated = false
+	}
+	sort.Slice(recovered, func(i, j int) bool {
+		return strings.ToLower(recovered[i].Name) < strings.ToLower(recovered[j].Name)
+	})
+	return recovered
+}
+
+// How often state entries are checked for deleted or renamed jobs
+const statePruneInterval = 24 * time.Hour
+
+// Drop alert history for jobs that no longer exist in Veeam, so renamed and
+// deleted jobs don't accumulate in the state file. Runs on the first cycle
+// and then daily. Jobs that still exist are kept however long they have been
+// healthy; an entry is only dropped once its job has been missing for
+// StateRetentionDays.
+func (m *Monitor) pruneState(source JobSource, now time.Time) {
+	if m.Config.StateRetentionDays <= 0 || len(m.state.Jobs) == 0 || now.Sub(m.lastPruned) < statePruneInterval {
+		return
+	}
+	lister, ok := source.(JobLister)
+	if !ok {
+		return
+	}
+
+	jobs, err := lister.AllJobs()
+	if err != nil {
+		// Without a job list every entry would look deleted
+		m.logf("Error listing jobs, not pruning state: %v\n", err)
+		return
+	}
+	m.lastPruned = now
+
+	existing := map[string]bool{}
+	for _, job := range jobs {
+		existing[jobIdentity(job)] = true
+	}
+
+	retention := time.Duration(m.Config.StateRetentionDays) * 24 * time.Hour
+	pruned := 0
+	for key, job := range m.state.Jobs {
+		if existing[key] {
+			job.LastSeen = now
+			continue
+		}
+		if now.Sub(job.LastSeen) >= retention {
+			delete(m.state.Jobs, key)
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		m.logf("Removed state for %d jobs not seen in Veeam for %d days\n", pruned, m.Config.StateRetentionDays)
+	}
+}
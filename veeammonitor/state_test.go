@@ -0,0 +1,232 @@
+package veeammonitor
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestJobCooldownsAreIndependent(t *testing.T) {
+	config := testConfig()
+	config.CooldownMinutes = 60
+	config.JobCooldownMinutes = map[string]int{"Nightly": 10, "Archive-*": 240}
+	m := newTestMonitor(config, nil)
+
+	nightly := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Running"}
+	archive := JobStatus{Name: "Archive-Weekly", JobType: "Backup", Status: "Running"}
+	other := JobStatus{Name: "Files", JobType: "Backup", Status: "Running"}
+	jobs := []JobStatus{nightly, archive, other}
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	m.recordAlerted(jobs, start)
+
+	tests := []struct {
+		after time.Duration
+		want  []string
+	}{
+		{5 * time.Minute, nil},
+		{10 * time.Minute, []string{"Nightly"}},
+		{90 * time.Minute, []string{"Nightly", "Files"}},
+		{4 * time.Hour, []string{"Nightly", "Archive-Weekly", "Files"}},
+	}
+	for _, test := range tests {
+		due, suppressed := m.jobsDueForAlert(jobs, start.Add(test.after))
+		if names := jobNames(due); !equalStrings(names, test.want) {
+			t.Errorf("after %v: due %v, want %v", test.after, names, test.want)
+		}
+		if suppressed != len(jobs)-len(test.want) {
+			t.Errorf("after %v: %d suppressed, want %d", test.after, suppressed, len(jobs)-len(test.want))
+		}
+	}
+}
+
+func TestJobWithTwoProblemsRespectsCooldown(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	jobs := []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "3/1/2024 1:20:00 AM"},
+		{Name: "Nightly", JobType: "Backup", Status: driftStatus},
+	}
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	due, _ := m.jobsDueForAlert(jobs, start)
+	if len(due) != 2 {
+		t.Fatalf("first check: %d entries due, want 2", len(due))
+	}
+	m.recordAlerted(due, start)
+
+	for _, order := range [][]JobStatus{jobs, {jobs[1], jobs[0]}} {
+		if due, suppressed := m.jobsDueForAlert(order, start.Add(time.Hour)); len(due) != 0 || suppressed != 2 {
+			t.Errorf("within the cooldown: %d due and %d suppressed, want 0 and 2", len(due), suppressed)
+		}
+	}
+
+	// Losing one of the problems is a change
+	if due, _ := m.jobsDueForAlert(jobs[:1], start.Add(time.Hour)); len(due) != 1 {
+		t.Errorf("after the drift cleared: %d entries due, want 1", len(due))
+	}
+	// So is a new failed session
+	next := []JobStatus{jobs[0], jobs[1]}
+	next[0].EndTime = "3/2/2024 1:20:00 AM"
+	if due, _ := m.jobsDueForAlert(next, start.Add(time.Hour)); len(due) != 2 {
+		t.Errorf("after a new failed session: %d entries due, want 2", len(due))
+	}
+}
+
+func TestAlertsDeduplicatedBySession(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "3/1/2024 1:20:00 AM"}
+	running := JobStatus{Name: "Archive", JobType: "Backup", Status: "Running", EndTime: "N/A"}
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	m.recordAlerted([]JobStatus{failed, running}, start)
+	if session := m.state.Jobs[jobIdentity(failed)].LastSession; session != failed.EndTime {
+		t.Errorf("recorded session %q, want the failed session's end time", session)
+	}
+
+	rerun, moved := failed, running
+	rerun.EndTime = "3/2/2024 1:20:00 AM"
+	moved.EndTime = "3/1/2024 9:00:00 AM"
+	tests := []struct {
+		name  string
+		jobs  []JobStatus
+		after time.Duration
+		want  []string
+	}{
+		{"same sessions", []JobStatus{failed, running}, time.Hour, nil},
+		{"new failed session", []JobStatus{rerun, moved}, time.Hour, []string{"Nightly"}},
+		{"reminder", []JobStatus{failed, running}, 6 * time.Hour, []string{"Nightly", "Archive"}},
+	}
+	for _, test := range tests {
+		due, _ := m.jobsDueForAlert(test.jobs, start.Add(test.after))
+		if names := jobNames(due); !equalStrings(names, test.want) {
+			t.Errorf("%s: due %v, want %v", test.name, names, test.want)
+		}
+	}
+
+	// State saved before sessions were tracked counts as the same session
+	m.state.Jobs[jobIdentity(failed)].LastSession = ""
+	if due, _ := m.jobsDueForAlert([]JobStatus{rerun}, start.Add(time.Hour)); len(due) != 0 {
+		t.Error("an entry without a session alerted again within the cooldown")
+	}
+}
+
+func TestZeroCooldownNeverReminds(t *testing.T) {
+	config := testConfig()
+	config.CooldownMinutes = 0
+	m := newTestMonitor(config, nil)
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "3/1/2024 1:20:00 AM"}
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	m.recordAlerted([]JobStatus{failed}, start)
+
+	if due, suppressed := m.jobsDueForAlert([]JobStatus{failed}, start.Add(30*24*time.Hour)); len(due) != 0 || suppressed != 1 {
+		t.Errorf("same session a month later: %d due and %d suppressed, want it suppressed", len(due), suppressed)
+	}
+	rerun := failed
+	rerun.EndTime = "3/2/2024 1:20:00 AM"
+	if due, _ := m.jobsDueForAlert([]JobStatus{rerun}, start.Add(15*time.Minute)); len(due) != 1 {
+		t.Error("a new failed session wasn't alerted with reminders off")
+	}
+}
+
+func TestRecordRecovered(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	failed := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed"}
+	warning := JobStatus{Name: "Files", JobType: "Backup", Status: "Warning"}
+	m.recordAlerted([]JobStatus{failed, warning}, start)
+
+	recovered := m.recordRecovered([]JobStatus{warning}, start.Add(time.Hour))
+	if len(recovered) != 1 || recovered[0].Name != "Nightly" || recovered[0].Status != "Failed" {
+		t.Fatalf("got recovered %+v, want Nightly as Failed", recovered)
+	}
+	// A new failure alerts straight away
+	if due, _ := m.jobsDueForAlert([]JobStatus{failed}, start.Add(2*time.Hour)); len(due) != 1 {
+		t.Error("new failure after recovery is still in its cooldown")
+	}
+}
+
+// Source whose job list can't be fetched
+type failingLister struct{ stubSource }
+
+func (s failingLister) AllJobs() ([]JobStatus, error) { return nil, errors.New("exit status 1") }
+
+func TestPruneState(t *testing.T) {
+	config := testConfig()
+	config.StateRetentionDays = 30
+	m := newTestMonitor(config, nil)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	m.recordAlerted([]JobStatus{{Name: "Nightly", JobType: "Backup"}}, start.Add(-60*24*time.Hour))
+	m.recordAlerted([]JobStatus{{Name: "Deleted", JobType: "Backup"}}, start.Add(-40*24*time.Hour))
+	m.recordAlerted([]JobStatus{{Name: "Renamed", JobType: "Backup"}}, start.Add(-10*24*time.Hour))
+	source := stubSource{[]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Success"}}}
+
+	// Healthy jobs that still exist are kept however long ago they were alerted
+	m.pruneState(source, start)
+	if got := stateJobNames(m); !equalStrings(got, []string{"Nightly", "Renamed"}) {
+		t.Errorf("after the first prune kept %v, want Nightly and Renamed", got)
+	}
+	if !m.state.Jobs["backup/nightly"].LastSeen.Equal(start) {
+		t.Errorf("existing job last seen %v, want %v", m.state.Jobs["backup/nightly"].LastSeen, start)
+	}
+
+	// Renamed has been missing for 30 days after 20 days, but pruning runs daily
+	day := 24 * time.Hour
+	m.pruneState(source, start.Add(19*day+12*time.Hour))
+	m.pruneState(source, start.Add(20*day+time.Hour))
+	if got := stateJobNames(m); !equalStrings(got, []string{"Nightly", "Renamed"}) {
+		t.Errorf("before the next daily prune kept %v", got)
+	}
+	m.pruneState(source, start.Add(20*day+12*time.Hour))
+	if got := stateJobNames(m); !equalStrings(got, []string{"Nightly"}) {
+		t.Errorf("after Renamed aged out kept %v, want Nightly", got)
+	}
+}
+
+func TestPruneStateKeepsEntriesWithoutJobList(t *testing.T) {
+	config := testConfig()
+	config.StateRetentionDays = 30
+	m := newTestMonitor(config, nil)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	m.recordAlerted([]JobStatus{{Name: "Nightly", JobType: "Backup"}}, start.Add(-60*24*time.Hour))
+
+	m.pruneState(failingLister{}, start)
+	if got := stateJobNames(m); !equalStrings(got, []string{"Nightly"}) {
+		t.Errorf("pruned to %v when the job list failed", got)
+	}
+
+	m.Config.StateRetentionDays = 0
+	m.pruneState(stubSource{}, start)
+	if got := stateJobNames(m); !equalStrings(got, []string{"Nightly"}) {
+		t.Errorf("pruned to %v with retention disabled", got)
+	}
+}
+
+// Sorted names of the jobs with state
+func stateJobNames(m *Monitor) []string {
+	var names []string
+	for _, job := range m.state.Jobs {
+		names = append(names, job.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Names of jobs, in order
+func jobNames(jobs []JobStatus) []string {
+	var names []string
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Longest StatsD packet sent, so metrics fit a single unfragmented datagram
+const statsDMaxPacket = 1432
+
+// Pushes metrics to a StatsD or DogStatsD agent over UDP. Writes never
+// block: UDP doesn't wait for the agent, and a missing agent only loses metrics.
+type statsDClient struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Send metric lines, batched into as few packets as possible
+func (c *statsDClient) send(address string, lines []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.Dial("udp", address)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+
+	var packet strings.Builder
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, err := c.conn.Write([]byte(packet.String()))
+		packet.Reset()
+		return err
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsDMaxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
+
+// Metric lines describing one check cycle
+func statsDLines(config *Config, jobs []JobStatus, lowSpaceRepos []RepositoryStatus, queryErrors []error, unreachable bool, duration time.Duration) []string {
+	prefix := strings.TrimSuffix(config.StatsDPrefix, ".")
+	if prefix != "" {
+		prefix += "."
+	}
+	tags := ""
+	if config.StatsDTags {
+		tags = "|#server:" + strings.NewReplacer(",", "_", "|", "_", " ", "_").Replace(serverDisplayName(config))
+	}
+
+	reachable := 1
+	if unreachable {
+		reachable = 0
+	}
+	metric := func(name string, value interface{}, kind string) string {
+		return fmt.Sprintf("%s%s:%v|%s%s", prefix, name, value, kind, tags)
+	}
+	kinds := map[string]int{}
+	for _, err := range queryErrors {
+		kinds[queryErrorKind(err)]++
+	}
+	return []string{
+		metric("cycles", 1, "c"),
+		metric("cycle.duration", duration.Milliseconds(), "ms"),
+		metric("powershell.errors", len(queryErrors), "c"),
+		metric("powershell.errors.module", kinds["module"], "c"),
+		metric("powershell.errors.environment", kinds["environment"], "c"),
+		metric("powershell.errors.unreachable", kinds["unreachable"], "c"),
+		metric("powershell.errors.timeout", kinds["timeout"], "c"),
+		metric("powershell.errors.parse", kinds["parse"], "c"),
+		metric("powershell.errors.other", kinds["other"], "c"),
+		metric("server.reachable", reachable, "g"),
+		metric("jobs.failed", countJobsByStatus(jobs, "Failed"), "g"),
+		metric("jobs.warning", countJobsByStatus(jobs, "Warning"), "g"),
+		metric("jobs.stuck", countJobsByStatus(jobs, stuckStatus), "g"),
+		metric("jobs.disabled", countJobsByStatus(jobs, disabledStatus), "g"),
+		metric("jobs.missing", countJobsByStatus(jobs, missingStatus), "g"),
+		metric("jobs.long_running", countJobsByStatus(jobs, "Running"), "g"),
+		metric("jobs.stale", countJobsByStatus(jobs, "Stale"), "g"),
+		metric("jobs.deviation", countJobsByStatus(jobs, deviationStatus), "g"),
+		metric("jobs.schedule_drift", countJobsByStatus(jobs, driftStatus), "g"),
+		metric("repositories.low_space", len(lowSpaceRepos), "g"),
+	}
+}
+
+// Push the cycle's metrics to StatsDAddress, if set
+func (m *Monitor) sendStatsD(jobs []JobStatus, lowSpaceRepos []RepositoryStatus, queryErrors []error, unreachable bool, duration time.Duration) {
+	if m.Config.StatsDAddress == "" {
+		return
+	}
+	lines := statsDLines(m.Config, jobs, lowSpaceRepos, queryErrors, unreachable, duration)
+	if err := m.statsD.send(m.Config.StatsDAddress, lines); err != nil {
+		m.logf("Error sending StatsD metrics: %v\n", err)
+	}
+}
@@ -0,0 +1,102 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Stand-in StatsD agent returning the packets received until none arrive for a while
+func listenStatsD(t *testing.T) (addr string, packets func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String(), func() []string {
+		var received []string
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return received
+			}
+			received = append(received, string(buf[:n]))
+		}
+	}
+}
+
+func TestStatsDCycleMetrics(t *testing.T) {
+	addr, packets := listenStatsD(t)
+	config := testConfig()
+	config.MonitorWarningJobs = true
+	config.StatsDAddress = addr
+	config.StatsDPrefix = "veeam."
+	config.StatsDTags = true
+	config.VeeamServerAddress = "veeam01"
+	m, _ := newCaptureMonitor(config, statusRunner([]string{"Nightly", "Weekly"}, []string{"Files"}))
+	m.RunCheckCycle()
+
+	lines := map[string]bool{}
+	for _, packet := range packets() {
+		for _, line := range strings.Split(packet, "\n") {
+			lines[line] = true
+		}
+	}
+	for _, want := range []string{
+		"veeam.cycles:1|c|#server:veeam01",
+		"veeam.jobs.failed:2|g|#server:veeam01",
+		"veeam.jobs.warning:1|g|#server:veeam01",
+		"veeam.jobs.long_running:0|g|#server:veeam01",
+		"veeam.powershell.errors:0|c|#server:veeam01",
+		"veeam.server.reachable:1|g|#server:veeam01",
+	} {
+		if !lines[want] {
+			t.Errorf("no %s in %v", want, lines)
+		}
+	}
+	found := false
+	for line := range lines {
+		found = found || strings.HasPrefix(line, "veeam.cycle.duration:") && strings.HasSuffix(line, "|ms|#server:veeam01")
+	}
+	if !found {
+		t.Errorf("no cycle duration in %v", lines)
+	}
+}
+
+func TestStatsDLinesWithoutTags(t *testing.T) {
+	config := testConfig()
+	lines := statsDLines(config, nil, nil, []error{&ParseError{"job output", fmt.Errorf("bad")}}, true, 1500*time.Millisecond)
+	for _, want := range []string{"veeam_monitor.cycle.duration:1500|ms", "veeam_monitor.powershell.errors.parse:1|c", "veeam_monitor.server.reachable:0|g"} {
+		if !strings.Contains(strings.Join(lines, "\n")+"\n", want+"\n") {
+			t.Errorf("no %s in %v", want, lines)
+		}
+	}
+}
+
+func TestStatsDSplitsPackets(t *testing.T) {
+	addr, packets := listenStatsD(t)
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("veeam.metric.%03d.%s:1|g", i, strings.Repeat("x", 40)))
+	}
+	client := &statsDClient{}
+	if err := client.send(addr, lines); err != nil {
+		t.Fatal(err)
+	}
+
+	var received []string
+	got := packets()
+	for _, packet := range got {
+		if len(packet) > statsDMaxPacket {
+			t.Errorf("packet of %d bytes", len(packet))
+		}
+		received = append(received, strings.Split(packet, "\n")...)
+	}
+	if len(got) < 2 || !equalStrings(received, lines) {
+		t.Errorf("got %d lines in %d packets, want all %d lines split into packets", len(received), len(got), len(lines))
+	}
+}
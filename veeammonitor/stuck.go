@@ -0,0 +1,119 @@
+package veeammonitor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status of running sessions that have stopped making progress
+const stuckStatus = "Stuck"
+
+// StuckSessionFinder is implemented by sources that can tell running
+// sessions that stopped making progress apart from ones still working, for
+// StuckSessionMinutes
+type StuckSessionFinder interface {
+	StuckSessions() ([]JobStatus, error)
+}
+
+// Progress of a running session last time it changed, for sessions whose
+// log doesn't say when they were last updated
+type sessionProgress struct {
+	progress string // Bytes processed so far
+	session  string // Start of the session, so a new one starts over
+	since    time.Time
+}
+
+// Get the running sessions of every monitored job type with the minutes
+// since their log was last updated (-1 when it can't be read) in Duration
+// and the bytes processed so far in Description, then keep the stuck ones.
+// Rows carry their last Session except for backup jobs, whose session is
+// looked up only once a job is known to be running.
+func (m *Monitor) getStuckSessions() ([]JobStatus, error) {
+	config := m.Config
+	jobs, err := m.queryJobTypes("running session", func(source string) string {
+		return fmt.Sprintf(`
+		%s | Where-Object {$_.IsRunning -eq $true} | ForEach-Object {
+			$session = if ($_.Session) { $_.Session } else { (Get-VBRJob -Name ([WildcardPattern]::Escape($_.Name))).FindLastSession() }
+			$updated = $null
+			$processed = $null
+			try { $updated = $session.Logger.GetLog().UpdatedRecords | ForEach-Object { $_.UpdateTime } | Sort-Object -Descending | Select-Object -First 1 } catch { }
+			try { $processed = $session.Progress.ProcessedSize } catch { }
+			[pscustomobject]@{Name=$_.Name; Status="%s"; StartTime=$_.SessionStart; EndTime="N/A"; Progress=$(if ($null -ne $processed) { [string]$processed } else { "" }); Duration=$(if ($updated) { ((Get-Date) - $updated).TotalMinutes.ToString([cultureinfo]::InvariantCulture) } else { "-1" }); NextRun=$_.NextRun}
+		} | %s
+	`, source, stuckStatus, convertToCsv(config))
+	}, stuckStatus)
+	if err != nil {
+		return nil, err
+	}
+	return m.stuckJobs(jobs, time.Now()), nil
+}
+
+// Keep the running sessions that have made no progress for longer than
+// StuckSessionMinutes. A session's last log update is used when known;
+// otherwise its processed bytes are compared between checks, and sessions
+// with neither can't be judged and are left to the long-running check.
+func (m *Monitor) stuckJobs(sessions []JobStatus, now time.Time) []JobStatus {
+	config := m.Config
+	threshold := time.Duration(config.StuckSessionMinutes) * time.Minute
+	if m.sessionProgress == nil {
+		m.sessionProgress = map[string]sessionProgress{}
+	}
+
+	var stuck []JobStatus
+	seen := map[string]bool{}
+	unknown := 0
+	for _, job := range sessions {
+		key := jobIdentity(job)
+		seen[key] = true
+		progress := strings.TrimSpace(job.Description)
+
+		var idle time.Duration
+		var what string
+		if minutes, err := strconv.ParseFloat(strings.TrimSpace(job.Duration), 64); err == nil && minutes >= 0 {
+			idle, what = time.Duration(minutes*float64(time.Minute)), "no session log updates"
+		} else if progress != "" {
+			last, ok := m.sessionProgress[key]
+			if !ok || last.progress != progress || last.session != job.StartTime {
+				last = sessionProgress{progress: progress, session: job.StartTime, since: now}
+				m.sessionProgress[key] = last
+			}
+			idle, what = now.Sub(last.since), "no change in processed data"
+		} else {
+			unknown++
+			continue
+		}
+		if idle <= threshold {
+			continue
+		}
+
+		job.Duration = strconv.FormatFloat(idle.Minutes(), 'f', 2, 64)
+		job.Description = fmt.Sprintf("Session shows as running but has had %s for %d minutes (threshold %d minutes)",
+			what, int(idle.Minutes()), config.StuckSessionMinutes)
+		stuck = append(stuck, job)
+	}
+
+	for key := range m.sessionProgress {
+		if !seen[key] {
+			delete(m.sessionProgress, key)
+		}
+	}
+	if unknown > 0 {
+		m.logf("Warning: %d running sessions report no progress information, can't tell whether they are stuck\n", unknown)
+	}
+	return stuck
+}
+
+// Drop the long-running entries of jobs found stuck, so each session is
+// reported once, as stuck
+func withoutStuckRunning(jobs []JobStatus, stuck []JobStatus) []JobStatus {
+	stuckJobs := map[string]bool{}
+	for _, job := range stuck {
+		stuckJobs[jobIdentity(job)] = true
+	}
+	kept, _ := withoutJobs(jobs, func(job JobStatus) bool {
+		return job.Status == "Running" && stuckJobs[jobIdentity(job)]
+	})
+	return kept
+}
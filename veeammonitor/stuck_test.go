@@ -0,0 +1,110 @@
+package veeammonitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Running sessions as the stuck session query returns them: a healthy one
+// logging recently, one whose log stopped updating 95 minutes ago, one
+// without a readable log and one with no progress information at all
+const stuckSessionOutput = `"Name","Status","StartTime","EndTime","Progress","Duration","NextRun"` + "\n" +
+	`"Healthy","Stuck","3/1/2024 1:00:00 AM","N/A","52428800","2.5",""` + "\n" +
+	`"Hung","Stuck","3/1/2024 1:00:00 AM","N/A","10485760","95.25",""` + "\n" +
+	`"NoLog","Stuck","3/1/2024 1:00:00 AM","N/A","2097152","-1",""` + "\n" +
+	`"Unknown","Stuck","3/1/2024 1:00:00 AM","N/A","","-1",""` + "\n"
+
+func stuckTestConfig() *Config {
+	config := testConfig()
+	config.StuckSessionMinutes = 60
+	return config
+}
+
+func TestGetStuckSessions(t *testing.T) {
+	var scripts []string
+	m := newTestMonitor(stuckTestConfig(), fakeRunner(func(script string) (string, string, error) {
+		scripts = append(scripts, script)
+		return stuckSessionOutput, "", nil
+	}))
+
+	stuck, err := m.getStuckSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stuck) != 1 || stuck[0].Name != "Hung" || stuck[0].Status != stuckStatus {
+		t.Fatalf("got stuck sessions %+v, want only Hung", stuck)
+	}
+	want := "Session shows as running but has had no session log updates for 95 minutes (threshold 60 minutes)"
+	if stuck[0].Description != want || stuck[0].Duration != "95.25" {
+		t.Errorf("got description %q and duration %q, want %q", stuck[0].Description, stuck[0].Duration, want)
+	}
+	if len(scripts) != 1 || !strings.Contains(scripts[0], "UpdatedRecords") || !strings.Contains(scripts[0], "Progress.ProcessedSize") {
+		t.Errorf("query doesn't read session progress: %q", scripts)
+	}
+}
+
+func TestStuckJobsByProcessedData(t *testing.T) {
+	m := newTestMonitor(stuckTestConfig(), nil)
+	start := time.Now()
+	session := func(progress, started string) []JobStatus {
+		return []JobStatus{{Name: "NoLog", JobType: "Backup", Status: stuckStatus, StartTime: started, Description: progress, Duration: "-1"}}
+	}
+
+	if stuck := m.stuckJobs(session("2097152", "1:00"), start); len(stuck) != 0 {
+		t.Errorf("first sighting reported stuck: %+v", stuck)
+	}
+	if stuck := m.stuckJobs(session("4194304", "1:00"), start.Add(45*time.Minute)); len(stuck) != 0 {
+		t.Errorf("session making progress reported stuck: %+v", stuck)
+	}
+	if stuck := m.stuckJobs(session("4194304", "1:00"), start.Add(90*time.Minute)); len(stuck) != 0 {
+		t.Errorf("session idle for 45 minutes reported stuck: %+v", stuck)
+	}
+	stuck := m.stuckJobs(session("4194304", "1:00"), start.Add(106*time.Minute))
+	if len(stuck) != 1 || !strings.Contains(stuck[0].Description, "no change in processed data for 61 minutes") {
+		t.Errorf("got %+v, want the session idle for 61 minutes stuck", stuck)
+	}
+
+	// A new session with the same processed size starts over
+	if stuck := m.stuckJobs(session("4194304", "3:00"), start.Add(120*time.Minute)); len(stuck) != 0 {
+		t.Errorf("new session reported stuck: %+v", stuck)
+	}
+
+	// Sessions that end are forgotten
+	m.stuckJobs(nil, start.Add(125*time.Minute))
+	if len(m.sessionProgress) != 0 {
+		t.Errorf("kept progress %+v of ended sessions", m.sessionProgress)
+	}
+}
+
+func TestCheckCycleReportsStuckApartFromRunning(t *testing.T) {
+	config := stuckTestConfig()
+	config.MonitorRunningJobs = true
+	m, capture := newCaptureMonitor(config, fakeRunner(func(script string) (string, string, error) {
+		switch {
+		case strings.Contains(script, "UpdatedRecords"):
+			return stuckSessionOutput, "", nil
+		case strings.Contains(script, "$longRunningJobs"):
+			return `"Name","Status","StartTime","EndTime","Description","Duration","NextRun"` + "\n" +
+				`"Healthy","Running","3/1/2024 1:00:00 AM","N/A","Currently running","400.5",""` + "\n" +
+				`"Hung","Running","3/1/2024 1:00:00 AM","N/A","Currently running","400.5",""` + "\n", "", nil
+		}
+		return jobCSVHeader, "", nil
+	}))
+	m.RunCheckCycle()
+
+	sent := capture.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d reports, want 1", len(sent))
+	}
+	report := sent[0]
+	if len(report.Stuck) != 1 || report.Stuck[0].Name != "Hung" || report.Counts.Stuck != 1 {
+		t.Errorf("got stuck %+v, want Hung", report.Stuck)
+	}
+	if len(report.Running) != 1 || report.Running[0].Name != "Healthy" {
+		t.Errorf("got long-running %+v, want only the healthy session", report.Running)
+	}
+	if _, body := buildEmailBody(report, config); !strings.Contains(body, "STUCK SESSIONS (1):") {
+		t.Errorf("email doesn't list the stuck session:\n%s", body)
+	}
+}
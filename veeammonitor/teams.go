@@ -0,0 +1,287 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Teams rejects webhook messages over about 28 KB; cards are kept below
+// this many bytes of JSON, leaving room for the envelope
+const teamsMaxCardBytes = 24000
+
+// Message posted to a Teams incoming webhook, carrying one Adaptive Card
+type teamsMessage struct {
+	Type        string            `json:"type"` // Always "message"
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string            `json:"$schema"`
+	Type    string            `json:"type"`
+	Version string            `json:"version"`
+	Body    []teamsElement    `json:"body"`
+	MSTeams map[string]string `json:"msteams,omitempty"`
+}
+
+// Adaptive Card element: a TextBlock, a Container of items or a FactSet
+type teamsElement struct {
+	Type      string         `json:"type"`
+	Text      string         `json:"text,omitempty"`
+	Weight    string         `json:"weight,omitempty"`
+	Size      string         `json:"size,omitempty"`
+	Color     string         `json:"color,omitempty"`
+	IsSubtle  bool           `json:"isSubtle,omitempty"`
+	Wrap      bool           `json:"wrap,omitempty"`
+	Style     string         `json:"style,omitempty"`
+	Separator bool           `json:"separator,omitempty"`
+	Items     []teamsElement `json:"items,omitempty"`
+	Facts     []teamsFact    `json:"facts,omitempty"`
+}
+
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// Container style and text color for a severity
+func teamsStyle(severity string) (style, color string) {
+	switch severity {
+	case severityCritical:
+		return "attention", "Attention"
+	case severityWarning:
+		return "warning", "Warning"
+	}
+	return "accent", "Accent"
+}
+
+func teamsText(text string) teamsElement {
+	return teamsElement{Type: "TextBlock", Text: text, Wrap: true}
+}
+
+// A card of the given body, stretched to the full width of the channel
+func newTeamsMessage(body []teamsElement) teamsMessage {
+	return teamsMessage{Type: "message", Attachments: []teamsAttachment{{
+		ContentType: "application/vnd.microsoft.card.adaptive",
+		Content: teamsCard{
+			Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+			Type:    "AdaptiveCard",
+			Version: "1.4",
+			Body:    body,
+			MSTeams: map[string]string{"width": "Full"},
+		},
+	}}}
+}
+
+// How long a job ran, or has been running or overdue, empty when unknown
+func teamsDuration(job JobStatus) string {
+	switch job.Status {
+	case "Running", stuckStatus:
+		if job.Duration != "" {
+			return strings.SplitN(job.Duration, ".", 2)[0] + " minutes"
+		}
+	case "Stale":
+		if job.Duration != "" {
+			return strings.SplitN(job.Duration, ".", 2)[0] + " hours since the last run"
+		}
+	default:
+		start, err := time.Parse(displayTimeLayout, job.StartTime)
+		if err != nil {
+			return ""
+		}
+		end, err := time.Parse(displayTimeLayout, job.EndTime)
+		if err != nil || end.Before(start) {
+			return ""
+		}
+		return fmt.Sprintf("%.0f minutes", end.Sub(start).Minutes())
+	}
+	return ""
+}
+
+// Name and facts of a job in the verbose format
+func teamsJob(job JobStatus, report *AlertReport, config *Config) []teamsElement {
+	facts := []teamsFact{{"Status", displayStatus(job)}}
+	for _, fact := range []teamsFact{
+		{"Escalated", escalationLine(job, "failed %d checks in a row")},
+		{"Failing since", failingSinceLine(job, "%s")},
+		{"Type", job.JobType},
+		{"Start", job.StartTime},
+		{"End", job.EndTime},
+		{"Duration", teamsDuration(job)},
+		{"Next run", config.nextRunLine(job, "%s")},
+		{"Note", noteLine(job, "%s")},
+	} {
+		if fact.Value != "" {
+			facts = append(facts, fact)
+		}
+	}
+	name := teamsText(groupedJobName(job, report, config, job.Name))
+	name.Weight, name.Separator = "Bolder", true
+	elements := []teamsElement{name, {Type: "FactSet", Facts: facts}}
+	if description := config.displayDescription(job.Description); description != "" {
+		elements = append(elements, teamsText(description))
+	}
+	return elements
+}
+
+// Items of each status's container, and of one for low-space repositories,
+// in the order of the email sections
+type teamsGroup struct {
+	style string
+	items [][]teamsElement // Kept together when the alert is split
+}
+
+func teamsGroups(report *AlertReport, config *Config) []teamsGroup {
+	var groups []teamsGroup
+	for _, section := range report.statusSections() {
+		style, color := teamsStyle(config.statusSeverity(section.status))
+		heading := teamsText(fmt.Sprintf("%s (%d)", section.title, len(section.jobs)+section.omitted))
+		heading.Weight, heading.Color = "Bolder", color
+		group := teamsGroup{style: style, items: [][]teamsElement{{heading}}}
+		for _, cause := range report.Causes {
+			if cause.Status == section.status {
+				group.items = append(group.items, []teamsElement{teamsText(fmt.Sprintf("**%s:** %s", cause.summary(config), cause.jobList()))})
+			}
+		}
+		for _, job := range report.withoutCauses(section.jobs) {
+			if config.compact() {
+				group.items = append(group.items, []teamsElement{teamsText(groupedJobName(job, report, config, compactJobLine(job, config, report.Timestamp)))})
+			} else {
+				group.items = append(group.items, teamsJob(job, report, config))
+			}
+		}
+		if line := omittedLine(section.omitted, section.label, report.omittedHint, "%s"); line != "" {
+			omitted := teamsText(line)
+			omitted.IsSubtle = true
+			group.items = append(group.items, []teamsElement{omitted})
+		}
+		groups = append(groups, group)
+	}
+
+	if len(report.Repositories) > 0 {
+		style, color := teamsStyle(config.statusSeverity(repositoryStatus))
+		heading := teamsText(fmt.Sprintf("Repositories low on space (%d)", len(report.Repositories)))
+		heading.Weight, heading.Color = "Bolder", color
+		facts := teamsElement{Type: "FactSet"}
+		for _, repo := range report.Repositories {
+			facts.Facts = append(facts.Facts, teamsFact{repo.DisplayName(), fmt.Sprintf("%s free of %s (%.1f%%)", formatGB(repo.FreeBytes), formatGB(repo.TotalBytes), repo.FreePercent())})
+		}
+		groups = append(groups, teamsGroup{style: style, items: [][]teamsElement{{heading}, {facts}}})
+	}
+	return groups
+}
+
+// JSON size of card elements, to keep cards under the webhook's limit
+func teamsSize(elements []teamsElement) int {
+	data, _ := json.Marshal(elements)
+	return len(data)
+}
+
+// Build the cards for an alert: a title colored by the worst severity and
+// the cycle and fingerprint, then a container per status styled by its
+// severity. Alerts too large for one card are split between jobs across
+// several, numbered in the title.
+func buildTeamsMessages(report *AlertReport, config *Config) []teamsMessage {
+	_, color := teamsStyle(report.Severity)
+	header := func(title string) []teamsElement {
+		heading := teamsText(title)
+		heading.Weight, heading.Size, heading.Color = "Bolder", "Large", color
+		elements := []teamsElement{heading}
+		if footer := alertFooter(report); footer != "" {
+			footer := teamsText(footer)
+			footer.IsSubtle = true
+			elements = append(elements, footer)
+		}
+		return elements
+	}
+	headerSize := teamsSize(header(alertTitle(report) + " (99/99)"))
+
+	var bodies [][]teamsElement
+	var body []teamsElement
+	size := headerSize
+	for _, group := range teamsGroups(report, config) {
+		container := -1
+		for _, item := range group.items {
+			itemSize := teamsSize(item)
+			if len(body) > 0 && size+itemSize > teamsMaxCardBytes {
+				bodies = append(bodies, body)
+				body, size, container = nil, headerSize, -1
+			}
+			if container < 0 {
+				body = append(body, teamsElement{Type: "Container", Style: group.style})
+				container = len(body) - 1
+			}
+			body[container].Items = append(body[container].Items, item...)
+			size += itemSize
+		}
+	}
+	bodies = append(bodies, body)
+
+	messages := make([]teamsMessage, len(bodies))
+	for i, body := range bodies {
+		title := alertTitle(report)
+		if len(bodies) > 1 {
+			title += fmt.Sprintf(" (%d/%d)", i+1, len(bodies))
+		}
+		messages[i] = newTeamsMessage(append(header(title), body...))
+	}
+	return messages
+}
+
+// Post an alert to the Teams webhook, split across as many cards as needed
+func sendTeamsAlert(ctx context.Context, httpClient *http.Client, report *AlertReport, config *Config) error {
+	if name := config.channelTemplate("teams"); name != "" {
+		text, err := renderReportTemplate(report, config, name)
+		if err == nil {
+			return postTeamsMessage(ctx, httpClient, config.TeamsWebhookURL, newTeamsMessage([]teamsElement{teamsText(truncateRunes(text, teamsMaxCardBytes/4))}))
+		}
+		log.Printf("Error rendering report template %s, sending the standard Teams alert: %v\n", name, err)
+	}
+	for i, message := range buildTeamsMessages(report, config) {
+		if err := postTeamsMessage(ctx, httpClient, config.TeamsWebhookURL, message); err != nil {
+			return fmt.Errorf("error sending Teams message %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Post a single card to a Teams webhook. Teams explains rejected cards in
+// the body, which is included.
+func postTeamsMessage(ctx context.Context, httpClient *http.Client, webhookURL string, message teamsMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return redactURLError(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return redactURLError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		if reason := strings.TrimSpace(string(body)); reason != "" {
+			return fmt.Errorf("Teams returned status %s: %s", resp.Status, firstLine(reason))
+		}
+		return fmt.Errorf("Teams returned status %s", resp.Status)
+	}
+	return nil
+}
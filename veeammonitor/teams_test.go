@@ -0,0 +1,155 @@
+package veeammonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stand-in Teams webhook collecting the cards posted to it and answering
+// with status and reply
+func newTeamsServer(t *testing.T, status int, reply string) (string, func() []teamsMessage) {
+	var mu sync.Mutex
+	var messages []teamsMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message teamsMessage
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("decoding message: %v", err)
+		}
+		mu.Lock()
+		messages = append(messages, message)
+		mu.Unlock()
+		w.WriteHeader(status)
+		fmt.Fprint(w, reply)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() []teamsMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]teamsMessage{}, messages...)
+	}
+}
+
+func TestTeamsAlertCard(t *testing.T) {
+	url, received := newTeamsServer(t, http.StatusOK, "1")
+	config := testConfig()
+	config.TeamsWebhookURL = url
+	jobs := []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", StartTime: "3/1/2024 1:00:00 AM", EndTime: "3/1/2024 1:45:00 AM", Description: "Disk full"},
+		{Name: "Files", JobType: "Backup", Status: "Warning"},
+	}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+
+	if err := sendTeamsAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+		t.Fatal(err)
+	}
+	messages := received()
+	if len(messages) != 1 || messages[0].Type != "message" || len(messages[0].Attachments) != 1 {
+		t.Fatalf("got %+v, want one message with one card", messages)
+	}
+	attachment := messages[0].Attachments[0]
+	card := attachment.Content
+	if attachment.ContentType != "application/vnd.microsoft.card.adaptive" || card.Type != "AdaptiveCard" || card.Version != "1.4" || card.MSTeams["width"] != "Full" {
+		t.Errorf("got attachment %+v", attachment)
+	}
+	if title := card.Body[0]; title.Text != alertTitle(report) || title.Color != "Attention" || title.Size != "Large" {
+		t.Errorf("got title %+v", title)
+	}
+
+	var containers []teamsElement
+	for _, element := range card.Body {
+		if element.Type == "Container" {
+			containers = append(containers, element)
+		}
+	}
+	if len(containers) != 2 || containers[0].Style != "attention" || containers[1].Style != "warning" {
+		t.Fatalf("got containers %+v, want failed then warning", containers)
+	}
+	failed := containers[0].Items
+	if len(failed) != 4 || failed[1].Text != "Nightly" || failed[3].Text != "Disk full" {
+		t.Fatalf("got failed items %+v", failed)
+	}
+	facts := map[string]string{}
+	for _, fact := range failed[2].Facts {
+		facts[fact.Title] = fact.Value
+	}
+	if facts["Status"] != "Failed" || facts["Duration"] != "45 minutes" || facts["Type"] != "Backup" {
+		t.Errorf("got facts %v", facts)
+	}
+}
+
+func TestTeamsDuration(t *testing.T) {
+	tests := []struct {
+		job  JobStatus
+		want string
+	}{
+		{JobStatus{Status: "Failed", StartTime: "3/1/2024 1:00:00 AM", EndTime: "3/1/2024 2:30:00 AM"}, "90 minutes"},
+		{JobStatus{Status: "Failed", StartTime: "3/1/2024 1:00:00 AM", EndTime: "N/A"}, ""},
+		{JobStatus{Status: "Warning", StartTime: "3/1/2024 2:00:00 AM", EndTime: "3/1/2024 1:00:00 AM"}, ""},
+		{JobStatus{Status: "Running", Duration: "312.5"}, "312 minutes"},
+		{JobStatus{Status: "Stale", Duration: "50.2"}, "50 hours since the last run"},
+		{JobStatus{Status: "Stale"}, ""},
+	}
+	for _, test := range tests {
+		if got := teamsDuration(test.job); got != test.want {
+			t.Errorf("teamsDuration(%+v) = %q, want %q", test.job, got, test.want)
+		}
+	}
+}
+
+func TestTeamsAlertSplitsLargeReports(t *testing.T) {
+	url, received := newTeamsServer(t, http.StatusOK, "1")
+	config := testConfig()
+	config.TeamsWebhookURL = url
+	var jobs []JobStatus
+	for i := 0; i < 200; i++ {
+		jobs = append(jobs, JobStatus{Name: fmt.Sprintf("Job %03d", i), JobType: "Backup", Status: "Failed", Description: strings.Repeat("x", 200)})
+	}
+	report := NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+
+	if err := sendTeamsAlert(context.Background(), http.DefaultClient, report, config); err != nil {
+		t.Fatal(err)
+	}
+	messages := received()
+	if len(messages) < 2 {
+		t.Fatalf("got %d messages, want the alert split", len(messages))
+	}
+	names := 0
+	for i, message := range messages {
+		card := message.Attachments[0].Content
+		if size := teamsSize(card.Body); size > teamsMaxCardBytes {
+			t.Errorf("card %d has %d bytes", i+1, size)
+		}
+		if want := fmt.Sprintf("(%d/%d)", i+1, len(messages)); !strings.HasSuffix(card.Body[0].Text, want) {
+			t.Errorf("card %d is titled %q, want it numbered %s", i+1, card.Body[0].Text, want)
+		}
+		for _, element := range card.Body {
+			for _, item := range element.Items {
+				if strings.HasPrefix(item.Text, "Job ") {
+					names++
+				}
+			}
+		}
+	}
+	if names != len(jobs) {
+		t.Errorf("sent %d jobs, want every job once", names)
+	}
+}
+
+func TestTeamsError(t *testing.T) {
+	url, _ := newTeamsServer(t, http.StatusBadRequest, "Bad payload received by generic incoming webhook.\nmore details")
+	config := testConfig()
+	config.TeamsWebhookURL = url
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", Status: "Failed"}}, nil, severityCritical, config, time.Now())
+
+	err := sendTeamsAlert(context.Background(), http.DefaultClient, report, config)
+	if err == nil || err.Error() != "error sending Teams message 1: Teams returned status 400 Bad Request: Bad payload received by generic incoming webhook." {
+		t.Errorf("got error %v", err)
+	}
+}
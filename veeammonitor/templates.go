@@ -0,0 +1,165 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// EmailAudience is a group of recipients sent their own rendering of each
+// alert, e.g. a terse technical one for the NOC and a summary for management
+type EmailAudience struct {
+	Name     string   `json:"name" yaml:"name"`
+	To       []string `json:"to" yaml:"to"`
+	Template string   `json:"template" yaml:"template"` // Name in ReportTemplates, empty for the standard report
+	Subject  string   `json:"subject" yaml:"subject"`   // Go text/template for the subject, empty uses emailSubjectTemplate
+}
+
+// Channel name of an audience's emails, for NotificationRouting
+func (a EmailAudience) channel() string {
+	return "email:" + strings.ToLower(a.Name)
+}
+
+// Sends an audience its rendering of alerts
+type emailAudienceNotifier struct {
+	config     *Config
+	httpClient *http.Client
+	audience   EmailAudience
+}
+
+func (n emailAudienceNotifier) Name() string { return n.audience.channel() }
+
+func (n emailAudienceNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	config := *n.config.forChannel(n.Name())
+	config.EmailTo = n.audience.To
+	if n.audience.Subject != "" {
+		config.EmailSubjectTemplate = n.audience.Subject
+	}
+	return sendTemplatedEmailAlert(ctx, n.httpClient, report, &config, n.audience.Template)
+}
+
+// Functions available to report templates besides the text/template built-ins
+func reportTemplateFuncs(config *Config) template.FuncMap {
+	return template.FuncMap{
+		"upper":       strings.ToUpper,
+		"lower":       strings.ToLower,
+		"join":        strings.Join,
+		"status":      displayStatus,
+		"gb":          formatGB,
+		"description": config.displayDescription,
+		"time": func(t time.Time, layout string) string {
+			return t.Format(layout)
+		},
+	}
+}
+
+// Parse one of ReportTemplates
+func parseReportTemplate(config *Config, name string) (*template.Template, error) {
+	text, ok := config.ReportTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("no report template named %q", name)
+	}
+	return template.New(name).Funcs(reportTemplateFuncs(config)).Parse(text)
+}
+
+// Render a report through one of ReportTemplates. The template is executed
+// with the AlertReport, so it has the same counts, jobs and groups as every
+// other channel.
+func renderReportTemplate(report *AlertReport, config *Config, name string) (string, error) {
+	tmpl, err := parseReportTemplate(config, name)
+	if err != nil {
+		return "", err
+	}
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, report); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// Template a channel renders alerts with, empty for its standard format
+func (c *Config) channelTemplate(channel string) string {
+	for name, templateName := range c.ChannelTemplates {
+		if strings.EqualFold(name, channel) {
+			return templateName
+		}
+	}
+	return ""
+}
+
+// Send an alert email whose body comes from a report template, falling
+// back to the standard report if the template fails
+func sendTemplatedEmailAlert(ctx context.Context, httpClient *http.Client, report *AlertReport, config *Config, name string) error {
+	if name == "" {
+		return sendEmailAlert(ctx, httpClient, report, config)
+	}
+	body, err := renderReportTemplate(report, config, name)
+	if err != nil {
+		log.Printf("Error rendering report template %s, sending the standard report: %v\n", name, err)
+		return sendEmailAlert(ctx, httpClient, report, config)
+	}
+	subject := emailSubject(report, config)
+
+	var attachments []emailAttachment
+	if config.AttachCSV && report.Counts.Total > 0 {
+		attachment, err := csvAttachment(report, config)
+		if err != nil {
+			return err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return sendEmail(ctx, httpClient, config, subject, body, attachments...)
+}
+
+// Report exercising every field templates can use, to check them at startup
+func sampleTemplateReport(config *Config) *AlertReport {
+	jobs := []JobStatus{
+		{Name: "Sample Job", Status: "Failed", StartTime: "1/2/2006 3:04:05 PM", EndTime: "1/2/2006 3:14:05 PM", Description: "Sample failure", JobType: "Backup"},
+		{Name: "Sample Warning", Status: "Warning", JobType: "Backup"},
+	}
+	repos := []RepositoryStatus{{Name: "Sample Repository", TotalBytes: 100 << 30, FreeBytes: 5 << 30}}
+	return NewAlertReport(jobs, repos, severityCritical, config, time.Now())
+}
+
+// Check that every report template parses and renders, and that channels
+// and audiences only name templates that exist
+func validateReportTemplates(config *Config) error {
+	names := make([]string, 0, len(config.ReportTemplates))
+	for name := range config.ReportTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := sampleTemplateReport(config)
+	for _, name := range names {
+		if _, err := renderReportTemplate(report, config, name); err != nil {
+			return fmt.Errorf("reportTemplates %q: %v", name, err)
+		}
+	}
+
+	for channel, name := range config.ChannelTemplates {
+		if _, ok := config.ReportTemplates[name]; !ok {
+			return fmt.Errorf("channelTemplates %q uses unknown report template %q", channel, name)
+		}
+	}
+	for _, audience := range config.EmailAudiences {
+		if audience.Name == "" || len(audience.To) == 0 {
+			return fmt.Errorf("emailAudiences entries need a name and at least one recipient in to")
+		}
+		if _, ok := config.ReportTemplates[audience.Template]; audience.Template != "" && !ok {
+			return fmt.Errorf("emailAudiences %q uses unknown report template %q", audience.Name, audience.Template)
+		}
+		if audience.Subject != "" {
+			if _, err := template.New("subject").Parse(audience.Subject); err != nil {
+				return fmt.Errorf("emailAudiences %q subject: %v", audience.Name, err)
+			}
+		}
+	}
+	return nil
+}
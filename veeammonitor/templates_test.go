@@ -0,0 +1,124 @@
+package veeammonitor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Terse lines for the NOC and a summary for management
+var audienceTemplates = map[string]string{
+	"noc":        `{{range .Failed}}FAIL {{.Name}} ({{.JobType}}): {{description .Description}}{{"\n"}}{{end}}{{range .Warning}}WARN {{.Name}} ({{.JobType}}){{"\n"}}{{end}}`,
+	"management": `{{.Counts.Failed}} of our backups failed and {{.Counts.Warning}} need a look on {{.Server}}. The team is on it.`,
+}
+
+func audienceReport(config *Config) *AlertReport {
+	jobs := []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: "Disk full"},
+		{Name: "Weekly", JobType: "Backup", Status: "Warning"},
+	}
+	return NewAlertReport(jobs, nil, severityCritical, config, time.Now())
+}
+
+func TestRenderReportTemplates(t *testing.T) {
+	config := testConfig()
+	config.ReportTemplates = audienceTemplates
+	report := audienceReport(config)
+
+	tests := []struct {
+		name, want string
+	}{
+		{"noc", "FAIL Nightly (Backup): Disk full\nWARN Weekly (Backup)\n"},
+		{"management", "1 of our backups failed and 1 need a look on localhost. The team is on it."},
+	}
+	for _, test := range tests {
+		got, err := renderReportTemplate(report, config, test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+	if _, err := renderReportTemplate(report, config, "board"); err == nil {
+		t.Error("rendered a template that doesn't exist")
+	}
+}
+
+func TestEmailAudiences(t *testing.T) {
+	relay := newFakeSMTP(t)
+	config := smtpTestConfig(relay.addr, "ops@example.com")
+	config.ReportTemplates = audienceTemplates
+	config.EmailAudiences = []EmailAudience{
+		{Name: "NOC", To: []string{"noc@example.com"}, Template: "noc"},
+		{Name: "Management", To: []string{"board@example.com"}, Template: "management", Subject: "Backup status on {{.Server}}"},
+	}
+	report := audienceReport(config)
+	for _, audience := range config.EmailAudiences {
+		notifier := emailAudienceNotifier{config, http.DefaultClient, audience}
+		if err := notifier.Notify(context.Background(), report); err != nil {
+			t.Fatalf("%s: %v", notifier.Name(), err)
+		}
+	}
+
+	delivered := relay.delivered()
+	if len(delivered) != 2 {
+		t.Fatalf("delivered %d emails, want one per audience", len(delivered))
+	}
+	noc, management := delivered[0], delivered[1]
+	if noc.to[0] != "noc@example.com" || !strings.Contains(noc.data, "FAIL Nightly (Backup): Disk full") || strings.Contains(noc.data, "The team is on it") {
+		t.Errorf("NOC got %+v", noc)
+	}
+	if management.to[0] != "board@example.com" || !strings.Contains(management.data, "Subject: Backup status on localhost") ||
+		!strings.Contains(management.data, "1 of our backups failed") || strings.Contains(management.data, "FAIL Nightly") {
+		t.Errorf("management got %+v", management)
+	}
+}
+
+func TestValidateReportTemplates(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *Config)
+	}{
+		{"syntax error", func(config *Config) { config.ReportTemplates["broken"] = "{{range .Failed}}" }},
+		{"unknown field", func(config *Config) { config.ReportTemplates["broken"] = "{{.Nonexistent}}" }},
+		{"unknown channel template", func(config *Config) { config.ChannelTemplates = map[string]string{"discord": "board"} }},
+		{"unknown audience template", func(config *Config) {
+			config.EmailAudiences = []EmailAudience{{Name: "Board", To: []string{"board@example.com"}, Template: "board"}}
+		}},
+		{"audience without recipients", func(config *Config) {
+			config.EmailAudiences = []EmailAudience{{Name: "Board", Template: "management"}}
+		}},
+		{"audience subject", func(config *Config) {
+			config.EmailAudiences = []EmailAudience{{Name: "Board", To: []string{"board@example.com"}, Subject: "{{.Server"}}
+		}},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.ReportTemplates = map[string]string{}
+		for name, text := range audienceTemplates {
+			config.ReportTemplates[name] = text
+		}
+		if err := validateReportTemplates(config); err != nil {
+			t.Fatalf("valid templates refused: %v", err)
+		}
+		test.modify(config)
+		if err := validateReportTemplates(config); err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
+
+func TestInvalidReportTemplateRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"reportTemplates": {"noc": "{{range .Failed}}"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want ErrInvalidConfig", err)
+	}
+}
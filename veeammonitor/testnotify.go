@@ -0,0 +1,139 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TestResult is the outcome of sending a test message through one channel
+type TestResult struct {
+	Channel string
+	Err     error
+}
+
+// SendTestEmail sends a single test message through the configured SMTP
+// settings, or SES, so the transport and authentication can be verified
+func SendTestEmail(config *Config) error {
+	return sendTestEmail(newHTTPClient(config), config)
+}
+
+func sendTestEmail(httpClient *http.Client, config *Config) error {
+	if config.EmailFrom == "" || len(config.EmailTo) == 0 || config.SMTPServer == "" && !config.SESEnabled {
+		return fmt.Errorf("email configuration incomplete: emailFrom, emailTo and smtpServer (or sesEnabled) are required")
+	}
+
+	subject := "Veeam monitor test message"
+	body := "Veeam Backup Monitor Test Message\n"
+	body += "=================================\n\n"
+	body += fmt.Sprintf("This test message was sent at %s to verify the email settings for %s.\n",
+		time.Now().In(config.location()).Format(time.RFC1123), serverDisplayName(config))
+	body += "\nThis is an automated message from the Veeam Backup Monitor.\n"
+
+	return sendEmail(context.Background(), httpClient, config, subject, body)
+}
+
+// Body of the test message posted to webhooks
+type webhookTestPayload struct {
+	Event     string    `json:"event"` // Always test
+	Server    string    `json:"server"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message"`
+}
+
+// SendTestNotifications sends a test message through every configured channel
+func SendTestNotifications(config *Config) []TestResult {
+	var results []TestResult
+	httpClient := newHTTPClient(config)
+
+	if config.SESEnabled {
+		results = append(results, TestResult{Channel: "ses", Err: sendTestEmail(httpClient, config)})
+	} else if config.EmailFrom != "" || len(config.EmailTo) > 0 || config.SMTPServer != "" {
+		results = append(results, TestResult{Channel: "email", Err: sendTestEmail(httpClient, config)})
+	}
+
+	if config.DiscordWebhookURL != "" {
+		err := postDiscordMessage(context.Background(), httpClient, config.DiscordWebhookURL, discordMessage{Content: "Veeam monitor test message from " + serverDisplayName(config)})
+		results = append(results, TestResult{Channel: "discord", Err: err})
+	}
+
+	if config.SlackWebhookURL != "" {
+		err := postSlackMessage(context.Background(), httpClient, config.SlackWebhookURL, slackMessage{Channel: config.SlackChannel, Text: "Veeam monitor test message from " + serverDisplayName(config)})
+		results = append(results, TestResult{Channel: "slack", Err: err})
+	}
+
+	if config.TeamsWebhookURL != "" {
+		err := postTeamsMessage(context.Background(), httpClient, config.TeamsWebhookURL, newTeamsMessage([]teamsElement{teamsText("Veeam monitor test message from " + serverDisplayName(config))}))
+		results = append(results, TestResult{Channel: "teams", Err: err})
+	}
+
+	for _, webhook := range config.Webhooks {
+		err := postWebhook(context.Background(), httpClient, config, webhook, webhookTestPayload{
+			Event:     "test",
+			Server:    serverDisplayName(config),
+			Timestamp: time.Now().In(config.location()),
+			Message:   "Veeam monitor test message from " + serverDisplayName(config),
+		})
+		results = append(results, TestResult{Channel: webhook.channel(), Err: err})
+	}
+
+	if config.SNSTopicARN != "" {
+		err := publishSNSMessage(context.Background(), httpClient, config, "Veeam monitor test message", "Veeam monitor test message from "+serverDisplayName(config))
+		results = append(results, TestResult{Channel: "sns", Err: err})
+	}
+
+	if config.PagerDutyRoutingKey != "" {
+		results = append(results, TestResult{Channel: "pagerduty", Err: sendPagerDutyTest(httpClient, config)})
+	}
+
+	if config.OpsgenieAPIKey != "" {
+		results = append(results, TestResult{Channel: opsgenieChannel, Err: sendOpsgenieTest(httpClient, config)})
+	}
+
+	return results
+}
+
+// Trigger an info-level PagerDuty event and resolve it straight away
+func sendPagerDutyTest(httpClient *http.Client, config *Config) error {
+	key := fmt.Sprintf("veeam-monitor/%s/test", serverDisplayName(config))
+
+	err := sendPagerDutyEvent(context.Background(), httpClient, config, pagerDutyEvent{
+		RoutingKey:  config.PagerDutyRoutingKey,
+		EventAction: "trigger",
+		DedupKey:    key,
+		Payload: &pagerDutyPayload{
+			Summary:  "Veeam monitor test message",
+			Source:   serverDisplayName(config),
+			Severity: "info",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendPagerDutyEvent(context.Background(), httpClient, config, pagerDutyEvent{
+		RoutingKey:  config.PagerDutyRoutingKey,
+		EventAction: "resolve",
+		DedupKey:    key,
+	})
+}
+
+// Create a P5 Opsgenie alert and close it straight away
+func sendOpsgenieTest(httpClient *http.Client, config *Config) error {
+	alias := fmt.Sprintf("veeam-monitor/%s/test", strings.ToLower(serverDisplayName(config)))
+
+	err := sendOpsgenieRequest(context.Background(), httpClient, config, "/v2/alerts", opsgenieAlert{
+		Message:  "Veeam monitor test message",
+		Alias:    alias,
+		Tags:     []string{"veeam", serverDisplayName(config)},
+		Source:   "veeam-monitor",
+		Priority: "P5",
+	})
+	if err != nil {
+		return err
+	}
+
+	return closeOpsgenieAlert(context.Background(), httpClient, config, alias, "Test message")
+}
@@ -0,0 +1,65 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendTestEmailNeedsSettings(t *testing.T) {
+	err := SendTestEmail(testConfig())
+	if err == nil || !strings.Contains(err.Error(), "email configuration incomplete") {
+		t.Errorf("got error %v, want the missing settings named", err)
+	}
+}
+
+func TestSendTestNotifications(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ok.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer broken.Close()
+	var webhookEvent webhookTestPayload
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&webhookEvent)
+	}))
+	defer webhook.Close()
+	_, pagerDuty := newPagerDutyMonitor(t)
+
+	config := testConfig()
+	config.WebhookRetries = 0
+	config.SlackWebhookURL = ok.URL
+	config.DiscordWebhookURL = broken.URL
+	config.Webhooks = []Webhook{{Name: "Ops", URL: webhook.URL}}
+	config.PagerDutyRoutingKey, config.PagerDutyRegion = "routing-key", "test"
+
+	results := SendTestNotifications(config)
+	got := map[string]error{}
+	for _, result := range results {
+		got[result.Channel] = result.Err
+	}
+	if len(results) != 4 {
+		t.Fatalf("got results %v, want discord, slack, the webhook and PagerDuty", got)
+	}
+	for _, channel := range []string{"slack", "webhook:ops", "pagerduty"} {
+		if err, tested := got[channel]; !tested || err != nil {
+			t.Errorf("%s: got error %v, tested %v", channel, err, tested)
+		}
+	}
+	if err := got["discord"]; err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("discord: got error %v, want the 404", err)
+	}
+
+	if webhookEvent.Event != "test" {
+		t.Errorf("webhook got %+v, want a test event", webhookEvent)
+	}
+	events := pagerDuty.received()
+	if len(events) != 2 || events[0].EventAction != "trigger" || events[0].Payload.Severity != "info" || events[1].EventAction != "resolve" {
+		t.Errorf("PagerDuty got %+v, want an info event triggered and resolved", events)
+	}
+}
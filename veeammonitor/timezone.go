@@ -0,0 +1,41 @@
+package veeammonitor
+
+import (
+	"sync"
+	"time"
+	_ "time/tzdata" // Windows hosts often lack the zone database
+)
+
+// Loaded Timezone locations by name
+var locations sync.Map
+
+// Location times are shown and schedules evaluated in: Timezone, or the
+// local time zone when unset. LoadConfig rejects invalid names, so one that
+// still fails to load falls back to local time.
+func (c *Config) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	if loc, ok := locations.Load(c.Timezone); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	locations.Store(c.Timezone, loc)
+	return loc
+}
+
+// Show a source timestamp in Timezone using the PowerShell layout. ISO 8601
+// timestamps carrying a UTC offset are converted; ones without keep their
+// wall clock time, and anything else (empty, "N/A") is returned unchanged.
+func (c *Config) displayTime(value string) string {
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at.In(c.location()).Format(displayTimeLayout)
+	}
+	if at, err := time.Parse("2006-01-02T15:04:05", value); err == nil {
+		return at.Format(displayTimeLayout)
+	}
+	return value
+}
@@ -0,0 +1,94 @@
+package veeammonitor
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDisplayTime(t *testing.T) {
+	tests := []struct {
+		zone, value, want string
+	}{
+		{"UTC", "2024-03-01T12:00:00Z", "3/1/2024 12:00:00 PM"},
+		{"America/Chicago", "2024-03-01T12:00:00Z", "3/1/2024 6:00:00 AM"},
+		{"Asia/Tokyo", "2024-03-01T12:00:00Z", "3/1/2024 9:00:00 PM"},
+		{"America/Chicago", "2024-03-01T12:00:00+01:00", "3/1/2024 5:00:00 AM"},
+		{"America/Chicago", "2024-07-01T12:00:00Z", "7/1/2024 7:00:00 AM"}, // Daylight saving time
+		// Without an offset the wall clock time is kept
+		{"Asia/Tokyo", "2024-03-01T12:00:00", "3/1/2024 12:00:00 PM"},
+		{"Asia/Tokyo", "N/A", "N/A"},
+		{"Asia/Tokyo", "", ""},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.Timezone = test.zone
+		if got := config.displayTime(test.value); got != test.want {
+			t.Errorf("%s in %s: got %q, want %q", test.value, test.zone, got, test.want)
+		}
+	}
+}
+
+func TestJobTimesShownInTimezone(t *testing.T) {
+	config := testConfig()
+	config.Timezone = "America/Chicago"
+	m := newTestMonitor(config, staticRunner(jobCSVHeader+
+		`"Nightly","Failed","2024-03-01T01:00:00Z","2024-03-01T01:20:00Z","Disk full","2024-03-02T01:00:00Z","False"`+"\n", "", nil))
+
+	jobs, err := m.getJobsByStatus("Failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) == 0 {
+		t.Fatal("found no failed jobs")
+	}
+	job := jobs[0]
+	if job.StartTime != "2/29/2024 7:00:00 PM" || job.EndTime != "2/29/2024 7:20:00 PM" || job.NextRun != "3/1/2024 7:00:00 PM" {
+		t.Errorf("got start %q, end %q and next run %q, want them in US Central time", job.StartTime, job.EndTime, job.NextRun)
+	}
+}
+
+func TestSchedulesFollowTimezone(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC) // A Monday
+	tests := []struct {
+		zone       string
+		cron, week time.Time // Next 2:00 check and Monday 8:00 weekly report
+	}{
+		{"UTC", time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 8, 0, 0, 0, time.UTC)},
+		{"America/Chicago", time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 14, 0, 0, 0, time.UTC)},
+		{"Asia/Tokyo", time.Date(2024, 3, 4, 17, 0, 0, 0, time.UTC), time.Date(2024, 3, 10, 23, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.Timezone = test.zone
+		config.CronSchedule = "0 2 * * *"
+		config.WeeklyReportDay = "Monday"
+		config.WeeklyReportTime = "08:00"
+		m := newTestMonitor(config, nil)
+
+		if next := m.schedule()(now); !next.Equal(test.cron) || next.Location().String() != test.zone {
+			t.Errorf("%s: next check at %s, want %s", test.zone, next, test.cron)
+		}
+		if next := config.nextWeeklyReport(now); !next.Equal(test.week) {
+			t.Errorf("%s: next weekly report at %s, want %s", test.zone, next, test.week)
+		}
+	}
+}
+
+func TestTimezoneDefaultsToLocal(t *testing.T) {
+	if loc := testConfig().location(); loc != time.Local {
+		t.Errorf("got location %s without a timezone, want local time", loc)
+	}
+}
+
+func TestInvalidTimezoneRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"timezone": "US/Nowhere"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want ErrInvalidConfig", err)
+	}
+}
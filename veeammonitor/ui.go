@@ -0,0 +1,117 @@
+package veeammonitor
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Page listing the problems found by the last check with buttons to snooze
+// them. Forms post to /ack with ui set, which redirects back here.
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>Veeam Backup Monitor - {{.Server}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.4em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+form { display: inline; }
+.Failed, .Stale, .Stuck, .Disabled, .Missing { color: #c0392b; font-weight: bold; }
+.Warning, .Running, .Deviation { color: #d68910; font-weight: bold; }
+.acked { color: #777; }
+</style>
+</head>
+<body>
+<h1>Veeam Backup Monitor - {{.Server}}</h1>
+<p>{{if .Checked.IsZero}}Waiting for the first check.{{else}}Last check: {{.Checked.Format "Jan 2 15:04:05"}}.{{end}}
+{{if .Unreachable}}<strong>The Veeam server is unreachable, job statuses are unknown.</strong>{{end}}</p>
+{{if .Jobs}}
+<table>
+<tr><th>Job</th><th>Type</th><th>Status</th><th>Description</th><th>Alerts</th><th></th></tr>
+{{range .Jobs}}
+<tr>
+<td>{{.Job.Name}}</td>
+<td>{{.Job.JobType}}</td>
+<td class="{{.Job.Status}}">{{.Job.Status}}</td>
+<td>{{.Job.Description}}{{if .Job.Note}}<br><em>Note: {{.Job.Note}}</em>{{end}}</td>
+<td class="{{if .Acked}}acked{{end}}">{{.Acked}}</td>
+<td>
+{{$job := .Job}}{{range $.Durations}}<form method="post" action="ack">
+<input type="hidden" name="ui" value="1">
+<input type="hidden" name="job" value="{{$job.Name}}">
+<input type="hidden" name="jobType" value="{{$job.JobType}}">
+<input type="hidden" name="duration" value="{{.}}">
+<button type="submit">Snooze {{.}}</button>
+</form>
+{{end}}<form method="post" action="ack">
+<input type="hidden" name="ui" value="1">
+<input type="hidden" name="job" value="{{$job.Name}}">
+<input type="hidden" name="jobType" value="{{$job.JobType}}">
+<button type="submit" title="Silence alerts until the job recovers or shows a different problem">Until resolved</button>
+</form>
+</td>
+</tr>
+{{end}}
+</table>
+{{else if not .Unreachable}}{{if not .Checked.IsZero}}<p>No problematic jobs.</p>{{end}}{{end}}
+</body>
+</html>
+`))
+
+// Durations offered by the snooze buttons
+var uiSnoozeDurations = []string{"1h", "4h"}
+
+// A row of the page
+type uiJob struct {
+	Job   JobStatus
+	Acked string // How long alerts are silenced, empty when they aren't
+}
+
+// Serve the snooze page at /
+func (m *Monitor) handleUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m.statusMu.Lock()
+	status := m.status
+	m.statusMu.Unlock()
+
+	loc := m.Config.location()
+	m.ackMu.Lock()
+	var jobs []uiJob
+	for _, job := range status.Jobs {
+		row := uiJob{Job: job}
+		if ack := m.state.Acks[jobIdentity(job)]; ack != nil && job.Acknowledged {
+			row.Acked = "Silenced until the job changes"
+			if !ack.Expires.IsZero() {
+				row.Acked = "Snoozed until " + ack.Expires.In(loc).Format("Jan 2 15:04")
+			}
+		}
+		jobs = append(jobs, row)
+	}
+	m.ackMu.Unlock()
+
+	data := struct {
+		Server      string
+		Checked     time.Time
+		Unreachable bool
+		Jobs        []uiJob
+		Durations   []string
+	}{serverDisplayName(m.Config), status.Checked.In(loc), status.Unreachable, jobs, uiSnoozeDurations}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uiTemplate.Execute(w, data); err != nil {
+		m.logf("Error rendering the web page: %v\n", err)
+	}
+}
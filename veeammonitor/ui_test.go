@@ -0,0 +1,144 @@
+package veeammonitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Monitor whose last check found a failed and a warning job
+func newUITestMonitor() *Monitor {
+	m := newTestMonitor(testConfig(), nil)
+	m.setStatus(cycleStatus{Checked: time.Now(), Jobs: []JobStatus{
+		{Name: "Nightly", JobType: "Backup", Status: "Failed", Description: "Disk <full>"},
+		{Name: "Offsite", JobType: "Backup Copy", Status: "Warning", Description: "Slow link"},
+	}})
+	return m
+}
+
+// Render the page
+func getUIPage(t *testing.T, m *Monitor) string {
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("got status %d with content type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	return recorder.Body.String()
+}
+
+// Submit a snooze form from the page
+func postSnoozeForm(m *Monitor, values url.Values) *httptest.ResponseRecorder {
+	values.Set("ui", "1")
+	request := httptest.NewRequest(http.MethodPost, "/ack", strings.NewReader(values.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestUIPage(t *testing.T) {
+	page := getUIPage(t, newUITestMonitor())
+	for _, want := range []string{
+		"<title>Veeam Backup Monitor - localhost</title>",
+		`<td class="Failed">Failed</td>`,
+		`<td class="Warning">Warning</td>`,
+		"Disk &lt;full&gt;",
+		`<input type="hidden" name="job" value="Offsite">`,
+		`<input type="hidden" name="jobType" value="Backup Copy">`,
+		`<input type="hidden" name="duration" value="1h">`,
+		`<button type="submit">Snooze 4h</button>`,
+		"Until resolved</button>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page doesn't contain %q:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "<script") || strings.Contains(page, "http://") || strings.Contains(page, "https://") {
+		t.Error("page loads external assets or scripts")
+	}
+	if got := strings.Count(page, `action="ack"`); got != 6 {
+		t.Errorf("got %d forms, want 3 per job", got)
+	}
+}
+
+func TestUIPageStates(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	if page := getUIPage(t, m); !strings.Contains(page, "Waiting for the first check.") {
+		t.Errorf("page before the first check:\n%s", page)
+	}
+	m.setStatus(cycleStatus{Checked: time.Now()})
+	if page := getUIPage(t, m); !strings.Contains(page, "No problematic jobs.") || strings.Contains(page, "<table>") {
+		t.Errorf("page without problems:\n%s", page)
+	}
+	m.setStatus(cycleStatus{Checked: time.Now(), Unreachable: true})
+	if page := getUIPage(t, m); !strings.Contains(page, "The Veeam server is unreachable") || strings.Contains(page, "No problematic jobs.") {
+		t.Errorf("page with the server unreachable:\n%s", page)
+	}
+
+	for _, path := range []string{"/other", "/favicon.ico"} {
+		recorder := httptest.NewRecorder()
+		m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want 404", path, recorder.Code)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /: got status %d, want 405", recorder.Code)
+	}
+}
+
+func TestUISnoozeForm(t *testing.T) {
+	m := newUITestMonitor()
+	before := time.Now()
+	recorder := postSnoozeForm(m, url.Values{"job": {"Nightly"}, "jobType": {"Backup"}, "duration": {"4h"}})
+	if recorder.Code != http.StatusSeeOther || recorder.Header().Get("Location") != "/" {
+		t.Fatalf("got status %d redirecting to %q, want the page shown again", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	ack := m.state.Acks["backup/nightly"]
+	if ack == nil || ack.Expires.Before(before.Add(4*time.Hour)) || ack.Expires.After(time.Now().Add(4*time.Hour)) {
+		t.Fatalf("got ack %+v, want Nightly snoozed for 4 hours", ack)
+	}
+	if _, ok := m.state.Acks["backup copy/offsite"]; ok {
+		t.Error("snoozing Nightly acknowledged Offsite")
+	}
+	page := getUIPage(t, m)
+	if want := "Snoozed until " + ack.Expires.In(m.Config.location()).Format("Jan 2 15:04"); !strings.Contains(page, want) {
+		t.Errorf("page doesn't show %q:\n%s", want, page)
+	}
+
+	// Until resolved has no duration
+	postSnoozeForm(m, url.Values{"job": {"Offsite"}, "jobType": {"Backup Copy"}})
+	if ack := m.state.Acks["backup copy/offsite"]; ack == nil || !ack.Expires.IsZero() {
+		t.Errorf("got ack %+v, want Offsite silenced until it changes", ack)
+	}
+	if page := getUIPage(t, m); !strings.Contains(page, "Silenced until the job changes") {
+		t.Errorf("page doesn't show the ack until resolved:\n%s", page)
+	}
+}
+
+func TestUISnoozeFormErrors(t *testing.T) {
+	m := newUITestMonitor()
+	tests := []struct {
+		name   string
+		values url.Values
+		want   int
+	}{
+		{"no job", url.Values{"duration": {"1h"}}, http.StatusBadRequest},
+		{"bad duration", url.Values{"job": {"Nightly"}, "duration": {"soon"}}, http.StatusBadRequest},
+		{"job without a problem", url.Values{"job": {"Weekly"}, "duration": {"1h"}}, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if recorder := postSnoozeForm(m, test.values); recorder.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.want)
+		}
+	}
+	if len(m.state.Acks) != 0 {
+		t.Errorf("got acks %+v from rejected forms", m.state.Acks)
+	}
+}
@@ -0,0 +1,112 @@
+package veeammonitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Time allowed for a heartbeat ping or a reachability check
+const heartbeatTimeout = 10 * time.Second
+
+// How often the watchdog checks for a stalled monitor
+const watchdogCheckInterval = time.Minute
+
+// Record a fully successful cycle and ping HeartbeatURL. The ping runs in
+// the background so a slow heartbeat service can't delay the next check.
+func (m *Monitor) recordCycleSuccess(now time.Time) {
+	m.healthMu.Lock()
+	m.lastSuccess = now
+	m.healthMu.Unlock()
+
+	if m.Config.HeartbeatURL != "" {
+		go func() {
+			if err := pingHeartbeat(m.httpClient, m.Config.HeartbeatURL); err != nil {
+				m.logf("Error pinging heartbeat URL: %v\n", err)
+			}
+		}()
+	}
+}
+
+// Tell an external dead man's switch, such as healthchecks.io, that the
+// monitor is alive
+func pingHeartbeat(httpClient *http.Client, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), heartbeatTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return redactURLError(err)
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return redactURLError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("heartbeat returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// Time without a successful cycle after which the monitor counts as stalled:
+// WatchdogStalenessMinutes, or three check intervals when it is 0. Zero when
+// the watchdog is off.
+func (c *Config) watchdogStaleness() time.Duration {
+	if c.WatchdogStalenessMinutes > 0 {
+		return time.Duration(c.WatchdogStalenessMinutes) * time.Minute
+	}
+	// Cron schedules have no fixed interval to derive a limit from
+	if c.WatchdogStalenessMinutes < 0 || c.CronSchedule != "" {
+		return 0
+	}
+	return 3 * time.Duration(c.CheckIntervalMinutes) * time.Minute
+}
+
+// Whether the last successful cycle is older than staleness, along with its age.
+// Before the first success the age counts from startup.
+func (m *Monitor) stalled(now time.Time, staleness time.Duration) (bool, time.Duration) {
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+	age := now.Sub(m.lastSuccess)
+	return staleness > 0 && age > staleness, age
+}
+
+// Log an error every time a check finds no successful cycle within the
+// staleness limit, until cycles succeed again. Runs until ctx is cancelled.
+func (m *Monitor) watchdog(ctx context.Context, started time.Time) {
+	staleness := m.Config.watchdogStaleness()
+	if staleness == 0 {
+		return
+	}
+
+	m.healthMu.Lock()
+	if m.lastSuccess.IsZero() {
+		m.lastSuccess = started
+	}
+	m.healthMu.Unlock()
+
+	ticker := time.NewTicker(watchdogCheckInterval)
+	defer ticker.Stop()
+	wasStalled := false
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+		stalled, age := m.stalled(now, staleness)
+		if stalled {
+			m.logf("ERROR: WATCHDOG: No successful check in %s (limit %s), alerts may not be getting sent\n",
+				age.Round(time.Minute), staleness)
+		} else if wasStalled {
+			m.logln("Watchdog: Checks are succeeding again")
+		}
+		wasStalled = stalled
+	}
+}
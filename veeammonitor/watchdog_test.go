@@ -0,0 +1,90 @@
+package veeammonitor
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatPingedAfterSuccessfulCycle(t *testing.T) {
+	pings := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings <- r.Method + " " + r.URL.Path
+	}))
+	defer server.Close()
+	config := testConfig()
+	config.HeartbeatURL = server.URL + "/ping/abc"
+
+	// A failed query isn't a successful cycle
+	m := newTestMonitor(config, staticRunner("", "Get-VBRJob : Access is denied", errors.New("exit status 1")))
+	m.RunCheckCycle()
+	m = newTestMonitor(config, statusRunner(nil, nil))
+	m.RunCheckCycle()
+
+	select {
+	case ping := <-pings:
+		if ping != "GET /ping/abc" {
+			t.Errorf("got %s, want GET /ping/abc", ping)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat wasn't pinged")
+	}
+	select {
+	case ping := <-pings:
+		t.Errorf("got a second ping %s, want only the successful cycle's", ping)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPingHeartbeatFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	if err := pingHeartbeat(http.DefaultClient, server.URL); err == nil {
+		t.Error("got no error for a 404")
+	}
+}
+
+func TestWatchdogStaleness(t *testing.T) {
+	tests := []struct {
+		staleness, interval int
+		cron                string
+		want                time.Duration
+	}{
+		{0, 15, "", 45 * time.Minute},
+		{20, 15, "", 20 * time.Minute},
+		{-1, 15, "", 0},
+		{0, 15, "0 8 * * *", 0},
+		{90, 15, "0 8 * * *", 90 * time.Minute},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.WatchdogStalenessMinutes, config.CheckIntervalMinutes, config.CronSchedule = test.staleness, test.interval, test.cron
+		if got := config.watchdogStaleness(); got != test.want {
+			t.Errorf("staleness %d, interval %d, cron %q: got %v, want %v", test.staleness, test.interval, test.cron, got, test.want)
+		}
+	}
+}
+
+func TestStalled(t *testing.T) {
+	m := newTestMonitor(testConfig(), nil)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	m.recordCycleSuccess(start)
+
+	if stalled, _ := m.stalled(start.Add(45*time.Minute), 45*time.Minute); stalled {
+		t.Error("stalled at the limit")
+	}
+	if stalled, age := m.stalled(start.Add(46*time.Minute), 45*time.Minute); !stalled || age != 46*time.Minute {
+		t.Errorf("got stalled %v after %v, want stalled after 46 minutes", stalled, age)
+	}
+	if stalled, _ := m.stalled(start.Add(46*time.Minute), 0); stalled {
+		t.Error("stalled with the watchdog off")
+	}
+	m.recordCycleSuccess(start.Add(50 * time.Minute))
+	if stalled, _ := m.stalled(start.Add(60*time.Minute), 45*time.Minute); stalled {
+		t.Error("still stalled after a successful cycle")
+	}
+}
@@ -0,0 +1,173 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Longest wait between attempts to post to a webhook
+const maxWebhookRetryDelay = 30 * time.Second
+
+// Webhook is an HTTP endpoint alerts are posted to as JSON, for systems
+// without a notifier of their own
+type Webhook struct {
+	Name    string            `json:"name" yaml:"name"`
+	URL     string            `json:"url" yaml:"url" secret:"true"`
+	Headers map[string]string `json:"headers" yaml:"headers"`             // Sent with every request, e.g. {"Authorization": "Bearer ..."}
+	Secret  string            `json:"secret" yaml:"secret" secret:"true"` // Key of the X-Veeam-Signature HMAC, empty leaves requests unsigned
+}
+
+// Channel name of a webhook's alerts, for NotificationRouting
+func (w Webhook) channel() string {
+	return "webhook:" + strings.ToLower(w.Name)
+}
+
+// Body of an alert posted to a webhook: the event, then every field of the
+// AlertReport
+type webhookPayload struct {
+	Event string `json:"event"` // alert, or test for -test-notify
+	*AlertReport
+}
+
+// Posts alerts to one of Webhooks
+type webhookNotifier struct {
+	config     *Config
+	httpClient *http.Client
+	webhook    Webhook
+}
+
+func (n webhookNotifier) Name() string { return n.webhook.channel() }
+
+func (n webhookNotifier) Notify(ctx context.Context, report *AlertReport) error {
+	return postWebhook(ctx, n.httpClient, n.config, n.webhook, webhookPayload{Event: "alert", AlertReport: report})
+}
+
+// Check that every webhook has a unique name and an http or https URL
+func validateWebhooks(webhooks []Webhook) error {
+	names := map[string]bool{}
+	for _, webhook := range webhooks {
+		if webhook.Name == "" || webhook.URL == "" {
+			return fmt.Errorf("webhooks entries need a name and a url")
+		}
+		if names[strings.ToLower(webhook.Name)] {
+			return fmt.Errorf("webhooks has more than one entry named %q", webhook.Name)
+		}
+		names[strings.ToLower(webhook.Name)] = true
+		parsed, err := url.Parse(webhook.URL)
+		if err != nil || parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("webhooks %q url must be an http or https URL", webhook.Name)
+		}
+	}
+	return nil
+}
+
+// Hex HMAC-SHA256 of a request body, sent as X-Veeam-Signature: sha256=...
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post a payload to a webhook, retrying up to WebhookRetries times when the
+// request fails or the webhook answers 429 or a server error. Other answers
+// outside 2xx aren't retried, as sending the same request again won't
+// change them.
+func postWebhook(ctx context.Context, httpClient *http.Client, config *Config, webhook Webhook, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return retryPost(ctx, config.WebhookRetries, "webhook "+webhook.Name, func() (time.Duration, error) {
+		return sendWebhookRequest(ctx, httpClient, webhook, body)
+	})
+}
+
+// Call send until it succeeds, returns a negative retryAfter or has been
+// retried retries times, waiting a doubling delay from one second between
+// attempts, or the retryAfter it returned when longer. target names what is
+// posted to in the log.
+func retryPost(ctx context.Context, retries int, target string, send func() (retryAfter time.Duration, err error)) error {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := send()
+		if err == nil || retryAfter < 0 || attempt >= retries {
+			return err
+		}
+		wait := delay
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > maxWebhookRetryDelay {
+			wait = maxWebhookRetryDelay
+		}
+		log.Printf("Error posting to %s, retrying in %v: %v\n", target, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// Send one request to a webhook. When it fails, retryAfter is how long the
+// webhook asked to wait before retrying (0 when it didn't say), or negative
+// when the request shouldn't be retried.
+func sendWebhookRequest(ctx context.Context, httpClient *http.Client, webhook Webhook, body []byte) (retryAfter time.Duration, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, redactURLError(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "veeam-monitor")
+	for name, value := range webhook.Headers {
+		request.Header.Set(name, value)
+	}
+	if webhook.Secret != "" {
+		request.Header.Set("X-Veeam-Signature", webhookSignature(webhook.Secret, body))
+	}
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, redactURLError(err)
+		}
+		return 0, redactURLError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return 0, nil
+	}
+	err = fmt.Errorf("webhook returned status %s", resp.Status)
+	if reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512)); strings.TrimSpace(string(reason)) != "" {
+		err = fmt.Errorf("webhook returned status %s: %s", resp.Status, firstLine(strings.TrimSpace(string(reason))))
+	}
+	return retryAfterStatus(resp), err
+}
+
+// How long to wait before retrying a request that got resp: the
+// Retry-After seconds, 0 when there are none, or -1 when the status isn't
+// worth retrying, being neither 429 nor a server error
+func retryAfterStatus(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return -1
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+package veeammonitor
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Request received by a webhook
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// Stand-in webhook answering each request with the next of statuses, the
+// last one repeating, and collecting the requests. A 400 comes with a reason.
+func newWebhookServer(t *testing.T, statuses ...int) (string, func() []webhookRequest) {
+	var mu sync.Mutex
+	var requests []webhookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, webhookRequest{r.Header, body})
+		status := statuses[len(statuses)-1]
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+		if status == http.StatusBadRequest {
+			fmt.Fprint(w, "invalid payload\nat line 1")
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() []webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhookRequest{}, requests...)
+	}
+}
+
+func TestWebhookAlert(t *testing.T) {
+	url, received := newWebhookServer(t, http.StatusNoContent)
+	config := testConfig()
+	webhook := Webhook{Name: "Ops", URL: url, Headers: map[string]string{"Authorization": "Bearer abc", "X-Env": "prod"}, Secret: "s3cr3t"}
+	report := NewAlertReport([]JobStatus{{Name: "Nightly", JobType: "Backup", Status: "Failed"}}, nil, severityCritical, config, time.Now())
+
+	notifier := webhookNotifier{config: config, httpClient: http.DefaultClient, webhook: webhook}
+	if notifier.Name() != "webhook:ops" {
+		t.Errorf("got channel %q", notifier.Name())
+	}
+	if err := notifier.Notify(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	requests := received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	for name, want := range map[string]string{"Content-Type": "application/json", "User-Agent": "veeam-monitor", "Authorization": "Bearer abc", "X-Env": "prod"} {
+		if got := request.header.Get(name); got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write(request.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); request.header.Get("X-Veeam-Signature") != want {
+		t.Errorf("got signature %q, want %q", request.header.Get("X-Veeam-Signature"), want)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(request.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "alert" || payload.Server != "localhost" || payload.Counts.Failed != 1 || payload.Failed[0].Name != "Nightly" {
+		t.Errorf("got payload %s", request.body)
+	}
+
+	// Without a secret requests aren't signed
+	webhook.Secret = ""
+	postWebhook(context.Background(), http.DefaultClient, config, webhook, webhookPayload{Event: "alert", AlertReport: report})
+	if signature := received()[1].header.Get("X-Veeam-Signature"); signature != "" {
+		t.Errorf("got signature %q without a secret", signature)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	config := testConfig()
+	config.WebhookRetries = 2
+	url, received := newWebhookServer(t, http.StatusServiceUnavailable, http.StatusOK)
+	if err := postWebhook(context.Background(), http.DefaultClient, config, Webhook{Name: "ops", URL: url}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(received()) != 2 {
+		t.Errorf("got %d requests, want a retry after 503", len(received()))
+	}
+
+	// Client errors aren't retried
+	url, received = newWebhookServer(t, http.StatusBadRequest)
+	err := postWebhook(context.Background(), http.DefaultClient, config, Webhook{Name: "ops", URL: url}, nil)
+	if err == nil || err.Error() != "webhook returned status 400 Bad Request: invalid payload" || len(received()) != 1 {
+		t.Errorf("got error %v after %d requests", err, len(received()))
+	}
+
+	config.WebhookRetries = 0
+	url, received = newWebhookServer(t, http.StatusTooManyRequests)
+	if err := postWebhook(context.Background(), http.DefaultClient, config, Webhook{Name: "ops", URL: url}, nil); err == nil || len(received()) != 1 {
+		t.Errorf("got error %v after %d requests, want no retries", err, len(received()))
+	}
+
+	// The wait between attempts ends with the context
+	config.WebhookRetries = 3
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := postWebhook(ctx, http.DefaultClient, config, Webhook{Name: "ops", URL: url}, nil); err == nil || time.Since(start) > 900*time.Millisecond {
+		t.Errorf("got error %v after %v, want to give up when the context ends", err, time.Since(start))
+	}
+}
+
+func TestRetryAfterStatus(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		want       time.Duration
+	}{
+		{http.StatusTooManyRequests, "5", 5 * time.Second},
+		{http.StatusServiceUnavailable, "", 0},
+		{http.StatusInternalServerError, "soon", 0},
+		{http.StatusNotFound, "5", -1},
+	}
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.status, Header: http.Header{"Retry-After": {test.retryAfter}}}
+		if got := retryAfterStatus(resp); got != test.want {
+			t.Errorf("retryAfterStatus(%d, %q) = %v, want %v", test.status, test.retryAfter, got, test.want)
+		}
+	}
+}
+
+func TestValidateWebhooks(t *testing.T) {
+	tests := []struct {
+		webhooks []Webhook
+		valid    bool
+	}{
+		{[]Webhook{{Name: "ops", URL: "https://hooks.example/ops"}, {Name: "dev", URL: "http://10.0.0.5:8080/alerts"}}, true},
+		{[]Webhook{{Name: "ops"}}, false},
+		{[]Webhook{{URL: "https://hooks.example/ops"}}, false},
+		{[]Webhook{{Name: "ops", URL: "https://hooks.example/ops"}, {Name: "OPS", URL: "https://hooks.example/other"}}, false},
+		{[]Webhook{{Name: "ops", URL: "ftp://hooks.example/ops"}}, false},
+		{[]Webhook{{Name: "ops", URL: "hooks.example/ops"}}, false},
+	}
+	for _, test := range tests {
+		if err := validateWebhooks(test.webhooks); (err == nil) != test.valid {
+			t.Errorf("validateWebhooks(%+v) = %v, want valid %v", test.webhooks, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package veeammonitor
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Jobs listed under each heading of the weekly report
+const weeklyReportTopJobs = 10
+
+// One job's problems over a week of archived reports
+type weeklyJobTrend struct {
+	Name       string
+	JobType    string
+	Failures   int     // Distinct failed sessions
+	Warnings   int     // Distinct sessions that ended with warnings
+	Stale      int     // Checks that found the job hadn't run in time
+	LongestRun float64 // Longest run in minutes seen by the long-running check
+}
+
+// Summary of the problems in the reports archived over one week
+type weeklyTrend struct {
+	Start, End time.Time
+	Checks     int       // Archived reports in the week
+	Since      time.Time // Oldest archived report, when the archive doesn't reach back to Start
+	Failures   int
+	Warnings   int
+	Jobs       []weeklyJobTrend // Jobs with problems, most failures first
+
+	Previous *weeklyTrend // The week before, nil when nothing was archived then
+}
+
+// Parse WeeklyReportDay, a weekday name such as Monday or Mon
+func parseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(strings.TrimSpace(day))
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if day == name || (len(day) == 3 && strings.HasPrefix(name, day)) {
+			return weekday, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a day of the week", day)
+}
+
+// First WeeklyReportDay at WeeklyReportTime after now, in the configured time zone
+func (c *Config) nextWeeklyReport(now time.Time) time.Time {
+	weekday, _ := parseWeekday(c.WeeklyReportDay)
+	at, _ := time.Parse("15:04", c.WeeklyReportTime)
+	now = now.In(c.location())
+
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	next = next.AddDate(0, 0, (int(weekday)-int(now.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+// Email the weekly trend report every WeeklyReportDay at WeeklyReportTime, until
+// ctx is cancelled
+func (m *Monitor) runWeeklyReports(ctx context.Context) {
+	for {
+		next := m.Config.nextWeeklyReport(time.Now())
+		m.logf("Next weekly report at %s\n", formatNextCheck(next))
+		if !sleepUntil(ctx, next) {
+			return
+		}
+
+		if err := m.sendWeeklyReport(time.Now()); err != nil {
+			m.logf("Error sending weekly report: %v\n", err)
+		}
+	}
+}
+
+// Build the trend of the week before now from ReportHistoryDir and email it
+func (m *Monitor) sendWeeklyReport(now time.Time) error {
+	config := m.Config
+	reports, err := readReportArchives(config.ReportHistoryDir, now.AddDate(0, 0, -14), now)
+	if err != nil {
+		return err
+	}
+	trend := buildWeeklyTrend(reports, now.AddDate(0, 0, -7), now)
+	if trend.Checks == 0 {
+		m.logln("No checks archived in the last week, not sending the weekly report")
+		return nil
+	}
+
+	subject, body := weeklyReportEmail(trend, config)
+	ctx, cancel := m.notificationContext()
+	err = sendEmail(ctx, m.httpClient, config, subject, body)
+	cancel()
+	m.recordNotification(config.emailChannel(), err)
+	if err != nil {
+		return err
+	}
+	m.logf("Sent weekly report covering %d checks\n", trend.Checks)
+	return nil
+}
+
+// Read the reports archived in dir with timestamps in [from, to), oldest
+// first. Unreadable archives are logged and skipped.
+func readReportArchives(dir string, from, to time.Time) ([]AlertReport, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report history directory: %v", err)
+	}
+
+	var reports []AlertReport
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, reportArchivePrefix) || !strings.HasSuffix(name, reportArchiveSuffix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, reportArchivePrefix), reportArchiveSuffix)
+		timestamp, err := time.Parse(reportArchiveLayout, stamp)
+		if err != nil || timestamp.Before(from) || !timestamp.Before(to) {
+			continue
+		}
+		report, err := readReportArchive(filepath.Join(dir, name))
+		if err != nil {
+			log.Printf("Error reading archived report %s: %v\n", name, err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].Timestamp.Before(reports[j].Timestamp) })
+	return reports, nil
+}
+
+// Decode one gzip-compressed archived report
+func readReportArchive(path string) (AlertReport, error) {
+	var report AlertReport
+	file, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return report, err
+	}
+	defer reader.Close()
+	err = json.NewDecoder(reader).Decode(&report)
+	return report, err
+}
+
+// Aggregate the reports timestamped in [start, end), with the week before
+// start as the previous week. A failed or warning session listed by many
+// checks counts once, told apart by its end time.
+func buildWeeklyTrend(reports []AlertReport, start, end time.Time) weeklyTrend {
+	trend := weeklyTrend{Start: start, End: end}
+	var previous []AlertReport
+	var week []AlertReport
+	for _, report := range reports {
+		switch {
+		case report.Timestamp.Before(start) && !report.Timestamp.Before(start.AddDate(0, 0, -7)):
+			previous = append(previous, report)
+		case !report.Timestamp.Before(start) && report.Timestamp.Before(end):
+			week = append(week, report)
+		}
+	}
+
+	jobs := map[string]*weeklyJobTrend{}
+	sessions := map[string]bool{}
+	job := func(status JobStatus) *weeklyJobTrend {
+		key := jobIdentity(status)
+		if jobs[key] == nil {
+			jobs[key] = &weeklyJobTrend{Name: status.Name, JobType: status.JobType}
+		}
+		return jobs[key]
+	}
+	newSession := func(status JobStatus) bool {
+		key := status.Status + "/" + jobIdentity(status) + "/" + status.EndTime
+		if sessions[key] {
+			return false
+		}
+		sessions[key] = true
+		return true
+	}
+
+	for _, report := range week {
+		trend.Checks++
+		for _, status := range report.Failed {
+			if newSession(status) {
+				job(status).Failures++
+				trend.Failures++
+			}
+		}
+		for _, status := range report.Warning {
+			if newSession(status) {
+				job(status).Warnings++
+				trend.Warnings++
+			}
+		}
+		for _, status := range report.Stale {
+			job(status).Stale++
+		}
+		for _, status := range report.Running {
+			if minutes, err := strconv.ParseFloat(invariantDecimal(status.Duration), 64); err == nil && minutes > job(status).LongestRun {
+				job(status).LongestRun = minutes
+			}
+		}
+	}
+	if len(week) > 0 && week[0].Timestamp.Sub(start) > 24*time.Hour && len(previous) == 0 {
+		trend.Since = week[0].Timestamp
+	}
+
+	for _, job := range jobs {
+		trend.Jobs = append(trend.Jobs, *job)
+	}
+	sort.Slice(trend.Jobs, func(i, j int) bool {
+		a, b := trend.Jobs[i], trend.Jobs[j]
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		if a.Warnings != b.Warnings {
+			return a.Warnings > b.Warnings
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+
+	if len(previous) > 0 {
+		before := buildWeeklyTrend(previous, start.AddDate(0, 0, -7), start)
+		trend.Previous = &before
+	}
+	return trend
+}
+
+// Change from the previous week, e.g. " (previous week: 8, +4)"
+func weeklyChange(current int, previous *weeklyTrend, count func(*weeklyTrend) int) string {
+	if previous == nil {
+		return " (previous week: no data)"
+	}
+	before := count(previous)
+	return fmt.Sprintf(" (previous week: %d, %+d)", before, current-before)
+}
+
+// Render the weekly report email
+func weeklyReportEmail(trend weeklyTrend, config *Config) (subject, body string) {
+	loc := config.location()
+	start, end := trend.Start.In(loc), trend.End.In(loc)
+	subject = fmt.Sprintf("Veeam weekly report (%s): %d failed, %d warning sessions", serverDisplayName(config), trend.Failures, trend.Warnings)
+
+	body = "Veeam Backup & Replication Weekly Report\n"
+	body += "========================================\n\n"
+	body += fmt.Sprintf("Server: %s\n", serverDisplayName(config))
+	body += fmt.Sprintf("Week: %s to %s\n", start.Format("Mon Jan 2 15:04"), end.Format("Mon Jan 2 15:04"))
+	body += fmt.Sprintf("Checks recorded: %d\n", trend.Checks)
+	body += fmt.Sprintf("Failed sessions: %d%s\n", trend.Failures, weeklyChange(trend.Failures, trend.Previous, func(t *weeklyTrend) int { return t.Failures }))
+	body += fmt.Sprintf("Sessions with warnings: %d%s\n", trend.Warnings, weeklyChange(trend.Warnings, trend.Previous, func(t *weeklyTrend) int { return t.Warnings }))
+	if !trend.Since.IsZero() {
+		body += fmt.Sprintf("\nNote: the report history only goes back to %s, so this week's figures are incomplete.\n", trend.Since.In(loc).Format("Mon Jan 2 15:04"))
+	}
+	body += "\n"
+
+	var offenders, slow, stale []weeklyJobTrend
+	for _, job := range trend.Jobs {
+		if job.Failures+job.Warnings > 0 {
+			offenders = append(offenders, job)
+		}
+		if job.LongestRun > 0 {
+			slow = append(slow, job)
+		}
+		if job.Stale > 0 {
+			stale = append(stale, job)
+		}
+	}
+	sort.SliceStable(slow, func(i, j int) bool { return slow[i].LongestRun > slow[j].LongestRun })
+
+	if len(offenders) == 0 {
+		body += "No job failed or finished with warnings this week.\n\n"
+	} else {
+		body += "TOP OFFENDERS:\n"
+		body += "--------------\n"
+		for i, job := range offenders {
+			if i == weeklyReportTopJobs {
+				body += fmt.Sprintf("... and %d more jobs\n", len(offenders)-i)
+				break
+			}
+			body += fmt.Sprintf("%s (%s): %d failed, %d with warnings\n", job.Name, job.JobType, job.Failures, job.Warnings)
+		}
+		body += "\n"
+	}
+
+	if len(slow) > 0 {
+		body += "LONGEST RUNS:\n"
+		body += "-------------\n"
+		for i, job := range slow {
+			if i == weeklyReportTopJobs {
+				body += fmt.Sprintf("... and %d more jobs\n", len(slow)-i)
+				break
+			}
+			body += fmt.Sprintf("%s (%s): %.0f minutes\n", job.Name, job.JobType, job.LongestRun)
+		}
+		body += "\n"
+	}
+
+	if len(stale) > 0 {
+		body += "JOBS THAT MISSED THEIR RUNS:\n"
+		body += "----------------------------\n"
+		for _, job := range stale {
+			body += fmt.Sprintf("%s (%s): overdue in %d of %d checks\n", job.Name, job.JobType, job.Stale, trend.Checks)
+		}
+		body += "\n"
+	}
+
+	body += "This is an automated message from the Veeam Backup Monitor.\n"
+	return subject, body
+}
@@ -0,0 +1,147 @@
+package veeammonitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Week ending Monday March 11th 2024 at 08:00 UTC
+var weeklyTestEnd = time.Date(2024, 3, 11, 8, 0, 0, 0, time.UTC)
+
+// A report of a check some hours before weeklyTestEnd
+func weeklyTestReport(hoursBefore int, jobs ...JobStatus) AlertReport {
+	config := testConfig()
+	return *NewAlertReport(jobs, nil, severityCritical, config, weeklyTestEnd.Add(-time.Duration(hoursBefore)*time.Hour))
+}
+
+// Reports of two weeks of checks: Nightly failed the same session in three
+// checks and another session later, Weekly warned once, Archive missed its
+// runs twice and Mail ran long. The week before Nightly failed once.
+func seededWeeklyReports() []AlertReport {
+	nightly := JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "3/5/2024 1:20:00 AM"}
+	nightlyAgain := nightly
+	nightlyAgain.EndTime = "3/8/2024 1:20:00 AM"
+	weekly := JobStatus{Name: "Weekly", JobType: "Backup", Status: "Warning", EndTime: "3/9/2024 3:00:00 AM"}
+	archive := JobStatus{Name: "Archive", JobType: "Backup Copy", Status: "Stale"}
+	mail := func(minutes string) JobStatus {
+		return JobStatus{Name: "Mail", JobType: "Backup", Status: "Running", Duration: minutes}
+	}
+	return []AlertReport{
+		weeklyTestReport(24*10, JobStatus{Name: "Nightly", JobType: "Backup", Status: "Failed", EndTime: "2/29/2024 1:20:00 AM"}),
+		weeklyTestReport(24*6, nightly),
+		weeklyTestReport(24*6-1, nightly, archive),
+		weeklyTestReport(24*6-2, nightly, mail("300.5")),
+		weeklyTestReport(24*3, nightlyAgain, archive, mail("420")),
+		weeklyTestReport(24*2, weekly),
+		weeklyTestReport(1),
+	}
+}
+
+func TestBuildWeeklyTrend(t *testing.T) {
+	trend := buildWeeklyTrend(seededWeeklyReports(), weeklyTestEnd.AddDate(0, 0, -7), weeklyTestEnd)
+	if trend.Checks != 6 || trend.Failures != 2 || trend.Warnings != 1 || !trend.Since.IsZero() {
+		t.Errorf("got %d checks, %d failures and %d warnings since %v, want 6, 2 and 1", trend.Checks, trend.Failures, trend.Warnings, trend.Since)
+	}
+
+	want := []weeklyJobTrend{
+		{Name: "Nightly", JobType: "Backup", Failures: 2},
+		{Name: "Weekly", JobType: "Backup", Warnings: 1},
+		{Name: "Archive", JobType: "Backup Copy", Stale: 2},
+		{Name: "Mail", JobType: "Backup", LongestRun: 420},
+	}
+	if len(trend.Jobs) != len(want) {
+		t.Fatalf("got jobs %+v, want %+v", trend.Jobs, want)
+	}
+	for i := range want {
+		if trend.Jobs[i] != want[i] {
+			t.Errorf("job %d: got %+v, want %+v", i, trend.Jobs[i], want[i])
+		}
+	}
+
+	if trend.Previous == nil || trend.Previous.Checks != 1 || trend.Previous.Failures != 1 {
+		t.Errorf("got previous week %+v, want one failure in one check", trend.Previous)
+	}
+}
+
+func TestWeeklyTrendFirstWeek(t *testing.T) {
+	reports := seededWeeklyReports()[4:]
+	trend := buildWeeklyTrend(reports, weeklyTestEnd.AddDate(0, 0, -7), weeklyTestEnd)
+	if trend.Previous != nil || !trend.Since.Equal(reports[0].Timestamp) {
+		t.Errorf("got previous week %+v since %v, want the history flagged as starting mid-week", trend.Previous, trend.Since)
+	}
+
+	config := testConfig()
+	config.Timezone = "UTC"
+	_, body := weeklyReportEmail(trend, config)
+	for _, want := range []string{
+		"Failed sessions: 1 (previous week: no data)\n",
+		"Note: the report history only goes back to Fri Mar 8 08:00, so this week's figures are incomplete.",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+
+	if trend := buildWeeklyTrend(nil, weeklyTestEnd.AddDate(0, 0, -7), weeklyTestEnd); trend.Checks != 0 || len(trend.Jobs) != 0 {
+		t.Errorf("got trend %+v without history", trend)
+	}
+}
+
+func TestWeeklyReportEmail(t *testing.T) {
+	config := testConfig()
+	config.Timezone = "UTC"
+	trend := buildWeeklyTrend(seededWeeklyReports(), weeklyTestEnd.AddDate(0, 0, -7), weeklyTestEnd)
+	subject, body := weeklyReportEmail(trend, config)
+	if subject != "Veeam weekly report (localhost): 2 failed, 1 warning sessions" {
+		t.Errorf("got subject %q", subject)
+	}
+	for _, want := range []string{
+		"Week: Mon Mar 4 08:00 to Mon Mar 11 08:00\n",
+		"Checks recorded: 6\n",
+		"Failed sessions: 2 (previous week: 1, +1)\n",
+		"TOP OFFENDERS:\n--------------\nNightly (Backup): 2 failed, 0 with warnings\nWeekly (Backup): 0 failed, 1 with warnings\n\n",
+		"LONGEST RUNS:\n-------------\nMail (Backup): 420 minutes\n",
+		"Archive (Backup Copy): overdue in 2 of 6 checks\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestSendWeeklyReport(t *testing.T) {
+	dir := t.TempDir()
+	for _, report := range seededWeeklyReports() {
+		data, err := json.Marshal(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := archiveReport(dir, data, report.Timestamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, reportArchivePrefix+"20240310T000000Z"+reportArchiveSuffix), []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	relay := newFakeSMTP(t)
+	config := smtpTestConfig(relay.addr, "managers@example.com")
+	config.ReportHistoryDir = dir
+	m := newTestMonitor(config, nil)
+	if err := m.sendWeeklyReport(weeklyTestEnd); err != nil {
+		t.Fatal(err)
+	}
+	delivered := relay.delivered()
+	if len(delivered) != 1 || !strings.Contains(delivered[0].data, "Subject: Veeam weekly report (localhost): 2 failed, 1 warning sessions") {
+		t.Fatalf("delivered %+v, want the weekly report", delivered)
+	}
+
+	// Nothing is sent for a week without checks
+	if err := m.sendWeeklyReport(weeklyTestEnd.AddDate(0, 0, 30)); err != nil || len(relay.delivered()) != 1 {
+		t.Errorf("got error %v and %d emails for a week without checks", err, len(relay.delivered()))
+	}
+}
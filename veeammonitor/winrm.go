@@ -0,0 +1,340 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// WS-Management URIs used by the WinRM shell protocol
+const (
+	winrmShellURI        = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+	winrmActionCreate    = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	winrmActionDelete    = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	winrmActionCommand   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	winrmActionReceive   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	winrmActionSignal    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+	winrmSignalTerminate = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	winrmCommandDone     = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+
+	// Fault returned when a Receive finds no new output within the operation timeout
+	winrmTimeoutFaultCode = "2150858793"
+)
+
+// WinRMRunner runs scripts on a remote Windows host over WinRM, so the
+// monitor itself can run on a machine without the Veeam console. It uses
+// Basic authentication, which must be enabled on the WinRM service; use
+// HTTPS unless the network is trusted.
+type WinRMRunner struct {
+	Host               string
+	Port               int // Defaults to 5985, or 5986 with HTTPS
+	Username           string
+	Password           string
+	HTTPS              bool
+	InsecureSkipVerify bool              // Accept self-signed WinRM certificates
+	Timeout            time.Duration     // Kill scripts running longer than this, 0 for no limit
+	Env                map[string]string // Environment variables of the remote shell, used to pass secrets outside the script
+
+	client *http.Client
+}
+
+// Endpoint URL of the WinRM service
+func (r *WinRMRunner) endpoint() string {
+	scheme, port := "http", r.Port
+	if r.HTTPS {
+		scheme = "https"
+	}
+	if port == 0 {
+		port = 5985
+		if r.HTTPS {
+			port = 5986
+		}
+	}
+	return fmt.Sprintf("%s://%s/wsman", scheme, net.JoinHostPort(r.Host, strconv.Itoa(port)))
+}
+
+func (r *WinRMRunner) Run(script string) (string, string, error) {
+	return r.RunContext(context.Background(), script)
+}
+
+// RunContext runs a script like Run, terminating the remote command when ctx
+// is cancelled. The shell is still cleaned up after cancellation.
+func (r *WinRMRunner) RunContext(ctx context.Context, script string) (string, string, error) {
+	shellID, err := r.createShell(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	defer r.send(context.Background(), winrmActionDelete, shellID, "")
+
+	commandID, err := r.startCommand(ctx, shellID, script)
+	if err != nil {
+		return "", "", err
+	}
+
+	stdout, stderr, exitCode, err := r.receiveOutput(ctx, shellID, commandID)
+	if err != nil {
+		r.send(context.Background(), winrmActionSignal, shellID, fmt.Sprintf(
+			`<rsp:Signal CommandId="%s"><rsp:Code>%s</rsp:Code></rsp:Signal>`, commandID, winrmSignalTerminate))
+		return stdout, stderr, err
+	}
+	stderr = decodeCLIXML(stderr)
+	if exitCode != 0 {
+		return stdout, stderr, fmt.Errorf("exit status %d", exitCode)
+	}
+	return stdout, stderr, nil
+}
+
+// Open a remote cmd shell and return its ID
+func (r *WinRMRunner) createShell(ctx context.Context) (string, error) {
+	var env strings.Builder
+	if len(r.Env) > 0 {
+		env.WriteString("<rsp:Environment>")
+		for name, value := range r.Env {
+			fmt.Fprintf(&env, `<rsp:Variable Name="%s">%s</rsp:Variable>`, html.EscapeString(name), html.EscapeString(value))
+		}
+		env.WriteString("</rsp:Environment>")
+	}
+	response, err := r.send(ctx, winrmActionCreate, "", `<rsp:Shell>`+env.String()+`<rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>`)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		ShellID string `xml:"Body>Shell>ShellId"`
+	}
+	if err := xml.Unmarshal(response, &created); err != nil || created.ShellID == "" {
+		return "", fmt.Errorf("unexpected WinRM create shell response: %v", err)
+	}
+	return created.ShellID, nil
+}
+
+// Start PowerShell with the script in the shell and return the command ID.
+// The script is passed with -EncodedCommand so no quoting is needed.
+func (r *WinRMRunner) startCommand(ctx context.Context, shellID, script string) (string, error) {
+	body := fmt.Sprintf(`<rsp:CommandLine><rsp:Command>powershell</rsp:Command><rsp:Arguments>-NoProfile -NonInteractive -EncodedCommand %s</rsp:Arguments></rsp:CommandLine>`,
+		encodePowerShellCommand(script))
+	response, err := r.send(ctx, winrmActionCommand, shellID, body)
+	if err != nil {
+		return "", err
+	}
+	var started struct {
+		CommandID string `xml:"Body>CommandResponse>CommandId"`
+	}
+	if err := xml.Unmarshal(response, &started); err != nil || started.CommandID == "" {
+		return "", fmt.Errorf("unexpected WinRM command response: %v", err)
+	}
+	return started.CommandID, nil
+}
+
+// Output of a WinRM Receive request
+type winrmReceiveResponse struct {
+	Streams []struct {
+		Name    string `xml:"Name,attr"`
+		Content string `xml:",chardata"`
+	} `xml:"Body>ReceiveResponse>Stream"`
+	State struct {
+		State    string `xml:"State,attr"`
+		ExitCode int    `xml:"ExitCode"`
+	} `xml:"Body>ReceiveResponse>CommandState"`
+}
+
+// Collect output until the command finishes, the timeout expires or ctx is
+// cancelled
+func (r *WinRMRunner) receiveOutput(ctx context.Context, shellID, commandID string) (string, string, int, error) {
+	var stdout, stderr bytes.Buffer
+	var deadline time.Time
+	if r.Timeout > 0 {
+		deadline = time.Now().Add(r.Timeout)
+	}
+
+	body := fmt.Sprintf(`<rsp:Receive><rsp:DesiredStream CommandId="%s">stdout stderr</rsp:DesiredStream></rsp:Receive>`, commandID)
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return stdout.String(), stderr.String(), 0, fmt.Errorf("%w after %v", ErrPowerShellTimeout, r.Timeout)
+		}
+
+		response, err := r.send(ctx, winrmActionReceive, shellID, body)
+		if err != nil {
+			// No output yet, keep waiting
+			if ctx.Err() == nil && strings.Contains(err.Error(), winrmTimeoutFaultCode) {
+				continue
+			}
+			return stdout.String(), stderr.String(), 0, err
+		}
+
+		var received winrmReceiveResponse
+		if err := xml.Unmarshal(response, &received); err != nil {
+			return stdout.String(), stderr.String(), 0, fmt.Errorf("unexpected WinRM receive response: %v", err)
+		}
+		for _, stream := range received.Streams {
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Content))
+			if err != nil {
+				continue
+			}
+			if stream.Name == "stderr" {
+				stderr.Write(data)
+			} else {
+				stdout.Write(data)
+			}
+		}
+		if received.State.State == winrmCommandDone {
+			return stdout.String(), stderr.String(), received.State.ExitCode, nil
+		}
+	}
+}
+
+// Send a WS-Management request and return the response envelope. Transport
+// and authentication failures wrap ErrVeeamUnreachable since no job status
+// can be read without them.
+func (r *WinRMRunner) send(ctx context.Context, action, shellID, body string) ([]byte, error) {
+	if r.client == nil {
+		r.client = &http.Client{
+			Timeout: 2 * time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: r.InsecureSkipVerify},
+			},
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", r.endpoint(), strings.NewReader(r.envelope(action, shellID, body)))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	request.SetBasicAuth(r.Username, r.Password)
+
+	resp, err := r.client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("WinRM request cancelled: %v", ctx.Err())
+		}
+		return nil, fmt.Errorf("%w: cannot connect to WinRM at %s: %v", ErrVeeamUnreachable, r.endpoint(), err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading WinRM response: %v", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w: WinRM authentication failed for %s, check the credentials and that Basic authentication is enabled on the WinRM service",
+			ErrVeeamUnreachable, r.Username)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("WinRM request failed with %s: %s", resp.Status, winrmFault(data))
+	}
+	return data, nil
+}
+
+// Build a SOAP envelope for a WinRM shell operation
+func (r *WinRMRunner) envelope(action, shellID, body string) string {
+	var selector, options string
+	if shellID != "" {
+		selector = fmt.Sprintf(`<w:SelectorSet><w:Selector Name="ShellId">%s</w:Selector></w:SelectorSet>`, html.EscapeString(shellID))
+	}
+	switch action {
+	case winrmActionCreate:
+		options = `<w:OptionSet><w:Option Name="WINRS_NOPROFILE">TRUE</w:Option><w:Option Name="WINRS_CODEPAGE">65001</w:Option></w:OptionSet>`
+	case winrmActionCommand:
+		options = `<w:OptionSet><w:Option Name="WINRS_CONSOLEMODE_STDIN">TRUE</w:Option></w:OptionSet>`
+	}
+
+	return fmt.Sprintf(`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">`+
+		`<env:Header>`+
+		`<a:To>%s</a:To>`+
+		`<a:ReplyTo><a:Address env:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>`+
+		`<w:MaxEnvelopeSize env:mustUnderstand="true">153600</w:MaxEnvelopeSize>`+
+		`<a:MessageID>uuid:%s</a:MessageID>`+
+		`<w:Locale xml:lang="en-US" env:mustUnderstand="false"/>`+
+		`<w:OperationTimeout>PT60S</w:OperationTimeout>`+
+		`<w:ResourceURI env:mustUnderstand="true">%s</w:ResourceURI>`+
+		`<a:Action env:mustUnderstand="true">%s</a:Action>`+
+		`%s%s`+
+		`</env:Header>`+
+		`<env:Body>%s</env:Body>`+
+		`</env:Envelope>`,
+		html.EscapeString(r.endpoint()), newUUID(), winrmShellURI, action, selector, options, body)
+}
+
+// Error text from a SOAP fault, falling back to the raw response
+func winrmFault(data []byte) string {
+	var fault struct {
+		Reason string `xml:"Body>Fault>Reason>Text"`
+		Detail struct {
+			Code string `xml:"Code,attr"`
+		} `xml:"Body>Fault>Detail>WSManFault"`
+	}
+	if err := xml.Unmarshal(data, &fault); err != nil || fault.Reason == "" {
+		return firstLine(string(data))
+	}
+	return fmt.Sprintf("%s (code %s)", strings.TrimSpace(fault.Reason), fault.Detail.Code)
+}
+
+// Encode a script for powershell -EncodedCommand, which takes base64 UTF-16LE
+func encodePowerShellCommand(script string) string {
+	units := utf16.Encode([]rune(script))
+	data := make([]byte, len(units)*2)
+	for i, unit := range units {
+		binary.LittleEndian.PutUint16(data[i*2:], unit)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// Error records in PowerShell's CLIXML stderr format
+var clixmlErrorPattern = regexp.MustCompile(`<S S="Error">(.*?)</S>`)
+
+// Remote PowerShell writes stderr as CLIXML, turn it back into plain text
+// so the usual error hints still match
+func decodeCLIXML(stderr string) string {
+	if !strings.HasPrefix(strings.TrimSpace(stderr), "#< CLIXML") {
+		return stderr
+	}
+	var text strings.Builder
+	for _, match := range clixmlErrorPattern.FindAllStringSubmatch(stderr, -1) {
+		line := strings.NewReplacer("_x000D_", "", "_x000A_", "\n").Replace(match[1])
+		text.WriteString(html.UnescapeString(line))
+	}
+	return text.String()
+}
+
+// Random version 4 UUID for WS-Addressing message IDs
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Build the CommandRunner for the configured transport
+func newCommandRunner(config *Config) CommandRunner {
+	if config.Transport == "winrm" {
+		return &WinRMRunner{
+			Host:               config.WinRMHost,
+			Port:               config.WinRMPort,
+			Username:           config.WinRMUsername,
+			Password:           config.WinRMPassword,
+			HTTPS:              config.WinRMHTTPS,
+			InsecureSkipVerify: config.WinRMInsecureSkipVerify,
+			Timeout:            config.commandTimeout(),
+			Env:                veeamScriptEnv(config),
+		}
+	}
+	return PowerShellRunner{Timeout: config.commandTimeout(), Env: veeamScriptEnv(config)}
+}
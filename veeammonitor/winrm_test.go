@@ -0,0 +1,140 @@
+package veeammonitor
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf16"
+)
+
+// Stand-in WinRM service running one command, recording the requests made
+type fakeWinRM struct {
+	stdout, stderr string
+	exitCode       int
+
+	mu       sync.Mutex
+	actions  []string
+	script   string
+	envelope string // Of the create shell request
+	receives int
+}
+
+var (
+	winrmActionPattern    = regexp.MustCompile(`<a:Action env:mustUnderstand="true">([^<]*)</a:Action>`)
+	winrmArgumentsPattern = regexp.MustCompile(`-EncodedCommand ([A-Za-z0-9+/=]+)`)
+)
+
+func (f *fakeWinRM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	data, _ := ioutil.ReadAll(r.Body)
+	envelope := string(data)
+	action := winrmActionPattern.FindStringSubmatch(envelope)[1]
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.actions = append(f.actions, action[strings.LastIndex(action, "/")+1:])
+	switch action {
+	case winrmActionCreate:
+		f.envelope = envelope
+		fmt.Fprint(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><rsp:Shell xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><rsp:ShellId>shell-1</rsp:ShellId></rsp:Shell></s:Body></s:Envelope>`)
+	case winrmActionCommand:
+		encoded, _ := base64.StdEncoding.DecodeString(winrmArgumentsPattern.FindStringSubmatch(envelope)[1])
+		units := make([]uint16, len(encoded)/2)
+		for i := range units {
+			units[i] = uint16(encoded[2*i]) | uint16(encoded[2*i+1])<<8
+		}
+		f.script = string(utf16.Decode(units))
+		fmt.Fprint(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><rsp:CommandResponse xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><rsp:CommandId>command-1</rsp:CommandId></rsp:CommandResponse></s:Body></s:Envelope>`)
+	case winrmActionReceive:
+		// The first receive finds no output within the operation timeout
+		f.receives++
+		if f.receives == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><s:Fault><s:Reason><s:Text>The WS-Management service cannot complete the operation within the time specified</s:Text></s:Reason><s:Detail><f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="%s"/></s:Detail></s:Fault></s:Body></s:Envelope>`, winrmTimeoutFaultCode)
+			return
+		}
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><rsp:ReceiveResponse xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">`+
+			`<rsp:Stream Name="stdout" CommandId="command-1">%s</rsp:Stream><rsp:Stream Name="stderr" CommandId="command-1">%s</rsp:Stream>`+
+			`<rsp:CommandState CommandId="command-1" State="%s"><rsp:ExitCode>%d</rsp:ExitCode></rsp:CommandState></rsp:ReceiveResponse></s:Body></s:Envelope>`,
+			base64.StdEncoding.EncodeToString([]byte(f.stdout)), base64.StdEncoding.EncodeToString([]byte(f.stderr)), winrmCommandDone, f.exitCode)
+	default:
+		fmt.Fprint(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body/></s:Envelope>`)
+	}
+}
+
+// WinRMRunner connected to a fake service
+func newWinRMTestRunner(t *testing.T, service *fakeWinRM, password string) *WinRMRunner {
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	portNumber, _ := strconv.Atoi(port)
+	return &WinRMRunner{Host: host, Port: portNumber, Username: "admin", Password: password, Env: map[string]string{"VEEAM_PASSWORD": "p&ss"}}
+}
+
+func TestWinRMRunner(t *testing.T) {
+	service := &fakeWinRM{
+		stdout:   "\"Name\"\r\n\"Nightly\"\r\n",
+		stderr:   `#< CLIXML` + "\r\n" + `<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><S S="Error">Get-VBRJob : Access is denied_x000D__x000A_</S></Objs>`,
+		exitCode: 1,
+	}
+	runner := newWinRMTestRunner(t, service, "secret")
+
+	stdout, stderr, err := runner.Run(`Get-VBRJob | Where-Object {$_.Name -eq 'Nächtlich'}`)
+	if stdout != service.stdout {
+		t.Errorf("got stdout %q, want %q", stdout, service.stdout)
+	}
+	if stderr != "Get-VBRJob : Access is denied\n" {
+		t.Errorf("got stderr %q, want the CLIXML decoded", stderr)
+	}
+	if err == nil || err.Error() != "exit status 1" {
+		t.Errorf("got error %v, want exit status 1", err)
+	}
+
+	if service.script != `Get-VBRJob | Where-Object {$_.Name -eq 'Nächtlich'}` {
+		t.Errorf("service ran %q", service.script)
+	}
+	if !strings.Contains(service.envelope, `<rsp:Variable Name="VEEAM_PASSWORD">p&amp;ss</rsp:Variable>`) {
+		t.Errorf("shell wasn't created with the environment: %s", service.envelope)
+	}
+	want := "Create Command Receive Receive Delete"
+	if got := strings.Join(service.actions, " "); got != want {
+		t.Errorf("got actions %s, want %s", got, want)
+	}
+}
+
+func TestWinRMAuthenticationFailure(t *testing.T) {
+	runner := newWinRMTestRunner(t, &fakeWinRM{}, "wrong")
+	_, _, err := runner.Run("Get-VBRJob")
+	if !errors.Is(err, ErrVeeamUnreachable) || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("got error %v, want an unreachable authentication failure", err)
+	}
+}
+
+func TestWinRMEndpoint(t *testing.T) {
+	tests := []struct {
+		runner WinRMRunner
+		want   string
+	}{
+		{WinRMRunner{Host: "veeam01"}, "http://veeam01:5985/wsman"},
+		{WinRMRunner{Host: "veeam01", HTTPS: true}, "https://veeam01:5986/wsman"},
+		{WinRMRunner{Host: "veeam01", Port: 8080}, "http://veeam01:8080/wsman"},
+		{WinRMRunner{Host: "fe80::1", HTTPS: true}, "https://[fe80::1]:5986/wsman"},
+	}
+	for _, test := range tests {
+		if got := test.runner.endpoint(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package veeammonitor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config files ending in .yaml or .yml are read as YAML, with the same keys
+// as JSON files: every Config field has matching json and yaml tags.
+
+// Whether a config file is YAML rather than JSON, by its extension
+func isYAMLFile(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Format of a config file for error messages
+func configFormat(filePath string) string {
+	if isYAMLFile(filePath) {
+		return "YAML"
+	}
+	return "JSON"
+}
+
+// Decode a YAML config document into config, returning the top-level keys
+// it sets. An empty document sets nothing.
+func decodeYAMLConfig(data []byte, config *Config) (map[string]bool, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var doc yaml.Node
+	if err := decoder.Decode(&doc); errors.Is(err, io.EOF) {
+		return map[string]bool{}, nil
+	} else if err != nil {
+		return nil, yamlError(err)
+	}
+	if err := decoder.Decode(&yaml.Node{}); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("multiple documents aren't supported")
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: the document must be a mapping of settings", root.Line)
+	}
+	if err := root.Decode(config); err != nil {
+		return nil, yamlError(err)
+	}
+	present := map[string]bool{}
+	for i := 0; i < len(root.Content); i += 2 {
+		present[root.Content[i].Value] = true
+	}
+	return present, nil
+}
+
+// First field, at any depth, of a YAML config that Config doesn't have
+func unknownYAMLField(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&Config{}); err != nil && !errors.Is(err, io.EOF) {
+		return yamlError(err)
+	}
+	return nil
+}
+
+// A yaml error on one line, without the package prefix. Decoding errors
+// list every field that failed, each with its line.
+func yamlError(err error) error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		return errors.New(strings.Join(typeErr.Errors, "; "))
+	}
+	return errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+}
+
+// Render a config as YAML in Config's field order, optionally with each
+// setting's documentation above it
+func renderYAMLConfig(config *Config, docs bool) ([]byte, error) {
+	var root yaml.Node
+	if err := root.Encode(config); err != nil {
+		return nil, err
+	}
+	// yaml writes nil lists and maps as empty ones, which would load back
+	// differently, so they are written as null like in JSON
+	unset := map[string]bool{}
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if field := value.Field(i); (field.Kind() == reflect.Slice || field.Kind() == reflect.Map) && field.IsNil() {
+			unset[value.Type().Field(i).Tag.Get("yaml")] = true
+		}
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		key := root.Content[i]
+		if unset[key.Value] {
+			root.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+		}
+		if docs {
+			key.HeadComment = configFieldDocs[key.Value]
+		}
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
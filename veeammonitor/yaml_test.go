@@ -0,0 +1,121 @@
+package veeammonitor
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLConfigMatchesJSON(t *testing.T) {
+	jsonPath := writeConfigFile(t, "config.json", `{
+		"configVersion": 2,
+		"veeamServerAddress": "veeam01.corp.example",
+		"smtpServer": "mail.example",
+		"smtpPort": 587,
+		"smtpStartTLS": true,
+		"emailFrom": "monitor@example.com",
+		"emailTo": ["ops@example.com", "noc@example.com"],
+		"checkIntervalMinutes": 30,
+		"monitorWarningJobs": false,
+		"monitorJobTypes": ["backup", "copy"],
+		"repositoryFreeSpaceThresholdPercent": 12,
+		"statusSeverityMap": {"Failed": "critical", "Warning": "warning"},
+		"webhooks": [
+			{"name": "ops", "url": "https://hooks.example/ops", "headers": {"Authorization": "Bearer token"}}
+		],
+		"reportTemplates": {"noc": "{{range .Failed}}FAIL {{.Name}}\n{{end}}"},
+		"warningIgnorePatterns": ["low disk: #\\d+", "it's fine"]
+	}`)
+	yamlPath := writeConfigFile(t, "config.yaml", `---
+# Same settings as the JSON file
+configVersion: 2
+veeamServerAddress: veeam01.corp.example
+smtpServer: "mail.example"
+smtpPort: 587
+smtpStartTLS: true
+emailFrom: monitor@example.com   # Comments can follow values
+emailTo:
+  - ops@example.com
+  - noc@example.com
+checkIntervalMinutes: 30
+monitorWarningJobs: false
+monitorJobTypes: [backup, copy]
+repositoryFreeSpaceThresholdPercent: 12
+statusSeverityMap: {Failed: critical, Warning: warning}
+webhooks:
+  - name: ops
+    url: https://hooks.example/ops
+    headers:
+      Authorization: Bearer token
+reportTemplates:
+  noc: |-
+    {{range .Failed}}FAIL {{.Name}}
+    {{end}}
+warningIgnorePatterns:
+  - 'low disk: #\d+'
+  - "it's fine"
+`)
+
+	fromJSON, err := LoadConfig(jsonPath)
+	if err != nil {
+		t.Fatalf("loading JSON: %v", err)
+	}
+	fromYAML, err := LoadConfig(yamlPath)
+	if err != nil {
+		t.Fatalf("loading YAML: %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Errorf("configs differ:\nJSON: %+v\nYAML: %+v", fromJSON, fromYAML)
+	}
+	if len(fromYAML.WarningIgnorePatterns) != 2 || fromYAML.WarningIgnorePatterns[0] != `low disk: #\d+` {
+		t.Errorf("got patterns %q, want the quoted strings kept whole", fromYAML.WarningIgnorePatterns)
+	}
+}
+
+func TestYAMLConfigAnchors(t *testing.T) {
+	config, err := LoadConfig(writeConfigFile(t, "config.yaml", `configVersion: 2
+emailTo: &oncall
+  - ops@example.com
+  - noc@example.com
+emailAudiences:
+  - name: managers
+    to: *oncall
+    subject: >-
+      Veeam {{.Severity}}
+      alert
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.EmailAudiences) != 1 || !equalStrings(config.EmailAudiences[0].To, config.EmailTo) || len(config.EmailTo) != 2 {
+		t.Errorf("got audiences %+v and emailTo %v, want the alias to repeat emailTo", config.EmailAudiences, config.EmailTo)
+	}
+	if subject := config.EmailAudiences[0].Subject; subject != "Veeam {{.Severity}} alert" {
+		t.Errorf("got folded subject %q", subject)
+	}
+}
+
+func TestYAMLConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"tab indentation", "emailTo:\n\t- ops@example.com\n", "line 2: found character that cannot start any token"},
+		{"several documents", "smtpPort: 25\n---\nsmtpPort: 26\n", "multiple documents aren't supported"},
+		{"not a mapping", "- ops@example.com\n", "line 1: the document must be a mapping of settings"},
+		{"wrong type", "smtpPort: twenty-five\n", "line 1: cannot unmarshal !!str `twenty-...` into int"},
+	}
+	for _, test := range tests {
+		_, err := LoadConfig(writeConfigFile(t, "config.yml", test.data))
+		if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "YAML config file") || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want an invalid YAML config naming %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestYAMLConfigUnknownNestedField(t *testing.T) {
+	_, err := LoadStrictConfig(writeConfigFile(t, "config.yaml", "configVersion: 2\nwebhooks:\n  - name: ops\n    url: https://hooks.example/ops\n    secrett: s3cret\n"))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "line 5: field secrett not found") {
+		t.Errorf("got error %v, want the misspelled webhook field and its line", err)
+	}
+}